@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/acl"
+	"github.com/MG-RAST/AWE/lib/clientGroupAcl"
+)
+
+// aclCommand acl show|add|remove job|cgroup <id> [<type> <users>]
+func aclCommand(args []string) (err error) {
+	if len(args) < 3 {
+		err = fmt.Errorf("usage: acl show|add|remove job|cgroup <id> [<type> <users>]")
+		return
+	}
+	command := args[0]
+	kind := args[1]
+	id := args[2]
+	if kind != "job" && kind != "cgroup" {
+		err = fmt.Errorf("acl: unknown resource \"%s\", expected job or cgroup", kind)
+		return
+	}
+	resource := kind + "/" + id + "/acl"
+
+	var response *apiResponse
+	switch command {
+	case "show":
+		response, err = apiRequest("GET", resource, nil, nil)
+	case "add", "remove":
+		if len(args) < 5 {
+			err = fmt.Errorf("usage: acl %s %s <id> <type> <users>", command, kind)
+			return
+		}
+		method := "PUT"
+		if command == "remove" {
+			method = "DELETE"
+		}
+		// users are uuids or user names, comma separated
+		response, err = apiRequest(method, resource+"/"+args[3], nil, map[string]string{"users": args[4]})
+	default:
+		err = fmt.Errorf("unknown acl command \"%s\"", command)
+	}
+	if err != nil {
+		return
+	}
+
+	printed, err := printJSON(response)
+	if err != nil || printed {
+		return
+	}
+	table := newTable()
+	if kind == "job" {
+		jobACL := acl.Acl{}
+		if err = response.Decode(&jobACL); err != nil {
+			return
+		}
+		row(table, "owner:", orDash(jobACL.Owner))
+		row(table, "read:", orDash(strings.Join(jobACL.Read, ", ")))
+		row(table, "write:", orDash(strings.Join(jobACL.Write, ", ")))
+		row(table, "delete:", orDash(strings.Join(jobACL.Delete, ", ")))
+	} else {
+		cgACL := clientGroupAcl.ClientGroupAcl{}
+		if err = response.Decode(&cgACL); err != nil {
+			return
+		}
+		row(table, "owner:", orDash(cgACL.Owner))
+		row(table, "read:", orDash(strings.Join(cgACL.Read, ", ")))
+		row(table, "write:", orDash(strings.Join(cgACL.Write, ", ")))
+		row(table, "delete:", orDash(strings.Join(cgACL.Delete, ", ")))
+		row(table, "execute:", orDash(strings.Join(cgACL.Execute, ", ")))
+	}
+	table.Flush()
+	return
+}
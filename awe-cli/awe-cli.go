@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+const usage = `usage: awe-cli [options] <command> [arguments]
+
+commands:
+  job list [active|suspend|registered] [<field>=<value> ...] [limit=n] [offset=n] [order=<field>] [direction=asc|desc]
+  job show <job id>
+  job suspend|resume|resubmit <job id>
+  job recompute <job id> <task id>
+  job delete <job id> [full]
+  work log <workunit id> [stdout|stderr|worknotes]
+  work tail <workunit id> [stdout|stderr|worknotes]
+  acl show job|cgroup <id>
+  acl add|remove job|cgroup <id> <type> <users>
+  cgroup list
+  cgroup create|delete <clientgroup>
+  client list [busy|group=<group>|status=<status>|app=<app>]
+  client show|suspend|resume <client id>
+  queue
+
+options (see --help) have to be given before the command, e.g. awe-cli --format=json job list active
+`
+
+func main() {
+	err := mainWrapper()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func mainWrapper() (err error) {
+
+	conf.LOG_OUTPUT = "console"
+
+	err = conf.Init_conf("cli")
+	if err != nil {
+		err = fmt.Errorf("error in configuration: %s", err.Error())
+		return
+	}
+
+	logger.Initialize("client")
+
+	if len(conf.ARGS) == 0 || conf.ARGS[0] == "help" {
+		fmt.Print(usage)
+		return
+	}
+
+	args := conf.ARGS[1:]
+	switch conf.ARGS[0] {
+	case "job":
+		err = jobCommand(args)
+	case "work":
+		err = workCommand(args)
+	case "acl":
+		err = aclCommand(args)
+	case "cgroup":
+		err = cgroupCommand(args)
+	case "client":
+		err = clientCommand(args)
+	case "queue":
+		err = queueCommand(args)
+	default:
+		err = fmt.Errorf("unknown command \"%s\", see awe-cli help", conf.ARGS[0])
+	}
+	return
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+)
+
+// testServer an AWE server that answers every request with data and records the requests
+type testServer struct {
+	*httptest.Server
+	requests []*http.Request
+	forms    []url.Values
+	response map[string]interface{}
+}
+
+func newTestServer(t *testing.T, data interface{}) (server *testServer, output *bytes.Buffer) {
+	server = &testServer{response: map[string]interface{}{"status": http.StatusOK, "data": data}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		server.requests = append(server.requests, r)
+		server.forms = append(server.forms, r.PostForm)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(server.response)
+	}))
+	t.Cleanup(server.Close)
+
+	conf.SERVER_URL = server.URL
+	conf.CLI_FORMAT = "table"
+	time.Local = time.UTC
+	output = &bytes.Buffer{}
+	stdout = output
+	return
+}
+
+func TestJobListQuery(t *testing.T) {
+	query, err := jobListQuery([]string{"active", "info.user=alice", "state=queued,in-progress", "limit=5", "order=info.submittime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{"active": {""}, "query": {""}, "info.user": {"alice"}, "state": {"queued,in-progress"}, "limit": {"5"}, "order": {"info.submittime"}}
+	if !reflect.DeepEqual(query, want) {
+		t.Errorf("got %v, want %v", query, want)
+	}
+	if _, err := jobListQuery([]string{"everything"}); err == nil {
+		t.Errorf("expected an error for an invalid filter")
+	}
+}
+
+func TestTailOffset(t *testing.T) {
+	log := "1\n2\n3\n4\n"
+	for n, want := range map[int]string{0: "", 2: "3\n4\n", 4: log, 10: log} {
+		if got := log[tailOffset(log, n):]; got != want {
+			t.Errorf("%d lines: got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestJobList(t *testing.T) {
+	server, output := newTestServer(t, []map[string]interface{}{
+		{"id": "j1", "state": "completed", "info": map[string]interface{}{"name": "first", "user": "alice", "priority": 1, "submittime": "2026-01-02T03:04:05Z"}},
+		{"id": "j2", "state": "queued"},
+	})
+	server.response["total_count"] = 3
+
+	err := jobCommand([]string{"list", "suspend", "limit=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := server.requests[0]
+	if request.Method != "GET" || request.URL.Path != "/job" || request.URL.Query().Encode() != "limit=2&suspend=" {
+		t.Errorf("unexpected request %s %s", request.Method, request.URL)
+	}
+	want := `ID  NAME   STATE      PIPELINE  USER   PRIORITY  SUBMITTED            COMPLETED
+j1  first  completed  -         alice  1         2026-01-02 03:04:05  -
+j2  -      queued     -         -      0         -                    -
+
+1-2 of 3 jobs, use offset=2 for the next page
+`
+	if output.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", output.String(), want)
+	}
+
+	// json prints the data as it is
+	output.Reset()
+	conf.CLI_FORMAT = "json"
+	err = jobCommand([]string{"list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output.String(), "[\n  {\n    \"id\": \"j1\",") {
+		t.Errorf("unexpected json output %s", output.String())
+	}
+}
+
+func TestClientList(t *testing.T) {
+	_, output := newTestServer(t, []map[string]interface{}{
+		{"id": "c1", "name": "worker1", "group": "default", "hostname": "node1", "Status": "active-busy", "current_work": map[string]interface{}{"data": []string{"w1"}},
+			"total_completed": 4, "total_failed": 1, "regtime": "2026-01-02T03:04:05Z"},
+		{"id": "c2", "name": "worker2", "group": "default", "Status": "suspend", "suspended": true, "suspend_reason": "too many errors"},
+	})
+
+	err := clientCommand([]string{"list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ID  NAME     GROUP    HOST   STATUS                     WORK  COMPLETED  FAILED  REGISTERED
+c1  worker1  default  node1  active-busy                1     4          1       2026-01-02 03:04:05
+c2  worker2  default  -      suspend (too many errors)  0     0          0       -
+`
+	if output.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", output.String(), want)
+	}
+}
+
+func TestJobActions(t *testing.T) {
+	server, output := newTestServer(t, "job suspended: j1")
+	tests := []struct {
+		args   []string
+		method string
+		path   string
+		query  string
+	}{
+		{[]string{"suspend", "j1"}, "PUT", "/job/j1", "suspend="},
+		{[]string{"recompute", "j1", "2"}, "PUT", "/job/j1", "recompute=2"},
+		{[]string{"delete", "j1", "full"}, "DELETE", "/job/j1", "full="},
+	}
+	for i, test := range tests {
+		if err := jobCommand(test.args); err != nil {
+			t.Fatal(err)
+		}
+		request := server.requests[i]
+		if request.Method != test.method || request.URL.Path != test.path || request.URL.RawQuery != test.query {
+			t.Errorf("%v: unexpected request %s %s", test.args, request.Method, request.URL)
+		}
+	}
+	if output.String() != strings.Repeat("job suspended: j1\n", len(tests)) {
+		t.Errorf("unexpected output %q", output.String())
+	}
+
+	for _, args := range [][]string{{}, {"show"}, {"recompute", "j1"}, {"stop", "j1"}} {
+		if err := jobCommand(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestWorkLog(t *testing.T) {
+	server, output := newTestServer(t, "line 1\nline 2\n")
+	id := "j1_#main/step_0"
+	err := workCommand([]string{"log", id, "stderr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := server.requests[0]
+	if request.URL.Path != "/work/base64:"+base64.URLEncoding.EncodeToString([]byte(id)) || request.URL.Query().Get("report") != "stderr" {
+		t.Errorf("unexpected request %s", request.URL)
+	}
+	if output.String() != "line 1\nline 2\n" {
+		t.Errorf("unexpected output %q", output.String())
+	}
+}
+
+func TestACL(t *testing.T) {
+	server, output := newTestServer(t, map[string]interface{}{"owner": "alice", "read": []string{"alice", "bob"}, "write": []string{"alice"}, "delete": []string{}})
+	err := aclCommand([]string{"add", "job", "j1", "read", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := server.requests[0]
+	if request.Method != "PUT" || request.URL.Path != "/job/j1/acl/read" || server.forms[0].Get("users") != "bob" {
+		t.Errorf("unexpected request %s %s %v", request.Method, request.URL, server.forms[0])
+	}
+	want := `owner:   alice
+read:    alice, bob
+write:   alice
+delete:  -
+`
+	if output.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", output.String(), want)
+	}
+
+	for _, args := range [][]string{{"show", "job"}, {"show", "node", "n1"}, {"add", "job", "j1", "read"}} {
+		if err := aclCommand(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	server, output := newTestServer(t, map[string]map[string]int{
+		"jobs":      {"total": 3, "queued": 1, "in-progress": 2},
+		"tasks":     {"total": 0},
+		"workunits": {"total": 0},
+		"clients":   {"total": 1, "idle": 1},
+	})
+	err := queueCommand(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.requests[0].URL.RawQuery != "json=" {
+		t.Errorf("unexpected request %s", server.requests[0].URL)
+	}
+	want := `           STATE        COUNT
+jobs       total        3
+           in-progress  2
+           queued       1
+tasks      total        0
+workunits  total        0
+clients    total        1
+           idle         1
+`
+	if output.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", output.String(), want)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/core"
+)
+
+// clientCommand client list|show|suspend|resume
+func clientCommand(args []string) (err error) {
+	if len(args) == 0 {
+		err = fmt.Errorf("missing client command")
+		return
+	}
+	command := args[0]
+	args = args[1:]
+
+	switch command {
+	case "list":
+		// one filter: busy, group=<name>, status=<status> or app=<app>
+		query := url.Values{}
+		if len(args) > 0 {
+			parts := strings.SplitN(args[0], "=", 2)
+			if len(parts) == 2 {
+				query.Set(parts[0], parts[1])
+			} else {
+				query.Set(parts[0], "")
+			}
+		}
+		return clientList(query)
+	case "show", "suspend", "resume":
+	default:
+		err = fmt.Errorf("unknown client command \"%s\"", command)
+		return
+	}
+	if len(args) == 0 {
+		err = fmt.Errorf("client %s: missing client id", command)
+		return
+	}
+	id := args[0]
+	if command == "show" {
+		return clientShow(id)
+	}
+	response, err := apiRequest("PUT", "client/"+id, url.Values{command: {""}}, nil)
+	if err != nil {
+		return
+	}
+	return printMessage(response)
+}
+
+// clientList the clients registered with the server
+func clientList(query url.Values) (err error) {
+	clients := []*core.Client{}
+	response, err := apiGet("client", query, &clients)
+	if err != nil {
+		return
+	}
+	printed, err := printJSON(response)
+	if err != nil || printed {
+		return
+	}
+
+	table := newTable("ID", "NAME", "GROUP", "HOST", "STATUS", "WORK", "COMPLETED", "FAILED", "REGISTERED")
+	for _, worker := range clients {
+		status := worker.Status
+		if worker.Suspended {
+			status += " (" + worker.SuspendReason + ")"
+		}
+		row(table, worker.ID, worker.WorkerRuntime.Name, worker.Group, orDash(worker.Hostname), status, len(currentWork(worker)), worker.TotalCompleted, worker.TotalFailed, formatTime(worker.RegTime))
+	}
+	table.Flush()
+	return
+}
+
+// clientShow the details of a client
+func clientShow(id string) (err error) {
+	worker := &core.Client{}
+	response, err := apiGet("client/"+id, nil, worker)
+	if err != nil {
+		return
+	}
+	printed, err := printJSON(response)
+	if err != nil || printed {
+		return
+	}
+
+	table := newTable()
+	row(table, "id:", worker.ID)
+	row(table, "name:", worker.WorkerRuntime.Name)
+	row(table, "group:", worker.Group)
+	row(table, "host:", fmt.Sprintf("%s (%s)", orDash(worker.Hostname), orDash(worker.HostIP)))
+	row(table, "version:", orDash(worker.Version))
+	row(table, "cores:", worker.CPUs)
+	row(table, "apps:", orDash(strings.Join(worker.Apps, ", ")))
+	row(table, "status:", worker.Status)
+	if worker.Suspended {
+		row(table, "suspended:", worker.SuspendReason)
+	}
+	row(table, "registered:", formatTime(worker.RegTime))
+	row(table, "last completed:", formatTime(worker.LastCompleted))
+	row(table, "checkouts:", fmt.Sprintf("%d (completed %d, failed %d)", worker.TotalCheckout, worker.TotalCompleted, worker.TotalFailed))
+	row(table, "current work:", orDash(strings.Join(currentWork(worker), ", ")))
+	table.Flush()
+	return
+}
+
+// currentWork the ids of the workunits a client works on
+func currentWork(worker *core.Client) []string {
+	if worker.CurrentWork == nil {
+		return nil
+	}
+	return worker.CurrentWork.Data
+}
+
+// cgroupCommand cgroup list|create|delete
+func cgroupCommand(args []string) (err error) {
+	if len(args) == 0 {
+		err = fmt.Errorf("missing cgroup command")
+		return
+	}
+	command := args[0]
+	if command == "list" {
+		return cgroupList()
+	}
+	if len(args) < 2 {
+		err = fmt.Errorf("cgroup %s: missing clientgroup", command)
+		return
+	}
+
+	var response *apiResponse
+	switch command {
+	case "create":
+		response, err = apiRequest("POST", "cgroup/"+args[1], nil, nil)
+		if err != nil {
+			return
+		}
+		printed, xerr := printJSON(response)
+		if xerr != nil || printed {
+			return xerr
+		}
+		// the token is only shown here, clients of the group need it
+		cg := core.ClientGroup{}
+		if err = response.Decode(&cg); err != nil {
+			return
+		}
+		table := newTable()
+		row(table, "id:", cg.ID)
+		row(table, "name:", cg.Name)
+		row(table, "token:", cg.Token)
+		row(table, "expiration:", formatTime(cg.Expiration))
+		table.Flush()
+		return
+	case "delete":
+		response, err = apiRequest("DELETE", "cgroup/"+args[1], nil, nil)
+	default:
+		err = fmt.Errorf("unknown cgroup command \"%s\"", command)
+	}
+	if err != nil {
+		return
+	}
+	return printMessage(response)
+}
+
+// cgroupList the clientgroups the user can read
+func cgroupList() (err error) {
+	cgs := core.ClientGroups{}
+	response, err := apiGet("cgroup", nil, &cgs)
+	if err != nil {
+		return
+	}
+	printed, err := printJSON(response)
+	if err != nil || printed {
+		return
+	}
+
+	table := newTable("ID", "NAME", "CREATED", "EXPIRATION")
+	for _, cg := range cgs {
+		row(table, cg.ID, cg.Name, formatTime(cg.CreatedOn), formatTime(cg.Expiration))
+	}
+	table.Flush()
+	return
+}
+
+// queueCommand the numbers of jobs, tasks, workunits and clients by state
+func queueCommand(args []string) (err error) {
+	status := map[string]map[string]int{}
+	response, err := apiGet("queue", url.Values{"json": {""}}, &status)
+	if err != nil {
+		return
+	}
+	printed, err := printJSON(response)
+	if err != nil || printed {
+		return
+	}
+
+	table := newTable("", "STATE", "COUNT")
+	for _, section := range []string{"jobs", "tasks", "workunits", "clients"} {
+		states := []string{}
+		for state := range status[section] {
+			if state != "total" {
+				states = append(states, state)
+			}
+		}
+		sort.Strings(states)
+		row(table, section, "total", status[section]["total"])
+		for _, state := range states {
+			row(table, "", state, status[section][state])
+		}
+	}
+	table.Flush()
+	return
+}
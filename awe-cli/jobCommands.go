@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+)
+
+// jobCommand job list|show|suspend|resume|recompute|resubmit|delete
+func jobCommand(args []string) (err error) {
+	if len(args) == 0 {
+		err = fmt.Errorf("missing job command")
+		return
+	}
+	command := args[0]
+	args = args[1:]
+	if command == "list" {
+		return jobList(args)
+	}
+	if len(args) == 0 {
+		err = fmt.Errorf("job %s: missing job id", command)
+		return
+	}
+	id := args[0]
+
+	var response *apiResponse
+	switch command {
+	case "show":
+		return jobShow(id)
+	case "suspend", "resume", "resubmit":
+		response, err = apiRequest("PUT", "job/"+id, url.Values{command: {""}}, nil)
+	case "recompute":
+		if len(args) < 2 {
+			err = fmt.Errorf("job recompute: missing task id")
+			return
+		}
+		response, err = apiRequest("PUT", "job/"+id, url.Values{"recompute": {args[1]}}, nil)
+	case "delete":
+		query := url.Values{}
+		if len(args) > 1 && args[1] == "full" {
+			query.Set("full", "")
+		}
+		response, err = apiRequest("DELETE", "job/"+id, query, nil)
+	default:
+		err = fmt.Errorf("unknown job command \"%s\"", command)
+	}
+	if err != nil {
+		return
+	}
+	return printMessage(response)
+}
+
+// jobList lists jobs. Arguments are filters (field=value, comma separated values match any of them), paging
+// options (limit, offset, order, direction) or one of active, suspend and registered.
+func jobList(args []string) (err error) {
+	query, err := jobListQuery(args)
+	if err != nil {
+		return
+	}
+
+	jobs := []*core.Job{}
+	response, err := apiGet("job", query, &jobs)
+	if err != nil {
+		return
+	}
+	printed, err := printJSON(response)
+	if err != nil || printed {
+		return
+	}
+
+	table := newTable("ID", "NAME", "STATE", "PIPELINE", "USER", "PRIORITY", "SUBMITTED", "COMPLETED")
+	for _, job := range jobs {
+		info := job.Info
+		if info == nil {
+			info = &core.Info{}
+		}
+		row(table, job.ID, orDash(info.Name), job.State, orDash(info.Pipeline), orDash(info.User), info.Priority, formatTime(info.SubmitTime), formatTime(info.CompletedTime))
+	}
+	table.Flush()
+	if response.TotalCount > len(jobs) {
+		fmt.Fprintf(stdout, "\n%d-%d of %d jobs, use offset=%d for the next page\n", response.Offset+1, response.Offset+len(jobs), response.TotalCount, response.Offset+len(jobs))
+	}
+	return
+}
+
+// jobListQuery the query of job list
+func jobListQuery(args []string) (query url.Values, err error) {
+	query = url.Values{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) == 1 {
+			switch arg {
+			case "active", "suspend", "registered":
+				query.Set(arg, "")
+			default:
+				err = fmt.Errorf("job list: invalid filter \"%s\", expected field=value, active, suspend or registered", arg)
+				return
+			}
+			continue
+		}
+		switch parts[0] {
+		case "limit", "offset", "order", "direction":
+			query.Set(parts[0], parts[1])
+		default:
+			query.Set("query", "")
+			query.Add(parts[0], parts[1])
+		}
+	}
+	return
+}
+
+// jobShow the details and tasks of a job
+func jobShow(id string) (err error) {
+	job := &core.Job{}
+	response, err := apiGet("job/"+id, nil, job)
+	if err != nil {
+		return
+	}
+	printed, err := printJSON(response)
+	if err != nil || printed {
+		return
+	}
+
+	info := job.Info
+	if info == nil {
+		info = &core.Info{}
+	}
+	table := newTable()
+	row(table, "id:", job.ID)
+	row(table, "name:", orDash(info.Name))
+	row(table, "state:", job.State)
+	row(table, "pipeline:", orDash(info.Pipeline))
+	row(table, "user:", orDash(info.User))
+	row(table, "clientgroups:", orDash(info.ClientGroups))
+	row(table, "priority:", info.Priority)
+	row(table, "submitted:", formatTime(info.SubmitTime))
+	row(table, "started:", formatTime(info.StartedTime))
+	row(table, "completed:", formatTime(info.CompletedTime))
+	if !info.Deadline.IsZero() {
+		row(table, "deadline:", formatTime(info.Deadline))
+		if status := job.DeadlineStatus; status != nil {
+			row(table, "projected:", fmt.Sprintf("%s (at risk: %t, missed: %t)", formatTime(status.Projected), status.AtRisk, status.Missed))
+		}
+	}
+	if job.Error != nil {
+		row(table, "error:", fmt.Sprintf("%s %s %s", job.Error.WorkFailed, job.Error.ServerNotes, job.Error.WorkNotes))
+	}
+	table.Flush()
+
+	if len(job.Tasks) == 0 {
+		return
+	}
+	fmt.Fprintln(stdout)
+	table = newTable("TASK", "STATE", "REMAINING", "STARTED", "COMPLETED")
+	for _, task := range job.Tasks {
+		row(table, task.ID, task.State, fmt.Sprintf("%d/%d", task.RemainWork, task.TotalWork), formatTime(task.StartedDate), formatTime(task.CompletedDate))
+	}
+	table.Flush()
+	return
+}
+
+// workCommand work log|tail
+func workCommand(args []string) (err error) {
+	if len(args) < 2 {
+		err = fmt.Errorf("usage: work log|tail <workunit id> [stdout|stderr|worknotes]")
+		return
+	}
+	command := args[0]
+	id := args[1]
+	report := "stdout"
+	if len(args) > 2 {
+		report = args[2]
+	}
+	switch command {
+	case "log":
+		var log string
+		log, err = workLog(id, report)
+		if err != nil {
+			return
+		}
+		fmt.Fprint(stdout, log)
+	case "tail":
+		err = workTail(id, report)
+	default:
+		err = fmt.Errorf("unknown work command \"%s\"", command)
+	}
+	return
+}
+
+// workLog a log of a workunit, the worker uploads the logs when the workunit is done
+func workLog(id string, report string) (log string, err error) {
+	_, err = apiGet("work/"+workPath(id), url.Values{"report": {report}}, &log)
+	return
+}
+
+// workPath the workunit id for the URL path, CWL workunit ids contain "/" and "#"
+func workPath(id string) string {
+	return "base64:" + base64.URLEncoding.EncodeToString([]byte(id))
+}
+
+// tailLines the number of lines work tail starts with
+const tailLines = 10
+
+// tailOffset the offset of the last n lines of a log
+func tailOffset(log string, n int) int {
+	rest := strings.TrimSuffix(log, "\n")
+	for i := 0; i < n; i++ {
+		index := strings.LastIndex(rest, "\n")
+		if index < 0 {
+			return 0
+		}
+		rest = rest[:index]
+	}
+	return len(rest) + 1
+}
+
+// workTail prints the last lines of a log and then the content added to it, until interrupted. It waits for
+// logs that have not been uploaded yet.
+func workTail(id string, report string) (err error) {
+	printed := 0
+	first := true
+	for {
+		log, xerr := workLog(id, report)
+		if xerr != nil && !strings.Contains(xerr.Error(), "not found") {
+			return xerr
+		}
+		if len(log) < printed { // replaced by the log of another attempt
+			printed = 0
+		}
+		if first && len(log) > 0 {
+			printed = tailOffset(log, tailLines)
+			first = false
+		}
+		if len(log) > printed {
+			io.WriteString(stdout, log[printed:])
+			printed = len(log)
+		}
+		time.Sleep(time.Duration(conf.CLI_POLL_SECONDS) * time.Second)
+	}
+}
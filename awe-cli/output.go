@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+)
+
+// stdout the output of the commands
+var stdout io.Writer = os.Stdout
+
+// newTable a writer for aligned columns on stdout, call Flush when done
+func newTable(header ...interface{}) (table *tabwriter.Writer) {
+	table = tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	if len(header) > 0 {
+		row(table, header...)
+	}
+	return
+}
+
+// row writes one tab separated row
+func row(table *tabwriter.Writer, columns ...interface{}) {
+	for i, column := range columns {
+		if i > 0 {
+			fmt.Fprint(table, "\t")
+		}
+		fmt.Fprint(table, column)
+	}
+	fmt.Fprint(table, "\n")
+}
+
+// formatTime local time, "-" if not set
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// orDash "-" for empty strings
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// printJSON writes the data of the response, returns false if the table format is used
+func printJSON(response *apiResponse) (printed bool, err error) {
+	if conf.CLI_FORMAT != "json" {
+		return
+	}
+	data, err := indented(response)
+	if err != nil {
+		return
+	}
+	_, err = stdout.Write(data)
+	printed = true
+	return
+}
+
+// printMessage writes the message the server returns for actions like suspend or delete
+func printMessage(response *apiResponse) (err error) {
+	printed, err := printJSON(response)
+	if err != nil || printed {
+		return
+	}
+	var message string
+	if response.Decode(&message) != nil {
+		message = string(response.Data)
+	}
+	if message != "" && message != "null" {
+		fmt.Fprintln(stdout, message)
+	}
+	return
+}
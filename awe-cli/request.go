@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/MG-RAST/AWE/lib/client"
+	"github.com/MG-RAST/AWE/lib/conf"
+)
+
+// apiResponse the standard response of the AWE server, paginated responses have limit, offset and total_count
+type apiResponse = client.Response
+
+// apiRequest sends a request to the AWE server, form fields are sent as multipart form
+func apiRequest(method string, resource string, query url.Values, form map[string]string) (response *apiResponse, err error) {
+	var body interface{}
+	if len(form) > 0 {
+		multipart := client.NewForm()
+		for key, value := range form {
+			err = multipart.AddField(key, value)
+			if err != nil {
+				return
+			}
+		}
+		body = multipart
+	}
+	return client.New(conf.SERVER_URL, conf.SUBMITTER_AWE_AUTH).Do(context.Background(), method, resource, query, body)
+}
+
+// apiGet gets a resource and decodes its data into result
+func apiGet(resource string, query url.Values, result interface{}) (response *apiResponse, err error) {
+	return client.New(conf.SERVER_URL, conf.SUBMITTER_AWE_AUTH).Get(context.Background(), resource, query, result)
+}
+
+// indented the data of the response as indented JSON
+func indented(response *apiResponse) (data []byte, err error) {
+	var buffer bytes.Buffer
+	err = json.Indent(&buffer, response.Data, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("(indented) json.Indent returned: %s", err.Error())
+		return
+	}
+	buffer.WriteByte('\n')
+	data = buffer.Bytes()
+	return
+}
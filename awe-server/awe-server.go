@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
 	"runtime"
@@ -13,6 +15,7 @@ import (
 	"time"
 
 	"github.com/MG-RAST/AWE/lib/auth"
+	"github.com/MG-RAST/AWE/lib/autoscaler"
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/controller"
 	"github.com/MG-RAST/AWE/lib/core"
@@ -127,12 +130,14 @@ func launchSite(control chan int, port int) {
 func launchAPI(control chan int, port int) {
 	c := controller.NewServerController()
 	//goweb.ConfigureDefaultFormatters()
-	r := &goweb.RouteManager{}
+	r := goweb.DefaultRouteManager // served by goweb.DefaultHttpHandler, wrapped in controller.RateLimitHandler
 	r.Map("/job/{jid}/acl/{type}", c.JobAcl["typed"])
 	r.Map("/job/{jid}/acl", c.JobAcl["base"])
 	r.Map("/cgroup/{cgid}/acl/{type}", c.ClientGroupAcl["typed"])
 	r.Map("/cgroup/{cgid}/acl", c.ClientGroupAcl["base"])
 	r.Map("/cgroup/{cgid}/token", c.ClientGroupToken)
+	r.Map("/cgroup/{cgid}/cert/{serial}", c.ClientGroupCert["typed"])
+	r.Map("/cgroup/{cgid}/cert", c.ClientGroupCert["base"])
 	r.MapRest("/job", c.Job)
 	r.MapRest("/workflow_instances", c.WorkflowInstances)
 	r.MapRest("/work", c.Work)
@@ -140,17 +145,30 @@ func launchAPI(control chan int, port int) {
 	r.MapRest("/client", c.Client)
 	r.MapRest("/queue", c.Queue)
 	r.MapRest("/logger", c.Logger)
+	r.MapRest("/retention", c.Retention)
 	r.MapRest("/awf", c.Awf)
 	r.MapFunc("*", controller.ResourceDescription, goweb.GetMethod)
 
-	if conf.SSL_ENABLED {
-		err := goweb.ListenAndServeRoutesTLS(fmt.Sprintf(":%d", conf.API_PORT), conf.SSL_CERT_FILE, conf.SSL_KEY_FILE, r)
+	controller.InitRateLimits()
+	handler := controller.RateLimitHandler(goweb.DefaultHttpHandler)
+	if conf.HA_ENABLED {
+		handler = controller.StandbyHandler(handler)
+	}
+
+	if conf.SSL_ENABLED && conf.SSL_CLIENT_CA_FILE != "" {
+		err := listenMutualTLS(fmt.Sprintf(":%d", conf.API_PORT), handler)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: api: %v\n", err)
+			logger.Error("ERROR: api: " + err.Error())
+		}
+	} else if conf.SSL_ENABLED {
+		err := http.ListenAndServeTLS(fmt.Sprintf(":%d", conf.API_PORT), conf.SSL_CERT_FILE, conf.SSL_KEY_FILE, handler)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: api: %v\n", err)
 			logger.Error("ERROR: api: " + err.Error())
 		}
 	} else {
-		err := goweb.ListenAndServeRoutes(fmt.Sprintf(":%d", conf.API_PORT), r)
+		err := http.ListenAndServe(fmt.Sprintf(":%d", conf.API_PORT), handler)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: api: %v\n", err)
 			logger.Error("ERROR: api: " + err.Error())
@@ -159,6 +177,83 @@ func launchAPI(control chan int, port int) {
 	control <- 1 //we are ending
 }
 
+// listenMutualTLS serves the API with optional client certificates. Workers presenting a certificate
+// signed by client_ca are authenticated by it, everybody else uses the regular Authorization header.
+func listenMutualTLS(addr string, handler http.Handler) (err error) {
+	pool, err := core.ClientCAPool()
+	if err != nil {
+		return
+	}
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		},
+	}
+	err = server.ListenAndServeTLS(conf.SSL_CERT_FILE, conf.SSL_KEY_FILE)
+	return
+}
+
+// startScheduling starts the goroutines of the queue manager and recovers unfinished jobs
+func startScheduling(recoverJobs bool) {
+	go core.Ttl.Handle() // deletes expired jobs
+	if conf.ARCHIVE_AFTER != "" {
+		go core.Archiver.Handle() // moves old completed jobs into the archive
+	}
+	go core.QMgr.ClientHandle()
+	go core.QMgr.NoticeHandle()
+	go core.QMgr.ClientChecker()
+	go core.QMgr.UpdateQueueLoop()
+	go core.QMgr.DeadlineLoop() // projected completion of jobs with a deadline
+	if conf.PREEMPTION {
+		go core.QMgr.PreemptLoop() // stops lower priority work for waiting high priority workunits
+	}
+
+	if conf.AUTOSCALE_PROVIDER != "" {
+		if err := autoscaler.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: could not start autoscaler: %s\n", err.Error())
+			logger.Error("ERROR: could not start autoscaler: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	var host string
+	if hostname, err := os.Hostname(); err == nil {
+		host = fmt.Sprintf("%s:%d", hostname, conf.API_PORT)
+	}
+
+	//recover unfinished jobs before server went down last time
+	if recoverJobs {
+		if conf.RECOVER_MAX > 0 {
+			logger.Info("####### Recovering %d unfinished jobs #######", conf.RECOVER_MAX)
+		} else {
+			logger.Info("####### Recovering all unfinished jobs #######")
+		}
+
+		recovered, total, err := core.QMgr.RecoverJobs()
+		if err != nil {
+			logger.Error("RecoverJobs error: %v\n", err)
+		}
+		fmt.Printf("%d total jobs from mongo\n", total)
+		fmt.Printf("%d unfinished jobs recovered\n", recovered)
+
+		logger.Info("Recovering done")
+		logger.Event(event.SERVER_RECOVER, "host="+host)
+	} else {
+		logger.Event(event.SERVER_START, "host="+host)
+	}
+}
+
+// promote this server got the HA leader lease: the workunits checked out by the previous leader are
+// kept for their workers, then the unfinished jobs are recovered
+func promote() {
+	reserved := core.QMgr.ReserveCheckedOutWork()
+	logger.Info("promoted to leader, %d checked out workunits reserved for their workers", reserved)
+	startScheduling(true)
+}
+
 func main() {
 
 	if err := conf.Init_conf("server"); err != nil {
@@ -234,11 +329,18 @@ func main() {
 	logger.Info("InitAwfMgr...")
 	core.InitAwfMgr()
 
-	logger.Info("InitJobDB...")
-	core.InitJobDB()
+	logger.Info("InitRepositories...")
+	if err := core.InitRepositories(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR initializing the job database: %s\n", err.Error())
+		os.Exit(1)
+	}
 
-	logger.Info("InitClientGroupDB...")
-	core.InitClientGroupDB()
+	logger.Info("InitRetention...")
+	if err := core.InitRetention(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+		logger.Error("ERROR: " + err.Error())
+		os.Exit(1)
+	}
 
 	logger.Info("init auth...")
 	//init auth
@@ -247,15 +349,17 @@ func main() {
 	controller.PrintLogo()
 	conf.Print("server")
 
+	if conf.HA_ENABLED {
+		if err := core.InitClusterUUID(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	logger.Info("launching server...")
 
 	//launch server
 	control := make(chan int)
-	go core.Ttl.Handle() // deletes expired jobs
-	go core.QMgr.ClientHandle()
-	go core.QMgr.NoticeHandle()
-	go core.QMgr.ClientChecker()
-	go core.QMgr.UpdateQueueLoop()
 
 	goweb.ConfigureDefaultFormatters()
 	//go launchSite(control, conf.SITE_PORT) // deprecated
@@ -276,30 +380,12 @@ func main() {
 	//	logger.Error("LoadWorkflows: " + err.Error())
 	//}
 
-	var host string
-	if hostname, err := os.Hostname(); err == nil {
-		host = fmt.Sprintf("%s:%d", hostname, conf.API_PORT)
-	}
-
-	//recover unfinished jobs before server went down last time
-	if conf.RECOVER {
-		if conf.RECOVER_MAX > 0 {
-			logger.Info("####### Recovering %d unfinished jobs #######", conf.RECOVER_MAX)
-		} else {
-			logger.Info("####### Recovering all unfinished jobs #######")
-		}
-
-		recovered, total, err := core.QMgr.RecoverJobs()
-		if err != nil {
-			logger.Error("RecoverJobs error: %v\n", err)
-		}
-		fmt.Printf("%d total jobs from mongo\n", total)
-		fmt.Printf("%d unfinished jobs recovered\n", recovered)
-
-		logger.Info("Recovering done")
-		logger.Event(event.SERVER_RECOVER, "host="+host)
+	if conf.HA_ENABLED {
+		// standby until this server gets the leader lease
+		logger.Info("HA enabled, waiting for the leader lease...")
+		go core.QMgr.RunLeaderElection(promote)
 	} else {
-		logger.Event(event.SERVER_START, "host="+host)
+		startScheduling(conf.RECOVER)
 	}
 
 	if conf.PID_FILE_PATH != "" {
@@ -346,5 +432,9 @@ func main() {
 		runtime.GOMAXPROCS(avail)
 	}
 
+	if conf.STANDALONE {
+		go runStandalone()
+	}
+
 	<-control //block till something dies
 }
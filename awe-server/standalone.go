@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/autoscaler"
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/localstore"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/submitter"
+)
+
+// standaloneGroup client group of the standalone worker and job
+const standaloneGroup = "default"
+
+// runStandalone submits the workflow of the command line to this server like awe-submitter does,
+// waits for the results and exits. The worker runs as a child process: server and worker keep their
+// state in package globals (conf, core) and cannot share a process.
+func runStandalone() {
+	err := standalone()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+		logger.Error("(runStandalone) %s", err.Error())
+		time.Sleep(time.Second) // the logger writes asynchronously
+		os.Exit(1)
+	}
+	time.Sleep(time.Second)
+	os.Exit(0)
+}
+
+func standalone() (err error) {
+	storeURL, err := localstore.URL(conf.STANDALONE_DATA_STORE)
+	if err != nil {
+		return fmt.Errorf("(standalone) data store %s: %s", conf.STANDALONE_DATA_STORE, err.Error())
+	}
+	err = os.MkdirAll(conf.STANDALONE_DATA_STORE, 0777)
+	if err != nil {
+		return fmt.Errorf("(standalone) os.MkdirAll returned: %s", err.Error())
+	}
+	serverURL := fmt.Sprintf("http://localhost:%d", conf.API_PORT)
+	err = waitForAPI(serverURL, 30*time.Second)
+	if err != nil {
+		return
+	}
+
+	if conf.STANDALONE_WORKER {
+		workerDir := filepath.Join(conf.DATA_PATH, "worker")
+		args := fmt.Sprintf("--serverurl=%s --data=%s --workpath=%s --logs=%s --logoutput=file --supported_apps=* %s",
+			serverURL, filepath.Join(workerDir, "data"), filepath.Join(workerDir, "work"), filepath.Join(conf.LOGS_PATH, "worker"), conf.STANDALONE_WORKER_ARGS)
+		provider := autoscaler.NewLocalProvider(workerCommand(conf.STANDALONE_WORKER_COMMAND), args)
+		err = provider.Start(standaloneGroup, 1)
+		if err != nil {
+			return fmt.Errorf("(standalone) %s", err.Error())
+		}
+		defer provider.Stop()
+		done := make(chan struct{})
+		defer close(done)
+		go watchWorker(provider, done)
+	}
+
+	// the settings of awe-submitter
+	conf.SERVER_URL = serverURL
+	conf.SHOCK_URL = storeURL
+	conf.CLIENT_GROUP = standaloneGroup
+	conf.SUBMITTER_WAIT = true
+	conf.SUBMITTER_DOWNLOAD_FILES = true
+
+	jobFile := ""
+	if len(conf.ARGS) > 1 {
+		jobFile = conf.ARGS[1]
+	}
+	err = submitter.Run(conf.ARGS[0], jobFile, "", "")
+	if err != nil {
+		return fmt.Errorf("(standalone) submitter.Run returned: %s", err.Error())
+	}
+	return
+}
+
+// watchWorker exits if the worker process stopped before done, the workflow would never finish
+func watchWorker(provider *autoscaler.LocalProvider, done chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if count, _ := provider.Workers(standaloneGroup); count == 0 {
+			fmt.Fprintf(os.Stderr, "ERROR: the worker exited, see the logs in %s\n", filepath.Join(conf.LOGS_PATH, "worker"))
+			logger.Error("(watchWorker) the worker exited")
+			time.Sleep(time.Second)
+			os.Exit(1)
+		}
+	}
+}
+
+// waitForAPI waits until the API answers
+func waitForAPI(serverURL string, timeout time.Duration) (err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var response *http.Response
+		response, err = http.Get(serverURL)
+		if err == nil {
+			response.Body.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("(waitForAPI) the API at %s did not start: %s", serverURL, err.Error())
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// workerCommand prefers a worker binary next to the server binary over one in the PATH
+func workerCommand(command string) string {
+	if strings.ContainsRune(command, os.PathSeparator) {
+		return command
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return command
+	}
+	local := filepath.Join(filepath.Dir(executable), command)
+	if _, err = os.Stat(local); err == nil {
+		return local
+	}
+	return command
+}
@@ -2,6 +2,7 @@
 package auth
 
 import (
+	"crypto/tls"
 	"errors"
 
 	"github.com/MG-RAST/AWE/lib/auth/clientgroup"
@@ -64,3 +65,11 @@ func AuthenticateClientGroup(header string) (cg *core.ClientGroup, err error) {
 	}
 	return cg, nil
 }
+
+// AuthenticateClientGroupCertificate _
+func AuthenticateClientGroupCertificate(state *tls.ConnectionState) (cg *core.ClientGroup, err error) {
+	if cg, err = clientgroup.AuthCertificate(state); err != nil {
+		return nil, err
+	}
+	return cg, nil
+}
@@ -0,0 +1,32 @@
+package clientgroup
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/MG-RAST/AWE/lib/core"
+	e "github.com/MG-RAST/AWE/lib/errors"
+)
+
+// AuthCertificate takes the TLS state of a request with a verified worker certificate and returns the clientgroup
+func AuthCertificate(state *tls.ConnectionState) (cg *core.ClientGroup, err error) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, errors.New(e.NoAuth)
+	}
+	cert := state.VerifiedChains[0][0]
+	name := core.ClientGroupNameFromCertificate(cert)
+	if name == "" {
+		return nil, errors.New(e.InvalidAuth)
+	}
+	cg, err = core.LoadClientGroupByName(name)
+	if err != nil {
+		if err.Error() == "not found" {
+			return nil, errors.New(e.UnAuth)
+		}
+		return
+	}
+	if cg.IsCertificateRevoked(core.CertificateSerial(cert)) {
+		return nil, errors.New(e.ClientCertRevoked)
+	}
+	return
+}
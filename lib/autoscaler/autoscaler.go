@@ -0,0 +1,166 @@
+// Package autoscaler starts and drains workers of client groups according to the queued workunits
+package autoscaler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// Provider starts and stops workers of a client group. Clients are told to drain by the autoscaler
+// (heartbeat instruction "drain"), they finish their current work and exit.
+type Provider interface {
+	Name() string
+	// Workers returns the number of started workers of the group that are not draining
+	Workers(group string) (count int, err error)
+	// Start starts count more workers
+	Start(group string, count int) (err error)
+	// Drain is called after the clients have been told to drain
+	Drain(group string, clients []*core.Client) (err error)
+}
+
+// Autoscaler _
+type Autoscaler struct {
+	Provider  Provider
+	lastUp    map[string]time.Time // last scale up of a group
+	lastScale map[string]time.Time // last scale up or down of a group
+}
+
+// NewAutoscaler _
+func NewAutoscaler(provider Provider) *Autoscaler {
+	return &Autoscaler{
+		Provider:  provider,
+		lastUp:    make(map[string]time.Time),
+		lastScale: make(map[string]time.Time),
+	}
+}
+
+// NewProvider creates the provider configured in autoscale provider
+func NewProvider() (provider Provider, err error) {
+	switch conf.AUTOSCALE_PROVIDER {
+	case "local":
+		provider = NewLocalProvider(conf.AUTOSCALE_LOCAL_COMMAND, conf.AUTOSCALE_LOCAL_ARGS)
+	case "kubernetes":
+		provider, err = NewKubernetesProvider(conf.AUTOSCALE_KUBE_API_URL, conf.AUTOSCALE_KUBE_NAMESPACE, conf.AUTOSCALE_KUBE_DEPLOYMENT)
+		if err != nil {
+			err = fmt.Errorf("(NewProvider) NewKubernetesProvider returned: %s", err.Error())
+		}
+	default:
+		err = fmt.Errorf("(NewProvider) unknown autoscale provider \"%s\"", conf.AUTOSCALE_PROVIDER)
+	}
+	return
+}
+
+// Start creates the configured provider and runs the autoscaler in the background
+func Start() (err error) {
+	provider, err := NewProvider()
+	if err != nil {
+		return
+	}
+	logger.Info("(autoscaler) starting with provider %s for groups %v", provider.Name(), conf.AUTOSCALE_GROUP_NAMES)
+	go NewAutoscaler(provider).Run(time.Duration(conf.AUTOSCALE_INTERVAL_SECONDS) * time.Second)
+	return
+}
+
+// Run scales the groups every interval, it does not return
+func (a *Autoscaler) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		err := a.Scale()
+		if err != nil {
+			logger.Error("(autoscaler) %s", err.Error())
+		}
+	}
+}
+
+// Scale compares the demand of each group with its workers and starts or drains workers
+func (a *Autoscaler) Scale() (err error) {
+	demand, err := core.QMgr.GetQueueDemand(conf.AUTOSCALE_GROUP_NAMES, conf.AUTOSCALE_WORKER_CORES, conf.AUTOSCALE_WORKER_RAM_MB)
+	if err != nil {
+		err = fmt.Errorf("(Scale) GetQueueDemand returned: %s", err.Error())
+		return
+	}
+	for _, group := range conf.AUTOSCALE_GROUP_NAMES {
+		xerr := a.scaleGroup(demand[group], conf.AUTOSCALE_GROUP_BOUNDS[group], time.Now())
+		if xerr != nil {
+			logger.Error("(autoscaler) group %s: %s", group, xerr.Error())
+		}
+	}
+	return
+}
+
+// DesiredWorkers one worker per busy client and per queued workunit, within bounds (min, max)
+func DesiredWorkers(demand *core.QueueDemand, bounds [2]int) (desired int) {
+	desired = demand.Busy + demand.Queued
+	if desired < bounds[0] {
+		desired = bounds[0]
+	}
+	if desired > bounds[1] {
+		desired = bounds[1]
+	}
+	return
+}
+
+func (a *Autoscaler) scaleGroup(demand *core.QueueDemand, bounds [2]int, now time.Time) (err error) {
+	group := demand.Group
+	current, err := a.Provider.Workers(group)
+	if err != nil {
+		err = fmt.Errorf("(scaleGroup) %s Workers returned: %s", a.Provider.Name(), err.Error())
+		return
+	}
+	desired := DesiredWorkers(demand, bounds)
+	if demand.TooLarge > 0 {
+		logger.Warning("(autoscaler) group %s: %d queued workunits need more than %d cores / %d MiB", group, demand.TooLarge, conf.AUTOSCALE_WORKER_CORES, conf.AUTOSCALE_WORKER_RAM_MB)
+	}
+	logger.Debug(1, "(autoscaler) group %s: queued=%d busy=%d idle=%d draining=%d workers=%d desired=%d", group, demand.Queued, demand.Busy, len(demand.Idle), demand.Draining, current, desired)
+
+	switch {
+	case desired > current:
+		if now.Sub(a.lastUp[group]) < time.Duration(conf.AUTOSCALE_UP_COOLDOWN_SECONDS)*time.Second {
+			return
+		}
+		logger.Info("(autoscaler) group %s: starting %d workers (%d -> %d)", group, desired-current, current, desired)
+		err = a.Provider.Start(group, desired-current)
+		if err != nil {
+			err = fmt.Errorf("(scaleGroup) %s Start returned: %s", a.Provider.Name(), err.Error())
+			return
+		}
+		a.lastUp[group] = now
+		a.lastScale[group] = now
+	case desired < current:
+		if now.Sub(a.lastScale[group]) < time.Duration(conf.AUTOSCALE_DOWN_COOLDOWN_SECONDS)*time.Second {
+			return
+		}
+		// only idle clients are drained, busy ones finish their work first
+		clients := []*core.Client{}
+		for _, id := range demand.Idle {
+			if len(clients) == current-desired {
+				break
+			}
+			client, ok, xerr := core.QMgr.GetClient(id, true)
+			if xerr != nil || !ok {
+				continue
+			}
+			xerr = core.QMgr.DrainClient(id, false, 0)
+			if xerr != nil {
+				logger.Error("(autoscaler) DrainClient %s returned: %s", id, xerr.Error())
+				continue
+			}
+			clients = append(clients, client)
+		}
+		if len(clients) == 0 {
+			return
+		}
+		logger.Info("(autoscaler) group %s: draining %d workers (%d -> %d)", group, len(clients), current, current-len(clients))
+		err = a.Provider.Drain(group, clients)
+		if err != nil {
+			err = fmt.Errorf("(scaleGroup) %s Drain returned: %s", a.Provider.Name(), err.Error())
+			return
+		}
+		a.lastScale[group] = now
+	}
+	return
+}
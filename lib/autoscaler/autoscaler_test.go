@@ -0,0 +1,170 @@
+package autoscaler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+func TestDesiredWorkers(t *testing.T) {
+	tests := []struct {
+		busy    int
+		queued  int
+		bounds  [2]int
+		desired int
+	}{
+		{0, 0, [2]int{0, 10}, 0},
+		{0, 0, [2]int{2, 10}, 2},
+		{3, 4, [2]int{0, 10}, 7},
+		{3, 4, [2]int{0, 5}, 5},
+		{1, 0, [2]int{0, 10}, 1},
+	}
+	for _, test := range tests {
+		desired := DesiredWorkers(&core.QueueDemand{Busy: test.busy, Queued: test.queued}, test.bounds)
+		if desired != test.desired {
+			t.Errorf("busy=%d queued=%d bounds=%v: got %d, want %d", test.busy, test.queued, test.bounds, desired, test.desired)
+		}
+	}
+}
+
+// setupLocalProvider a LocalProvider whose workers are sleeping shell scripts
+func setupLocalProvider(t *testing.T) (provider *LocalProvider, cleanup func()) {
+	if logger.Log == nil {
+		logger.Initialize("server")
+	}
+	dir, err := ioutil.TempDir("", "awe-autoscaler-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := path.Join(dir, "worker.sh")
+	err = ioutil.WriteFile(command, []byte("#!/bin/sh\nexec sleep 60\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore := conftest.Save(&conf.AUTOSCALE_UP_COOLDOWN_SECONDS, &conf.AUTOSCALE_DOWN_COOLDOWN_SECONDS, &core.QMgr)
+	conf.AUTOSCALE_UP_COOLDOWN_SECONDS = 60
+	conf.AUTOSCALE_DOWN_COOLDOWN_SECONDS = 300
+	core.QMgr = core.NewServerMgr()
+
+	provider = NewLocalProvider(command, "")
+	cleanup = func() {
+		provider.Stop()
+		restore()
+		os.RemoveAll(dir)
+	}
+	return
+}
+
+// addClients registers the clients of the workers started by the provider
+func addClients(t *testing.T, provider *LocalProvider) {
+	provider.Lock()
+	defer provider.Unlock()
+	for name := range provider.workers {
+		client := core.NewClient()
+		client.ID = "id-" + name
+		client.WorkerRuntime.Name = name
+		if err := core.QMgr.AddClient(client, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func workers(t *testing.T, provider *LocalProvider) int {
+	count, err := provider.Workers("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestScaleUp(t *testing.T) {
+	provider, cleanup := setupLocalProvider(t)
+	defer cleanup()
+	a := NewAutoscaler(provider)
+	now := time.Now()
+
+	err := a.scaleGroup(&core.QueueDemand{Group: "default", Queued: 3}, [2]int{0, 10}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := workers(t, provider); n != 3 {
+		t.Fatalf("expected 3 workers, got %d", n)
+	}
+
+	// no second scale up within the cooldown
+	err = a.scaleGroup(&core.QueueDemand{Group: "default", Queued: 5}, [2]int{0, 10}, now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := workers(t, provider); n != 3 {
+		t.Errorf("scaled up during the cooldown: %d workers", n)
+	}
+
+	// the upper bound limits the workers
+	err = a.scaleGroup(&core.QueueDemand{Group: "default", Queued: 5, Busy: 3}, [2]int{0, 4}, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := workers(t, provider); n != 4 {
+		t.Errorf("expected 4 workers, got %d", n)
+	}
+}
+
+func TestScaleDownDrainsIdleClients(t *testing.T) {
+	provider, cleanup := setupLocalProvider(t)
+	defer cleanup()
+	a := NewAutoscaler(provider)
+	now := time.Now()
+
+	err := a.scaleGroup(&core.QueueDemand{Group: "default", Queued: 3}, [2]int{0, 10}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addClients(t, provider)
+
+	// one client is busy, only one idle client is known: the busy one must not be drained
+	idle := "id-default-local-3"
+	demand := &core.QueueDemand{Group: "default", Busy: 1, Idle: []string{idle}}
+	err = a.scaleGroup(demand, [2]int{0, 10}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := workers(t, provider); n != 3 {
+		t.Errorf("drained during the cooldown: %d workers", n)
+	}
+
+	err = a.scaleGroup(demand, [2]int{0, 10}, now.Add(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := workers(t, provider); n != 2 {
+		t.Errorf("expected 2 workers after draining the idle client, got %d", n)
+	}
+	for i := 1; i <= 3; i++ {
+		client, ok, err := core.QMgr.GetClient(fmt.Sprintf("id-default-local-%d", i), true)
+		if err != nil || !ok {
+			t.Fatalf("client %d not found", i)
+		}
+		draining, _ := client.GetDraining(true)
+		if draining != (i == 3) {
+			t.Errorf("client %d: draining=%t", i, draining)
+		}
+	}
+
+	// the lower bound keeps workers
+	err = a.scaleGroup(&core.QueueDemand{Group: "default", Idle: []string{"id-default-local-1", "id-default-local-2"}}, [2]int{1, 10}, now.Add(20*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := workers(t, provider); n != 1 {
+		t.Errorf("expected 1 worker at the lower bound, got %d", n)
+	}
+}
@@ -0,0 +1,88 @@
+package autoscaler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/kube"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// podDeletionCost pods with a lower cost are removed first when a Deployment is scaled down
+const podDeletionCost = "controller.kubernetes.io/pod-deletion-cost"
+
+// KubernetesProvider scales one worker Deployment per client group. The client name of the
+// workers has to be the pod name (the default, the hostname of the pod).
+type KubernetesProvider struct {
+	Client     *kube.RESTClient
+	Namespace  string
+	Deployment string // name template, {group} is replaced by the client group
+}
+
+// NewKubernetesProvider uses the service account of the server pod
+func NewKubernetesProvider(apiURL string, namespace string, deployment string) (p *KubernetesProvider, err error) {
+	client, err := kube.NewInClusterClient(apiURL)
+	if err != nil {
+		return
+	}
+	if namespace == "" {
+		namespace = kube.InClusterNamespace()
+	}
+	p = &KubernetesProvider{Client: client, Namespace: namespace, Deployment: deployment}
+	return
+}
+
+// Name _
+func (p *KubernetesProvider) Name() string {
+	return "kubernetes"
+}
+
+func (p *KubernetesProvider) deployment(group string) string {
+	return strings.Replace(p.Deployment, "{group}", group, -1)
+}
+
+// Workers returns the replicas of the Deployment
+func (p *KubernetesProvider) Workers(group string) (count int, err error) {
+	scale, err := p.Client.GetDeploymentScale(p.Namespace, p.deployment(group))
+	if err != nil {
+		return
+	}
+	count = int(scale.Spec.Replicas)
+	return
+}
+
+// Start _
+func (p *KubernetesProvider) Start(group string, count int) (err error) {
+	name := p.deployment(group)
+	scale, err := p.Client.GetDeploymentScale(p.Namespace, name)
+	if err != nil {
+		return
+	}
+	return p.Client.UpdateDeploymentScale(p.Namespace, name, scale.Spec.Replicas+int32(count))
+}
+
+// Drain marks the pods of the clients for deletion and scales the Deployment down
+func (p *KubernetesProvider) Drain(group string, clients []*core.Client) (err error) {
+	name := p.deployment(group)
+	for _, client := range clients {
+		pod := client.WorkerRuntime.Name
+		xerr := p.Client.AnnotatePod(p.Namespace, pod, map[string]string{podDeletionCost: "-1000"})
+		if xerr != nil {
+			logger.Warning("(KubernetesProvider) could not annotate pod %s: %s", pod, xerr.Error())
+		}
+	}
+	scale, err := p.Client.GetDeploymentScale(p.Namespace, name)
+	if err != nil {
+		return
+	}
+	replicas := scale.Spec.Replicas - int32(len(clients))
+	if replicas < 0 {
+		replicas = 0
+	}
+	err = p.Client.UpdateDeploymentScale(p.Namespace, name, replicas)
+	if err != nil {
+		err = fmt.Errorf("(KubernetesProvider/Drain) UpdateDeploymentScale returned: %s", err.Error())
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package autoscaler
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// LocalProvider starts awe-worker processes on the server host, meant for testing
+type LocalProvider struct {
+	sync.Mutex
+	Command string
+	Args    []string
+	counter int
+	workers map[string]*localWorker // by client name
+}
+
+type localWorker struct {
+	group    string
+	cmd      *exec.Cmd
+	draining bool
+}
+
+// NewLocalProvider args are space separated
+func NewLocalProvider(command string, args string) *LocalProvider {
+	return &LocalProvider{Command: command, Args: strings.Fields(args), workers: make(map[string]*localWorker)}
+}
+
+// Name _
+func (p *LocalProvider) Name() string {
+	return "local"
+}
+
+// Workers _
+func (p *LocalProvider) Workers(group string) (count int, err error) {
+	p.Lock()
+	defer p.Unlock()
+	for _, worker := range p.workers {
+		if worker.group == group && !worker.draining {
+			count++
+		}
+	}
+	return
+}
+
+// Start the workers get the name <group>-local-<n>, by which they are found again when drained
+func (p *LocalProvider) Start(group string, count int) (err error) {
+	p.Lock()
+	defer p.Unlock()
+	for i := 0; i < count; i++ {
+		p.counter++
+		name := fmt.Sprintf("%s-local-%d", group, p.counter)
+		args := append(append([]string{}, p.Args...), "--group="+group, "--name="+name)
+		cmd := exec.Command(p.Command, args...)
+		err = cmd.Start()
+		if err != nil {
+			err = fmt.Errorf("(LocalProvider/Start) could not start %s: %s", p.Command, err.Error())
+			return
+		}
+		logger.Info("(LocalProvider) started worker %s (pid %d)", name, cmd.Process.Pid)
+		p.workers[name] = &localWorker{group: group, cmd: cmd}
+		go p.wait(name, cmd)
+	}
+	return
+}
+
+func (p *LocalProvider) wait(name string, cmd *exec.Cmd) {
+	err := cmd.Wait()
+	if err != nil {
+		logger.Warning("(LocalProvider) worker %s exited: %s", name, err.Error())
+	} else {
+		logger.Info("(LocalProvider) worker %s exited", name)
+	}
+	p.Lock()
+	delete(p.workers, name)
+	p.Unlock()
+}
+
+// Drain the processes exit by themselves, they are no longer counted
+func (p *LocalProvider) Drain(group string, clients []*core.Client) (err error) {
+	p.Lock()
+	defer p.Unlock()
+	for _, client := range clients {
+		worker, ok := p.workers[client.WorkerRuntime.Name]
+		if ok {
+			worker.draining = true
+		}
+	}
+	return
+}
+
+// Stop kills the worker processes
+func (p *LocalProvider) Stop() {
+	p.Lock()
+	defer p.Unlock()
+	for name, worker := range p.workers {
+		if err := worker.cmd.Process.Kill(); err != nil {
+			logger.Warning("(LocalProvider) could not stop worker %s: %s", name, err.Error())
+		}
+	}
+}
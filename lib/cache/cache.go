@@ -21,6 +21,8 @@ import (
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/datastore"
+	"github.com/MG-RAST/AWE/lib/localstore"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/logger/event"
 	shock "github.com/MG-RAST/go-shock-client"
@@ -163,6 +165,11 @@ func UploadFile(file *cwl.File, inputfilePath string, shockClient *shock.ShockCl
 	//	return
 	//}
 
+	if file.Path == "" && shockClient != nil && localstore.Stored(shockClient.Host, file.Location) {
+		// already in the local data store
+		return
+	}
+
 	filePath := file.Path
 	var fileSize int64
 	newFileName := ""
@@ -284,20 +291,21 @@ func UploadFile(file *cwl.File, inputfilePath string, shockClient *shock.ShockCl
 	}
 	//shockClient.Debug = true
 	var nodeid string
+	store := datastore.New(shockClient.Host, shockClient.Token)
 	if lazyUpload {
 		//example: "${SHOCK_SERVER}/node?querynode&file.checksum.md5=37cbb1f811a144158cec0cbd87d97941"
 		//nodeid, err = shockClient.PostFileIf(file_path, new_file_name)
 
-		nodeid, err = shockClient.PostFileLazy(filePath, newFileName, file.Contents, false)
+		nodeid, err = store.PostFileLazy(filePath, file.Contents, newFileName)
 		if err != nil {
-			err = fmt.Errorf("(UploadFile) shockClient.PostFileLazy returned: %s", err.Error())
+			err = fmt.Errorf("(UploadFile) store.PostFileLazy returned: %s", err.Error())
 			return
 		}
 	} else {
 
-		nodeid, err = shockClient.PostFile(filePath, file.Contents, newFileName)
+		nodeid, err = store.PostFile(filePath, file.Contents, newFileName)
 		if err != nil {
-			err = fmt.Errorf("(UploadFile) shockClient.PostFile returned: %s", err.Error())
+			err = fmt.Errorf("(UploadFile) store.PostFile returned: %s", err.Error())
 			return
 		}
 	}
@@ -418,9 +426,9 @@ func DownloadFile(file *cwl.File, downloadPath string, basePath string, shockCli
 		}
 	}
 
-	_, _, err = shock.FetchFile(filePath, file.Location, token, "", false)
+	_, err = datastore.New(file.Location, token).FetchFile(filePath, file.Location)
 	if err != nil {
-		err = fmt.Errorf("(DownloadFile) shock.FetchFile returned: %s (download_path: %s, basename: %s, file.Location: %s, TokenLength: %d)", err.Error(), downloadPath, basename, file.Location, len(token))
+		err = fmt.Errorf("(DownloadFile) FetchFile returned: %s (download_path: %s, basename: %s, file.Location: %s, TokenLength: %d)", err.Error(), downloadPath, basename, file.Location, len(token))
 		return
 	}
 
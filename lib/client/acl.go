@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+
+	"github.com/MG-RAST/AWE/lib/acl"
+	"github.com/MG-RAST/AWE/lib/clientGroupAcl"
+)
+
+// GetJobACL _
+func (c *Client) GetJobACL(ctx context.Context, jobID string) (jobACL *acl.Acl, err error) {
+	jobACL = &acl.Acl{}
+	_, err = c.Get(ctx, "job/"+jobID+"/acl", nil, jobACL)
+	return
+}
+
+// AddJobACL gives users (comma separated names or uuids) a right: read, write, delete, owner, all or public_read etc.
+func (c *Client) AddJobACL(ctx context.Context, jobID string, right string, users string) (jobACL *acl.Acl, err error) {
+	return c.changeJobACL(ctx, "PUT", jobID, right, users)
+}
+
+// RemoveJobACL _
+func (c *Client) RemoveJobACL(ctx context.Context, jobID string, right string, users string) (jobACL *acl.Acl, err error) {
+	return c.changeJobACL(ctx, "DELETE", jobID, right, users)
+}
+
+func (c *Client) changeJobACL(ctx context.Context, method string, jobID string, right string, users string) (jobACL *acl.Acl, err error) {
+	response, err := c.Do(ctx, method, "job/"+jobID+"/acl/"+right, nil, usersForm(users))
+	if err != nil {
+		return
+	}
+	jobACL = &acl.Acl{}
+	err = response.Decode(jobACL)
+	return
+}
+
+// GetClientGroupACL _
+func (c *Client) GetClientGroupACL(ctx context.Context, cgID string) (cgACL *clientGroupAcl.ClientGroupAcl, err error) {
+	cgACL = &clientGroupAcl.ClientGroupAcl{}
+	_, err = c.Get(ctx, "cgroup/"+cgID+"/acl", nil, cgACL)
+	return
+}
+
+// AddClientGroupACL gives users a right on a clientgroup, like on jobs, clientgroups also have execute
+func (c *Client) AddClientGroupACL(ctx context.Context, cgID string, right string, users string) (cgACL *clientGroupAcl.ClientGroupAcl, err error) {
+	return c.changeClientGroupACL(ctx, "PUT", cgID, right, users)
+}
+
+// RemoveClientGroupACL _
+func (c *Client) RemoveClientGroupACL(ctx context.Context, cgID string, right string, users string) (cgACL *clientGroupAcl.ClientGroupAcl, err error) {
+	return c.changeClientGroupACL(ctx, "DELETE", cgID, right, users)
+}
+
+func (c *Client) changeClientGroupACL(ctx context.Context, method string, cgID string, right string, users string) (cgACL *clientGroupAcl.ClientGroupAcl, err error) {
+	response, err := c.Do(ctx, method, "cgroup/"+cgID+"/acl/"+right, nil, usersForm(users))
+	if err != nil {
+		return
+	}
+	cgACL = &clientGroupAcl.ClientGroupAcl{}
+	err = response.Decode(cgACL)
+	return
+}
+
+// usersForm the server reads the users of an ACL change from a form
+func usersForm(users string) (form *Form) {
+	form = NewForm()
+	_ = form.AddField("users", users) // writes to a buffer
+	return
+}
@@ -0,0 +1,295 @@
+// Package client is a client for the AWE server API, used by awe-submitter, awe-worker and awe-cli.
+// Documents (jobs, clients, clientgroups) are decoded into the values callers pass, usually the lib/core
+// types, so the package does not depend on the server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Doer sends HTTP requests, *http.Client implements it; the worker uses core.ServerClient
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client talks to one AWE server
+type Client struct {
+	URL        string        // e.g. http://localhost:8001
+	Auth       string        // Authorization header, e.g. "mgrast <token>" or "CG_TOKEN <token>"
+	DataToken  string        // Datatoken header, the token for the data store used by submitted jobs
+	HTTPClient Doer          // http.DefaultClient if nil
+	Retries    int           // retries of requests that failed with a network error, 429, 502, 503 or 504
+	Backoff    time.Duration // wait before the first retry, doubled for each further retry
+}
+
+// New creates a client for the server at serverURL, auth may be empty
+func New(serverURL string, auth string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(serverURL, "/"),
+		Auth:       auth,
+		HTTPClient: http.DefaultClient,
+		Retries:    3,
+		Backoff:    time.Second,
+	}
+}
+
+// Response is the standard response of the AWE server, paginated responses have limit, offset and total_count
+type Response struct {
+	Status     int             `json:"status"`
+	Data       json.RawMessage `json:"data"`
+	Error      []string        `json:"error"`
+	Limit      int             `json:"limit"`
+	Offset     int             `json:"offset"`
+	TotalCount int             `json:"total_count"`
+	Header     http.Header     `json:"-"`
+}
+
+// Decode the data of the response into result
+func (response *Response) Decode(result interface{}) (err error) {
+	if len(response.Data) == 0 {
+		err = fmt.Errorf("(Decode) response has no data")
+		return
+	}
+	err = json.Unmarshal(response.Data, result)
+	if err != nil {
+		err = fmt.Errorf("(Decode) json.Unmarshal returned: %s", err.Error())
+	}
+	return
+}
+
+// Form is a multipart form body, e.g. for job submission
+type Form struct {
+	buffer bytes.Buffer
+	writer *multipart.Writer
+}
+
+// NewForm _
+func NewForm() (form *Form) {
+	form = &Form{}
+	form.writer = multipart.NewWriter(&form.buffer)
+	return
+}
+
+// AddField _
+func (form *Form) AddField(name string, value string) (err error) {
+	err = form.writer.WriteField(name, value)
+	if err != nil {
+		err = fmt.Errorf("(AddField) WriteField returned: %s", err.Error())
+	}
+	return
+}
+
+// AddFile adds data as a file with the given file name
+func (form *Form) AddFile(fieldname string, filename string, data []byte) (err error) {
+	part, err := form.writer.CreateFormFile(fieldname, filename)
+	if err != nil {
+		err = fmt.Errorf("(AddFile) CreateFormFile returned: %s", err.Error())
+		return
+	}
+	_, err = part.Write(data)
+	return
+}
+
+// body closes the form, it can not be changed afterwards
+func (form *Form) body() (data []byte, contentType string, err error) {
+	err = form.writer.Close()
+	if err != nil {
+		err = fmt.Errorf("(body) multipart.Writer.Close returned: %s", err.Error())
+		return
+	}
+	data = form.buffer.Bytes()
+	contentType = form.writer.FormDataContentType()
+	return
+}
+
+// Do sends a request to the resource (e.g. "job/<id>") and returns the response if it contains no errors.
+// body is nil, a *Form or a value that is sent as JSON. Errors returned by the server are of type *Error.
+// POST requests are only repeated if the server did not process them (429, 503).
+func (c *Client) Do(ctx context.Context, method string, resource string, query url.Values, body interface{}) (response *Response, err error) {
+	requestURL := c.URL + "/" + resource
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	var data []byte
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case *Form:
+		data, contentType, err = b.body()
+		if err != nil {
+			return
+		}
+	default:
+		data, err = json.Marshal(body)
+		if err != nil {
+			err = fmt.Errorf("(Do) json.Marshal returned: %s", err.Error())
+			return
+		}
+		contentType = "application/json"
+	}
+
+	wait := c.Backoff
+	for retry := 0; ; retry++ {
+		var httpResponse *http.Response
+		httpResponse, err = c.send(ctx, method, requestURL, data, contentType)
+
+		retryable := false
+		if err != nil {
+			retryable = method != "POST" && ctx.Err() == nil
+		} else {
+			switch httpResponse.StatusCode {
+			case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+				retryable = true
+				wait = RetryAfter(httpResponse, wait)
+			case http.StatusBadGateway, http.StatusGatewayTimeout:
+				retryable = method != "POST"
+			}
+		}
+
+		if !retryable || retry >= c.Retries {
+			if err != nil {
+				err = fmt.Errorf("(Do) %s %s failed: %s", method, requestURL, err.Error())
+				return
+			}
+			response, err = readResponse(method, requestURL, httpResponse)
+			return
+		}
+
+		if httpResponse != nil {
+			httpResponse.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("(Do) %s %s: %s", method, requestURL, ctx.Err().Error())
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// Get sends a GET request and decodes the data of the response into result
+func (c *Client) Get(ctx context.Context, resource string, query url.Values, result interface{}) (response *Response, err error) {
+	response, err = c.Do(ctx, "GET", resource, query, nil)
+	if err != nil {
+		return
+	}
+	err = response.Decode(result)
+	return
+}
+
+// message sends a request that returns a message, like suspend or delete
+func (c *Client) message(ctx context.Context, method string, resource string, query url.Values, body interface{}) (message string, err error) {
+	response, err := c.Do(ctx, method, resource, query, body)
+	if err != nil {
+		return
+	}
+	if len(response.Data) > 0 && json.Unmarshal(response.Data, &message) != nil {
+		message = string(response.Data)
+	}
+	return
+}
+
+func (c *Client) send(ctx context.Context, method string, requestURL string, data []byte, contentType string) (httpResponse *http.Response, err error) {
+	var reader io.Reader
+	if data != nil {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, requestURL, reader)
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Auth != "" {
+		req.Header.Set("Authorization", c.Auth)
+	}
+	if c.DataToken != "" {
+		req.Header.Set("Datatoken", c.DataToken)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	httpResponse, err = httpClient.Do(req)
+	return
+}
+
+// readResponse parses the standard response, errors in it or an error status are returned as *Error
+func readResponse(method string, requestURL string, httpResponse *http.Response) (response *Response, err error) {
+	defer httpResponse.Body.Close()
+
+	body, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		err = fmt.Errorf("(readResponse) ioutil.ReadAll returned: %s", err.Error())
+		return
+	}
+
+	apiErr := &Error{StatusCode: httpResponse.StatusCode, Method: method, URL: requestURL}
+	response = &Response{Header: httpResponse.Header}
+	if len(bytes.TrimSpace(body)) > 0 {
+		err = json.Unmarshal(body, response)
+		if err != nil {
+			if httpResponse.StatusCode >= 300 {
+				// e.g. a proxy in front of the server
+				apiErr.Messages = []string{bodyPrefix(body)}
+				err = apiErr
+				return
+			}
+			err = fmt.Errorf("(readResponse) json.Unmarshal returned: %s (%s %s, status %d, body: \"%s\")", err.Error(), method, requestURL, httpResponse.StatusCode, bodyPrefix(body))
+			return
+		}
+	}
+
+	if len(response.Error) > 0 || httpResponse.StatusCode >= 300 {
+		apiErr.Messages = response.Error
+		err = apiErr
+		return
+	}
+	return
+}
+
+// bodyPrefix the start of a response body in one line, this helps debugging
+func bodyPrefix(body []byte) string {
+	prefix := string(body)
+	if len(prefix) > 100 {
+		prefix = prefix[0:100] + "..."
+	}
+	r := strings.NewReplacer("\n", " ", "\r", " ")
+	return strings.TrimSpace(r.Replace(prefix))
+}
+
+// RetryAfter reads the Retry-After header (seconds or HTTP date) of a response
+func RetryAfter(response *http.Response, defaultWait time.Duration) (wait time.Duration) {
+	wait = defaultWait
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+		return
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait = time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+	}
+	return
+}
@@ -0,0 +1,314 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// respond writes a standard response like the goweb server
+func respond(w http.ResponseWriter, status int, data interface{}, errs ...string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "data": data, "error": errs})
+}
+
+// testJob the fields of a job the tests read, callers usually decode into core.Job
+type testJob struct {
+	ID         string `json:"id"`
+	State      string `json:"state"`
+	Root       string `json:"root"`
+	Entrypoint string `json:"entrypoint"`
+}
+
+func newTestClient(handler http.HandlerFunc) (c *Client, server *httptest.Server) {
+	server = httptest.NewServer(handler)
+	c = New(server.URL, "mgrast token")
+	c.Backoff = time.Millisecond
+	return
+}
+
+func TestGetJob(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/job/j1" || r.Header.Get("Authorization") != "mgrast token" {
+			respond(w, http.StatusBadRequest, nil, "unexpected request "+r.URL.Path)
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"id": "j1", "state": "completed", "root": "j1_root"})
+	})
+	defer server.Close()
+
+	job := &testJob{}
+	err := c.GetJob(context.Background(), "j1", job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != "j1" || job.State != "completed" || job.Root != "j1_root" {
+		t.Errorf("unexpected job %s %s %s", job.ID, job.State, job.Root)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusBadRequest, nil, "Client Not Found")
+	})
+	defer server.Close()
+
+	err := c.GetClient(context.Background(), "c1", &map[string]interface{}{})
+	apiErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || err.Error() != "Client Not Found" {
+		t.Errorf("unexpected error %d %s", apiErr.StatusCode, err.Error())
+	}
+	if !HasMessage(err, "Not Found") || IsNotFound(err) || StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("helpers do not match the error")
+	}
+	if StatusCode(fmt.Errorf("connection refused")) != -1 {
+		t.Errorf("StatusCode of a network error should be -1")
+	}
+}
+
+func TestErrorStatusWithoutJSON(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such page", http.StatusNotFound)
+	})
+	defer server.Close()
+
+	_, err := c.QueueStatus(context.Background())
+	if !IsNotFound(err) || !HasMessage(err, "no such page") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			respond(w, http.StatusTooManyRequests, nil, "too many requests")
+			return
+		}
+		respond(w, http.StatusOK, "job suspended")
+	})
+	defer server.Close()
+
+	message, err := c.SuspendJob(context.Background(), "j1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != "job suspended" || calls != 3 {
+		t.Errorf("unexpected message %q after %d calls", message, calls)
+	}
+
+	// out of retries
+	atomic.StoreInt32(&calls, -10)
+	c.Retries = 1
+	_, err = c.SuspendJob(context.Background(), "j1")
+	if StatusCode(err) != http.StatusTooManyRequests || calls != -8 {
+		t.Errorf("expected 429 after 2 calls, got %v after %d calls", err, calls+10)
+	}
+}
+
+func TestNoRetryOfPOST(t *testing.T) {
+	var calls int32
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		respond(w, http.StatusBadGateway, nil, "bad gateway")
+	})
+	defer server.Close()
+
+	err := c.CreateClientGroup(context.Background(), "cg1", &map[string]interface{}{})
+	if StatusCode(err) != http.StatusBadGateway || calls != 1 {
+		t.Errorf("expected one call with 502, got %v after %d calls", err, calls)
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusServiceUnavailable, nil, "standby")
+	})
+	defer server.Close()
+	c.Backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.GetJob(ctx, "j1", &testJob{})
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("the backoff did not stop with the context")
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	const total = 5
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if _, ok := query["active"]; !ok {
+			respond(w, http.StatusBadRequest, nil, "missing active")
+			return
+		}
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		jobs := []map[string]string{}
+		for i := offset; i < offset+limit && i < total; i++ {
+			jobs = append(jobs, map[string]string{"id": fmt.Sprintf("j%d", i)})
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": 200, "data": jobs, "limit": limit, "offset": offset, "total_count": total})
+	})
+	defer server.Close()
+
+	query := map[string][]string{"active": {""}}
+	jobs := []*testJob{}
+	page, err := c.ListJobs(context.Background(), query, ListOptions{Limit: 2}, &jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || !page.HasNext() || page.Next(ListOptions{}).Offset != 2 {
+		t.Errorf("unexpected first page: %d jobs, %+v", len(jobs), page)
+	}
+
+	ids := []string{}
+	err = c.EachPage(context.Background(), "job", query, ListOptions{Limit: 2}, func(response *Response) (more bool, err error) {
+		jobs := []*testJob{}
+		if err = response.Decode(&jobs); err != nil {
+			return
+		}
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "j0,j1,j2,j3,j4" {
+		t.Errorf("unexpected jobs %v", ids)
+	}
+
+	pages := 0
+	err = c.EachPage(context.Background(), "job", query, ListOptions{Limit: 2}, func(response *Response) (more bool, err error) {
+		pages++
+		return pages < 2, nil
+	})
+	if err != nil || pages != 2 {
+		t.Errorf("EachPage did not stop: %d pages, %v", pages, err)
+	}
+}
+
+func TestSubmitCWLJob(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Datatoken") != "shock token" {
+			respond(w, http.StatusBadRequest, nil, "unexpected request")
+			return
+		}
+		err := r.ParseMultipartForm(1 << 20)
+		if err != nil {
+			respond(w, http.StatusBadRequest, nil, err.Error())
+			return
+		}
+		file, _, err := r.FormFile("cwl")
+		if err != nil {
+			respond(w, http.StatusBadRequest, nil, err.Error())
+			return
+		}
+		workflow, _ := ioutil.ReadAll(file)
+		if string(workflow) != "cwlVersion: v1.0" || r.FormValue("CLIENT_GROUP") != "default" {
+			respond(w, http.StatusBadRequest, nil, "unexpected form")
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"id": "j1", "entrypoint": r.FormValue("entrypoint")})
+	})
+	defer server.Close()
+	c.DataToken = "shock token"
+
+	job := &testJob{}
+	err := c.SubmitCWLJob(context.Background(), "workflow.cwl", []byte("cwlVersion: v1.0"), "job.yaml", []byte("t: 5"), "default", "#main", job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != "j1" || job.Entrypoint != "#main" {
+		t.Errorf("unexpected job %s %s", job.ID, job.Entrypoint)
+	}
+}
+
+func TestWorkerCalls(t *testing.T) {
+	workID := "j1_#main/step1_0"
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "mgrast token" {
+			respond(w, http.StatusUnauthorized, nil, "unauthorized")
+			return
+		}
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/work" && query.Get("client") == "c1" && query.Get("available") == "1024":
+			respond(w, http.StatusOK, map[string]interface{}{
+				"task_name":     "step1",
+				"jobid":         "j1",
+				"rank":          0,
+				"id":            "j1_step1_0",
+				"cmd":           map[string]interface{}{"name": "echo"},
+				"info":          map[string]interface{}{"name": "test", "submittime": "2026-10-19T10:00:00Z", "auth": true},
+				"checkout_time": "2026-10-19T10:00:00Z",
+			})
+		case r.URL.Path == "/client/c1" && r.Method == "PUT":
+			state := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&state)
+			if _, ok := query["heartbeat"]; !ok || state["healthy"] != true {
+				respond(w, http.StatusBadRequest, nil, "unexpected heartbeat")
+				return
+			}
+			respond(w, http.StatusOK, map[string]string{"server-uuid": "s1"})
+		case r.URL.Path == "/work/base64:"+base64.URLEncoding.EncodeToString([]byte(workID)):
+			if _, ok := query["datatoken"]; !ok {
+				respond(w, http.StatusBadRequest, nil, "missing datatoken")
+				return
+			}
+			w.Header().Set("Datatoken", "data token")
+			respond(w, http.StatusOK, nil)
+		default:
+			respond(w, http.StatusBadRequest, nil, "unexpected request "+r.URL.String())
+		}
+	})
+	defer server.Close()
+	ctx := context.Background()
+
+	workunit, err := c.CheckoutWorkunit(ctx, "c1", 1024, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workunit["task_name"] != "step1" || workunit["jobid"] != "j1" {
+		t.Errorf("unexpected workunit: %v", workunit)
+	}
+
+	instructions := map[string]string{}
+	err = c.Heartbeat(ctx, "c1", map[string]bool{"healthy": true}, &instructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instructions["server-uuid"] != "s1" {
+		t.Errorf("unexpected instructions %v", instructions)
+	}
+
+	token, err := c.WorkunitDataToken(ctx, workID, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "data token" {
+		t.Errorf("unexpected token %q", token)
+	}
+}
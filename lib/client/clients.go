@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"net/url"
+)
+
+// Heartbeat sends the state of a worker (core.WorkerState), the server answers with instructions like discard
+// or drain (core.HeartbeatInstructions), they are decoded into instructions
+func (c *Client) Heartbeat(ctx context.Context, clientID string, state interface{}, instructions interface{}) (err error) {
+	response, err := c.Do(ctx, "PUT", "client/"+clientID, url.Values{"heartbeat": {""}}, state)
+	if err != nil {
+		return
+	}
+	if len(response.Data) > 0 && string(response.Data) != "null" {
+		err = response.Decode(instructions)
+	}
+	return
+}
+
+// ListClients decodes the clients registered with the server into clients, e.g. a *[]*core.Client. query
+// filters them, e.g. {"busy": {""}} or {"group": {"default"}}
+func (c *Client) ListClients(ctx context.Context, query url.Values, clients interface{}) (err error) {
+	_, err = c.Get(ctx, "client", query, clients)
+	return
+}
+
+// GetClient decodes a client into client, e.g. a *core.Client
+func (c *Client) GetClient(ctx context.Context, id string, client interface{}) (err error) {
+	_, err = c.Get(ctx, "client/"+id, nil, client)
+	return
+}
+
+// SuspendClient _
+func (c *Client) SuspendClient(ctx context.Context, id string) (message string, err error) {
+	return c.message(ctx, "PUT", "client/"+id, url.Values{"suspend": {""}}, nil)
+}
+
+// ResumeClient _
+func (c *Client) ResumeClient(ctx context.Context, id string) (message string, err error) {
+	return c.message(ctx, "PUT", "client/"+id, url.Values{"resume": {""}}, nil)
+}
+
+// ListClientGroups decodes the clientgroups the user can read into cgs, e.g. a *core.ClientGroups
+func (c *Client) ListClientGroups(ctx context.Context, cgs interface{}) (err error) {
+	_, err = c.Get(ctx, "cgroup", nil, cgs)
+	return
+}
+
+// CreateClientGroup decodes the new clientgroup with its token into cg, e.g. a *core.ClientGroup. Workers of
+// the group need the token.
+func (c *Client) CreateClientGroup(ctx context.Context, name string, cg interface{}) (err error) {
+	response, err := c.Do(ctx, "POST", "cgroup/"+name, nil, nil)
+	if err != nil {
+		return
+	}
+	err = response.Decode(cg)
+	return
+}
+
+// DeleteClientGroup _
+func (c *Client) DeleteClientGroup(ctx context.Context, name string) (message string, err error) {
+	return c.message(ctx, "DELETE", "cgroup/"+name, nil, nil)
+}
+
+// QueueStatus the numbers of jobs, tasks, workunits and clients by state, e.g. status["jobs"]["total"]
+func (c *Client) QueueStatus(ctx context.Context) (status map[string]map[string]int, err error) {
+	_, err = c.Get(ctx, "queue", url.Values{"json": {""}}, &status)
+	return
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error is returned if the server responds with errors ({"error": [...]}) or an error status
+type Error struct {
+	StatusCode int
+	Messages   []string // the error list of the response
+	Method     string
+	URL        string
+}
+
+// Error returns the messages of the server, callers like the worker compare them with lib/errors
+func (e *Error) Error() string {
+	if len(e.Messages) > 0 {
+		return strings.Join(e.Messages, ", ")
+	}
+	return fmt.Sprintf("%s %s returned status %d", e.Method, e.URL, e.StatusCode)
+}
+
+// IsNotFound _
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// HasMessage reports whether one of the messages of the server contains message, e.g. errors.ClientNotFound
+func HasMessage(err error, message string) bool {
+	apiErr, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+	for _, m := range apiErr.Messages {
+		if strings.Contains(m, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusCode the status of the response, -1 if the server did not respond
+func StatusCode(err error) int {
+	apiErr, ok := err.(*Error)
+	if !ok {
+		return -1
+	}
+	return apiErr.StatusCode
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// SubmitJob submits a job from a multipart form (fields cwl, job, CLIENT_GROUP, entrypoint, ...) and
+// decodes the new job into job, e.g. a *core.Job
+func (c *Client) SubmitJob(ctx context.Context, form *Form, job interface{}) (err error) {
+	response, err := c.Do(ctx, "POST", "job", nil, form)
+	if err != nil {
+		return
+	}
+	err = response.Decode(job)
+	return
+}
+
+// SubmitCWLJob submits a CWL workflow document and its job input document, jobInput may be empty
+func (c *Client) SubmitCWLJob(ctx context.Context, workflowFile string, workflow []byte, jobFile string, jobInput []byte, clientGroup string, entrypoint string, job interface{}) (err error) {
+	form := NewForm()
+	err = form.AddFile("cwl", workflowFile, workflow)
+	if err != nil {
+		return
+	}
+	if jobFile != "" {
+		err = form.AddFile("job", jobFile, jobInput)
+		if err != nil {
+			return
+		}
+	}
+	err = form.AddField("CLIENT_GROUP", clientGroup)
+	if err != nil {
+		return
+	}
+	err = form.AddField("entrypoint", entrypoint)
+	if err != nil {
+		return
+	}
+	return c.SubmitJob(ctx, form, job)
+}
+
+// GetJob decodes a job into job, e.g. a *core.Job
+func (c *Client) GetJob(ctx context.Context, id string, job interface{}) (err error) {
+	if id == "" {
+		err = fmt.Errorf("(GetJob) job id empty")
+		return
+	}
+	_, err = c.Get(ctx, "job/"+id, nil, job)
+	return
+}
+
+// ListJobs decodes one page of jobs into jobs, e.g. a *[]*core.Job. query selects them like on the server,
+// e.g. {"active": {""}} or {"query": {""}, "info.user": {"alice"}}. EachPage walks through all pages.
+func (c *Client) ListJobs(ctx context.Context, query url.Values, options ListOptions, jobs interface{}) (page Page, err error) {
+	response, err := c.Get(ctx, "job", options.apply(query), jobs)
+	if err != nil {
+		return
+	}
+	page = Page{Limit: response.Limit, Offset: response.Offset, TotalCount: response.TotalCount}
+	return
+}
+
+// SuspendJob _
+func (c *Client) SuspendJob(ctx context.Context, id string) (message string, err error) {
+	return c.message(ctx, "PUT", "job/"+id, url.Values{"suspend": {""}}, nil)
+}
+
+// ResumeJob _
+func (c *Client) ResumeJob(ctx context.Context, id string) (message string, err error) {
+	return c.message(ctx, "PUT", "job/"+id, url.Values{"resume": {""}}, nil)
+}
+
+// ResubmitJob _
+func (c *Client) ResubmitJob(ctx context.Context, id string) (message string, err error) {
+	return c.message(ctx, "PUT", "job/"+id, url.Values{"resubmit": {""}}, nil)
+}
+
+// RecomputeJob recomputes a job from the task
+func (c *Client) RecomputeJob(ctx context.Context, id string, task string) (message string, err error) {
+	return c.message(ctx, "PUT", "job/"+id, url.Values{"recompute": {task}}, nil)
+}
+
+// DeleteJob marks a job as deleted, full removes it from the database
+func (c *Client) DeleteJob(ctx context.Context, id string, full bool) (message string, err error) {
+	query := url.Values{}
+	if full {
+		query.Set("full", "")
+	}
+	return c.message(ctx, "DELETE", "job/"+id, query, nil)
+}
+
+// GetWorkflowInstance a workflow instance of a job, e.g. job.Root, as the server returns it
+func (c *Client) GetWorkflowInstance(ctx context.Context, id string) (wi map[string]interface{}, err error) {
+	if id == "" {
+		err = fmt.Errorf("(GetWorkflowInstance) workflow instance id empty")
+		return
+	}
+	_, err = c.Get(ctx, "workflow_instances/"+id, nil, &wi)
+	return
+}
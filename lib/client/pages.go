@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// ListOptions paging and ordering of list requests, zero values use the defaults of the server
+type ListOptions struct {
+	Limit     int
+	Offset    int
+	Order     string // field, e.g. info.submittime
+	Direction string // asc or desc
+}
+
+// Page the position of a response in a paginated list
+type Page struct {
+	Limit      int
+	Offset     int
+	TotalCount int
+}
+
+// HasNext reports whether there are more results after this page
+func (page Page) HasNext() bool {
+	return page.Limit > 0 && page.Offset+page.Limit < page.TotalCount
+}
+
+// Next the options for the following page
+func (page Page) Next(options ListOptions) ListOptions {
+	options.Limit = page.Limit
+	options.Offset = page.Offset + page.Limit
+	return options
+}
+
+// apply adds the options to the query of a request
+func (options ListOptions) apply(query url.Values) url.Values {
+	result := url.Values{}
+	for key, values := range query {
+		result[key] = values
+	}
+	if options.Limit > 0 {
+		result.Set("limit", strconv.Itoa(options.Limit))
+	}
+	if options.Offset > 0 {
+		result.Set("offset", strconv.Itoa(options.Offset))
+	}
+	if options.Order != "" {
+		result.Set("order", options.Order)
+	}
+	if options.Direction != "" {
+		result.Set("direction", options.Direction)
+	}
+	return result
+}
+
+// EachPage gets the pages of a paginated resource until fn returns false or an error, or the last page is reached
+func (c *Client) EachPage(ctx context.Context, resource string, query url.Values, options ListOptions, fn func(response *Response) (more bool, err error)) (err error) {
+	for {
+		var response *Response
+		response, err = c.Do(ctx, "GET", resource, options.apply(query), nil)
+		if err != nil {
+			return
+		}
+		more, xerr := fn(response)
+		if xerr != nil || !more {
+			return xerr
+		}
+		page := Page{Limit: response.Limit, Offset: response.Offset, TotalCount: response.TotalCount}
+		if !page.HasNext() {
+			return
+		}
+		options = page.Next(options)
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// workPath the resource of a workunit, its id contains "/" and "#" for CWL tasks
+func workPath(workID string) string {
+	return "work/base64:" + base64.URLEncoding.EncodeToString([]byte(workID))
+}
+
+// CheckoutWorkunit checks out a workunit for the client, available is the free disk space in bytes
+// (-1 if unknown). The workunit is returned as the server sends it, the worker decodes it.
+func (c *Client) CheckoutWorkunit(ctx context.Context, clientID string, available int64, serverUUID string) (data map[string]interface{}, err error) {
+	query := url.Values{
+		"client":      {clientID},
+		"available":   {strconv.FormatInt(available, 10)},
+		"server_uuid": {serverUUID},
+	}
+	_, err = c.Get(ctx, "work", query, &data)
+	if err != nil {
+		return
+	}
+	if data == nil {
+		err = fmt.Errorf("(CheckoutWorkunit) data field missing")
+	}
+	return
+}
+
+// WorkunitDataToken the data store token of the job of a workunit, empty if the job has none
+func (c *Client) WorkunitDataToken(ctx context.Context, workID string, clientID string) (token string, err error) {
+	response, err := c.Do(ctx, "GET", workPath(workID), url.Values{"datatoken": {""}, "client": {clientID}}, nil)
+	if err != nil {
+		return
+	}
+	token = response.Header.Get("Datatoken")
+	return
+}
+
+// WorkunitPrivateEnv the private environment variables of a workunit
+func (c *Client) WorkunitPrivateEnv(ctx context.Context, workID string, clientID string) (envs map[string]string, err error) {
+	response, err := c.Do(ctx, "GET", workPath(workID), url.Values{"privateenv": {""}, "client": {clientID}}, nil)
+	if err != nil {
+		return
+	}
+	envs = map[string]string{}
+	err = json.Unmarshal([]byte(response.Header.Get("Privateenv")), &envs)
+	if err != nil {
+		err = fmt.Errorf("(WorkunitPrivateEnv) json.Unmarshal returned: %s", err.Error())
+	}
+	return
+}
+
+// WorkunitReport the stdout, stderr or worknotes log of a workunit
+func (c *Client) WorkunitReport(ctx context.Context, workID string, report string) (log string, err error) {
+	_, err = c.Get(ctx, workPath(workID), url.Values{"report": {report}}, &log)
+	return
+}
@@ -0,0 +1,27 @@
+// Package conftest helps tests that change the global configuration of lib/conf
+package conftest
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Save records the values of config variables, e.g. Save(&conf.WORK_PATH, &conf.BATCH_SYSTEM).
+// The function returned sets them back, tests defer it before they change the variables.
+func Save(variables ...interface{}) (restore func()) {
+	saved := make([]reflect.Value, len(variables))
+	for i, variable := range variables {
+		value := reflect.ValueOf(variable)
+		if value.Kind() != reflect.Ptr || value.IsNil() {
+			panic(fmt.Sprintf("(conftest.Save) argument %d is not a pointer to a variable: %T", i, variable))
+		}
+		saved[i] = reflect.New(value.Elem().Type()).Elem()
+		saved[i].Set(value.Elem())
+	}
+	restore = func() {
+		for i, variable := range variables {
+			reflect.ValueOf(variable).Elem().Set(saved[i])
+		}
+	}
+	return
+}
@@ -0,0 +1,22 @@
+package conftest
+
+import (
+	"testing"
+)
+
+func TestSave(t *testing.T) {
+	name, count, list := "a", 1, []string{"x"}
+	restore := Save(&name, &count, &list)
+	name, count, list = "b", 2, nil
+	restore()
+	if name != "a" || count != 1 || len(list) != 1 {
+		t.Errorf("not restored: %s %d %v", name, count, list)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic for a value")
+		}
+	}()
+	Save(name)
+}
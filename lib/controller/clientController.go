@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
@@ -251,11 +252,71 @@ func (cr *ClientController) Update(id string, cx *goweb.Context) {
 
 	}
 
+	var drainDeadline time.Duration
+	if query.Has("deadline") {
+		var err error
+		drainDeadline, err = time.ParseDuration(query.Value("deadline"))
+		if err != nil {
+			cx.RespondWithErrorMessage("invalid deadline, e.g. 30m: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// drain requested by the worker itself (SIGTERM), authenticated as clientgroup
+	if (query.Has("drain") || query.Has("deregister")) && clientGroupRequest(cx.Request) {
+		cg, done := GetClientGroup(cx)
+		if done {
+			return
+		}
+		if cg == nil {
+			cx.RespondWithErrorMessage(e.InvalidAuth, http.StatusUnauthorized)
+			return
+		}
+		if query.Has("deregister") {
+			if err := core.QMgr.DeregisterClientByClientGroup(id, cg); err != nil {
+				cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			} else {
+				cx.RespondWithData("client deregistered")
+			}
+			return
+		}
+		if err := core.QMgr.DrainClientByClientGroup(id, cg, drainDeadline); err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		} else {
+			cx.RespondWithData("client draining")
+		}
+		return
+	}
+
 	u, done := GetAuthorizedUser(cx)
 	if done {
 		return
 	}
 
+	if query.Has("drain") { //no new work, finish current work then exit
+		if err := core.QMgr.DrainClientByUser(id, u, false, drainDeadline); err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		} else {
+			cx.RespondWithData("client draining")
+		}
+		return
+	}
+	if query.Has("deregister") { //remove a draining client
+		if err := core.QMgr.DeregisterClientByUser(id, u); err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		} else {
+			cx.RespondWithData("client deregistered")
+		}
+		return
+	}
+	if query.Has("maintenance") { //no new work, the client stays registered until resumed
+		if err := core.QMgr.DrainClientByUser(id, u, true, drainDeadline); err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		} else {
+			cx.RespondWithData("client in maintenance")
+		}
+		return
+	}
 	if query.Has("subclients") { //update the number of subclients for a proxy
 		if count, err := strconv.Atoi(query.Value("subclients")); err != nil {
 			cx.RespondWithError(http.StatusNotImplemented)
@@ -285,6 +346,15 @@ func (cr *ClientController) Update(id string, cx *goweb.Context) {
 	return
 }
 
+// clientGroupRequest the request carries a clientgroup token or certificate. Requests without either are
+// authorized as user requests, also if clients need no authentication.
+func clientGroupRequest(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	return strings.HasPrefix(r.Header.Get("Authorization"), "CG_TOKEN ")
+}
+
 // PUT: /client
 func (cr *ClientController) UpdateMany(cx *goweb.Context) {
 	LogRequest(cx.Request)
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/user"
+	"github.com/MG-RAST/golib/goweb"
+)
+
+// updateClient sends PUT /client/{id}?<query> to the ClientController
+func updateClient(id string, query string, authorization string) int {
+	request := httptest.NewRequest("PUT", "/client/"+id+"?"+query, nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	cx := &goweb.Context{Request: request, ResponseWriter: recorder, Format: goweb.JSON_FORMAT}
+	(&ClientController{}).Update(id, cx)
+	return recorder.Code
+}
+
+func TestClientDrainAndDeregister(t *testing.T) {
+	defer conftest.Save(&conf.CLIENT_AUTH_REQ, &conf.ANON_WRITE, &core.QMgr)()
+	conf.CLIENT_AUTH_REQ = false
+	core.QMgr = core.NewServerMgr()
+
+	owned, err := core.CreateClientGroup("owned", &user.User{Uuid: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := core.CreateClientGroup("other", &user.User{Uuid: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := core.NewClient()
+	client.ID = "c1"
+	client.Group = owned.Name
+	if err = core.QMgr.AddClient(client, true); err != nil {
+		t.Fatal(err)
+	}
+	draining := func() bool {
+		d, _ := client.GetDraining(true)
+		return d
+	}
+	registered := func() bool {
+		has, _ := core.QMgr.HasClient("c1", true)
+		return has
+	}
+
+	// anonymous requests are user requests, the clientgroup belongs to alice
+	for _, anonWrite := range []bool{false, true} {
+		conf.ANON_WRITE = anonWrite
+		for _, query := range []string{"drain", "deregister"} {
+			if code := updateClient("c1", query, ""); code == http.StatusOK {
+				t.Errorf("anonymous %s (anon_write=%t) accepted", query, anonWrite)
+			}
+		}
+	}
+	if code := updateClient("c1", "drain", "CG_TOKEN invalid"); code != http.StatusUnauthorized {
+		t.Errorf("drain with an invalid token: got %d", code)
+	}
+	if code := updateClient("c1", "drain", "CG_TOKEN "+other.Token); code == http.StatusOK {
+		t.Errorf("drain with the token of another clientgroup accepted")
+	}
+	if draining() || !registered() {
+		t.Fatalf("client drained or deregistered without authorization")
+	}
+
+	// the worker itself
+	if code := updateClient("c1", "deregister", "CG_TOKEN "+owned.Token); code == http.StatusOK {
+		t.Errorf("deregister of a client that is not draining accepted")
+	}
+	if code := updateClient("c1", "drain", "CG_TOKEN "+owned.Token); code != http.StatusOK || !draining() {
+		t.Errorf("drain with the clientgroup token: got %d", code)
+	}
+	if code := updateClient("c1", "deregister", "CG_TOKEN "+owned.Token); code != http.StatusOK || registered() {
+		t.Errorf("deregister with the clientgroup token: got %d", code)
+	}
+}
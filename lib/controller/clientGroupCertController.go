@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/request"
+	"github.com/MG-RAST/AWE/lib/user"
+	"github.com/MG-RAST/golib/goweb"
+	mgo "gopkg.in/mgo.v2"
+)
+
+// loadClientGroupForCert authenticates the user and loads the clientgroup if the user may manage its certificates
+func loadClientGroupForCert(cx *goweb.Context) (cg *core.ClientGroup, done bool) {
+	done = true
+
+	// Try to authenticate user.
+	u, err := request.Authenticate(cx.Request)
+	if err != nil && err.Error() != e.NoAuth {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// If no auth was provided and ANON_CG_WRITE is true, use the public user.
+	if u == nil {
+		if conf.ANON_CG_WRITE == true {
+			u = &user.User{Uuid: "public"}
+		} else {
+			cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	cgid := cx.PathParams["cgid"]
+	cg, err = core.LoadClientGroup(cgid)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			cx.RespondWithNotFound()
+		} else {
+			cx.RespondWithErrorMessage("clientgroup id not found:"+cgid, http.StatusBadRequest)
+		}
+		return
+	}
+
+	// same rules as for the clientgroup token: certificates are equivalent to the token
+	rights := cg.ACL.Check(u.Uuid)
+	public_rights := cg.ACL.Check("public")
+	if (u.Uuid != "public" && (cg.ACL.Owner == u.Uuid || rights["write"] == true || u.Admin == true || public_rights["write"] == true)) ||
+		(u.Uuid == "public" && conf.ANON_CG_WRITE == true && public_rights["write"] == true) {
+		done = false
+		return
+	}
+
+	cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+	return
+}
+
+// GET, POST, OPTIONS: /cgroup/{cgid}/cert
+// POST issues a new worker certificate. The body may contain a PEM encoded CSR, otherwise a key is generated.
+// The common name can be set with ?name=
+var ClientGroupCertController goweb.ControllerFunc = func(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	if cx.Request.Method == "OPTIONS" {
+		cx.RespondWithOK()
+		return
+	}
+
+	cg, done := loadClientGroupForCert(cx)
+	if done {
+		return
+	}
+
+	switch cx.Request.Method {
+	case "GET":
+		cx.RespondWithData(cg.Certificates)
+		return
+	case "POST":
+		csr, err := ioutil.ReadAll(cx.Request.Body)
+		defer cx.Request.Body.Close()
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := &Query{Li: cx.Request.URL.Query()}
+		commonName := ""
+		if query.Has("name") {
+			commonName = query.Value("name")
+		}
+		issued, err := cg.IssueCertificate(commonName, csr)
+		if err != nil {
+			logger.Error("(ClientGroupCertController) clientgroup %s: %s", cg.Name, err.Error())
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("(ClientGroupCertController) issued certificate %s (%s) for clientgroup %s", issued.Serial, issued.CommonName, cg.Name)
+		cx.RespondWithData(issued)
+		return
+	default:
+		cx.RespondWithError(http.StatusNotImplemented)
+		return
+	}
+}
+
+// GET, DELETE, OPTIONS: /cgroup/{cgid}/cert/{serial}
+// DELETE revokes the certificate
+var ClientGroupCertControllerTyped goweb.ControllerFunc = func(cx *goweb.Context) {
+	LogRequest(cx.Request)
+
+	if cx.Request.Method == "OPTIONS" {
+		cx.RespondWithOK()
+		return
+	}
+
+	cg, done := loadClientGroupForCert(cx)
+	if done {
+		return
+	}
+	serial := cx.PathParams["serial"]
+
+	switch cx.Request.Method {
+	case "GET":
+		for _, c := range cg.Certificates {
+			if c.Serial == serial {
+				cx.RespondWithData(c)
+				return
+			}
+		}
+		cx.RespondWithNotFound()
+		return
+	case "DELETE":
+		if err := cg.RevokeCertificate(serial); err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("(ClientGroupCertController) revoked certificate %s of clientgroup %s", serial, cg.Name)
+		cx.RespondWithData(cg.Certificates)
+		return
+	default:
+		cx.RespondWithError(http.StatusNotImplemented)
+		return
+	}
+}
@@ -9,11 +9,13 @@ type ServerController struct {
 	Client            *ClientController
 	ClientGroup       *ClientGroupController
 	ClientGroupAcl    map[string]goweb.ControllerFunc
+	ClientGroupCert   map[string]goweb.ControllerFunc
 	ClientGroupToken  goweb.ControllerFunc
 	Job               *JobController
 	JobAcl            map[string]goweb.ControllerFunc
 	Logger            *LoggerController
 	Queue             *QueueController
+	Retention         *RetentionController
 	Work              *WorkController
 	WorkflowInstances *WorkflowInstancesController
 }
@@ -24,11 +26,13 @@ func NewServerController() *ServerController {
 		Client:            new(ClientController),
 		ClientGroup:       new(ClientGroupController),
 		ClientGroupAcl:    map[string]goweb.ControllerFunc{"base": ClientGroupAclController, "typed": ClientGroupAclControllerTyped},
+		ClientGroupCert:   map[string]goweb.ControllerFunc{"base": ClientGroupCertController, "typed": ClientGroupCertControllerTyped},
 		ClientGroupToken:  ClientGroupTokenController,
 		Job:               new(JobController),
 		JobAcl:            map[string]goweb.ControllerFunc{"base": JobAclController, "typed": JobAclControllerTyped},
 		Logger:            new(LoggerController),
 		Queue:             new(QueueController),
+		Retention:         new(RetentionController),
 		Work:              new(WorkController),
 		WorkflowInstances: new(WorkflowInstancesController),
 	}
@@ -66,6 +66,8 @@ func (cr *JobController) Create(cx *goweb.Context) {
 	if err != nil {
 		if err.Error() == "request Content-Type isn't multipart/form-data" {
 			cx.RespondWithErrorMessage("No job file is submitted", http.StatusBadRequest)
+		} else if strings.Contains(err.Error(), "request body too large") {
+			cx.RespondWithErrorMessage(fmt.Sprintf("job submission too large, limit is %d MB", conf.MAX_JOB_UPLOAD_MB), http.StatusRequestEntityTooLarge)
 		} else {
 			// Some error other than request encoding. Theoretically
 			// could be a lost db connection between user lookup and parsing.
@@ -76,6 +78,16 @@ func (cr *JobController) Create(cx *goweb.Context) {
 		return
 	}
 
+	// validate the parameters before a job is created
+	var deadline time.Time
+	if params["deadline"] != "" { // the job should complete before this timepoint
+		deadline, err = time.Parse(time.RFC3339, params["deadline"])
+		if err != nil {
+			cx.RespondWithErrorMessage("deadline must be a RFC3339 timestamp, e.g. 2006-01-02T15:04:05Z", http.StatusBadRequest)
+			return
+		}
+	}
+
 	_, hasImport := files["import"]
 	_, hasUpload := files["upload"]
 	_, hasAWF := files["awf"]
@@ -85,7 +97,14 @@ func (cr *JobController) Create(cx *goweb.Context) {
 	var job *core.Job
 	job = nil
 
+	query := &Query{Li: cx.Request.URL.Query()}
+	dryrun := query.Has("dryrun")
+
 	if hasImport {
+		if dryrun {
+			cx.RespondWithErrorMessage("dryrun is not supported for job imports", http.StatusBadRequest)
+			return
+		}
 		// import a job document
 		job, err = core.CreateJobImport(_user, files["import"])
 		if err != nil {
@@ -582,6 +601,17 @@ func (cr *JobController) Create(cx *goweb.Context) {
 		//	spew.Dump(step)
 		//}
 
+		clientGroup, ok := params["CLIENT_GROUP"]
+		if !ok {
+			clientGroup = conf.CLIENT_GROUP
+		}
+
+		if admitJob(cx, _user, dryrun, func(report *core.AdmissionReport) {
+			report.CheckCWLJob(jobInput, cwlWorkflow, context, clientGroup)
+		}) {
+			return
+		}
+
 		//context.CwlVersion = cwl_version
 		//fmt.Println("\n\n\n--------------------------------- Create AWE Job:\n")
 		job, err = core.CWL2AWE(_user, files, jobInput, cwlWorkflow, entrypoint, context)
@@ -617,11 +647,6 @@ func (cr *JobController) Create(cx *goweb.Context) {
 
 		job.Info.Pipeline = cwlWorkflowFileName
 
-		clientGroup, ok := params["CLIENT_GROUP"]
-		if !ok {
-			clientGroup = conf.CLIENT_GROUP
-		}
-
 		job.Info.ClientGroups = clientGroup
 
 		if shockRequirement != nil {
@@ -636,6 +661,20 @@ func (cr *JobController) Create(cx *goweb.Context) {
 	} else {
 		// create new uploaded job
 
+		if hasUpload && (dryrun || conf.ADMISSION_POLICY != "off") {
+			var parsedJob *core.Job
+			parsedJob, err = core.ReadJobFile(files["upload"].Path)
+			if err != nil {
+				cx.RespondWithErrorMessage(fmt.Sprintf("(JobController/Create) ReadJobFile returned: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+			if admitJob(cx, _user, dryrun, func(report *core.AdmissionReport) {
+				report.CheckAWEJob(parsedJob)
+			}) {
+				return
+			}
+		}
+
 		job, err = core.CreateJobUpload(_user, files)
 
 		if err != nil {
@@ -647,6 +686,13 @@ func (cr *JobController) Create(cx *goweb.Context) {
 		logger.Event(event.JOB_SUBMISSION, "jobid="+job.ID+";name="+job.Info.Name+";project="+job.Info.Project+";user="+job.Info.User)
 	}
 
+	if params["keep_intermediates"] == "true" { // no cleanup of the intermediate nodes when the job completes
+		job.Info.KeepIntermediates = true
+	}
+	if !deadline.IsZero() {
+		job.Info.Deadline = deadline
+	}
+
 	token, err := request.RetrieveToken(cx.Request)
 	if err != nil {
 		logger.Debug(3, "job %s no token", job.ID)
@@ -693,16 +739,8 @@ func (cr *JobController) Create(cx *goweb.Context) {
 				return
 			}
 
-			//var wi *core.WorkflowInstance
-
-			//wi = loaded_job.WorkflowInstancesMap["_root"]
-
-			//err = core.QMgr.EnqueueWorkflowInstance(wi)
-			//if err != nil {
-			//	err = fmt.Errorf("(JobController/Create) core.QMgr.EnqueueTasksByJobId returned: %s", err.Error())
-			//	cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
-			//	return
-			//}
+			// create the tasks of the root workflow instance without waiting for the reconciliation pass
+			core.QMgr.NotifyWorkflowInstancesPending(job)
 
 		} else {
 			err = core.QMgr.EnqueueTasksByJobId(job.ID, "JobController/Create")
@@ -746,6 +784,40 @@ func (cr *JobController) Create(cx *goweb.Context) {
 	return
 }
 
+// admitJob runs the admission checks of a submission. With dryrun the report is the response,
+// otherwise the [Admission] policy decides whether failed checks reject the job.
+// It returns true if a response has been sent.
+func admitJob(cx *goweb.Context, u *user.User, dryrun bool, check func(*core.AdmissionReport)) (done bool) {
+	if !dryrun && conf.ADMISSION_POLICY == "off" {
+		return
+	}
+
+	token, _ := request.RetrieveToken(cx.Request)
+	report, err := core.NewAdmissionReport(token)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusInternalServerError)
+		done = true
+		return
+	}
+	check(report)
+
+	if dryrun {
+		cx.RespondWithData(report)
+		done = true
+		return
+	}
+	if report.Valid {
+		return
+	}
+
+	logger.Warning("(JobController/Create) job of user %s: %s", u.Uuid, report.Error())
+	if conf.ADMISSION_POLICY == "enforce" {
+		cx.Respond(report, http.StatusBadRequest, []string{report.Error()}, cx)
+		done = true
+	}
+	return
+}
+
 // GET: /job/{id}
 func (cr *JobController) Read(id string, cx *goweb.Context) {
 	LogRequest(cx.Request)
@@ -767,9 +839,22 @@ func (cr *JobController) Read(id string, cx *goweb.Context) {
 		}
 	}
 
+	// Gather query params
+	query := &Query{Li: cx.Request.URL.Query()}
+
+	if query.Has("archived") {
+		readArchivedJob(id, u, query, cx)
+		return
+	}
+
 	// Load job by id
 	job, err := core.GetJob(id)
 	if err != nil {
+		// archived jobs are read transparently
+		if _, aerr := core.GetArchivedJob(id); aerr == nil {
+			readArchivedJob(id, u, query, cx)
+			return
+		}
 		if err == mgo.ErrNotFound {
 			cx.RespondWithNotFound()
 		} else {
@@ -800,9 +885,6 @@ func (cr *JobController) Read(id string, cx *goweb.Context) {
 		return
 	}
 
-	// Gather query params
-	query := &Query{Li: cx.Request.URL.Query()}
-
 	if query.Has("perf") {
 		//Load job perf by id
 		perf, err := core.LoadJobPerf(id)
@@ -893,6 +975,51 @@ func (cr *JobController) Read(id string, cx *goweb.Context) {
 	return
 }
 
+// readArchivedJob GET /job/{id} of an archived job, only ?perf is supported
+func readArchivedJob(id string, u *user.User, query *Query, cx *goweb.Context) {
+	archived, err := core.GetArchivedJob(id)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			cx.RespondWithNotFound()
+		} else {
+			cx.RespondWithErrorMessage("archived job not found:"+id+" "+err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	// User must have read permissions on job or be job owner or be an admin
+	rights := archived.ACL.Check(u.Uuid)
+	prights := archived.ACL.Check("public")
+	if archived.ACL.Owner != u.Uuid && rights["read"] == false && u.Admin == false && prights["read"] == false {
+		cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+		return
+	}
+
+	if query.Has("perf") {
+		perf, err := archived.Perf()
+		if err != nil {
+			if err == mgo.ErrNotFound {
+				cx.RespondWithNotFound()
+			} else {
+				logger.Error("Err@ArchivedJob.Perf: " + id + ":" + err.Error())
+				cx.RespondWithErrorMessage("job perf stats not found:"+id, http.StatusBadRequest)
+			}
+			return
+		}
+		cx.RespondWithData(perf)
+		return
+	}
+
+	job, err := archived.Job()
+	if err != nil {
+		logger.Error("Err@ArchivedJob.Job: " + id + ":" + err.Error())
+		cx.RespondWithErrorMessage("could not read archived job:"+id+" "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cx.RespondWithData(job)
+	return
+}
+
 // GET: /job
 // To do:
 // - Iterate job queries
@@ -1408,9 +1535,32 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 	// Gather query params
 	query := &Query{Li: cx.Request.URL.Query()}
 
+	if query.Has("restore") { // to move an archived job back into the live collections
+		archived, err := core.GetArchivedJob(id)
+		if err != nil {
+			if err == mgo.ErrNotFound {
+				cx.RespondWithNotFound()
+			} else {
+				cx.RespondWithErrorMessage("archived job not found: "+id+" "+err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		rights := archived.ACL.Check(u.Uuid)
+		if archived.ACL.Owner != u.Uuid && rights["write"] == false && u.Admin == false {
+			cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+			return
+		}
+		if err := core.RestoreJob(id); err != nil {
+			cx.RespondWithErrorMessage("fail to restore job: "+id+" "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cx.RespondWithData("job restored: " + id)
+		return
+	}
+
 	// Load job by id
 	var job *core.Job
-	if query.Has("clientgroup") || query.Has("priority") || query.Has("pipeline") || query.Has("expiration") || query.Has("settoken") {
+	if query.Has("clientgroup") || query.Has("priority") || query.Has("pipeline") || query.Has("expiration") || query.Has("settoken") || query.Has("keep_intermediates") || query.Has("deadline") {
 		job, err = core.GetJob(id)
 		if err != nil {
 			if err == mgo.ErrNotFound {
@@ -1443,6 +1593,14 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 		return
 	}
 
+	if query.Has("archive") { // to move a completed job into the archive
+		if err := core.ArchiveJob(id); err != nil {
+			cx.RespondWithErrorMessage("fail to archive job: "+id+" "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cx.RespondWithData("job archived: " + id)
+		return
+	}
 	if query.Has("resume") { // to resume a suspended job
 		if err := core.QMgr.ResumeSuspendedJobByUser(id, u); err != nil {
 			cx.RespondWithErrorMessage("fail to resume job: "+id+" "+err.Error(), http.StatusBadRequest)
@@ -1549,6 +1707,44 @@ func (cr *JobController) Update(id string, cx *goweb.Context) {
 		cx.RespondWithData("expiration '" + job.Expiration.String() + "' set for job: " + id)
 		return
 	}
+	if query.Has("keep_intermediates") { // keep or clean up the intermediate nodes when the job completes
+		keep, err := strconv.ParseBool(query.Value("keep_intermediates"))
+		if err != nil {
+			cx.RespondWithErrorMessage("keep_intermediates value must be true or false", http.StatusBadRequest)
+			return
+		}
+		if err := job.SetKeepIntermediates(keep); err != nil {
+			cx.RespondWithErrorMessage("failed to set keep_intermediates for job: "+id+" "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cx.RespondWithData("keep_intermediates set to " + strconv.FormatBool(keep) + " for job: " + id)
+		return
+	}
+	if query.Has("deadline") { // change the deadline of the job, "none" removes it
+		value := query.Value("deadline")
+		if value == "" {
+			cx.RespondWithErrorMessage("lacking deadline value", http.StatusBadRequest)
+			return
+		}
+		var deadline time.Time
+		if value != "none" {
+			deadline, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				cx.RespondWithErrorMessage("deadline must be a RFC3339 timestamp or none", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := job.SetDeadline(deadline); err != nil {
+			cx.RespondWithErrorMessage("failed to set the deadline for job: "+id+" "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if deadline.IsZero() {
+			cx.RespondWithData("deadline removed for job: " + id)
+		} else {
+			cx.RespondWithData("deadline set to " + deadline.Format(time.RFC3339) + " for job: " + id)
+		}
+		return
+	}
 	if query.Has("settoken") { // set data token
 		token, err := request.RetrieveToken(cx.Request)
 		if err != nil {
@@ -1595,6 +1791,21 @@ func (cr *JobController) Delete(id string, cx *goweb.Context) {
 		full = true
 	}
 
+	// archived jobs are removed from the archive
+	if archived, aerr := core.GetArchivedJob(id); aerr == nil {
+		rights := archived.ACL.Check(u.Uuid)
+		if archived.ACL.Owner != u.Uuid && rights["delete"] == false && u.Admin == false {
+			cx.RespondWithErrorMessage(e.UnAuth, http.StatusUnauthorized)
+			return
+		}
+		if err = core.DeleteArchivedJob(id); err != nil {
+			cx.RespondWithErrorMessage("fail to delete job "+id+" "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cx.RespondWithData("job deleted: " + id)
+		return
+	}
+
 	if err = core.QMgr.DeleteJobByUser(id, u, full); err != nil {
 		if err == mgo.ErrNotFound {
 			cx.RespondWithNotFound()
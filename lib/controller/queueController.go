@@ -14,7 +14,7 @@ import (
 
 type QueueController struct{}
 
-var queueTypes = []string{"job", "task", "workall", "workqueue", "workcheckout", "worksuspend", "client"}
+var queueTypes = []string{"job", "task", "workall", "workqueue", "workcheckout", "worksuspend", "client", "readiness"}
 
 // OPTIONS: /queue
 func (cr *QueueController) Options(cx *goweb.Context) {
@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/request"
+	"github.com/MG-RAST/AWE/lib/user"
+)
+
+// route classes for rate limiting
+const (
+	RouteClassSubmit   = "submit"
+	RouteClassQuery    = "query"
+	RouteClassCheckout = "checkout"
+)
+
+// minSweepInterval bounds how often the buckets that are full again (keys that went quiet) are removed
+const minSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token bucket per key (user, client or address)
+type RateLimiter struct {
+	sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+	swept   time.Time // last removal of full buckets
+}
+
+// NewRateLimiter returns nil if perMinute is not positive, i.e. no limit
+func NewRateLimiter(perMinute int, burst int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: float64(perMinute) / 60.0, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes one token from the bucket of key. If the bucket is empty it returns the time until the next token.
+func (rl *RateLimiter) Allow(key string, now time.Time) (ok bool, retryAfter time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.sweep(now)
+
+	b, has := rl.buckets[key]
+	if !has {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+		return
+	}
+	retryAfter = time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	return
+}
+
+// sweep removes the buckets that are full again. It runs at most once per refill time of a bucket
+// (and minSweepInterval), a bucket removed is recreated full on the next request of its key.
+func (rl *RateLimiter) sweep(now time.Time) {
+	interval := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	if interval < minSweepInterval {
+		interval = minSweepInterval
+	}
+	if rl.swept.IsZero() {
+		rl.swept = now
+	}
+	if now.Sub(rl.swept) < interval {
+		return
+	}
+	rl.swept = now
+	for k, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, k)
+		}
+	}
+}
+
+var rateLimiters = map[string]*RateLimiter{}
+
+// InitRateLimits creates the rate limiters from the [Limits] config section
+func InitRateLimits() {
+	rateLimiters = map[string]*RateLimiter{
+		RouteClassSubmit:   NewRateLimiter(conf.RATE_LIMIT_SUBMIT, conf.RATE_LIMIT_SUBMIT_BURST),
+		RouteClassQuery:    NewRateLimiter(conf.RATE_LIMIT_QUERY, conf.RATE_LIMIT_QUERY_BURST),
+		RouteClassCheckout: NewRateLimiter(conf.RATE_LIMIT_CHECKOUT, conf.RATE_LIMIT_CHECKOUT_BURST),
+	}
+}
+
+// routeClass returns the rate limit class of a request, empty if it is not limited (e.g. heartbeats, workunit delivery)
+func routeClass(r *http.Request) string {
+	resource := strings.Split(strings.Trim(r.URL.Path, "/"), "/")[0]
+	switch r.Method {
+	case "POST":
+		if resource == "job" {
+			return RouteClassSubmit
+		}
+	case "GET":
+		switch resource {
+		case "work":
+			if r.URL.Path == "/work" && r.URL.Query().Get("client") != "" {
+				return RouteClassCheckout
+			}
+			if r.URL.Query().Get("client") != "" {
+				return "" // datatoken, privateenv
+			}
+			return RouteClassQuery
+		case "job", "workflow_instances", "client", "queue", "cgroup":
+			return RouteClassQuery
+		}
+	}
+	return ""
+}
+
+// rateLimitKey identifies who sends the request: the worker for checkouts authenticated as a clientgroup
+// (token or certificate), the authenticated user, or the remote address. The request returned carries
+// the authentication, so that the controller does not authenticate the user again.
+func rateLimitKey(r *http.Request, class string) (key string, authenticated *http.Request) {
+	authenticated = r
+	header := r.Header.Get("Authorization")
+	clientGroupAuth := strings.HasPrefix(strings.ToLower(header), "cg_token") || (r.TLS != nil && len(r.TLS.VerifiedChains) > 0)
+	if class == RouteClassCheckout && clientGroupAuth {
+		var cg *core.ClientGroup
+		var err error
+		authenticated, cg, err = request.WithClientGroupAuthentication(r)
+		if client := r.URL.Query().Get("client"); err == nil && cg != nil && client != "" {
+			key = "client:" + client
+			return
+		}
+	}
+	if header != "" && !strings.HasPrefix(strings.ToLower(header), "cg_token") {
+		var u *user.User
+		var err error
+		authenticated, u, err = request.WithAuthentication(r)
+		if err == nil && u != nil {
+			key = "user:" + u.Uuid
+			return
+		}
+	}
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	key = "ip:" + host
+	return
+}
+
+func respondWithLimit(w http.ResponseWriter, message string, statusCode int) {
+	data, _ := json.Marshal(StandardResponse{S: statusCode, D: nil, E: []string{message}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// RateLimitHandler enforces the [Limits] config on the API: request rates per route class and
+// the maximum size of job submissions. Rejected requests get 429 with Retry-After, or 413.
+func RateLimitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := routeClass(r)
+		if class == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if class == RouteClassSubmit && conf.MAX_JOB_UPLOAD_MB > 0 {
+			limit := int64(conf.MAX_JOB_UPLOAD_MB) * 1024 * 1024
+			if r.ContentLength > limit {
+				respondWithLimit(w, fmt.Sprintf("job submission too large, limit is %d MB", conf.MAX_JOB_UPLOAD_MB), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+
+		if rl := rateLimiters[class]; rl != nil {
+			var key string
+			key, r = rateLimitKey(r, class)
+			if ok, retryAfter := rl.Allow(key, time.Now()); !ok {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				logger.Info("(RateLimitHandler) %s rate limit exceeded by %s (%s %s), retry after %d seconds", class, key, r.Method, r.URL.Path, seconds)
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				respondWithLimit(w, fmt.Sprintf("%s rate limit exceeded, retry after %d seconds", class, seconds), http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	if NewRateLimiter(0, 5) != nil {
+		t.Errorf("expected no limiter for a rate of 0")
+	}
+	rl := NewRateLimiter(60, 2) // one token per second
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.Allow("a", now); !ok {
+			t.Fatalf("request %d within the burst rejected", i)
+		}
+	}
+	ok, retryAfter := rl.Allow("a", now)
+	if ok || retryAfter != time.Second {
+		t.Errorf("got %t %s, want false 1s", ok, retryAfter)
+	}
+	if ok, _ = rl.Allow("b", now); !ok {
+		t.Errorf("keys share a bucket")
+	}
+	if ok, _ = rl.Allow("a", now.Add(time.Second)); !ok {
+		t.Errorf("bucket not refilled")
+	}
+	ok, retryAfter = rl.Allow("a", now.Add(time.Second+time.Second/2))
+	if ok || retryAfter != time.Second/2 {
+		t.Errorf("got %t %s, want false 500ms", ok, retryAfter)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	rl := NewRateLimiter(60, 2)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	rl.Allow("quiet", now)
+	rl.Allow("busy", now)
+
+	// nothing is removed before the sweep interval
+	rl.Allow("busy", now.Add(minSweepInterval/2))
+	if len(rl.buckets) != 2 {
+		t.Errorf("swept too early: %d buckets", len(rl.buckets))
+	}
+	rl.Allow("busy", now.Add(minSweepInterval))
+	if _, has := rl.buckets["quiet"]; has || len(rl.buckets) != 1 {
+		t.Errorf("full bucket not removed: %v", rl.buckets)
+	}
+}
+
+func TestRouteClass(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		class  string
+	}{
+		{"POST", "/job", RouteClassSubmit},
+		{"POST", "/job/", RouteClassSubmit},
+		{"PUT", "/job/j1?suspend", ""},
+		{"GET", "/job?query&info.user=alice", RouteClassQuery},
+		{"GET", "/job/j1", RouteClassQuery},
+		{"GET", "/workflow_instances/w1", RouteClassQuery},
+		{"GET", "/client", RouteClassQuery},
+		{"GET", "/queue", RouteClassQuery},
+		{"GET", "/cgroup", RouteClassQuery},
+		{"GET", "/work?client=c1", RouteClassCheckout},
+		{"GET", "/work/w1?client=c1&datatoken", ""},
+		{"GET", "/work/w1?report=stdout", RouteClassQuery},
+		{"PUT", "/work/w1?client=c1&status=done", ""},
+		{"PUT", "/client/c1?heartbeat", ""},
+		{"GET", "/", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.url, nil)
+		if class := routeClass(r); class != test.class {
+			t.Errorf("%s %s: got %q, want %q", test.method, test.url, class, test.class)
+		}
+	}
+}
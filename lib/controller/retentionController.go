@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/core"
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/request"
+	"github.com/MG-RAST/AWE/lib/user"
+	"github.com/MG-RAST/golib/goweb"
+)
+
+type RetentionController struct{}
+
+// retentionAdmin authenticates the request, the retention policy is for admins only
+func retentionAdmin(cx *goweb.Context) (u *user.User, ok bool) {
+	u, err := request.Authenticate(cx.Request)
+	if err != nil && err.Error() != e.NoAuth {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if u == nil || u.Admin == false {
+		cx.RespondWithErrorMessage(e.NoAuth, http.StatusUnauthorized)
+		return
+	}
+	ok = true
+	return
+}
+
+// respondWithPreview responds with the jobs the policy expires now, or within the time given by ?within
+func respondWithPreview(policy *core.RetentionPolicy, query *Query, cx *goweb.Context) {
+	var within time.Duration
+	if query.Value("within") != "" {
+		var err error
+		within, err = core.ExpireDuration(query.Value("within"))
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	decisions, err := policy.Due(time.Now(), within)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cx.RespondWithData(decisions)
+	return
+}
+
+// OPTIONS: /retention
+func (cr *RetentionController) Options(cx *goweb.Context) {
+	LogRequest(cx.Request)
+	cx.RespondWithOK()
+	return
+}
+
+// POST: /retention?preview[&within=<expire>]
+// previews the rules in the request body (YAML or JSON), they are not stored
+func (cr *RetentionController) Create(cx *goweb.Context) {
+	LogRequest(cx.Request)
+	if _, ok := retentionAdmin(cx); !ok {
+		return
+	}
+
+	query := &Query{Li: cx.Request.URL.Query()}
+	if !query.Has("preview") {
+		cx.RespondWithErrorMessage("rules are configured with retention_rules in the server config, use ?preview to test rules", http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(cx.Request.Body)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy, err := core.ParseRetentionPolicy(body)
+	if err != nil {
+		cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+		return
+	}
+	respondWithPreview(policy, query, cx)
+	return
+}
+
+// GET: /retention/{id}
+func (cr *RetentionController) Read(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+	cx.RespondWithError(http.StatusNotImplemented)
+	return
+}
+
+// GET: /retention[?preview[&within=<expire>]]
+// returns the rules of the retention policy, or the jobs it expires
+func (cr *RetentionController) ReadMany(cx *goweb.Context) {
+	LogRequest(cx.Request)
+	if _, ok := retentionAdmin(cx); !ok {
+		return
+	}
+
+	query := &Query{Li: cx.Request.URL.Query()}
+	if query.Has("preview") {
+		respondWithPreview(core.Retention, query, cx)
+		return
+	}
+	cx.RespondWithData(core.Retention)
+	return
+}
+
+// PUT: /retention/{id}
+func (cr *RetentionController) Update(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+	cx.RespondWithError(http.StatusNotImplemented)
+	return
+}
+
+// PUT: /retention
+func (cr *RetentionController) UpdateMany(cx *goweb.Context) {
+	LogRequest(cx.Request)
+	cx.RespondWithError(http.StatusNotImplemented)
+	return
+}
+
+// DELETE: /retention/{id}
+func (cr *RetentionController) Delete(id string, cx *goweb.Context) {
+	LogRequest(cx.Request)
+	cx.RespondWithError(http.StatusNotImplemented)
+	return
+}
+
+// DELETE: /retention
+func (cr *RetentionController) DeleteMany(cx *goweb.Context) {
+	LogRequest(cx.Request)
+	cx.RespondWithError(http.StatusNotImplemented)
+	return
+}
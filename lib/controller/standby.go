@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// StandbyHandler a standby server only answers GET / (which shows the leader), all other requests
+// get 503 with the leader URL so that workers and clients switch to it
+func StandbyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if core.IsLeader() || (r.Method == "GET" && r.URL.Path == "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		leader := core.LeaderURL()
+		message := "this server is a standby, leader unknown"
+		if leader != "" {
+			w.Header().Set(core.LeaderHeader, leader)
+			message = "this server is a standby, leader is " + leader
+		}
+		logger.Debug(2, "(StandbyHandler) rejected %s %s", r.Method, r.URL.Path)
+		w.Header().Set("Retry-After", "5")
+		respondWithLimit(w, message, http.StatusServiceUnavailable)
+	})
+}
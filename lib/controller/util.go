@@ -137,6 +137,8 @@ type resource struct {
 	//GitCommitHash string    `json:"git_commit_hash"`
 	Uptime       string `json:"uptime"`
 	InstanceUUID string `json:"uuid"`
+	HARole       string `json:"ha_role,omitempty"`   // leader or standby
+	HALeader     string `json:"ha_leader,omitempty"` // URL of the leader
 }
 
 func ResourceDescription(cx *goweb.Context) {
@@ -181,6 +183,14 @@ func ResourceDescription(cx *goweb.Context) {
 		InstanceUUID: core.ServerUUID,
 	}
 
+	if conf.HA_ENABLED {
+		r.HARole = "standby"
+		if core.IsLeader() {
+			r.HARole = "leader"
+		}
+		r.HALeader = core.LeaderURL()
+	}
+
 	if core.Service == "server" {
 		r.R = []string{"job", "work", "client", "queue", "awf", "event"}
 	} else if core.Service == "proxy" {
@@ -368,6 +378,16 @@ func GetClientGroup(cx *goweb.Context) (cg *core.ClientGroup, done bool) {
 	done = false
 	cg, err := request.AuthenticateClientGroup(cx.Request)
 	if err != nil {
+		if err.Error() == e.ClientCertRequired {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusUnauthorized)
+			done = true
+			return
+		}
+		if err.Error() == e.ClientCertRevoked || err.Error() == e.ClientGroupIPDenied {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusForbidden)
+			done = true
+			return
+		}
 		if err.Error() == e.NoAuth || err.Error() == e.UnAuth || err.Error() == e.InvalidAuth {
 			if conf.CLIENT_AUTH_REQ == true {
 				cx.RespondWithError(http.StatusUnauthorized)
@@ -384,20 +404,31 @@ func GetClientGroup(cx *goweb.Context) (cg *core.ClientGroup, done bool) {
 	return
 }
 
+// DecodeBase64 decodes "base64:"-prefixed identifiers, see decodeBase64ID
 func DecodeBase64(cx *goweb.Context, id string) (return_id string) {
-	if strings.HasPrefix(id, "base64:") {
-		id_b64 := strings.TrimPrefix(id, "base64:")
-		id_bytes, err := base64.StdEncoding.DecodeString(id_b64)
-		if err != nil {
-			cx.RespondWithErrorMessage("error decoding base64 workunit identifier: "+id, http.StatusBadRequest)
-			return
-		}
-
-		return_id = string(id_bytes[:])
-	} else {
-		return_id = id
+	return_id, err := decodeBase64ID(id)
+	if err != nil {
+		cx.RespondWithErrorMessage("error decoding base64 workunit identifier: "+id, http.StatusBadRequest)
+		return ""
 	}
+	return
+}
 
+// decodeBase64ID decodes "base64:"-prefixed identifiers, other identifiers are returned as they are.
+// The URL-safe alphabet is accepted too, standard base64 may contain "/".
+func decodeBase64ID(id string) (decoded string, err error) {
+	if !strings.HasPrefix(id, "base64:") {
+		return id, nil
+	}
+	id_b64 := strings.TrimPrefix(id, "base64:")
+	id_bytes, err := base64.StdEncoding.DecodeString(id_b64)
+	if err != nil {
+		id_bytes, err = base64.URLEncoding.DecodeString(id_b64)
+	}
+	if err != nil {
+		return
+	}
+	decoded = string(id_bytes[:])
 	return
 }
 
@@ -0,0 +1,32 @@
+package controller
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecodeBase64ID(t *testing.T) {
+	// the standard encoding of id contains "+", the URL-safe encoding "-"
+	id := "job1_#main/step??>_0"
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain_id_0", "plain_id_0"},
+		{"base64:" + base64.StdEncoding.EncodeToString([]byte(id)), id},
+		{"base64:" + base64.URLEncoding.EncodeToString([]byte(id)), id},
+	}
+	for _, test := range tests {
+		got, err := decodeBase64ID(test.in)
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err.Error())
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.in, got, test.want)
+		}
+	}
+	if _, err := decodeBase64ID("base64:not base64"); err == nil {
+		t.Errorf("expected an error for an invalid identifier")
+	}
+}
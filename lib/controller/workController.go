@@ -149,6 +149,16 @@ func (cr *WorkController) Read(id string, cx *goweb.Context) {
 		return
 	}
 
+	if query.Value("report") == "usage" { //retrieve sampled resource usage
+		usage, err := core.QMgr.GetUsageReport(work_id)
+		if err != nil {
+			cx.RespondWithErrorMessage(err.Error(), http.StatusBadRequest)
+			return
+		}
+		cx.RespondWithData(usage)
+		return
+	}
+
 	if query.Has("report") { //retrieve report: stdout or stderr or worknotes
 		reportmsg, err := core.QMgr.GetReportMsg(work_id, query.Value("report"))
 		if err != nil {
@@ -359,7 +369,8 @@ func (cr *WorkController) ReadMany(cx *goweb.Context) {
 			strings.Contains(errStr, e.QueueSuspend) ||
 			strings.Contains(errStr, e.NoEligibleWorkunitFound) ||
 			strings.Contains(errStr, e.ClientNotFound) ||
-			strings.Contains(errStr, e.ClientSuspended) {
+			strings.Contains(errStr, e.ClientSuspended) ||
+			strings.Contains(errStr, e.ClientDraining) {
 
 			logger.Debug(3, err.Error())
 		} else {
@@ -456,6 +467,31 @@ func (cr *WorkController) Update(id string, cx *goweb.Context) {
 		return
 	}
 
+	if query.Has("checkpoint") { // a client reports a new checkpoint of the workunit
+		body, err := ioutil.ReadAll(cx.Request.Body)
+		if err != nil {
+			cx.RespondWithErrorMessage("could not read body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var checkpoint core.Checkpoint
+		err = json.Unmarshal(body, &checkpoint)
+		if err != nil {
+			cx.RespondWithErrorMessage("could not parse checkpoint: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = core.QMgr.UpdateCheckpoint(work_id, clientid, &checkpoint)
+		if err != nil {
+			if err.Error() == e.UnAuth {
+				cx.RespondWithErrorMessage("workunit is not checked out by this client", http.StatusUnauthorized)
+				return
+			}
+			cx.RespondWithErrorMessage("UpdateCheckpoint: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cx.RespondWithData("ok")
+		return
+	}
+
 	// old-style
 	var notice *core.Notice
 	if query.Has("status") && query.Has("client") { //notify execution result: "done" or "fail"
@@ -558,6 +594,9 @@ func (cr *WorkController) Update(id string, cx *goweb.Context) {
 				core.QMgr.SaveStdLog(work_id, log, files[log].Path)
 			}
 		}
+		if _, ok := files["usage"]; ok {
+			core.QMgr.SaveStdLog(work_id, "usage", files["usage"].Path)
+		}
 	}
 
 	core.QMgr.NotifyWorkStatus(*notice)
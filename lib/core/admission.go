@@ -0,0 +1,528 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	shock "github.com/MG-RAST/go-shock-client"
+)
+
+// admission check types
+const (
+	AdmissionInput       = "input"
+	AdmissionShockNode   = "shock_node"
+	AdmissionDockerImage = "docker_image"
+	AdmissionClientGroup = "clientgroup"
+	AdmissionStep        = "step"
+)
+
+// admission check status
+const (
+	AdmissionOK      = "ok"
+	AdmissionWarning = "warning"
+	AdmissionError   = "error"
+)
+
+// maxAdmissionDepth protects against subworkflows that include each other
+const maxAdmissionDepth = 20
+
+const dockerHubRegistry = "registry-1.docker.io"
+
+var registryTimeout = 10 * time.Second
+
+// AdmissionCheck is a single result of an admission check
+type AdmissionCheck struct {
+	Type    string `bson:"type" json:"type"`
+	Name    string `bson:"name" json:"name"`
+	Step    string `bson:"step,omitempty" json:"step,omitempty"`
+	Status  string `bson:"status" json:"status"`
+	Message string `bson:"message,omitempty" json:"message,omitempty"`
+}
+
+// AdmissionReport is returned by POST /job?dryrun and used by the admission policy
+type AdmissionReport struct {
+	Valid    bool             `bson:"valid" json:"valid"`
+	Errors   int              `bson:"errors" json:"errors"`
+	Warnings int              `bson:"warnings" json:"warnings"`
+	Checks   []AdmissionCheck `bson:"checks" json:"checks"`
+
+	datatoken string
+	clients   []*Client
+	resolved  map[string]bool // shock nodes and docker images that were checked already
+}
+
+// NewAdmissionReport collects a snapshot of the registered clients. The datatoken is used to read shock nodes.
+func NewAdmissionReport(datatoken string) (report *AdmissionReport, err error) {
+	report = &AdmissionReport{Valid: true, Checks: []AdmissionCheck{}, datatoken: datatoken, resolved: make(map[string]bool)}
+	report.clients, err = QMgr.GetClientMap().GetClients()
+	if err != nil {
+		err = fmt.Errorf("(NewAdmissionReport) GetClients returned: %s", err.Error())
+		return
+	}
+	return
+}
+
+func (report *AdmissionReport) add(checkType string, name string, step string, status string, message string) {
+	switch status {
+	case AdmissionError:
+		report.Errors++
+		report.Valid = false
+	case AdmissionWarning:
+		report.Warnings++
+	}
+	report.Checks = append(report.Checks, AdmissionCheck{Type: checkType, Name: name, Step: step, Status: status, Message: message})
+}
+
+// Error summarizes the failed checks, for logging and for the error of a rejected submission
+func (report *AdmissionReport) Error() string {
+	messages := []string{}
+	for _, c := range report.Checks {
+		if c.Status != AdmissionError {
+			continue
+		}
+		name := c.Type + " " + c.Name
+		if c.Step != "" {
+			name += " (step " + c.Step + ")"
+		}
+		messages = append(messages, name+": "+c.Message)
+	}
+	return fmt.Sprintf("admission check failed (%d errors): %s", report.Errors, strings.Join(messages, "; "))
+}
+
+// CheckCWLJob validates and type-checks a parsed CWL workflow against its input document, resolves
+// shock nodes, docker images and clientgroups and checks that registered clients could run each step.
+// Nothing is persisted, CWL2AWE is not called.
+func (report *AdmissionReport) CheckCWLJob(jobInput *cwl.Job_document, cwlWorkflow *cwl.Workflow, context *cwl.WorkflowContext, clientGroups string) {
+
+	_, err := CWLInputCheck(jobInput, cwlWorkflow, context)
+	if err != nil {
+		report.add(AdmissionInput, cwlWorkflow.ID, "", AdmissionError, err.Error())
+	} else {
+		report.add(AdmissionInput, cwlWorkflow.ID, "", AdmissionOK, "")
+	}
+
+	_, err = cwl.GetShockRequirement(cwlWorkflow.Requirements)
+	if err != nil {
+		report.add(AdmissionInput, "ShockRequirement", "", AdmissionError, "ShockRequirement missing, it is needed to store workflow outputs")
+	}
+
+	for _, named := range *jobInput {
+		report.checkCWLValue(named.Value)
+	}
+
+	report.checkClientGroups(clientGroups)
+
+	inherited := append(append([]cwl.Requirement{}, cwlWorkflow.Requirements...), cwlWorkflow.Hints...)
+	report.checkCWLSteps(cwlWorkflow, context, clientGroups, inherited, 0)
+	return
+}
+
+// checkCWLValue resolves the shock nodes of File inputs, also inside arrays
+func (report *AdmissionReport) checkCWLValue(value cwl.CWLType) {
+	switch v := value.(type) {
+	case *cwl.File:
+		// nodes of the local store (file urls) are not checked
+		if node, ok := shockNodeFromLocation(v.Location); ok && !strings.HasPrefix(node.Host, "file:") {
+			report.checkShockNode(node.Host, node.Node, "")
+		}
+	case *cwl.Array:
+		for _, element := range *v {
+			report.checkCWLValue(element)
+		}
+	}
+}
+
+func (report *AdmissionReport) checkShockNode(host string, node string, step string) {
+	name := host + "/node/" + node
+	if report.resolved[name] {
+		return
+	}
+	report.resolved[name] = true
+
+	sc := shock.ShockClient{Host: host, Token: report.datatoken}
+	if _, err := sc.GetNode(node); err != nil {
+		report.add(AdmissionShockNode, name, step, AdmissionError, fmt.Sprintf("shock node not found or not readable: %s", err.Error()))
+		return
+	}
+	report.add(AdmissionShockNode, name, step, AdmissionOK, "")
+}
+
+func (report *AdmissionReport) checkCWLSteps(workflow *cwl.Workflow, context *cwl.WorkflowContext, clientGroups string, inherited []cwl.Requirement, depth int) {
+	if depth > maxAdmissionDepth {
+		report.add(AdmissionStep, workflow.ID, "", AdmissionError, "subworkflows are nested too deep")
+		return
+	}
+
+	for i := range workflow.Steps {
+		step := &workflow.Steps[i]
+		process, _, err := step.GetProcess(context)
+		if err != nil {
+			report.add(AdmissionStep, step.ID, step.ID, AdmissionError, err.Error())
+			continue
+		}
+
+		var requirements []cwl.Requirement
+		switch p := process.(type) {
+		case *cwl.Workflow:
+			subInherited := append(append(append([]cwl.Requirement{}, p.Requirements...), p.Hints...), inherited...)
+			report.checkCWLSteps(p, context, clientGroups, subInherited, depth+1)
+			continue
+		case *cwl.CommandLineTool:
+			requirements = append(append([]cwl.Requirement{}, p.Requirements...), p.Hints...)
+		case *cwl.ExpressionTool:
+			requirements = append(append([]cwl.Requirement{}, p.Requirements...), p.Hints...)
+		}
+		requirements = append(requirements, inherited...)
+
+		// the first requirement wins, tool requirements come before inherited ones
+		cores := 0
+		hasDocker := false
+		for _, r := range requirements {
+			switch req := r.(type) {
+			case *cwl.DockerRequirement:
+				if !hasDocker && req.DockerPull != "" {
+					report.checkDockerImage(req.DockerPull, false, step.ID)
+				}
+				hasDocker = true
+			case *cwl.ResourceRequirement:
+				if cores == 0 {
+					cores, _ = cwl.ResourceNumber(req.CoresMin)
+				}
+			}
+		}
+
+		// CWL workunits are created without command name, see filterWorkByClient
+		report.checkStepClients(step.ID, clientGroups, "", cores)
+	}
+}
+
+// CheckAWEJob checks a parsed (not yet saved) AWE job document
+func (report *AdmissionReport) CheckAWEJob(job *Job) {
+	if job.Info == nil {
+		report.add(AdmissionInput, job.ID, "", AdmissionError, "job info missing")
+		return
+	}
+	clientGroups := job.Info.ClientGroups
+	if len(job.Tasks) == 0 {
+		report.add(AdmissionInput, job.Info.Name, "", AdmissionError, "task list empty")
+		return
+	}
+	report.add(AdmissionInput, job.Info.Name, "", AdmissionOK, "")
+
+	report.checkClientGroups(clientGroups)
+	taskGroups := map[string]bool{clientGroups: true}
+
+	for _, task := range job.Tasks {
+		name := task.TaskName
+		if name == "" {
+			name = task.ID
+		}
+		for _, io := range task.Inputs {
+			if io.Origin != "" || io.Node == "" || io.Node == "-" {
+				continue // output of another task
+			}
+			host := io.Host
+			if host == "" {
+				host = job.ShockHost
+			}
+			report.checkShockNode(host, io.Node, name)
+		}
+
+		groups := clientGroups
+		if task.ClientGroups != "" {
+			groups = task.ClientGroups
+			if !taskGroups[groups] {
+				taskGroups[groups] = true
+				report.checkClientGroups(groups)
+			}
+		}
+
+		cmdName := ""
+		if task.Cmd != nil {
+			cmdName = task.Cmd.Name
+			if task.Cmd.DockerPull != "" {
+				report.checkDockerImage(task.Cmd.DockerPull, false, name)
+			} else if task.Cmd.Dockerimage != "" {
+				report.checkDockerImage(task.Cmd.Dockerimage, true, name)
+			}
+		}
+		report.checkStepClients(name, groups, cmdName, 0)
+	}
+	return
+}
+
+// checkClientGroups resolves a comma separated list of clientgroup names
+func (report *AdmissionReport) checkClientGroups(clientGroups string) {
+	if clientGroups == "" {
+		return
+	}
+	for _, name := range strings.Split(clientGroups, ",") {
+		clients := 0
+		for _, client := range report.clients {
+			if client.Group == name {
+				clients++
+			}
+		}
+
+		cg, err := LoadClientGroupByName(name)
+		if err == nil {
+			if !cg.Expiration.IsZero() && cg.Expiration.Before(time.Now()) {
+				report.add(AdmissionClientGroup, name, "", AdmissionError, "clientgroup expired on "+cg.Expiration.Format(time.RFC3339))
+				continue
+			}
+			report.add(AdmissionClientGroup, name, "", AdmissionOK, fmt.Sprintf("%d clients registered", clients))
+			continue
+		}
+
+		switch {
+		case clients > 0:
+			report.add(AdmissionClientGroup, name, "", AdmissionOK, fmt.Sprintf("not a stored clientgroup, %d clients registered", clients))
+		case conf.CLIENT_AUTH_REQ:
+			report.add(AdmissionClientGroup, name, "", AdmissionError, "clientgroup does not exist")
+		default:
+			report.add(AdmissionClientGroup, name, "", AdmissionWarning, "clientgroup does not exist and no client of this group is registered")
+		}
+	}
+}
+
+// checkStepClients checks that at least one registered client could check out the workunits of a step
+func (report *AdmissionReport) checkStepClients(step string, clientGroups string, cmdName string, cores int) {
+	inGroup := 0
+	withApp := 0
+	available := 0
+	for _, client := range report.clients {
+		groupOK, appOK := clientAcceptsWork(client, clientGroups, cmdName)
+		if !groupOK {
+			continue
+		}
+		inGroup++
+		if !appOK {
+			continue
+		}
+		withApp++
+		if cores > 0 && client.CPUs > 0 && client.CPUs < cores {
+			continue
+		}
+		if client.Online && !client.Suspended {
+			available++
+		}
+	}
+
+	if available > 0 {
+		report.add(AdmissionStep, step, step, AdmissionOK, fmt.Sprintf("%d clients available", available))
+		return
+	}
+
+	var message string
+	switch {
+	case inGroup == 0 && clientGroups != "":
+		message = "no registered client in clientgroup(s) " + clientGroups
+	case inGroup == 0:
+		message = "no registered client"
+	case withApp == 0:
+		message = fmt.Sprintf("no registered client supports app \"%s\"", cmdName)
+	case cores > 0:
+		message = fmt.Sprintf("no online client with %d cores", cores)
+	default:
+		message = "no online client, matching clients are offline or suspended"
+	}
+	status := AdmissionWarning
+	if conf.ADMISSION_REQUIRE_CLIENTS {
+		status = AdmissionError
+	}
+	report.add(AdmissionStep, step, step, status, message)
+}
+
+// checkDockerImage looks up an image in its registry, or in the shock image repository (image_url) if fromShock
+func (report *AdmissionReport) checkDockerImage(image string, fromShock bool, step string) {
+	if !conf.ADMISSION_DOCKER_LOOKUP {
+		return
+	}
+	if report.resolved["docker:"+image] {
+		return
+	}
+	report.resolved["docker:"+image] = true
+
+	var found bool
+	var err error
+	if fromShock {
+		found, err = lookupShockDockerImage(image, report.datatoken)
+	} else {
+		found, err = lookupRegistryImage(image)
+	}
+	switch {
+	case err != nil:
+		report.add(AdmissionDockerImage, image, step, AdmissionWarning, "image could not be looked up: "+err.Error())
+	case !found:
+		report.add(AdmissionDockerImage, image, step, AdmissionError, "image not found")
+	default:
+		report.add(AdmissionDockerImage, image, step, AdmissionOK, "")
+	}
+}
+
+// lookupShockDockerImage uses the same queries as the worker (findDockerImageInShock)
+func lookupShockDockerImage(image string, datatoken string) (found bool, err error) {
+	if conf.SHOCK_DOCKER_IMAGE_REPOSITORY == "" {
+		err = fmt.Errorf("image_url is not configured")
+		return
+	}
+	repository := image
+	tag := "latest"
+	if i := strings.LastIndex(image, ":"); i > 0 {
+		repository = image[:i]
+		tag = image[i+1:]
+	}
+	q := url.Values{"type": {"dockerimage"}, "name": {image}}
+	if tag == "latest" {
+		q = url.Values{"type": {"dockerimage"}, "repository": {repository}}
+	}
+
+	sc := shock.ShockClient{Host: conf.SHOCK_DOCKER_IMAGE_REPOSITORY, Token: datatoken}
+	response, err := sc.Query(q)
+	if err != nil {
+		return
+	}
+	found = response != nil && len(response.Data) > 0
+	return
+}
+
+// parseDockerImage splits an image reference into registry, repository and tag or digest
+func parseDockerImage(image string) (registry string, repository string, reference string) {
+	registry = dockerHubRegistry
+	repository = image
+	if i := strings.Index(repository, "/"); i > 0 {
+		first := repository[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			registry = first
+			repository = repository[i+1:]
+		}
+	}
+	if registry == "docker.io" || registry == "index.docker.io" {
+		registry = dockerHubRegistry
+	}
+
+	reference = "latest"
+	if i := strings.Index(repository, "@"); i > 0 {
+		reference = repository[i+1:]
+		repository = repository[:i]
+	} else if i := strings.LastIndex(repository, ":"); i > 0 {
+		reference = repository[i+1:]
+		repository = repository[:i]
+	}
+
+	if registry == dockerHubRegistry && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return
+}
+
+var authParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// lookupRegistryImage asks a docker registry (API v2) for the manifest of an image, with an anonymous token if needed
+func lookupRegistryImage(image string) (found bool, err error) {
+	registry, repository, reference := parseDockerImage(image)
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repository, reference)
+	client := &http.Client{Timeout: registryTimeout}
+
+	head := func(token string) (res *http.Response, err error) {
+		req, err := http.NewRequest("HEAD", manifestURL, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.oci.image.manifest.v1+json, application/vnd.oci.image.index.v1+json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err = client.Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		return
+	}
+
+	res, err := head("")
+	if err != nil {
+		return
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("Www-Authenticate")
+		if !strings.HasPrefix(strings.ToLower(challenge), "bearer") {
+			err = fmt.Errorf("registry %s requires authentication", registry)
+			return
+		}
+		params := map[string]string{}
+		for _, match := range authParamRegexp.FindAllStringSubmatch(challenge, -1) {
+			params[match[1]] = match[2]
+		}
+		if params["scope"] == "" {
+			params["scope"] = "repository:" + repository + ":pull"
+		}
+		var token string
+		token, err = registryToken(client, params["realm"], params["service"], params["scope"])
+		if err != nil {
+			return
+		}
+		res, err = head(token)
+		if err != nil {
+			return
+		}
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		found = true
+	case http.StatusNotFound, http.StatusUnauthorized, http.StatusForbidden:
+		// docker hub answers 401 for repositories that do not exist
+		found = false
+	default:
+		err = fmt.Errorf("registry %s returned %s", registry, res.Status)
+	}
+	return
+}
+
+func registryToken(client *http.Client, realm string, service string, scope string) (token string, err error) {
+	if realm == "" {
+		err = fmt.Errorf("registry did not send a token realm")
+		return
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return
+	}
+	q := tokenURL.Query()
+	if service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", scope)
+	tokenURL.RawQuery = q.Encode()
+
+	res, err := client.Get(tokenURL.String())
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("token request to %s returned %s", realm, res.Status)
+		return
+	}
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&tokenResponse); err != nil {
+		return
+	}
+	token = tokenResponse.Token
+	if token == "" {
+		token = tokenResponse.AccessToken
+	}
+	return
+}
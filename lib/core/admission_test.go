@@ -0,0 +1,29 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestParseDockerImage(t *testing.T) {
+	tests := []struct {
+		image      string
+		registry   string
+		repository string
+		reference  string
+	}{
+		{"ubuntu", dockerHubRegistry, "library/ubuntu", "latest"},
+		{"ubuntu:18.04", dockerHubRegistry, "library/ubuntu", "18.04"},
+		{"mgrast/awe-worker:v1", dockerHubRegistry, "mgrast/awe-worker", "v1"},
+		{"docker.io/mgrast/awe-worker", dockerHubRegistry, "mgrast/awe-worker", "latest"},
+		{"quay.io/biocontainers/samtools:1.9--h8571acd_11", "quay.io", "biocontainers/samtools", "1.9--h8571acd_11"},
+		{"localhost:5000/tool", "localhost:5000", "tool", "latest"},
+		{"localhost/tool:dev", "localhost", "tool", "dev"},
+		{"ubuntu@sha256:abc", dockerHubRegistry, "library/ubuntu", "sha256:abc"},
+	}
+	for _, test := range tests {
+		registry, repository, reference := parseDockerImage(test.image)
+		if registry != test.registry || repository != test.repository || reference != test.reference {
+			t.Errorf("%s: got %s %s %s, want %s %s %s", test.image, registry, repository, reference, test.registry, test.repository, test.reference)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/acl"
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	// Archiver _
+	Archiver = NewJobArchiver()
+)
+
+// ArchivedJob a completed job moved out of the live collections. The job document, its workflow
+// instances (with their tasks) and its perf record are kept bson encoded and gzip compressed in
+// Data. The fields needed to find the job and to check permissions stay uncompressed.
+type ArchivedJob struct {
+	ID         string    `bson:"id" json:"id"`
+	ACL        acl.Acl   `bson:"acl" json:"-"`
+	Info       *Info     `bson:"info" json:"info"`
+	State      string    `bson:"state" json:"state"`
+	Expiration time.Time `bson:"expiration" json:"expiration"`
+	Archived   time.Time `bson:"archived" json:"archived"`
+	Size       int       `bson:"size" json:"size"` // uncompressed size of Data
+	Data       []byte    `bson:"data" json:"-"`
+}
+
+// archiveContent the documents of an archived job
+type archiveContent struct {
+	Job               bson.M   `bson:"job"`
+	WorkflowInstances []bson.M `bson:"workflow_instances"`
+	Perf              bson.M   `bson:"perf,omitempty"`
+}
+
+// withoutObjectID the database ids are not archived, documents get new ones when restored
+func withoutObjectID(doc bson.M) bson.M {
+	delete(doc, "_id")
+	return doc
+}
+
+// ArchiveJob moves a completed (or suspended, failed or deleted) job with its workflow instances and perf record into the archive
+func ArchiveJob(id string) (err error) {
+	// IsJobRegistered is not used, it loads the job and counts completed jobs as suspended
+	if QMgr != nil && QMgr.isActJob(id) {
+		err = fmt.Errorf("(ArchiveJob) job %s is active", id)
+		return
+	}
+
+	content := archiveContent{}
+	content.Job, err = Repo.Jobs.GetDocument(id)
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) Repo.Jobs.GetDocument returned: %s", err.Error())
+		return
+	}
+	withoutObjectID(content.Job)
+
+	archived := &ArchivedJob{}
+	raw, err := bson.Marshal(content.Job)
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) bson.Marshal returned: %s", err.Error())
+		return
+	}
+	err = bson.Unmarshal(raw, archived) // id, acl, info, state and expiration of the job
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) bson.Unmarshal returned: %s", err.Error())
+		return
+	}
+	if !contains(JOB_STATS_FINAL, archived.State) {
+		err = fmt.Errorf("(ArchiveJob) job %s is not in a final state, state: %s", id, archived.State)
+		return
+	}
+
+	content.WorkflowInstances, err = Repo.WorkflowInstances.GetDocuments([]string{id}, nil)
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) Repo.WorkflowInstances.GetDocuments returned: %s", err.Error())
+		return
+	}
+	for _, wi := range content.WorkflowInstances {
+		withoutObjectID(wi)
+	}
+
+	content.Perf, err = Repo.Perf.GetDocument(id)
+	if err != nil {
+		if err != db.ErrNotFound {
+			err = fmt.Errorf("(ArchiveJob) Repo.Perf.GetDocument returned: %s", err.Error())
+			return
+		}
+		err = nil
+	}
+	withoutObjectID(content.Perf)
+
+	raw, err = bson.Marshal(&content)
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) bson.Marshal returned: %s", err.Error())
+		return
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err = zw.Write(raw)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) gzip returned: %s", err.Error())
+		return
+	}
+	archived.Archived = time.Now()
+	archived.Size = len(raw)
+	archived.Data = compressed.Bytes()
+	if len(archived.Data) >= DocumentMaxByte {
+		err = fmt.Errorf("(ArchiveJob) compressed job %s is greater than limit of %d bytes", id, DocumentMaxByte)
+		return
+	}
+
+	// the archive document is complete before the live documents are removed
+	err = Repo.Archive.Save(archived)
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) Repo.Archive.Save returned: %s", err.Error())
+		return
+	}
+	err = Repo.WorkflowInstances.DeleteJob(id)
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) Repo.WorkflowInstances.DeleteJob returned: %s", err.Error())
+		return
+	}
+	err = Repo.Perf.Delete(id)
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) Repo.Perf.Delete returned: %s", err.Error())
+		return
+	}
+	err = Repo.Jobs.Delete(id)
+	if err != nil {
+		err = fmt.Errorf("(ArchiveJob) Repo.Jobs.Delete returned: %s", err.Error())
+		return
+	}
+
+	forgetJob(id, archived.State)
+	logger.Event(event.JOB_ARCHIVED, "jobid="+id)
+	return
+}
+
+// forgetJob removes a job that is no longer in the database from memory, suspended jobs also from the queue
+func forgetJob(id string, state string) {
+	if QMgr != nil && (state == JOB_STAT_SUSPEND || state == JOB_STAT_FAILED_PERMANENT) {
+		if err := QMgr.unregisterJob(id); err != nil {
+			logger.Error("(forgetJob) unregisterJob %s returned: %s", id, err.Error())
+		}
+		return
+	}
+	if JM != nil {
+		JM.Delete(id, true)
+	}
+	if GlobalWorkflowInstanceMap != nil {
+		GlobalWorkflowInstanceMap.DeleteJob(id)
+	}
+}
+
+// GetArchivedJob returns the archive document of a job, db.ErrNotFound if the job is not archived
+func GetArchivedJob(id string) (archived *ArchivedJob, err error) {
+	return Repo.Archive.Get(id)
+}
+
+// content decompresses the documents of the archived job
+func (archived *ArchivedJob) content() (content *archiveContent, err error) {
+	zr, err := gzip.NewReader(bytes.NewReader(archived.Data))
+	if err != nil {
+		err = fmt.Errorf("(ArchivedJob/content) gzip.NewReader returned: %s", err.Error())
+		return
+	}
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		err = fmt.Errorf("(ArchivedJob/content) reading job %s returned: %s", archived.ID, err.Error())
+		return
+	}
+	content = &archiveContent{}
+	err = bson.Unmarshal(raw, content)
+	if err != nil {
+		err = fmt.Errorf("(ArchivedJob/content) bson.Unmarshal returned: %s", err.Error())
+	}
+	return
+}
+
+// Job decodes the archived job, it is not added to the job map
+func (archived *ArchivedJob) Job() (job *Job, err error) {
+	content, err := archived.content()
+	if err != nil {
+		return
+	}
+	raw, err := bson.Marshal(content.Job)
+	if err != nil {
+		err = fmt.Errorf("(ArchivedJob/Job) bson.Marshal returned: %s", err.Error())
+		return
+	}
+	job = NewJob()
+	err = bson.Unmarshal(raw, job)
+	if err != nil {
+		job = nil
+		err = fmt.Errorf("(ArchivedJob/Job) bson.Unmarshal returned: %s", err.Error())
+		return
+	}
+	job.Archived = true
+	return
+}
+
+// Perf decodes the archived perf record, db.ErrNotFound if the job had none
+func (archived *ArchivedJob) Perf() (perf *JobPerf, err error) {
+	content, err := archived.content()
+	if err != nil {
+		return
+	}
+	if content.Perf == nil {
+		err = db.ErrNotFound
+		return
+	}
+	raw, err := bson.Marshal(content.Perf)
+	if err != nil {
+		err = fmt.Errorf("(ArchivedJob/Perf) bson.Marshal returned: %s", err.Error())
+		return
+	}
+	perf = new(JobPerf)
+	err = bson.Unmarshal(raw, perf)
+	if err != nil {
+		perf = nil
+		err = fmt.Errorf("(ArchivedJob/Perf) bson.Unmarshal returned: %s", err.Error())
+	}
+	return
+}
+
+// RestoreJob moves an archived job back into the live collections
+func RestoreJob(id string) (err error) {
+	archived, err := GetArchivedJob(id)
+	if err != nil {
+		err = fmt.Errorf("(RestoreJob) GetArchivedJob returned: %s", err.Error())
+		return
+	}
+	content, err := archived.content()
+	if err != nil {
+		return
+	}
+
+	// upserts, so that an interrupted restore can be repeated
+	for _, wi := range content.WorkflowInstances {
+		err = Repo.WorkflowInstances.RestoreDocument(wi)
+		if err != nil {
+			err = fmt.Errorf("(RestoreJob) Repo.WorkflowInstances.RestoreDocument returned: %s", err.Error())
+			return
+		}
+	}
+	if content.Perf != nil {
+		err = Repo.Perf.RestoreDocument(content.Perf)
+		if err != nil {
+			err = fmt.Errorf("(RestoreJob) Repo.Perf.RestoreDocument returned: %s", err.Error())
+			return
+		}
+	}
+	err = Repo.Jobs.RestoreDocument(content.Job)
+	if err != nil {
+		err = fmt.Errorf("(RestoreJob) Repo.Jobs.RestoreDocument returned: %s", err.Error())
+		return
+	}
+
+	err = Repo.Archive.Delete(id)
+	if err != nil {
+		err = fmt.Errorf("(RestoreJob) Repo.Archive.Delete returned: %s", err.Error())
+		return
+	}
+	logger.Event(event.JOB_RESTORED, "jobid="+id)
+	return
+}
+
+// DeleteArchivedJob removes an archived job and its job directory
+func DeleteArchivedJob(id string) (err error) {
+	if err = Repo.Archive.Delete(id); err != nil {
+		return
+	}
+	var path string
+	if path, err = getPathByJobID(id); err != nil {
+		return
+	}
+	if err = os.RemoveAll(path); err != nil {
+		return
+	}
+	logger.Event(event.JOB_FULL_DELETE, "jobid="+id)
+	return
+}
+
+// JobArchiver moves completed jobs into the archive, conf.ARCHIVE_AFTER after their completion
+type JobArchiver struct{}
+
+// NewJobArchiver _
+func NewJobArchiver() *JobArchiver {
+	return &JobArchiver{}
+}
+
+// Handle _
+func (ja *JobArchiver) Handle() {
+	after, err := ExpireDuration(conf.ARCHIVE_AFTER)
+	if err != nil {
+		logger.Error("(JobArchiver) %s", err.Error())
+		return
+	}
+	waitDuration := time.Duration(conf.ARCHIVE_WAIT) * time.Minute
+	for {
+		time.Sleep(waitDuration)
+		ids, err := ja.getJobs(time.Now().Add(-after))
+		if err != nil {
+			logger.Error("(JobArchiver) getJobs returned: %s", err.Error())
+			continue
+		}
+		archived := 0
+		for _, id := range ids {
+			if err := ArchiveJob(id); err != nil {
+				logger.Error("(JobArchiver) job %s: %s", id, err.Error())
+				continue
+			}
+			archived++
+		}
+		if archived > 0 {
+			logger.Info("(JobArchiver) archived %d jobs", archived)
+		}
+	}
+}
+
+// getJobs the ids of the completed jobs that completed before the given time
+func (ja *JobArchiver) getJobs(completedBefore time.Time) (ids []string, err error) {
+	return Repo.Jobs.CompletedBefore(completedBefore, conf.ARCHIVE_BATCH)
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const archiveTestJob = "7a3e9c1d-4b2f-4e8a-8d6c-2f1b0a9e8d7c"
+
+// archiveTestDocuments the documents of the job, without database ids
+func archiveTestDocuments(t *testing.T, id string) (job bson.M, wis []bson.M, perf bson.M) {
+	job, err := Repo.Jobs.GetDocument(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wis, err = Repo.WorkflowInstances.GetDocuments([]string{id}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, wi := range wis {
+		withoutObjectID(wi)
+	}
+	if perf, err = Repo.Perf.GetDocument(id); err != nil {
+		t.Fatal(err)
+	}
+	return withoutObjectID(job), wis, withoutObjectID(perf)
+}
+
+func TestArchiveRestoreJob(t *testing.T) {
+	job := NewJob()
+	job.ID = archiveTestJob
+	job.State = JOB_STAT_COMPLETED
+	job.IsCWL = true
+	job.Info.Name = "archived"
+	job.Info.CompletedTime = time.Now().Add(-time.Hour).Round(time.Millisecond)
+	job.Expiration = time.Now().Add(time.Hour).Round(time.Millisecond)
+	if err := Repo.Jobs.Save(job); err != nil {
+		t.Fatal(err)
+	}
+	wi := bson.M{"id": "wi-" + archiveTestJob, "job_id": archiveTestJob, "state": "completed", "remain_steps": 0}
+	if err := Repo.WorkflowInstances.RestoreDocument(wi); err != nil {
+		t.Fatal(err)
+	}
+	if err := Repo.Perf.Save(&JobPerf{Id: archiveTestJob, Queued: 1, Start: 2, End: 3}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		Repo.Jobs.Delete(archiveTestJob)
+		Repo.WorkflowInstances.DeleteJob(archiveTestJob)
+		Repo.Perf.Delete(archiveTestJob)
+		Repo.Archive.Delete(archiveTestJob)
+	}()
+	jobDoc, wiDocs, perfDoc := archiveTestDocuments(t, archiveTestJob)
+
+	if err := ArchiveJob(archiveTestJob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Repo.Jobs.Get(archiveTestJob); err != db.ErrNotFound {
+		t.Errorf("the archived job is still live: %v", err)
+	}
+	if docs, _ := Repo.WorkflowInstances.GetDocuments([]string{archiveTestJob}, nil); len(docs) != 0 {
+		t.Errorf("the workflow instances of the archived job are still live")
+	}
+	if _, err := Repo.Perf.GetDocument(archiveTestJob); err != db.ErrNotFound {
+		t.Errorf("the perf record of the archived job is still live: %v", err)
+	}
+
+	archived, err := GetArchivedJob(archiveTestJob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived.State != JOB_STAT_COMPLETED || !archived.Expiration.Equal(job.Expiration) || archived.Info.Name != "archived" {
+		t.Errorf("archive document: state %s, expiration %v, info %+v", archived.State, archived.Expiration, archived.Info)
+	}
+	archivedJob, err := archived.Job()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archivedJob.ID != archiveTestJob || !archivedJob.Archived || !archivedJob.Info.CompletedTime.Equal(job.Info.CompletedTime) {
+		t.Errorf("archived job: id %s, archived %t, completed %v", archivedJob.ID, archivedJob.Archived, archivedJob.Info.CompletedTime)
+	}
+	perf, err := archived.Perf()
+	if err != nil || perf.End != 3 {
+		t.Errorf("archived perf: %+v, %v", perf, err)
+	}
+
+	if err = RestoreJob(archiveTestJob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetArchivedJob(archiveTestJob); err != db.ErrNotFound {
+		t.Errorf("the restored job is still archived: %v", err)
+	}
+	restoredJob, restoredWIs, restoredPerf := archiveTestDocuments(t, archiveTestJob)
+	if !reflect.DeepEqual(restoredJob, jobDoc) {
+		t.Errorf("restored job:\n%v\nwant\n%v", restoredJob, jobDoc)
+	}
+	if !reflect.DeepEqual(restoredWIs, wiDocs) {
+		t.Errorf("restored workflow instances:\n%v\nwant\n%v", restoredWIs, wiDocs)
+	}
+	if !reflect.DeepEqual(restoredPerf, perfDoc) {
+		t.Errorf("restored perf:\n%v\nwant\n%v", restoredPerf, perfDoc)
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	e "github.com/MG-RAST/AWE/lib/errors"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// Checkpoint latest checkpoint of a workunit: a tar.gz of its checkpoint directory in Shock.
+// A requeued workunit restores it into its work directory before the command is run again.
+type Checkpoint struct {
+	Host      string `bson:"host" json:"host" mapstructure:"host"`
+	Node      string `bson:"node" json:"node" mapstructure:"node"`
+	Directory string `bson:"directory" json:"directory" mapstructure:"directory"` // relative to the work directory
+	Size      int64  `bson:"size" json:"size" mapstructure:"size"`
+	Time      int64  `bson:"time" json:"time" mapstructure:"time"`       // unix time of the upload
+	Count     int    `bson:"count" json:"count" mapstructure:"count"`    // checkpoints uploaded for this workunit
+	Client    string `bson:"client" json:"client" mapstructure:"client"` // client that uploaded it
+}
+
+// UpdateCheckpoint a client reports a new checkpoint of a workunit it has checked out, the node of the
+// previous checkpoint is deleted
+func (qm *ServerMgr) UpdateCheckpoint(workID Workunit_Unique_Identifier, clientID string, checkpoint *Checkpoint) (err error) {
+	work, ok, err := qm.workQueue.Get(workID)
+	if err != nil {
+		return
+	}
+	if !ok {
+		workStr, _ := workID.String()
+		err = fmt.Errorf("(UpdateCheckpoint) workunit %s not found", workStr)
+		return
+	}
+	if work.Client != clientID {
+		err = errors.New(e.UnAuth)
+		return
+	}
+	if checkpoint.Host == "" || checkpoint.Node == "" {
+		err = fmt.Errorf("(UpdateCheckpoint) checkpoint host or node missing")
+		return
+	}
+
+	work.checkpointLock.Lock()
+	previous := work.Checkpoint
+	checkpoint.Client = clientID
+	checkpoint.Count = 1
+	if previous != nil {
+		checkpoint.Count = previous.Count + 1
+	}
+	work.Checkpoint = checkpoint
+	work.checkpointLock.Unlock()
+	dbSaveCheckout(work)
+
+	if previous != nil && previous.Node != checkpoint.Node {
+		go deleteCheckpointNode(workID.JobId, previous)
+	}
+	return
+}
+
+// DeleteCheckpoint deletes the checkpoint of a finished workunit
+func (work *Workunit) DeleteCheckpoint() {
+	work.checkpointLock.Lock()
+	checkpoint := work.Checkpoint
+	work.Checkpoint = nil
+	work.checkpointLock.Unlock()
+	if checkpoint == nil {
+		return
+	}
+	go deleteCheckpointNode(work.JobId, checkpoint)
+}
+
+// GetCheckpoint _
+func (work *Workunit) GetCheckpoint() *Checkpoint {
+	work.checkpointLock.Lock()
+	defer work.checkpointLock.Unlock()
+	return work.Checkpoint
+}
+
+// keepCheckpoint sets the checkpoint persisted before a server restart, unless there is a newer one
+func (work *Workunit) keepCheckpoint(checkpoint *Checkpoint) {
+	work.checkpointLock.Lock()
+	defer work.checkpointLock.Unlock()
+	if work.Checkpoint == nil {
+		work.Checkpoint = checkpoint
+	}
+}
+
+func deleteCheckpointNode(jobID string, checkpoint *Checkpoint) {
+	token := ""
+	job, err := GetJob(jobID)
+	if err == nil {
+		token = job.GetDataToken()
+	}
+	err = deleteNode(checkpoint.Host, checkpoint.Node, token)
+	if err != nil {
+		logger.Warning("(deleteCheckpointNode) could not delete checkpoint node %s: %s", checkpoint.Node, err.Error())
+	}
+}
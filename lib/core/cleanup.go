@@ -0,0 +1,390 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/golib/go-uuid/uuid"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// cleanupRetryWait the wait before a failed node deletion is retried, multiplied by the number of the retry
+var cleanupRetryWait = 5 * time.Second
+
+// CleanupReport the data store nodes of a job deleted by the cleanup
+type CleanupReport struct {
+	Time   time.Time `bson:"time" json:"time"`
+	Nodes  int       `bson:"nodes" json:"nodes"`   // deleted nodes
+	Bytes  int64     `bson:"bytes" json:"bytes"`   // reclaimed bytes, nodes of unknown size are not counted
+	Kept   int       `bson:"kept" json:"kept"`     // nodes kept because other live jobs read them
+	Failed []string  `bson:"failed" json:"failed"` // nodes that could not be deleted
+}
+
+// shockNode a node referenced by a job
+type shockNode struct {
+	Host string
+	Node string
+}
+
+// jobNodes the output nodes of a job with their size (0 if unknown)
+type jobNodes struct {
+	Final        map[shockNode]int64
+	Intermediate map[shockNode]int64
+	Token        string // data token of the job
+	Keep         bool   // the job keeps its intermediate nodes
+	Cleaned      bool   // the job has a cleanup report
+}
+
+// shockNodeFromLocation the node of a Shock or local store url, e.g. http://shock.example.org/node/<id>?download
+// or file:///data/store/node/<id>
+func shockNodeFromLocation(location string) (node shockNode, ok bool) {
+	locationURL, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+	if locationURL.Scheme != "http" && locationURL.Scheme != "https" && locationURL.Scheme != "file" {
+		return
+	}
+	pos := strings.LastIndex(locationURL.Path, "/node/")
+	if pos < 0 {
+		return
+	}
+	id := strings.Trim(locationURL.Path[pos+len("/node/"):], "/")
+	if uuid.Parse(id) == nil {
+		return
+	}
+	node = shockNode{Host: locationURL.Scheme + "://" + locationURL.Host + locationURL.Path[:pos], Node: id}
+	ok = true
+	return
+}
+
+// ioNode the node of an input or output of an AWE task document
+func ioNode(io bson.M) (node shockNode, ok bool) {
+	host, _ := io["host"].(string)
+	id, _ := io["node"].(string)
+	if host != "" && id != "" && id != "-" {
+		return shockNode{Host: host, Node: id}, true
+	}
+	location, _ := io["url"].(string)
+	return shockNodeFromLocation(location)
+}
+
+// asDocument a sub document read from any backend
+func asDocument(v interface{}) (doc bson.M, ok bool) {
+	switch t := v.(type) {
+	case bson.M:
+		return t, true
+	case map[string]interface{}:
+		return bson.M(t), true
+	}
+	return
+}
+
+// asDocuments the documents of an array read from any backend
+func asDocuments(v interface{}) (docs []bson.M) {
+	array, _ := v.([]interface{})
+	for _, e := range array {
+		if doc, ok := asDocument(e); ok {
+			docs = append(docs, doc)
+		}
+	}
+	return
+}
+
+// asSize a size read from any backend
+func asSize(v interface{}) int64 {
+	switch t := v.(type) {
+	case int:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case int64:
+		return t
+	case float64:
+		return int64(t)
+	}
+	return 0
+}
+
+// addNode adds a node, keeping the known size
+func addNode(nodes map[shockNode]int64, node shockNode, size int64) {
+	if size > nodes[node] {
+		nodes[node] = size
+		return
+	}
+	if _, ok := nodes[node]; !ok {
+		nodes[node] = size
+	}
+}
+
+// cwlNodes adds the nodes of the CWL files (location fields) in v
+func cwlNodes(v interface{}, nodes map[shockNode]int64) {
+	if doc, ok := asDocument(v); ok {
+		if location, isString := doc["location"].(string); isString {
+			if node, ok := shockNodeFromLocation(location); ok {
+				addNode(nodes, node, asSize(doc["size"]))
+			}
+		}
+		for key, value := range doc {
+			if key != "location" {
+				cwlNodes(value, nodes)
+			}
+		}
+		return
+	}
+	if array, ok := v.([]interface{}); ok {
+		for _, e := range array {
+			cwlNodes(e, nodes)
+		}
+	}
+}
+
+// jobOutputNodes the output nodes of a job, split into final and intermediate outputs. Outputs of AWE
+// tasks are intermediate only if they are flagged temporary or delete (like inputs flagged with delete),
+// outputs of CWL steps are intermediate unless they are outputs of the workflow.
+func jobOutputNodes(id string) (nodes *jobNodes, err error) {
+	job, err := Repo.Jobs.GetDocument(id)
+	if err != nil {
+		err = fmt.Errorf("(jobOutputNodes) Repo.Jobs.GetDocument returned: %s", err.Error())
+		return
+	}
+	nodes = &jobNodes{Final: map[shockNode]int64{}, Intermediate: map[shockNode]int64{}}
+	if info, ok := asDocument(job["info"]); ok {
+		nodes.Token, _ = info["datatoken"].(string)
+		nodes.Keep, _ = info["keep_intermediates"].(bool)
+	}
+	_, nodes.Cleaned = asDocument(job["cleanup"])
+
+	// AWE tasks
+	for _, task := range asDocuments(job["tasks"]) {
+		for _, io := range asDocuments(task["inputs"]) {
+			if node, ok := ioNode(io); ok {
+				if deleted, _ := io["delete"].(bool); deleted {
+					addNode(nodes.Intermediate, node, asSize(io["size"]))
+				}
+			}
+		}
+	}
+	for _, task := range asDocuments(job["tasks"]) {
+		for _, io := range asDocuments(task["outputs"]) {
+			node, ok := ioNode(io)
+			if !ok {
+				continue
+			}
+			temporary, _ := io["temporary"].(bool)
+			deleted, _ := io["delete"].(bool)
+			if temporary || deleted {
+				addNode(nodes.Intermediate, node, asSize(io["size"]))
+			} else {
+				addNode(nodes.Final, node, asSize(io["size"]))
+			}
+		}
+	}
+
+	// CWL steps
+	wis, err := Repo.WorkflowInstances.GetDocuments([]string{id}, nil)
+	if err != nil {
+		err = fmt.Errorf("(jobOutputNodes) Repo.WorkflowInstances.GetDocuments returned: %s", err.Error())
+		return
+	}
+	root, _ := job["root"].(string)
+	steps := map[shockNode]int64{}
+	for _, wi := range wis {
+		if wiID, _ := wi["id"].(string); wiID != "" && wiID == root {
+			cwlNodes(wi["outputs"], nodes.Final)
+		}
+		for _, task := range asDocuments(wi["tasks"]) {
+			cwlNodes(task["stepOutput"], steps)
+		}
+	}
+	for node, size := range steps {
+		if _, ok := nodes.Final[node]; !ok {
+			addNode(nodes.Intermediate, node, size)
+		}
+	}
+	return
+}
+
+// jobStatsLive states of jobs that may still read nodes, a suspended job can be resumed
+var jobStatsLive = []string{JOB_STAT_INIT, JOB_STAT_QUEUING, JOB_STAT_QUEUED, JOB_STAT_INPROGRESS, JOB_STAT_SUSPEND}
+
+// liveNodes the nodes of candidates that jobs other than job id read which are not in a final state
+// (a suspended job counts as live). Only AWE jobs with one of the nodes as input and CWL jobs are read,
+// with the fields that reference nodes.
+func liveNodes(id string, candidates map[shockNode]int64) (live map[shockNode]bool, err error) {
+	live = map[shockNode]bool{}
+	nodeIDs := []string{}
+	for node := range candidates {
+		nodeIDs = append(nodeIDs, node.Node)
+	}
+	jobs, err := Repo.Jobs.ReadingJobs(id, jobStatsLive, nodeIDs)
+	if err != nil {
+		err = fmt.Errorf("(liveNodes) Repo.Jobs.ReadingJobs returned: %s", err.Error())
+		return
+	}
+	referenced := map[shockNode]int64{}
+	cwlJobs := []string{}
+	for _, job := range jobs {
+		for _, task := range asDocuments(job["tasks"]) {
+			for _, io := range asDocuments(task["inputs"]) {
+				if node, ok := ioNode(io); ok {
+					addNode(referenced, node, 0)
+				}
+			}
+		}
+		if isCWL, _ := job["is_cwl"].(bool); isCWL {
+			cwlNodes(job["cwl_job_input"], referenced)
+			if jobID, _ := job["id"].(string); jobID != "" {
+				cwlJobs = append(cwlJobs, jobID)
+			}
+		}
+	}
+	if len(cwlJobs) > 0 {
+		var wis []bson.M
+		wis, err = Repo.WorkflowInstances.GetDocuments(cwlJobs, []string{"inputs", "outputs", "tasks.stepOutput", "tasks.processOutput"})
+		if err != nil {
+			err = fmt.Errorf("(liveNodes) Repo.WorkflowInstances.GetDocuments returned: %s", err.Error())
+			return
+		}
+		for _, wi := range wis {
+			cwlNodes(wi, referenced)
+		}
+	}
+	for node := range candidates {
+		if _, ok := referenced[node]; ok {
+			live[node] = true
+		}
+	}
+	return
+}
+
+// deleteNodeWithRetry deletes a node, failed deletions are retried cleanup_retries times
+func deleteNodeWithRetry(node shockNode, token string) (err error) {
+	for retry := 0; ; retry++ {
+		err = deleteNode(node.Host, node.Node, token)
+		if err == nil || retry >= conf.CLEANUP_RETRIES {
+			return
+		}
+		time.Sleep(time.Duration(retry+1) * cleanupRetryWait)
+	}
+}
+
+// nodeCleanup the nodes of a job a cleanup deletes
+type nodeCleanup struct {
+	job    string
+	token  string
+	remove map[shockNode]int64
+	live   map[shockNode]bool // kept, other live jobs read them
+}
+
+// planCleanup reads the intermediate or all output nodes of a job and which of them live jobs read.
+// Intermediate nodes of jobs with keep_intermediates are kept, plan is nil then. With onDelete a job
+// that was cleaned up already (when it completed) is skipped as well.
+func planCleanup(id string, intermediateOnly bool, onDelete bool) (plan *nodeCleanup, err error) {
+	nodes, err := jobOutputNodes(id)
+	if err != nil {
+		return
+	}
+	if (intermediateOnly && nodes.Keep) || (onDelete && nodes.Cleaned) {
+		return
+	}
+	plan = &nodeCleanup{job: id, token: nodes.Token, remove: nodes.Intermediate, live: map[shockNode]bool{}}
+	if !intermediateOnly {
+		for node, size := range nodes.Final {
+			addNode(plan.remove, node, size)
+		}
+	}
+	if len(plan.remove) > 0 {
+		if plan.live, err = liveNodes(id, plan.remove); err != nil {
+			plan = nil
+		}
+	}
+	return
+}
+
+// run deletes the nodes that no live job reads, failed deletions are retried
+func (plan *nodeCleanup) run() (report *CleanupReport) {
+	report = &CleanupReport{Time: time.Now(), Failed: []string{}}
+	for node, size := range plan.remove {
+		if plan.live[node] {
+			report.Kept++
+			continue
+		}
+		if err := deleteNodeWithRetry(node, plan.token); err != nil {
+			logger.Warning("(cleanupJobNodes) job %s: failed to delete node %s/node/%s: %s", plan.job, node.Host, node.Node, err.Error())
+			report.Failed = append(report.Failed, node.Host+"/node/"+node.Node)
+			continue
+		}
+		report.Nodes++
+		report.Bytes += size
+	}
+	return
+}
+
+// cleanupJobNodes deletes the intermediate or all output nodes of a job, nodes that live jobs read are
+// kept. Intermediate nodes of jobs with keep_intermediates are kept, report is nil then.
+func cleanupJobNodes(id string, intermediateOnly bool) (report *CleanupReport, err error) {
+	plan, err := planCleanup(id, intermediateOnly, false)
+	if err != nil || plan == nil {
+		return
+	}
+	report = plan.run()
+	return
+}
+
+// cleanupIntermediates deletes the intermediate nodes of a job and saves the report in the job document.
+// With onDelete a job that was cleaned up already (when it completed) is skipped.
+func cleanupIntermediates(id string, onDelete bool) {
+	if !conf.CLEANUP_INTERMEDIATES {
+		return
+	}
+	plan, err := planCleanup(id, true, onDelete)
+	if err != nil {
+		logger.Error("(cleanupIntermediates) job %s: %s", id, err.Error())
+		return
+	}
+	if plan == nil {
+		return
+	}
+	report := plan.run()
+	if err = dbUpdateJobFields(id, bson.M{"cleanup": report}); err != nil {
+		logger.Error("(cleanupIntermediates) job %s: dbUpdateJobFields returned: %s", id, err.Error())
+		return
+	}
+	if job, ok, _ := JM.Get(id, true); ok {
+		if err = job.LockNamed("cleanupIntermediates"); err == nil {
+			job.Cleanup = report
+			job.Unlock()
+		}
+	}
+	logger.Info("(cleanupIntermediates) job %s: deleted %d nodes (%d bytes), kept %d, failed %d", id, report.Nodes, report.Bytes, report.Kept, len(report.Failed))
+	return
+}
+
+// deleteJobAndIntermediates removes a job from the database and, with cleanup_intermediates, deletes its
+// intermediate nodes in the background, as failed deletions are retried with waits. The nodes are read
+// before the job is removed.
+func deleteJobAndIntermediates(id string) (err error) {
+	var plan *nodeCleanup
+	if conf.CLEANUP_INTERMEDIATES {
+		plan, err = planCleanup(id, true, true)
+		if err != nil {
+			// the job is deleted anyway
+			logger.Error("(deleteJobAndIntermediates) job %s: %s", id, err.Error())
+		}
+	}
+	if err = deleteJobByID(id); err != nil {
+		return
+	}
+	if plan != nil {
+		go func() {
+			report := plan.run()
+			logger.Info("(deleteJobAndIntermediates) job %s: deleted %d nodes (%d bytes), kept %d, failed %d", id, report.Nodes, report.Bytes, report.Kept, len(report.Failed))
+		}()
+	}
+	return
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	cleanupTestJob    = "3c9b2e4f-6a1d-4f7e-8b2c-9d0e1f2a3b4c"
+	cleanupTestReader = "8f7e6d5c-4b3a-4c2d-9e1f-0a1b2c3d4e5f"
+	cleanupTestHost   = "http://shock.example.org"
+	nodeTemporary     = "11111111-2222-4333-8444-555555555555"
+	nodeFinal         = "22222222-3333-4444-8555-666666666666"
+	nodeRead          = "33333333-4444-4555-8666-777777777777"
+)
+
+func TestShockNodeFromLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		host     string
+		node     string
+		ok       bool
+	}{
+		{"http://shock.example.org/node/" + nodeFinal + "?download", "http://shock.example.org", nodeFinal, true},
+		{"https://example.org/shock/api/node/" + nodeFinal + "/", "https://example.org/shock/api", nodeFinal, true},
+		{"file:///data/store/node/" + nodeFinal, "file:///data/store", nodeFinal, true},
+		{"https://example.org/node/abc", "", "", false},
+		{"https://example.org/node/" + nodeFinal + "/acl", "", "", false},
+		{"https://example.org/node/", "", "", false},
+		{"https://example.org/file.txt", "", "", false},
+		{"/data/node/" + nodeFinal, "", "", false},
+	}
+	for _, test := range tests {
+		node, ok := shockNodeFromLocation(test.location)
+		if ok != test.ok || (ok && (node.Host != test.host || node.Node != test.node)) {
+			t.Errorf("%s: got %s %s %t, want %s %s %t", test.location, node.Host, node.Node, ok, test.host, test.node, test.ok)
+		}
+	}
+}
+
+// saveCleanupTestJobs a completed job with a temporary, a final and a temporary output another (running) job reads
+func saveCleanupTestJobs(t *testing.T, keep bool) {
+	output := func(node string, temporary bool) bson.M {
+		return bson.M{"host": cleanupTestHost, "node": node, "temporary": temporary, "size": 10}
+	}
+	jobs := []bson.M{
+		{"id": cleanupTestJob, "state": JOB_STAT_COMPLETED, "info": bson.M{"datatoken": "token", "keep_intermediates": keep},
+			"tasks": []bson.M{{"outputs": []bson.M{output(nodeTemporary, true), output(nodeFinal, false), output(nodeRead, true)}}}},
+		{"id": cleanupTestReader, "state": JOB_STAT_INPROGRESS,
+			"tasks": []bson.M{{"inputs": []bson.M{{"host": cleanupTestHost, "node": nodeRead}}}}},
+	}
+	for _, job := range jobs {
+		if err := Repo.Jobs.RestoreDocument(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func deleteCleanupTestJobs() {
+	Repo.Jobs.Delete(cleanupTestJob)
+	Repo.Jobs.Delete(cleanupTestReader)
+}
+
+func TestPlanCleanup(t *testing.T) {
+	defer deleteCleanupTestJobs()
+	tests := []struct {
+		keep             bool
+		intermediateOnly bool
+		remove           []string // nil if there is no plan
+		live             []string
+	}{
+		{false, true, []string{nodeTemporary, nodeRead}, []string{nodeRead}},
+		{false, false, []string{nodeTemporary, nodeFinal, nodeRead}, []string{nodeRead}},
+		{true, true, nil, nil},
+		{true, false, []string{nodeTemporary, nodeFinal, nodeRead}, []string{nodeRead}},
+	}
+	for _, test := range tests {
+		saveCleanupTestJobs(t, test.keep)
+		plan, err := planCleanup(cleanupTestJob, test.intermediateOnly, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.remove == nil {
+			if plan != nil {
+				t.Errorf("keep %t, intermediate only %t: got plan %v, want none", test.keep, test.intermediateOnly, plan.remove)
+			}
+			continue
+		}
+		if plan == nil {
+			t.Errorf("keep %t, intermediate only %t: got no plan", test.keep, test.intermediateOnly)
+			continue
+		}
+		remove, live := map[shockNode]int64{}, map[shockNode]bool{}
+		for _, node := range test.remove {
+			remove[shockNode{Host: cleanupTestHost, Node: node}] = 10
+		}
+		for _, node := range test.live {
+			live[shockNode{Host: cleanupTestHost, Node: node}] = true
+		}
+		if !reflect.DeepEqual(plan.remove, remove) || !reflect.DeepEqual(plan.live, live) {
+			t.Errorf("keep %t, intermediate only %t: got remove %v live %v, want %v %v", test.keep, test.intermediateOnly, plan.remove, plan.live, remove, live)
+		}
+	}
+}
+
+func TestNodeCleanupRun(t *testing.T) {
+	defer conftest.Save(&conf.CLEANUP_RETRIES, &cleanupRetryWait, &deleteNode)()
+	conf.CLEANUP_RETRIES = 1
+	cleanupRetryWait = time.Millisecond
+	attempts := map[string]int{}
+	deleteNode = func(host string, node string, token string) error {
+		attempts[node]++
+		if node == nodeFinal {
+			return errors.New("unavailable")
+		}
+		if node == nodeTemporary && attempts[node] == 1 {
+			return errors.New("timeout") // succeeds when retried
+		}
+		return nil
+	}
+	plan := &nodeCleanup{job: cleanupTestJob, token: "token", remove: map[shockNode]int64{
+		{Host: cleanupTestHost, Node: nodeTemporary}: 10,
+		{Host: cleanupTestHost, Node: nodeFinal}:     20,
+		{Host: cleanupTestHost, Node: nodeRead}:      30,
+	}, live: map[shockNode]bool{{Host: cleanupTestHost, Node: nodeRead}: true}}
+
+	report := plan.run()
+	if report.Nodes != 1 || report.Bytes != 10 || report.Kept != 1 || !reflect.DeepEqual(report.Failed, []string{cleanupTestHost + "/node/" + nodeFinal}) {
+		t.Errorf("got report %+v", report)
+	}
+	if want := map[string]int{nodeTemporary: 2, nodeFinal: 2}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("got attempts %v, want %v", attempts, want)
+	}
+}
+
+func TestDeleteJobAndIntermediates(t *testing.T) {
+	defer conftest.Save(&conf.CLEANUP_INTERMEDIATES, &deleteNode)()
+	defer deleteCleanupTestJobs()
+	conf.CLEANUP_INTERMEDIATES = true
+	release := make(chan bool)
+	deleted := make(chan string, 3)
+	deleteNode = func(host string, node string, token string) error {
+		<-release
+		deleted <- node
+		return nil
+	}
+	saveCleanupTestJobs(t, false)
+
+	// returns while the deletion of the intermediate nodes waits
+	if err := deleteJobAndIntermediates(cleanupTestJob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Repo.Jobs.Get(cleanupTestJob); err == nil {
+		t.Errorf("the job was not deleted")
+	}
+	close(release)
+	select {
+	case node := <-deleted:
+		if node != nodeTemporary {
+			t.Errorf("deleted node %s, want %s", node, nodeTemporary)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the intermediate node was not deleted")
+	}
+	select {
+	case node := <-deleted:
+		t.Errorf("deleted node %s, only %s is an intermediate no other job reads", node, nodeTemporary)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -36,9 +36,24 @@ type Client struct {
 	Online          bool          `bson:"online" json:"online"`                 // a state
 	Suspended       bool          `bson:"suspended" json:"suspended"`           // a state
 	SuspendReason   string        `bson:"suspend_reason" json:"suspend_reason"` // a state
-	Status          string        `bson:"Status" json:"Status"`                 // 0) unhealthy 1) suspended? 2) busy ? 3) online (call is idle) 4) offline
-	AssignedWork    *WorkunitList `bson:"assigned_work" json:"assigned_work"`   // this is for exporting into json
-}
+	Draining        bool          `bson:"draining" json:"draining"`             // a state, finish current work then exit
+	Maintenance     bool          `bson:"maintenance" json:"maintenance"`       // draining client that stays registered
+	DrainDeadline   time.Time     `bson:"drain_deadline" json:"drain_deadline"` // unfinished work is requeued after this time
+	DrainExpired    bool          `bson:"drain_expired" json:"drain_expired"`
+	Preempted       []string      `bson:"preempted" json:"preempted"`         // workunits requeued by preemption that the worker still has to stop
+	Status          string        `bson:"Status" json:"Status"`               // 0) unhealthy 1) suspended? 2) busy ? 3) online (call is idle) 4) offline
+	AssignedWork    *WorkunitList `bson:"assigned_work" json:"assigned_work"` // this is for exporting into json
+}
+
+// container runtimes reported by workers
+const (
+	ContainerRuntimeDocker      = "docker"
+	ContainerRuntimePodman      = "podman"
+	ContainerRuntimeApptainer   = "apptainer"
+	ContainerRuntimeSingularity = "singularity"
+	ContainerRuntimeKubernetes  = "kubernetes"
+	ContainerRuntimeNone        = "none" // the worker has no container runtime
+)
 
 // WorkerRuntime worker info that does not change at runtime
 type WorkerRuntime struct {
@@ -54,6 +69,9 @@ type WorkerRuntime struct {
 	HostIP   string   `bson:"host_ip" json:"host_ip"` // Host can be physical machine or VM, whatever is helpful for management
 	CPUs     int      `bson:"cores" json:"cores"`
 	Apps     []string `bson:"apps" json:"apps"`
+	// container runtimes installed on the worker, e.g. docker, podman or apptainer, ContainerRuntimeNone if
+	// there is none. Empty for workers that do not report them.
+	ContainerRuntimes []string `bson:"container_runtimes" json:"container_runtimes"`
 	//GitCommitHash string   `bson:"git_commit_hash" json:"git_commit_hash"`
 	Version string `bson:"version" json:"version"`
 }
@@ -65,6 +83,16 @@ type WorkerState struct {
 	Busy         bool          `bson:"busy" json:"busy"` // a state
 	CurrentWork  *WorkunitList `bson:"current_work" json:"current_work"`
 	ServerUUID   string        `bson:"server_uuid,omitempty" json:"server_uuid,omitempty" ` //this is what the worker thinks its server is / mostly for debugging
+	Disk         *DiskUsage    `bson:"disk,omitempty" json:"disk,omitempty"`
+}
+
+// DiskUsage disk space of a worker in bytes, measured periodically
+type DiskUsage struct {
+	Free    int64 `bson:"free" json:"free"`       // available in the work path
+	Work    int64 `bson:"work" json:"work"`       // used by workunit directories
+	Predata int64 `bson:"predata" json:"predata"` // used by predata
+	Cache   int64 `bson:"cache" json:"cache"`     // used by the container image cache
+	Time    int64 `bson:"time" json:"time"`       // unix time of the measurement
 }
 
 // RegistrationResponse _
@@ -233,6 +261,34 @@ func (client *Client) ContainsSkipWorkNolock(workid string) (c bool) {
 	return
 }
 
+// WasPreempted the workunit was requeued by preemption while the client ran it
+func (client *Client) WasPreempted(workid string) (c bool) {
+	readLock, err := client.RLockNamed("WasPreempted")
+	if err != nil {
+		return
+	}
+	defer client.RUnlockNamed(readLock)
+	c = contains(client.Preempted, workid)
+	return
+}
+
+// ClearPreempted forgets the preemption of a workunit the client checked out again
+func (client *Client) ClearPreempted(workid string) (err error) {
+	err = client.LockNamed("ClearPreempted")
+	if err != nil {
+		return
+	}
+	defer client.Unlock()
+	preempted := []string{}
+	for _, id := range client.Preempted {
+		if id != workid {
+			preempted = append(preempted, id)
+		}
+	}
+	client.Preempted = preempted
+	return
+}
+
 // GetID _
 func (client *Client) GetID(doReadLock bool) (s string, err error) {
 	if doReadLock {
@@ -311,6 +367,14 @@ func (client *Client) UpdateStatus(writeLock bool) (err error) {
 		return
 	}
 
+	if client.Draining {
+		client.Status = "draining"
+		if client.Maintenance {
+			client.Status = "maintenance"
+		}
+		return
+	}
+
 	if client.Busy {
 		client.Status = "busy"
 		return
@@ -353,9 +417,23 @@ func (client *Client) Suspend(reason string, writeLock bool) (err error) {
 	return client.SetSuspended(true, reason, writeLock)
 }
 
-// Resume _
+// Resume ends suspension, drain and maintenance
 func (client *Client) Resume(writeLock bool) (err error) {
-	return client.SetSuspended(false, "", writeLock)
+	if writeLock {
+		err = client.LockNamed("Resume")
+		if err != nil {
+			return
+		}
+		defer client.Unlock()
+	}
+
+	client.Draining = false
+	client.Maintenance = false
+	client.DrainExpired = false
+	client.DrainDeadline = time.Time{}
+	err = client.SetSuspended(false, "", false)
+	client.UpdateStatus(false)
+	return
 }
 
 // GetSuspended _
@@ -372,6 +450,69 @@ func (client *Client) GetSuspended(doReadLock bool) (s bool, err error) {
 	return
 }
 
+// Drain the client gets no new work and exits after its current work, unless maintenance is set.
+// If deadline > 0 the unfinished work is requeued when the deadline expires.
+func (client *Client) Drain(maintenance bool, deadline time.Duration, writeLock bool) (err error) {
+	if writeLock {
+		err = client.LockNamed("Drain")
+		if err != nil {
+			return
+		}
+		defer client.Unlock()
+	}
+
+	client.Draining = true
+	client.Maintenance = maintenance
+	client.DrainExpired = false
+	client.DrainDeadline = time.Time{}
+	if deadline > 0 {
+		client.DrainDeadline = time.Now().Add(deadline)
+	}
+	client.UpdateStatus(false)
+	return
+}
+
+// SetDisk sets the disk usage reported with the heartbeat
+func (client *Client) SetDisk(usage *DiskUsage, writeLock bool) (err error) {
+	if writeLock {
+		err = client.LockNamed("SetDisk")
+		if err != nil {
+			return
+		}
+		defer client.Unlock()
+	}
+	client.Disk = usage
+	return
+}
+
+// GetWorkerState returns a copy of the WorkerState, e.g. to send it with the heartbeat
+func (client *Client) GetWorkerState(doReadLock bool) (state WorkerState, err error) {
+	if doReadLock {
+		readLock, xerr := client.RLockNamed("GetWorkerState")
+		if xerr != nil {
+			err = xerr
+			return
+		}
+		defer client.RUnlockNamed(readLock)
+	}
+	state = client.WorkerState
+	return
+}
+
+// GetDraining _
+func (client *Client) GetDraining(doReadLock bool) (d bool, err error) {
+	if doReadLock {
+		readLock, xerr := client.RLockNamed("GetDraining")
+		if xerr != nil {
+			err = xerr
+			return
+		}
+		defer client.RUnlockNamed(readLock)
+	}
+	d = client.Draining
+	return
+}
+
 // SetOnline _
 func (client *Client) SetOnline(o bool, writeLock bool) (err error) {
 	if writeLock {
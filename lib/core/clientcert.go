@@ -0,0 +1,291 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+)
+
+// clientGroupURIScheme is used in the URI SAN of worker certificates: awe://clientgroup/<name>
+const clientGroupURIScheme = "awe"
+const clientGroupURIHost = "clientgroup"
+
+// ClientCertificate records a worker certificate that was issued for, or revoked in, a clientgroup
+type ClientCertificate struct {
+	Serial     string    `bson:"serial" json:"serial"`
+	CommonName string    `bson:"common_name" json:"common_name"`
+	CreatedOn  time.Time `bson:"created_on" json:"created_on"`
+	Expiration time.Time `bson:"expiration" json:"expiration"`
+	Revoked    bool      `bson:"revoked" json:"revoked"`
+	RevokedOn  time.Time `bson:"revoked_on" json:"revoked_on"`
+}
+
+// IssuedCertificate is returned once, when a certificate is issued. Key is empty if the caller sent a CSR.
+type IssuedCertificate struct {
+	ClientCertificate
+	Certificate string `json:"certificate"`
+	Key         string `json:"key,omitempty"`
+}
+
+// CertificateSerial returns the serial number in the form it is stored in the clientgroup
+func CertificateSerial(cert *x509.Certificate) string {
+	return fmt.Sprintf("%x", cert.SerialNumber)
+}
+
+// ClientGroupNameFromCertificate maps a verified worker certificate to a clientgroup name.
+// The URI SAN awe://clientgroup/<name> takes precedence over the subject OU.
+func ClientGroupNameFromCertificate(cert *x509.Certificate) (name string) {
+	for _, u := range cert.URIs {
+		if u.Scheme == clientGroupURIScheme && u.Host == clientGroupURIHost {
+			name = strings.Trim(u.Path, "/")
+			if name != "" {
+				return
+			}
+		}
+	}
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		name = cert.Subject.OrganizationalUnit[0]
+	}
+	return
+}
+
+// ClientCAPool returns the pool used by the server to verify worker certificates
+func ClientCAPool() (pool *x509.CertPool, err error) {
+	caPEM, err := ioutil.ReadFile(conf.SSL_CLIENT_CA_FILE)
+	if err != nil {
+		err = fmt.Errorf("(ClientCAPool) could not read client_ca: %s", err.Error())
+		return
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		err = fmt.Errorf("(ClientCAPool) no certificate found in %s", conf.SSL_CLIENT_CA_FILE)
+		return
+	}
+	return
+}
+
+// loadClientCA reads the CA certificate and key used to issue worker certificates
+func loadClientCA() (caCert *x509.Certificate, caKey crypto.Signer, err error) {
+	if conf.SSL_CLIENT_CA_FILE == "" || conf.SSL_CLIENT_CA_KEY_FILE == "" {
+		err = errors.New("certificate issuing is not configured (client_ca and client_ca_key)")
+		return
+	}
+	certPEM, err := ioutil.ReadFile(conf.SSL_CLIENT_CA_FILE)
+	if err != nil {
+		return
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		err = fmt.Errorf("no PEM data found in %s", conf.SSL_CLIENT_CA_FILE)
+		return
+	}
+	caCert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return
+	}
+
+	keyPEM, err := ioutil.ReadFile(conf.SSL_CLIENT_CA_KEY_FILE)
+	if err != nil {
+		return
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		err = fmt.Errorf("no PEM data found in %s", conf.SSL_CLIENT_CA_KEY_FILE)
+		return
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return
+	}
+	var ok bool
+	caKey, ok = key.(crypto.Signer)
+	if !ok {
+		err = errors.New("client_ca_key is not a signing key")
+		return
+	}
+	return
+}
+
+// IssueCertificate signs a new worker certificate for this clientgroup. If csrPEM is empty a new
+// key pair is generated and returned together with the certificate. The clientgroup is saved.
+func (cg *ClientGroup) IssueCertificate(commonName string, csrPEM []byte) (issued *IssuedCertificate, err error) {
+	caCert, caKey, err := loadClientCA()
+	if err != nil {
+		err = fmt.Errorf("(IssueCertificate) %s", err.Error())
+		return
+	}
+
+	issued = &IssuedCertificate{}
+
+	var publicKey crypto.PublicKey
+	if len(csrPEM) > 0 {
+		block, _ := pem.Decode(csrPEM)
+		if block == nil || block.Type != "CERTIFICATE REQUEST" {
+			err = errors.New("(IssueCertificate) body is not a PEM encoded certificate request")
+			return
+		}
+		var csr *x509.CertificateRequest
+		csr, err = x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			err = fmt.Errorf("(IssueCertificate) could not parse certificate request: %s", err.Error())
+			return
+		}
+		if err = csr.CheckSignature(); err != nil {
+			err = fmt.Errorf("(IssueCertificate) invalid certificate request signature: %s", err.Error())
+			return
+		}
+		publicKey = csr.PublicKey
+		if commonName == "" {
+			commonName = csr.Subject.CommonName
+		}
+	} else {
+		var key *ecdsa.PrivateKey
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return
+		}
+		var keyDER []byte
+		keyDER, err = x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return
+		}
+		issued.Key = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+		publicKey = key.Public()
+	}
+	if commonName == "" {
+		commonName = cg.Name
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:         commonName,
+			OrganizationalUnit: []string{cg.Name},
+		},
+		URIs:        []*url.URL{&url.URL{Scheme: clientGroupURIScheme, Host: clientGroupURIHost, Path: "/" + cg.Name}},
+		NotBefore:   now.Add(-5 * time.Minute),
+		NotAfter:    now.AddDate(0, 0, conf.SSL_CLIENT_CERT_DAYS),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if template.NotAfter.After(cg.Expiration) && !cg.Expiration.IsZero() {
+		template.NotAfter = cg.Expiration
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, publicKey, caKey)
+	if err != nil {
+		err = fmt.Errorf("(IssueCertificate) x509.CreateCertificate returned: %s", err.Error())
+		return
+	}
+	issued.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
+	issued.ClientCertificate = ClientCertificate{
+		Serial:     CertificateSerial(template),
+		CommonName: commonName,
+		CreatedOn:  now,
+		Expiration: template.NotAfter,
+	}
+
+	cg.Certificates = append(cg.Certificates, issued.ClientCertificate)
+	err = cg.Save()
+	return
+}
+
+// RevokeCertificate marks a certificate as revoked and saves the clientgroup. Serials that were
+// not issued by this server (e.g. signed directly with the CA) are recorded as revoked as well.
+func (cg *ClientGroup) RevokeCertificate(serial string) (err error) {
+	serial = strings.ToLower(strings.TrimPrefix(serial, "0x"))
+	if serial == "" {
+		err = errors.New("certificate serial missing")
+		return
+	}
+	now := time.Now()
+	found := false
+	for i := range cg.Certificates {
+		if cg.Certificates[i].Serial == serial {
+			cg.Certificates[i].Revoked = true
+			cg.Certificates[i].RevokedOn = now
+			found = true
+		}
+	}
+	if !found {
+		cg.Certificates = append(cg.Certificates, ClientCertificate{Serial: serial, Revoked: true, RevokedOn: now})
+	}
+	err = cg.Save()
+	return
+}
+
+// IsCertificateRevoked _
+func (cg *ClientGroup) IsCertificateRevoked(serial string) bool {
+	for _, c := range cg.Certificates {
+		if c.Serial == serial && c.Revoked {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAddress checks the client address against IPCidr (comma separated list of CIDRs).
+// An empty IPCidr or 0.0.0.0/0 allows every address, including IPv6.
+func (cg *ClientGroup) AllowsAddress(ip net.IP) (ok bool, err error) {
+	if cg.IPCidr == "" {
+		ok = true
+		return
+	}
+	ok, err = AddressInCIDRs(ip, cg.IPCidr)
+	if err != nil {
+		err = fmt.Errorf("(AllowsAddress) clientgroup %s has invalid ip_cidr: %s", cg.Name, err.Error())
+	}
+	return
+}
+
+// AddressInCIDRs reports whether ip is in one of the comma separated CIDRs, 0.0.0.0/0 and ::/0
+// match every address
+func AddressInCIDRs(ip net.IP, cidrs string) (ok bool, err error) {
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if cidr == "0.0.0.0/0" || cidr == "::/0" {
+			ok = true
+			return
+		}
+		var ipnet *net.IPNet
+		_, ipnet, err = net.ParseCIDR(cidr)
+		if err != nil {
+			return
+		}
+		if ip != nil && ipnet.Contains(ip) {
+			ok = true
+			return
+		}
+	}
+	return
+}
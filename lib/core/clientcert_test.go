@@ -0,0 +1,191 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+)
+
+func TestAllowsAddress(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		ip      string
+		allowed bool
+	}{
+		{"", "10.1.2.3", true},
+		{"0.0.0.0/0", "2001:db8::1", true},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "192.168.1.1", false},
+		{"192.168.0.0/16, 10.0.0.0/8", "10.1.2.3", true},
+		{"2001:db8::/32", "2001:db8::1", true},
+		{"10.0.0.0/8", "", false},
+	}
+	for _, test := range tests {
+		cg := &ClientGroup{Name: "test", IPCidr: test.cidr}
+		allowed, err := cg.AllowsAddress(net.ParseIP(test.ip))
+		if err != nil {
+			t.Errorf("%s %s: %s", test.cidr, test.ip, err.Error())
+			continue
+		}
+		if allowed != test.allowed {
+			t.Errorf("%s %s: got %t, want %t", test.cidr, test.ip, allowed, test.allowed)
+		}
+	}
+	cg := &ClientGroup{Name: "test", IPCidr: "10.0.0.0"}
+	if _, err := cg.AllowsAddress(net.ParseIP("10.0.0.1")); err == nil {
+		t.Errorf("expected an error for an invalid ip_cidr")
+	}
+}
+
+func TestClientGroupNameFromCertificate(t *testing.T) {
+	tests := []struct {
+		cert *x509.Certificate
+		want string
+	}{
+		{&x509.Certificate{URIs: []*url.URL{{Scheme: "awe", Host: "clientgroup", Path: "/gpu"}}, Subject: pkix.Name{OrganizationalUnit: []string{"other"}}}, "gpu"},
+		{&x509.Certificate{URIs: []*url.URL{{Scheme: "https", Host: "clientgroup", Path: "/gpu"}}, Subject: pkix.Name{OrganizationalUnit: []string{"other"}}}, "other"},
+		{&x509.Certificate{URIs: []*url.URL{{Scheme: "awe", Host: "clientgroup", Path: "/"}}}, ""},
+		{&x509.Certificate{}, ""},
+	}
+	for i, test := range tests {
+		if got := ClientGroupNameFromCertificate(test.cert); got != test.want {
+			t.Errorf("%d: got %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+// writeTestCA writes a CA certificate and key and configures them as client_ca and client_ca_key
+func writeTestCA(t *testing.T) (ca *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24 * 1000),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := path.Join(dir, "ca.pem"), path.Join(dir, "ca.key")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldCert, oldKey, oldDays := conf.SSL_CLIENT_CA_FILE, conf.SSL_CLIENT_CA_KEY_FILE, conf.SSL_CLIENT_CERT_DAYS
+	conf.SSL_CLIENT_CA_FILE, conf.SSL_CLIENT_CA_KEY_FILE, conf.SSL_CLIENT_CERT_DAYS = certFile, keyFile, 30
+	t.Cleanup(func() {
+		conf.SSL_CLIENT_CA_FILE, conf.SSL_CLIENT_CA_KEY_FILE, conf.SSL_CLIENT_CERT_DAYS = oldCert, oldKey, oldDays
+	})
+	return
+}
+
+func TestIssueAndRevokeCertificate(t *testing.T) {
+	ca := writeTestCA(t)
+	cg := &ClientGroup{ID: "cg-cert-test", Name: "gpu", Expiration: time.Now().Add(time.Hour * 24)}
+
+	issued, err := cg.IssueCertificate("worker1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued.Key == "" {
+		t.Errorf("no key generated")
+	}
+	block, _ := pem.Decode([]byte(issued.Certificate))
+	if block == nil {
+		t.Fatal("certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	if _, err = cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("issued certificate does not verify: %s", err.Error())
+	}
+	if ClientGroupNameFromCertificate(cert) != "gpu" || cert.Subject.CommonName != "worker1" {
+		t.Errorf("unexpected subject %v", cert.Subject)
+	}
+	if cert.NotAfter.After(cg.Expiration) {
+		t.Errorf("certificate expires after the clientgroup: %s", cert.NotAfter)
+	}
+	serial := CertificateSerial(cert)
+	if len(cg.Certificates) != 1 || cg.Certificates[0].Serial != serial || cg.IsCertificateRevoked(serial) {
+		t.Errorf("certificate not recorded: %+v", cg.Certificates)
+	}
+
+	// a CSR keeps the key with the worker
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "worker2"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err = cg.IssueCertificate("", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued.Key != "" || issued.CommonName != "worker2" {
+		t.Errorf("unexpected certificate from CSR: %+v", issued.ClientCertificate)
+	}
+	if _, err = cg.IssueCertificate("", []byte("not a csr")); err == nil {
+		t.Errorf("expected an error for an invalid CSR")
+	}
+
+	if err = cg.RevokeCertificate("0x" + serial); err != nil {
+		t.Fatal(err)
+	}
+	if !cg.IsCertificateRevoked(serial) || cg.IsCertificateRevoked(issued.Serial) {
+		t.Errorf("wrong certificates revoked: %+v", cg.Certificates)
+	}
+	// serials signed directly with the CA are recorded as revoked
+	if err = cg.RevokeCertificate("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if !cg.IsCertificateRevoked("abc") || len(cg.Certificates) != 3 {
+		t.Errorf("foreign serial not recorded: %+v", cg.Certificates)
+	}
+	if err = cg.RevokeCertificate(""); err == nil {
+		t.Errorf("expected an error for an empty serial")
+	}
+
+	saved, err := LoadClientGroup(cg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Certificates) != 3 || !saved.IsCertificateRevoked(serial) {
+		t.Errorf("clientgroup not saved: %+v", saved.Certificates)
+	}
+}
@@ -26,6 +26,7 @@ type ClientGroup struct {
 	CreatedOn    time.Time                     `bson:"created_on" json:"created_on"`
 	Expiration   time.Time                     `bson:"expiration" json:"expiration"`
 	LastModified time.Time                     `bson:"last_modified" json:"last_modified"`
+	Certificates []ClientCertificate           `bson:"certificates" json:"certificates"`
 }
 
 var (
@@ -84,6 +85,6 @@ func (cg *ClientGroup) SetToken() {
 // Save _
 func (cg *ClientGroup) Save() (err error) {
 	cg.LastModified = time.Now()
-	err = dbUpsert(cg)
+	err = Repo.ClientGroups.Save(cg)
 	return
 }
@@ -12,6 +12,6 @@ func (n *ClientGroups) GetPaginated(q bson.M, limit int, offset int, order strin
 	if direction == "desc" {
 		order = "-" + order
 	}
-	count, err = dbFindSortClientGroups(q, n, map[string]int{"limit": limit, "offset": offset}, order)
+	count, err = dbFindSortClientGroups(q, n, &DefaultQueryOptions{Limit: limit, Offset: offset, Sort: []string{order}})
 	return
 }
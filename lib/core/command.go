@@ -19,8 +19,13 @@ type Command struct {
 	Environ       Envs     `bson:"environ" json:"environ" mapstructure:"environ"`
 	HasPrivateEnv bool     `bson:"has_private_env" json:"has_private_env" mapstructure:"has_private_env"`
 	Description   string   `bson:"description" json:"description" mapstructure:"description"`
+	NetworkAccess bool     `bson:"network_access,omitempty" json:"network_access,omitempty" mapstructure:"network_access,omitempty"` // container needs network
 	ParsedArgs    []string `bson:"-" json:"-" mapstructure:"-"`
 	Local         bool     // indicates local execution, i.e. working directory is same as current working directory (do not delete !)
+
+	// directory (relative to the work directory) that is uploaded periodically and restored when the workunit is requeued
+	CheckpointDir      string `bson:"checkpoint_dir,omitempty" json:"checkpoint_dir,omitempty" mapstructure:"checkpoint_dir,omitempty"`
+	CheckpointInterval int    `bson:"checkpoint_interval,omitempty" json:"checkpoint_interval,omitempty" mapstructure:"checkpoint_interval,omitempty"` // seconds
 }
 
 // Envs _
@@ -481,7 +481,7 @@ func contains(list []string, elem string) bool {
 
 //NotifyWorkunitProcessed notify AWE server a workunit is finished with status either "failed" or "done", and with perf statistics if "done"
 func NotifyWorkunitProcessed(work *Workunit, perf *WorkPerf) (err error) {
-	targetURL := fmt.Sprintf("%s/work/%s?workid=%s&jobid=%s&status=%s&client=%s", conf.SERVER_URL, work.ID, work.TaskName, work.JobId, work.State, Self.ID)
+	targetURL := fmt.Sprintf("%s/work/%s?workid=%s&jobid=%s&status=%s&client=%s", ServerURL(), work.ID, work.TaskName, work.JobId, work.State, Self.ID)
 
 	argv := []string{}
 	argv = append(argv, "-X")
@@ -516,10 +516,10 @@ func NotifyWorkunitProcessedWithLogs(work *Workunit, perf *WorkPerf, sendstdlogs
 
 	targetURL := ""
 	if work.CWLWorkunit != nil {
-		targetURL = fmt.Sprintf("%s/work/%s?client=%s", conf.SERVER_URL, workIDb64, Self.ID) // client info is needed for authentication
+		targetURL = fmt.Sprintf("%s/work/%s?client=%s", ServerURL(), workIDb64, Self.ID) // client info is needed for authentication
 	} else {
 		// old AWE style result reporting (note that nodes had been created by the AWE server)
-		targetURL = fmt.Sprintf("%s/work/%s?status=%s&client=%s&computetime=%d", conf.SERVER_URL, workIDb64, work.State, Self.ID, work.ComputeTime)
+		targetURL = fmt.Sprintf("%s/work/%s?status=%s&client=%s&computetime=%d", ServerURL(), workIDb64, work.State, Self.ID, work.ComputeTime)
 	}
 	form := httpclient.NewForm()
 	hasreport := false
@@ -530,6 +530,13 @@ func NotifyWorkunitProcessedWithLogs(work *Workunit, perf *WorkPerf, sendstdlogs
 			hasreport = true
 		}
 	}
+	if perf != nil && perf.Usage != nil { // also for failed workunits, e.g. to see why they ran out of memory
+		usageFile, err := getUsageFilePath(work, perf.Usage)
+		if err == nil {
+			form.AddFile("usage", usageFile)
+			hasreport = true
+		}
+	}
 	if sendstdlogs { //send stdout and stderr files if specified and existed
 		stdoutFile, err := getStdOutPath(work)
 		if err == nil {
@@ -588,7 +595,7 @@ func NotifyWorkunitProcessedWithLogs(work *Workunit, perf *WorkPerf, sendstdlogs
 		}
 	}
 	logger.Debug(3, "PUT %s", targetURL)
-	res, err := httpclient.Put(targetURL, headers, form.Reader, nil)
+	res, err := DoServerRequest("PUT", targetURL, headers, form.Reader, 0)
 	if err != nil {
 		return
 	}
@@ -743,6 +750,20 @@ func getPerfFilePath(work *Workunit, perfstat *WorkPerf) (reportPath string, err
 	return
 }
 
+func getUsageFilePath(work *Workunit, usage *ResourceUsage) (reportPath string, err error) {
+	usageJsonstream, err := json.Marshal(usage)
+	if err != nil {
+		return
+	}
+	workPath, err := work.Path()
+	if err != nil {
+		return
+	}
+	reportPath = fmt.Sprintf("%s/%s.usage", workPath, work.ID)
+	err = ioutil.WriteFile(reportPath, usageJsonstream, 0644)
+	return
+}
+
 func getStdOutPath(work *Workunit) (stdoutFilePath string, err error) {
 	var workPath string
 	workPath, err = work.Path()
@@ -37,6 +37,8 @@ type FilterWorkStats struct {
 	SkipWork         int
 	WrongClientgroup int
 	WrongApp         int
+	NoRuntime        int
+	Reserved         int
 }
 
 //--------mgr methods-------
@@ -175,6 +177,15 @@ func (qm *CQMgr) CheckClient(client *Client) (ok bool, err error) {
 
 		logger.Debug(3, "(CheckClient) client %s has %d workunits", client.ID, len(currentWork))
 
+		if client.Draining && !client.DrainExpired && !client.DrainDeadline.IsZero() && time.Now().After(client.DrainDeadline) {
+			logger.Info("(CheckClient) drain deadline of client %s expired, requeue %d workunits", client.ID, len(currentWork))
+			client.DrainExpired = true
+			xerr = qm.ReQueueWorkunitByClient(client, false)
+			if xerr != nil {
+				logger.Error("(CheckClient) ReQueueWorkunitByClient: %s", xerr.Error())
+			}
+		}
+
 		for _, workID := range currentWork {
 			var workidStr string
 			workidStr, err = workID.String()
@@ -311,6 +322,8 @@ func (qm *CQMgr) ClientHeartBeat(id string, cg *ClientGroup, workerstate WorkerS
 	//get suspended workunit that need the client to discard
 	currentWork, xerr := client.CurrentWork.Get_list(false)
 	discard := []string{}
+	preempt := []string{}
+	stillPreempted := []string{}
 
 	for _, workID := range currentWork {
 		var work *Workunit
@@ -322,23 +335,45 @@ func (qm *CQMgr) ClientHeartBeat(id string, cg *ClientGroup, workerstate WorkerS
 
 		if !ok {
 			workIDSstr, _ := workID.String()
+			if reservedFor(workIDSstr) == id {
+				// new leader, the job of the workunit has not been recovered yet
+				continue
+			}
 			// server does not know about the work the client id working on
 			logger.Error("(ClientHeartBeat) Client was working on unknown workunit. Told him to discard.")
 			discard = append(discard, workIDSstr)
 			continue
 		}
 
+		if contains(client.Preempted, work.ID) && (work.State != WORK_STAT_CHECKOUT || work.Client != id) {
+			// requeued for higher priority work and not checked out by the client again
+			preempt = append(preempt, work.ID)
+			stillPreempted = append(stillPreempted, work.ID)
+			continue
+		}
+
 		if work.State == WORK_STAT_SUSPEND {
 			discard = append(discard, work.ID)
+		} else if client.DrainExpired {
+			// the work has been requeued when the drain deadline expired
+			discard = append(discard, work.ID)
 		}
 
 	}
 	if len(discard) > 0 {
 		hbmsg["discard"] = strings.Join(discard, ",")
 	}
+	// forget the preempted workunits the client has stopped
+	client.Preempted = stillPreempted
+	if len(preempt) > 0 {
+		hbmsg["preempt"] = strings.Join(preempt, ",")
+	}
 	//if client.Status == CLIENT_STAT_DELETED {
 	//	hbmsg["stop"] = id
 	//}
+	if client.Draining && !client.Maintenance {
+		hbmsg["drain"] = id
+	}
 
 	hbmsg["server-uuid"] = ServerUUID
 
@@ -408,6 +443,9 @@ func (qm *CQMgr) RegisterNewClient(files FormFiles, cg *ClientGroup) (client *Cl
 		return
 	}
 
+	// workunits the client is still running, e.g. after a leader failover
+	client.CurrentWork.FillMap()
+
 	if oldClientExists {
 		// copy values from new client to old client
 		oldClient.CurrentWork = client.CurrentWork
@@ -646,6 +684,125 @@ func (qm *CQMgr) SuspendAllClientsByUser(u *user.User, reason string) (count int
 	return
 }
 
+// DrainClient the client gets no new work, with the next heartbeat it is told to finish its current work
+// and exit (unless maintenance is set). deadline 0 means no deadline.
+func (qm *CQMgr) DrainClient(id string, maintenance bool, deadline time.Duration) (err error) {
+	client, ok, err := qm.GetClient(id, true)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return errors.New(e.ClientNotFound)
+	}
+
+	err = client.Drain(maintenance, deadline, true)
+	if err != nil {
+		return
+	}
+	logger.Event(event.CLIENT_DRAIN, "clientid="+id)
+	logger.Info("(DrainClient) client %s (%s) is draining, maintenance=%t, deadline=%s", id, client.WorkerRuntime.Name, maintenance, deadline)
+	return
+}
+
+// DrainClientByUser _
+func (qm *CQMgr) DrainClientByUser(id string, u *user.User, maintenance bool, deadline time.Duration) (err error) {
+	err = qm.checkClientOfUser(id, u)
+	if err != nil {
+		return
+	}
+	return qm.DrainClient(id, maintenance, deadline)
+}
+
+// DrainClientByClientGroup drain requested by the worker itself, e.g. on SIGTERM
+func (qm *CQMgr) DrainClientByClientGroup(id string, cg *ClientGroup, deadline time.Duration) (err error) {
+	err = qm.checkClientOfClientGroup(id, cg)
+	if err != nil {
+		return
+	}
+	return qm.DrainClient(id, false, deadline)
+}
+
+// DeregisterClient removes a draining client, e.g. a drained worker before it exits
+func (qm *CQMgr) DeregisterClient(id string) (err error) {
+	client, ok, err := qm.GetClient(id, true)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return errors.New(e.ClientNotFound)
+	}
+	isDraining, err := client.GetDraining(true)
+	if err != nil {
+		return
+	}
+	if !isDraining {
+		return errors.New(e.ClientNotDraining)
+	}
+	return qm.RemoveClient(id, true)
+}
+
+// DeregisterClientByUser _
+func (qm *CQMgr) DeregisterClientByUser(id string, u *user.User) (err error) {
+	err = qm.checkClientOfUser(id, u)
+	if err != nil {
+		return
+	}
+	return qm.DeregisterClient(id)
+}
+
+// DeregisterClientByClientGroup a drained worker removes itself before it exits
+func (qm *CQMgr) DeregisterClientByClientGroup(id string, cg *ClientGroup) (err error) {
+	err = qm.checkClientOfClientGroup(id, cg)
+	if err != nil {
+		return
+	}
+	return qm.DeregisterClient(id)
+}
+
+// checkClientOfUser the clientgroup of the client is owned by u or publicly owned, or u is admin
+func (qm *CQMgr) checkClientOfUser(id string, u *user.User) (err error) {
+	client, ok, err := qm.GetClient(id, true)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return errors.New(e.ClientNotFound)
+	}
+
+	// Get all clientgroups that user owns or that are publicly owned, or all if user is admin
+	q := bson.M{}
+	clientgroups := new(ClientGroups)
+	dbFindClientGroups(q, clientgroups)
+	for _, cg := range *clientgroups {
+		if cg.Name != client.Group {
+			continue
+		}
+		if (u.Uuid != "public" && (cg.ACL.Owner == u.Uuid || u.Admin == true || cg.ACL.Owner == "public")) ||
+			(u.Uuid == "public" && conf.CLIENT_AUTH_REQ == false && cg.ACL.Owner == "public") {
+			return
+		}
+	}
+	return errors.New(e.UnAuth)
+}
+
+// checkClientOfClientGroup the client belongs to the authenticated clientgroup cg
+func (qm *CQMgr) checkClientOfClientGroup(id string, cg *ClientGroup) (err error) {
+	if cg == nil {
+		return errors.New(e.UnAuth)
+	}
+	client, ok, err := qm.GetClient(id, true)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return errors.New(e.ClientNotFound)
+	}
+	if client.Group != cg.Name {
+		return errors.New(e.ClientGroupBadName)
+	}
+	return
+}
+
 // ResumeClient _
 func (qm *CQMgr) ResumeClient(id string) (err error) {
 	client, ok, err := qm.GetClient(id, true)
@@ -857,6 +1014,16 @@ func (qm *CQMgr) CheckoutWorkunits(reqPolicy string, clientID string, client *Cl
 		return
 	}
 
+	isDraining, err := client.GetDraining(true)
+	if err != nil {
+		return
+	}
+
+	if isDraining {
+		err = errors.New(e.ClientDraining)
+		return
+	}
+
 	//if status == CLIENT_STAT_DELETED {
 	//	qm.RemoveClient(client_id, false)
 	//	return nil, errors.New(e.ClientDeleted)
@@ -918,6 +1085,10 @@ func (qm *CQMgr) CheckoutWorkunits(reqPolicy string, clientID string, client *Cl
 		if err != nil {
 			return
 		}
+		err = client.ClearPreempted(work.ID)
+		if err != nil {
+			return
+		}
 		addedWork++
 	}
 
@@ -1011,7 +1182,7 @@ func (qm *CQMgr) popWorks(req CheckoutRequest) (clientSpecificWorkunits []*Worku
 // client has to be read-locked
 func (qm *CQMgr) filterWorkByClient(client *Client) (workunits WorkList, s FilterWorkStats, err error) {
 
-	s = FilterWorkStats{0, 0, 0, 0}
+	s = FilterWorkStats{0, 0, 0, 0, 0, 0}
 
 	if client == nil {
 		err = fmt.Errorf("(filterWorkByClient) client == nil")
@@ -1050,17 +1221,27 @@ func (qm *CQMgr) filterWorkByClient(client *Client) (workunits WorkList, s Filte
 			s.SkipWork++
 			continue
 		}
+		//skip works kept for the client that ran them before a leader failover
+		if reservedFor(workunit.ID) != "" {
+			logger.Debug(3, "2) workunit %s is reserved for its previous client", id)
+			s.Reserved++
+			continue
+		}
 		//skip works that have dedicate client groups which this client doesn't belong to
-		if len(workunit.Info.ClientGroups) > 0 {
-			eligibleGroups := strings.Split(workunit.Info.ClientGroups, ",")
-			if !contains(eligibleGroups, client.Group) {
-				logger.Debug(3, fmt.Sprintf("3) !contains(eligibleGroups, client.Group) %s", id))
-				s.WrongClientgroup++
-				continue
-			}
+		groupOK, appOK, runtimeOK := clientAcceptsWorkunit(client, workunit)
+		if !groupOK {
+			logger.Debug(3, fmt.Sprintf("3) !contains(eligibleGroups, client.Group) %s", id))
+			s.WrongClientgroup++
+			continue
+		}
+		//skip container works if the client has no container runtime
+		if !runtimeOK {
+			logger.Debug(3, "3) client %s has no container runtime for %s", clientid, id)
+			s.NoRuntime++
+			continue
 		}
 		//append works whos apps are supported by the client
-		if contains(client.Apps, workunit.Cmd.Name) || contains(client.Apps, conf.ALL_APP) {
+		if appOK {
 			logger.Debug(3, "append job %s to list of client %s", id, clientid)
 			workunits = append(workunits, workunit)
 		} else {
@@ -1079,6 +1260,28 @@ func (qm *CQMgr) filterWorkByClient(client *Client) (workunits WorkList, s Filte
 	return
 }
 
+// clientAcceptsWork applies the clientgroup and app rules of filterWorkByClient
+func clientAcceptsWork(client *Client, clientGroups string, cmdName string) (groupOK bool, appOK bool) {
+	groupOK = true
+	if len(clientGroups) > 0 {
+		groupOK = contains(strings.Split(clientGroups, ","), client.Group)
+	}
+	appOK = contains(client.Apps, cmdName) || contains(client.Apps, conf.ALL_APP)
+	return
+}
+
+// clientAcceptsWorkunit is clientAcceptsWork for a workunit, runtimeOK is false if the workunit needs a
+// container and the client reported that it has no container runtime. Clients that do not report their
+// runtimes are assumed to have one.
+func clientAcceptsWorkunit(client *Client, work *Workunit) (groupOK bool, appOK bool, runtimeOK bool) {
+	groupOK, appOK = clientAcceptsWork(client, work.Info.ClientGroups, work.Cmd.Name)
+	runtimeOK = true
+	if len(client.ContainerRuntimes) == 1 && client.ContainerRuntimes[0] == ContainerRuntimeNone {
+		runtimeOK = !work.NeedsContainer()
+	}
+	return
+}
+
 // lock: read-lock for client
 //func (qm *CQMgr) getWorkByClient(clientid string, lock bool) (ids []string) {
 //	client, ok := qm.GetClient(clientid, true)
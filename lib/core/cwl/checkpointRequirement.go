@@ -0,0 +1,28 @@
+package cwl
+
+import (
+	"github.com/mitchellh/mapstructure"
+)
+
+// CheckpointRequirement AWE extension: the worker uploads the directory (relative to the output directory)
+// every interval seconds and restores it when the workunit is requeued, e.g. after a preemption
+type CheckpointRequirement struct {
+	BaseRequirement `bson:",inline" yaml:",inline" json:",inline" mapstructure:",squash"`
+	Directory       string `yaml:"directory" bson:"directory" json:"directory" mapstructure:"directory"`
+	Interval        int    `yaml:"interval,omitempty" bson:"interval,omitempty" json:"interval,omitempty" mapstructure:"interval,omitempty"`
+}
+
+// GetID _
+func (c CheckpointRequirement) GetID() string { return "None" }
+
+// NewCheckpointRequirement _
+func NewCheckpointRequirement(original interface{}) (r *CheckpointRequirement, err error) {
+
+	var requirement CheckpointRequirement
+	r = &requirement
+	err = mapstructure.Decode(original, &requirement)
+
+	requirement.Class = "CheckpointRequirement"
+
+	return
+}
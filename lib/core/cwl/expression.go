@@ -207,6 +207,159 @@ func (e Expression) EvaluateExpression(self interface{}, inputs interface{}, con
 
 }
 
+// EvaluateRaw evaluates the expression with self, inputs and runtime bound and returns
+// the result as plain JSON value (string, float64, bool, nil, []interface{} or
+// map[string]interface{}). Parameter references embedded in a longer string are
+// interpolated as strings, a ${...} body is evaluated as function.
+func (e Expression) EvaluateRaw(self interface{}, inputs interface{}, runtime interface{}) (result interface{}, err error) {
+
+	exprStr := e.String()
+
+	trimmed := strings.TrimSpace(exprStr)
+	if strings.HasPrefix(trimmed, "${") && strings.HasSuffix(trimmed, "}") {
+		body := strings.TrimSuffix(strings.TrimPrefix(trimmed, "${"), "}")
+		result, err = evaluateJavascript(fmt.Sprintf("(function(){\n%s\n})()", body), self, inputs, runtime)
+		if err != nil {
+			err = fmt.Errorf("(EvaluateRaw) evaluateJavascript returned: %s", err.Error())
+		}
+		return
+	}
+
+	parsed := ""
+	rest := exprStr
+	for {
+		start, end := findParameterReference(rest)
+		if start < 0 {
+			break
+		}
+
+		var value interface{}
+		value, err = evaluateJavascript(rest[start+2:end], self, inputs, runtime)
+		if err != nil {
+			err = fmt.Errorf("(EvaluateRaw) evaluateJavascript returned: %s", err.Error())
+			return
+		}
+
+		if start == 0 && end == len(rest)-1 && parsed == "" {
+			// the expression consists of a single parameter reference, keep the type
+			result = value
+			return
+		}
+
+		valueStr := ""
+		switch value.(type) {
+		case string:
+			valueStr = value.(string)
+		default:
+			var valueBytes []byte
+			valueBytes, err = json.Marshal(value)
+			if err != nil {
+				err = fmt.Errorf("(EvaluateRaw) json.Marshal returned: %s", err.Error())
+				return
+			}
+			valueStr = string(valueBytes)
+		}
+
+		parsed += rest[:start] + valueStr
+		rest = rest[end+1:]
+	}
+
+	result = parsed + rest
+	return
+}
+
+// findParameterReference returns the position of the next "$(" and its closing parenthesis
+func findParameterReference(str string) (start int, end int) {
+	start = strings.Index(str, "$(")
+	for start > 0 && str[start-1] == '\\' {
+		next := strings.Index(str[start+2:], "$(")
+		if next < 0 {
+			start = -1
+			break
+		}
+		start = start + 2 + next
+	}
+	if start < 0 {
+		return -1, -1
+	}
+
+	depth := 0
+	var quote byte
+	for i := start + 1; i < len(str); i++ {
+		c := str[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				end = i
+				return
+			}
+		}
+	}
+	return -1, -1
+}
+
+// evaluateJavascript runs code in a new javascript VM and converts the result via JSON
+func evaluateJavascript(code string, self interface{}, inputs interface{}, runtime interface{}) (result interface{}, err error) {
+
+	var selfJSON, inputsJSON, runtimeJSON []byte
+	selfJSON, err = json.Marshal(self)
+	if err != nil {
+		err = fmt.Errorf("(evaluateJavascript) json.Marshal(self) returned: %s", err.Error())
+		return
+	}
+	inputsJSON, err = json.Marshal(inputs)
+	if err != nil {
+		err = fmt.Errorf("(evaluateJavascript) json.Marshal(inputs) returned: %s", err.Error())
+		return
+	}
+	runtimeJSON, err = json.Marshal(runtime)
+	if err != nil {
+		err = fmt.Errorf("(evaluateJavascript) json.Marshal(runtime) returned: %s", err.Error())
+		return
+	}
+
+	javascript := fmt.Sprintf("var self=%s; var inputs=%s; var runtime=%s;\nJSON.stringify(%s);", selfJSON, inputsJSON, runtimeJSON, code)
+	logger.Debug(3, "(evaluateJavascript) %s", javascript)
+
+	vm := otto.New()
+	value, xerr := vm.Run(javascript)
+	if xerr != nil {
+		err = fmt.Errorf("javascript complained: %s (javascript: %s)", xerr.Error(), code)
+		return
+	}
+
+	if value.IsUndefined() {
+		return
+	}
+
+	resultStr, xerr := value.ToString()
+	if xerr != nil {
+		err = fmt.Errorf("(evaluateJavascript) value.ToString returned: %s", xerr.Error())
+		return
+	}
+
+	err = json.Unmarshal([]byte(resultStr), &result)
+	if err != nil {
+		err = fmt.Errorf("(evaluateJavascript) json.Unmarshal returned: %s", err.Error())
+		return
+	}
+
+	return
+}
+
 //var CWL_Expression CWLType_Type = "expression"
 func NewExpressionFromString(original string) (expression *Expression) {
 
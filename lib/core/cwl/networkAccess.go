@@ -0,0 +1,57 @@
+package cwl
+
+import (
+	"fmt"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// NetworkAccess https://www.commonwl.org/v1.1/CommandLineTool.html#NetworkAccess
+type NetworkAccess struct {
+	BaseRequirement `bson:",inline" yaml:",inline" json:",inline" mapstructure:",squash"`
+	NetworkAccess   interface{} `yaml:"networkAccess" bson:"networkAccess" json:"networkAccess" mapstructure:"networkAccess"` // boolean | Expression
+}
+
+// GetID _
+func (c NetworkAccess) GetID() string { return "None" }
+
+// NewNetworkAccess _
+func NewNetworkAccess(original interface{}) (r *NetworkAccess, err error) {
+
+	var requirement NetworkAccess
+	r = &requirement
+	err = mapstructure.Decode(original, &requirement)
+
+	requirement.Class = "NetworkAccess"
+
+	return
+}
+
+// Granted evaluates networkAccess, which is either a boolean or an expression
+func (c *NetworkAccess) Granted(inputs interface{}) (granted bool, err error) {
+
+	value := c.NetworkAccess
+	switch value.(type) {
+	case string:
+		value, err = Expression(value.(string)).EvaluateRaw(nil, inputs, nil)
+		if err != nil {
+			err = fmt.Errorf("(NetworkAccess/Granted) EvaluateRaw returned: %s", err.Error())
+			return
+		}
+	case Expression:
+		value, err = value.(Expression).EvaluateRaw(nil, inputs, nil)
+		if err != nil {
+			err = fmt.Errorf("(NetworkAccess/Granted) EvaluateRaw returned: %s", err.Error())
+			return
+		}
+	}
+
+	switch value.(type) {
+	case bool:
+		granted = value.(bool)
+	case nil:
+	default:
+		err = fmt.Errorf("(NetworkAccess/Granted) networkAccess has to be a boolean, got %T", value)
+	}
+	return
+}
@@ -149,6 +149,22 @@ func NewRequirement(class string, obj interface{}, inputs interface{}, context *
 		}
 		return
 
+	case "NetworkAccess":
+		r, err = NewNetworkAccess(obj)
+		if err != nil {
+			err = fmt.Errorf("(NewRequirement) NewNetworkAccess returns: %s", err.Error())
+			return
+		}
+		return
+
+	case "CheckpointRequirement":
+		r, err = NewCheckpointRequirement(obj)
+		if err != nil {
+			err = fmt.Errorf("(NewRequirement) NewCheckpointRequirement returns: %s", err.Error())
+			return
+		}
+		return
+
 	case "SubworkflowFeatureRequirement":
 		thisR := DummyRequirement{}
 		thisR.Class = "SubworkflowFeatureRequirement"
@@ -127,3 +127,20 @@ func NewResourceRequirement(original interface{}, inputs interface{}, context *W
 	r.Class = "ResourceRequirement"
 	return
 }
+
+// ResourceNumber returns the value of a number field (e.g. coresMin), ok is false for expressions
+func ResourceNumber(value interface{}) (n int, ok bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case *Int:
+		return int(*v), true
+	case *Long:
+		return int(*v), true
+	}
+	return
+}
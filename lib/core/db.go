@@ -2,8 +2,8 @@ package core
 
 import (
 	"fmt"
+	"strings"
 
-	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/db"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -26,115 +26,91 @@ type DefaultQueryOptions struct {
 }
 
 func dbDelete(q bson.M, coll string) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(coll)
+	c := db.C(coll)
 	_, err = c.RemoveAll(q)
 	return
 }
 
-func dbUpsert(t interface{}) (err error) {
-	// test that document not to large
-	var nbson []byte
-	if nbson, err = bson.Marshal(t); err == nil {
-		if len(nbson) >= DocumentMaxByte {
-			err = fmt.Errorf("bson document size is greater than limit of %d bytes", DocumentMaxByte)
-			return
-		}
-	}
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	switch t := t.(type) {
-	case *Job:
-		c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-		_, err = c.Upsert(bson.M{"id": t.ID}, &t)
-	case *WorkflowInstance:
-
-		err = fmt.Errorf("(dbUpsert) not supported, please use dbInsert or dbUpdate")
+// checkDocumentSize documents must not exceed DocumentMaxByte
+func checkDocumentSize(t interface{}) (err error) {
+	nbson, err := bson.Marshal(t)
+	if err != nil {
 		return
-
-	case *JobPerf:
-		c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_PERF)
-		_, err = c.Upsert(bson.M{"id": t.Id}, &t)
-	case *ClientGroup:
-		c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
-		_, err = c.Upsert(bson.M{"id": t.ID}, &t)
-	default:
-		fmt.Printf("invalid database entry type\n")
+	}
+	if len(nbson) >= DocumentMaxByte {
+		err = fmt.Errorf("bson document size is greater than limit of %d bytes", DocumentMaxByte)
 	}
 	return
 }
 
-func dbInsert(t interface{}) (err error) {
-	// test that document not to large
-	var nbson []byte
-	if nbson, err = bson.Marshal(t); err == nil {
-		if len(nbson) >= DocumentMaxByte {
-			err = fmt.Errorf("bson document size is greater than limit of %d bytes", DocumentMaxByte)
+// ensureIndexes creates the indexes of a collection, each a single field
+func ensureIndexes(coll string, unique []string, fields []string) (err error) {
+	c := db.C(coll)
+	for _, field := range unique {
+		if err = c.EnsureIndex([]string{field}, true); err != nil {
+			err = fmt.Errorf("(ensureIndexes) %s.%s: %s", coll, field, err.Error())
 			return
 		}
 	}
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	switch t := t.(type) {
-
-	case *WorkflowInstance:
-		c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_SUBWORKFLOWS)
-		//var info *mgo.ChangeInfo
-
-		//id, _ := t.GetID(false)
-
-		err = c.Insert(&t)
-		if err != nil {
-			err = fmt.Errorf("(dbUpsert) c.Upsert returned: %s", err.Error())
+	for _, field := range fields {
+		if err = c.EnsureIndex([]string{field}, false); err != nil {
+			err = fmt.Errorf("(ensureIndexes) %s.%s: %s", coll, field, err.Error())
 			return
 		}
-		//fmt.Println("(dbUpsert) Upsert: " + id)
-
-		//info, err = c.Upsert(bson.M{"id": t.Id}, &t)
-
-		//fmt.Println("dbUpsert: info")
-		//spew.Dump(info)
-
-	default:
-		fmt.Printf("invalid database entry type\n")
 	}
 	return
 }
 
-func dbUpdate(t interface{}) (err error) {
-	// test that document not to large
-	var nbson []byte
-	if nbson, err = bson.Marshal(t); err == nil {
-		if len(nbson) >= DocumentMaxByte {
-			err = fmt.Errorf("bson document size is greater than limit of %d bytes", DocumentMaxByte)
-			return
-		}
+// withOptions sorts and pages a query, options may be nil
+func withOptions(query db.Query, options *DefaultQueryOptions) db.Query {
+	if options == nil {
+		return query
 	}
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	switch t := t.(type) {
-
-	case *WorkflowInstance:
-		c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_SUBWORKFLOWS)
-		//var info *mgo.ChangeInfo
+	if len(options.Sort) > 0 {
+		query = query.Sort(options.Sort...)
+	}
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit)
+	}
+	if options.Offset > 0 {
+		query = query.Skip(options.Offset)
+	}
+	return query
+}
 
-		//id, _ := t.GetID(false)
+// projection the fields to select, nil (all fields) if there are none
+func projection(fields []string) (p bson.M) {
+	if len(fields) == 0 {
+		return
+	}
+	p = bson.M{}
+	for _, field := range fields {
+		p[field] = 1
+	}
+	return
+}
 
-		err = c.Update(bson.M{"id": t.ID}, &t)
-		if err != nil {
-			err = fmt.Errorf("(dbUpsert) c.Upsert returned: %s", err.Error())
-			return
+// documentField converts the value of a (dotted) field of a document into result
+func documentField(doc bson.M, field string, result interface{}) (err error) {
+	var value interface{} = doc
+	for _, key := range strings.Split(field, ".") {
+		m, ok := asDocument(value)
+		if !ok {
+			return fmt.Errorf("(documentField) field %s not found", field)
+		}
+		if value, ok = m[key]; !ok {
+			return fmt.Errorf("(documentField) field %s not found", field)
 		}
-		//fmt.Println("(dbUpsert) Upsert: " + id)
-
-		//info, err = c.Upsert(bson.M{"id": t.Id}, &t)
-
-		//fmt.Println("dbUpsert: info")
-		//spew.Dump(info)
-
-	default:
-		fmt.Printf("invalid database entry type\n")
 	}
-	return
+	raw, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		return
+	}
+	holder := struct {
+		V bson.Raw `bson:"v"`
+	}{}
+	if err = bson.Unmarshal(raw, &holder); err != nil {
+		return
+	}
+	return holder.V.Unmarshal(result)
 }
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// documentArchive the archived jobs in conf.DB_COLL_ARCHIVE
+type documentArchive struct{}
+
+func (documentArchive) Init() error {
+	return ensureIndexes(conf.DB_COLL_ARCHIVE, []string{"id"}, []string{"acl.owner", "expiration"})
+}
+
+func (documentArchive) Get(id string) (archived *ArchivedJob, err error) {
+	archived = &ArchivedJob{}
+	if err = db.C(conf.DB_COLL_ARCHIVE).Find(bson.M{"id": id}).One(archived); err != nil {
+		archived = nil
+	}
+	return
+}
+
+func (documentArchive) Save(archived *ArchivedJob) error {
+	return db.C(conf.DB_COLL_ARCHIVE).Upsert(bson.M{"id": archived.ID}, archived)
+}
+
+func (documentArchive) Delete(id string) (err error) {
+	_, err = db.C(conf.DB_COLL_ARCHIVE).RemoveAll(bson.M{"id": id})
+	return
+}
+
+func (documentArchive) Expired(t time.Time) (ids []string, err error) {
+	query := bson.M{"$and": []bson.M{
+		bson.M{"expiration": bson.M{"$exists": true}},
+		bson.M{"expiration": bson.M{"$ne": time.Time{}}},
+		bson.M{"expiration": bson.M{"$lt": t}},
+	}}
+	docs := []struct {
+		ID string `bson:"id"`
+	}{}
+	if err = db.C(conf.DB_COLL_ARCHIVE).Find(query).Select(bson.M{"id": 1}).All(&docs); err != nil {
+		return
+	}
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return
+}
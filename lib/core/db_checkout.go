@@ -0,0 +1,105 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// CheckoutRecord a checked out workunit, persisted so that a restarted server (or a new HA leader)
+// can give it back to the client that is still running it. The records are written asynchronously,
+// a crash loses the changes of the last checkoutWriteDelay.
+type CheckoutRecord struct {
+	Work       string      `bson:"_id" json:"work"`
+	Client     string      `bson:"client" json:"client"`
+	Time       time.Time   `bson:"time" json:"time"`     // checkout time
+	Failed     int         `bson:"failed" json:"failed"` // failed attempts before this checkout
+	Checkpoint *Checkpoint `bson:"checkpoint,omitempty" json:"checkpoint,omitempty"`
+}
+
+// persistCheckouts only the server persists checkouts, and only with a database
+func persistCheckouts() bool {
+	return Service == "server" && db.Connected()
+}
+
+// checkoutWrites pending writes of checkout records, the last state of a workunit wins (nil: delete).
+// The callers hold the workqueue lock, the writes are done by writeCheckouts.
+var checkoutWrites = struct {
+	sync.Mutex
+	pending map[string]*CheckoutRecord
+	signal  chan bool
+	once    sync.Once
+}{pending: make(map[string]*CheckoutRecord), signal: make(chan bool, 1)}
+
+// checkoutWriteDelay collects the changes of a burst of checkouts into one batch
+const checkoutWriteDelay = 200 * time.Millisecond
+
+// queueCheckoutWrite records the new state of a workunit for writeCheckouts
+func queueCheckoutWrite(workID string, record *CheckoutRecord) {
+	checkoutWrites.once.Do(func() { go writeCheckouts() })
+	checkoutWrites.Lock()
+	checkoutWrites.pending[workID] = record
+	checkoutWrites.Unlock()
+	select {
+	case checkoutWrites.signal <- true:
+	default:
+	}
+}
+
+// writeCheckouts writes the pending checkout records in batches. A record changed during a batch is
+// written by the next one, so the database ends with the last state.
+func writeCheckouts() {
+	for range checkoutWrites.signal {
+		time.Sleep(checkoutWriteDelay)
+		checkoutWrites.Lock()
+		batch := checkoutWrites.pending
+		checkoutWrites.pending = make(map[string]*CheckoutRecord)
+		checkoutWrites.Unlock()
+
+		c := db.C(conf.DB_COLL_CHECKOUTS)
+		for workID, record := range batch {
+			var err error
+			if record == nil {
+				err = dbDelete(bson.M{"_id": workID}, conf.DB_COLL_CHECKOUTS)
+			} else {
+				err = c.Upsert(bson.M{"_id": workID}, record)
+			}
+			if err != nil {
+				logger.Error("(writeCheckouts) workunit %s: %s", workID, err.Error())
+			}
+		}
+	}
+}
+
+// dbSaveCheckout stores the checkout state of a workunit
+func dbSaveCheckout(work *Workunit) {
+	if !persistCheckouts() {
+		return
+	}
+	record := &CheckoutRecord{Work: work.ID, Client: work.Client, Time: work.CheckoutTime, Failed: work.Failed, Checkpoint: work.GetCheckpoint()}
+	queueCheckoutWrite(record.Work, record)
+}
+
+// dbDeleteCheckout removes the checkout state of a workunit that was delivered, requeued or deleted
+func dbDeleteCheckout(workID string) {
+	if !persistCheckouts() {
+		return
+	}
+	queueCheckoutWrite(workID, nil)
+}
+
+// dbGetCheckouts returns all persisted checkouts
+func dbGetCheckouts() (records []CheckoutRecord, err error) {
+	c := db.C(conf.DB_COLL_CHECKOUTS)
+	records = []CheckoutRecord{}
+	err = c.Find(nil).All(&records)
+	if err != nil {
+		err = fmt.Errorf("(dbGetCheckouts) c.Find returned: %s", err.Error())
+	}
+	return
+}
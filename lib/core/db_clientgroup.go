@@ -5,94 +5,86 @@ import (
 
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/db"
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-// InitClientGroupDB _
-func InitClientGroupDB() {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	cc := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
-	cc.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
-	cc.EnsureIndex(mgo.Index{Key: []string{"name"}, Unique: true})
-	cc.EnsureIndex(mgo.Index{Key: []string{"token"}, Unique: true})
+// documentClientGroups the clientgroups in conf.DB_COLL_CGS
+type documentClientGroups struct{}
+
+func (documentClientGroups) Init() error {
+	return ensureIndexes(conf.DB_COLL_CGS, []string{"id", "name", "token"}, nil)
 }
 
-// dbFindClientGroups _
-func dbFindClientGroups(q bson.M, results *ClientGroups) (count int, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
-	query := c.Find(q)
-	if count, err = query.Count(); err != nil {
-		return 0, err
+func (documentClientGroups) findOne(selector bson.M) (clientgroup *ClientGroup, err error) {
+	clientgroup = new(ClientGroup)
+	if err = db.C(conf.DB_COLL_CGS).Find(selector).One(&clientgroup); err != nil {
+		clientgroup = nil
 	}
-	err = query.All(results)
 	return
 }
 
-// dbFindSortClientGroups _
-func dbFindSortClientGroups(q bson.M, results *ClientGroups, options map[string]int, sortby string) (count int, err error) {
-	if sortby == "" {
-		return 0, errors.New("sortby must be an nonempty string")
-	}
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
-	query := c.Find(q)
+func (r documentClientGroups) Get(id string) (*ClientGroup, error) {
+	return r.findOne(bson.M{"id": id})
+}
+
+func (r documentClientGroups) GetByName(name string) (*ClientGroup, error) {
+	return r.findOne(bson.M{"name": name})
+}
+
+func (r documentClientGroups) GetByToken(token string) (*ClientGroup, error) {
+	return r.findOne(bson.M{"token": token})
+}
+
+func (documentClientGroups) Find(filter bson.M, options *DefaultQueryOptions, results *ClientGroups) (count int, err error) {
+	query := db.C(conf.DB_COLL_CGS).Find(filter)
 	if count, err = query.Count(); err != nil {
 		return 0, err
 	}
+	err = withOptions(query, options).All(results)
+	return
+}
 
-	if limit, has := options["limit"]; has {
-		if offset, has := options["offset"]; has {
-			err = query.Sort(sortby).Limit(limit).Skip(offset).All(results)
-			return
-		}
+func (documentClientGroups) Save(cg *ClientGroup) (err error) {
+	if err = checkDocumentSize(cg); err != nil {
+		return
 	}
-	err = query.Sort(sortby).All(results)
+	return db.C(conf.DB_COLL_CGS).Upsert(bson.M{"id": cg.ID}, cg)
+}
+
+func (documentClientGroups) Delete(id string) (err error) {
+	_, err = db.C(conf.DB_COLL_CGS).RemoveAll(bson.M{"id": id})
 	return
 }
 
+// dbFindClientGroups _
+func dbFindClientGroups(q bson.M, results *ClientGroups) (count int, err error) {
+	return Repo.ClientGroups.Find(q, nil, results)
+}
+
+// dbFindSortClientGroups _
+func dbFindSortClientGroups(q bson.M, results *ClientGroups, options *DefaultQueryOptions) (count int, err error) {
+	if len(options.Sort) == 0 || options.Sort[0] == "" {
+		return 0, errors.New("sortby must be an nonempty string")
+	}
+	return Repo.ClientGroups.Find(q, options, results)
+}
+
 // LoadClientGroup _
 func LoadClientGroup(id string) (clientgroup *ClientGroup, err error) {
-	clientgroup = new(ClientGroup)
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
-	if err = c.Find(bson.M{"id": id}).One(&clientgroup); err == nil {
-		return clientgroup, nil
-	}
-	return nil, err
+	return Repo.ClientGroups.Get(id)
 }
 
 // LoadClientGroupByName _
 func LoadClientGroupByName(name string) (clientgroup *ClientGroup, err error) {
-	clientgroup = new(ClientGroup)
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
-	if err = c.Find(bson.M{"name": name}).One(&clientgroup); err == nil {
-		return clientgroup, nil
-	}
-	return nil, err
+	return Repo.ClientGroups.GetByName(name)
 }
 
 // LoadClientGroupByToken _
 func LoadClientGroupByToken(token string) (clientgroup *ClientGroup, err error) {
-	clientgroup = new(ClientGroup)
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_CGS)
-	if err = c.Find(bson.M{"token": token}).One(&clientgroup); err == nil {
-		return clientgroup, nil
-	}
-	return nil, err
+	return Repo.ClientGroups.GetByToken(token)
 }
 
 // DeleteClientGroup _
 func DeleteClientGroup(id string) (err error) {
-	err = dbDelete(bson.M{"id": id}, conf.DB_COLL_CGS)
-	return
+	return Repo.ClientGroups.Delete(id)
 }
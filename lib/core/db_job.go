@@ -10,7 +10,6 @@ import (
 	"github.com/MG-RAST/AWE/lib/db"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/davecgh/go-spew/spew"
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -27,54 +26,58 @@ func HasInfoField(a string) bool {
 	return false
 }
 
-// InitJobDB _
-func InitJobDB() {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	cj := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	cj.EnsureIndex(mgo.Index{Key: []string{"acl.owner"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"acl.read"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"acl.write"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"acl.delete"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"state"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"expiration"}, Background: true})
-	cj.EnsureIndex(mgo.Index{Key: []string{"updatetime"}, Background: true})
+// documentJobs the jobs in conf.DB_COLL_JOBS
+type documentJobs struct{}
+
+func (documentJobs) Init() error {
+	fields := []string{"acl.owner", "acl.read", "acl.write", "acl.delete", "state", "expiration", "updatetime"}
 	for _, v := range JobInfoIndexes {
-		cj.EnsureIndex(mgo.Index{Key: []string{"info." + v}, Background: true})
+		fields = append(fields, "info."+v)
 	}
-	cp := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_PERF)
-	cp.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
-
-	cw := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_SUBWORKFLOWS)
-	//cw.EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true}) not needed, already got _id
-	cw.EnsureIndex(mgo.Index{Key: []string{"job_id"}, Unique: false})
+	return ensureIndexes(conf.DB_COLL_JOBS, []string{"id"}, fields)
 }
 
-func dbCount(q bson.M) (count int, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	if count, err = c.Find(q).Count(); err != nil {
-		return 0, err
+func (documentJobs) Get(id string) (job *Job, err error) {
+	job = NewJob()
+	if err = db.C(conf.DB_COLL_JOBS).Find(bson.M{"id": id}).One(&job); err != nil {
+		job = nil
 	}
+	return
+}
 
-	return count, nil
+func (documentJobs) Save(job *Job) (err error) {
+	if err = checkDocumentSize(job); err != nil {
+		return
+	}
+	return db.C(conf.DB_COLL_JOBS).Upsert(bson.M{"id": job.ID}, job)
+}
 
+func (documentJobs) Delete(id string) (err error) {
+	_, err = db.C(conf.DB_COLL_JOBS).RemoveAll(bson.M{"id": id})
+	return
 }
 
-// get a minimal subset of the job documents required for an admin overview
-// for all completed jobs younger than a month and all running jobs
-func dbAdminData(special string) (data []interface{}, err error) {
-	// get a DB connection
-	session := db.Connection.Session.Copy()
+func (documentJobs) Find(filter bson.M, options *DefaultQueryOptions, results *Jobs) (count int, err error) {
+	query := db.C(conf.DB_COLL_JOBS).Find(filter)
+	if count, err = query.Count(); err != nil {
+		return 0, err
+	}
+	err = withOptions(query, options).All(results)
+	return
+}
 
-	// close the connection when the function completes
-	defer session.Close()
+func (documentJobs) Count(filter bson.M) (int, error) {
+	return db.C(conf.DB_COLL_JOBS).Find(filter).Count()
+}
 
-	// set the database and collection
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+func (documentJobs) Distinct(filter bson.M, infoField string) (values interface{}, err error) {
+	err = db.C(conf.DB_COLL_JOBS).Find(filter).Distinct("info."+infoField, &values)
+	return
+}
 
+// AdminData a minimal subset of the job documents required for an admin overview
+// for all completed jobs younger than a month and all running jobs
+func (documentJobs) AdminData(special string) (data []interface{}, err error) {
 	// get the completed jobs that have a completed time not older than one month
 	var completedjobs = bson.M{"state": "completed", "info.completedtime": bson.M{"$gt": time.Now().AddDate(0, -1, 0)}}
 
@@ -85,46 +88,123 @@ func dbAdminData(special string) (data []interface{}, err error) {
 	var resultfields = bson.M{"_id": 0, "state": 1, "info.name": 1, "info.submittime": 1, "info.startedtime": 1, "info.completedtime": 1, "info.pipeline": 1, "tasks.createdDate": 1, "tasks.startedDate": 1, "tasks.completedDate": 1, "tasks.state": 1, "tasks.inputs.size": 1, "tasks.outputs.size": 1, special: 1}
 
 	// return all data without iterating
-	err = c.Find(bson.M{"$or": []bson.M{completedjobs, runningjobs}}).Select(resultfields).All(&data)
+	err = db.C(conf.DB_COLL_JOBS).Find(bson.M{"$or": []bson.M{completedjobs, runningjobs}}).Select(resultfields).All(&data)
+	return
+}
 
+func (documentJobs) GetField(id string, field string, result interface{}) (err error) {
+	doc := bson.M{}
+	if err = db.C(conf.DB_COLL_JOBS).Find(bson.M{"id": id}).Select(bson.M{field: 1}).One(&doc); err != nil {
+		return
+	}
+	return documentField(doc, field, result)
+}
+
+func (documentJobs) GetACL(id string) (_acl acl.Acl, err error) {
+	job := JobACL{}
+	if err = db.C(conf.DB_COLL_JOBS).Find(bson.M{"id": id}).Select(bson.M{"acl": 1}).One(&job); err != nil {
+		return
+	}
+	_acl = job.ACL
 	return
 }
 
-func dbFindSort(filterQuery bson.M, results *Jobs, options map[string]int, sortby string, doInit bool) (count int, err error) {
-	if sortby == "" {
-		return 0, errors.New("sortby must be an nonempty string")
+func (documentJobs) UpdateFields(id string, fields bson.M) error {
+	fields["updatetime"] = time.Now() // read by the warm standby
+	return db.C(conf.DB_COLL_JOBS).Update(bson.M{"id": id}, bson.M{"$set": fields})
+}
+
+func (documentJobs) GetDocument(id string) (doc bson.M, err error) {
+	err = db.C(conf.DB_COLL_JOBS).Find(bson.M{"id": id}).One(&doc)
+	return
+}
+
+func (documentJobs) RestoreDocument(doc bson.M) error {
+	return db.C(conf.DB_COLL_JOBS).Upsert(bson.M{"id": doc["id"]}, doc)
+}
+
+func (documentJobs) CompletedBefore(t time.Time, limit int) (ids []string, err error) {
+	query := bson.M{"$and": []bson.M{
+		bson.M{"state": JOB_STAT_COMPLETED},
+		bson.M{"info.completedtime": bson.M{"$gt": time.Time{}}}, // value has been set, not default
+		bson.M{"info.completedtime": bson.M{"$lt": t}},
+	}}
+	docs := []struct {
+		ID string `bson:"id"`
+	}{}
+	if err = db.C(conf.DB_COLL_JOBS).Find(query).Select(bson.M{"id": 1}).Limit(limit).All(&docs); err != nil {
+		return
+	}
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
 	}
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
+	return
+}
 
-	query := c.Find(filterQuery)
+func (documentJobs) UpdateTimes(states []string) (times map[string]time.Time, err error) {
+	docs := []struct {
+		ID         string    `bson:"id"`
+		UpdateTime time.Time `bson:"updatetime"`
+	}{}
+	err = db.C(conf.DB_COLL_JOBS).Find(bson.M{"state": bson.M{"$in": states}}).Select(bson.M{"id": 1, "updatetime": 1}).All(&docs)
+	if err != nil {
+		return
+	}
+	times = make(map[string]time.Time, len(docs))
+	for _, doc := range docs {
+		times[doc.ID] = doc.UpdateTime
+	}
+	return
+}
 
-	if count, err = query.Count(); err != nil {
+// RetentionCandidates the jobs in a final state with an expiration before expiresBefore, and, unless
+// finalBefore is nil, those without one that were updated or completed before finalBefore
+func (documentJobs) RetentionCandidates(expiresBefore time.Time, finalBefore *time.Time) (jobs []*retentionJob, err error) {
+	candidates := []bson.M{{"expiration": bson.M{"$gt": time.Time{}, "$lt": expiresBefore}}}
+	if finalBefore != nil {
+		candidates = append(candidates, bson.M{
+			"expiration": bson.M{"$in": []interface{}{nil, time.Time{}}},
+			"$or":        []bson.M{{"updatetime": bson.M{"$lt": *finalBefore}}, {"info.completedtime": bson.M{"$lt": *finalBefore}}},
+		})
+	}
+	jobs = []*retentionJob{}
+	query := bson.M{"state": bson.M{"$in": JOB_STATS_FINAL}, "$or": candidates}
+	projection := bson.M{"id": 1, "state": 1, "info": 1, "expiration": 1, "updatetime": 1}
+	err = db.C(conf.DB_COLL_JOBS).Find(query).Select(projection).All(&jobs)
+	return
+}
+
+// ReadingJobs the jobs other than exclude in one of states that have one of nodes as task input, and
+// the CWL jobs in one of states, with the fields that reference nodes
+func (documentJobs) ReadingJobs(exclude string, states []string, nodes []string) (jobs []bson.M, err error) {
+	query := bson.M{
+		"id":    bson.M{"$ne": exclude},
+		"state": bson.M{"$in": states},
+		"$or":   []bson.M{{"tasks.inputs.node": bson.M{"$in": nodes}}, {"is_cwl": true}},
+	}
+	jobs = []bson.M{}
+	err = db.C(conf.DB_COLL_JOBS).Find(query).Select(bson.M{"id": 1, "is_cwl": 1, "tasks.inputs": 1, "cwl_job_input": 1}).All(&jobs)
+	return
+}
+
+func dbCount(q bson.M) (count int, err error) {
+	if count, err = Repo.Jobs.Count(q); err != nil {
 		return 0, err
 	}
+	return count, nil
+}
 
-	if limit, has := options["limit"]; has {
-		if offset, has := options["offset"]; has {
-			err = query.Sort(sortby).Limit(limit).Skip(offset).All(results)
-			if results != nil {
-				if doInit {
-					_, err = results.Init()
-					if err != nil {
-						return
-					}
-				}
-			}
-			return
-		}
+func dbAdminData(special string) (data []interface{}, err error) {
+	return Repo.Jobs.AdminData(special)
+}
+
+func dbFindSort(filterQuery bson.M, results *Jobs, options *DefaultQueryOptions, doInit bool) (count int, err error) {
+	if len(options.Sort) == 0 || options.Sort[0] == "" {
+		return 0, errors.New("sortby must be an nonempty string")
 	}
-	err = query.Sort(sortby).All(results)
+	count, err = Repo.Jobs.Find(filterQuery, options, results)
 	if err != nil {
-		err = fmt.Errorf("query.Sort(sortby).All(results) failed: %s", err.Error())
-		return
-	}
-	if results == nil {
-		err = fmt.Errorf("(dbFindSort) results == nil")
+		err = fmt.Errorf("(dbFindSort) Repo.Jobs.Find returned: %s", err.Error())
 		return
 	}
 
@@ -143,11 +223,7 @@ func dbFindSort(filterQuery bson.M, results *Jobs, options map[string]int, sortb
 
 // DbFindDistinct _
 func DbFindDistinct(q bson.M, d string) (results interface{}, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	err = c.Find(q).Distinct("info."+d, &results)
-	return
+	return Repo.Jobs.Distinct(q, d)
 }
 
 func dbUpdateJobState(jobID string, newState string, notes string) (err error) {
@@ -162,22 +238,6 @@ func dbUpdateJobState(jobID string, newState string, notes string) (err error) {
 	return dbUpdateJobFields(jobID, updateValue)
 }
 
-func dbGetJobTasks(jobID string) (tasks []*Task, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-
-	selector := bson.M{"id": jobID}
-	fieldname := "tasks"
-	err = c.Find(selector).Select(bson.M{fieldname: 1}).All(&tasks)
-	if err != nil {
-		err = fmt.Errorf("(dbGetJobTasks) Error getting tasks from jobID %s: %s", jobID, err.Error())
-		return
-	}
-	return
-}
-
 func dbGetJobTaskString(jobID string, taskID string, fieldname string) (result string, err error) {
 	var cont StructContainer
 	err = dbGetJobTaskField(jobID, taskID, fieldname, &cont)
@@ -208,47 +268,16 @@ func dbGetJobTaskInt(jobID string, taskID string, fieldname string) (result int,
 	return
 }
 
-func dbFind(q bson.M, results *Jobs, options map[string]int) (count int, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	query := c.Find(q)
-	if count, err = query.Count(); err != nil {
+func dbFind(q bson.M, results *Jobs, options *DefaultQueryOptions) (count int, err error) {
+	if count, err = Repo.Jobs.Find(q, options, results); err != nil {
 		return 0, err
 	}
-	if limit, has := options["limit"]; has {
-		if offset, has := options["offset"]; has {
-			err = query.Limit(limit).Skip(offset).All(results)
-			if results != nil {
-				_, err = results.Init()
-				if err != nil {
-					return
-				}
-			}
-			return
-		}
-
-		return 0, errors.New("store.db.Find options limit and offset must be used together")
-
-	}
-	err = query.All(results)
-	if results != nil {
-		_, err = results.Init()
-		if err != nil {
-			return
-		}
-	}
+	_, err = results.Init()
 	return
 }
 
 func dbUpdateJobFields(jobID string, updateValue bson.M) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	selector := bson.M{"id": jobID}
-
-	err = c.Update(selector, bson.M{"$set": updateValue})
+	err = Repo.Jobs.UpdateFields(jobID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("Error updating job fields: " + err.Error())
 		return
@@ -282,43 +311,20 @@ func dbUpdateJobFieldNull(jobID string, fieldname string) (err error) {
 }
 
 func dbUpdateJobTaskFields(jobID string, workflowInstanceID string, taskID string, updateValue bson.M) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	database := conf.DB_COLL_JOBS
 	if workflowInstanceID != "" {
-		database = conf.DB_COLL_SUBWORKFLOWS
-	}
-
-	c := session.DB(conf.MONGODB_DATABASE).C(database)
-
-	var selector bson.M
-	var updateOp bson.M
-	if workflowInstanceID == "" {
-		selector = bson.M{"id": jobID, "tasks.taskid": taskID}
-		updateOp = bson.M{"$set": updateValue}
-	} else {
 		err = fmt.Errorf("not supported")
 		return
-
-		//unique_id := jobID + workflowInstance_id
-		//selector = bson.M{"_id": unique_id, "tasks.taskid": taskID}
-		//updateOp = bson.M{"$set": updateValue}
 	}
-
-	//fmt.Println("(dbUpdateJobTaskFields) updateValue:")
-	//spew.Dump(updateValue)
-
-	err = c.Update(selector, updateOp)
+	err = Repo.Tasks.UpdateFields(jobID, "", taskID, updateValue)
 	if err != nil {
-		err = fmt.Errorf("(dbUpdateJobTaskFields) (db: %s, workflowInstanceID: %s) Error updating task %s: %s", database, workflowInstanceID, taskID, err.Error())
+		err = fmt.Errorf("(dbUpdateJobTaskFields) (workflowInstanceID: %s) Error updating task %s: %s", workflowInstanceID, taskID, err.Error())
 		return
 	}
 	return
 }
 
 func dbUpdateJobTaskField(jobID string, workflowInstance string, taskID string, fieldname string, value interface{}) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateJobTaskFields(jobID, workflowInstance, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateJobTaskField) dbUpdateJobTaskFields returned: %s", err.Error())
@@ -328,7 +334,7 @@ func dbUpdateJobTaskField(jobID string, workflowInstance string, taskID string,
 }
 
 func dbUpdateJobTaskInt(jobID string, workflowInstance string, taskID string, fieldname string, value int) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateJobTaskFields(jobID, workflowInstance, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateJobTaskInt) dbUpdateJobTaskFields returned: %s", err.Error())
@@ -337,7 +343,7 @@ func dbUpdateJobTaskInt(jobID string, workflowInstance string, taskID string, fi
 
 }
 func dbUpdateJobTaskBoolean(jobID string, workflowInstance string, taskID string, fieldname string, value bool) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateJobTaskFields(jobID, workflowInstance, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateJobTaskBoolean) dbUpdateJobTaskFields returned: %s", err.Error())
@@ -347,7 +353,7 @@ func dbUpdateJobTaskBoolean(jobID string, workflowInstance string, taskID string
 }
 
 func dbUpdateJobTaskString(jobID string, workflowInstance string, taskID string, fieldname string, value string) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateJobTaskFields(jobID, workflowInstance, taskID, updateValue)
 
 	if err != nil {
@@ -357,7 +363,7 @@ func dbUpdateJobTaskString(jobID string, workflowInstance string, taskID string,
 }
 
 func dbUpdateJobTaskTime(jobID string, workflowInstance string, taskID string, fieldname string, value time.Time) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateJobTaskFields(jobID, workflowInstance, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateJobTaskTime) (fieldname %s) dbUpdateJobTaskFields returned: %s", fieldname, err.Error())
@@ -366,7 +372,7 @@ func dbUpdateJobTaskTime(jobID string, workflowInstance string, taskID string, f
 }
 
 func dbUpdateJobTaskPartition(jobID string, workflowInstance string, taskID string, partition *PartInfo) (err error) {
-	updateValue := bson.M{"tasks.$.partinfo": partition}
+	updateValue := bson.M{"partinfo": partition}
 	err = dbUpdateJobTaskFields(jobID, workflowInstance, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateJobTaskPartition) dbUpdateJobTaskFields returned: %s", err.Error())
@@ -375,7 +381,7 @@ func dbUpdateJobTaskPartition(jobID string, workflowInstance string, taskID stri
 }
 
 func dbUpdateJobTaskIO(jobID string, workflowInstance string, taskID string, fieldname string, value []*IO) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateJobTaskFields(jobID, workflowInstance, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateJobTaskIO) dbUpdateJobTaskFields returned: %s", err.Error())
@@ -384,97 +390,95 @@ func dbUpdateJobTaskIO(jobID string, workflowInstance string, taskID string, fie
 }
 
 func dbIncrementJobTaskField(jobID string, taskID string, fieldname string, incrementValue int) (err error) {
-
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-
-	selector := bson.M{"id": jobID, "tasks.taskid": taskID}
-
-	updateValue := bson.M{"tasks.$." + fieldname: incrementValue}
-
-	err = c.Update(selector, bson.M{"$inc": updateValue})
+	err = Repo.Tasks.Increment(jobID, taskID, fieldname, incrementValue)
 	if err != nil {
 		err = fmt.Errorf("Error incrementing jobID=%s fieldname=%s by %d: %s", jobID, fieldname, incrementValue, err.Error())
 		return
 	}
-
 	return
 }
 
 // DbUpdateJobField _
 func DbUpdateJobField(jobID string, key string, value interface{}) (err error) {
-
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-
-	query := bson.M{"id": jobID}
-	updateValue := bson.M{key: value}
-	update := bson.M{"$set": updateValue}
-
-	err = c.Update(query, update)
+	err = Repo.Jobs.UpdateFields(jobID, bson.M{key: value})
 	if err != nil {
 		err = fmt.Errorf("Error updating job %s and key %s in job: %s", jobID, key, err.Error())
 		return
 	}
-
 	return
 }
 
 // LoadJob _
 func LoadJob(id string) (job *Job, err error) {
-	job = NewJob()
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-
-	// A) get job document
-	err = c.Find(bson.M{"id": id}).One(&job)
+	job, WIsIf, err := readJobDocuments(id)
 	if err != nil {
 		job = nil
-		err = fmt.Errorf("(LoadJob) c.Find failed: %s", err.Error())
+		err = fmt.Errorf("(LoadJob) %s", err.Error())
 		return
 	}
+	err = initJobDocuments(job, WIsIf)
+	if err != nil {
+		err = fmt.Errorf("(LoadJob) %s", err.Error())
+	}
+	return
+}
 
-	// continue A, initialize
-	var jobChanged bool
-	jobChanged, err = job.Init() // values have already been set at this point...
+// readJobDocuments reads the job document and, for CWL jobs, its WorkflowInstances without changing
+// anything, the warm standby keeps them until it becomes the leader
+func readJobDocuments(id string) (job *Job, WIsIf []interface{}, err error) {
+	// A) get job document
+	job, err = Repo.Jobs.Get(id)
 	if err != nil {
-		err = fmt.Errorf("(LoadJob) job.Init failed: %s", err.Error())
+		err = fmt.Errorf("(readJobDocuments) Repo.Jobs.Get failed: %s", err.Error())
 		return
 	}
 
 	// B) get WorkflowInstances
 	if job.IsCWL {
-
-		c2 := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_SUBWORKFLOWS)
-
-		WIsIf := []interface{}{} // have to use interface, because mongo cannot handle interface types
-
-		err = c2.Find(bson.M{"job_id": id}).All(&WIsIf)
+		var docs []bson.M
+		docs, err = Repo.WorkflowInstances.GetDocuments([]string{id}, nil)
 		if err != nil {
-			job = nil
-			err = fmt.Errorf("(LoadJob) (DB_COLL_SUBWORKFLOWS) c.Find failed: %s", err.Error())
+			err = fmt.Errorf("(readJobDocuments) Repo.WorkflowInstances.GetDocuments failed: %s", err.Error())
 			return
 		}
 
-		if len(WIsIf) == 0 {
-			err = fmt.Errorf("(LoadJob) no matching WorkflowInstances found for job %s", id)
+		if len(docs) == 0 {
+			err = fmt.Errorf("(readJobDocuments) no matching WorkflowInstances found for job %s", id)
 			return
 		}
 
+		WIsIf = make([]interface{}, len(docs)) // have to use interface, because mongo cannot handle interface types
+		for i, doc := range docs {
+			WIsIf[i] = doc
+		}
+	}
+	return
+}
+
+// initJobDocuments initializes a job read by readJobDocuments and adds its WorkflowInstances
+func initJobDocuments(job *Job, WIsIf []interface{}) (err error) {
+	id := job.ID
+
+	// continue A, initialize
+	var jobChanged bool
+	jobChanged, err = job.Init() // values have already been set at this point...
+	if err != nil {
+		err = fmt.Errorf("(initJobDocuments) job.Init failed: %s", err.Error())
+		return
+	}
+
+	// continue B
+	if job.IsCWL {
+
 		var wis []*WorkflowInstance
 		wis, err = NewWorkflowInstanceArrayFromInterface(WIsIf, job, job.WorkflowContext)
 		if err != nil {
-			err = fmt.Errorf("(LoadJob) NewWorkflowInstanceArrayFromInterface returned: %s", err.Error())
+			err = fmt.Errorf("(initJobDocuments) NewWorkflowInstanceArrayFromInterface returned: %s", err.Error())
 			return
 		}
 
 		if len(wis) == 0 {
-			err = fmt.Errorf("(LoadJob) NewWorkflowInstanceArrayFromInterface returned no WorkflowInstances for job %s", id)
+			err = fmt.Errorf("(initJobDocuments) NewWorkflowInstanceArrayFromInterface returned no WorkflowInstances for job %s", id)
 			return
 		}
 
@@ -483,28 +487,28 @@ func LoadJob(id string) (job *Job, err error) {
 			wi := wis[i]
 			if wi.ID == "" {
 				spew.Dump(wis)
-				err = fmt.Errorf("(LoadJob) wi.ID empty")
+				err = fmt.Errorf("(initJobDocuments) wi.ID empty")
 				return
 			}
 			wiChanged, err = wi.Init(job)
 			if err != nil {
-				err = fmt.Errorf("(LoadJob) wis[i].Init returned: %s", err.Error())
+				err = fmt.Errorf("(initJobDocuments) wis[i].Init returned: %s", err.Error())
 				return
 			}
 			if wiChanged {
 				err = wi.Update(true)
 				if err != nil {
-					err = fmt.Errorf("(LoadJob) wi.Update() returned: %s", err.Error())
+					err = fmt.Errorf("(initJobDocuments) wi.Update() returned: %s", err.Error())
 					return
 				}
 			}
 			// add WorkflowInstance to job
 
-			//fmt.Printf("(LoadJob) loading: %s\n", wi.LocalID)
+			//fmt.Printf("(initJobDocuments) loading: %s\n", wi.LocalID)
 
 			err = job.AddWorkflowInstance(wi, DbSyncFalse, true) // load from database
 			if err != nil {
-				err = fmt.Errorf("(LoadJob) AddWorkflowInstance returned: %s", err.Error())
+				err = fmt.Errorf("(initJobDocuments) AddWorkflowInstance returned: %s", err.Error())
 				return
 			}
 
@@ -512,7 +516,7 @@ func LoadJob(id string) (job *Job, err error) {
 
 			err = GlobalWorkflowInstanceMap.Add(wiUniqueID, wi)
 			if err != nil {
-				err = fmt.Errorf("(LoadJob) GlobalWorkflowInstanceMap.Add returned: %s", err.Error())
+				err = fmt.Errorf("(initJobDocuments) GlobalWorkflowInstanceMap.Add returned: %s", err.Error())
 				return
 			}
 
@@ -523,7 +527,7 @@ func LoadJob(id string) (job *Job, err error) {
 	if jobChanged {
 		err = job.Save()
 		if err != nil {
-			err = fmt.Errorf("(LoadJob) job.Save() returned: %s", err.Error())
+			err = fmt.Errorf("(initJobDocuments) job.Save() returned: %s", err.Error())
 			return
 		}
 	}
@@ -534,91 +538,24 @@ func LoadJob(id string) (job *Job, err error) {
 
 // LoadJobPerf _
 func LoadJobPerf(id string) (perf *JobPerf, err error) {
-	perf = new(JobPerf)
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_PERF)
-	if err = c.Find(bson.M{"id": id}).One(&perf); err == nil {
-		return perf, nil
-	}
-	return nil, err
+	return Repo.Perf.Get(id)
 }
 
 func dbGetJobTaskField(jobID string, taskID string, fieldname string, result *StructContainer) (err error) {
-
-	arrayName := "tasks"
-	idField := "taskid"
-	return dbGetJobArrayField(jobID, taskID, arrayName, idField, fieldname, result)
-}
-
-// TODO: warning: this does not cope with subfields such as "partinfo.index"
-func dbGetJobArrayField(jobID string, taskID string, arrayName string, idField string, fieldname string, result *StructContainer) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	selector := bson.M{"id": jobID}
-
-	projection := bson.M{arrayName: bson.M{"$elemMatch": bson.M{idField: taskID}}, arrayName + "." + fieldname: 1}
-	tempResult := bson.M{}
-
-	err = c.Find(selector).Select(projection).One(&tempResult)
+	result.Data, err = Repo.Tasks.GetField(jobID, taskID, fieldname)
 	if err != nil {
-		err = fmt.Errorf("(dbGetJobArrayField) Error getting field from jobID %s , %s=%s and fieldname %s: %s", jobID, idField, taskID, fieldname, err.Error())
-		return
-	}
-
-	//logger.Debug(3, "GOT: %v", temp_result)
-
-	tasksUnknown := tempResult[arrayName]
-	tasks, ok := tasksUnknown.([]interface{})
-
-	if !ok {
-		err = fmt.Errorf("(dbGetJobArrayField) Array expected, but not found")
+		err = fmt.Errorf("(dbGetJobTaskField) Error getting field from jobID %s , taskid=%s and fieldname %s: %s", jobID, taskID, fieldname, err.Error())
 		return
 	}
-	if len(tasks) == 0 {
-		err = fmt.Errorf("(dbGetJobArrayField) result task array empty")
-		return
-	}
-
-	firstTask := tasks[0].(bson.M)
-	testResult, ok := firstTask[fieldname]
-
-	if !ok {
-		err = fmt.Errorf("(dbGetJobArrayField) Field %s not in task object", fieldname)
-		return
-	}
-	result.Data = testResult
-
-	//logger.Debug(3, "GOT2: %v", result)
-	logger.Debug(3, "(dbGetJobArrayField) %s got something", fieldname)
+	logger.Debug(3, "(dbGetJobTaskField) %s got something", fieldname)
 	return
 }
 
 func dbGetJobTask(jobID string, taskID string) (result *Task, err error) {
-	dummyJob := NewJob()
-	dummyJob.Init()
-
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-
-	selector := bson.M{"id": jobID}
-	projection := bson.M{"tasks": bson.M{"$elemMatch": bson.M{"taskid": taskID}}}
-
-	err = c.Find(selector).Select(projection).One(&dummyJob)
+	result, err = Repo.Tasks.Get(jobID, taskID)
 	if err != nil {
 		err = fmt.Errorf("(dbGetJobTask) Error getting field from jobID %s , taskID %s : %s", jobID, taskID, err.Error())
-		return
 	}
-	if len(dummyJob.Tasks) != 1 {
-		err = fmt.Errorf("(dbGetJobTask) len(dummy_job.Tasks) != 1   len(dummy_job.Tasks)=%d", len(dummyJob.Tasks))
-		return
-	}
-
-	result = dummyJob.Tasks[0]
 	return
 }
 
@@ -652,14 +589,7 @@ func dbGetJobFieldString(jobID string, fieldname string) (result string, err err
 }
 
 func dbGetJobField(jobID string, fieldname string, result interface{}) (err error) {
-
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	selector := bson.M{"id": jobID}
-
-	err = c.Find(selector).Select(bson.M{fieldname: 1}).One(&result)
+	err = Repo.Jobs.GetField(jobID, fieldname, result)
 	if err != nil {
 		err = fmt.Errorf("(dbGetJobField) Error getting field from jobID %s and fieldname %s: %s", jobID, fieldname, err.Error())
 		return
@@ -674,61 +604,25 @@ type JobACL struct {
 
 // DBGetJobACL _
 func DBGetJobACL(jobID string) (_acl acl.Acl, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	selector := bson.M{"id": jobID}
-
-	job := JobACL{}
-
-	err = c.Find(selector).Select(bson.M{"acl": 1}).One(&job)
+	_acl, err = Repo.Jobs.GetACL(jobID)
 	if err != nil {
 		err = fmt.Errorf("Error getting acl field from jobID %s: %s", jobID, err.Error())
 		return
 	}
-
-	_acl = job.ACL
 	return
 }
 
 func dbGetJobFieldTime(jobID string, fieldname string) (result time.Time, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-	selector := bson.M{"id": jobID}
-
-	err = c.Find(selector).Select(bson.M{fieldname: 1}).One(&result)
-	if err != nil {
-		err = fmt.Errorf("(dbGetJobFieldTime) Error getting field from jobID %s and fieldname %s: %s", jobID, fieldname, err.Error())
-		return
-	}
+	err = dbGetJobField(jobID, fieldname, &result)
 	return
 }
 
 func dbPushJobTask(jobID string, task *Task) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_JOBS)
-
-	selector := bson.M{"id": jobID}
-	change := bson.M{"$push": bson.M{"tasks": task}}
-
-	err = c.Update(selector, change)
+	err = Repo.Tasks.Push(jobID, "", task)
 	if err != nil {
 		err = fmt.Errorf("Error adding task: " + err.Error())
 		return
 	}
-
-	//fmt.Printf("(dbPushJobTask) added task: %s\n", task.Task_Unique_Identifier.TaskName)
-	//test, _ := LoadJob(jobID)
-
-	//spew.Dump(test)
-
-	//panic("done")
-
 	return
 }
 
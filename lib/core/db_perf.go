@@ -0,0 +1,49 @@
+package core
+
+import (
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// documentPerf the performance records in conf.DB_COLL_PERF
+type documentPerf struct{}
+
+func (documentPerf) Init() error {
+	return ensureIndexes(conf.DB_COLL_PERF, []string{"id"}, nil)
+}
+
+func (documentPerf) Get(id string) (perf *JobPerf, err error) {
+	perf = new(JobPerf)
+	if err = db.C(conf.DB_COLL_PERF).Find(bson.M{"id": id}).One(&perf); err != nil {
+		perf = nil
+	}
+	return
+}
+
+func (documentPerf) Save(perf *JobPerf) (err error) {
+	if err = checkDocumentSize(perf); err != nil {
+		return
+	}
+	return db.C(conf.DB_COLL_PERF).Upsert(bson.M{"id": perf.Id}, perf)
+}
+
+func (documentPerf) Delete(id string) (err error) {
+	_, err = db.C(conf.DB_COLL_PERF).RemoveAll(bson.M{"id": id})
+	return
+}
+
+func (documentPerf) Recent(limit int) (perfs []JobPerf, err error) {
+	perfs = []JobPerf{}
+	err = db.C(conf.DB_COLL_PERF).Find(bson.M{"end": bson.M{"$gt": 0}}).Sort("-end").Limit(limit).All(&perfs)
+	return
+}
+
+func (documentPerf) GetDocument(id string) (doc bson.M, err error) {
+	err = db.C(conf.DB_COLL_PERF).Find(bson.M{"id": id}).One(&doc)
+	return
+}
+
+func (documentPerf) RestoreDocument(doc bson.M) error {
+	return db.C(conf.DB_COLL_PERF).Upsert(bson.M{"id": doc["id"]}, doc)
+}
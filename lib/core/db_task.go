@@ -35,41 +35,104 @@ import (
 // 	return
 // }
 
-func dbUpdateTaskFields(workflowInstanceUUID string, taskID string, updateValue bson.M) (err error) {
+// documentTasks the tasks in the documents of their job (conf.DB_COLL_JOBS) or their workflow
+// instance (conf.DB_COLL_SUBWORKFLOWS)
+type documentTasks struct{}
 
-	if workflowInstanceUUID == "" {
-		err = fmt.Errorf("(dbUpdateWITaskFields) workflowInstanceUUID empty ! ")
+func (documentTasks) Get(jobID string, taskID string) (task *Task, err error) {
+	dummyJob := NewJob()
+	dummyJob.Init()
+
+	selector := bson.M{"id": jobID}
+	projection := bson.M{"tasks": bson.M{"$elemMatch": bson.M{"taskid": taskID}}}
+
+	if err = db.C(conf.DB_COLL_JOBS).Find(selector).Select(projection).One(&dummyJob); err != nil {
 		return
 	}
+	if len(dummyJob.Tasks) != 1 {
+		err = fmt.Errorf("len(dummy_job.Tasks) != 1   len(dummy_job.Tasks)=%d", len(dummyJob.Tasks))
+		return
+	}
+	task = dummyJob.Tasks[0]
+	return
+}
 
-	if len(workflowInstanceUUID) != 36 {
-		err = fmt.Errorf("(dbUpdateWITaskFields) workflowInstanceUUID is not length 36: %s", workflowInstanceUUID)
+// GetField TODO: warning: this does not cope with subfields such as "partinfo.index"
+func (documentTasks) GetField(jobID string, taskID string, field string) (value interface{}, err error) {
+	selector := bson.M{"id": jobID}
+	projection := bson.M{"tasks": bson.M{"$elemMatch": bson.M{"taskid": taskID}}, "tasks." + field: 1}
+	tempResult := bson.M{}
+
+	if err = db.C(conf.DB_COLL_JOBS).Find(selector).Select(projection).One(&tempResult); err != nil {
+		return
+	}
+	tasks, ok := tempResult["tasks"].([]interface{})
+	if !ok {
+		err = fmt.Errorf("Array expected, but not found")
 		return
 	}
+	if len(tasks) == 0 {
+		err = fmt.Errorf("result task array empty")
+		return
+	}
+	firstTask, _ := asDocument(tasks[0])
+	value, ok = firstTask[field]
+	if !ok {
+		err = fmt.Errorf("Field %s not in task object", field)
+	}
+	return
+}
 
-	session := db.Connection.Session.Copy()
-	defer session.Close()
+// Push adds a task to a job, or to a workflow instance if workflowInstanceID is set
+func (documentTasks) Push(jobID string, workflowInstanceID string, task *Task) error {
+	coll, selector := conf.DB_COLL_JOBS, bson.M{"id": jobID}
+	if workflowInstanceID != "" {
+		coll, selector = conf.DB_COLL_SUBWORKFLOWS, bson.M{"id": workflowInstanceID}
+	}
+	change := bson.M{"$push": bson.M{"tasks": task}, "$set": bson.M{"updatetime": time.Now()}}
+	return db.C(coll).Update(selector, change)
+}
 
-	database := conf.DB_COLL_SUBWORKFLOWS
+// UpdateFields sets fields of a task of a job, or of a workflow instance if workflowInstanceID is set
+func (documentTasks) UpdateFields(jobID string, workflowInstanceID string, taskID string, fields bson.M) error {
+	coll, selector := conf.DB_COLL_JOBS, bson.M{"id": jobID, "tasks.taskid": taskID}
+	if workflowInstanceID != "" {
+		coll, selector = conf.DB_COLL_SUBWORKFLOWS, bson.M{"id": workflowInstanceID, "tasks.taskid": taskID}
+	}
+	updateValue := bson.M{"updatetime": time.Now()} // read by the warm standby
+	for field, value := range fields {
+		updateValue["tasks.$."+field] = value
+	}
+	return db.C(coll).Update(selector, bson.M{"$set": updateValue})
+}
 
-	c := session.DB(conf.MONGODB_DATABASE).C(database)
+func (documentTasks) Increment(jobID string, taskID string, field string, value int) error {
+	selector := bson.M{"id": jobID, "tasks.taskid": taskID}
+	return db.C(conf.DB_COLL_JOBS).Update(selector, bson.M{"$inc": bson.M{"tasks.$." + field: value}, "$set": bson.M{"updatetime": time.Now()}})
+}
 
-	selector := bson.M{"id": workflowInstanceUUID, "tasks.taskid": taskID}
-	updateOp := bson.M{"$set": updateValue}
+func dbUpdateTaskFields(workflowInstanceUUID string, taskID string, updateValue bson.M) (err error) {
 
-	//fmt.Println("(dbUpdateTaskFields) updateValue:")
-	//spew.Dump(updateValue)
+	if workflowInstanceUUID == "" {
+		err = fmt.Errorf("(dbUpdateWITaskFields) workflowInstanceUUID empty ! ")
+		return
+	}
+
+	if len(workflowInstanceUUID) != 36 {
+		err = fmt.Errorf("(dbUpdateWITaskFields) workflowInstanceUUID is not length 36: %s", workflowInstanceUUID)
+		return
+	}
 
-	err = c.Update(selector, updateOp)
+	err = Repo.Tasks.UpdateFields("", workflowInstanceUUID, taskID, updateValue)
 	if err != nil {
-		err = fmt.Errorf("(dbUpdateWITaskFields) (db: %s) Error updating task %s (workflowInstanceUUID: %s): %s", database, taskID, workflowInstanceUUID, err.Error())
+		err = fmt.Errorf("(dbUpdateWITaskFields) Error updating task %s (workflowInstanceUUID: %s): %s", taskID, workflowInstanceUUID, err.Error())
 		return
 	}
 	return
 }
 
 func dbUpdateTaskTime(workflowInstanceID string, taskID string, fieldname string, value time.Time) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateTaskFields(workflowInstanceID, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateTaskTime) dbUpdateTaskFields returned: %s", err.Error())
@@ -78,7 +141,7 @@ func dbUpdateTaskTime(workflowInstanceID string, taskID string, fieldname string
 }
 
 func dbUpdateTaskString(workflowInstanceID string, taskID string, fieldname string, value string) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateTaskFields(workflowInstanceID, taskID, updateValue)
 
 	if err != nil {
@@ -88,7 +151,7 @@ func dbUpdateTaskString(workflowInstanceID string, taskID string, fieldname stri
 }
 
 func dbUpdateTaskBoolean(workflowInstanceID string, taskID string, fieldname string, value bool) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateTaskFields(workflowInstanceID, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateTaskBoolean) dbUpdateTaskFields returned: %s", err.Error())
@@ -98,7 +161,7 @@ func dbUpdateTaskBoolean(workflowInstanceID string, taskID string, fieldname str
 }
 
 func dbUpdateTaskIO(workflowInstanceID string, taskID string, fieldname string, value []*IO) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateTaskFields(workflowInstanceID, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateJobTaskIO) dbUpdateTaskFields returned: %s", err.Error())
@@ -107,7 +170,7 @@ func dbUpdateTaskIO(workflowInstanceID string, taskID string, fieldname string,
 }
 
 func dbUpdateTaskField(workflowInstanceID, taskID string, fieldname string, value interface{}) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateTaskFields(workflowInstanceID, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateTaskField) dbUpdateTaskFields returned: %s", err.Error())
@@ -117,7 +180,7 @@ func dbUpdateTaskField(workflowInstanceID, taskID string, fieldname string, valu
 }
 
 func dbUpdateTaskInt(workflowInstanceID, taskID string, fieldname string, value int) (err error) {
-	updateValue := bson.M{"tasks.$." + fieldname: value}
+	updateValue := bson.M{fieldname: value}
 	err = dbUpdateTaskFields(workflowInstanceID, taskID, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateTaskInt) dbUpdateTaskFields returned: %s", err.Error())
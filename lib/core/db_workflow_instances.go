@@ -2,105 +2,110 @@ package core
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core/cwl"
 	"github.com/MG-RAST/AWE/lib/db"
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-// DBGetJobWorkflowInstances _
-func DBGetJobWorkflowInstances(q bson.M, options *DefaultQueryOptions, doInit bool) (results []interface{}, count int, err error) {
+// documentWorkflowInstances the workflow instances in conf.DB_COLL_SUBWORKFLOWS
+type documentWorkflowInstances struct{}
 
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_SUBWORKFLOWS)
-	query := c.Find(q)
-	count, err = query.Count()
-	if err != nil {
-		results = nil
-		err = fmt.Errorf("(DBGetJobWorkflowInstances) query.Count returned: %s", err.Error())
-		return
-	}
+func (documentWorkflowInstances) Init() error {
+	//id not needed, already got _id
+	return ensureIndexes(conf.DB_COLL_SUBWORKFLOWS, nil, []string{"job_id", "updatetime"})
+}
 
-	if len(options.Sort) > 0 {
-		// this allows to provide multiple sort fields
-		query = query.Sort(options.Sort...)
+func (documentWorkflowInstances) Insert(wi *WorkflowInstance) (err error) {
+	if err = checkDocumentSize(wi); err != nil {
+		return
 	}
+	wi.UpdateTime = time.Now()
+	return db.C(conf.DB_COLL_SUBWORKFLOWS).Insert(&wi)
+}
 
-	// options.Limit is always defined
-	query = query.Limit(options.Limit)
-
-	// options.Offset is always defined
-	query = query.Skip(options.Offset)
-
-	//var results []WorkflowInstance
-	results = []interface{}{}
-
-	err = query.All(&results)
-	if err != nil {
-		err = fmt.Errorf("query.All(results) failed: %s", err.Error())
+func (documentWorkflowInstances) Update(wi *WorkflowInstance) (err error) {
+	if err = checkDocumentSize(wi); err != nil {
 		return
 	}
-	if results == nil {
-		err = fmt.Errorf("(dbFindSort) results == nil")
+	wi.UpdateTime = time.Now()
+	return db.C(conf.DB_COLL_SUBWORKFLOWS).Update(bson.M{"id": wi.ID}, &wi)
+}
+
+func (documentWorkflowInstances) Find(filter bson.M, options *DefaultQueryOptions) (results []interface{}, count int, err error) {
+	query := db.C(conf.DB_COLL_SUBWORKFLOWS).Find(filter)
+	if count, err = query.Count(); err != nil {
 		return
 	}
+	results = []interface{}{}
+	err = withOptions(query, options).All(&results)
+	return
+}
 
-	// if do_init {
-	// 	var count_changed int
-
-	// 	for i, _ := range results {
-	// 		var changed bool
-	// 		changed, err = results[i].Init(nil)
-	// 		if err != nil {
-	// 			err = fmt.Errorf("results[i].Init(nil) failed: %s", err.Error())
-	// 			return
-	// 		}
-	// 		if changed {
-	// 			count_changed += 1
-	// 			results[i].Save(true)
-	// 		}
-	// 	}
-	// 	logger.Debug(1, "%d jobs haven been updated by Init function", count_changed)
-
-	// }
-
+func (documentWorkflowInstances) FindOne(filter bson.M) (result interface{}, err error) {
+	err = db.C(conf.DB_COLL_SUBWORKFLOWS).Find(filter).One(&result)
 	return
 }
 
-// DBGetJobWorkflowInstanceOne _
-func DBGetJobWorkflowInstanceOne(q bson.M, options *DefaultQueryOptions, doInit bool) (result interface{}, err error) {
+func (documentWorkflowInstances) UpdateFields(id string, fields bson.M) error {
+	fields["updatetime"] = time.Now() // read by the warm standby
+	return db.C(conf.DB_COLL_SUBWORKFLOWS).Update(bson.M{"id": id}, bson.M{"$set": fields})
+}
 
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_SUBWORKFLOWS)
-	query := c.Find(q)
-	// count, err = query.Count()
-	// if err != nil {
-	// 	result = nil
-	// 	err = fmt.Errorf("(DBGetJobWorkflow_instance_One) query.Count returned: %s", err.Error())
-	// 	return
-	// }
+func (documentWorkflowInstances) Increment(id string, field string, value int) error {
+	return db.C(conf.DB_COLL_SUBWORKFLOWS).Update(bson.M{"id": id}, bson.M{"$inc": bson.M{field: value}, "$set": bson.M{"updatetime": time.Now()}})
+}
 
-	// if len(options.Sort) > 0 {
-	// 	// this allows to provide multiple sort fields
-	// 	query = query.Sort(options.Sort...)
-	// }
+func (documentWorkflowInstances) DeleteJob(jobID string) (err error) {
+	_, err = db.C(conf.DB_COLL_SUBWORKFLOWS).RemoveAll(bson.M{"job_id": jobID})
+	return
+}
+
+func (documentWorkflowInstances) GetDocuments(jobIDs []string, fields []string) (docs []bson.M, err error) {
+	query := db.C(conf.DB_COLL_SUBWORKFLOWS).Find(bson.M{"job_id": bson.M{"$in": jobIDs}})
+	if p := projection(fields); p != nil {
+		query = query.Select(p)
+	}
+	docs = []bson.M{}
+	err = query.All(&docs)
+	return
+}
 
-	// options.Limit is always defined
-	//query = query.Limit(options.Limit)
+func (documentWorkflowInstances) RestoreDocument(doc bson.M) error {
+	return db.C(conf.DB_COLL_SUBWORKFLOWS).Upsert(bson.M{"job_id": doc["job_id"], "id": doc["id"]}, doc)
+}
 
-	// options.Offset is always defined
-	//query = query.Skip(options.Offset)
+func (documentWorkflowInstances) JobsUpdatedSince(t time.Time) (jobIDs []string, err error) {
+	docs := []struct {
+		JobID string `bson:"job_id"`
+	}{}
+	if err = db.C(conf.DB_COLL_SUBWORKFLOWS).Find(bson.M{"updatetime": bson.M{"$gte": t}}).Select(bson.M{"job_id": 1}).All(&docs); err != nil {
+		return
+	}
+	for _, doc := range docs {
+		jobIDs = append(jobIDs, doc.JobID)
+	}
+	return
+}
 
-	//var results []WorkflowInstance
-	//result = interface{}
+// DBGetJobWorkflowInstances _
+func DBGetJobWorkflowInstances(q bson.M, options *DefaultQueryOptions, doInit bool) (results []interface{}, count int, err error) {
+	results, count, err = Repo.WorkflowInstances.Find(q, options)
+	if err != nil {
+		results = nil
+		err = fmt.Errorf("(DBGetJobWorkflowInstances) Repo.WorkflowInstances.Find returned: %s", err.Error())
+		return
+	}
+	return
+}
 
-	err = query.One(&result)
+// DBGetJobWorkflowInstanceOne _
+func DBGetJobWorkflowInstanceOne(q bson.M, options *DefaultQueryOptions, doInit bool) (result interface{}, err error) {
+	result, err = Repo.WorkflowInstances.FindOne(q)
 	if err != nil {
-		err = fmt.Errorf("(DBGetJobWorkflowInstanceOne) query.All(results) failed: %s", err.Error())
+		err = fmt.Errorf("(DBGetJobWorkflowInstanceOne) Repo.WorkflowInstances.FindOne failed: %s", err.Error())
 		return
 	}
 	if result == nil {
@@ -111,22 +116,11 @@ func DBGetJobWorkflowInstanceOne(q bson.M, options *DefaultQueryOptions, doInit
 }
 
 func dbPushTask(subworkflowIdentifier string, task *Task) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_SUBWORKFLOWS)
-
-	//unique_id := jobID + "_" + subworkflow_id
-	selector := bson.M{"id": subworkflowIdentifier}
-
-	change := bson.M{"$push": bson.M{"tasks": task}}
-
-	err = c.Update(selector, change)
+	err = Repo.Tasks.Push("", subworkflowIdentifier, task)
 	if err != nil {
 		err = fmt.Errorf("(dbPushTask) Error adding task: " + err.Error())
 		return
 	}
-
 	return
 }
 
@@ -161,27 +155,11 @@ func dbUpdateWorkflowInstancesField(subworkflowIdentifier string, fieldname stri
 }
 
 func dbIncrementWorkflowInstancesField(subworkflowIdentifier string, field string, value int) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_SUBWORKFLOWS)
-	//selector := bson.M{"id": job_id, "workflow_instances.id": subworkflow_id}
-	//unique_id := job_id + "_" + subworkflow_id
-	selector := bson.M{"id": subworkflowIdentifier}
-	//err = c.Update(selector, bson.M{"$set": updateValue})
-
-	change := mgo.Change{
-		Update:    bson.M{"$inc": bson.M{field: value}},
-		ReturnNew: true,
-	}
-	//var info *mgo.ChangeInfo
-
-	_, err = c.Find(selector).Apply(change, nil)
+	err = Repo.WorkflowInstances.Increment(subworkflowIdentifier, field, value)
 	if err != nil {
 		err = fmt.Errorf("(dbIncrementWorkflow_instancesField) Error updating workflow_instance %s  (field %s, value: %d): %s", subworkflowIdentifier, field, value, err.Error())
 		return
 	}
-
 	return
 }
 
@@ -194,14 +172,7 @@ func dbIncrementWorkflowInstancesField(subworkflowIdentifier string, field strin
 // dbUpdateWorkflowInstancesFields _
 
 func dbUpdateWorkflowInstancesFields(subworkflowIdentifier string, updateValue bson.M) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-
-	c := session.DB(conf.MONGODB_DATABASE).C(conf.DB_COLL_SUBWORKFLOWS)
-	//unique_id := job_id + "_" + subworkflow_id
-	selector := bson.M{"id": subworkflowIdentifier}
-
-	err = c.Update(selector, bson.M{"$set": updateValue})
+	err = Repo.WorkflowInstances.UpdateFields(subworkflowIdentifier, updateValue)
 	if err != nil {
 		err = fmt.Errorf("(dbUpdateWorkflowInstancesFields) Error updating workflow_instance (_id: %s): %s", subworkflowIdentifier, err.Error())
 		return
@@ -0,0 +1,351 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// runtimeHistoryMax the number of recent runtimes kept per tool
+const runtimeHistoryMax = 20
+
+// DeadlineStatus the projected completion of a job with a deadline
+type DeadlineStatus struct {
+	Projected time.Time `bson:"projected" json:"projected"` // projected completion time, completion time of finished jobs
+	Remaining int64     `bson:"remaining" json:"remaining"` // estimated seconds of work left on the critical path
+	AtRisk    bool      `bson:"at_risk" json:"at_risk"`     // the deadline is predicted to be missed
+	Missed    bool      `bson:"missed" json:"missed"`
+	Updated   time.Time `bson:"updated" json:"updated"`
+}
+
+// runtimeHistory recent task runtimes in seconds per tool
+type runtimeHistory struct {
+	sync.Mutex
+	runtimes map[string][]int64
+}
+
+// taskRuntimes the runtime history of the server
+var taskRuntimes = &runtimeHistory{runtimes: map[string][]int64{}}
+
+// add records a runtime of a tool
+func (h *runtimeHistory) add(tool string, seconds int64) {
+	if tool == "" || seconds < 0 {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	runtimes := append(h.runtimes[tool], seconds)
+	if len(runtimes) > runtimeHistoryMax {
+		runtimes = runtimes[len(runtimes)-runtimeHistoryMax:]
+	}
+	h.runtimes[tool] = runtimes
+}
+
+// estimate the mean runtime of a tool, deadline_default_runtime if it has no history
+func (h *runtimeHistory) estimate(tool string) int64 {
+	h.Lock()
+	defer h.Unlock()
+	runtimes := h.runtimes[tool]
+	if len(runtimes) == 0 {
+		return int64(conf.DEADLINE_DEFAULT_RUNTIME)
+	}
+	var total int64
+	for _, runtime := range runtimes {
+		total += runtime
+	}
+	return total / int64(len(runtimes))
+}
+
+// load reads the task runtimes of the deadline_history most recently completed jobs
+func (h *runtimeHistory) load() (err error) {
+	perfs, err := Repo.Perf.Recent(conf.DEADLINE_HISTORY)
+	if err != nil {
+		err = fmt.Errorf("(runtimeHistory/load) Repo.Perf.Recent returned: %s", err.Error())
+		return
+	}
+	// oldest first, so the most recent runtimes are kept
+	for i := len(perfs) - 1; i >= 0; i-- {
+		for _, taskPerf := range perfs[i].Ptasks {
+			if taskPerf != nil && taskPerf.Start > 0 && taskPerf.End >= taskPerf.Start {
+				h.add(taskPerf.Tool, taskPerf.End-taskPerf.Start)
+			}
+		}
+	}
+	return
+}
+
+// taskTool the key of the runtime history of a task: the pipeline (the workflow file of CWL jobs) and the
+// tool the task runs, see stepTool for CWL steps and the command of AWE tasks. Step ids are not used
+// alone, the same step id names different tools in different workflows.
+func taskTool(task *Task) string {
+	tool := ""
+	if task.WorkflowStep != nil {
+		tool = stepTool(task.WorkflowStep, task.WorkflowStepID)
+	} else if task.Cmd != nil {
+		tool = task.Cmd.Name
+	}
+	pipeline := ""
+	if task.Info != nil {
+		pipeline = task.Info.Pipeline
+	}
+	return runtimeKey(pipeline, tool)
+}
+
+// stepTool the tool a CWL step runs: the base command or id of the tool, the step id if it has neither
+func stepTool(step *cwl.WorkflowStep, stepID string) (tool string) {
+	switch run := step.Run.(type) {
+	case string:
+		tool = run
+	case *cwl.CommandLineTool:
+		tool = strings.Join(run.BaseCommand, " ")
+		if tool == "" {
+			tool = run.ID
+		}
+	case *cwl.ExpressionTool:
+		tool = run.ID
+	}
+	if tool == "" {
+		tool = stepID
+	}
+	return
+}
+
+// runtimeKey the key of the runtime history of a tool in a pipeline, empty without a tool
+func runtimeKey(pipeline string, tool string) string {
+	if tool == "" {
+		return ""
+	}
+	return pipeline + ":" + tool
+}
+
+// DeadlineLoop projects the completion of the jobs with a deadline every deadline_interval
+func (qm *ServerMgr) DeadlineLoop() {
+	logger.Info("(DeadlineLoop) starting")
+	if conf.DEADLINE_HISTORY > 0 {
+		if err := taskRuntimes.load(); err != nil {
+			logger.Error("(DeadlineLoop) %s", err.Error())
+		}
+	}
+	for {
+		time.Sleep(time.Duration(conf.DEADLINE_INTERVAL) * time.Second)
+		if err := qm.checkDeadlines(time.Now()); err != nil {
+			logger.Error("(DeadlineLoop) checkDeadlines returned: %s", err.Error())
+		}
+	}
+}
+
+// checkDeadlines updates the projected completion of the unfinished jobs with a deadline
+func (qm *ServerMgr) checkDeadlines(now time.Time) (err error) {
+	jobs, err := JM.Get_List(true)
+	if err != nil {
+		return
+	}
+	var started map[Task_Unique_Identifier]time.Time
+	var tasks map[string][]*Task
+	for _, job := range jobs {
+		if job.Info == nil || job.Info.Deadline.IsZero() {
+			continue
+		}
+		state, xerr := job.GetState(true)
+		if xerr != nil || contains(JOB_STATS_FINAL, state) {
+			continue
+		}
+		if started == nil {
+			started, err = qm.checkoutTimes()
+			if err != nil {
+				return
+			}
+			tasks, err = qm.activeTasks()
+			if err != nil {
+				return
+			}
+		}
+		remaining := projectRemaining(job, state, tasks[job.ID], started, now)
+		projected := now.Add(time.Duration(remaining) * time.Second)
+		if xerr = updateDeadlineStatus(job, projected, remaining, now); xerr != nil {
+			logger.Error("(checkDeadlines) job %s: updateDeadlineStatus returned: %s", job.ID, xerr.Error())
+		}
+	}
+	return
+}
+
+// checkoutTimes the first checkout of the tasks with checked out workunits
+func (qm *ServerMgr) checkoutTimes() (started map[Task_Unique_Identifier]time.Time, err error) {
+	works, err := qm.workQueue.Checkout.GetWorkunits()
+	if err != nil {
+		return
+	}
+	started = map[Task_Unique_Identifier]time.Time{}
+	for _, work := range works {
+		if work.CheckoutTime.IsZero() {
+			continue
+		}
+		id := work.GetTask()
+		if first, ok := started[id]; !ok || work.CheckoutTime.Before(first) {
+			started[id] = work.CheckoutTime
+		}
+	}
+	return
+}
+
+// activeTasks the tasks in the task map by job. Scatter tasks that have been expanded are represented by
+// their children.
+func (qm *ServerMgr) activeTasks() (tasks map[string][]*Task, err error) {
+	all, err := qm.TaskMap.GetTasks()
+	if err != nil {
+		return
+	}
+	tasks = map[string][]*Task{}
+	for _, task := range all {
+		if task.ProcessType == ProcessTypeScatter && len(task.ScatterChildren) > 0 {
+			continue
+		}
+		tasks[task.JobId] = append(tasks[task.JobId], task)
+	}
+	return
+}
+
+// taskRemaining the estimated seconds until a task completes, tasks in progress are credited with the time
+// since the first checkout of their workunits
+func taskRemaining(task *Task, started map[Task_Unique_Identifier]time.Time, now time.Time) (seconds int64) {
+	lock, err := task.RLockNamed("taskRemaining")
+	if err != nil {
+		return
+	}
+	state := task.State
+	tool := taskTool(task)
+	id := task.Task_Unique_Identifier
+	task.RUnlockNamed(lock)
+
+	switch state {
+	case TASK_STAT_COMPLETED, TASK_STAT_SKIPPED, TASK_STAT_FAIL_SKIP, TASK_STAT_PASSED:
+		return 0
+	}
+	seconds = taskRuntimes.estimate(tool)
+	if start, ok := started[id]; ok {
+		seconds -= int64(now.Sub(start).Seconds())
+		if seconds < 0 {
+			seconds = 0
+		}
+	}
+	return
+}
+
+// projectRemaining the estimated seconds of work left on the critical path of the active tasks of a job.
+// Tasks of AWE jobs run after the tasks they depend on, CWL steps are assumed to run one after another.
+// The steps of CWL jobs whose workflow has not been instantiated yet are estimated from the workflow.
+func projectRemaining(job *Job, state string, tasks []*Task, started map[Task_Unique_Identifier]time.Time, now time.Time) (remaining int64) {
+	if job.IsCWL {
+		if len(tasks) == 0 && state != JOB_STAT_INPROGRESS && job.CWL_workflow != nil {
+			pipeline := ""
+			if job.Info != nil {
+				pipeline = job.Info.Pipeline
+			}
+			for i := range job.CWL_workflow.Steps {
+				step := &job.CWL_workflow.Steps[i]
+				remaining += taskRuntimes.estimate(runtimeKey(pipeline, stepTool(step, step.ID)))
+			}
+			return
+		}
+		for _, task := range tasks {
+			remaining += taskRemaining(task, started, now)
+		}
+		return
+	}
+
+	byID := map[string]*Task{}
+	for _, task := range tasks {
+		if id, xerr := task.String(); xerr == nil {
+			byID[id] = task
+		}
+	}
+	finish := map[string]int64{}
+	var path func(id string, depth int) int64
+	path = func(id string, depth int) int64 {
+		if f, ok := finish[id]; ok {
+			return f
+		}
+		task, ok := byID[id]
+		if !ok || depth > len(byID) { // unknown task or a cycle
+			return 0
+		}
+		var before int64
+		dependsOn, _ := task.GetDependsOn()
+		for _, dep := range dependsOn {
+			if f := path(dep, depth+1); f > before {
+				before = f
+			}
+		}
+		finish[id] = before + taskRemaining(task, started, now)
+		return finish[id]
+	}
+	for id := range byID {
+		if f := path(id, 0); f > remaining {
+			remaining = f
+		}
+	}
+	return
+}
+
+// updateDeadlineStatus saves the projection of a job and emits an event when the job becomes at risk of
+// missing its deadline or misses it. The workunits of jobs at risk get deadline_boost.
+func updateDeadlineStatus(job *Job, projected time.Time, remaining int64, now time.Time) (err error) {
+	err = job.LockNamed("updateDeadlineStatus")
+	if err != nil {
+		return
+	}
+	defer job.Unlock()
+
+	deadline := job.Info.Deadline
+	if deadline.IsZero() {
+		return
+	}
+	previous := job.DeadlineStatus
+	if previous == nil {
+		previous = &DeadlineStatus{}
+	}
+	status := &DeadlineStatus{
+		Projected: projected,
+		Remaining: remaining,
+		Missed:    now.After(deadline),
+		Updated:   now,
+	}
+	status.AtRisk = status.Missed || (remaining > 0 && projected.Add(time.Duration(conf.DEADLINE_MARGIN)*time.Second).After(deadline))
+
+	if status.AtRisk && !status.Missed && !previous.AtRisk {
+		logger.Event(event.JOB_DEADLINE_RISK, "jobid="+job.ID+";deadline="+deadline.Format(time.RFC3339)+";projected="+projected.Format(time.RFC3339))
+	}
+	if status.Missed && !previous.Missed {
+		logger.Event(event.JOB_DEADLINE_MISSED, "jobid="+job.ID+";deadline="+deadline.Format(time.RFC3339))
+	}
+
+	err = dbUpdateJobFields(job.ID, bson.M{"deadline_status": status})
+	if err != nil {
+		return
+	}
+	job.DeadlineStatus = status
+	boost := 0
+	if status.AtRisk {
+		boost = conf.DEADLINE_BOOST
+	}
+	job.Info.setDeadlineBoost(boost)
+	return
+}
+
+// deadlineCompleted records the completion time of a job with a deadline
+func deadlineCompleted(job *Job) {
+	if job.Info == nil || job.Info.Deadline.IsZero() {
+		return
+	}
+	now := time.Now()
+	if err := updateDeadlineStatus(job, now, 0, now); err != nil {
+		logger.Error("(deadlineCompleted) job %s: updateDeadlineStatus returned: %s", job.ID, err.Error())
+	}
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+)
+
+const deadlineTestJob = "6b5a4c3d-2e1f-4a0b-8c9d-7e6f5a4b3c2d"
+
+func TestTaskTool(t *testing.T) {
+	tests := []struct {
+		name string
+		step *cwl.WorkflowStep
+		cmd  string
+		want string
+	}{
+		{"AWE command", nil, "bowtie2", "pipe.cwl:bowtie2"},
+		{"AWE without command", nil, "", ""},
+		{"base command", &cwl.WorkflowStep{Run: &cwl.CommandLineTool{BaseCommand: []string{"samtools", "sort"}}}, "", "pipe.cwl:samtools sort"},
+		{"tool id", &cwl.WorkflowStep{Run: &cwl.CommandLineTool{ID: "#sort.cwl"}}, "", "pipe.cwl:#sort.cwl"},
+		{"expression tool", &cwl.WorkflowStep{Run: &cwl.ExpressionTool{ID: "#merge.cwl"}}, "", "pipe.cwl:#merge.cwl"},
+		{"run file", &cwl.WorkflowStep{Run: "tools/sort.cwl"}, "", "pipe.cwl:tools/sort.cwl"},
+		{"step id", &cwl.WorkflowStep{}, "", "pipe.cwl:#main/sort"},
+	}
+	for _, test := range tests {
+		task := &Task{TaskRaw: TaskRaw{Info: &Info{Pipeline: "pipe.cwl"}}}
+		if test.step != nil {
+			task.WorkflowStep = test.step
+			task.WorkflowStepID = "#main/sort"
+		} else if test.cmd != "" {
+			task.Cmd = &Command{Name: test.cmd}
+		}
+		if got := taskTool(task); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProjectRemaining(t *testing.T) {
+	defer conftest.Save(&taskRuntimes, &conf.DEADLINE_DEFAULT_RUNTIME)()
+	conf.DEADLINE_DEFAULT_RUNTIME = 100
+	taskRuntimes = &runtimeHistory{runtimes: map[string][]int64{}}
+	taskRuntimes.add("pipe.cwl:samtools sort", 30)
+	taskRuntimes.add("pipe.cwl:samtools sort", 50)
+	taskRuntimes.add("#main/index", 7) // step ids alone are not keys
+	taskRuntimes.add("awe:align", 60)
+	taskRuntimes.add("awe:count", 10)
+	if got := taskRuntimes.estimate("pipe.cwl:samtools sort"); got != 40 {
+		t.Errorf("estimate returned %d, want 40", got)
+	}
+	now := time.Now()
+
+	// a CWL job whose workflow is not instantiated yet
+	cwlJob := NewJob()
+	cwlJob.ID = deadlineTestJob
+	cwlJob.IsCWL = true
+	cwlJob.Info.Pipeline = "pipe.cwl"
+	cwlJob.CWL_workflow = &cwl.Workflow{Steps: []cwl.WorkflowStep{
+		{ID: "#main/sort", Run: &cwl.CommandLineTool{BaseCommand: []string{"samtools", "sort"}}},
+		{ID: "#main/index", Run: &cwl.CommandLineTool{BaseCommand: []string{"samtools", "index"}}},
+	}}
+	if got := projectRemaining(cwlJob, JOB_STAT_QUEUED, nil, nil, now); got != 140 {
+		t.Errorf("CWL job: got %d, want 140", got)
+	}
+
+	// an AWE job: align, then count and a second count in parallel, then merge (no history)
+	job := NewJob()
+	job.ID = deadlineTestJob
+	job.Entrypoint = "#main"
+	job.Info.Pipeline = "awe"
+	tasks := []*Task{}
+	for _, spec := range []struct {
+		name    string
+		cmd     string
+		state   string
+		depends []string
+	}{
+		{"0", "align", TASK_STAT_INPROGRESS, nil},
+		{"1", "count", TASK_STAT_QUEUED, []string{"0"}},
+		{"2", "count", TASK_STAT_QUEUED, []string{"0"}},
+		{"3", "merge", TASK_STAT_PENDING, []string{"1", "2"}},
+		{"4", "count", TASK_STAT_COMPLETED, nil},
+	} {
+		task, err := NewTask(job, "", "", spec.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		task.Cmd = &Command{Name: spec.cmd}
+		task.State = spec.state
+		for _, dep := range spec.depends {
+			task.DependsOn = append(task.DependsOn, deadlineTestJob+"_"+dep)
+		}
+		tasks = append(tasks, task)
+	}
+	started := map[Task_Unique_Identifier]time.Time{tasks[0].Task_Unique_Identifier: now.Add(-20 * time.Second)}
+	// align 60-20, count 10, merge 100
+	if got := projectRemaining(job, JOB_STAT_INPROGRESS, tasks, started, now); got != 150 {
+		t.Errorf("AWE job: got %d, want 150", got)
+	}
+}
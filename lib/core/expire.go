@@ -1,13 +1,14 @@
 package core
 
 import (
+	"fmt"
 	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/logger/event"
-	"gopkg.in/mgo.v2/bson"
 )
 
 var (
@@ -17,6 +18,25 @@ var (
 	ExpireRegex = regexp.MustCompile(`^(\d+)(M|H|D)$`)
 )
 
+// ExpireDuration parses a number and unit of time (M, H or D), e.g. 30D
+func ExpireDuration(expire string) (d time.Duration, err error) {
+	parts := ExpireRegex.FindStringSubmatch(expire)
+	if len(parts) == 0 {
+		err = fmt.Errorf("expiration format '%s' is invalid", expire)
+		return
+	}
+	num, _ := strconv.Atoi(parts[1])
+	switch parts[2] {
+	case "M":
+		d = time.Duration(num) * time.Minute
+	case "H":
+		d = time.Duration(num) * time.Hour
+	case "D":
+		d = time.Duration(num*24) * time.Hour
+	}
+	return
+}
+
 // InitReaper _
 func InitReaper() {
 	Ttl = NewJobReaper()
@@ -36,25 +56,33 @@ func (jr *JobReaper) Handle() {
 	for {
 		// sleep
 		time.Sleep(waitDuration)
-		// query to get expired jobs
-		jobs := Jobs{}
-		query := jr.getQuery()
-		jobs.GetAllUnsorted(query)
-		// delete expired jobs
-		for _, j := range jobs {
-			logger.Event(event.JOB_EXPIRED, "jobid="+j.ID)
-			if err := j.Delete(); err != nil {
-				logger.Error("Err@job_delete: " + err.Error())
+		// expired jobs of the retention policy
+		decisions, err := Retention.Due(time.Now(), 0)
+		if err != nil {
+			logger.Error("Err@job_expire: " + err.Error())
+		}
+		for _, d := range decisions {
+			logger.Event(event.JOB_EXPIRED, "jobid="+d.JobID+";rule="+d.Rule+";action="+d.Action)
+			if err := applyRetention(d); err != nil {
+				logger.Error("Err@job_expire: " + err.Error())
+			}
+		}
+		// delete expired archived jobs
+		ids, err := jr.getArchived()
+		if err != nil {
+			logger.Error("Err@archive_expire: " + err.Error())
+			continue
+		}
+		for _, id := range ids {
+			logger.Event(event.JOB_EXPIRED, "jobid="+id)
+			if err := DeleteArchivedJob(id); err != nil {
+				logger.Error("Err@archive_delete: " + err.Error())
 			}
 		}
 	}
 }
 
-func (jr *JobReaper) getQuery() (query bson.M) {
-	isCompOrDel := bson.M{"$or": []bson.M{bson.M{"state": JOB_STAT_COMPLETED}, bson.M{"state": JOB_STAT_DELETED}}} // job in completed or deleted state
-	hasExpire := bson.M{"expiration": bson.M{"$exists": true}}                                                     // has the field
-	toExpire := bson.M{"expiration": bson.M{"$ne": time.Time{}}}                                                   // value has been set, not default
-	isExpired := bson.M{"expiration": bson.M{"$lt": time.Now()}}                                                   // value is too old
-	query = bson.M{"$and": []bson.M{isCompOrDel, hasExpire, toExpire, isExpired}}
-	return
+// getArchived the ids of the expired archived jobs
+func (jr *JobReaper) getArchived() (ids []string, err error) {
+	return Repo.Archive.Expired(time.Now())
 }
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/MG-RAST/AWE/lib/conf"
@@ -8,24 +9,37 @@ import (
 
 //Info job info
 type Info struct {
-	Name          string                 `bson:"name" json:"name" mapstructure:"name"`
-	Xref          string                 `bson:"xref" json:"xref" mapstructure:"xref"`
-	Service       string                 `bson:"service" json:"service" mapstructure:"service"`
-	Project       string                 `bson:"project" json:"project" mapstructure:"project"`
-	User          string                 `bson:"user" json:"user" mapstructure:"user"`
-	Pipeline      string                 `bson:"pipeline" json:"pipeline" mapstructure:"pipeline"` // or workflow
-	ClientGroups  string                 `bson:"clientgroups" json:"clientgroups" mapstructure:"clientgroups"`
-	SubmitTime    time.Time              `bson:"submittime" json:"submittime" mapstructure:"submittime"`
-	StartedTime   time.Time              `bson:"startedtime" json:"startedtime" mapstructure:"startedtime"`
-	CompletedTime time.Time              `bson:"completedtime" json:"completedtime" mapstructure:"completedtime"`
-	Priority      int                    `bson:"priority" json:"priority" mapstructure:"priority"`
-	Auth          bool                   `bson:"auth" json:"auth" mapstructure:"auth"`
-	DataToken     string                 `bson:"datatoken" json:"-" mapstructure:"-"`
-	NoRetry       bool                   `bson:"noretry" json:"noretry" mapstructure:"noretry"`
-	UserAttr      map[string]interface{} `bson:"userattr" json:"userattr" mapstructure:"userattr"`
-	Description   string                 `bson:"description" json:"description" mapstructure:"description"`
-	Tracking      bool                   `bson:"tracking" json:"tracking" mapstructure:"tracking"`
-	StartAt       time.Time              `bson:"start_at" json:"start_at" mapstructure:"start_at"` // will start tasks at this timepoint or shortly after
+	Name              string                 `bson:"name" json:"name" mapstructure:"name"`
+	Xref              string                 `bson:"xref" json:"xref" mapstructure:"xref"`
+	Service           string                 `bson:"service" json:"service" mapstructure:"service"`
+	Project           string                 `bson:"project" json:"project" mapstructure:"project"`
+	User              string                 `bson:"user" json:"user" mapstructure:"user"`
+	Pipeline          string                 `bson:"pipeline" json:"pipeline" mapstructure:"pipeline"` // or workflow
+	ClientGroups      string                 `bson:"clientgroups" json:"clientgroups" mapstructure:"clientgroups"`
+	SubmitTime        time.Time              `bson:"submittime" json:"submittime" mapstructure:"submittime"`
+	StartedTime       time.Time              `bson:"startedtime" json:"startedtime" mapstructure:"startedtime"`
+	CompletedTime     time.Time              `bson:"completedtime" json:"completedtime" mapstructure:"completedtime"`
+	Priority          int                    `bson:"priority" json:"priority" mapstructure:"priority"`
+	Auth              bool                   `bson:"auth" json:"auth" mapstructure:"auth"`
+	DataToken         string                 `bson:"datatoken" json:"-" mapstructure:"-"`
+	NoRetry           bool                   `bson:"noretry" json:"noretry" mapstructure:"noretry"`
+	UserAttr          map[string]interface{} `bson:"userattr" json:"userattr" mapstructure:"userattr"`
+	Description       string                 `bson:"description" json:"description" mapstructure:"description"`
+	Tracking          bool                   `bson:"tracking" json:"tracking" mapstructure:"tracking"`
+	StartAt           time.Time              `bson:"start_at" json:"start_at" mapstructure:"start_at"`                               // will start tasks at this timepoint or shortly after
+	KeepIntermediates bool                   `bson:"keep_intermediates" json:"keep_intermediates" mapstructure:"keep_intermediates"` // no cleanup of the intermediate nodes
+	Deadline          time.Time              `bson:"deadline" json:"deadline" mapstructure:"deadline"`                               // the job should complete before this timepoint
+
+	deadlineBoost int32 // priority added while the job is at risk of missing its deadline, shared by the workunits of the job, access with atomic
+}
+
+// DeadlineBoost the priority added while the job is at risk of missing its deadline
+func (info *Info) DeadlineBoost() int {
+	return int(atomic.LoadInt32(&info.deadlineBoost))
+}
+
+func (info *Info) setDeadlineBoost(boost int) {
+	atomic.StoreInt32(&info.deadlineBoost, int32(boost))
 }
 
 // NewInfo _
@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/MG-RAST/AWE/lib/datastore"
 	shock "github.com/MG-RAST/go-shock-client"
 	"github.com/MG-RAST/golib/go-uuid/uuid"
 )
@@ -241,6 +242,11 @@ func (io *IO) IndexFile(indextype string) (idxInfo shock.IdxInfo, err error) {
 
 // DeleteNode _
 func (io *IO) DeleteNode() (err error) {
-	err = shock.ShockDelete(io.Host, io.Node, io.DataToken)
+	err = deleteNode(io.Host, io.Node, io.DataToken)
 	return
 }
+
+// deleteNode deletes a node from its data store
+var deleteNode = func(host string, node string, token string) (err error) {
+	return datastore.New(host, token).Delete(node)
+}
@@ -22,7 +22,6 @@ import (
 	uuid "github.com/MG-RAST/golib/go-uuid/uuid"
 	"gopkg.in/mgo.v2/bson"
 
-	"strconv"
 	"strings"
 	"time"
 )
@@ -40,6 +39,7 @@ const (
 
 var JOB_STATS_ACTIVE = []string{JOB_STAT_QUEUING, JOB_STAT_QUEUED, JOB_STAT_INPROGRESS}
 var JOB_STATS_REGISTERED = []string{JOB_STAT_QUEUING, JOB_STAT_QUEUED, JOB_STAT_INPROGRESS, JOB_STAT_SUSPEND}
+var JOB_STATS_FINAL = []string{JOB_STAT_COMPLETED, JOB_STAT_SUSPEND, JOB_STAT_FAILED_PERMANENT, JOB_STAT_DELETED} // states the retention policy applies to
 var JOB_STATS_TO_RECOVER = []string{JOB_STAT_INIT, JOB_STAT_QUEUING, JOB_STAT_QUEUED, JOB_STAT_INPROGRESS, JOB_STAT_SUSPEND}
 
 // JobError _
@@ -68,13 +68,16 @@ type JobRaw struct {
 	Script                  script                       `bson:"script" json:"-"`
 	State                   string                       `bson:"state" json:"state"`
 	Registered              bool                         `bson:"registered" json:"registered"`
+	Archived                bool                         `bson:"-" json:"archived,omitempty"`    // read from the archive
 	RemainTasks             int                          `bson:"remaintasks" json:"remaintasks"` // old-style AWE
 	RemainSteps             int                          `bson:"remainsteps" json:"remainteps"`
 	Expiration              time.Time                    `bson:"expiration" json:"expiration"` // 0 means no expiration
 	UpdateTime              time.Time                    `bson:"updatetime" json:"updatetime"`
-	Error                   *JobError                    `bson:"error" json:"error"`         // error struct exists when in suspended state
-	Resumed                 int                          `bson:"resumed" json:"resumed"`     // number of times the job has been resumed from suspension
-	ShockHost               string                       `bson:"shockhost" json:"shockhost"` // this is a fall-back default if not specified at a lower level
+	Error                   *JobError                    `bson:"error" json:"error"`                     // error struct exists when in suspended state
+	Resumed                 int                          `bson:"resumed" json:"resumed"`                 // number of times the job has been resumed from suspension
+	Cleanup                 *CleanupReport               `bson:"cleanup" json:"cleanup"`                 // intermediate nodes deleted by the cleanup
+	DeadlineStatus          *DeadlineStatus              `bson:"deadline_status" json:"deadline_status"` // projected completion, jobs with a deadline only
+	ShockHost               string                       `bson:"shockhost" json:"shockhost"`             // this is a fall-back default if not specified at a lower level
 	IsCWL                   bool                         `bson:"is_cwl" json:"is_cwl"`
 	CWL_job_input           interface{}                  `bson:"cwl_job_input" json:"cwl_job_input"` // has to be an array for mongo (id as key would not work)
 	CWL_ShockRequirement    *cwl.ShockRequirement        `bson:"cwl_shock_requirement" json:"cwl_shock_requirement"`
@@ -139,9 +142,9 @@ func (job *Job) AddWorkflowInstance(wi *WorkflowInstance, dbSync bool, writeLock
 
 	if dbSync == DbSyncTrue {
 
-		err = dbInsert(wi)
+		err = Repo.WorkflowInstances.Insert(wi)
 		if err != nil {
-			err = fmt.Errorf("(AddWorkflowInstance) dbUpsert(wi) returned: %s", err.Error())
+			err = fmt.Errorf("(AddWorkflowInstance) Repo.WorkflowInstances.Insert returned: %s", err.Error())
 			return
 		}
 	}
@@ -604,12 +607,12 @@ func (job *Job) Save() (err error) {
 		return
 	}
 
-	logger.Debug(1, "(job.Save()) dbUpsert next: %s", job.ID)
+	logger.Debug(1, "(job.Save()) Repo.Jobs.Save next: %s", job.ID)
 	//spew.Dump(job)
 
-	err = dbUpsert(job)
+	err = Repo.Jobs.Save(job)
 	if err != nil {
-		err = fmt.Errorf("(job.Save()) dbUpsert failed (job_id=%s) error=%s", job.ID, err.Error())
+		err = fmt.Errorf("(job.Save()) Repo.Jobs.Save failed (job_id=%s) error=%s", job.ID, err.Error())
 		return
 	}
 	logger.Debug(1, "(job.Save()) job saved: %s", job.ID)
@@ -618,13 +621,28 @@ func (job *Job) Save() (err error) {
 
 // Delete _
 func (job *Job) Delete() (err error) {
-	if err = dbDelete(bson.M{"id": job.ID}, conf.DB_COLL_JOBS); err != nil {
+	return deleteJobByID(job.ID)
+}
+
+// deleteJobByID removes the job, its workflow instances and perf record from the database, and the job directory
+func deleteJobByID(id string) (err error) {
+	if err = Repo.WorkflowInstances.DeleteJob(id); err != nil {
+		return err
+	}
+	if err = Repo.Perf.Delete(id); err != nil {
+		return err
+	}
+	if err = Repo.Jobs.Delete(id); err != nil {
+		return err
+	}
+	var path string
+	if path, err = getPathByJobID(id); err != nil {
 		return err
 	}
-	if err = job.Rmdir(); err != nil {
+	if err = os.RemoveAll(path); err != nil {
 		return err
 	}
-	logger.Event(event.JOB_FULL_DELETE, "jobid="+job.ID)
+	logger.Event(event.JOB_FULL_DELETE, "jobid="+id)
 	return
 }
 
@@ -922,6 +940,41 @@ func (job *Job) SetPipeline(pipeline string) (err error) {
 	return
 }
 
+// SetKeepIntermediates _
+func (job *Job) SetKeepIntermediates(keep bool) (err error) {
+	err = job.LockNamed("SetKeepIntermediates")
+	if err != nil {
+		return
+	}
+	defer job.Unlock()
+
+	err = dbUpdateJobFieldBoolean(job.ID, "info.keep_intermediates", keep)
+	if err != nil {
+		return
+	}
+	job.Info.KeepIntermediates = keep
+	return
+}
+
+// SetDeadline sets the deadline of the job, a zero time removes it. The projection is renewed by the
+// deadline monitor.
+func (job *Job) SetDeadline(deadline time.Time) (err error) {
+	err = job.LockNamed("SetDeadline")
+	if err != nil {
+		return
+	}
+	defer job.Unlock()
+
+	err = dbUpdateJobFields(job.ID, bson.M{"info.deadline": deadline, "deadline_status": nil})
+	if err != nil {
+		return
+	}
+	job.Info.Deadline = deadline
+	job.Info.setDeadlineBoost(0)
+	job.DeadlineStatus = nil
+	return
+}
+
 func (job *Job) SetDataToken(token string) (err error) {
 	err = job.LockNamed("SetDataToken")
 	if err != nil {
@@ -963,24 +1016,12 @@ func (job *Job) SetExpiration(expire string) (err error) {
 	}
 	defer job.Unlock()
 
-	parts := ExpireRegex.FindStringSubmatch(expire)
-	if len(parts) == 0 {
-		return errors.New("expiration format '" + expire + "' is invalid")
-	}
-	var expireTime time.Duration
-	expireNum, _ := strconv.Atoi(parts[1])
-	currTime := time.Now()
-
-	switch parts[2] {
-	case "M":
-		expireTime = time.Duration(expireNum) * time.Minute
-	case "H":
-		expireTime = time.Duration(expireNum) * time.Hour
-	case "D":
-		expireTime = time.Duration(expireNum*24) * time.Hour
+	expireTime, err := ExpireDuration(expire)
+	if err != nil {
+		return
 	}
 
-	newExpiration := currTime.Add(expireTime)
+	newExpiration := time.Now().Add(expireTime)
 	err = dbUpdateJobFieldTime(job.ID, "expiration", newExpiration)
 	if err != nil {
 		return
@@ -1035,7 +1076,7 @@ func ReloadFromDisk(path string) (err error) {
 	job := NewJob()
 	err = bson.Unmarshal(jobbson, &job)
 	if err == nil {
-		if err = dbUpsert(job); err != nil {
+		if err = Repo.Jobs.Save(job); err != nil {
 			return err
 		}
 	}
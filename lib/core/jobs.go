@@ -53,7 +53,7 @@ func (n *Jobs) GetAll(q bson.M, order string, direction string, do_init bool) (e
 	if direction == "desc" {
 		order = "-" + order
 	}
-	_, err = dbFindSort(q, n, nil, order, do_init)
+	_, err = dbFindSort(q, n, &DefaultQueryOptions{Sort: []string{order}}, do_init)
 	return
 }
 
@@ -61,17 +61,17 @@ func (n *Jobs) GetPaginated(q bson.M, limit int, offset int, order string, direc
 	if direction == "desc" {
 		order = "-" + order
 	}
-	count, err = dbFindSort(q, n, map[string]int{"limit": limit, "offset": offset}, order, do_init)
+	count, err = dbFindSort(q, n, &DefaultQueryOptions{Limit: limit, Offset: offset, Sort: []string{order}}, do_init)
 	return
 }
 
 func (n *Jobs) GetAllLimitOffset(q bson.M, limit int, offset int) (err error) {
-	_, err = dbFind(q, n, map[string]int{"limit": limit, "offset": offset})
+	_, err = dbFind(q, n, &DefaultQueryOptions{Limit: limit, Offset: offset})
 	return
 }
 
 func (n *Jobs) GetAllRecent(q bson.M, recent int, do_init bool) (count int, err error) {
-	count, err = dbFindSort(q, n, map[string]int{"limit": recent}, "-updatetime", do_init)
+	count, err = dbFindSort(q, n, &DefaultQueryOptions{Limit: recent, Sort: []string{"-updatetime"}}, do_init)
 	return
 }
 
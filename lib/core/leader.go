@@ -0,0 +1,366 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+	uuid "github.com/MG-RAST/golib/go-uuid/uuid"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// documents in the ServerState collection
+const (
+	leaseDocID   = "leader"
+	clusterDocID = "cluster"
+)
+
+// LeaderHeader names the API URL of the leader in the 503 responses of a standby server
+const LeaderHeader = "X-AWE-Leader"
+
+// InstanceUUID identifies this server process. With HA the ServerUUID is shared by all servers of the
+// cluster, so that workers do not stop their work when another server becomes the leader.
+var InstanceUUID = uuid.New()
+
+var isLeader int32
+
+// leaseDeadline (UnixNano) the leader stops at this time unless it renewed the lease. It is the expiry
+// written with the last renewal, taken before the write, so that no standby takes over earlier.
+var leaseDeadline int64
+
+// leaderExit ends a leader without a valid lease, it must not schedule work next to the new leader
+var leaderExit = func(reason string) {
+	logger.Error("(RunLeaderElection) %s, exiting", reason)
+	fmt.Fprintln(os.Stderr, reason+", exiting")
+	os.Exit(1)
+}
+
+// LeaderLease the leader renews it every third of the lease time, a standby takes it over when it expired
+type LeaderLease struct {
+	ID       string    `bson:"_id" json:"-"`
+	Holder   string    `bson:"holder" json:"holder"` // InstanceUUID
+	URL      string    `bson:"url" json:"url"`
+	Acquired time.Time `bson:"acquired" json:"acquired"`
+	Expires  time.Time `bson:"expires" json:"expires"`
+}
+
+// warmCheckouts copy of the persisted checkouts kept by a standby, used if the database is not
+// reachable when it takes over
+var warmCheckouts = struct {
+	sync.Mutex
+	records []CheckoutRecord
+}{}
+
+// reservation a recovered workunit is only given to the client that had it checked out, until expires
+type reservation struct {
+	record  CheckoutRecord
+	expires time.Time
+}
+
+var reservations = struct {
+	sync.Mutex
+	work map[string]reservation
+}{work: make(map[string]reservation)}
+
+// IsLeader true if HA is disabled or this server holds the lease and its deadline has not passed
+func IsLeader() bool {
+	if !conf.HA_ENABLED {
+		return true
+	}
+	return atomic.LoadInt32(&isLeader) == 1 && time.Now().UnixNano() < atomic.LoadInt64(&leaseDeadline)
+}
+
+// AdvertiseURL API URL of this server
+func AdvertiseURL() string {
+	if conf.HA_ADVERTISE_URL != "" {
+		return conf.HA_ADVERTISE_URL
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("http://%s:%d", hostname, conf.API_PORT)
+}
+
+// GetLeaderLease returns the current lease, nil if there is none
+func GetLeaderLease() (lease *LeaderLease, err error) {
+	c := db.C(conf.DB_COLL_SERVER_STATE)
+	lease = &LeaderLease{}
+	err = c.Find(bson.M{"_id": leaseDocID}).One(lease)
+	if err == db.ErrNotFound {
+		lease = nil
+		err = nil
+	}
+	return
+}
+
+// leaderCache the lease read by LeaderURL, a standby reads it at most once per leaderCacheTime
+var leaderCache = struct {
+	sync.Mutex
+	lease   *LeaderLease
+	fetched time.Time
+}{}
+
+const leaderCacheTime = time.Second
+
+// LeaderURL API URL of the leader, empty if unknown
+func LeaderURL() string {
+	leaderCache.Lock()
+	defer leaderCache.Unlock()
+	if time.Since(leaderCache.fetched) > leaderCacheTime {
+		lease, err := GetLeaderLease()
+		if err != nil {
+			lease = nil
+		}
+		leaderCache.lease = lease
+		leaderCache.fetched = time.Now()
+	}
+	lease := leaderCache.lease
+	if lease == nil || time.Now().After(lease.Expires) {
+		return ""
+	}
+	return lease.URL
+}
+
+// InitClusterUUID the ServerUUID of an HA cluster is created by the first server and stored in mongodb
+func InitClusterUUID() (err error) {
+	c := db.C(conf.DB_COLL_SERVER_STATE)
+
+	err = c.Insert(bson.M{"_id": clusterDocID, "uuid": uuid.New()})
+	if err != nil && !db.IsDup(err) {
+		err = fmt.Errorf("(InitClusterUUID) c.Insert returned: %s", err.Error())
+		return
+	}
+	doc := bson.M{}
+	err = c.Find(bson.M{"_id": clusterDocID}).One(&doc)
+	if err != nil {
+		err = fmt.Errorf("(InitClusterUUID) c.FindId returned: %s", err.Error())
+		return
+	}
+	clusterUUID, ok := doc["uuid"].(string)
+	if !ok || clusterUUID == "" {
+		err = fmt.Errorf("(InitClusterUUID) cluster document has no uuid")
+		return
+	}
+	ServerUUID = clusterUUID
+	return
+}
+
+// acquireLease takes or renews the lease until expires, ok is false if another server holds it
+func acquireLease(url string) (ok bool, expires time.Time, err error) {
+	c := db.C(conf.DB_COLL_SERVER_STATE)
+
+	now := time.Now()
+	expires = now.Add(time.Duration(conf.HA_LEASE_SECONDS) * time.Second)
+	selector := bson.M{
+		"_id": leaseDocID,
+		"$or": []bson.M{{"holder": InstanceUUID}, {"expires": bson.M{"$lt": now}}},
+	}
+	update := bson.M{"$set": bson.M{
+		"holder":  InstanceUUID,
+		"url":     url,
+		"expires": expires,
+	}}
+	if atomic.LoadInt32(&isLeader) == 0 {
+		update["$set"].(bson.M)["acquired"] = now
+	}
+	err = c.Upsert(selector, update)
+	if err != nil {
+		if db.IsDup(err) {
+			// the lease exists and is held by another server
+			err = nil
+		}
+		return
+	}
+	ok = true
+	return
+}
+
+// RunLeaderElection blocks while this server is a standby, keeping a warm copy of the persisted
+// checkouts and of the unfinished jobs. When it got the lease it calls promote and keeps renewing
+// the lease. A leader that loses its lease exits, it must not schedule work next to the new leader.
+func (qm *ServerMgr) RunLeaderElection(promote func()) {
+	url := AdvertiseURL()
+	renew := time.Duration(conf.HA_LEASE_SECONDS) * time.Second / 3
+
+	for {
+		runElectionRound(url, promote)
+		time.Sleep(renew)
+	}
+}
+
+// runElectionRound takes or renews the lease once
+func runElectionRound(url string, promote func()) {
+	ok, expires, err := acquireLease(url)
+	if err != nil {
+		logger.Error("(RunLeaderElection) acquireLease returned: %s", err.Error())
+	}
+	if atomic.LoadInt32(&isLeader) == 1 {
+		if ok {
+			atomic.StoreInt64(&leaseDeadline, expires.UnixNano())
+		} else if err == nil {
+			leaderExit("lost the leader lease")
+		}
+		// if the database is not reachable fenceLeader stops the leader at the deadline
+		return
+	}
+	if ok {
+		logger.Info("(RunLeaderElection) this server (%s) is the leader now", url)
+		atomic.StoreInt64(&leaseDeadline, expires.UnixNano())
+		atomic.StoreInt32(&isLeader, 1)
+		go fenceLeader()
+		go promote()
+		return
+	}
+	xerr := refreshWarmCheckouts()
+	if xerr != nil {
+		logger.Error("(RunLeaderElection) %s", xerr.Error())
+	}
+	xerr = refreshWarmJobs()
+	if xerr != nil {
+		logger.Error("(RunLeaderElection) %s", xerr.Error())
+	}
+}
+
+// fenceLeader stops the leader as soon as the lease deadline passed without a renewal, also while a
+// renewal is blocked by the database. IsLeader is false from the deadline on, the API rejects
+// requests like a standby until the server exited.
+func fenceLeader() {
+	for atomic.LoadInt32(&isLeader) == 1 {
+		remaining := time.Until(time.Unix(0, atomic.LoadInt64(&leaseDeadline)))
+		if remaining <= 0 {
+			leaderExit("the leader lease expired")
+			return
+		}
+		time.Sleep(remaining)
+	}
+}
+
+func refreshWarmCheckouts() (err error) {
+	records, err := dbGetCheckouts()
+	if err != nil {
+		err = fmt.Errorf("(refreshWarmCheckouts) %s", err.Error())
+		return
+	}
+	warmCheckouts.Lock()
+	warmCheckouts.records = records
+	warmCheckouts.Unlock()
+	return
+}
+
+// ReserveCheckedOutWork called by a new leader (or a restarted server) before it recovers the jobs:
+// the workunits that were checked out are kept for their clients, which adopt them when they re-register
+func (qm *ServerMgr) ReserveCheckedOutWork() (count int) {
+	records, err := dbGetCheckouts()
+	if err != nil {
+		logger.Error("(ReserveCheckedOutWork) %s", err.Error())
+		if !conf.HA_ENABLED {
+			return
+		}
+		warmCheckouts.Lock()
+		records = warmCheckouts.records
+		warmCheckouts.Unlock()
+		logger.Info("(ReserveCheckedOutWork) using the warm copy of %d checkouts", len(records))
+	}
+	if len(records) == 0 {
+		return
+	}
+	expires := time.Now().Add(time.Duration(conf.RECLAIM_GRACE) * time.Second)
+	reservations.Lock()
+	for _, record := range records {
+		reservations.work[record.Work] = reservation{record: record, expires: expires}
+		count++
+	}
+	reservations.Unlock()
+	go qm.adoptReservedWorkLoop()
+	return
+}
+
+// reservedFor returns the client a workunit is reserved for, empty if none
+func reservedFor(workID string) string {
+	reservations.Lock()
+	defer reservations.Unlock()
+	r, ok := reservations.work[workID]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(r.expires) {
+		return ""
+	}
+	return r.record.Client
+}
+
+func (qm *ServerMgr) adoptReservedWorkLoop() {
+	for {
+		time.Sleep(2 * time.Second)
+		if qm.adoptReservedWork() == 0 {
+			return
+		}
+	}
+}
+
+// adoptReservedWork checks out recovered workunits to the clients still running them, returns the
+// number of reservations left
+func (qm *ServerMgr) adoptReservedWork() (left int) {
+	reservations.Lock()
+	work := make(map[string]reservation, len(reservations.work))
+	for id, r := range reservations.work {
+		work[id] = r
+	}
+	reservations.Unlock()
+
+	for id, r := range work {
+		adopted, keep := qm.adoptWorkunit(id, r)
+		if keep && time.Now().Before(r.expires) {
+			left++
+			continue
+		}
+		if _, ok := takeReservation(id); !ok {
+			// taken by a delivery in the meantime
+			continue
+		}
+		if adopted {
+			logger.Info("(adoptReservedWork) workunit %s adopted by client %s", id, r.record.Client)
+			continue
+		}
+		qm.releaseReservation(id, r)
+	}
+	return
+}
+
+// adoptWorkunit keep is true while the workunit or the client are not back yet
+func (qm *ServerMgr) adoptWorkunit(id string, r reservation) (adopted bool, keep bool) {
+	workID, err := New_Workunit_Unique_Identifier_FromString(id)
+	if err != nil {
+		return
+	}
+	work, ok, err := qm.workQueue.Get(workID)
+	if err != nil || !ok {
+		// the job has not been recovered yet
+		keep = true
+		return
+	}
+	keepAttempt(work, r.record)
+	if work.State != WORK_STAT_QUEUED {
+		return
+	}
+	client, ok, err := qm.GetClient(r.record.Client, true)
+	if err != nil || !ok {
+		keep = true
+		return
+	}
+	running, err := client.CurrentWork.Has(workID)
+	if err != nil || !running {
+		// the client re-registered without this workunit
+		return
+	}
+	err = qm.reclaimWorkunit(work, client, r.record)
+	if err != nil {
+		logger.Error("(adoptWorkunit) %s", err.Error())
+		return
+	}
+	adopted = true
+	return
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// setupLeaderTest enables HA without a lease, leaderExit records the reason instead of exiting
+func setupLeaderTest(t *testing.T, leaseSeconds int) (exits chan string) {
+	restore := conftest.Save(&conf.HA_ENABLED, &conf.HA_LEASE_SECONDS, &InstanceUUID, &leaderExit)
+	t.Cleanup(func() {
+		atomic.StoreInt32(&isLeader, 0)
+		atomic.StoreInt64(&leaseDeadline, 0)
+		restore()
+	})
+	conf.HA_ENABLED = true
+	conf.HA_LEASE_SECONDS = leaseSeconds
+	exits = make(chan string, 10)
+	leaderExit = func(reason string) { exits <- reason }
+	if _, err := db.C(conf.DB_COLL_SERVER_STATE).RemoveAll(bson.M{"_id": leaseDocID}); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestLeaderLease(t *testing.T) {
+	exits := setupLeaderTest(t, 30)
+	promoted := make(chan bool, 1)
+	promote := func() { promoted <- true }
+
+	// acquire
+	runElectionRound("http://a:8001", promote)
+	select {
+	case <-promoted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not promoted")
+	}
+	if !IsLeader() {
+		t.Fatal("not the leader after acquiring the lease")
+	}
+	lease, err := GetLeaderLease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease == nil || lease.Holder != InstanceUUID || lease.URL != "http://a:8001" {
+		t.Fatalf("unexpected lease %+v", lease)
+	}
+
+	// another server cannot take an unexpired lease
+	leader := InstanceUUID
+	InstanceUUID = "other-server"
+	ok, _, err := acquireLease("http://b:8001")
+	if err != nil || ok {
+		t.Errorf("lease taken by another server: %t %v", ok, err)
+	}
+	InstanceUUID = leader
+
+	// renew
+	deadline := atomic.LoadInt64(&leaseDeadline)
+	time.Sleep(10 * time.Millisecond)
+	runElectionRound("http://a:8001", promote)
+	renewed, err := GetLeaderLease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&leaseDeadline) <= deadline || !renewed.Expires.After(lease.Expires) || !renewed.Acquired.Equal(lease.Acquired) {
+		t.Errorf("lease not renewed: %+v, was %+v", renewed, lease)
+	}
+	if len(promoted) != 0 || len(exits) != 0 {
+		t.Errorf("renewal promoted or stopped the leader")
+	}
+
+	// lose: another server took over the lease
+	err = db.C(conf.DB_COLL_SERVER_STATE).Update(bson.M{"_id": leaseDocID}, bson.M{"$set": bson.M{"holder": "other-server", "expires": time.Now().Add(time.Minute)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runElectionRound("http://a:8001", promote)
+	if len(exits) != 1 || <-exits != "lost the leader lease" {
+		t.Errorf("leader did not stop after losing the lease")
+	}
+
+	// an expired lease is taken over
+	atomic.StoreInt32(&isLeader, 0)
+	err = db.C(conf.DB_COLL_SERVER_STATE).Update(bson.M{"_id": leaseDocID}, bson.M{"$set": bson.M{"expires": time.Now().Add(-time.Second)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, _, err = acquireLease("http://a:8001")
+	if err != nil || !ok {
+		t.Errorf("expired lease not taken over: %t %v", ok, err)
+	}
+}
+
+func TestFenceLeader(t *testing.T) {
+	exits := setupLeaderTest(t, 30)
+
+	atomic.StoreInt32(&isLeader, 1)
+	atomic.StoreInt64(&leaseDeadline, time.Now().Add(100*time.Millisecond).UnixNano())
+	go fenceLeader()
+	if !IsLeader() {
+		t.Fatal("not the leader before the deadline")
+	}
+
+	// a renewal moves the fence
+	time.Sleep(50 * time.Millisecond)
+	deadline := time.Now().Add(200 * time.Millisecond)
+	atomic.StoreInt64(&leaseDeadline, deadline.UnixNano())
+	select {
+	case reason := <-exits:
+		if time.Now().Before(deadline) {
+			t.Errorf("stopped before the deadline: %s", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("leader not stopped after the deadline")
+	}
+	if IsLeader() {
+		t.Errorf("still the leader after the deadline")
+	}
+
+	conf.HA_ENABLED = false
+	if !IsLeader() {
+		t.Errorf("not the leader without HA")
+	}
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/db"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// TestMain runs the tests of the package against an embedded database in a temporary directory
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "awe-core-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	conf.DATA_PATH = path.Join(dir, "data")
+	conf.DB_BACKEND = db.BackendEmbedded
+	conf.EMBEDDED_PATH = path.Join(dir, "awe.db")
+	logger.Initialize("server")
+	err = db.Initialize()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
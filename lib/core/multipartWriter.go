@@ -7,6 +7,10 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/client"
+	"github.com/MG-RAST/AWE/lib/logger"
 )
 
 type MultipartWriter struct {
@@ -20,33 +24,50 @@ func NewMultipartWriter() *MultipartWriter {
 	return m
 }
 
+// rateLimitMaxRetries is the number of times a request is repeated after the server answered 429
+const rateLimitMaxRetries = 10
+
+// Send sends the form. If the server rate-limits the request (429) it waits as long as
+// the Retry-After header asks and sends it again.
 func (m *MultipartWriter) Send(method string, url string, header map[string][]string) (response *http.Response, err error) {
 	m.w.Close()
 	//fmt.Println("------------")
 	//spew.Dump(m.w)
 	//fmt.Println("------------")
 
-	req, err := http.NewRequest(method, url, &m.b)
-	if err != nil {
-		return
-	}
-	// Don't forget to set the content type, this will contain the boundary.
-	req.Header.Set("Content-Type", m.w.FormDataContentType())
+	body := m.b.Bytes()
+	for retry := 0; ; retry++ {
+		var req *http.Request
+		req, err = http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		// Don't forget to set the content type, this will contain the boundary.
+		req.Header.Set("Content-Type", m.w.FormDataContentType())
+
+		for key := range header {
+			header_array := header[key]
+			for _, value := range header_array {
+				req.Header.Add(key, value)
+			}
 
-	for key := range header {
-		header_array := header[key]
-		for _, value := range header_array {
-			req.Header.Add(key, value)
 		}
 
-	}
+		// Submit the request
+		httpClient := &http.Client{}
+		//fmt.Printf("%s %s\n\n", method, url)
+		response, err = httpClient.Do(req)
+		if err != nil {
+			return
+		}
 
-	// Submit the request
-	client := &http.Client{}
-	//fmt.Printf("%s %s\n\n", method, url)
-	response, err = client.Do(req)
-	if err != nil {
-		return
+		if response.StatusCode != http.StatusTooManyRequests || retry >= rateLimitMaxRetries {
+			break
+		}
+		wait := client.RetryAfter(response, 5*time.Second)
+		response.Body.Close()
+		logger.Debug(1, "(MultipartWriter/Send) %s %s rate limited, retry in %s", method, url, wait)
+		time.Sleep(wait)
 	}
 
 	// Check the response
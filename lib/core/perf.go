@@ -21,6 +21,7 @@ type TaskPerf struct {
 	Resp         int64   `bson:"resp" json:"resp"` //End -Queued
 	InFileSizes  []int64 `bson:"size_infile" json:"size_infile"`
 	OutFileSizes []int64 `bson:"size_outfile" json:"size_outfile"`
+	Tool         string  `bson:"tool" json:"tool"` // pipeline and command or CWL tool, key of the runtime history
 }
 
 type WorkPerf struct {
@@ -42,6 +43,32 @@ type WorkPerf struct {
 	PreDataSize        int64   `bson:"size_predata" json:"size_predata"` //predata moved over network
 	InFileSize         int64   `bson:"size_infile" json:"size_infile"`   //input file moved over network
 	OutFileSize        int64   `bson:"size_outfile" json:"size_outfile"` //outpuf file moved over network
+	OOMKilled          bool    `bson:"oom_killed" json:"oom_killed"`     //container exceeded its memory limit
+	DiskFull           bool    `bson:"disk_full" json:"disk_full"`       //killed because the work path ran out of space
+
+	Usage *ResourceUsage `bson:"usage,omitempty" json:"usage,omitempty"` //sampled resource usage of the command
+}
+
+// ResourceUsage resource usage of a workunit sampled by the worker, memory and I/O in bytes
+type ResourceUsage struct {
+	Source     string        `bson:"source" json:"source"`           // cgroup2, cgroup1 or proc
+	Interval   int           `bson:"interval" json:"interval"`       // seconds between samples
+	CPUSeconds float64       `bson:"cpu_seconds" json:"cpu_seconds"` // user + system
+	MemPeak    int64         `bson:"mem_peak" json:"mem_peak"`
+	MemAvg     int64         `bson:"mem_avg" json:"mem_avg"`
+	SwapPeak   int64         `bson:"swap_peak" json:"swap_peak"`
+	IORead     int64         `bson:"io_read" json:"io_read"`
+	IOWrite    int64         `bson:"io_write" json:"io_write"`
+	Samples    []UsageSample `bson:"samples" json:"samples"`
+}
+
+// UsageSample one point of the usage time series
+type UsageSample struct {
+	Time    int64   `bson:"time" json:"time"` // seconds since start
+	CPU     float64 `bson:"cpu" json:"cpu"`   // cores used since the previous sample
+	Memory  int64   `bson:"memory" json:"memory"`
+	IORead  int64   `bson:"io_read" json:"io_read"` // cumulative
+	IOWrite int64   `bson:"io_write" json:"io_write"`
 }
 
 func NewJobPerf(id string) *JobPerf {
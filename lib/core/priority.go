@@ -0,0 +1,163 @@
+package core
+
+import (
+	"sort"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
+)
+
+// EffectivePriority the priority of the job of the workunit, a queued workunit gains priority_aging for
+// every priority_aging_interval it waits and deadline_boost while its job is at risk of missing its deadline
+func (work *Workunit) EffectivePriority(now time.Time) (priority int) {
+	if work.Info == nil {
+		return
+	}
+	priority = work.Info.Priority + work.Info.DeadlineBoost()
+	if conf.PRIORITY_AGING <= 0 || work.State != WORK_STAT_QUEUED || work.QueuedTime.IsZero() {
+		return
+	}
+	intervals := int(now.Sub(work.QueuedTime) / (time.Duration(conf.PRIORITY_AGING_INTERVAL) * time.Minute))
+	gain := intervals * conf.PRIORITY_AGING
+	if conf.PRIORITY_AGING_MAX > 0 && gain > conf.PRIORITY_AGING_MAX {
+		gain = conf.PRIORITY_AGING_MAX
+	}
+	priority += gain
+	return
+}
+
+// PreemptLoop periodically makes room for high priority workunits that cannot be placed
+func (qm *ServerMgr) PreemptLoop() {
+	logger.Info("(PreemptLoop) starting")
+	for {
+		time.Sleep(30 * time.Second)
+		if qm.suspendQueue {
+			continue
+		}
+		if err := qm.preemptWork(time.Now()); err != nil {
+			logger.Error("(PreemptLoop) preemptWork returned: %s", err.Error())
+		}
+	}
+}
+
+// preemptWork requeues one lower priority workunit for each queued workunit with a job priority of at
+// least preempt_priority that waited longer than preempt_wait. The victim runs on a client that could
+// run the waiting workunit, see preemptionVictim.
+func (qm *ServerMgr) preemptWork(now time.Time) (err error) {
+	queued, err := qm.workQueue.Queue.GetWorkunits()
+	if err != nil {
+		return
+	}
+	wait := time.Duration(conf.PREEMPT_WAIT) * time.Second
+	urgent := WorkList{}
+	for _, work := range queued {
+		if work.Info == nil || work.Info.Priority < conf.PREEMPT_PRIORITY || now.Sub(work.QueuedTime) < wait {
+			continue
+		}
+		if reservedFor(work.ID) != "" {
+			continue
+		}
+		urgent = append(urgent, work)
+	}
+	if len(urgent) == 0 {
+		return
+	}
+	sort.Sort(byFCFS{WorkList: urgent, now: now})
+
+	running, err := qm.workQueue.Checkout.GetWorkunits()
+	if err != nil {
+		return
+	}
+	clients, err := qm.clientMap.GetClients()
+	if err != nil {
+		return
+	}
+	clientByID := map[string]*Client{}
+	for _, client := range clients {
+		clientByID[client.ID] = client
+	}
+
+	taken := map[string]bool{}
+	for _, work := range urgent {
+		candidates := WorkList{}
+		for _, candidate := range running {
+			if taken[candidate.ID] {
+				continue
+			}
+			client, ok := clientByID[candidate.Client]
+			if !ok || !clientCanRun(client, work) {
+				continue
+			}
+			candidates = append(candidates, candidate)
+		}
+		victim := preemptionVictim(work, candidates, now)
+		if victim == nil {
+			continue
+		}
+		taken[victim.ID] = true
+		if xerr := qm.preemptWorkunit(victim, clientByID[victim.Client], work); xerr != nil {
+			logger.Error("(preemptWork) preemptWorkunit %s returned: %s", victim.ID, xerr.Error())
+		}
+	}
+	return
+}
+
+// preemptionVictim the running workunit to preempt for the waiting workunit, its effective priority is at
+// least preempt_margin lower. The lowest priority and most recently checked out workunit is chosen.
+func preemptionVictim(work *Workunit, running WorkList, now time.Time) (victim *Workunit) {
+	priority := work.EffectivePriority(now)
+	victimPriority := 0
+	for _, candidate := range running {
+		if candidate.Info == nil {
+			continue
+		}
+		candidatePriority := candidate.EffectivePriority(now)
+		if candidatePriority+conf.PREEMPT_MARGIN > priority {
+			continue
+		}
+		if victim == nil || candidatePriority < victimPriority ||
+			(candidatePriority == victimPriority && candidate.CheckoutTime.After(victim.CheckoutTime)) {
+			victim = candidate
+			victimPriority = candidatePriority
+		}
+	}
+	return
+}
+
+// clientCanRun the client is online, takes new work and could check out the workunit
+func clientCanRun(client *Client, work *Workunit) bool {
+	readLock, err := client.RLockNamed("clientCanRun")
+	if err != nil {
+		return false
+	}
+	defer client.RUnlockNamed(readLock)
+
+	if !client.Online || client.Suspended || client.Draining || client.ContainsSkipWorkNolock(work.ID) {
+		return false
+	}
+	groupOK, appOK, runtimeOK := clientAcceptsWorkunit(client, work)
+	return groupOK && appOK && runtimeOK
+}
+
+// preemptWorkunit requeues the workunit without counting a failure, the client is told to stop it with
+// the next heartbeat
+func (qm *ServerMgr) preemptWorkunit(victim *Workunit, client *Client, work *Workunit) (err error) {
+	err = client.LockNamed("preemptWorkunit")
+	if err != nil {
+		return
+	}
+	defer client.Unlock()
+
+	err = qm.workQueue.StatusChange(victim.Workunit_Unique_Identifier, victim, WORK_STAT_QUEUED, "")
+	if err != nil {
+		return
+	}
+	_ = client.AssignedWork.Delete(victim.Workunit_Unique_Identifier, true)
+	if !contains(client.Preempted, victim.ID) {
+		client.Preempted = append(client.Preempted, victim.ID)
+	}
+	logger.Event(event.WORK_PREEMPT, "workid="+victim.ID+";clientid="+client.ID+";for="+work.ID)
+	return
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+)
+
+// priorityTestWork a workunit of a job with priority and deadline boost
+func priorityTestWork(id string, state string, priority int, boost int, queued time.Time, checkout time.Time) *Workunit {
+	work := &Workunit{ID: id, Info: &Info{Priority: priority}, QueuedTime: queued, CheckoutTime: checkout}
+	work.State = state
+	work.Info.setDeadlineBoost(boost)
+	return work
+}
+
+func TestEffectivePriority(t *testing.T) {
+	defer conftest.Save(&conf.PRIORITY_AGING, &conf.PRIORITY_AGING_INTERVAL, &conf.PRIORITY_AGING_MAX)()
+	now := time.Now()
+	tests := []struct {
+		aging    int
+		max      int
+		state    string
+		priority int
+		boost    int
+		waited   time.Duration // zero: no queued time
+		want     int
+	}{
+		{0, 0, WORK_STAT_QUEUED, 10, 0, 5 * time.Hour, 10},
+		{2, 0, WORK_STAT_QUEUED, 10, 0, 5*time.Hour + 30*time.Minute, 20},
+		{2, 6, WORK_STAT_QUEUED, 10, 0, 5 * time.Hour, 16},
+		{2, 0, WORK_STAT_QUEUED, 10, 0, 0, 10},
+		{2, 0, WORK_STAT_CHECKOUT, 10, 0, 5 * time.Hour, 10},
+		{2, 0, WORK_STAT_QUEUED, 10, 50, time.Hour, 62},
+		{0, 0, WORK_STAT_CHECKOUT, 10, 50, 0, 60},
+	}
+	for i, test := range tests {
+		conf.PRIORITY_AGING, conf.PRIORITY_AGING_INTERVAL, conf.PRIORITY_AGING_MAX = test.aging, 60, test.max
+		queued := time.Time{}
+		if test.waited > 0 {
+			queued = now.Add(-test.waited)
+		}
+		work := priorityTestWork("w", test.state, test.priority, test.boost, queued, time.Time{})
+		if got := work.EffectivePriority(now); got != test.want {
+			t.Errorf("test %d: got %d, want %d", i, got, test.want)
+		}
+	}
+	if got := (&Workunit{}).EffectivePriority(now); got != 0 {
+		t.Errorf("got %d for a workunit without info", got)
+	}
+}
+
+func TestPreemptionVictim(t *testing.T) {
+	defer conftest.Save(&conf.PRIORITY_AGING, &conf.PREEMPT_MARGIN)()
+	conf.PRIORITY_AGING = 0
+	conf.PREEMPT_MARGIN = 10
+	now := time.Now()
+	early, late := now.Add(-2*time.Hour), now.Add(-time.Hour)
+	running := func(id string, priority int, boost int, checkout time.Time) *Workunit {
+		return priorityTestWork(id, WORK_STAT_CHECKOUT, priority, boost, time.Time{}, checkout)
+	}
+	tests := []struct {
+		name    string
+		boost   int // of the waiting workunit with priority 100
+		running WorkList
+		want    string // empty: no victim
+	}{
+		{"lowest priority", 0, WorkList{running("a", 50, 0, early), running("b", 20, 0, early), running("c", 80, 0, early)}, "b"},
+		{"most recent checkout", 0, WorkList{running("a", 20, 0, early), running("b", 20, 0, late)}, "b"},
+		{"within the margin", 0, WorkList{running("a", 95, 0, early)}, ""},
+		{"boosted running workunit", 0, WorkList{running("a", 50, 60, early), running("b", 60, 0, early)}, "b"},
+		{"boost protects from preemption", 0, WorkList{running("a", 50, 45, early)}, ""},
+		{"boosted waiting workunit", 20, WorkList{running("a", 105, 0, early)}, "a"},
+		{"without info", 0, WorkList{{ID: "a"}}, ""},
+	}
+	for _, test := range tests {
+		work := priorityTestWork("w", WORK_STAT_QUEUED, 100, test.boost, now.Add(-time.Hour), time.Time{})
+		victim := preemptionVictim(work, test.running, now)
+		got := ""
+		if victim != nil {
+			got = victim.ID
+		}
+		if got != test.want {
+			t.Errorf("%s: got victim %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QueueDemand queued work and clients of a client group, input of the autoscaler
+type QueueDemand struct {
+	Group    string   `bson:"group" json:"group"`
+	Queued   int      `bson:"queued" json:"queued"`       // queued workunits a worker of the group can run
+	TooLarge int      `bson:"too_large" json:"too_large"` // queued workunits that need more cores or memory than a worker has
+	Cores    int      `bson:"cores" json:"cores"`         // cores requested by the queued workunits
+	RamMB    int      `bson:"ram_mb" json:"ram_mb"`       // memory requested by the queued workunits
+	Busy     int      `bson:"busy" json:"busy"`
+	Draining int      `bson:"draining" json:"draining"`
+	Idle     []string `bson:"idle" json:"idle"` // online clients without work, candidates for draining
+}
+
+// GetQueueDemand counts queued workunits and clients per client group. Workunits without client groups are
+// counted for the first of groups. workerCores and workerRamMB are the size of a worker, 0 if not limited.
+func (qm *ServerMgr) GetQueueDemand(groups []string, workerCores int, workerRamMB int) (demand map[string]*QueueDemand, err error) {
+	demand = make(map[string]*QueueDemand)
+	if len(groups) == 0 {
+		return
+	}
+	for _, group := range groups {
+		demand[group] = &QueueDemand{Group: group, Idle: []string{}}
+	}
+
+	workunitList, err := qm.workQueue.Queue.GetWorkunits()
+	if err != nil {
+		err = fmt.Errorf("(GetQueueDemand) qm.workQueue.Queue.GetWorkunits returned: %s", err.Error())
+		return
+	}
+
+	for _, workunit := range workunitList {
+		group := groups[0]
+		if workunit.Info != nil && workunit.Info.ClientGroups != "" {
+			group = ""
+			eligibleGroups := strings.Split(workunit.Info.ClientGroups, ",")
+			for _, g := range groups {
+				if contains(eligibleGroups, g) {
+					group = g
+					break
+				}
+			}
+			if group == "" {
+				continue
+			}
+		}
+		d := demand[group]
+
+		cores, ramMB := workunit.ResourceNeeds()
+		if (workerCores > 0 && cores > workerCores) || (workerRamMB > 0 && ramMB > workerRamMB) {
+			d.TooLarge++
+			continue
+		}
+		d.Queued++
+		d.Cores += cores
+		d.RamMB += ramMB
+	}
+
+	clientList, err := qm.clientMap.GetClients()
+	if err != nil {
+		err = fmt.Errorf("(GetQueueDemand) qm.clientMap.GetClients returned: %s", err.Error())
+		return
+	}
+
+	for _, client := range clientList {
+		readLock, xerr := client.RLockNamed("GetQueueDemand")
+		if xerr != nil {
+			continue
+		}
+		d, ok := demand[client.Group]
+		if ok {
+			workLength, _ := client.CurrentWork.Length(false)
+			switch {
+			case client.Draining:
+				d.Draining++
+			case client.Busy || workLength > 0:
+				d.Busy++
+			case client.Online && !client.Suspended:
+				d.Idle = append(d.Idle, client.ID)
+			}
+		}
+		client.RUnlockNamed(readLock)
+	}
+
+	return
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// takeReservation removes the reservation of a workunit
+func takeReservation(workID string) (record CheckoutRecord, ok bool) {
+	reservations.Lock()
+	defer reservations.Unlock()
+	r, ok := reservations.work[workID]
+	if ok {
+		delete(reservations.work, workID)
+		record = r.record
+	}
+	return
+}
+
+// releaseReservation a reserved workunit was not reclaimed by its client and is scheduled again, its
+// checkout record is removed unless another client checked it out in the meantime
+func (qm *ServerMgr) releaseReservation(id string, r reservation) {
+	logger.Info("(releaseReservation) workunit %s not reclaimed by client %s, it is scheduled again", id, r.record.Client)
+	workID, err := New_Workunit_Unique_Identifier_FromString(id)
+	if err != nil {
+		dbDeleteCheckout(id)
+		return
+	}
+	if w, ok, _ := qm.workQueue.Get(workID); !ok || w.State != WORK_STAT_CHECKOUT {
+		dbDeleteCheckout(id)
+	}
+}
+
+// keepAttempt the attempt and checkpoint survive the restart, even if another client runs the workunit
+func keepAttempt(work *Workunit, record CheckoutRecord) {
+	if work.Failed < record.Failed {
+		work.Failed = record.Failed
+	}
+	work.keepCheckpoint(record.Checkpoint)
+}
+
+// reclaimWorkunit checks out a recovered workunit to the client that was running it
+func (qm *ServerMgr) reclaimWorkunit(work *Workunit, client *Client, record CheckoutRecord) (err error) {
+	keepAttempt(work, record)
+	work.Client = client.ID
+	work.CheckoutTime = record.Time
+	err = qm.workQueue.StatusChange(work.Workunit_Unique_Identifier, work, WORK_STAT_CHECKOUT, "reclaimed after server restart")
+	if err != nil {
+		return
+	}
+	err = client.AssignedWork.Add(work.Workunit_Unique_Identifier)
+	if err != nil {
+		return
+	}
+	err = client.ClearPreempted(work.ID)
+	if err != nil {
+		return
+	}
+	qm.UpdateJobTaskToInProgress([]*Workunit{work})
+	return
+}
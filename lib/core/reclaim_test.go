@@ -0,0 +1,136 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const reclaimTestJob = "0b6f8e52-2c1d-4a8e-9a4f-7d3c5e1f0a41"
+
+// addReclaimTestWork adds a queued workunit to the workqueue, like a recovered job does
+func addReclaimTestWork(t *testing.T, qm *ServerMgr, task string) (work *Workunit) {
+	id := New_Workunit_Unique_Identifier(Task_Unique_Identifier{JobId: reclaimTestJob, TaskName: task}, 0)
+	idStr, _ := id.String()
+	work = &Workunit{Workunit_Unique_Identifier: id, ID: idStr}
+	if err := qm.workQueue.Add(work); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// addReclaimTestClient registers a client that is running the workunits
+func addReclaimTestClient(t *testing.T, qm *ServerMgr, id string, running ...*Workunit) (client *Client) {
+	client = NewClient()
+	client.ID = id
+	for _, work := range running {
+		if err := client.CurrentWork.Add(work.Workunit_Unique_Identifier); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := qm.AddClient(client, true); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func reserve(work *Workunit, record CheckoutRecord, expires time.Time) {
+	record.Work = work.ID
+	reservations.Lock()
+	reservations.work[work.ID] = reservation{record: record, expires: expires}
+	reservations.Unlock()
+}
+
+func TestAdoptReservedWork(t *testing.T) {
+	defer conftest.Save(&JM)()
+	JM = NewJobMap()
+	qm := NewServerMgr()
+	checkoutTime := time.Now().Add(-time.Hour).Round(time.Second)
+	future := time.Now().Add(time.Hour)
+
+	running := addReclaimTestWork(t, qm, "running")
+	dropped := addReclaimTestWork(t, qm, "dropped")
+	waiting := addReclaimTestWork(t, qm, "waiting")
+	expired := addReclaimTestWork(t, qm, "expired")
+	notRecovered := &Workunit{ID: reclaimTestJob + "_notrecovered_0"}
+	addReclaimTestClient(t, qm, "c1", running)
+	addReclaimTestClient(t, qm, "c2")
+
+	reserve(running, CheckoutRecord{Client: "c1", Time: checkoutTime, Failed: 2, Checkpoint: &Checkpoint{Node: "n1"}}, future)
+	reserve(dropped, CheckoutRecord{Client: "c2", Failed: 1}, future)
+	reserve(waiting, CheckoutRecord{Client: "c3"}, future)
+	reserve(expired, CheckoutRecord{Client: "c3"}, time.Now().Add(-time.Second))
+	reserve(notRecovered, CheckoutRecord{Client: "c1"}, future)
+	defer func() {
+		for _, work := range []*Workunit{running, dropped, waiting, expired, notRecovered} {
+			takeReservation(work.ID)
+		}
+	}()
+
+	if reservedFor(running.ID) != "c1" || reservedFor(expired.ID) != "" || reservedFor("unknown") != "" {
+		t.Errorf("unexpected reservations")
+	}
+
+	left := qm.adoptReservedWork()
+	if left != 2 {
+		t.Errorf("got %d reservations left, want 2 (client or workunit not back yet)", left)
+	}
+	tests := []struct {
+		work     *Workunit
+		state    string
+		client   string
+		failed   int
+		reserved bool
+	}{
+		{running, WORK_STAT_CHECKOUT, "c1", 2, false}, // adopted by the client running it
+		{dropped, WORK_STAT_QUEUED, "", 1, false},     // the client re-registered without it
+		{waiting, WORK_STAT_QUEUED, "", 0, true},      // the client is not back yet
+		{expired, WORK_STAT_QUEUED, "", 0, false},     // the grace time passed
+		{notRecovered, "", "", 0, true},               // the job is not recovered yet
+	}
+	for _, test := range tests {
+		work := test.work
+		if work != notRecovered && (work.State != test.state || work.Client != test.client || work.Failed != test.failed) {
+			t.Errorf("%s: got %s %q %d, want %s %q %d", work.ID, work.State, work.Client, work.Failed, test.state, test.client, test.failed)
+		}
+		reservations.Lock()
+		_, reserved := reservations.work[work.ID]
+		reservations.Unlock()
+		if reserved != test.reserved {
+			t.Errorf("%s: reserved %t, want %t", work.ID, reserved, test.reserved)
+		}
+	}
+	if !running.CheckoutTime.Equal(checkoutTime) || running.GetCheckpoint() == nil || running.GetCheckpoint().Node != "n1" {
+		t.Errorf("checkout of the adopted workunit not restored: %s %+v", running.CheckoutTime, running.GetCheckpoint())
+	}
+	client, _, _ := qm.GetClient("c1", true)
+	if assigned, _ := client.AssignedWork.Has(running.Workunit_Unique_Identifier); !assigned {
+		t.Errorf("adopted workunit not assigned to the client")
+	}
+}
+
+func TestReserveCheckedOutWork(t *testing.T) {
+	defer conftest.Save(&conf.RECLAIM_GRACE, &conf.HA_ENABLED)()
+	conf.RECLAIM_GRACE = 60
+	conf.HA_ENABLED = false
+	qm := NewServerMgr()
+
+	c := db.C(conf.DB_COLL_CHECKOUTS)
+	workID := reclaimTestJob + "_persisted_0"
+	if err := c.Insert(&CheckoutRecord{Work: workID, Client: "c1", Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.RemoveAll(bson.M{"_id": workID})
+	defer takeReservation(workID)
+
+	if count := qm.ReserveCheckedOutWork(); count != 1 {
+		t.Errorf("got %d reservations, want 1", count)
+	}
+	if reservedFor(workID) != "c1" {
+		t.Errorf("persisted checkout not reserved for its client")
+	}
+}
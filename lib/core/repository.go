@@ -0,0 +1,120 @@
+package core
+
+import (
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/acl"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The server reads and writes its entities through one repository each. Filters passed to Find are in
+// the query language of the job and clientgroup APIs (MongoDB selectors), every other query has its
+// own method. The document implementations (db_*.go) store the entities with lib/db, which serves
+// MongoDB, PostgreSQL and the embedded database.
+
+// JobRepository the job documents
+type JobRepository interface {
+	Init() error
+	Get(id string) (*Job, error) // the job document only, see LoadJob
+	Save(job *Job) error         // inserts or replaces
+	Delete(id string) error
+	Find(filter bson.M, options *DefaultQueryOptions, results *Jobs) (count int, err error)
+	Count(filter bson.M) (int, error)
+	Distinct(filter bson.M, infoField string) (values interface{}, err error)
+	AdminData(special string) ([]interface{}, error)
+	GetField(id string, field string, result interface{}) error
+	GetACL(id string) (acl.Acl, error)
+	UpdateFields(id string, fields bson.M) error // also sets updatetime
+	GetDocument(id string) (bson.M, error)       // all fields, also those unknown to Job
+	RestoreDocument(doc bson.M) error
+	CompletedBefore(t time.Time, limit int) (ids []string, err error)
+	UpdateTimes(states []string) (times map[string]time.Time, err error)
+	RetentionCandidates(expiresBefore time.Time, finalBefore *time.Time) ([]*retentionJob, error)
+	ReadingJobs(exclude string, states []string, nodes []string) ([]bson.M, error)
+}
+
+// TaskRepository the tasks, in the document of their job (AWE) or of their workflow instance (CWL).
+// Fields are named relative to the task, e.g. "state".
+type TaskRepository interface {
+	Get(jobID string, taskID string) (*Task, error)
+	GetField(jobID string, taskID string, field string) (interface{}, error)
+	Push(jobID string, workflowInstanceID string, task *Task) error
+	UpdateFields(jobID string, workflowInstanceID string, taskID string, fields bson.M) error
+	Increment(jobID string, taskID string, field string, value int) error
+}
+
+// WorkflowInstanceRepository the workflow instances of CWL jobs
+type WorkflowInstanceRepository interface {
+	Init() error
+	Insert(wi *WorkflowInstance) error
+	Update(wi *WorkflowInstance) error
+	Find(filter bson.M, options *DefaultQueryOptions) (results []interface{}, count int, err error)
+	FindOne(filter bson.M) (interface{}, error)
+	UpdateFields(id string, fields bson.M) error // also sets updatetime
+	Increment(id string, field string, value int) error
+	DeleteJob(jobID string) error
+	GetDocuments(jobIDs []string, fields []string) ([]bson.M, error) // all fields if fields is empty
+	RestoreDocument(doc bson.M) error
+	JobsUpdatedSince(t time.Time) (jobIDs []string, err error)
+}
+
+// ClientGroupRepository the clientgroups
+type ClientGroupRepository interface {
+	Init() error
+	Get(id string) (*ClientGroup, error)
+	GetByName(name string) (*ClientGroup, error)
+	GetByToken(token string) (*ClientGroup, error)
+	Find(filter bson.M, options *DefaultQueryOptions, results *ClientGroups) (count int, err error)
+	Save(cg *ClientGroup) error
+	Delete(id string) error
+}
+
+// PerfRepository the performance records of jobs
+type PerfRepository interface {
+	Init() error
+	Get(id string) (*JobPerf, error)
+	Save(perf *JobPerf) error
+	Delete(id string) error
+	Recent(limit int) ([]JobPerf, error) // completed jobs, the most recent first
+	GetDocument(id string) (bson.M, error)
+	RestoreDocument(doc bson.M) error
+}
+
+// ArchiveRepository the archived jobs
+type ArchiveRepository interface {
+	Init() error
+	Get(id string) (*ArchivedJob, error)
+	Save(archived *ArchivedJob) error
+	Delete(id string) error
+	Expired(t time.Time) (ids []string, err error)
+}
+
+// Repositories the repositories of the server
+type Repositories struct {
+	Jobs              JobRepository
+	Tasks             TaskRepository
+	WorkflowInstances WorkflowInstanceRepository
+	ClientGroups      ClientGroupRepository
+	Perf              PerfRepository
+	Archive           ArchiveRepository
+}
+
+// Repo the repositories used by the server, lib/db documents unless replaced (e.g. by tests)
+var Repo = Repositories{
+	Jobs:              documentJobs{},
+	Tasks:             documentTasks{},
+	WorkflowInstances: documentWorkflowInstances{},
+	ClientGroups:      documentClientGroups{},
+	Perf:              documentPerf{},
+	Archive:           documentArchive{},
+}
+
+// InitRepositories creates the indexes
+func InitRepositories() (err error) {
+	for _, init := range []func() error{Repo.Jobs.Init, Repo.WorkflowInstances.Init, Repo.ClientGroups.Init, Repo.Perf.Init, Repo.Archive.Init} {
+		if err = init(); err != nil {
+			return
+		}
+	}
+	return
+}
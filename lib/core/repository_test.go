@@ -0,0 +1,72 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const repositoryTestJob = "5d1c2b7a-8e3f-4a6b-9c0d-1e2f3a4b5c6d"
+
+func TestJobRepository(t *testing.T) {
+	job := NewJob()
+	job.ID = repositoryTestJob
+	job.Entrypoint = "#main"
+	job.State = JOB_STAT_COMPLETED
+	job.Expiration = time.Now().Add(time.Hour).Round(time.Millisecond)
+	task, err := NewTask(job, "", "", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.State = TASK_STAT_COMPLETED
+	job.Tasks = []*Task{task}
+	if err = Repo.Jobs.Save(job); err != nil {
+		t.Fatal(err)
+	}
+	defer Repo.Jobs.Delete(job.ID)
+	taskID, _ := task.String()
+
+	got, err := Repo.Jobs.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != job.ID || got.State != JOB_STAT_COMPLETED || len(got.Tasks) != 1 {
+		t.Errorf("Get returned id %s, state %s and %d tasks", got.ID, got.State, len(got.Tasks))
+	}
+	if count, err := Repo.Jobs.Count(bson.M{"id": job.ID}); err != nil || count != 1 {
+		t.Errorf("Count returned %d, %v", count, err)
+	}
+
+	if err = Repo.Jobs.UpdateFields(job.ID, bson.M{"state": JOB_STAT_SUSPEND}); err != nil {
+		t.Fatal(err)
+	}
+	var state string
+	if err = Repo.Jobs.GetField(job.ID, "state", &state); err != nil || state != JOB_STAT_SUSPEND {
+		t.Errorf("GetField state returned %q, %v", state, err)
+	}
+	expiration, err := dbGetJobFieldTime(job.ID, "expiration")
+	if err != nil || !expiration.Equal(job.Expiration) {
+		t.Errorf("dbGetJobFieldTime returned %v, %v, want %v", expiration, err, job.Expiration)
+	}
+
+	if err = dbUpdateJobTaskString(job.ID, "", taskID, "state", TASK_STAT_SUSPEND); err != nil {
+		t.Fatal(err)
+	}
+	if err = dbIncrementJobTaskField(job.ID, taskID, "remainwork", 2); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := Repo.Tasks.GetField(job.ID, taskID, "state"); err != nil || value != TASK_STAT_SUSPEND {
+		t.Errorf("Tasks.GetField state returned %v, %v", value, err)
+	}
+	if value, err := Repo.Tasks.GetField(job.ID, taskID, "remainwork"); err != nil || asSize(value) != 2 {
+		t.Errorf("Tasks.GetField remainwork returned %v, %v", value, err)
+	}
+
+	if err = Repo.Jobs.Delete(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Repo.Jobs.Get(job.ID); err == nil {
+		t.Errorf("Get found the deleted job")
+	}
+}
@@ -0,0 +1,293 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"gopkg.in/yaml.v2"
+)
+
+// actions of retention rules
+const (
+	RetentionKeep               = "keep"                // never expire the job
+	RetentionDelete             = "delete"              // delete the job (and its intermediate outputs with cleanup_intermediates)
+	RetentionDeleteIntermediate = "delete-intermediate" // delete the intermediate Shock outputs and the job
+	RetentionDeleteOutputs      = "delete-outputs"      // delete all Shock outputs and the job
+	RetentionArchive            = "archive"             // move the job into the archive
+)
+
+var retentionActions = []string{RetentionKeep, RetentionDelete, RetentionDeleteIntermediate, RetentionDeleteOutputs, RetentionArchive}
+
+var (
+	// Retention the retention policy applied by the JobReaper
+	Retention = &RetentionPolicy{}
+)
+
+// RetentionRule matches jobs in a final state by user, project, pipeline and client group, an empty
+// field matches every job. Matching jobs expire Expire after they reached their state.
+type RetentionRule struct {
+	Name        string        `yaml:"name" json:"name"`
+	User        string        `yaml:"user" json:"user,omitempty"`
+	Project     string        `yaml:"project" json:"project,omitempty"`
+	Pipeline    string        `yaml:"pipeline" json:"pipeline,omitempty"`
+	ClientGroup string        `yaml:"clientgroup" json:"clientgroup,omitempty"`
+	States      []string      `yaml:"states" json:"states"` // default: completed
+	Expire      string        `yaml:"expire" json:"expire,omitempty"`
+	Action      string        `yaml:"action" json:"action"` // default: delete
+	after       time.Duration // parsed Expire
+}
+
+// RetentionPolicy an ordered list of rules, the first matching rule applies
+type RetentionPolicy struct {
+	Rules []*RetentionRule `yaml:"rules" json:"rules"`
+}
+
+// RetentionDecision what the policy does with a job and when
+type RetentionDecision struct {
+	JobID    string    `json:"id"`
+	Name     string    `json:"name"`
+	User     string    `json:"user"`
+	Project  string    `json:"project"`
+	Pipeline string    `json:"pipeline"`
+	State    string    `json:"state"`
+	Rule     string    `json:"rule"` // empty if the expiration was set for the job
+	Action   string    `json:"action"`
+	Expires  time.Time `json:"expires"`
+}
+
+// retentionJob the fields of a job document the policy looks at
+type retentionJob struct {
+	ID         string    `bson:"id"`
+	State      string    `bson:"state"`
+	Info       *Info     `bson:"info"`
+	Expiration time.Time `bson:"expiration"`
+	UpdateTime time.Time `bson:"updatetime"`
+}
+
+// InitRetention loads the retention rules of conf.RETENTION_RULES
+func InitRetention() (err error) {
+	var data []byte
+	if conf.RETENTION_RULES != "" {
+		data, err = ioutil.ReadFile(conf.RETENTION_RULES)
+		if err != nil {
+			err = fmt.Errorf("(InitRetention) ioutil.ReadFile returned: %s", err.Error())
+			return
+		}
+	}
+	policy, err := ParseRetentionPolicy(data)
+	if err != nil {
+		err = fmt.Errorf("(InitRetention) %s: %s", conf.RETENTION_RULES, err.Error())
+		return
+	}
+	Retention = policy
+	return
+}
+
+// ParseRetentionPolicy parses rules (YAML or JSON) and appends the rules of global_expire and
+// pipeline_expire, which delete completed jobs
+func ParseRetentionPolicy(data []byte) (policy *RetentionPolicy, err error) {
+	policy = &RetentionPolicy{}
+	err = yaml.Unmarshal(data, policy)
+	if err != nil {
+		err = fmt.Errorf("(ParseRetentionPolicy) yaml.Unmarshal returned: %s", err.Error())
+		return
+	}
+
+	pipelines := []string{}
+	for pipeline := range conf.PIPELINE_EXPIRE_MAP {
+		pipelines = append(pipelines, pipeline)
+	}
+	sort.Strings(pipelines)
+	for _, pipeline := range pipelines {
+		policy.Rules = append(policy.Rules, &RetentionRule{Name: "pipeline_expire " + pipeline, Pipeline: pipeline, Expire: conf.PIPELINE_EXPIRE_MAP[pipeline]})
+	}
+	if conf.GLOBAL_EXPIRE != "" {
+		policy.Rules = append(policy.Rules, &RetentionRule{Name: "global_expire", Expire: conf.GLOBAL_EXPIRE})
+	}
+
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		err = rule.init()
+		if err != nil {
+			err = fmt.Errorf("(ParseRetentionPolicy) %s: %s", rule.Name, err.Error())
+			return
+		}
+	}
+	return
+}
+
+// init validates the rule and sets the defaults
+func (rule *RetentionRule) init() (err error) {
+	if len(rule.States) == 0 {
+		rule.States = []string{JOB_STAT_COMPLETED}
+	}
+	for _, state := range rule.States {
+		if !contains(JOB_STATS_FINAL, state) {
+			err = fmt.Errorf("state %s is not one of %s", state, strings.Join(JOB_STATS_FINAL, ", "))
+			return
+		}
+	}
+	if rule.Action == "" {
+		rule.Action = RetentionDelete
+	}
+	if !contains(retentionActions, rule.Action) {
+		err = fmt.Errorf("action %s is not one of %s", rule.Action, strings.Join(retentionActions, ", "))
+		return
+	}
+	if rule.Action == RetentionKeep {
+		return
+	}
+	rule.after, err = ExpireDuration(rule.Expire)
+	return
+}
+
+// matches true if the rule applies to the job
+func (rule *RetentionRule) matches(job *retentionJob) bool {
+	if !contains(rule.States, job.State) {
+		return false
+	}
+	info := job.Info
+	if info == nil {
+		info = &Info{}
+	}
+	if rule.User != "" && rule.User != info.User {
+		return false
+	}
+	if rule.Project != "" && rule.Project != info.Project {
+		return false
+	}
+	if rule.Pipeline != "" && rule.Pipeline != info.Pipeline {
+		return false
+	}
+	if rule.ClientGroup != "" && !contains(strings.Split(info.ClientGroups, ","), rule.ClientGroup) {
+		return false
+	}
+	return true
+}
+
+// rule the first rule matching the job, nil if none does
+func (policy *RetentionPolicy) rule(job *retentionJob) *RetentionRule {
+	for _, r := range policy.Rules {
+		if r.matches(job) {
+			return r
+		}
+	}
+	return nil
+}
+
+// decide returns the decision for the job, nil if it does not expire
+func (policy *RetentionPolicy) decide(job *retentionJob) (decision *RetentionDecision) {
+	rule := policy.rule(job)
+
+	decision = &RetentionDecision{JobID: job.ID, State: job.State, Action: RetentionDelete}
+	if job.Info != nil {
+		decision.Name = job.Info.Name
+		decision.User = job.Info.User
+		decision.Project = job.Info.Project
+		decision.Pipeline = job.Info.Pipeline
+	}
+
+	if !job.Expiration.IsZero() {
+		// the expiration set for the job overrides the rules, as before completed and
+		// deleted jobs are also deleted without a rule
+		if rule == nil && job.State != JOB_STAT_COMPLETED && job.State != JOB_STAT_DELETED {
+			return nil
+		}
+		if rule != nil && rule.Action != RetentionKeep {
+			decision.Action = rule.Action
+		}
+		decision.Expires = job.Expiration
+		return
+	}
+
+	if rule == nil || rule.Action == RetentionKeep {
+		return nil
+	}
+	final := job.UpdateTime
+	if job.State == JOB_STAT_COMPLETED && job.Info != nil && !job.Info.CompletedTime.IsZero() {
+		final = job.Info.CompletedTime
+	}
+	decision.Rule = rule.Name
+	decision.Action = rule.Action
+	decision.Expires = final.Add(rule.after)
+	return
+}
+
+// minExpire the shortest expiry of the rules that expire jobs, ok is false if no rule does
+func (policy *RetentionPolicy) minExpire() (after time.Duration, ok bool) {
+	for _, r := range policy.Rules {
+		if r.Action == RetentionKeep {
+			continue
+		}
+		if !ok || r.after < after {
+			after = r.after
+			ok = true
+		}
+	}
+	return
+}
+
+// Due returns the decisions for the jobs that expire before now+within, earliest first
+func (policy *RetentionPolicy) Due(now time.Time, within time.Duration) (decisions []*RetentionDecision, err error) {
+	until := now.Add(within)
+	// jobs with an expiration that passes, and jobs without one that reached their state before
+	// the shortest expiry of the rules
+	var finalBefore *time.Time
+	if after, ok := policy.minExpire(); ok {
+		final := until.Add(-after)
+		finalBefore = &final
+	}
+	jobs, err := Repo.Jobs.RetentionCandidates(until, finalBefore)
+	if err != nil {
+		err = fmt.Errorf("(RetentionPolicy/Due) Repo.Jobs.RetentionCandidates returned: %s", err.Error())
+		return
+	}
+
+	decisions = []*RetentionDecision{}
+	for _, job := range jobs {
+		decision := policy.decide(job)
+		if decision != nil && decision.Expires.Before(until) {
+			decisions = append(decisions, decision)
+		}
+	}
+	sort.Slice(decisions, func(i, j int) bool { return decisions[i].Expires.Before(decisions[j].Expires) })
+	return
+}
+
+// applyRetention carries out the decision for an expired job
+func applyRetention(decision *RetentionDecision) (err error) {
+	// the job may have been resumed since the decision was made
+	var state string
+	err = Repo.Jobs.GetField(decision.JobID, "state", &state)
+	if err != nil {
+		err = fmt.Errorf("(applyRetention) Repo.Jobs.GetField returned: %s", err.Error())
+		return
+	}
+	if state != decision.State {
+		return
+	}
+
+	switch decision.Action {
+	case RetentionArchive:
+		return ArchiveJob(decision.JobID)
+	case RetentionDeleteOutputs, RetentionDeleteIntermediate:
+		_, err = cleanupJobNodes(decision.JobID, decision.Action == RetentionDeleteIntermediate)
+		if err != nil {
+			return
+		}
+		err = deleteJobByID(decision.JobID)
+	default:
+		err = deleteJobAndIntermediates(decision.JobID)
+	}
+	if err != nil {
+		return
+	}
+	forgetJob(decision.JobID, state)
+	return
+}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	defer conftest.Save(&conf.GLOBAL_EXPIRE, &conf.PIPELINE_EXPIRE_MAP)()
+	conf.PIPELINE_EXPIRE_MAP = map[string]string{}
+
+	tests := []struct {
+		name         string
+		data         string
+		globalExpire string
+		pipelines    map[string]string
+		want         []RetentionRule // Name, States, Action and after are compared
+		wantErr      string
+	}{
+		{name: "empty"},
+		{name: "defaults", data: "rules:\n- expire: 30D\n", want: []RetentionRule{
+			{Name: "rule 1", States: []string{JOB_STAT_COMPLETED}, Action: RetentionDelete, after: 30 * 24 * time.Hour},
+		}},
+		{name: "json", data: `{"rules": [{"name": "qc", "pipeline": "qc", "states": ["completed", "suspend"], "expire": "2H", "action": "archive"}]}`, want: []RetentionRule{
+			{Name: "qc", States: []string{JOB_STAT_COMPLETED, JOB_STAT_SUSPEND}, Action: RetentionArchive, after: 2 * time.Hour},
+		}},
+		{name: "keep without expire", data: "rules:\n- user: alice\n  action: keep\n", want: []RetentionRule{
+			{Name: "rule 1", States: []string{JOB_STAT_COMPLETED}, Action: RetentionKeep},
+		}},
+		{name: "pipeline and global expire are appended", data: "rules:\n- user: alice\n  action: keep\n", globalExpire: "10D", pipelines: map[string]string{"b": "1D", "a": "30M"}, want: []RetentionRule{
+			{Name: "rule 1", States: []string{JOB_STAT_COMPLETED}, Action: RetentionKeep},
+			{Name: "pipeline_expire a", States: []string{JOB_STAT_COMPLETED}, Action: RetentionDelete, after: 30 * time.Minute},
+			{Name: "pipeline_expire b", States: []string{JOB_STAT_COMPLETED}, Action: RetentionDelete, after: 24 * time.Hour},
+			{Name: "global_expire", States: []string{JOB_STAT_COMPLETED}, Action: RetentionDelete, after: 10 * 24 * time.Hour},
+		}},
+		{name: "state not final", data: "rules:\n- states: [queued]\n  expire: 1D\n", wantErr: "state queued"},
+		{name: "unknown action", data: "rules:\n- expire: 1D\n  action: shred\n", wantErr: "action shred"},
+		{name: "invalid expire", data: "rules:\n- expire: 1W\n", wantErr: "'1W' is invalid"},
+		{name: "missing expire", data: "rules:\n- action: archive\n", wantErr: "is invalid"},
+		{name: "invalid yaml", data: "rules: [", wantErr: "yaml.Unmarshal"},
+	}
+	for _, tt := range tests {
+		conf.GLOBAL_EXPIRE = tt.globalExpire
+		conf.PIPELINE_EXPIRE_MAP = tt.pipelines
+		policy, err := ParseRetentionPolicy([]byte(tt.data))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err.Error())
+			continue
+		}
+		if len(policy.Rules) != len(tt.want) {
+			t.Errorf("%s: got %d rules, want %d", tt.name, len(policy.Rules), len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			got := policy.Rules[i]
+			if got.Name != want.Name || strings.Join(got.States, ",") != strings.Join(want.States, ",") || got.Action != want.Action || got.after != want.after {
+				t.Errorf("%s: rule %d is %s %v %s %v, want %s %v %s %v", tt.name, i, got.Name, got.States, got.Action, got.after, want.Name, want.States, want.Action, want.after)
+			}
+		}
+	}
+}
+
+func TestRetentionRuleMatches(t *testing.T) {
+	rule := &RetentionRule{User: "alice", Project: "p1", Pipeline: "qc", ClientGroup: "gpu", States: []string{JOB_STAT_COMPLETED, JOB_STAT_DELETED}}
+	info := func(user, project, pipeline, clientgroups string) *Info {
+		return &Info{User: user, Project: project, Pipeline: pipeline, ClientGroups: clientgroups}
+	}
+	tests := []struct {
+		name string
+		rule *RetentionRule
+		job  retentionJob
+		want bool
+	}{
+		{"all fields", rule, retentionJob{State: JOB_STAT_COMPLETED, Info: info("alice", "p1", "qc", "gpu")}, true},
+		{"one of the states", rule, retentionJob{State: JOB_STAT_DELETED, Info: info("alice", "p1", "qc", "gpu")}, true},
+		{"other state", rule, retentionJob{State: JOB_STAT_SUSPEND, Info: info("alice", "p1", "qc", "gpu")}, false},
+		{"other user", rule, retentionJob{State: JOB_STAT_COMPLETED, Info: info("bob", "p1", "qc", "gpu")}, false},
+		{"other project", rule, retentionJob{State: JOB_STAT_COMPLETED, Info: info("alice", "p2", "qc", "gpu")}, false},
+		{"other pipeline", rule, retentionJob{State: JOB_STAT_COMPLETED, Info: info("alice", "p1", "assembly", "gpu")}, false},
+		{"one of the clientgroups", rule, retentionJob{State: JOB_STAT_COMPLETED, Info: info("alice", "p1", "qc", "cpu,gpu")}, true},
+		{"clientgroup prefix", rule, retentionJob{State: JOB_STAT_COMPLETED, Info: info("alice", "p1", "qc", "gpu2")}, false},
+		{"no info", rule, retentionJob{State: JOB_STAT_COMPLETED}, false},
+		{"empty fields match every job", &RetentionRule{States: []string{JOB_STAT_COMPLETED}}, retentionJob{State: JOB_STAT_COMPLETED}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(&tt.job); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testRetentionPolicy archives qc jobs after a day, keeps the jobs of keeper and deletes completed jobs after a week
+func testRetentionPolicy(t *testing.T) *RetentionPolicy {
+	policy, err := ParseRetentionPolicy([]byte(`rules:
+- name: qc
+  pipeline: qc
+  expire: 1D
+  action: archive
+- name: keeper
+  user: keeper
+  action: keep
+- name: week
+  expire: 7D
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestRetentionPolicyDecide(t *testing.T) {
+	defer conftest.Save(&conf.GLOBAL_EXPIRE, &conf.PIPELINE_EXPIRE_MAP)()
+	conf.GLOBAL_EXPIRE = ""
+	conf.PIPELINE_EXPIRE_MAP = map[string]string{}
+	policy := testRetentionPolicy(t)
+	completed := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := completed.Add(time.Hour)
+	expiration := completed.Add(48 * time.Hour)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name       string
+		job        retentionJob
+		wantRule   string
+		wantAction string
+		wantExpire time.Time // zero: no decision
+	}{
+		{"rule by pipeline", retentionJob{State: JOB_STAT_COMPLETED, Info: &Info{Pipeline: "qc", CompletedTime: completed}}, "qc", RetentionArchive, completed.Add(day)},
+		{"first matching rule", retentionJob{State: JOB_STAT_COMPLETED, Info: &Info{Pipeline: "qc", User: "keeper", CompletedTime: completed}}, "qc", RetentionArchive, completed.Add(day)},
+		{"keep", retentionJob{State: JOB_STAT_COMPLETED, Info: &Info{User: "keeper", CompletedTime: completed}}, "", "", time.Time{}},
+		{"last rule", retentionJob{State: JOB_STAT_COMPLETED, Info: &Info{CompletedTime: completed}, UpdateTime: updated}, "week", RetentionDelete, completed.Add(7 * day)},
+		{"update time without completed time", retentionJob{State: JOB_STAT_COMPLETED, Info: &Info{}, UpdateTime: updated}, "week", RetentionDelete, updated.Add(7 * day)},
+		{"no matching rule", retentionJob{State: JOB_STAT_SUSPEND, Info: &Info{}, UpdateTime: updated}, "", "", time.Time{}},
+		{"expiration of the job", retentionJob{State: JOB_STAT_COMPLETED, Info: &Info{CompletedTime: completed}, Expiration: expiration}, "", RetentionDelete, expiration},
+		{"expiration with the action of the rule", retentionJob{State: JOB_STAT_COMPLETED, Info: &Info{Pipeline: "qc"}, Expiration: expiration}, "", RetentionArchive, expiration},
+		{"expiration overrides keep", retentionJob{State: JOB_STAT_COMPLETED, Info: &Info{User: "keeper"}, Expiration: expiration}, "", RetentionDelete, expiration},
+		{"expiration of a deleted job without rule", retentionJob{State: JOB_STAT_DELETED, Info: &Info{}, Expiration: expiration}, "", RetentionDelete, expiration},
+		{"expiration of a suspended job without rule", retentionJob{State: JOB_STAT_SUSPEND, Info: &Info{}, Expiration: expiration}, "", "", time.Time{}},
+	}
+	for _, tt := range tests {
+		tt.job.ID = "job"
+		decision := policy.decide(&tt.job)
+		if tt.wantExpire.IsZero() {
+			if decision != nil {
+				t.Errorf("%s: got decision %+v, want none", tt.name, decision)
+			}
+			continue
+		}
+		if decision == nil {
+			t.Errorf("%s: got no decision", tt.name)
+			continue
+		}
+		if decision.JobID != "job" || decision.Rule != tt.wantRule || decision.Action != tt.wantAction || !decision.Expires.Equal(tt.wantExpire) {
+			t.Errorf("%s: got rule %q, action %s, expires %v, want %q, %s, %v", tt.name, decision.Rule, decision.Action, decision.Expires, tt.wantRule, tt.wantAction, tt.wantExpire)
+		}
+	}
+}
+
+func TestRetentionPolicyDue(t *testing.T) {
+	defer conftest.Save(&conf.GLOBAL_EXPIRE, &conf.PIPELINE_EXPIRE_MAP)()
+	conf.GLOBAL_EXPIRE = ""
+	conf.PIPELINE_EXPIRE_MAP = map[string]string{}
+	policy := testRetentionPolicy(t)
+	now := time.Now().Round(time.Millisecond)
+	day := 24 * time.Hour
+
+	jobs := []struct {
+		id         string
+		state      string
+		pipeline   string
+		user       string
+		completed  time.Duration // before now
+		expiration time.Duration // after now, 0: not set
+	}{
+		{"qc", JOB_STAT_COMPLETED, "qc", "", 2 * day, 0},                     // expired a day ago
+		{"week", JOB_STAT_COMPLETED, "", "", 9 * day, 0},                     // expired two days ago
+		{"qc-recent", JOB_STAT_COMPLETED, "qc", "", time.Hour, 0},            // expires in 23 hours
+		{"keeper", JOB_STAT_COMPLETED, "", "keeper", 30 * day, 0},            // kept
+		{"expiration", JOB_STAT_COMPLETED, "", "", time.Hour, -time.Hour},    // expired an hour ago
+		{"expiration-later", JOB_STAT_COMPLETED, "", "", time.Hour, 2 * day}, // expires in two days
+		{"in-progress", JOB_STAT_INPROGRESS, "", "", 30 * day, 0},            // not final
+		{"suspended", JOB_STAT_SUSPEND, "", "", 30 * day, -time.Hour},        // no rule for suspended jobs
+	}
+	ids := map[string]bool{}
+	for _, j := range jobs {
+		job := NewJob()
+		job.ID = "retention-" + j.id
+		job.State = j.state
+		job.Info.Pipeline = j.pipeline
+		job.Info.User = j.user
+		job.Info.CompletedTime = now.Add(-j.completed)
+		job.UpdateTime = job.Info.CompletedTime
+		if j.expiration != 0 {
+			job.Expiration = now.Add(j.expiration)
+		}
+		if err := Repo.Jobs.Save(job); err != nil {
+			t.Fatal(err)
+		}
+		ids[job.ID] = true
+		defer Repo.Jobs.Delete(job.ID)
+	}
+
+	tests := []struct {
+		within time.Duration
+		want   []string
+	}{
+		{0, []string{"week", "qc", "expiration"}},
+		{day, []string{"week", "qc", "expiration", "qc-recent"}},
+		{3 * day, []string{"week", "qc", "expiration", "qc-recent", "expiration-later"}},
+	}
+	for _, tt := range tests {
+		decisions, err := policy.Due(now, tt.within)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, decision := range decisions {
+			if ids[decision.JobID] {
+				got = append(got, strings.TrimPrefix(decision.JobID, "retention-"))
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("within %v: got %v, want %v", tt.within, got, tt.want)
+		}
+	}
+}
@@ -43,6 +43,9 @@ type ServerMgr struct {
 	TaskMap        TaskMap
 	ajLock         sync.RWMutex
 	actJobs        map[string]*JobPerf
+
+	readiness *readinessQueue // tasks to evaluate after a dependency changed
+	wiLock    sync.Mutex      // only one goroutine instantiates pending workflow instances
 }
 
 // NewServerMgr _
@@ -61,6 +64,7 @@ func NewServerMgr() *ServerMgr {
 		lastUpdate: time.Now().Add(time.Second * -30),
 		TaskMap:    *NewTaskMap(),
 		actJobs:    map[string]*JobPerf{},
+		readiness:  newReadinessQueue(),
 	}
 }
 
@@ -78,9 +82,10 @@ func (qm *ServerMgr) RLock() {}
 // RUnlock _
 func (qm *ServerMgr) RUnlock() {}
 
-// UpdateQueueLoop _
+// UpdateQueueLoop tasks are enqueued by the readiness workers when their dependencies complete, this
+// loop is a reconciliation pass through all tasks for anything the events missed
 func (qm *ServerMgr) UpdateQueueLoop() {
-	// TODO this may not be dynamic enough for small amounts of workunits, as they always have to wait
+	qm.startReadinessWorkers()
 
 	var err error
 	for {
@@ -100,16 +105,12 @@ func (qm *ServerMgr) UpdateQueueLoop() {
 			logger.Error("(UpdateQueueLoop) updateQueue returned: %s", err.Error())
 			err = nil
 		}
-		elapsed := time.Since(start)        // type Duration
-		elapsedSeconds := elapsed.Seconds() // type float64
+		elapsed := time.Since(start) // type Duration
 
-		var sleeptime time.Duration
-		if elapsedSeconds <= 1 {
-			sleeptime = 1 * time.Second // wait at least 1 second
-		} else if elapsedSeconds > 1 && elapsedSeconds < 30 {
+		sleeptime := reconcileInterval()
+		if elapsed > sleeptime {
+			// do not spend most of the time reconciling
 			sleeptime = elapsed
-		} else {
-			sleeptime = 30 * time.Second // wait at most 30 seconds
 		}
 
 		logger.Debug(0, "(UpdateQueueLoop) elapsed: %s (sleeping for %s)", elapsed, sleeptime)
@@ -388,9 +389,9 @@ func (qm *ServerMgr) updateWorkflowInstancesMapTask(workflowInstance *WorkflowIn
 						return
 					}
 
-					err = qm.TaskMap.Add(aweTask, "updateWorkflowInstancesMapTask")
+					err = qm.addTask(aweTask, "updateWorkflowInstancesMapTask")
 					if err != nil {
-						err = fmt.Errorf("(updateWorkflowInstancesMapTask) qm.TaskMap.Add returned: %s", err.Error())
+						err = fmt.Errorf("(updateWorkflowInstancesMapTask) qm.addTask returned: %s", err.Error())
 						return
 					}
 
@@ -589,6 +590,8 @@ func (qm *ServerMgr) updateWorkflowInstancesMapTask(workflowInstance *WorkflowIn
 }
 
 func (qm *ServerMgr) updateWorkflowInstancesMap() (err error) {
+	qm.wiLock.Lock()
+	defer qm.wiLock.Unlock()
 
 	var wis []*WorkflowInstance
 	wis, err = GlobalWorkflowInstanceMap.GetWorkflowInstances()
@@ -818,6 +821,9 @@ func (qm *ServerMgr) GetQueue(name string) interface{} {
 	if name == "client" {
 		return &qm.clientMap
 	}
+	if name == "readiness" {
+		return qm.GetReadinessStats()
+	}
 	return nil
 }
 
@@ -1060,6 +1066,7 @@ func (qm *ServerMgr) updateQueue(logTimes bool) (err error) {
 		}
 	}
 	close(queueChan)
+	qm.readiness.recordReconcile(queued, time.Since(loopStart))
 	logger.Debug(0, "(updateQueue) completed loop through TaskMap; # processed: %d, queued: %d, skipped: %d, took %s", size, queued, skipped, time.Since(loopStart))
 
 	logger.Debug(3, "(updateQueue) range qm.workQueue.Clean()")
@@ -1093,32 +1100,13 @@ func (qm *ServerMgr) updateQueueWorker(id int, logTimes bool, taskChan <-chan *T
 		taskStart := time.Now()
 		taskIDStr, _ := task.String()
 
-		isQueued, times, skip, err := qm.updateQueueTask(task, logTimes)
-		if err != nil {
-
-			logger.Error("(updateQueueWorker) qm.updateQueueTask returned: %s", err.Error())
-
-			jerror := &JobError{
-				ClientFailed: "NA",
-				WorkFailed:   "NA",
-				TaskFailed:   taskIDStr,
-				ServerNotes:  "updateQueueTask returned error: " + err.Error(),
-				WorkNotes:    "NA",
-				AppError:     "NA",
-				Status:       JOB_STAT_SUSPEND,
-			}
-			err = nil
-
-			_ = task.SetState(TASK_STAT_SUSPEND, true, "updateQueueWorker")
-
-			jobID := task.JobId
-
-			err = qm.SuspendJob(jobID, nil, jerror)
-			if err != nil {
-				logger.Error("(updateQueueWorker) SuspendJob failed: jobID=%s; err=%s", jobID, err.Error())
-				err = nil
-			}
+		if !qm.readiness.tryBegin(task) {
+			// a readiness worker is evaluating it
+			queueChan <- 2
+			continue
 		}
+		isQueued, times, skip := qm.processQueueTask(task, logTimes)
+		qm.readiness.done(task)
 		if skip {
 			queueChan <- 2 // skipped
 			continue
@@ -1139,6 +1127,37 @@ func (qm *ServerMgr) updateQueueWorker(id int, logTimes bool, taskChan <-chan *T
 	}
 }
 
+// processQueueTask checks if a task is ready and enqueues it, the job is suspended on error
+func (qm *ServerMgr) processQueueTask(task *Task, logTimes bool) (isQueued bool, times map[string]time.Duration, skip bool) {
+	isQueued, times, skip, err := qm.updateQueueTask(task, logTimes)
+	if err == nil {
+		return
+	}
+	taskIDStr, _ := task.String()
+
+	logger.Error("(processQueueTask) qm.updateQueueTask returned: %s", err.Error())
+
+	jerror := &JobError{
+		ClientFailed: "NA",
+		WorkFailed:   "NA",
+		TaskFailed:   taskIDStr,
+		ServerNotes:  "updateQueueTask returned error: " + err.Error(),
+		WorkNotes:    "NA",
+		AppError:     "NA",
+		Status:       JOB_STAT_SUSPEND,
+	}
+
+	_ = task.SetState(TASK_STAT_SUSPEND, true, "processQueueTask")
+
+	jobID := task.JobId
+
+	err = qm.SuspendJob(jobID, nil, jerror)
+	if err != nil {
+		logger.Error("(processQueueTask) SuspendJob failed: jobID=%s; err=%s", jobID, err.Error())
+	}
+	return
+}
+
 // updateQueueTask
 // returns skip if task is locked
 func (qm *ServerMgr) updateQueueTask(task *Task, logTimes bool) (isQueued bool, times map[string]time.Duration, skip bool, err error) {
@@ -1617,6 +1636,12 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 			return
 		}
 		defer RemoveWorkFromClient(client, workID)
+
+		if client.WasPreempted(workStr) {
+			// the workunit has been requeued, it may run on another client already
+			logger.Info("(handleNoticeWorkDelivered) ignoring workunit %s from client %s, it was preempted", workStr, clientid)
+			return
+		}
 	}
 	// *** Get Task
 	var task *Task
@@ -1646,6 +1671,15 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 		err = fmt.Errorf("(handleNoticeWorkDelivered) workunit %s not found in workQueue", workStr)
 		return
 	}
+	if work.State == WORK_STAT_QUEUED && client != nil && reservedFor(workStr) == clientid {
+		// delivered by the client that ran it before the server restarted
+		record, _ := takeReservation(workStr)
+		err = qm.reclaimWorkunit(work, client, record)
+		if err != nil {
+			err = fmt.Errorf("(handleNoticeWorkDelivered) qm.reclaimWorkunit returned: %s", err.Error())
+			return
+		}
+	}
 	workState := work.State
 
 	if workState != WORK_STAT_CHECKOUT && workState != WORK_STAT_RESERVED {
@@ -1672,10 +1706,13 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 	task.Unlock()
 
 	var MAX_FAILURE int
+	var MAX_OOM int
 	if noretry == true {
 		MAX_FAILURE = 1
+		MAX_OOM = 1
 	} else {
 		MAX_FAILURE = conf.MAX_WORK_FAILURE
+		MAX_OOM = conf.MAX_WORK_OOM
 	}
 
 	var taskState string
@@ -1704,6 +1741,7 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 			err = fmt.Errorf("(handleNoticeWorkDelivered) handleWorkStatDone returned: %s", err.Error())
 			return
 		}
+		work.DeleteCheckpoint()
 	case WORK_STAT_FAILED_PERMANENT: // (special case !) failed and cannot be recovered
 
 		logger.Event(event.WORK_FAILED, "workid="+workStr+";clientid="+clientid)
@@ -1737,18 +1775,30 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 		if err != nil {
 			logger.Error("(handleNoticeWorkDelivered:SuspendJob) jobID=%s; err=%s", jobID, err.Error())
 		}
-	case WORK_STAT_ERROR: //workunit failed, requeue or put it to suspend list
-		logger.Event(event.WORK_FAIL, "workid="+workStr+";clientid="+clientid)
+	case WORK_STAT_ERROR, WORK_STAT_FAILED_OOM: //workunit failed, requeue or put it to suspend list
+		// an out-of-memory kill is not held against the client, the workunit is retried elsewhere
+		oomKilled := noticeStatus == WORK_STAT_FAILED_OOM
+		logger.Event(event.WORK_FAIL, "workid="+workStr+";clientid="+clientid+fmt.Sprintf(";oom=%t", oomKilled))
 		logger.Debug(3, "(handleNoticeWorkDelivered) work failed (status=%s, notes: %s) workid=%s clientid=%s", noticeStatus, notes, workStr, clientid)
 
-		work.Failed++
+		var retry bool
+		reason := "work.Failed >= MAX_FAILURE"
+		if oomKilled {
+			// counted separately, the normal retries are kept for other failures
+			work.OOM++
+			retry = work.OOM < MAX_OOM
+			reason = "work.OOM >= MAX_OOM"
+		} else {
+			work.Failed++
+			retry = work.Failed < MAX_FAILURE
+		}
 
-		if work.Failed < MAX_FAILURE {
+		if retry {
 			qm.workQueue.StatusChange(Workunit_Unique_Identifier{}, work, WORK_STAT_QUEUED, "")
 			logger.Event(event.WORK_REQUEUE, "workid="+workStr)
 		} else {
 			//failure time exceeds limit, suspend workunit, task, job
-			err = qm.workQueue.StatusChange(Workunit_Unique_Identifier{}, work, WORK_STAT_SUSPEND, "work.Failed >= MAX_FAILURE")
+			err = qm.workQueue.StatusChange(Workunit_Unique_Identifier{}, work, WORK_STAT_SUSPEND, reason)
 			if err != nil {
 				err = fmt.Errorf("(handleNoticeWorkDelivered) qm.workQueue.StatusChange returned: %s", err.Error())
 				return
@@ -1767,11 +1817,15 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 				err = fmt.Errorf("(handleNoticeWorkDelivered) task.String returned: %s", err.Error())
 				return
 			}
+			serverNotes := fmt.Sprintf("workunit failed %d time(s)", MAX_FAILURE)
+			if oomKilled {
+				serverNotes = fmt.Sprintf("workunit container exceeded its memory limit %d time(s)", MAX_OOM)
+			}
 			jerror := &JobError{
 				ClientFailed: clientid,
 				WorkFailed:   workStr,
 				TaskFailed:   taskStr,
-				ServerNotes:  fmt.Sprintf("workunit failed %d time(s)", MAX_FAILURE),
+				ServerNotes:  serverNotes,
 				WorkNotes:    notes,
 				AppError:     notice.Stderr,
 				Status:       JOB_STAT_SUSPEND,
@@ -1828,6 +1882,10 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 			return
 		}
 
+		if oomKilled {
+			return
+		}
+
 		var lastFailed int
 		lastFailed, err = client.IncrementLastFailed(true)
 		if err != nil {
@@ -1837,7 +1895,7 @@ func (qm *ServerMgr) handleNoticeWorkDelivered(notice Notice) (err error) {
 			qm.SuspendClient(clientid, client, "MAX_CLIENT_FAILURE on client reached", true)
 		}
 	default:
-		err = fmt.Errorf("No handler for workunit status '%s' implemented (allowd: %s, %s, %s)", noticeStatus, WORK_STAT_DONE, WORK_STAT_FAILED_PERMANENT, WORK_STAT_ERROR)
+		err = fmt.Errorf("No handler for workunit status '%s' implemented (allowd: %s, %s, %s, %s)", noticeStatus, WORK_STAT_DONE, WORK_STAT_FAILED_PERMANENT, WORK_STAT_ERROR, WORK_STAT_FAILED_OOM)
 		return
 	}
 	return
@@ -2136,6 +2194,21 @@ func (qm *ServerMgr) GetReportMsg(id Workunit_Unique_Identifier, logname string)
 	return string(content), err
 }
 
+// GetUsageReport returns the resource usage of the last run of a workunit
+func (qm *ServerMgr) GetUsageReport(id Workunit_Unique_Identifier) (usage *ResourceUsage, err error) {
+	report, err := qm.GetReportMsg(id, "usage")
+	if err != nil {
+		return
+	}
+	usage = new(ResourceUsage)
+	err = json.Unmarshal([]byte(report), usage)
+	if err != nil {
+		err = fmt.Errorf("(GetUsageReport) json.Unmarshal returned: %s", err.Error())
+		usage = nil
+	}
+	return
+}
+
 func getStdLogPathByWorkID(id Workunit_Unique_Identifier, logname string) (savedpath string, err error) {
 	jobid := id.JobId
 
@@ -2152,7 +2225,8 @@ func getStdLogPathByWorkID(id Workunit_Unique_Identifier, logname string) (saved
 		return
 	}
 
-	savedpath = fmt.Sprintf("%s/%s.%s", logdir, workStr, logname)
+	// CWL task names contain "/"
+	savedpath = fmt.Sprintf("%s/%s.%s", logdir, strings.Replace(workStr, "/", "_", -1), logname)
 	return
 }
 
@@ -2237,9 +2311,9 @@ func (qm *ServerMgr) EnqueueTasks(tasks []*Task) (err error) {
 		// add to qm.TaskMap
 		// updateQueue() process will actually enqueue the task
 		// TaskMap.Add - makes it a pending task if init, throws error if task already in map with different pointer
-		err = qm.TaskMap.Add(task, "EnqueueTasks")
+		err = qm.addTask(task, "EnqueueTasks")
 		if err != nil {
-			err = fmt.Errorf("(EnqueueTasks) qm.TaskMap.Add() returns: %s", err.Error())
+			err = fmt.Errorf("(EnqueueTasks) qm.addTask returned: %s", err.Error())
 			return
 		}
 	}
@@ -2300,9 +2374,9 @@ func (qm *ServerMgr) EnqueueTasksByJobId(jobid string, caller string) (err error
 		// add to qm.TaskMap
 		// updateQueue() process will actually enqueue the task
 		// TaskMap.Add - makes it a pending task if init, throws error if task already in map with different pointer
-		err = qm.TaskMap.Add(task, "EnqueueTasksByJobId/"+caller)
+		err = qm.addTask(task, "EnqueueTasksByJobId/"+caller)
 		if err != nil {
-			err = fmt.Errorf("(EnqueueTasksByJobId) qm.TaskMap.Add() returns: %s", err.Error())
+			err = fmt.Errorf("(EnqueueTasksByJobId) qm.addTask returned: %s", err.Error())
 			return
 		}
 	}
@@ -2319,6 +2393,11 @@ func (qm *ServerMgr) EnqueueTasksByJobId(jobid string, caller string) (err error
 		}
 	}
 
+	if job.IsCWL {
+		// workflow instances that did not create their tasks yet, e.g. the root of a recovered job
+		qm.NotifyWorkflowInstancesPending(job)
+	}
+
 	return
 }
 
@@ -3082,7 +3161,7 @@ func (qm *ServerMgr) processInstanceEnQueueScatter(parentWorkflowInstance *Workf
 				err = fmt.Errorf("(processInstanceEnQueueScatter) workflowInstance.AddTask returned: %s", err.Error())
 				return
 			}
-			err = qm.TaskMap.Add(dummyTask, "processInstanceEnQueueScatter")
+			err = qm.addTask(dummyTask, "processInstanceEnQueueScatter")
 			if err != nil {
 				err = fmt.Errorf("(processInstanceEnQueueScatter) workflowInstance.AddTask returned: %s", err.Error())
 				return
@@ -3303,10 +3382,10 @@ func (qm *ServerMgr) processInstanceEnQueueScatter(parentWorkflowInstance *Workf
 				return
 			}
 
-			err = qm.TaskMap.Add(subTask, "processInstanceEnQueueScatter")
+			err = qm.addTask(subTask, "processInstanceEnQueueScatter")
 			if err != nil {
 				//subTaskIDStr := subTask.ID
-				err = fmt.Errorf("(processInstanceEnQueueScatter) subProcessIDStr=%s qm.TaskMap.Add() returns: %s", subProcessIDStr, err.Error())
+				err = fmt.Errorf("(processInstanceEnQueueScatter) subProcessIDStr=%s qm.addTask returned: %s", subProcessIDStr, err.Error())
 				return
 			}
 
@@ -3520,6 +3599,7 @@ func (qm *ServerMgr) taskEnQueue(taskID Task_Unique_Identifier, task *Task, job
 
 	// log event about task enqueue (TQ)
 	logger.Event(event.TASK_ENQUEUE, fmt.Sprintf("taskid=%s;totalwork=%d", taskIDStr, task.TotalWork))
+	qm.CreateJobPerf(task.JobId) // CWL jobs get their perf with the first task
 	err = qm.CreateTaskPerf(task)
 	if err != nil {
 		err = fmt.Errorf("(taskEnQueue) CreateTaskPerf returned: %s", err.Error())
@@ -5765,6 +5845,9 @@ func (qm *ServerMgr) completeSubworkflow(job *Job, workflowInstance *WorkflowIns
 		return
 	}
 
+	// steps of the parent may wait for the outputs of this subworkflow
+	qm.notifyWorkflowInstance(job, parent)
+
 	if parentRemain > 0 {
 		// no need to notify
 		logger.Debug(3, "(completeSubworkflow) no need to complete parent workflow")
@@ -5875,6 +5958,9 @@ func (qm *ServerMgr) taskCompleted(wi *WorkflowInstance, task *Task) (err error)
 
 	_ = task.SetTaskNotReadyReason("", true)
 
+	// evaluate the tasks waiting for this one right away
+	qm.notifyDependents(job, wi, task)
+
 	// ******************
 	// check if workflowInstance needs to be completed
 
@@ -6004,14 +6090,17 @@ func (qm *ServerMgr) finalizeJob(job *Job) (err error) {
 	qm.FinalizeJobPerf(jobid)
 	qm.LogJobPerf(jobid)
 	qm.removeActJob(jobid)
+	deadlineCompleted(job)
 	//delete tasks in task map
 	//delete from shock output flagged for deletion
 
 	modified := 0
 	for i, task := range job.TaskList() {
-		// delete nodes that have been flagged to be deleted
-		modified += task.DeleteOutput()
-		modified += task.DeleteInput()
+		// delete nodes that have been flagged to be deleted, the cleanup deletes them with the other intermediate nodes
+		if !conf.CLEANUP_INTERMEDIATES {
+			modified += task.DeleteOutput()
+			modified += task.DeleteInput()
+		}
 		//combined_id := jobid + "_" + task.Id
 
 		id, _ := task.GetID("updateJobTask." + strconv.Itoa(i))
@@ -6028,36 +6117,28 @@ func (qm *ServerMgr) finalizeJob(job *Job) (err error) {
 		job.Save() // TODO avoid this, try partial updates
 	}
 
-	//set expiration from conf if not set
-	nullTime := time.Time{}
-	var job_expiration time.Time
-	job_expiration, err = dbGetJobFieldTime(jobid, "expiration")
+	// set the expiration of the first matching retention rule (global_expire and pipeline_expire
+	// are rules too), unless one was set for the job
+	var jobExpiration time.Time
+	jobExpiration, err = dbGetJobFieldTime(jobid, "expiration")
 	if err != nil {
 		err = fmt.Errorf("(updateJobTask) dbGetJobFieldTime returned: %s", err.Error())
 		return
 	}
-
-	if job_expiration == nullTime {
-		expire := conf.GLOBAL_EXPIRE
-
-		var job_info_pipeline string
-		job_info_pipeline, err = dbGetJobFieldString(jobid, "info.pipeline")
-		if err != nil {
-			err = fmt.Errorf("(updateJobTask) dbGetJobFieldTime returned: %s", err.Error())
-			return
-		}
-
-		if val, ok := conf.PIPELINE_EXPIRE_MAP[job_info_pipeline]; ok {
-			expire = val
-		}
-		if expire != "" {
-			err = job.SetExpiration(expire)
+	if jobExpiration.IsZero() {
+		rule := Retention.rule(&retentionJob{ID: jobid, State: JOB_STAT_COMPLETED, Info: job.Info})
+		if rule != nil && rule.Action != RetentionKeep {
+			err = job.SetExpiration(rule.Expire)
 			if err != nil {
 				err = fmt.Errorf("(updateJobTask) SetExpiration returned: %s", err.Error())
 				return
 			}
 		}
 	}
+
+	// delete the intermediate nodes that no live job reads
+	go cleanupIntermediates(jobid, false)
+
 	//log event about job done (JD)
 	logger.Event(event.JOB_DONE, "jobid="+job.ID+";name="+job.Info.Name+";project="+job.Info.Project+";user="+job.Info.User)
 
@@ -6230,6 +6311,21 @@ func (qm *ServerMgr) DeleteJobByUser(jobid string, u *user.User, full bool) (err
 	if err = job.SetState(JOB_STAT_DELETED, nil); err != nil {
 		return
 	}
+	if err = qm.unregisterJob(jobid); err != nil {
+		return
+	}
+	// really delete it !
+	if full {
+		return deleteJobAndIntermediates(jobid)
+	} else {
+		go cleanupIntermediates(jobid, true)
+		logger.Event(event.JOB_DELETED, "jobid="+jobid)
+	}
+	return
+}
+
+// unregisterJob removes the workunits and tasks of a job from the queue and the job from the job map
+func (qm *ServerMgr) unregisterJob(jobid string) (err error) {
 	//delete queueing workunits
 	var workunit_list []*Workunit
 	workunit_list, err = qm.workQueue.GetAll()
@@ -6238,33 +6334,32 @@ func (qm *ServerMgr) DeleteJobByUser(jobid string, u *user.User, full bool) (err
 	}
 	for _, workunit := range workunit_list {
 		workid := workunit.Workunit_Unique_Identifier
-		workunit_jobid := workid.JobId
-		//parentid, _ := GetJobIdByWorkId(workid)
-		if jobid == workunit_jobid {
+		if jobid == workid.JobId {
 			qm.workQueue.Delete(workid)
 		}
 	}
 	//delete parsed tasks
-	for i := 0; i < len(job.TaskList()); i++ {
-		//taskID := fmt.Sprintf("%s_%d", jobid, i)
-		var taskID Task_Unique_Identifier
-		taskID, err = New_Task_Unique_Identifier(jobid, strconv.Itoa(i)) // TODO that will not work
-		if err != nil {
-			return
+	var tasks []*Task
+	tasks, err = qm.TaskMap.GetTasks()
+	if err != nil {
+		return
+	}
+	for _, task := range tasks {
+		taskID, xerr := task.GetID("unregisterJob")
+		if xerr != nil {
+			continue
+		}
+		if taskID.JobId == jobid {
+			qm.TaskMap.Delete(taskID)
 		}
-		qm.TaskMap.Delete(taskID)
 	}
 	qm.removeActJob(jobid)
-	//qm.removeSusJob(jobid)
 	// delete from job map
 	if err = JM.Delete(jobid, true); err != nil {
 		return
 	}
-	// really delete it !
-	if full {
-		return job.Delete()
-	} else {
-		logger.Event(event.JOB_DELETED, "jobid="+jobid)
+	if GlobalWorkflowInstanceMap != nil {
+		err = GlobalWorkflowInstanceMap.DeleteJob(jobid)
 	}
 	return
 }
@@ -6401,11 +6496,13 @@ func (qm *ServerMgr) RecoverJob(id string, job *Job) (recovered bool, err error)
 	}
 
 	if jobState == JOB_STAT_SUSPEND {
-		// just add suspended jobs to in-memory map
-		err = JM.Add(job)
-		if err != nil {
-			err = errors.New("(RecoverJob) JM.Add failed " + err.Error())
-			return
+		// just add suspended jobs to in-memory map, jobs of the warm standby are already in it
+		if _, inMap, _ := JM.Get(id, true); !inMap {
+			err = JM.Add(job)
+			if err != nil {
+				err = errors.New("(RecoverJob) JM.Add failed " + err.Error())
+				return
+			}
 		}
 	} else {
 		if jobState == JOB_STAT_COMPLETED || jobState == JOB_STAT_DELETED || jobState == JOB_STAT_FAILED_PERMANENT {
@@ -6450,6 +6547,37 @@ func (qm *ServerMgr) RecoverJob(id string, job *Job) (recovered bool, err error)
 
 //recover jobs not completed before awe-server restarts
 func (qm *ServerMgr) RecoverJobs() (recovered int, total int, err error) {
+	if !conf.HA_ENABLED {
+		// workunits still running on clients are given back to them when they re-register, a new
+		// HA leader reserved them when it was promoted
+		reserved := qm.ReserveCheckedOutWork()
+		logger.Info("(RecoverJobs) %d checked out workunits reserved for their clients", reserved)
+	}
+
+	if conf.HA_ENABLED {
+		if jobs, ok := takeWarmJobs(); ok {
+			logger.Info("(RecoverJobs) recovering the %d jobs kept by the standby", len(jobs))
+			total = len(jobs)
+			for _, job := range jobs {
+				err = JM.Add(job)
+				if err != nil {
+					logger.Error("(RecoverJobs) job=%s: %s", job.ID, err.Error())
+					err = nil
+					continue
+				}
+				isRecovered, rerr := qm.RecoverJob("", job)
+				if rerr != nil {
+					logger.Error(fmt.Sprintf("(RecoverJobs) job=%s failed: %s", job.ID, rerr.Error()))
+					continue
+				}
+				if isRecovered {
+					recovered += 1
+				}
+			}
+			return
+		}
+	}
+
 	//Get jobs to be recovered from db whose states are recoverable
 	dbjobs := new(Jobs)
 	q := bson.M{}
@@ -6750,6 +6878,7 @@ func (qm *ServerMgr) CreateTaskPerf(task *Task) (err error) {
 			return
 		}
 		perf.Ptasks[task_str] = NewTaskPerf(task_str)
+		perf.Ptasks[task_str].Tool = taskTool(task)
 		qm.putActJob(perf)
 	}
 	return
@@ -6795,6 +6924,9 @@ func (qm *ServerMgr) FinalizeTaskPerf(task *Task) (err error) {
 			now := time.Now().Unix()
 			taskperf.End = now
 			taskperf.Resp = now - taskperf.Queued
+			if taskperf.Start > 0 {
+				taskRuntimes.add(taskperf.Tool, taskperf.End-taskperf.Start)
+			}
 
 			for _, io := range task.Inputs {
 				taskperf.InFileSizes = append(taskperf.InFileSizes, io.Size)
@@ -6877,7 +7009,7 @@ func (qm *ServerMgr) LogJobPerf(jobid string) {
 	if perf, ok := qm.getActJob(jobid); ok {
 		perfstr, _ := json.Marshal(perf)
 		logger.Perf(string(perfstr)) //write into perf log
-		dbUpsert(perf)               //write into mongodb
+		Repo.Perf.Save(perf)         //write into the database
 	}
 }
 
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+)
+
+func TestGetStdLogPathByWorkID(t *testing.T) {
+	defer conftest.Save(&conf.DATA_PATH)()
+	conf.DATA_PATH = "/data"
+	tests := []struct {
+		taskName string
+		want     string
+	}{
+		{"0", "/data/ab/cd/ef/abcdef12/abcdef12_0_1.stdout"},
+		{"#main/step/sub", "/data/ab/cd/ef/abcdef12/abcdef12_#main_step_sub_1.stdout"},
+	}
+	for _, test := range tests {
+		id := New_Workunit_Unique_Identifier(Task_Unique_Identifier{JobId: "abcdef12", TaskName: test.taskName}, 1)
+		got, err := getStdLogPathByWorkID(id, "stdout")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.taskName, got, test.want)
+		}
+	}
+}
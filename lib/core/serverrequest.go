@@ -0,0 +1,187 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/golib/httpclient"
+)
+
+var (
+	serverTransport     *http.Transport
+	serverTransportErr  error
+	serverTransportOnce sync.Once
+
+	// defaultServerClient sends the requests of ServerClient without a worker certificate
+	defaultServerClient = &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // same as httpclient
+		Proxy:           http.ProxyFromEnvironment,
+		IdleConnTimeout: 90 * time.Second,
+	}}
+)
+
+// newServerTransport creates the transport used by the worker for mutual TLS with the server
+func newServerTransport() (t *http.Transport, err error) {
+	cert, err := tls.LoadX509KeyPair(conf.CLIENT_SSL_CERT, conf.CLIENT_SSL_KEY)
+	if err != nil {
+		err = fmt.Errorf("(newServerTransport) could not load worker certificate: %s", err.Error())
+		return
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if conf.CLIENT_SSL_CA != "" {
+		var caPEM []byte
+		caPEM, err = ioutil.ReadFile(conf.CLIENT_SSL_CA)
+		if err != nil {
+			err = fmt.Errorf("(newServerTransport) could not read ssl_ca: %s", err.Error())
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			err = fmt.Errorf("(newServerTransport) no certificate found in %s", conf.CLIENT_SSL_CA)
+			return
+		}
+		tlsConfig.RootCAs = pool
+	} else {
+		tlsConfig.InsecureSkipVerify = true // same as httpclient
+	}
+	t = &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}
+	return
+}
+
+// DoServerRequest sends a request from the worker to the AWE server. If a worker certificate is
+// configured (ssl_cert, ssl_key) it is presented to the server, otherwise this is httpclient.DoTimeout.
+// With an HA cluster the following requests go to the leader named by a standby, or to the next
+// server of server_urls if the server does not respond; the caller retries.
+func DoServerRequest(method string, url string, header httpclient.Header, data io.Reader, timeout time.Duration) (res *http.Response, err error) {
+	res, err = doServerRequest(method, url, header, data, timeout)
+	serverResponded(res, err)
+	return
+}
+
+// ServerClient sends the requests of lib/client like DoServerRequest, with the context of the request
+type ServerClient struct{}
+
+// Do _
+func (ServerClient) Do(req *http.Request) (res *http.Response, err error) {
+	httpClient := defaultServerClient
+	if conf.CLIENT_SSL_CERT != "" {
+		serverTransportOnce.Do(func() {
+			serverTransport, serverTransportErr = newServerTransport()
+		})
+		if serverTransportErr != nil {
+			err = serverTransportErr
+			return
+		}
+		httpClient = &http.Client{Transport: serverTransport}
+	}
+	res, err = httpClient.Do(req)
+	serverResponded(res, err)
+	return
+}
+
+// serverResponded switches the server for the following requests if needed
+func serverResponded(res *http.Response, err error) {
+	if err != nil {
+		nextServerURL()
+		return
+	}
+	if res.StatusCode == http.StatusServiceUnavailable {
+		followLeader(res.Header.Get(LeaderHeader))
+	}
+}
+
+// serverURLLock guards conf.SERVER_URL, which the failover changes while requests are sent
+var serverURLLock sync.RWMutex
+
+// ServerURL API URL of the server the worker sends its requests to
+func ServerURL() string {
+	serverURLLock.RLock()
+	defer serverURLLock.RUnlock()
+	return conf.SERVER_URL
+}
+
+// followLeader switches to the leader named by a standby server. Only servers listed in server_urls
+// are accepted, the requests carry the clientgroup token.
+func followLeader(leader string) {
+	leader = strings.TrimSuffix(leader, "/")
+	if leader == "" {
+		return
+	}
+	serverURLLock.Lock()
+	defer serverURLLock.Unlock()
+	if leader == conf.SERVER_URL {
+		return
+	}
+	known := false
+	for _, url := range conf.SERVER_URL_LIST {
+		if url == leader {
+			known = true
+			break
+		}
+	}
+	if !known {
+		logger.Warning("(followLeader) %s names the leader %s, which is not listed in server_urls, ignored", conf.SERVER_URL, leader)
+		return
+	}
+	logger.Info("(followLeader) %s is a standby server, switching to the leader %s", conf.SERVER_URL, leader)
+	conf.SERVER_URL = leader
+}
+
+// nextServerURL switches to the next server of server_urls
+func nextServerURL() {
+	if len(conf.SERVER_URL_LIST) < 2 {
+		return
+	}
+	serverURLLock.Lock()
+	defer serverURLLock.Unlock()
+	next := conf.SERVER_URL_LIST[0]
+	for i, url := range conf.SERVER_URL_LIST {
+		if url == conf.SERVER_URL {
+			next = conf.SERVER_URL_LIST[(i+1)%len(conf.SERVER_URL_LIST)]
+			break
+		}
+	}
+	logger.Info("(nextServerURL) %s does not respond, switching to %s", conf.SERVER_URL, next)
+	conf.SERVER_URL = next
+}
+
+func doServerRequest(method string, url string, header httpclient.Header, data io.Reader, timeout time.Duration) (res *http.Response, err error) {
+	if conf.CLIENT_SSL_CERT == "" {
+		return httpclient.DoTimeout(method, url, header, data, nil, timeout)
+	}
+
+	serverTransportOnce.Do(func() {
+		serverTransport, serverTransportErr = newServerTransport()
+	})
+	if serverTransportErr != nil {
+		err = serverTransportErr
+		return
+	}
+
+	req, err := http.NewRequest(method, url, data)
+	if err != nil {
+		err = fmt.Errorf("(DoServerRequest) http.NewRequest returned: %s", err.Error())
+		return
+	}
+	for k, v := range header {
+		for _, v2 := range v {
+			req.Header.Add(k, v2)
+		}
+	}
+	if length, ok := header["Content-Length"]; ok && len(length) > 0 {
+		req.ContentLength, _ = strconv.ParseInt(length[0], 10, 64)
+	}
+	client := &http.Client{Transport: serverTransport, Timeout: timeout}
+	res, err = client.Do(req)
+	return
+}
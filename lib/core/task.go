@@ -1623,7 +1623,7 @@ func (task *Task) SetResetTaskOutputs() (err error) {
 		if dataUrl, _ := io.DataUrl(); dataUrl != "" {
 			// delete dataUrl if is shock node
 			if strings.HasSuffix(dataUrl, shock.DATA_SUFFIX) {
-				err = shock.ShockDelete(io.Host, io.Node, io.DataToken)
+				err = io.DeleteNode()
 				if err == nil {
 					logger.Debug(2, "Deleted node %s from shock", io.Node)
 				} else {
@@ -0,0 +1,308 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// readinessWorkers number of goroutines evaluating tasks after events
+const readinessWorkers = 8
+
+// ReadinessStats scheduling latency of the event-driven task readiness, shown with GET /queue?readiness
+type ReadinessStats struct {
+	Events            int64   `json:"events"`             // task evaluations triggered by an event
+	Enqueued          int64   `json:"enqueued"`           // tasks enqueued after an event
+	Pending           int     `json:"pending"`            // tasks waiting for evaluation
+	LatencyAvgMs      float64 `json:"latency_avg_ms"`     // from the event (e.g. completion of a dependency) to enqueuing
+	LatencyMaxMs      float64 `json:"latency_max_ms"`     //
+	LatencyLastMs     float64 `json:"latency_last_ms"`    //
+	ReconcilePasses   int64   `json:"reconcile_passes"`   // passes through all tasks of the TaskMap
+	ReconcileEnqueued int64   `json:"reconcile_enqueued"` // tasks enqueued by a pass, i.e. missed by events
+	ReconcileLastMs   float64 `json:"reconcile_last_ms"`  // duration of the last pass
+	latencySumMs      float64
+}
+
+// readinessQueue tasks to evaluate because one of their dependencies changed. A task is evaluated
+// by one goroutine at a time, events arriving during an evaluation trigger another one.
+type readinessQueue struct {
+	sync.Mutex
+	cond       *sync.Cond
+	order      []*Task
+	pending    map[*Task]time.Time // time of the earliest event not yet evaluated
+	evaluating map[*Task]bool
+	again      map[*Task]time.Time // events that arrived during an evaluation
+	retry      map[*Task]time.Time // skipped tasks, pushed again by retryLoop
+	stats      ReadinessStats
+}
+
+func newReadinessQueue() (rq *readinessQueue) {
+	rq = &readinessQueue{
+		pending:    make(map[*Task]time.Time),
+		evaluating: make(map[*Task]bool),
+		again:      make(map[*Task]time.Time),
+		retry:      make(map[*Task]time.Time),
+	}
+	rq.cond = sync.NewCond(rq)
+	return
+}
+
+func (rq *readinessQueue) push(task *Task, since time.Time) {
+	rq.Lock()
+	defer rq.Unlock()
+	rq.pushLocked(task, since)
+}
+
+func (rq *readinessQueue) pushLocked(task *Task, since time.Time) {
+	if rq.evaluating[task] {
+		if _, ok := rq.again[task]; !ok {
+			rq.again[task] = since
+		}
+		return
+	}
+	if _, ok := rq.pending[task]; ok {
+		return
+	}
+	rq.pending[task] = since
+	rq.order = append(rq.order, task)
+	rq.cond.Signal()
+}
+
+// pop blocks until a task is pending, the task is then marked as being evaluated
+func (rq *readinessQueue) pop() (task *Task, since time.Time) {
+	rq.Lock()
+	defer rq.Unlock()
+	for {
+		for len(rq.order) == 0 {
+			rq.cond.Wait()
+		}
+		task = rq.order[0]
+		rq.order = rq.order[1:]
+		var ok bool
+		since, ok = rq.pending[task]
+		if !ok {
+			// taken over by the reconciliation pass
+			continue
+		}
+		delete(rq.pending, task)
+		rq.evaluating[task] = true
+		return
+	}
+}
+
+// tryBegin used by the reconciliation pass, false if the task is being evaluated already
+func (rq *readinessQueue) tryBegin(task *Task) bool {
+	rq.Lock()
+	defer rq.Unlock()
+	if rq.evaluating[task] {
+		return false
+	}
+	delete(rq.pending, task)
+	rq.evaluating[task] = true
+	return true
+}
+
+func (rq *readinessQueue) done(task *Task) {
+	rq.Lock()
+	defer rq.Unlock()
+	delete(rq.evaluating, task)
+	if since, ok := rq.again[task]; ok {
+		delete(rq.again, task)
+		rq.pushLocked(task, since)
+	}
+}
+
+// later a task could not be evaluated, e.g. because it or its job was locked
+func (rq *readinessQueue) later(task *Task, since time.Time) {
+	rq.Lock()
+	defer rq.Unlock()
+	if _, ok := rq.retry[task]; !ok {
+		rq.retry[task] = since
+	}
+}
+
+// retryLoop pushes the skipped tasks again once per interval
+func (rq *readinessQueue) retryLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		rq.Lock()
+		for task, since := range rq.retry {
+			delete(rq.retry, task)
+			rq.pushLocked(task, since)
+		}
+		rq.Unlock()
+	}
+}
+
+func (rq *readinessQueue) recordEvent(enqueued bool, since time.Time) {
+	rq.Lock()
+	defer rq.Unlock()
+	rq.stats.Events++
+	if !enqueued {
+		return
+	}
+	latency := float64(time.Since(since)) / float64(time.Millisecond)
+	rq.stats.Enqueued++
+	rq.stats.latencySumMs += latency
+	rq.stats.LatencyAvgMs = rq.stats.latencySumMs / float64(rq.stats.Enqueued)
+	rq.stats.LatencyLastMs = latency
+	if latency > rq.stats.LatencyMaxMs {
+		rq.stats.LatencyMaxMs = latency
+	}
+}
+
+func (rq *readinessQueue) recordReconcile(enqueued int, elapsed time.Duration) {
+	rq.Lock()
+	defer rq.Unlock()
+	rq.stats.ReconcilePasses++
+	rq.stats.ReconcileEnqueued += int64(enqueued)
+	rq.stats.ReconcileLastMs = float64(elapsed) / float64(time.Millisecond)
+}
+
+// GetReadinessStats _
+func (qm *ServerMgr) GetReadinessStats() (stats ReadinessStats) {
+	qm.readiness.Lock()
+	defer qm.readiness.Unlock()
+	stats = qm.readiness.stats
+	stats.Pending = len(qm.readiness.pending)
+	return
+}
+
+// startReadinessWorkers evaluates tasks as soon as events arrive
+func (qm *ServerMgr) startReadinessWorkers() {
+	for w := 0; w < readinessWorkers; w++ {
+		go qm.readinessWorker()
+	}
+	go qm.readiness.retryLoop(time.Second)
+}
+
+func (qm *ServerMgr) readinessWorker() {
+	for {
+		task, since := qm.readiness.pop()
+		isQueued, _, skip := qm.processQueueTask(task, false)
+		qm.readiness.done(task)
+		if skip {
+			// task or job locked, try again shortly
+			qm.readiness.later(task, since)
+			continue
+		}
+		qm.readiness.recordEvent(isQueued, since)
+	}
+}
+
+// addTask adds a task to the TaskMap and evaluates it right away
+func (qm *ServerMgr) addTask(task *Task, caller string) (err error) {
+	err = qm.TaskMap.Add(task, caller)
+	if err != nil {
+		return
+	}
+	qm.readiness.push(task, time.Now())
+	return
+}
+
+// notifyDependents a task completed: evaluates the tasks that depend on it, the other steps of its
+// workflow instance (CWL) or the tasks listing it in DependsOn (AWE), and the pending workflow
+// instances of the job
+func (qm *ServerMgr) notifyDependents(job *Job, wi *WorkflowInstance, completed *Task) {
+	now := time.Now()
+
+	if wi != nil {
+		qm.notifyWorkflowInstance(job, wi)
+		return
+	}
+
+	completedStr, err := completed.String()
+	if err != nil {
+		logger.Error("(notifyDependents) completed.String returned: %s", err.Error())
+		return
+	}
+	tasks, err := job.GetTasks()
+	if err != nil {
+		logger.Error("(notifyDependents) job.GetTasks returned: %s", err.Error())
+		return
+	}
+	for _, task := range tasks {
+		if task == completed {
+			continue
+		}
+		dependsOn, xerr := task.GetDependsOn()
+		if xerr != nil {
+			continue
+		}
+		for _, dep := range dependsOn {
+			if dep == completedStr {
+				qm.readiness.push(task, now)
+				break
+			}
+		}
+	}
+}
+
+// notifyWorkflowInstance evaluates the waiting tasks of a workflow instance, e.g. after a step or
+// subworkflow completed, and instantiates pending workflow instances of the job that became ready
+func (qm *ServerMgr) notifyWorkflowInstance(job *Job, wi *WorkflowInstance) {
+	now := time.Now()
+	tasks, err := wi.GetTasks(true)
+	if err != nil {
+		logger.Error("(notifyWorkflowInstance) wi.GetTasks returned: %s", err.Error())
+		return
+	}
+	for _, task := range tasks {
+		state, xerr := task.GetStateTimeout(time.Second)
+		if xerr != nil || state == TASK_STAT_INIT || state == TASK_STAT_PENDING || state == TASK_STAT_READY {
+			qm.readiness.push(task, now)
+		}
+	}
+
+	// a subworkflow waiting for outputs of the completed step
+	qm.NotifyWorkflowInstancesPending(job)
+}
+
+// NotifyWorkflowInstancesPending instantiates the pending workflow instances of a job that are ready,
+// e.g. the root workflow instance after the job was submitted or recovered
+func (qm *ServerMgr) NotifyWorkflowInstancesPending(job *Job) {
+	go func() {
+		err := qm.updatePendingWorkflowInstances(job)
+		if err != nil {
+			logger.Error("(NotifyWorkflowInstancesPending) %s", err.Error())
+		}
+	}()
+}
+
+// updatePendingWorkflowInstances creates the tasks of pending workflow instances of a job that are ready
+func (qm *ServerMgr) updatePendingWorkflowInstances(job *Job) (err error) {
+	qm.wiLock.Lock()
+	defer qm.wiLock.Unlock()
+
+	wis := []*WorkflowInstance{}
+	lock, err := job.RLockNamed("updatePendingWorkflowInstances")
+	if err != nil {
+		return
+	}
+	for _, wi := range job.WorkflowInstancesMap {
+		wis = append(wis, wi)
+	}
+	job.RUnlockNamed(lock)
+
+	for _, wi := range wis {
+		state, xerr := wi.GetState(true)
+		if xerr != nil || state != WIStatePending {
+			continue
+		}
+		err = qm.updateWorkflowInstancesMapTask(wi)
+		if err != nil {
+			err = fmt.Errorf("(updatePendingWorkflowInstances) updateWorkflowInstancesMapTask returned: %s", err.Error())
+			return
+		}
+	}
+	return
+}
+
+// reconcileInterval time between reconciliation passes through all tasks
+func reconcileInterval() time.Duration {
+	return time.Duration(conf.RECONCILE_INTERVAL) * time.Second
+}
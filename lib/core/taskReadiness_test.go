@@ -0,0 +1,144 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadinessQueuePush(t *testing.T) {
+	rq := newReadinessQueue()
+	a, b := &Task{}, &Task{}
+	first := time.Now().Add(-time.Second)
+
+	// a pending task is queued once and keeps the time of its earliest event
+	rq.push(a, first)
+	rq.push(b, time.Now())
+	rq.push(a, time.Now())
+	if len(rq.order) != 2 || len(rq.pending) != 2 {
+		t.Fatalf("task not deduplicated: order %d, pending %d", len(rq.order), len(rq.pending))
+	}
+	task, since := rq.pop()
+	if task != a || !since.Equal(first) {
+		t.Errorf("got %p %s, want %p %s", task, since, a, first)
+	}
+	if !rq.evaluating[a] || len(rq.pending) != 1 {
+		t.Errorf("popped task not marked as evaluating")
+	}
+	if task, _ = rq.pop(); task != b {
+		t.Errorf("tasks not popped in order")
+	}
+}
+
+func TestReadinessQueueTransitions(t *testing.T) {
+	rq := newReadinessQueue()
+	task := &Task{}
+	first, second := time.Now().Add(-time.Minute), time.Now()
+
+	rq.push(task, first)
+	rq.pop()
+
+	// events during an evaluation trigger another one after done
+	rq.push(task, second)
+	rq.push(task, time.Now())
+	if len(rq.order) != 0 || len(rq.pending) != 0 {
+		t.Errorf("task queued while being evaluated")
+	}
+	if since, ok := rq.again[task]; !ok || !since.Equal(second) {
+		t.Errorf("event during the evaluation not recorded: %v", rq.again)
+	}
+	rq.done(task)
+	if rq.evaluating[task] || len(rq.again) != 0 {
+		t.Errorf("evaluation not finished")
+	}
+	if since, ok := rq.pending[task]; !ok || !since.Equal(second) {
+		t.Errorf("task not pending again: %v", rq.pending)
+	}
+
+	// the reconciliation pass takes over a pending task, pop skips it
+	if !rq.tryBegin(task) {
+		t.Fatal("tryBegin failed for a pending task")
+	}
+	if rq.tryBegin(task) {
+		t.Errorf("tryBegin succeeded for a task being evaluated")
+	}
+	rq.done(task)
+	other := &Task{}
+	rq.push(other, time.Now())
+	if popped, _ := rq.pop(); popped != other {
+		t.Errorf("pop returned a task taken over by the reconciliation pass")
+	}
+	rq.done(other)
+
+	// skipped tasks are pushed again by the retry loop
+	rq.later(task, first)
+	rq.later(task, second)
+	go rq.retryLoop(time.Millisecond)
+	popped, since := rq.pop()
+	if popped != task || !since.Equal(first) {
+		t.Errorf("skipped task not retried with its first event: %p %s", popped, since)
+	}
+}
+
+func TestReadinessQueueConcurrent(t *testing.T) {
+	rq := newReadinessQueue()
+	tasks := make([]*Task, 20)
+	for i := range tasks {
+		tasks[i] = &Task{}
+	}
+
+	var mutex sync.Mutex
+	evaluating := make(map[*Task]bool)
+	evaluations := 0
+	for w := 0; w < readinessWorkers; w++ {
+		go func() {
+			for {
+				task, _ := rq.pop()
+				mutex.Lock()
+				if evaluating[task] {
+					t.Errorf("task evaluated by two goroutines")
+				}
+				evaluating[task] = true
+				mutex.Unlock()
+				time.Sleep(time.Microsecond)
+				mutex.Lock()
+				delete(evaluating, task)
+				evaluations++
+				mutex.Unlock()
+				rq.done(task)
+				rq.recordEvent(true, time.Now())
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				rq.push(tasks[i%len(tasks)], time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		rq.Lock()
+		idle := len(rq.pending) == 0 && len(rq.evaluating) == 0 && len(rq.again) == 0
+		rq.Unlock()
+		if idle {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("queue not drained")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if evaluations < len(tasks) || evaluations > 400 {
+		t.Errorf("unexpected number of evaluations %d", evaluations)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"os/exec"
 	"time"
 
-	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/logger/event"
 )
@@ -55,7 +54,7 @@ func notifySubClients(clientid string, count int) (err error) {
 	argv := []string{}
 	argv = append(argv, "-X")
 	argv = append(argv, "PUT")
-	target_url := fmt.Sprintf("%s/client/%s?subclients=%d", conf.SERVER_URL, clientid, count)
+	target_url := fmt.Sprintf("%s/client/%s?subclients=%d", ServerURL(), clientid, count)
 	argv = append(argv, target_url)
 	cmd := exec.Command("curl", argv...)
 	err = cmd.Run()
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// warmJob documents of an unfinished job as read by a standby
+type warmJob struct {
+	job        *Job
+	wis        []interface{}
+	updateTime time.Time
+}
+
+// warmJobs documents of the unfinished jobs kept by a standby, a new leader recovers them without
+// reading all jobs from the database. A refresh lists the ids of the unfinished jobs and reads only
+// the jobs whose document or WorkflowInstances changed. The standby holds all unfinished jobs in
+// memory, about as much as the leader does.
+var warmJobs = struct {
+	sync.Mutex
+	jobs      map[string]*warmJob
+	refreshed time.Time // start of the last refresh, zero if there was none
+}{jobs: make(map[string]*warmJob)}
+
+// refreshWarmJobs reads the unfinished jobs that changed since the last refresh
+func refreshWarmJobs() (err error) {
+	start := time.Now()
+	warmJobs.Lock()
+	since := warmJobs.refreshed
+	warmJobs.Unlock()
+
+	current, err := Repo.Jobs.UpdateTimes(JOB_STATS_TO_RECOVER)
+	if err != nil {
+		err = fmt.Errorf("(refreshWarmJobs) Repo.Jobs.UpdateTimes returned: %s", err.Error())
+		return
+	}
+
+	// WorkflowInstances carry the time of the leader, the lease time covers a clock skew
+	changedWIs := make(map[string]bool)
+	if !since.IsZero() {
+		var jobIDs []string
+		after := since.Add(-time.Duration(conf.HA_LEASE_SECONDS) * time.Second)
+		jobIDs, err = Repo.WorkflowInstances.JobsUpdatedSince(after)
+		if err != nil {
+			err = fmt.Errorf("(refreshWarmJobs) Repo.WorkflowInstances.JobsUpdatedSince returned: %s", err.Error())
+			return
+		}
+		for _, jobID := range jobIDs {
+			changedWIs[jobID] = true
+		}
+	}
+
+	unfinished := make(map[string]bool, len(current))
+	stale := []string{}
+	warmJobs.Lock()
+	for id, updateTime := range current {
+		unfinished[id] = true
+		warm, ok := warmJobs.jobs[id]
+		if !ok || !warm.updateTime.Equal(updateTime) || changedWIs[id] {
+			stale = append(stale, id)
+		}
+	}
+	for id := range warmJobs.jobs {
+		if !unfinished[id] {
+			delete(warmJobs.jobs, id)
+		}
+	}
+	warmJobs.Unlock()
+
+	for _, id := range stale {
+		job, wis, xerr := readJobDocuments(id)
+		if xerr != nil {
+			// e.g. deleted in the meantime, read again by the next refresh
+			logger.Debug(1, "(refreshWarmJobs) %s", xerr.Error())
+			continue
+		}
+		warmJobs.Lock()
+		warmJobs.jobs[id] = &warmJob{job: job, wis: wis, updateTime: job.UpdateTime}
+		warmJobs.Unlock()
+	}
+
+	warmJobs.Lock()
+	warmJobs.refreshed = start
+	warmJobs.Unlock()
+	logger.Debug(1, "(refreshWarmJobs) %d unfinished jobs, %d read", len(current), len(stale))
+	return
+}
+
+// takeWarmJobs initializes the jobs kept by the standby after a last refresh, ok is false if this
+// server never refreshed them. The jobs are ordered like RecoverJobs does and limited by recover_max.
+func takeWarmJobs() (jobs []*Job, ok bool) {
+	warmJobs.Lock()
+	refreshed := !warmJobs.refreshed.IsZero()
+	warmJobs.Unlock()
+	if !refreshed {
+		return
+	}
+	err := refreshWarmJobs()
+	if err != nil {
+		logger.Error("(takeWarmJobs) using the jobs of the previous refresh: %s", err.Error())
+	}
+
+	warmJobs.Lock()
+	warm := warmJobs.jobs
+	warmJobs.jobs = make(map[string]*warmJob)
+	warmJobs.Unlock()
+
+	candidates := make([]*warmJob, 0, len(warm))
+	for _, w := range warm {
+		candidates = append(candidates, w)
+	}
+	info := func(w *warmJob) *Info {
+		if w.job.Info == nil {
+			return &Info{}
+		}
+		return w.job.Info
+	}
+	if conf.RECOVER_MAX > 0 {
+		sort.Slice(candidates, func(i, j int) bool { return info(candidates[i]).Priority > info(candidates[j]).Priority })
+		if len(candidates) > conf.RECOVER_MAX {
+			candidates = candidates[:conf.RECOVER_MAX]
+		}
+	} else {
+		sort.Slice(candidates, func(i, j int) bool {
+			return info(candidates[i]).SubmitTime.Before(info(candidates[j]).SubmitTime)
+		})
+	}
+
+	for _, w := range candidates {
+		xerr := initJobDocuments(w.job, w.wis)
+		if xerr != nil {
+			logger.Error("(takeWarmJobs) job=%s: %s", w.job.ID, xerr.Error())
+			continue
+		}
+		jobs = append(jobs, w.job)
+	}
+	ok = true
+	return
+}
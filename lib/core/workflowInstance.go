@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"path"
 	"reflect"
+	"time"
 
 	"github.com/MG-RAST/AWE/lib/acl"
 	"github.com/MG-RAST/AWE/lib/core/cwl"
@@ -74,7 +75,8 @@ type WorkflowInstance struct {
 	Parent              *WorkflowInstance `bson:"-" json:"-" mapstructure:"-"` // cache for ParentId
 	Job                 *Job              `bson:"-" json:"-" mapstructure:"-"` // cache
 	//IsScatter           bool              `bson:"isscatter" json:"isscatter" mapstructure:"isscatter"`
-	ScatterParent string `bson:"scatter_parent" json:"scatter_parent" mapstructure:"scatter_parent"`
+	ScatterParent string    `bson:"scatter_parent" json:"scatter_parent" mapstructure:"scatter_parent"`
+	UpdateTime    time.Time `bson:"updatetime" json:"-" mapstructure:"-"` // set with every write, read by the warm standby
 	//Created_by          string            `bson:"created_by" json:"created_by" mapstructure:"created_by"`
 }
 
@@ -677,40 +679,6 @@ func (wi *WorkflowInstance) Init(job *Job) (changed bool, err error) {
 	return
 }
 
-// Save _
-func (wi *WorkflowInstance) SaveDEPRECATED(readLock bool) (err error) {
-	if readLock {
-		var lock rwmutex.ReadLock
-		lock, err = wi.RLockNamed("WorkflowInstance/Save")
-		if err != nil {
-			return
-		}
-		defer wi.RUnlockNamed(lock)
-	}
-
-	if wi.LocalID == "" {
-		err = fmt.Errorf("(WorkflowInstance/Save) job id empty")
-		return
-	}
-
-	if wi.ACL == nil {
-		err = fmt.Errorf("(WorkflowInstance/Save) wi.ACL == nil ")
-		return
-	}
-
-	logger.Debug(1, "(WorkflowInstance/Save)  dbUpsert next: %s", wi.LocalID)
-	//spew.Dump(job)
-
-	err = dbUpsert(wi)
-	if err != nil {
-		spew.Dump(wi)
-		err = fmt.Errorf("(WorkflowInstance/Save) dbUpsert failed (wi.ID=%s) error=%s", wi.ID, err.Error())
-		return
-	}
-	logger.Debug(1, "(WorkflowInstance/Save)  wi saved: %s", wi.LocalID)
-	return
-}
-
 // Insert _
 func (wi *WorkflowInstance) Insert(readLock bool) (err error) {
 	if readLock {
@@ -732,13 +700,13 @@ func (wi *WorkflowInstance) Insert(readLock bool) (err error) {
 		return
 	}
 
-	logger.Debug(1, "(WorkflowInstance/Insert)  dbUpsert next: %s", wi.ID)
+	logger.Debug(1, "(WorkflowInstance/Insert)  Repo.WorkflowInstances.Insert next: %s", wi.ID)
 	//spew.Dump(job)
 
-	err = dbInsert(wi)
+	err = Repo.WorkflowInstances.Insert(wi)
 	if err != nil {
 		spew.Dump(wi)
-		err = fmt.Errorf("(WorkflowInstance/Insert) dbInsert failed (wi.ID=%s) error=%s", wi.ID, err.Error())
+		err = fmt.Errorf("(WorkflowInstance/Insert) Repo.WorkflowInstances.Insert failed (wi.ID=%s) error=%s", wi.ID, err.Error())
 		return
 	}
 	logger.Debug(1, "(WorkflowInstance/Insert)  wi saved: %s", wi.ID)
@@ -766,13 +734,13 @@ func (wi *WorkflowInstance) Update(readLock bool) (err error) {
 		return
 	}
 
-	logger.Debug(1, "(WorkflowInstance/Update)  dbUpdate next: %s", wi.ID)
+	logger.Debug(1, "(WorkflowInstance/Update)  Repo.WorkflowInstances.Update next: %s", wi.ID)
 	//spew.Dump(job)
 
-	err = dbUpdate(wi)
+	err = Repo.WorkflowInstances.Update(wi)
 	if err != nil {
 		spew.Dump(wi)
-		err = fmt.Errorf("(WorkflowInstance/Update) dbUpdate failed (wi.ID=%s) error=%s", wi.ID, err.Error())
+		err = fmt.Errorf("(WorkflowInstance/Update) Repo.WorkflowInstances.Update failed (wi.ID=%s) error=%s", wi.ID, err.Error())
 		return
 	}
 	logger.Debug(1, "(WorkflowInstance/Update)  wi saved: %s", wi.ID)
@@ -63,3 +63,19 @@ func (wim *WorkflowInstanceMap) Get(id string) (workflow_instance *WorkflowInsta
 	workflow_instance, ok = wim._map[id]
 	return
 }
+
+// DeleteJob removes the workflow instances of a job
+func (wim *WorkflowInstanceMap) DeleteJob(jobID string) (err error) {
+	err = wim.LockNamed("WorkflowInstanceMap/DeleteJob")
+	if err != nil {
+		return
+	}
+	defer wim.Unlock()
+
+	for id, wi := range wim._map {
+		if wi.JobID == jobID {
+			delete(wim._map, id)
+		}
+	}
+	return
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"errors"
 	"sort"
+	"time"
 
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/logger"
@@ -142,6 +143,9 @@ func (wq *WorkQueue) Delete(id Workunit_Unique_Identifier) (err error) {
 	if err != nil {
 		return
 	}
+	if workStr, xerr := id.String(); xerr == nil {
+		dbDeleteCheckout(workStr)
+	}
 	return
 
 }
@@ -180,7 +184,9 @@ func (wq *WorkQueue) StatusChange(id Workunit_Unique_Identifier, workunit *Worku
 	if workunit.State == new_status {
 		return
 	}
-	if workunit.State != WORK_STAT_CHECKOUT && workunit.State != WORK_STAT_RESERVED {
+	wasCheckedOut := workunit.State == WORK_STAT_CHECKOUT
+	if new_status != WORK_STAT_CHECKOUT && workunit.State != WORK_STAT_CHECKOUT && workunit.State != WORK_STAT_RESERVED {
+		// keep the client set by the caller for a checkout
 		workunit.Client = ""
 	}
 
@@ -201,6 +207,7 @@ func (wq *WorkQueue) StatusChange(id Workunit_Unique_Identifier, workunit *Worku
 		if err != nil {
 			return
 		}
+		workunit.QueuedTime = time.Now()
 		wq.Queue.Set(workunit)
 
 	case WORK_STAT_SUSPEND:
@@ -226,6 +233,13 @@ func (wq *WorkQueue) StatusChange(id Workunit_Unique_Identifier, workunit *Worku
 		}
 	}
 
+	// persisted for a lossless server restart
+	if new_status == WORK_STAT_CHECKOUT {
+		dbSaveCheckout(workunit)
+	} else if wasCheckedOut {
+		dbDeleteCheckout(workunit.ID)
+	}
+
 	return
 }
 
@@ -235,7 +249,7 @@ func (wq *WorkQueue) selectWorkunits(workunits WorkList, policy string, availabl
 	logger.Debug(3, "starting selectWorkunits")
 
 	if policy == "FCFS" {
-		sort.Sort(byFCFS{workunits})
+		sort.Sort(byFCFS{WorkList: workunits, now: time.Now()})
 	}
 	added := 0
 	for _, work := range workunits {
@@ -270,12 +284,15 @@ type WorkList []*Workunit
 func (wl WorkList) Len() int      { return len(wl) }
 func (wl WorkList) Swap(i, j int) { wl[i], wl[j] = wl[j], wl[i] }
 
-type byFCFS struct{ WorkList }
+type byFCFS struct {
+	WorkList
+	now time.Time
+}
 
-//compare priority first, then FCFS (if priorities are the same)
+//compare priority (with aging) first, then FCFS (if priorities are the same)
 func (s byFCFS) Less(i, j int) (ret bool) {
-	p_i := s.WorkList[i].Info.Priority
-	p_j := s.WorkList[j].Info.Priority
+	p_i := s.WorkList[i].EffectivePriority(s.now)
+	p_j := s.WorkList[j].EffectivePriority(s.now)
 	switch {
 	case p_i > p_j:
 		return true
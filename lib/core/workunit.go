@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"path"
 	"reflect"
+	"sync"
 
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core/cwl"
@@ -35,6 +36,7 @@ const (
 	WORK_STAT_FAILED_PERMANENT = "failed-permanent" // app had exit code 42
 	WORK_STAT_DONE             = "done"             // client only: done
 	WORK_STAT_ERROR            = "fail"             // client only: workunit computation or IO error (variable was renamed to ERROR but not the string fail, to maintain backwards compability)
+	WORK_STAT_FAILED_OOM       = "failed-oom"       // client only: container was killed for exceeding its memory limit
 	WORK_STAT_PREPARED         = "prepared"         // client only: after argument parsing
 	WORK_STAT_COMPUTED         = "computed"         // client only: after computation is done, before upload
 	WORK_STAT_DISCARDED        = "discarded"        // client only: job / task suspended or server UUID changes
@@ -55,21 +57,25 @@ type Workunit struct {
 	TotalWork                  int                    `bson:"totalwork,omitempty" json:"totalwork,omitempty" mapstructure:"totalwork,omitempty"`
 	Partition                  *PartInfo              `bson:"part,omitempty" json:"part,omitempty" mapstructure:"part,omitempty"` // ***
 	CheckoutTime               time.Time              `bson:"checkout_time,omitempty" json:"checkout_time,omitempty" mapstructure:"checkout_time,omitempty"`
+	QueuedTime                 time.Time              `bson:"queued_time,omitempty" json:"queued_time,omitempty" mapstructure:"queued_time,omitempty"` // since when the workunit waits in the queue
 	ComputeTime                int                    `bson:"computetime,omitempty" json:"computetime,omitempty" mapstructure:"computetime,omitempty"`
 	ExitStatus                 int                    `bson:"exitstatus,omitempty" json:"exitstatus,omitempty" mapstructure:"exitstatus,omitempty"` // Linux Exit Status Code (0 is success)
 	Notes                      []string               `bson:"notes,omitempty" json:"notes,omitempty" mapstructure:"notes,omitempty"`
 	UserAttr                   map[string]interface{} `bson:"userattr,omitempty" json:"userattr,omitempty" mapstructure:"userattr,omitempty"`
 	ShockHost                  string                 `bson:"shockhost,omitempty" json:"shockhost,omitempty" mapstructure:"shockhost,omitempty"` // specifies default Shock host for outputs
 	CWLWorkunit                *CWLWorkunit           `bson:"cwl,omitempty" json:"cwl,omitempty" mapstructure:"cwl,omitempty"`
+	Checkpoint                 *Checkpoint            `bson:"checkpoint,omitempty" json:"checkpoint,omitempty" mapstructure:"checkpoint,omitempty"`
 	WorkPath                   string                 // this is the working directory. If empty, it will be computed.
 	WorkPerf                   *WorkPerf
 	Context                    *cwl.WorkflowContext `bson:"-" json:"-" mapstructure:"-"`
+	checkpointLock             sync.Mutex           // Checkpoint is changed by checkpoint requests of the client
 }
 
 // WorkunitState _
 type WorkunitState struct {
 	State  string `bson:"state,omitempty" json:"state,omitempty" mapstructure:"state,omitempty"`
 	Failed int    `bson:"failed,omitempty" json:"failed,omitempty" mapstructure:"failed,omitempty"`
+	OOM    int    `bson:"oom,omitempty" json:"oom,omitempty" mapstructure:"oom,omitempty"` // out-of-memory kills, not counted in Failed
 	Client string `bson:"client,omitempty" json:"client,omitempty" mapstructure:"client,omitempty"`
 }
 
@@ -407,6 +413,56 @@ func (work *Workunit) Evaluate(inputs interface{}, context *cwl.WorkflowContext)
 	return
 }
 
+// NeedsContainer is true if the workunit is executed by a container runtime, this includes a
+// DockerRequirement hint because the worker uses it like a requirement
+func (work *Workunit) NeedsContainer() bool {
+	if work.Cmd != nil && (work.Cmd.Dockerimage != "" || work.Cmd.DockerPull != "") {
+		return true
+	}
+	if work.CWLWorkunit == nil {
+		return false
+	}
+	var requirements []cwl.Requirement
+	switch work.CWLWorkunit.Tool.(type) {
+	case *cwl.CommandLineTool:
+		tool := work.CWLWorkunit.Tool.(*cwl.CommandLineTool)
+		requirements = append(append(requirements, tool.Hints...), tool.Requirements...)
+	case *cwl.ExpressionTool:
+		tool := work.CWLWorkunit.Tool.(*cwl.ExpressionTool)
+		requirements = append(append(requirements, tool.Hints...), tool.Requirements...)
+	}
+	for _, requirement := range requirements {
+		if _, ok := requirement.(*cwl.DockerRequirement); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceNeeds returns the minimum cores and memory (MiB) of the ResourceRequirement of a CWL tool,
+// 0 if not specified or an expression
+func (work *Workunit) ResourceNeeds() (cores int, ramMB int) {
+	if work.CWLWorkunit == nil {
+		return
+	}
+	var requirements []cwl.Requirement
+	switch work.CWLWorkunit.Tool.(type) {
+	case *cwl.CommandLineTool:
+		tool := work.CWLWorkunit.Tool.(*cwl.CommandLineTool)
+		requirements = append(append(requirements, tool.Hints...), tool.Requirements...)
+	case *cwl.ExpressionTool:
+		tool := work.CWLWorkunit.Tool.(*cwl.ExpressionTool)
+		requirements = append(append(requirements, tool.Hints...), tool.Requirements...)
+	}
+	for _, requirement := range requirements {
+		if r, ok := requirement.(*cwl.ResourceRequirement); ok {
+			cores, _ = cwl.ResourceNumber(r.CoresMin)
+			ramMB, _ = cwl.ResourceNumber(r.RamMin)
+		}
+	}
+	return
+}
+
 // GetID _
 func (work *Workunit) GetID() (id Workunit_Unique_Identifier) {
 	id = work.Workunit_Unique_Identifier
@@ -571,7 +627,7 @@ func (work *Workunit) FetchDataToken() (token string, err error) {
 		return
 	}
 
-	targeturl := fmt.Sprintf("%s/work/%s?datatoken&client=%s", conf.SERVER_URL, workIDB64, Self.ID)
+	targeturl := fmt.Sprintf("%s/work/%s?datatoken&client=%s", ServerURL(), workIDB64, Self.ID)
 	logger.Debug(1, "(FetchDataToken) targeturl: %s", targeturl)
 	var headers httpclient.Header
 	logger.Debug(3, "(FetchDataToken) len(conf.CLIENT_GROUP_TOKEN): %d ", len(conf.CLIENT_GROUP_TOKEN))
@@ -581,9 +637,9 @@ func (work *Workunit) FetchDataToken() (token string, err error) {
 			"Authorization": []string{"CG_TOKEN " + conf.CLIENT_GROUP_TOKEN},
 		}
 	}
-	res, err := httpclient.Get(targeturl, headers, nil)
+	res, err := DoServerRequest("GET", targeturl, headers, nil, 0)
 	if err != nil {
-		err = fmt.Errorf("(FetchDataToken) httpclient.Get returned: %s", err.Error())
+		err = fmt.Errorf("(FetchDataToken) DoServerRequest returned: %s", err.Error())
 		return
 	}
 	defer res.Body.Close()
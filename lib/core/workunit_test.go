@@ -0,0 +1,26 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+)
+
+func TestResourceNeeds(t *testing.T) {
+	cores, ramMB := (&Workunit{}).ResourceNeeds()
+	if cores != 0 || ramMB != 0 {
+		t.Errorf("got %d %d for an AWE workunit", cores, ramMB)
+	}
+	ram := cwl.Long(2048)
+	tool := &cwl.CommandLineTool{}
+	tool.Hints = []cwl.Requirement{&cwl.ResourceRequirement{CoresMin: 1, RamMin: 512}}
+	tool.Requirements = []cwl.Requirement{&cwl.ResourceRequirement{CoresMin: float64(4), RamMin: &ram}}
+	work := &Workunit{CWLWorkunit: &CWLWorkunit{Tool: tool}}
+	if cores, ramMB = work.ResourceNeeds(); cores != 4 || ramMB != 2048 {
+		t.Errorf("got %d %d, want 4 2048", cores, ramMB)
+	}
+	tool.Requirements = []cwl.Requirement{&cwl.ResourceRequirement{CoresMin: "$(inputs.threads)"}}
+	if cores, ramMB = work.ResourceNeeds(); cores != 0 || ramMB != 0 {
+		t.Errorf("got %d %d for an expression", cores, ramMB)
+	}
+}
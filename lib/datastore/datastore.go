@@ -0,0 +1,70 @@
+// Package datastore the data store of the workflow files: a Shock server, or the local store of a
+// standalone server (see package localstore). Both are addressed by a url, a node id names a file.
+package datastore
+
+import (
+	"github.com/MG-RAST/AWE/lib/localstore"
+	shock "github.com/MG-RAST/go-shock-client"
+)
+
+// Store a data store
+type Store interface {
+	// PostFile uploads the file at sourcePath, or contents if sourcePath is empty, name is the file name of the node
+	PostFile(sourcePath string, contents string, name string) (node string, err error)
+	// PostFileLazy like PostFile, a store may return an existing node with the same content
+	PostFileLazy(sourcePath string, contents string, name string) (node string, err error)
+	// FetchFile downloads the node at location, a download url of the store, to target
+	FetchFile(target string, location string) (size int64, err error)
+	// Delete removes a node
+	Delete(node string) error
+}
+
+// New returns the store at url, a Shock server or a local store. url may also be a location within
+// the store. The token authenticates the requests to Shock.
+func New(url string, token string) Store {
+	if localstore.IsURL(url) {
+		return &localStore{host: url}
+	}
+	return &shockStore{client: &shock.ShockClient{Host: url, Token: token, Debug: false}}
+}
+
+type localStore struct {
+	host string
+}
+
+func (s *localStore) PostFile(sourcePath string, contents string, name string) (node string, err error) {
+	return localstore.PostFile(s.host, sourcePath, contents)
+}
+
+func (s *localStore) PostFileLazy(sourcePath string, contents string, name string) (node string, err error) {
+	return localstore.PostFile(s.host, sourcePath, contents)
+}
+
+func (s *localStore) FetchFile(target string, location string) (size int64, err error) {
+	return localstore.FetchFile(target, location)
+}
+
+func (s *localStore) Delete(node string) error {
+	return localstore.Delete(s.host, node)
+}
+
+type shockStore struct {
+	client *shock.ShockClient
+}
+
+func (s *shockStore) PostFile(sourcePath string, contents string, name string) (node string, err error) {
+	return s.client.PostFile(sourcePath, contents, name)
+}
+
+func (s *shockStore) PostFileLazy(sourcePath string, contents string, name string) (node string, err error) {
+	return s.client.PostFileLazy(sourcePath, name, contents, false)
+}
+
+func (s *shockStore) FetchFile(target string, location string) (size int64, err error) {
+	size, _, err = shock.FetchFile(target, location, s.client.Token, "", false)
+	return
+}
+
+func (s *shockStore) Delete(node string) error {
+	return shock.ShockDelete(s.client.Host, node, s.client.Token)
+}
@@ -1,4 +1,4 @@
-// Package db to connect to mongodb
+// Package db to connect to the database, mongodb (default), postgresql or an embedded file
 package db
 
 import (
@@ -27,7 +27,30 @@ type connection struct {
 	DB       *mgo.Database
 }
 
+// Initialize connects to the database of the configured backend
 func Initialize() (err error) {
+	switch conf.DB_BACKEND {
+	case BackendPostgres:
+		var pg *pgStore
+		pg, err = openPostgres(conf.POSTGRES_URL)
+		if err != nil {
+			return
+		}
+		store = pg
+		return
+	case BackendEmbedded:
+		var es *embeddedStore
+		es, err = openEmbedded(conf.EMBEDDED_PATH, int64(conf.EMBEDDED_MAX_MB)<<20)
+		if err != nil {
+			return
+		}
+		store = es
+		return
+	case BackendMongoDB:
+	default:
+		return fmt.Errorf("unknown database backend %s", conf.DB_BACKEND)
+	}
+
 	c := connection{}
 
 	// test connection
@@ -55,9 +78,11 @@ func Initialize() (err error) {
 		c.DB.Login(conf.MONGODB_USER, conf.MONGODB_PASSWD)
 	}
 	Connection = c
+	store = mongoStore{}
 	return
 }
 
+// Drop removes all documents
 func Drop() error {
-	return Connection.DB.DropDatabase()
+	return store.Drop()
 }
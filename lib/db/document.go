@@ -0,0 +1,904 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The subset of the MongoDB query language used by AWE, for backends that evaluate documents in the
+// server: selectors with $or/$and/$nor, $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $regex,
+// $elemMatch and $size, inclusion/exclusion projections with $elemMatch, sorting, and the update
+// operators $set, $unset, $inc and $push including the positional "$".
+
+// toDocument converts a struct or map into a bson.M with the bson field names
+func toDocument(v interface{}) (doc bson.M, err error) {
+	doc = bson.M{}
+	if v == nil {
+		return
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return
+	}
+	err = bson.Unmarshal(raw, &doc)
+	return
+}
+
+// fromDocument decodes a document into result, like mgo's Query.One
+func fromDocument(doc bson.M, result interface{}) (err error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return
+	}
+	return bson.Unmarshal(raw, result)
+}
+
+// fromDocuments decodes documents into the slice result points to, like mgo's Query.All
+func fromDocuments(docs []bson.M, result interface{}) (err error) {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("result argument must be a slice address")
+	}
+	slicev := resultv.Elem()
+	slicev = slicev.Slice(0, 0)
+	elemt := slicev.Type().Elem()
+	for _, doc := range docs {
+		elemp := reflect.New(elemt)
+		err = fromDocument(doc, elemp.Interface())
+		if err != nil {
+			return
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+	}
+	resultv.Elem().Set(slicev)
+	return
+}
+
+// fromValue decodes a value that is not a document, e.g. the array of Distinct
+func fromValue(v interface{}, result interface{}) (err error) {
+	raw, err := bson.Marshal(bson.M{"v": v})
+	if err != nil {
+		return
+	}
+	wrapper := struct {
+		V bson.Raw `bson:"v"`
+	}{}
+	err = bson.Unmarshal(raw, &wrapper)
+	if err != nil {
+		return
+	}
+	return wrapper.V.Unmarshal(result)
+}
+
+// normalize converts a value into the types bson.Unmarshal produces
+func normalize(v interface{}) (interface{}, error) {
+	doc, err := toDocument(bson.M{"v": v})
+	if err != nil {
+		return nil, err
+	}
+	return doc["v"], nil
+}
+
+func isOperatorDoc(v interface{}) bool {
+	m, ok := v.(bson.M)
+	if !ok || len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the values at a dotted path, descending into arrays of documents
+func lookup(v interface{}, path []string) (values []interface{}) {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	switch t := v.(type) {
+	case bson.M:
+		child, ok := t[path[0]]
+		if !ok {
+			return nil
+		}
+		return lookup(child, path[1:])
+	case []interface{}:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i >= 0 && i < len(t) {
+				return lookup(t[i], path[1:])
+			}
+			return nil
+		}
+		for _, e := range t {
+			if _, ok := e.(bson.M); ok {
+				values = append(values, lookup(e, path)...)
+			}
+		}
+	}
+	return
+}
+
+// candidates values compared with a condition, an array matches as a whole or by any element
+func candidates(values []interface{}) (result []interface{}) {
+	for _, v := range values {
+		result = append(result, v)
+		if a, ok := v.([]interface{}); ok {
+			result = append(result, a...)
+		}
+	}
+	return
+}
+
+// match reports whether a normalized document matches a normalized selector
+func match(doc bson.M, q bson.M) (ok bool, err error) {
+	for k, cond := range q {
+		switch k {
+		case "$or", "$and", "$nor":
+			subs, isArray := cond.([]interface{})
+			if !isArray {
+				return false, fmt.Errorf("%s needs an array", k)
+			}
+			any := false
+			all := true
+			for _, sub := range subs {
+				subq, isDoc := sub.(bson.M)
+				if !isDoc {
+					return false, fmt.Errorf("%s needs an array of documents", k)
+				}
+				var m bool
+				m, err = match(doc, subq)
+				if err != nil {
+					return
+				}
+				any = any || m
+				all = all && m
+			}
+			if (k == "$or" && !any) || (k == "$and" && !all) || (k == "$nor" && any) {
+				return false, nil
+			}
+		default:
+			if strings.HasPrefix(k, "$") {
+				return false, fmt.Errorf("unsupported query operator %s", k)
+			}
+			ok, err = matchField(lookup(doc, strings.Split(k, ".")), cond)
+			if err != nil || !ok {
+				return
+			}
+		}
+	}
+	return true, nil
+}
+
+func matchField(values []interface{}, cond interface{}) (ok bool, err error) {
+	if !isOperatorDoc(cond) {
+		return matchEqual(values, cond), nil
+	}
+	ops := cond.(bson.M)
+	for op, arg := range ops {
+		switch op {
+		case "$eq":
+			ok = matchEqual(values, arg)
+		case "$ne":
+			ok = !matchEqual(values, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = false
+			for _, v := range candidates(values) {
+				c, comparable := compare(v, arg)
+				if !comparable {
+					continue
+				}
+				if (op == "$gt" && c > 0) || (op == "$gte" && c >= 0) || (op == "$lt" && c < 0) || (op == "$lte" && c <= 0) {
+					ok = true
+					break
+				}
+			}
+		case "$in", "$nin":
+			list, isArray := arg.([]interface{})
+			if !isArray {
+				return false, fmt.Errorf("%s needs an array", op)
+			}
+			ok = false
+			for _, item := range list {
+				if matchEqual(values, item) {
+					ok = true
+					break
+				}
+			}
+			if op == "$nin" {
+				ok = !ok
+			}
+		case "$exists":
+			ok = (len(values) > 0) == truthy(arg)
+		case "$regex":
+			var re *regexp.Regexp
+			re, err = compileRegex(arg, ops["$options"])
+			if err != nil {
+				return
+			}
+			ok = false
+			for _, v := range candidates(values) {
+				if s, isString := v.(string); isString && re.MatchString(s) {
+					ok = true
+					break
+				}
+			}
+		case "$options":
+			continue
+		case "$elemMatch":
+			ok = false
+			for _, v := range values {
+				var m bool
+				m, err = elemMatch(v, arg)
+				if err != nil {
+					return
+				}
+				if m {
+					ok = true
+					break
+				}
+			}
+		case "$size":
+			ok = false
+			for _, v := range values {
+				if a, isArray := v.([]interface{}); isArray && numberEqual(len(a), arg) {
+					ok = true
+					break
+				}
+			}
+		default:
+			return false, fmt.Errorf("unsupported query operator %s", op)
+		}
+		if !ok {
+			return
+		}
+	}
+	return true, nil
+}
+
+// elemMatch reports whether an element of an array matches cond
+func elemMatch(v interface{}, cond interface{}) (ok bool, err error) {
+	i, err := elemMatchIndex(v, cond)
+	return i >= 0, err
+}
+
+// elemMatchIndex index of the first element of an array that matches cond, -1 if none
+func elemMatchIndex(v interface{}, cond interface{}) (index int, err error) {
+	index = -1
+	a, ok := v.([]interface{})
+	if !ok {
+		return
+	}
+	q, ok := cond.(bson.M)
+	if !ok {
+		return -1, fmt.Errorf("$elemMatch needs a document")
+	}
+	for i, e := range a {
+		var m bool
+		if isOperatorDoc(q) {
+			m, err = matchField([]interface{}{e}, q)
+		} else if doc, isDoc := e.(bson.M); isDoc {
+			m, err = match(doc, q)
+		}
+		if err != nil {
+			return
+		}
+		if m {
+			return i, nil
+		}
+	}
+	return
+}
+
+func matchEqual(values []interface{}, v interface{}) bool {
+	if v == nil && len(values) == 0 {
+		// null matches missing fields
+		return true
+	}
+	for _, c := range candidates(values) {
+		if equal(c, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case int, int64, float64:
+		f, _ := toFloat(t)
+		return f != 0
+	}
+	return true
+}
+
+func compileRegex(pattern interface{}, options interface{}) (re *regexp.Regexp, err error) {
+	var expr, opts string
+	switch t := pattern.(type) {
+	case string:
+		expr = t
+	case bson.RegEx:
+		expr = t.Pattern
+		opts = t.Options
+	default:
+		return nil, fmt.Errorf("$regex needs a string")
+	}
+	if s, ok := options.(string); ok {
+		opts += s
+	}
+	flags := ""
+	for _, o := range opts {
+		if o == 'i' || o == 'm' || o == 's' {
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+func toFloat(v interface{}) (f float64, ok bool) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+func numberEqual(a interface{}, b interface{}) bool {
+	fa, okA := toFloat(a)
+	fb, okB := toFloat(b)
+	return okA && okB && fa == fb
+}
+
+// equal compares normalized values
+func equal(a interface{}, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	switch ta := a.(type) {
+	case time.Time:
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	case bson.M:
+		tb, ok := b.(bson.M)
+		if !ok || len(ta) != len(tb) {
+			return false
+		}
+		for k, v := range ta {
+			w, ok := tb[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		tb, ok := b.([]interface{})
+		if !ok || len(ta) != len(tb) {
+			return false
+		}
+		for i := range ta {
+			if !equal(ta[i], tb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two values of the same type, ok is false if they cannot be compared
+func compare(a interface{}, b interface{}) (c int, ok bool) {
+	if fa, isNumber := toFloat(a); isNumber {
+		fb, isNumber := toFloat(b)
+		if !isNumber {
+			return 0, false
+		}
+		return compareFloat(fa, fb), true
+	}
+	switch ta := a.(type) {
+	case string:
+		tb, isString := b.(string)
+		if !isString {
+			return 0, false
+		}
+		return strings.Compare(ta, tb), true
+	case time.Time:
+		tb, isTime := b.(time.Time)
+		if !isTime {
+			return 0, false
+		}
+		if ta.Before(tb) {
+			return -1, true
+		}
+		if ta.After(tb) {
+			return 1, true
+		}
+		return 0, true
+	case bson.ObjectId:
+		tb, isID := b.(bson.ObjectId)
+		if !isID {
+			return 0, false
+		}
+		return strings.Compare(string(ta), string(tb)), true
+	case bool:
+		tb, isBool := b.(bool)
+		if !isBool {
+			return 0, false
+		}
+		if ta == tb {
+			return 0, true
+		}
+		if !ta {
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func compareFloat(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// typeRank the order of values of different types when sorting, as in MongoDB
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case int, int32, int64, float64:
+		return 2
+	case string:
+		return 3
+	case bson.M:
+		return 4
+	case []interface{}:
+		return 5
+	case bson.ObjectId:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	}
+	return 10
+}
+
+func sortValue(doc bson.M, path string) interface{} {
+	values := lookup(doc, strings.Split(path, "."))
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// lessDocuments compares two documents by sort fields ("field" ascending, "-field" descending)
+func lessDocuments(a bson.M, b bson.M, fields []string) bool {
+	for _, field := range fields {
+		desc := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(strings.TrimPrefix(field, "-"), "+")
+		va := sortValue(a, field)
+		vb := sortValue(b, field)
+		c, ok := compare(va, vb)
+		if !ok {
+			c = typeRank(va) - typeRank(vb)
+		}
+		if c == 0 {
+			continue
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// project applies a projection to a document
+func project(doc bson.M, projection bson.M) (result bson.M, err error) {
+	if len(projection) == 0 {
+		return doc, nil
+	}
+	inclusion := false
+	for k, v := range projection {
+		if k == "_id" {
+			continue
+		}
+		if _, isDoc := v.(bson.M); isDoc || truthy(v) {
+			inclusion = true
+		}
+	}
+
+	if !inclusion {
+		result = copyDocument(doc)
+		for k, v := range projection {
+			if !truthy(v) {
+				unsetPath(result, strings.Split(k, "."))
+			}
+		}
+		return
+	}
+
+	result = bson.M{}
+	if id, ok := doc["_id"]; ok {
+		if v, listed := projection["_id"]; !listed || truthy(v) {
+			result["_id"] = id
+		}
+	}
+	elemMatched := map[string]bool{}
+	for k, v := range projection {
+		if k == "_id" {
+			continue
+		}
+		if cond, isDoc := v.(bson.M); isDoc {
+			em, ok := cond["$elemMatch"]
+			if !ok {
+				return nil, fmt.Errorf("unsupported projection of %s", k)
+			}
+			elemMatched[k] = true
+			var i int
+			i, err = elemMatchIndex(doc[k], em)
+			if err != nil {
+				return
+			}
+			if i >= 0 {
+				result[k] = []interface{}{doc[k].([]interface{})[i]}
+			}
+		}
+	}
+	for k, v := range projection {
+		if k == "_id" || !truthy(v) {
+			continue
+		}
+		if _, isDoc := v.(bson.M); isDoc {
+			continue
+		}
+		path := strings.Split(k, ".")
+		if elemMatched[path[0]] {
+			// the matched element is returned as a whole
+			continue
+		}
+		includePath(doc, result, path)
+	}
+	return
+}
+
+// includePath copies the value at path from src to dst, through arrays of documents
+func includePath(src bson.M, dst bson.M, path []string) {
+	v, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = v
+		return
+	}
+	switch t := v.(type) {
+	case bson.M:
+		sub, isDoc := dst[path[0]].(bson.M)
+		if !isDoc {
+			sub = bson.M{}
+			dst[path[0]] = sub
+		}
+		includePath(t, sub, path[1:])
+	case []interface{}:
+		sub, isArray := dst[path[0]].([]interface{})
+		if !isArray {
+			sub = []interface{}{}
+		}
+		result := []interface{}{}
+		n := 0
+		for _, e := range t {
+			ed, isDoc := e.(bson.M)
+			if !isDoc {
+				continue
+			}
+			var target bson.M
+			if n < len(sub) {
+				target = sub[n].(bson.M)
+			} else {
+				target = bson.M{}
+			}
+			includePath(ed, target, path[1:])
+			result = append(result, target)
+			n++
+		}
+		dst[path[0]] = result
+	}
+}
+
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case bson.M:
+		return copyDocument(t)
+	case []interface{}:
+		c := make([]interface{}, len(t))
+		for i := range t {
+			c[i] = copyValue(t[i])
+		}
+		return c
+	}
+	return v
+}
+
+func copyDocument(doc bson.M) bson.M {
+	c := make(bson.M, len(doc))
+	for k, v := range doc {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+// setPath sets the value at a dotted path, creating missing documents
+func setPath(doc bson.M, path []string, value interface{}) (err error) {
+	var container interface{} = doc
+	for i, seg := range path {
+		last := i == len(path)-1
+		switch t := container.(type) {
+		case bson.M:
+			if last {
+				t[seg] = value
+				return
+			}
+			next, ok := t[seg]
+			if !ok || next == nil {
+				next = bson.M{}
+				t[seg] = next
+			}
+			container = next
+		case []interface{}:
+			index, xerr := strconv.Atoi(seg)
+			if xerr != nil || index < 0 || index >= len(t) {
+				return fmt.Errorf("cannot set %s, %s is not an index of the array", strings.Join(path, "."), seg)
+			}
+			if last {
+				t[index] = value
+				return
+			}
+			container = t[index]
+		default:
+			return fmt.Errorf("cannot set %s, %s is not a document", strings.Join(path, "."), strings.Join(path[:i], "."))
+		}
+	}
+	return
+}
+
+func getPath(doc bson.M, path []string) (value interface{}, ok bool) {
+	var container interface{} = doc
+	for _, seg := range path {
+		switch t := container.(type) {
+		case bson.M:
+			container, ok = t[seg]
+			if !ok {
+				return nil, false
+			}
+		case []interface{}:
+			index, err := strconv.Atoi(seg)
+			if err != nil || index < 0 || index >= len(t) {
+				return nil, false
+			}
+			container = t[index]
+		default:
+			return nil, false
+		}
+	}
+	return container, true
+}
+
+func unsetPath(doc bson.M, path []string) {
+	if len(path) == 1 {
+		delete(doc, path[0])
+		return
+	}
+	parent, ok := getPath(doc, path[:len(path)-1])
+	if !ok {
+		return
+	}
+	if m, isDoc := parent.(bson.M); isDoc {
+		delete(m, path[len(path)-1])
+	}
+}
+
+// positionalIndex the index of the array element the selector matched, for "array.$.field" updates
+func positionalIndex(doc bson.M, selector bson.M, array string) (index int, err error) {
+	values, _ := getPath(doc, strings.Split(array, "."))
+	elements, ok := values.([]interface{})
+	if !ok {
+		return -1, fmt.Errorf("the positional operator did not find the match needed from the query")
+	}
+	if cond, ok := selector[array].(bson.M); ok {
+		if em, ok := cond["$elemMatch"]; ok {
+			index, err = elemMatchIndex(elements, em)
+			if err == nil && index < 0 {
+				err = fmt.Errorf("the positional operator did not find the match needed from the query")
+			}
+			return
+		}
+	}
+	elementQuery := bson.M{}
+	for k, v := range selector {
+		if strings.HasPrefix(k, array+".") {
+			elementQuery[strings.TrimPrefix(k, array+".")] = v
+		}
+	}
+	if len(elementQuery) == 0 {
+		return -1, fmt.Errorf("the positional operator did not find the match needed from the query")
+	}
+	for i, e := range elements {
+		ed, isDoc := e.(bson.M)
+		if !isDoc {
+			continue
+		}
+		var m bool
+		m, err = match(ed, elementQuery)
+		if err != nil {
+			return
+		}
+		if m {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("the positional operator did not find the match needed from the query")
+}
+
+// resolvePath replaces the positional "$" of a dotted path
+func resolvePath(doc bson.M, selector bson.M, key string) (path []string, err error) {
+	path = strings.Split(key, ".")
+	for i, seg := range path {
+		if seg != "$" {
+			continue
+		}
+		var index int
+		index, err = positionalIndex(doc, selector, strings.Join(path[:i], "."))
+		if err != nil {
+			return
+		}
+		path[i] = strconv.Itoa(index)
+	}
+	return
+}
+
+// applyUpdate applies a normalized document of update operators to a document
+func applyUpdate(doc bson.M, selector bson.M, update bson.M) (err error) {
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("%s needs a document", op)
+		}
+		for key, value := range fields {
+			var path []string
+			path, err = resolvePath(doc, selector, key)
+			if err != nil {
+				return
+			}
+			switch op {
+			case "$set":
+				err = setPath(doc, path, value)
+			case "$unset":
+				unsetPath(doc, path)
+			case "$inc":
+				err = incPath(doc, path, value)
+			case "$push":
+				err = pushPath(doc, path, value)
+			default:
+				err = fmt.Errorf("unsupported update operator %s", op)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func incPath(doc bson.M, path []string, value interface{}) (err error) {
+	if _, ok := toFloat(value); !ok {
+		return fmt.Errorf("cannot increment with a non-numeric argument")
+	}
+	current, ok := getPath(doc, path)
+	if !ok || current == nil {
+		return setPath(doc, path, value)
+	}
+	if _, isNumber := toFloat(current); !isNumber {
+		return fmt.Errorf("cannot apply $inc to the non-numeric field %s", strings.Join(path, "."))
+	}
+	var sum interface{}
+	switch c := current.(type) {
+	case float64:
+		f, _ := toFloat(value)
+		sum = c + f
+	default:
+		if f, isFloat := value.(float64); isFloat {
+			cf, _ := toFloat(c)
+			sum = cf + f
+			break
+		}
+		ci, _ := toFloat(c)
+		vi, _ := toFloat(value)
+		s := int64(ci) + int64(vi)
+		if s > math.MaxInt32 || s < math.MinInt32 {
+			sum = s
+		} else {
+			sum = int(s)
+		}
+	}
+	return setPath(doc, path, sum)
+}
+
+func pushPath(doc bson.M, path []string, value interface{}) (err error) {
+	values := []interface{}{value}
+	if each, ok := value.(bson.M); ok {
+		if list, ok := each["$each"].([]interface{}); ok {
+			values = list
+		}
+	}
+	current, ok := getPath(doc, path)
+	if !ok || current == nil {
+		return setPath(doc, path, values)
+	}
+	array, isArray := current.([]interface{})
+	if !isArray {
+		return fmt.Errorf("the field %s is not an array", strings.Join(path, "."))
+	}
+	return setPath(doc, path, append(array, values...))
+}
+
+// upsertDocument the document an upsert inserts: the equality fields of the selector with the
+// update applied
+func upsertDocument(selector bson.M, update interface{}) (doc bson.M, err error) {
+	doc = bson.M{}
+	for k, v := range selector {
+		if strings.HasPrefix(k, "$") || isOperatorDoc(v) {
+			continue
+		}
+		err = setPath(doc, strings.Split(k, "."), copyValue(v))
+		if err != nil {
+			return
+		}
+	}
+	return updateDocument(doc, selector, update)
+}
+
+// updateDocument returns the document with the update (operators or a replacement) applied
+func updateDocument(doc bson.M, selector bson.M, update interface{}) (result bson.M, err error) {
+	u, err := toDocument(update)
+	if err != nil {
+		return
+	}
+	if isOperatorDoc(u) {
+		result = copyDocument(doc)
+		err = applyUpdate(result, selector, u)
+		return
+	}
+	result = u
+	if id, ok := doc["_id"]; ok {
+		result["_id"] = id
+	}
+	return
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMatch(t *testing.T) {
+	doc, _ := toDocument(bson.M{
+		"state": "queued",
+		"acl":   bson.M{"owner": "u1", "read": []string{"u2", "public"}},
+		"info":  bson.M{"priority": 3, "submittime": time.Unix(1000, 0), "clientgroups": "a,b"},
+		"tasks": []bson.M{{"taskid": "0", "state": "completed"}, {"taskid": "1", "state": "queued"}},
+	})
+	cases := []struct {
+		q    bson.M
+		want bool
+	}{
+		{bson.M{"state": "queued"}, true},
+		{bson.M{"acl.read": "public"}, true},
+		{bson.M{"acl.read": "u3"}, false},
+		{bson.M{"tasks.taskid": "1"}, true},
+		{bson.M{"expiration": nil}, true},
+		{bson.M{"acl": bson.M{"$exists": "false"}}, true}, // a string is true, as in MongoDB
+		{bson.M{"expiration": bson.M{"$exists": true}}, false},
+		{bson.M{"info.priority": bson.M{"$gt": 2, "$lt": 3.5}}, true},
+		{bson.M{"info.submittime": bson.M{"$lt": time.Unix(999, 0)}}, false},
+		{bson.M{"state": bson.M{"$in": []string{"completed", "queued"}}}, true},
+		{bson.M{"info.clientgroups": bson.M{"$regex": "(^|,)b($|,)"}}, true},
+		{bson.M{"$and": []bson.M{{"state": "queued"}, {"info.priority": 4}}}, false},
+		{bson.M{"$or": []bson.M{{"state": "completed"}, {"info.priority": 3}}}, true},
+		{bson.M{"tasks": bson.M{"$elemMatch": bson.M{"taskid": "0", "state": "queued"}}}, false},
+	}
+	for _, c := range cases {
+		q, _ := toDocument(c.q)
+		got, err := match(doc, q)
+		if err != nil {
+			t.Errorf("%v: %s", c.q, err.Error())
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%v: expected %t", c.q, c.want)
+		}
+	}
+	if _, err := match(doc, bson.M{"$where": "true"}); err == nil {
+		t.Errorf("expected an error for an unsupported operator")
+	}
+}
+
+func TestUpdateDocument(t *testing.T) {
+	doc, _ := toDocument(bson.M{"id": "j", "tasks": []bson.M{{"taskid": "0"}, {"taskid": "1", "failed": 1}}})
+	selector, _ := toDocument(bson.M{"id": "j", "tasks.taskid": "1"})
+	result, err := updateDocument(doc, selector, bson.M{
+		"$inc":   bson.M{"tasks.$.failed": 1, "remain": 2},
+		"$set":   bson.M{"info.name": "n"},
+		"$push":  bson.M{"notes": "x"},
+		"$unset": bson.M{"id": ""},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if v, _ := getPath(result, []string{"tasks", "1", "failed"}); v != 2 {
+		t.Errorf("$inc positional: got %v", v)
+	}
+	if result["remain"] != 2 || result["info"].(bson.M)["name"] != "n" || len(result["notes"].([]interface{})) != 1 {
+		t.Errorf("got %v", result)
+	}
+	if _, ok := result["id"]; ok {
+		t.Errorf("$unset: id still set")
+	}
+	if _, ok := doc["remain"]; ok {
+		t.Errorf("the original document was modified")
+	}
+	if _, err = updateDocument(doc, bson.M{"id": "j"}, bson.M{"$set": bson.M{"tasks.$.failed": 0}}); err == nil {
+		t.Errorf("expected an error for a positional update without array selector")
+	}
+}
+
+func TestExtendedJSON(t *testing.T) {
+	doc, _ := toDocument(bson.M{
+		"_id":    bson.NewObjectId(),
+		"int":    7,
+		"long":   int64(math.MaxInt64),
+		"float":  3.0,
+		"frac":   0.25,
+		"nan":    math.NaN(),
+		"time":   time.Now().Truncate(time.Millisecond),
+		"bytes":  []byte("abc"),
+		"nested": bson.M{"a": []interface{}{1, "b", nil, true}},
+	})
+	data, err := encodeJSON(doc)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	back, err := decodeJSON(data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for k, v := range doc {
+		if k == "nan" {
+			if f, ok := back[k].(float64); !ok || !math.IsNaN(f) {
+				t.Errorf("%s: got %#v", k, back[k])
+			}
+			continue
+		}
+		if !equal(v, back[k]) {
+			t.Errorf("%s: expected %#v, got %#v", k, v, back[k])
+		}
+	}
+	if _, ok := back["float"].(float64); !ok {
+		t.Errorf("float: type changed to %T", back["float"])
+	}
+	if _, ok := back["long"].(int64); !ok {
+		t.Errorf("long: type changed to %T", back["long"])
+	}
+}
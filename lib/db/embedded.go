@@ -0,0 +1,486 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The embedded backend keeps all documents in memory and persists them in one local bbolt file, a
+// bucket per collection with the documents JSON encoded by _id. A write commits the documents it
+// changed in one transaction before it is applied in memory. On open the file is locked (an exclusive
+// flock, a second server on the same file fails to start) and all documents are read. It is meant for
+// the standalone mode. As all documents are held in memory, their size is limited by [Embedded] max_mb:
+// a write that grows the documents beyond it fails, removals always succeed. Job retention keeps the
+// database small.
+
+// embeddedLockTimeout how long open waits for the lock of another process
+const embeddedLockTimeout = time.Second
+
+type embeddedStore struct {
+	mutex       sync.Mutex
+	path        string
+	bolt        *bolt.DB
+	collections map[string]*embeddedData
+	size        int64 // size of the encoded documents
+	maxSize     int64 // 0: no limit
+}
+
+// embeddedData the documents of a collection by _id
+type embeddedData struct {
+	docs   map[string]bson.M
+	sizes  map[string]int
+	unique [][]string
+}
+
+func openEmbedded(path string, maxSize int64) (es *embeddedStore, err error) {
+	if path == "" {
+		err = fmt.Errorf("(openEmbedded) [Embedded] path is not set")
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		err = fmt.Errorf("(openEmbedded) os.MkdirAll returned: %s", err.Error())
+		return
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: embeddedLockTimeout})
+	if err == bolt.ErrTimeout {
+		err = fmt.Errorf("(openEmbedded) %s is locked, it is used by another process", path)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("(openEmbedded) bolt.Open returned: %s", err.Error())
+		return
+	}
+	es = &embeddedStore{path: path, bolt: db, collections: make(map[string]*embeddedData), maxSize: maxSize}
+	err = es.load()
+	if err != nil {
+		db.Close()
+		es = nil
+		err = fmt.Errorf("(openEmbedded) %s", err.Error())
+	}
+	return
+}
+
+// close releases the file and its lock
+func (es *embeddedStore) close() error {
+	return es.bolt.Close()
+}
+
+func (es *embeddedStore) data(collection string) *embeddedData {
+	d, ok := es.collections[collection]
+	if !ok {
+		d = &embeddedData{docs: make(map[string]bson.M), sizes: make(map[string]int)}
+		es.collections[collection] = d
+	}
+	return d
+}
+
+// load reads all documents
+func (es *embeddedStore) load() error {
+	return es.bolt.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			d := es.data(string(name))
+			return b.ForEach(func(id []byte, value []byte) error {
+				doc, err := decodeJSON(value)
+				if err != nil {
+					return fmt.Errorf("(load) %s %s: %s", name, id, err.Error())
+				}
+				d.docs[string(id)] = doc
+				d.sizes[string(id)] = len(value)
+				es.size += int64(len(value))
+				return nil
+			})
+		})
+	})
+}
+
+// embeddedChange the new state of a document, doc is nil for a removal
+type embeddedChange struct {
+	id  string
+	doc bson.M
+}
+
+// commit writes the changes of a collection and applies them, es.mutex is held
+func (es *embeddedStore) commit(collection string, changes []embeddedChange) (err error) {
+	if len(changes) == 0 {
+		return
+	}
+	d := es.data(collection)
+	values := make([][]byte, len(changes))
+	sizes := make(map[string]int, len(changes))
+	size := es.size
+	for i, change := range changes {
+		if change.doc != nil {
+			values[i], err = encodeJSON(change.doc)
+			if err != nil {
+				return
+			}
+		}
+		previous, changed := sizes[change.id]
+		if !changed {
+			previous = d.sizes[change.id]
+		}
+		sizes[change.id] = len(values[i])
+		size += int64(sizes[change.id] - previous)
+	}
+	if es.maxSize > 0 && size > es.maxSize && size > es.size {
+		return fmt.Errorf("(commit) the embedded database is full: %d MB of documents, [Embedded] max_mb is %d", size>>20, es.maxSize>>20)
+	}
+	err = es.bolt.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(collection))
+		if err != nil {
+			return err
+		}
+		for i, change := range changes {
+			if change.doc == nil {
+				err = b.Delete([]byte(change.id))
+			} else {
+				err = b.Put([]byte(change.id), values[i])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("(commit) writing %s returned: %s", es.path, err.Error())
+	}
+	for _, change := range changes {
+		if change.doc == nil {
+			delete(d.docs, change.id)
+			delete(d.sizes, change.id)
+		} else {
+			d.docs[change.id] = change.doc
+			d.sizes[change.id] = sizes[change.id]
+		}
+	}
+	es.size = size
+	return
+}
+
+func (es *embeddedStore) C(name string) Collection {
+	return &embeddedCollection{es: es, name: name}
+}
+
+func (es *embeddedStore) Drop() (err error) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	err = es.bolt.Update(func(tx *bolt.Tx) error {
+		names := [][]byte{}
+		tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		})
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("(Drop) %s", err.Error())
+	}
+	es.collections = make(map[string]*embeddedData)
+	es.size = 0
+	return
+}
+
+type embeddedCollection struct {
+	es   *embeddedStore
+	name string
+}
+
+// matching returns the ids of the documents matching the selector in id order, es.mutex is held
+func (c *embeddedCollection) matching(selector bson.M) (ids []string, err error) {
+	normalized, err := toDocument(selector)
+	if err != nil {
+		err = fmt.Errorf("(matching) invalid selector: %s", err.Error())
+		return
+	}
+	d := c.es.data(c.name)
+	if id, ok := normalized["_id"]; ok && !isOperatorDoc(id) {
+		// a lookup
+		doc, found := d.docs[idString(id)]
+		if !found {
+			return
+		}
+		if ok, err = match(doc, normalized); ok {
+			ids = []string{idString(id)}
+		}
+		return
+	}
+	for id, doc := range d.docs {
+		var ok bool
+		ok, err = match(doc, normalized)
+		if err != nil {
+			return
+		}
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return
+}
+
+// idTaken true if a document with the _id exists after the changes
+func (d *embeddedData) idTaken(id string, changes []embeddedChange) bool {
+	_, taken := d.docs[id]
+	for _, change := range changes {
+		if change.id == id {
+			taken = change.doc != nil
+		}
+	}
+	return taken
+}
+
+// uniqueViolation true if doc has the values of a unique index of another document after the changes
+func (d *embeddedData) uniqueViolation(id string, doc bson.M, changes []embeddedChange) bool {
+	if len(d.unique) == 0 {
+		return false
+	}
+	changed := make(map[string]bson.M, len(changes))
+	for _, change := range changes {
+		changed[change.id] = change.doc
+	}
+	for _, key := range d.unique {
+		for otherID, other := range d.docs {
+			if _, ok := changed[otherID]; ok || otherID == id {
+				continue
+			}
+			if sameKey(doc, other, key) {
+				return true
+			}
+		}
+		for otherID, other := range changed {
+			if other != nil && otherID != id && sameKey(doc, other, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameKey true if both documents have equal values for the fields, a missing field is null
+func sameKey(a bson.M, b bson.M, key []string) bool {
+	for _, field := range key {
+		path := strings.Split(strings.TrimPrefix(field, "-"), ".")
+		va, _ := getPath(a, path)
+		vb, _ := getPath(b, path)
+		if !equal(va, vb) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *embeddedCollection) Find(q bson.M) Query {
+	return &documentQuery{load: c.find, q: q}
+}
+
+// find returns copies of the documents matching q, for queries
+func (c *embeddedCollection) find(q bson.M) (docs []bson.M, err error) {
+	c.es.mutex.Lock()
+	defer c.es.mutex.Unlock()
+	ids, err := c.matching(q)
+	if err != nil {
+		return
+	}
+	d := c.es.data(c.name)
+	docs = make([]bson.M, len(ids))
+	for i, id := range ids {
+		docs[i] = copyDocument(d.docs[id])
+	}
+	return
+}
+
+// insertChange validates a new document, es.mutex is held
+func (c *embeddedCollection) insertChange(doc bson.M, changes []embeddedChange) (change embeddedChange, err error) {
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+	id := idString(doc["_id"])
+	d := c.es.data(c.name)
+	if d.idTaken(id, changes) || d.uniqueViolation(id, doc, changes) {
+		err = ErrDuplicate
+		return
+	}
+	change = embeddedChange{id: id, doc: doc}
+	return
+}
+
+// updateChanges the changes that replace the document id by doc, es.mutex is held
+func (c *embeddedCollection) updateChanges(id string, doc bson.M, changes []embeddedChange) ([]embeddedChange, error) {
+	d := c.es.data(c.name)
+	newID := idString(doc["_id"])
+	if newID != id {
+		if d.idTaken(newID, changes) {
+			return nil, ErrDuplicate
+		}
+		changes = append(changes, embeddedChange{id: id})
+	}
+	if d.uniqueViolation(newID, doc, changes) {
+		return nil, ErrDuplicate
+	}
+	return append(changes, embeddedChange{id: newID, doc: doc}), nil
+}
+
+func (c *embeddedCollection) Insert(docs ...interface{}) (err error) {
+	c.es.mutex.Lock()
+	defer c.es.mutex.Unlock()
+	changes := []embeddedChange{}
+	for _, d := range docs {
+		var doc bson.M
+		doc, err = toDocument(d)
+		if err != nil {
+			return
+		}
+		var change embeddedChange
+		change, err = c.insertChange(doc, changes)
+		if err != nil {
+			return
+		}
+		changes = append(changes, change)
+	}
+	return c.es.commit(c.name, changes)
+}
+
+func (c *embeddedCollection) update(selector bson.M, update interface{}, all bool) (updated int, err error) {
+	c.es.mutex.Lock()
+	defer c.es.mutex.Unlock()
+	normalized, err := toDocument(selector)
+	if err != nil {
+		return
+	}
+	ids, err := c.matching(normalized)
+	if err != nil {
+		return
+	}
+	if !all && len(ids) > 1 {
+		ids = ids[:1]
+	}
+	d := c.es.data(c.name)
+	changes := []embeddedChange{}
+	for _, id := range ids {
+		var doc bson.M
+		doc, err = updateDocument(d.docs[id], normalized, update)
+		if err != nil {
+			return
+		}
+		changes, err = c.updateChanges(id, doc, changes)
+		if err != nil {
+			return
+		}
+	}
+	err = c.es.commit(c.name, changes)
+	if err != nil {
+		return
+	}
+	updated = len(ids)
+	return
+}
+
+func (c *embeddedCollection) Update(selector bson.M, update interface{}) (err error) {
+	updated, err := c.update(selector, update, false)
+	if err == nil && updated == 0 {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (c *embeddedCollection) UpdateAll(selector bson.M, update interface{}) (updated int, err error) {
+	return c.update(selector, update, true)
+}
+
+func (c *embeddedCollection) Upsert(selector bson.M, update interface{}) (err error) {
+	c.es.mutex.Lock()
+	defer c.es.mutex.Unlock()
+	normalized, err := toDocument(selector)
+	if err != nil {
+		return
+	}
+	ids, err := c.matching(normalized)
+	if err != nil {
+		return
+	}
+	var changes []embeddedChange
+	if len(ids) > 0 {
+		var doc bson.M
+		doc, err = updateDocument(c.es.data(c.name).docs[ids[0]], normalized, update)
+		if err != nil {
+			return
+		}
+		changes, err = c.updateChanges(ids[0], doc, nil)
+		if err != nil {
+			return
+		}
+		return c.es.commit(c.name, changes)
+	}
+	doc, err := upsertDocument(normalized, update)
+	if err != nil {
+		return
+	}
+	change, err := c.insertChange(doc, nil)
+	if err != nil {
+		return
+	}
+	return c.es.commit(c.name, []embeddedChange{change})
+}
+
+func (c *embeddedCollection) RemoveAll(selector bson.M) (removed int, err error) {
+	c.es.mutex.Lock()
+	defer c.es.mutex.Unlock()
+	ids, err := c.matching(selector)
+	if err != nil {
+		return
+	}
+	changes := make([]embeddedChange, len(ids))
+	for i, id := range ids {
+		changes[i] = embeddedChange{id: id}
+	}
+	err = c.es.commit(c.name, changes)
+	if err != nil {
+		return
+	}
+	removed = len(ids)
+	return
+}
+
+// EnsureIndex unique indexes are enforced, the others are not needed
+func (c *embeddedCollection) EnsureIndex(key []string, unique bool) (err error) {
+	if !unique {
+		return
+	}
+	c.es.mutex.Lock()
+	defer c.es.mutex.Unlock()
+	d := c.es.data(c.name)
+	for _, existing := range d.unique {
+		if strings.Join(existing, "\x00") == strings.Join(key, "\x00") {
+			return
+		}
+	}
+	ids := make([]string, 0, len(d.docs))
+	for id := range d.docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			if sameKey(d.docs[ids[i]], d.docs[ids[j]], key) {
+				return fmt.Errorf("(EnsureIndex) %s: documents %s and %s have the same %s", c.name, ids[i], ids[j], strings.Join(key, ","))
+			}
+		}
+	}
+	d.unique = append(d.unique, key)
+	return
+}
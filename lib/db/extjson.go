@@ -0,0 +1,191 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Documents are stored as JSON in PostgreSQL. The BSON types JSON cannot represent are written in
+// the MongoDB extended JSON notation, so that a document is read back with the same types:
+// {"$date": "..."}, {"$oid": "..."}, {"$numberLong": "..."}, {"$numberDouble": "..."} and
+// {"$binary": "...", "$type": "00"}. Floats with a fraction and ints are plain JSON numbers.
+
+// jsonDateFormat RFC 3339 with a fixed number of fractional digits, dates compare and sort as strings
+const jsonDateFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+func encodeJSON(doc bson.M) ([]byte, error) {
+	v, err := toJSONValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func decodeJSON(data []byte) (doc bson.M, err error) {
+	value, err := decodeJSONValue(data)
+	if err != nil {
+		return
+	}
+	doc, ok := value.(bson.M)
+	if !ok {
+		err = fmt.Errorf("(decodeJSON) not a document")
+	}
+	return
+}
+
+// decodeJSONValue decodes any JSON value, e.g. a value of Distinct
+func decodeJSONValue(data []byte) (value interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	err = decoder.Decode(&v)
+	if err != nil {
+		return
+	}
+	return fromJSONValue(v)
+}
+
+func toJSONValue(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case nil, string, bool, int:
+		return t, nil
+	case int32:
+		return int(t), nil
+	case int64:
+		return map[string]interface{}{"$numberLong": strconv.FormatInt(t, 10)}, nil
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) || math.Trunc(t) == t {
+			return map[string]interface{}{"$numberDouble": strconv.FormatFloat(t, 'g', -1, 64)}, nil
+		}
+		return t, nil
+	case time.Time:
+		return map[string]interface{}{"$date": t.UTC().Format(jsonDateFormat)}, nil
+	case bson.ObjectId:
+		return map[string]interface{}{"$oid": t.Hex()}, nil
+	case []byte:
+		return map[string]interface{}{"$binary": base64.StdEncoding.EncodeToString(t), "$type": "00"}, nil
+	case bson.Binary:
+		return map[string]interface{}{"$binary": base64.StdEncoding.EncodeToString(t.Data), "$type": fmt.Sprintf("%02x", t.Kind)}, nil
+	case bson.M:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			ev, err := toJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = ev
+		}
+		return m, nil
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, e := range t {
+			ev, err := toJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = ev
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("(toJSONValue) unsupported type %T", v)
+}
+
+func fromJSONValue(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case json.Number:
+		s := t.String()
+		if strings.ContainsAny(s, ".eE") {
+			return t.Float64()
+		}
+		i, err := t.Int64()
+		return int(i), err
+	case map[string]interface{}:
+		if value, ok, err := fromExtendedJSON(t); ok {
+			return value, err
+		}
+		m := make(bson.M, len(t))
+		for k, e := range t {
+			ev, err := fromJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = ev
+		}
+		return m, nil
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, e := range t {
+			ev, err := fromJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = ev
+		}
+		return a, nil
+	}
+	return v, nil
+}
+
+// fromExtendedJSON ok is false if m is a plain document
+func fromExtendedJSON(m map[string]interface{}) (value interface{}, ok bool, err error) {
+	if len(m) == 2 {
+		data, isBinary := m["$binary"].(string)
+		kind, hasType := m["$type"].(string)
+		if !isBinary || !hasType {
+			return
+		}
+		ok = true
+		var raw []byte
+		raw, err = base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return
+		}
+		var k uint64
+		k, err = strconv.ParseUint(kind, 16, 8)
+		if err != nil {
+			return
+		}
+		if k == 0 {
+			value = raw
+			return
+		}
+		value = bson.Binary{Kind: byte(k), Data: raw}
+		return
+	}
+	if len(m) != 1 {
+		return
+	}
+	for key, e := range m {
+		s, isString := e.(string)
+		if !isString {
+			return
+		}
+		switch key {
+		case "$date":
+			ok = true
+			value, err = time.Parse(time.RFC3339Nano, s)
+		case "$oid":
+			ok = true
+			if !bson.IsObjectIdHex(s) {
+				err = fmt.Errorf("(fromExtendedJSON) invalid ObjectId %s", s)
+				return
+			}
+			value = bson.ObjectIdHex(s)
+		case "$numberLong":
+			ok = true
+			value, err = strconv.ParseInt(s, 10, 64)
+		case "$numberDouble":
+			ok = true
+			value, err = strconv.ParseFloat(s, 64)
+		}
+	}
+	return
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"github.com/MG-RAST/AWE/lib/conf"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// mongoStore every operation uses a copy of the session of Connection
+type mongoStore struct{}
+
+func (mongoStore) C(name string) Collection {
+	return &mongoCollection{name: name}
+}
+
+func (mongoStore) Drop() error {
+	return Connection.DB.DropDatabase()
+}
+
+type mongoCollection struct {
+	name string
+}
+
+func (c *mongoCollection) session() (*mgo.Session, *mgo.Collection) {
+	session := Connection.Session.Copy()
+	return session, session.DB(conf.MONGODB_DATABASE).C(c.name)
+}
+
+func (c *mongoCollection) Find(q bson.M) Query {
+	return &mongoQuery{c: c, q: q}
+}
+
+func (c *mongoCollection) Insert(docs ...interface{}) error {
+	session, mc := c.session()
+	defer session.Close()
+	return mc.Insert(docs...)
+}
+
+func (c *mongoCollection) Update(selector bson.M, update interface{}) error {
+	session, mc := c.session()
+	defer session.Close()
+	return mc.Update(selector, update)
+}
+
+func (c *mongoCollection) UpdateAll(selector bson.M, update interface{}) (updated int, err error) {
+	session, mc := c.session()
+	defer session.Close()
+	info, err := mc.UpdateAll(selector, update)
+	if err != nil {
+		return
+	}
+	updated = info.Updated
+	return
+}
+
+func (c *mongoCollection) Upsert(selector bson.M, update interface{}) (err error) {
+	session, mc := c.session()
+	defer session.Close()
+	_, err = mc.Upsert(selector, update)
+	return
+}
+
+func (c *mongoCollection) RemoveAll(selector bson.M) (removed int, err error) {
+	session, mc := c.session()
+	defer session.Close()
+	info, err := mc.RemoveAll(selector)
+	if err != nil {
+		return
+	}
+	removed = info.Removed
+	return
+}
+
+func (c *mongoCollection) EnsureIndex(key []string, unique bool) error {
+	session, mc := c.session()
+	defer session.Close()
+	return mc.EnsureIndex(mgo.Index{Key: key, Unique: unique, Background: !unique})
+}
+
+type mongoQuery struct {
+	c          *mongoCollection
+	q          bson.M
+	sort       []string
+	skip       int
+	limit      int
+	projection bson.M
+}
+
+func (q *mongoQuery) Sort(fields ...string) Query {
+	q.sort = fields
+	return q
+}
+
+func (q *mongoQuery) Skip(n int) Query {
+	q.skip = n
+	return q
+}
+
+func (q *mongoQuery) Limit(n int) Query {
+	q.limit = n
+	return q
+}
+
+func (q *mongoQuery) Select(projection bson.M) Query {
+	q.projection = projection
+	return q
+}
+
+func (q *mongoQuery) query(mc *mgo.Collection) (query *mgo.Query) {
+	query = mc.Find(q.q)
+	if len(q.sort) > 0 {
+		query = query.Sort(q.sort...)
+	}
+	if q.skip > 0 {
+		query = query.Skip(q.skip)
+	}
+	if q.limit > 0 {
+		query = query.Limit(q.limit)
+	}
+	if q.projection != nil {
+		query = query.Select(q.projection)
+	}
+	return
+}
+
+func (q *mongoQuery) Count() (int, error) {
+	session, mc := q.c.session()
+	defer session.Close()
+	return q.query(mc).Count()
+}
+
+func (q *mongoQuery) One(result interface{}) error {
+	session, mc := q.c.session()
+	defer session.Close()
+	return q.query(mc).One(result)
+}
+
+func (q *mongoQuery) All(result interface{}) error {
+	session, mc := q.c.session()
+	defer session.Close()
+	return q.query(mc).All(result)
+}
+
+func (q *mongoQuery) Distinct(key string, result interface{}) error {
+	session, mc := q.c.session()
+	defer session.Close()
+	return q.query(mc).Distinct(key, result)
+}
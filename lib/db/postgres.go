@@ -0,0 +1,584 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+
+	// registers the "postgres" driver of database/sql
+	_ "github.com/lib/pq"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The PostgreSQL backend keeps the documents of all collections as JSONB in one table. Selectors,
+// sorting, paging and counting are evaluated by PostgreSQL (see postgresSelector.go), projections on
+// nested fields and $elemMatch by the server. Writes lock only the matching rows, an update reads,
+// modifies and writes back the matching documents. Requires PostgreSQL 12 or newer (SQL/JSON path).
+
+// postgresMigrations the schema versions, applied in order and recorded in awe_schema_migrations.
+// Never change a released migration, append a new one.
+var postgresMigrations = []string{
+	// 1: documents
+	`CREATE TABLE awe_documents (
+		collection text NOT NULL,
+		id text NOT NULL,
+		doc jsonb NOT NULL,
+		PRIMARY KEY (collection, id)
+	);
+	CREATE INDEX awe_documents_doc_id ON awe_documents (collection, (doc #>> '{"id"}'));`,
+	// 2: jsonpath predicates and sorting
+	`CREATE INDEX awe_documents_doc_path ON awe_documents USING gin (doc jsonb_path_ops);
+	CREATE FUNCTION awe_sort_value(v jsonb) RETURNS jsonb LANGUAGE sql IMMUTABLE AS $$
+		SELECT CASE
+			WHEN jsonb_typeof(v) IS DISTINCT FROM 'object' THEN v
+			WHEN v ? '$numberLong' THEN to_jsonb((v ->> '$numberLong')::numeric)
+			WHEN v ? '$numberDouble' AND v ->> '$numberDouble' NOT IN ('NaN', '+Inf', '-Inf') THEN to_jsonb((v ->> '$numberDouble')::numeric)
+			WHEN v ? '$date' THEN v -> '$date'
+			WHEN v ? '$oid' THEN v -> '$oid'
+			ELSE v
+		END
+	$$;`,
+}
+
+// postgresMinVersion server_version_num of PostgreSQL 12, the first with SQL/JSON path
+const postgresMinVersion = 120000
+
+// postgresMigrationLock pg_advisory_lock key, servers of an HA cluster start concurrently
+const postgresMigrationLock = 4173
+
+type pgStore struct {
+	db      *sql.DB
+	mutex   sync.Mutex
+	indexed map[string]map[string]bool // collection -> top-level fields with an index
+}
+
+func openPostgres(url string) (pg *pgStore, err error) {
+	if url == "" {
+		err = fmt.Errorf("(openPostgres) [Postgres] url is not set")
+		return
+	}
+	sqlDB, err := sql.Open("postgres", url)
+	if err != nil {
+		err = fmt.Errorf("(openPostgres) sql.Open returned: %s", err.Error())
+		return
+	}
+	err = sqlDB.Ping()
+	if err != nil {
+		sqlDB.Close()
+		err = fmt.Errorf("(openPostgres) no reachable postgresql server: %s", err.Error())
+		return
+	}
+	var version int
+	err = sqlDB.QueryRow(`SHOW server_version_num`).Scan(&version)
+	if err == nil && version < postgresMinVersion {
+		err = fmt.Errorf("PostgreSQL %d is too old, 12 or newer is needed", version)
+	}
+	if err != nil {
+		sqlDB.Close()
+		err = fmt.Errorf("(openPostgres) %s", err.Error())
+		return
+	}
+	pg = &pgStore{db: sqlDB, indexed: make(map[string]map[string]bool)}
+	err = pg.migrate()
+	if err != nil {
+		sqlDB.Close()
+		pg = nil
+		err = fmt.Errorf("(openPostgres) %s", err.Error())
+	}
+	return
+}
+
+// migrate applies the migrations the database does not have yet
+func (pg *pgStore) migrate() (err error) {
+	conn, err := pg.db.Begin()
+	if err != nil {
+		return
+	}
+	defer conn.Rollback()
+	_, err = conn.Exec(`SELECT pg_advisory_xact_lock($1)`, postgresMigrationLock)
+	if err != nil {
+		return fmt.Errorf("(migrate) pg_advisory_xact_lock returned: %s", err.Error())
+	}
+	_, err = conn.Exec(`CREATE TABLE IF NOT EXISTS awe_schema_migrations (version integer PRIMARY KEY, applied timestamptz NOT NULL DEFAULT now())`)
+	if err != nil {
+		return fmt.Errorf("(migrate) creating awe_schema_migrations returned: %s", err.Error())
+	}
+	var version int
+	err = conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM awe_schema_migrations`).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("(migrate) reading the schema version returned: %s", err.Error())
+	}
+	if version > len(postgresMigrations) {
+		return fmt.Errorf("(migrate) the database schema (version %d) is newer than this server (version %d)", version, len(postgresMigrations))
+	}
+	for v := version + 1; v <= len(postgresMigrations); v++ {
+		_, err = conn.Exec(postgresMigrations[v-1])
+		if err != nil {
+			return fmt.Errorf("(migrate) migration %d returned: %s", v, err.Error())
+		}
+		_, err = conn.Exec(`INSERT INTO awe_schema_migrations (version) VALUES ($1)`, v)
+		if err != nil {
+			return fmt.Errorf("(migrate) recording migration %d returned: %s", v, err.Error())
+		}
+	}
+	return conn.Commit()
+}
+
+func (pg *pgStore) C(name string) Collection {
+	return &pgCollection{pg: pg, name: name}
+}
+
+func (pg *pgStore) Drop() (err error) {
+	_, err = pg.db.Exec(`DELETE FROM awe_documents`)
+	return
+}
+
+func (pg *pgStore) isIndexed(collection string, field string) bool {
+	pg.mutex.Lock()
+	defer pg.mutex.Unlock()
+	return field == "id" || pg.indexed[collection][field]
+}
+
+type pgCollection struct {
+	pg   *pgStore
+	name string
+}
+
+// queryer *sql.DB or *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type pgDocument struct {
+	id  string
+	doc bson.M
+}
+
+// quoteLiteral a string as SQL literal
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// jsonPath the text[] literal of a dotted path, for the #>> operator
+func jsonPath(field string) string {
+	segments := strings.Split(field, ".")
+	for i, s := range segments {
+		segments[i] = `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+	}
+	return quoteLiteral("{" + strings.Join(segments, ",") + "}")
+}
+
+// idString the primary key of a document
+func idString(id interface{}) string {
+	switch t := id.(type) {
+	case bson.ObjectId:
+		return t.Hex()
+	case string:
+		return t
+	}
+	return fmt.Sprintf("%v", id)
+}
+
+// where the WHERE clause of a selector and its arguments, $1 is the collection
+func (c *pgCollection) where(q bson.M) (where string, args []interface{}, err error) {
+	normalized, err := toDocument(q)
+	if err != nil {
+		err = fmt.Errorf("(where) invalid selector: %s", err.Error())
+		return
+	}
+	w := &pgWhere{
+		args:    []interface{}{c.name},
+		indexed: func(field string) bool { return c.pg.isIndexed(c.name, field) },
+	}
+	condition, err := w.selector(normalized)
+	if err != nil {
+		err = fmt.Errorf("(where) %s: %s", c.name, err.Error())
+		return
+	}
+	return "collection = $1 AND " + condition, w.args, nil
+}
+
+// scan runs a query returning id and document columns
+func (c *pgCollection) scan(tx queryer, query string, args []interface{}) (docs []pgDocument, err error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		err = fmt.Errorf("(scan) %s returned: %s", c.name, err.Error())
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var data []byte
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			return
+		}
+		var doc bson.M
+		doc, err = decodeJSON(data)
+		if err != nil {
+			err = fmt.Errorf("(scan) document %s of %s: %s", id, c.name, err.Error())
+			return
+		}
+		docs = append(docs, pgDocument{id: id, doc: doc})
+	}
+	err = rows.Err()
+	return
+}
+
+// lock returns the matching documents locked for an update, in the order of the ids like all
+// writers lock them
+func (c *pgCollection) lock(tx queryer, selector bson.M, first bool) (docs []pgDocument, err error) {
+	where, args, err := c.where(selector)
+	if err != nil {
+		return
+	}
+	query := "SELECT id, doc FROM awe_documents WHERE " + where + " ORDER BY id"
+	if first {
+		query += " LIMIT 1"
+	}
+	return c.scan(tx, query+" FOR UPDATE", args)
+}
+
+// withTx runs f in a transaction, committed if f returns no error
+func (c *pgCollection) withTx(f func(tx *sql.Tx) error) (err error) {
+	tx, err := c.pg.db.Begin()
+	if err != nil {
+		return
+	}
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	return tx.Commit()
+}
+
+func isUniqueViolation(err error) bool {
+	if e, ok := err.(interface {
+		SQLState() string
+	}); ok {
+		return e.SQLState() == "23505"
+	}
+	return strings.Contains(err.Error(), "duplicate key value")
+}
+
+func (c *pgCollection) insert(tx queryer, doc bson.M) (err error) {
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+	data, err := encodeJSON(doc)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`INSERT INTO awe_documents (collection, id, doc) VALUES ($1, $2, $3)`, c.name, idString(doc["_id"]), string(data))
+	if err != nil && isUniqueViolation(err) {
+		err = ErrDuplicate
+	}
+	return
+}
+
+func (c *pgCollection) save(tx queryer, id string, doc bson.M) (err error) {
+	data, err := encodeJSON(doc)
+	if err != nil {
+		return
+	}
+	newID := idString(doc["_id"])
+	_, err = tx.Exec(`UPDATE awe_documents SET id = $3, doc = $4 WHERE collection = $1 AND id = $2`, c.name, id, newID, string(data))
+	if err != nil && isUniqueViolation(err) {
+		err = ErrDuplicate
+	}
+	return
+}
+
+func (c *pgCollection) Find(q bson.M) Query {
+	return &pgQuery{c: c, q: q}
+}
+
+// pgQuery a Query evaluated by PostgreSQL
+type pgQuery struct {
+	c          *pgCollection
+	q          bson.M
+	sort       []string
+	skip       int
+	limit      int
+	projection bson.M
+}
+
+func (q *pgQuery) Sort(fields ...string) Query {
+	q.sort = fields
+	return q
+}
+
+func (q *pgQuery) Skip(n int) Query {
+	q.skip = n
+	return q
+}
+
+func (q *pgQuery) Limit(n int) Query {
+	q.limit = n
+	return q
+}
+
+func (q *pgQuery) Select(projection bson.M) Query {
+	q.projection = projection
+	return q
+}
+
+// sql the SELECT of the query with the given columns, sorted and paged
+func (q *pgQuery) sql(columns string) (query string, args []interface{}, err error) {
+	where, args, err := q.c.where(q.q)
+	if err != nil {
+		return
+	}
+	query = "SELECT " + columns + " FROM awe_documents WHERE " + where
+	if len(q.sort) > 0 {
+		order := []string{}
+		for _, field := range q.sort {
+			order = append(order, pgOrder(field))
+		}
+		// ties in the same order for paging
+		query += " ORDER BY " + strings.Join(order, ", ") + ", id"
+	}
+	if q.limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.limit)
+	}
+	if q.skip > 0 {
+		query += fmt.Sprintf(" OFFSET %d", q.skip)
+	}
+	return
+}
+
+// run returns the matching documents, sorted, paged and projected
+func (q *pgQuery) run() (docs []bson.M, err error) {
+	var projection bson.M
+	if q.projection != nil {
+		projection, err = toDocument(q.projection)
+		if err != nil {
+			return
+		}
+	}
+	query, args, err := q.sql("id, " + pgProjection(projection))
+	if err != nil {
+		return
+	}
+	loaded, err := q.c.scan(q.c.pg.db, query, args)
+	if err != nil {
+		return
+	}
+	docs = make([]bson.M, len(loaded))
+	for i := range loaded {
+		docs[i] = loaded[i].doc
+		if projection != nil {
+			docs[i], err = project(docs[i], projection)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (q *pgQuery) Count() (count int, err error) {
+	query, args, err := q.sql("1")
+	if err != nil {
+		return
+	}
+	err = q.c.pg.db.QueryRow("SELECT count(*) FROM ("+query+") AS matched", args...).Scan(&count)
+	if err != nil {
+		err = fmt.Errorf("(Count) %s returned: %s", q.c.name, err.Error())
+	}
+	return
+}
+
+func (q *pgQuery) One(result interface{}) (err error) {
+	if q.limit == 0 {
+		q.limit = 1
+	}
+	docs, err := q.run()
+	if err != nil {
+		return
+	}
+	if len(docs) == 0 {
+		return ErrNotFound
+	}
+	return fromDocument(docs[0], result)
+}
+
+func (q *pgQuery) All(result interface{}) (err error) {
+	docs, err := q.run()
+	if err != nil {
+		return
+	}
+	return fromDocuments(docs, result)
+}
+
+// Distinct the values of a field, the elements of arrays count as values
+func (q *pgQuery) Distinct(key string, result interface{}) (err error) {
+	where, args, err := q.c.where(q.q)
+	if err != nil {
+		return
+	}
+	args = append(args, jpPath("$", key)+"[*]")
+	query := fmt.Sprintf("SELECT DISTINCT value FROM awe_documents, jsonb_path_query(doc, $%d::jsonpath) AS value WHERE %s", len(args), where)
+	rows, err := q.c.pg.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("(Distinct) %s returned: %s", q.c.name, err.Error())
+	}
+	defer rows.Close()
+	values := []interface{}{}
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return
+		}
+		var v interface{}
+		v, err = decodeJSONValue(data)
+		if err != nil {
+			return
+		}
+		// an int64 and an int of the same value are different JSON values
+		if !containsValue(values, v) {
+			values = append(values, v)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	return fromValue(values, result)
+}
+
+func (c *pgCollection) Insert(docs ...interface{}) error {
+	return c.withTx(func(tx *sql.Tx) error {
+		for _, d := range docs {
+			doc, err := toDocument(d)
+			if err != nil {
+				return err
+			}
+			err = c.insert(tx, doc)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *pgCollection) update(selector bson.M, update interface{}, all bool) (updated int, err error) {
+	normalized, err := toDocument(selector)
+	if err != nil {
+		return
+	}
+	err = c.withTx(func(tx *sql.Tx) error {
+		docs, err := c.lock(tx, selector, !all)
+		if err != nil {
+			return err
+		}
+		for _, d := range docs {
+			doc, err := updateDocument(d.doc, normalized, update)
+			if err != nil {
+				return err
+			}
+			err = c.save(tx, d.id, doc)
+			if err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	return
+}
+
+func (c *pgCollection) Update(selector bson.M, update interface{}) (err error) {
+	updated, err := c.update(selector, update, false)
+	if err == nil && updated == 0 {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (c *pgCollection) UpdateAll(selector bson.M, update interface{}) (updated int, err error) {
+	return c.update(selector, update, true)
+}
+
+func (c *pgCollection) Upsert(selector bson.M, update interface{}) (err error) {
+	normalized, err := toDocument(selector)
+	if err != nil {
+		return
+	}
+	return c.withTx(func(tx *sql.Tx) error {
+		docs, err := c.lock(tx, selector, true)
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			doc, err := updateDocument(docs[0].doc, normalized, update)
+			if err != nil {
+				return err
+			}
+			return c.save(tx, docs[0].id, doc)
+		}
+		doc, err := upsertDocument(normalized, update)
+		if err != nil {
+			return err
+		}
+		return c.insert(tx, doc)
+	})
+}
+
+func (c *pgCollection) RemoveAll(selector bson.M) (removed int, err error) {
+	where, args, err := c.where(selector)
+	if err != nil {
+		return
+	}
+	result, err := c.pg.db.Exec("DELETE FROM awe_documents WHERE "+where, args...)
+	if err != nil {
+		err = fmt.Errorf("(RemoveAll) %s returned: %s", c.name, err.Error())
+		return
+	}
+	n, err := result.RowsAffected()
+	removed = int(n)
+	return
+}
+
+var identifierChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// EnsureIndex creates an expression index on the fields, restricted to the collection
+func (c *pgCollection) EnsureIndex(key []string, unique bool) (err error) {
+	expressions := []string{}
+	for _, field := range key {
+		expressions = append(expressions, "(doc #>> "+jsonPath(strings.TrimPrefix(field, "-"))+")")
+	}
+	h := fnv.New32a()
+	h.Write([]byte(c.name + "\x00" + strings.Join(key, "\x00")))
+	name := identifierChars.ReplaceAllString(strings.ToLower(c.name+"_"+strings.Join(key, "_")), "_")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	name = fmt.Sprintf("awe_%s_%08x", name, h.Sum32())
+
+	statement := "CREATE INDEX"
+	if unique {
+		statement = "CREATE UNIQUE INDEX"
+	}
+	statement += fmt.Sprintf(" IF NOT EXISTS %s ON awe_documents (%s) WHERE collection = %s", name, strings.Join(expressions, ", "), quoteLiteral(c.name))
+	_, err = c.pg.db.Exec(statement)
+	if err != nil {
+		return fmt.Errorf("(EnsureIndex) %s returned: %s", statement, err.Error())
+	}
+	if len(key) == 1 && !strings.Contains(key[0], ".") {
+		c.pg.mutex.Lock()
+		if c.pg.indexed[c.name] == nil {
+			c.pg.indexed[c.name] = make(map[string]bool)
+		}
+		c.pg.indexed[c.name][key[0]] = true
+		c.pg.mutex.Unlock()
+	}
+	return
+}
@@ -0,0 +1,397 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Selectors of the PostgreSQL backend are compiled to SQL. Equality on the _id and on indexed
+// top-level string fields compares the primary key and the expression indexes, all other conditions
+// are SQL/JSON path predicates on the document (doc @@ jsonpath), which the GIN index of the
+// documents serves for equality. The lax mode of jsonpath descends into arrays like MongoDB does.
+// Numbers, dates and ObjectIds are compared in their extended JSON form, see extjson.go.
+
+// pgWhere collects the arguments of a WHERE clause, $1 is the collection
+type pgWhere struct {
+	args    []interface{}
+	indexed func(field string) bool
+}
+
+func (w *pgWhere) arg(v interface{}) string {
+	w.args = append(w.args, v)
+	return fmt.Sprintf("$%d", len(w.args))
+}
+
+func sortedKeys(m bson.M) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// subSelectors the documents of $or, $and and $nor
+func subSelectors(op string, cond interface{}) (subs []bson.M, err error) {
+	list, isArray := cond.([]interface{})
+	if !isArray || len(list) == 0 {
+		return nil, fmt.Errorf("%s needs a non-empty array", op)
+	}
+	for _, sub := range list {
+		q, isDoc := sub.(bson.M)
+		if !isDoc {
+			return nil, fmt.Errorf("%s needs an array of documents", op)
+		}
+		subs = append(subs, q)
+	}
+	return
+}
+
+// selector the SQL condition of a normalized selector
+func (w *pgWhere) selector(q bson.M) (condition string, err error) {
+	conditions := []string{}
+	for _, k := range sortedKeys(q) {
+		var c string
+		switch k {
+		case "$or", "$and", "$nor":
+			var subs []bson.M
+			subs, err = subSelectors(k, q[k])
+			if err != nil {
+				return
+			}
+			parts := []string{}
+			for _, sub := range subs {
+				var p string
+				p, err = w.selector(sub)
+				if err != nil {
+					return
+				}
+				parts = append(parts, "("+p+")")
+			}
+			switch k {
+			case "$or":
+				c = "(" + strings.Join(parts, " OR ") + ")"
+			case "$and":
+				c = "(" + strings.Join(parts, " AND ") + ")"
+			case "$nor":
+				c = "NOT (" + strings.Join(parts, " OR ") + ")"
+			}
+		default:
+			c, err = w.field(k, q[k])
+			if err != nil {
+				return
+			}
+		}
+		conditions = append(conditions, c)
+	}
+	if len(conditions) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+// field the SQL condition of one field of a selector
+func (w *pgWhere) field(k string, cond interface{}) (condition string, err error) {
+	if strings.HasPrefix(k, "$") {
+		return "", fmt.Errorf("unsupported query operator %s", k)
+	}
+	if !isOperatorDoc(cond) {
+		switch v := cond.(type) {
+		case nil:
+		case bson.M, []interface{}:
+			// a document or array equals as a whole
+			var value interface{}
+			value, err = toJSONValue(v)
+			if err != nil {
+				return
+			}
+			var data []byte
+			data, err = json.Marshal(value)
+			if err != nil {
+				return
+			}
+			return fmt.Sprintf("doc #> %s = %s::jsonb", jsonPath(k), w.arg(string(data))), nil
+		default:
+			if k == "_id" {
+				return "id = " + w.arg(idString(v)), nil
+			}
+			if s, isString := v.(string); isString && !strings.Contains(k, ".") && w.indexed(k) {
+				return fmt.Sprintf("doc #>> %s = %s", jsonPath(k), w.arg(s)), nil
+			}
+		}
+	}
+	predicate, err := jpField(jpPath("$", k), cond)
+	if err != nil {
+		return
+	}
+	return "doc @@ " + w.arg(predicate) + "::jsonpath", nil
+}
+
+// jpString a jsonpath string literal
+func jpString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// jpPath the jsonpath of a dotted field, relative to base ("$" or "@")
+func jpPath(base string, field string) string {
+	path := base
+	for _, segment := range strings.Split(field, ".") {
+		path += "." + jpString(segment)
+	}
+	return path
+}
+
+// jpNumber a jsonpath numeric literal
+func jpNumber(v interface{}) (literal string, ok bool) {
+	switch t := v.(type) {
+	case int:
+		return strconv.Itoa(t), true
+	case int32:
+		return strconv.FormatInt(int64(t), 10), true
+	case int64:
+		return strconv.FormatInt(t, 10), true
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+var jpComparisons = map[string]string{"$eq": "==", "$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}
+
+// jpCompare the filter predicate comparing the current item @ with a value
+func jpCompare(op string, v interface{}) (predicate string, err error) {
+	switch t := v.(type) {
+	case string:
+		return "@ " + op + " " + jpString(t), nil
+	case bool:
+		return "@ " + op + " " + strconv.FormatBool(t), nil
+	case time.Time:
+		return `@."$date" ` + op + " " + jpString(t.UTC().Format(jsonDateFormat)), nil
+	case bson.ObjectId:
+		return `@."$oid" ` + op + " " + jpString(t.Hex()), nil
+	}
+	n, ok := jpNumber(v)
+	if !ok {
+		return "", fmt.Errorf("unsupported value %T in a query", v)
+	}
+	return fmt.Sprintf(`(@ %[1]s %[2]s || @."$numberLong".double() %[1]s %[2]s || @."$numberDouble".double() %[1]s %[2]s)`, op, n), nil
+}
+
+// jpEqual the predicate of target equal to v, null matches missing fields
+func jpEqual(target string, v interface{}) (predicate string, err error) {
+	if v == nil {
+		return "!exists(" + target + " ? (@ != null))", nil
+	}
+	c, err := jpCompare("==", v)
+	if err != nil {
+		return
+	}
+	return "exists(" + target + " ? (" + c + "))", nil
+}
+
+// jpRegex the predicate of a $regex condition
+func jpRegex(target string, pattern interface{}, options interface{}) (predicate string, err error) {
+	var expr, opts string
+	switch t := pattern.(type) {
+	case string:
+		expr = t
+	case bson.RegEx:
+		expr, opts = t.Pattern, t.Options
+	default:
+		return "", fmt.Errorf("$regex needs a string")
+	}
+	if s, ok := options.(string); ok {
+		opts += s
+	}
+	flags := ""
+	for _, f := range opts {
+		if strings.ContainsRune("imsx", f) && !strings.ContainsRune(flags, f) {
+			flags += string(f)
+		}
+	}
+	predicate = target + " ? (@ like_regex " + jpString(expr)
+	if flags != "" {
+		predicate += " flag " + jpString(flags)
+	}
+	return "exists(" + predicate + "))", nil
+}
+
+// jpSelector the predicate of a selector, relative to base ("@" for the elements of $elemMatch)
+func jpSelector(base string, q bson.M) (predicate string, err error) {
+	parts := []string{}
+	for _, k := range sortedKeys(q) {
+		var p string
+		switch k {
+		case "$or", "$and", "$nor":
+			var subs []bson.M
+			subs, err = subSelectors(k, q[k])
+			if err != nil {
+				return
+			}
+			alternatives := []string{}
+			for _, sub := range subs {
+				var s string
+				s, err = jpSelector(base, sub)
+				if err != nil {
+					return
+				}
+				alternatives = append(alternatives, "("+s+")")
+			}
+			switch k {
+			case "$or":
+				p = "(" + strings.Join(alternatives, " || ") + ")"
+			case "$and":
+				p = "(" + strings.Join(alternatives, " && ") + ")"
+			case "$nor":
+				p = "!(" + strings.Join(alternatives, " || ") + ")"
+			}
+		default:
+			if strings.HasPrefix(k, "$") {
+				return "", fmt.Errorf("unsupported query operator %s", k)
+			}
+			p, err = jpField(jpPath(base, k), q[k])
+			if err != nil {
+				return
+			}
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return "exists(" + base + ")", nil
+	}
+	return strings.Join(parts, " && "), nil
+}
+
+// jpField the predicate of the condition on a field, target is its jsonpath
+func jpField(target string, cond interface{}) (predicate string, err error) {
+	if !isOperatorDoc(cond) {
+		return jpEqual(target, cond)
+	}
+	ops := cond.(bson.M)
+	parts := []string{}
+	for _, op := range sortedKeys(ops) {
+		arg := ops[op]
+		var p string
+		switch op {
+		case "$eq":
+			p, err = jpEqual(target, arg)
+		case "$ne":
+			p, err = jpEqual(target, arg)
+			p = "!(" + p + ")"
+		case "$gt", "$gte", "$lt", "$lte":
+			p, err = jpCompare(jpComparisons[op], arg)
+			p = "exists(" + target + " ? (" + p + "))"
+		case "$in", "$nin":
+			list, isArray := arg.([]interface{})
+			if !isArray {
+				return "", fmt.Errorf("%s needs an array", op)
+			}
+			alternatives := []string{}
+			for _, item := range list {
+				var e string
+				e, err = jpEqual(target, item)
+				if err != nil {
+					return
+				}
+				alternatives = append(alternatives, e)
+			}
+			if len(alternatives) == 0 {
+				// matches nothing
+				p = "(1 == 2)"
+			} else {
+				p = "(" + strings.Join(alternatives, " || ") + ")"
+			}
+			if op == "$nin" {
+				p = "!" + p
+			}
+		case "$exists":
+			p = "exists(" + target + ")"
+			if !truthy(arg) {
+				p = "!" + p
+			}
+		case "$regex":
+			p, err = jpRegex(target, arg, ops["$options"])
+		case "$options":
+			continue
+		case "$elemMatch":
+			sub, isDoc := arg.(bson.M)
+			if !isDoc {
+				return "", fmt.Errorf("$elemMatch needs a document")
+			}
+			if isOperatorDoc(sub) {
+				p, err = jpField("@", sub)
+			} else {
+				p, err = jpSelector("@", sub)
+			}
+			p = "exists(" + target + "[*] ? (" + p + "))"
+		case "$size":
+			n, ok := jpNumber(arg)
+			if !ok {
+				return "", fmt.Errorf("$size needs a number")
+			}
+			p = "(" + target + ".size() == " + n + ")"
+		default:
+			return "", fmt.Errorf("unsupported query operator %s", op)
+		}
+		if err != nil {
+			return
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return "exists($)", nil
+	}
+	return strings.Join(parts, " && "), nil
+}
+
+// pgOrder the ORDER BY expression of a sort field, missing values sort first like in MongoDB
+func pgOrder(field string) string {
+	expr := "awe_sort_value(doc #> " + jsonPath(strings.TrimPrefix(strings.TrimPrefix(field, "-"), "+")) + ")"
+	if strings.HasPrefix(field, "-") {
+		return expr + " DESC NULLS LAST"
+	}
+	return expr + " ASC NULLS FIRST"
+}
+
+// pgProjection the SQL expression of the document restricted to the top-level fields of an
+// inclusion projection, "doc" if the projection has to be evaluated on the whole document
+func pgProjection(projection bson.M) string {
+	if len(projection) == 0 {
+		return "doc"
+	}
+	keys := []string{}
+	withID := true
+	for _, k := range sortedKeys(projection) {
+		if strings.HasPrefix(k, "$") || strings.Contains(k, ".") || isOperatorDoc(projection[k]) {
+			return "doc"
+		}
+		if !truthy(projection[k]) {
+			if k != "_id" {
+				return "doc"
+			}
+			withID = false
+			continue
+		}
+		if k != "_id" {
+			keys = append(keys, quoteLiteral(k))
+		}
+	}
+	if withID {
+		keys = append(keys, quoteLiteral("_id"))
+	}
+	if len(keys) == 0 {
+		return "doc"
+	}
+	return "COALESCE((SELECT jsonb_object_agg(key, value) FROM jsonb_each(doc) WHERE key IN (" + strings.Join(keys, ", ") + ")), '{}'::jsonb)"
+}
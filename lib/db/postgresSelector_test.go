@@ -0,0 +1,80 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPostgresWhere(t *testing.T) {
+	date := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		q     bson.M
+		where string
+		args  []interface{}
+	}{
+		{"empty", bson.M{}, "TRUE", nil},
+		{"id", bson.M{"_id": "abc"}, "id = $2", []interface{}{"abc"}},
+		{"indexed", bson.M{"state": "queued"}, `doc #>> '{"state"}' = $2`, []interface{}{"queued"}},
+		{"not indexed", bson.M{"info.name": "x"}, "doc @@ $2::jsonpath", []interface{}{`exists($."info"."name" ? (@ == "x"))`}},
+		{"null", bson.M{"error": nil}, "doc @@ $2::jsonpath", []interface{}{`!exists($."error" ? (@ != null))`}},
+		{"number", bson.M{"n": bson.M{"$gte": 2}}, "doc @@ $2::jsonpath", []interface{}{`exists($."n" ? ((@ >= 2 || @."$numberLong".double() >= 2 || @."$numberDouble".double() >= 2)))`}},
+		{"date", bson.M{"expiration": bson.M{"$lt": date}}, "doc @@ $2::jsonpath", []interface{}{`exists($."expiration" ? (@."$date" < "2026-01-02T03:04:05.000000000Z"))`}},
+		{"in and exists", bson.M{"state": bson.M{"$in": []string{"a", "b"}}, "x": bson.M{"$exists": false}}, "doc @@ $2::jsonpath AND doc @@ $3::jsonpath", []interface{}{
+			`(exists($."state" ? (@ == "a")) || exists($."state" ? (@ == "b")))`, `!exists($."x")`}},
+		{"nin", bson.M{"state": bson.M{"$nin": []string{}}}, "doc @@ $2::jsonpath", []interface{}{`!(1 == 2)`}},
+		{"regex", bson.M{"name": bson.M{"$regex": "^a\"", "$options": "i"}}, "doc @@ $2::jsonpath", []interface{}{`exists($."name" ? (@ like_regex "^a\"" flag "i"))`}},
+		{"elemMatch", bson.M{"tasks": bson.M{"$elemMatch": bson.M{"id": "1", "n": bson.M{"$ne": 0}}}}, "doc @@ $2::jsonpath", []interface{}{
+			`exists($."tasks"[*] ? (exists(@."id" ? (@ == "1")) && !(exists(@."n" ? ((@ == 0 || @."$numberLong".double() == 0 || @."$numberDouble".double() == 0))))))`}},
+		{"or", bson.M{"$or": []bson.M{{"_id": "a"}, {"state": "b"}}}, `((id = $2) OR (doc #>> '{"state"}' = $3))`, []interface{}{"a", "b"}},
+		{"document", bson.M{"acl": bson.M{"owner": "u"}}, `doc #> '{"acl"}' = $2::jsonb`, []interface{}{`{"owner":"u"}`}},
+	}
+	for _, test := range tests {
+		normalized, err := toDocument(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &pgWhere{indexed: func(field string) bool { return field == "state" }, args: []interface{}{"jobs"}}
+		where, err := w.selector(normalized)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if where != test.where {
+			t.Errorf("%s: got %s, want %s", test.name, where, test.where)
+		}
+		if !reflect.DeepEqual(w.args[1:], test.args) && !(len(w.args) == 1 && test.args == nil) {
+			t.Errorf("%s: got arguments %q, want %q", test.name, w.args[1:], test.args)
+		}
+	}
+
+	for _, q := range []bson.M{{"$where": "x"}, {"a": bson.M{"$near": 1}}, {"$or": []bson.M{}}} {
+		normalized, _ := toDocument(q)
+		w := &pgWhere{indexed: func(string) bool { return false }}
+		if _, err := w.selector(normalized); err == nil {
+			t.Errorf("%v: expected an error", q)
+		}
+	}
+}
+
+func TestPostgresProjection(t *testing.T) {
+	tests := []struct {
+		projection bson.M
+		want       string
+	}{
+		{nil, "doc"},
+		{bson.M{"id": 1, "state": 1}, `COALESCE((SELECT jsonb_object_agg(key, value) FROM jsonb_each(doc) WHERE key IN ('id', 'state', '_id')), '{}'::jsonb)`},
+		{bson.M{"id": 1, "_id": 0}, `COALESCE((SELECT jsonb_object_agg(key, value) FROM jsonb_each(doc) WHERE key IN ('id')), '{}'::jsonb)`},
+		{bson.M{"info.name": 1}, "doc"},
+		{bson.M{"tasks": 0}, "doc"},
+		{bson.M{"tasks": bson.M{"$elemMatch": bson.M{"id": "1"}}}, "doc"},
+	}
+	for _, test := range tests {
+		if got := pgProjection(test.projection); got != test.want {
+			t.Errorf("%v: got %s", test.projection, got)
+		}
+	}
+}
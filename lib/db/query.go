@@ -0,0 +1,133 @@
+package db
+
+import (
+	"sort"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// documentQuery a Query of a backend without query engine: load returns the documents matching
+// the selector, sorting, paging and projections are done by the server
+type documentQuery struct {
+	load       func(q bson.M) ([]bson.M, error)
+	q          bson.M
+	sort       []string
+	skip       int
+	limit      int
+	projection bson.M
+}
+
+func (q *documentQuery) Sort(fields ...string) Query {
+	q.sort = fields
+	return q
+}
+
+func (q *documentQuery) Skip(n int) Query {
+	q.skip = n
+	return q
+}
+
+func (q *documentQuery) Limit(n int) Query {
+	q.limit = n
+	return q
+}
+
+func (q *documentQuery) Select(projection bson.M) Query {
+	q.projection = projection
+	return q
+}
+
+// run returns the matching documents, sorted, paged and projected
+func (q *documentQuery) run() (docs []bson.M, err error) {
+	docs, err = q.load(q.q)
+	if err != nil {
+		return
+	}
+	if len(q.sort) > 0 {
+		sort.SliceStable(docs, func(i, j int) bool {
+			return lessDocuments(docs[i], docs[j], q.sort)
+		})
+	}
+	if q.skip > 0 {
+		if q.skip >= len(docs) {
+			docs = nil
+		} else {
+			docs = docs[q.skip:]
+		}
+	}
+	if q.limit > 0 && q.limit < len(docs) {
+		docs = docs[:q.limit]
+	}
+	if q.projection == nil {
+		return
+	}
+	projection, err := toDocument(q.projection)
+	if err != nil {
+		return
+	}
+	for i := range docs {
+		docs[i], err = project(docs[i], projection)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (q *documentQuery) Count() (count int, err error) {
+	docs, err := q.run()
+	count = len(docs)
+	return
+}
+
+func (q *documentQuery) One(result interface{}) (err error) {
+	docs, err := q.run()
+	if err != nil {
+		return
+	}
+	if len(docs) == 0 {
+		return ErrNotFound
+	}
+	return fromDocument(docs[0], result)
+}
+
+func (q *documentQuery) All(result interface{}) (err error) {
+	docs, err := q.run()
+	if err != nil {
+		return
+	}
+	return fromDocuments(docs, result)
+}
+
+func (q *documentQuery) Distinct(key string, result interface{}) (err error) {
+	docs, err := q.run()
+	if err != nil {
+		return
+	}
+	values := []interface{}{}
+	path := strings.Split(key, ".")
+	for _, doc := range docs {
+		for _, v := range lookup(doc, path) {
+			elements := []interface{}{v}
+			if a, isArray := v.([]interface{}); isArray {
+				elements = a
+			}
+			for _, e := range elements {
+				if !containsValue(values, e) {
+					values = append(values, e)
+				}
+			}
+		}
+	}
+	return fromValue(values, result)
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, e := range values {
+		if equal(e, v) {
+			return true
+		}
+	}
+	return false
+}
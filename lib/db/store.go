@@ -0,0 +1,71 @@
+package db
+
+import (
+	"errors"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// database backends, [Database] backend
+const (
+	BackendMongoDB  = "mongodb"
+	BackendPostgres = "postgres"
+	BackendEmbedded = "embedded"
+)
+
+// ErrNotFound returned by One and Update if no document matched
+var ErrNotFound = mgo.ErrNotFound
+
+// ErrDuplicate returned by the PostgreSQL and embedded backends if a unique index or the document id is violated
+var ErrDuplicate = errors.New("duplicate key")
+
+// Store the persistence layer of the server. Jobs, tasks, workflow instances, client groups, users
+// and perf records are documents in named collections, queried and updated with the MongoDB query
+// language (bson.M selectors, $set/$inc/$push/$unset updates) that all implementations understand.
+type Store interface {
+	C(name string) Collection
+	Drop() error
+}
+
+// Collection a collection of documents
+type Collection interface {
+	Find(q bson.M) Query
+	Insert(docs ...interface{}) error
+	// Update updates the first matching document, update is either a replacement document or a
+	// document of update operators
+	Update(selector bson.M, update interface{}) error
+	UpdateAll(selector bson.M, update interface{}) (updated int, err error)
+	Upsert(selector bson.M, update interface{}) error
+	RemoveAll(selector bson.M) (removed int, err error)
+	EnsureIndex(key []string, unique bool) error
+}
+
+// Query a find operation, like mgo.Query
+type Query interface {
+	Sort(fields ...string) Query
+	Skip(n int) Query
+	Limit(n int) Query
+	Select(projection bson.M) Query
+	Count() (int, error)
+	One(result interface{}) error
+	All(result interface{}) error
+	Distinct(key string, result interface{}) error
+}
+
+var store Store
+
+// C returns a collection of the configured backend
+func C(name string) Collection {
+	return store.C(name)
+}
+
+// Connected true after Initialize succeeded
+func Connected() bool {
+	return store != nil
+}
+
+// IsDup true if err is a duplicate key error of any backend
+func IsDup(err error) bool {
+	return err == ErrDuplicate || mgo.IsDup(err)
+}
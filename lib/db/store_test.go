@@ -0,0 +1,274 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The embedded store is always tested, the other store tests run against a local database:
+//   AWE_TEST_MONGODB_HOST=localhost go test ./lib/db/
+//   AWE_TEST_POSTGRES_URL=postgres://awe@localhost/awe_test?sslmode=disable go test ./lib/db/
+// Both databases are emptied.
+
+type testTask struct {
+	TaskID string    `bson:"taskid"`
+	State  string    `bson:"state"`
+	Failed int       `bson:"failed"`
+	Size   int64     `bson:"size"`
+	Done   time.Time `bson:"done"`
+}
+
+type testJob struct {
+	ID       string            `bson:"id"`
+	State    string            `bson:"state"`
+	Priority int               `bson:"priority"`
+	Ratio    float64           `bson:"ratio"`
+	Info     map[string]string `bson:"info"`
+	Tasks    []testTask        `bson:"tasks"`
+}
+
+func testJobs() []interface{} {
+	return []interface{}{
+		&testJob{ID: "job1", State: "queued", Priority: 1, Ratio: 0.5, Info: map[string]string{"pipeline": "qc"}, Tasks: []testTask{{TaskID: "0", State: "completed", Size: 1 << 40}, {TaskID: "1", State: "queued"}}},
+		&testJob{ID: "job2", State: "completed", Priority: 5, Ratio: 2, Info: map[string]string{"pipeline": "assembly"}},
+		&testJob{ID: "job3", State: "suspend", Priority: 3, Info: map[string]string{"pipeline": "qc-long"}},
+	}
+}
+
+func testStore(t *testing.T, s Store) {
+	c := s.C("TestJobs")
+	if err := c.EnsureIndex([]string{"id"}, true); err != nil {
+		t.Fatalf("EnsureIndex: %s", err.Error())
+	}
+	if err := c.EnsureIndex([]string{"state"}, false); err != nil {
+		t.Fatalf("EnsureIndex: %s", err.Error())
+	}
+	if err := c.Insert(testJobs()...); err != nil {
+		t.Fatalf("Insert: %s", err.Error())
+	}
+	if err := c.Insert(&testJob{ID: "job1"}); !IsDup(err) {
+		t.Errorf("Insert of a duplicate id: expected a duplicate key error, got %v", err)
+	}
+
+	job := &testJob{}
+	if err := c.Find(bson.M{"id": "job1"}).One(job); err != nil {
+		t.Fatalf("One: %s", err.Error())
+	}
+	if job.Ratio != 0.5 || len(job.Tasks) != 2 || job.Tasks[0].Size != 1<<40 || job.Info["pipeline"] != "qc" {
+		t.Errorf("One: document changed: %+v", job)
+	}
+	if err := c.Find(bson.M{"id": "nope"}).One(job); err != ErrNotFound {
+		t.Errorf("One: expected ErrNotFound, got %v", err)
+	}
+
+	// queries
+	count, err := c.Find(bson.M{"$or": []bson.M{{"state": "queued"}, {"priority": bson.M{"$gte": 5}}}}).Count()
+	if err != nil || count != 2 {
+		t.Errorf("Count $or: expected 2, got %d (%v)", count, err)
+	}
+	count, _ = c.Find(bson.M{"state": bson.M{"$nin": []string{"completed", "deleted"}}, "info.pipeline": bson.M{"$regex": "^qc"}}).Count()
+	if count != 2 {
+		t.Errorf("Count $nin/$regex: expected 2, got %d", count)
+	}
+	jobs := []testJob{}
+	if err = c.Find(nil).Sort("-priority").Skip(1).Limit(1).All(&jobs); err != nil {
+		t.Fatalf("All: %s", err.Error())
+	}
+	if len(jobs) != 1 || jobs[0].ID != "job3" {
+		t.Errorf("Sort/Skip/Limit: expected job3, got %+v", jobs)
+	}
+	var pipelines []string
+	if err = c.Find(bson.M{"state": bson.M{"$ne": "completed"}}).Distinct("info.pipeline", &pipelines); err != nil || len(pipelines) != 2 {
+		t.Errorf("Distinct: expected 2 pipelines, got %v (%v)", pipelines, err)
+	}
+
+	// projections
+	result := bson.M{}
+	if err = c.Find(bson.M{"id": "job1"}).Select(bson.M{"tasks": bson.M{"$elemMatch": bson.M{"taskid": "1"}}}).One(&result); err != nil {
+		t.Fatalf("Select $elemMatch: %s", err.Error())
+	}
+	tasks, ok := result["tasks"].([]interface{})
+	if !ok || len(tasks) != 1 || tasks[0].(bson.M)["state"] != "queued" {
+		t.Errorf("Select $elemMatch: got %v", result)
+	}
+	if _, ok = result["state"]; ok {
+		t.Errorf("Select $elemMatch: state must not be returned")
+	}
+
+	// updates
+	done := time.Now().Truncate(time.Millisecond)
+	err = c.Update(bson.M{"id": "job1", "tasks.taskid": "1"}, bson.M{"$set": bson.M{"tasks.$.state": "completed", "tasks.$.done": done}, "$inc": bson.M{"tasks.$.failed": 2}})
+	if err != nil {
+		t.Fatalf("Update positional: %s", err.Error())
+	}
+	err = c.Update(bson.M{"id": "job1"}, bson.M{"$push": bson.M{"tasks": testTask{TaskID: "2", State: "init"}}, "$set": bson.M{"state": "in-progress"}})
+	if err != nil {
+		t.Fatalf("Update $push: %s", err.Error())
+	}
+	job = &testJob{}
+	c.Find(bson.M{"id": "job1"}).One(job)
+	if job.State != "in-progress" || len(job.Tasks) != 3 || job.Tasks[1].State != "completed" || job.Tasks[1].Failed != 2 || !job.Tasks[1].Done.Equal(done) || job.Tasks[0].State != "completed" {
+		t.Errorf("Update: got %+v", job)
+	}
+	if err = c.Update(bson.M{"id": "nope"}, bson.M{"$set": bson.M{"state": "x"}}); err != ErrNotFound {
+		t.Errorf("Update: expected ErrNotFound, got %v", err)
+	}
+	updated, err := c.UpdateAll(bson.M{"info.pipeline": bson.M{"$regex": "^qc"}}, bson.M{"$set": bson.M{"priority": 0}})
+	if err != nil || updated != 2 {
+		t.Errorf("UpdateAll: expected 2, got %d (%v)", updated, err)
+	}
+
+	// upserts, the selector of the leader lease
+	lease := s.C("TestState")
+	now := time.Now()
+	selector := func(holder string) bson.M {
+		return bson.M{"_id": "leader", "$or": []bson.M{{"holder": holder}, {"expires": bson.M{"$lt": now}}}}
+	}
+	if err = lease.Upsert(selector("a"), bson.M{"$set": bson.M{"holder": "a", "expires": now.Add(time.Minute)}}); err != nil {
+		t.Fatalf("Upsert: %s", err.Error())
+	}
+	if err = lease.Upsert(selector("a"), bson.M{"$set": bson.M{"holder": "a", "expires": now.Add(time.Hour)}}); err != nil {
+		t.Fatalf("Upsert renew: %s", err.Error())
+	}
+	if err = lease.Upsert(selector("b"), bson.M{"$set": bson.M{"holder": "b"}}); !IsDup(err) {
+		t.Errorf("Upsert of a held lease: expected a duplicate key error, got %v", err)
+	}
+	doc := bson.M{}
+	lease.Find(bson.M{"_id": "leader"}).One(&doc)
+	if doc["holder"] != "a" {
+		t.Errorf("Upsert: expected holder a, got %v", doc)
+	}
+
+	// replacement, removal
+	if err = c.Upsert(bson.M{"id": "job2"}, &testJob{ID: "job2", State: "deleted"}); err != nil {
+		t.Fatalf("Upsert replacement: %s", err.Error())
+	}
+	removed, err := c.RemoveAll(bson.M{"state": "deleted"})
+	if err != nil || removed != 1 {
+		t.Errorf("RemoveAll: expected 1, got %d (%v)", removed, err)
+	}
+	count, _ = c.Find(nil).Count()
+	if count != 2 {
+		t.Errorf("expected 2 jobs left, got %d", count)
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("AWE_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("AWE_TEST_POSTGRES_URL not set")
+	}
+	pg, err := openPostgres(url)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer pg.db.Close()
+	if err = pg.Drop(); err != nil {
+		t.Fatal(err.Error())
+	}
+	// the migrations are idempotent
+	if err = pg.migrate(); err != nil {
+		t.Fatal(err.Error())
+	}
+	testStore(t, pg)
+}
+
+func TestMongoStore(t *testing.T) {
+	host := os.Getenv("AWE_TEST_MONGODB_HOST")
+	if host == "" {
+		t.Skip("AWE_TEST_MONGODB_HOST not set")
+	}
+	conf.DB_BACKEND = BackendMongoDB
+	conf.MONGODB_HOST = host
+	conf.MONGODB_DATABASE = "AWETest"
+	if err := Initialize(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer Connection.Session.Close()
+	if err := Drop(); err != nil {
+		t.Fatal(err.Error())
+	}
+	testStore(t, store)
+}
+
+func TestEmbeddedStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-embedded-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "awe.db")
+	es, err := openEmbedded(path, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	testStore(t, es)
+
+	// the file is locked while it is open
+	if _, err = openEmbedded(path, 0); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("opening a locked store: expected a lock error, got %v", err)
+	}
+
+	// the documents are read on open
+	if err = es.close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	es, err = openEmbedded(path, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer es.close()
+	jobs := []testJob{}
+	if err = es.C("TestJobs").Find(nil).Sort("id").All(&jobs); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(jobs) != 2 || jobs[0].ID != "job1" || jobs[0].State != "in-progress" || len(jobs[0].Tasks) != 3 || jobs[1].Priority != 0 {
+		t.Errorf("reopened store: got %+v", jobs)
+	}
+	if err = es.C("TestJobs").EnsureIndex([]string{"id"}, true); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = es.C("TestJobs").Insert(&testJob{ID: "job3"}); !IsDup(err) {
+		t.Errorf("reopened store: expected a duplicate key error, got %v", err)
+	}
+}
+
+func TestEmbeddedStoreLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-embedded-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	es, err := openEmbedded(filepath.Join(dir, "awe.db"), 1000)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer es.close()
+	c := es.C("TestJobs")
+	if err = c.Insert(&testJob{ID: "job1", State: strings.Repeat("x", 500)}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = c.Insert(&testJob{ID: "job2", State: strings.Repeat("x", 500)}); err == nil {
+		t.Errorf("expected the store to be full")
+	}
+	// shrinking and removing documents is possible
+	if err = c.Update(bson.M{"id": "job1"}, bson.M{"$set": bson.M{"state": "done"}}); err != nil {
+		t.Error(err.Error())
+	}
+	if err = c.Insert(&testJob{ID: "job2", State: strings.Repeat("x", 500)}); err != nil {
+		t.Errorf("insert after shrinking: %s", err.Error())
+	}
+	if _, err = c.RemoveAll(bson.M{}); err != nil {
+		t.Error(err.Error())
+	}
+	if es.size != 0 {
+		t.Errorf("size of an empty store: %d", es.size)
+	}
+}
@@ -13,9 +13,15 @@ const (
 	ClientNotActive          = "Client not active"
 	ClientSuspended          = "Client suspended"
 	ClientNotSuspended       = "Client not suspended"
+	ClientDraining           = "Client draining"
+	ClientNotDraining        = "Client not draining"
 	ClientDeleted            = "Client deleted"
 	ClientBusy               = "Client busy"
+	InsufficientDiskSpace    = "Insufficient disk space"
 	ClientGroupBadName       = "Clientgroup name in token does not match that in the client."
+	ClientGroupIPDenied      = "Client address not allowed for clientgroup"
+	ClientCertRevoked        = "Client certificate revoked"
+	ClientCertRequired       = "Client certificate required"
 	InvalidFileTypeForFilter = "Invalid file type for filter"
 	InvalidIndex             = "Invalid Index"
 	InvalidAuth              = "Invalid Auth Header"
@@ -0,0 +1,215 @@
+package kube
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Clientset is the part of the Kubernetes API used by the kubernetes executor, the worker
+// tests use a fake implementation
+type Clientset interface {
+	CreateJob(namespace string, job *Job) (created *Job, err error)
+	GetJob(namespace string, name string) (job *Job, err error)
+	DeleteJob(namespace string, name string) (err error)
+	ListPods(namespace string, labelSelector string) (pods []Pod, err error)
+	CreateSecret(namespace string, secret *Secret) (err error)
+	DeleteSecret(namespace string, name string) (err error)
+}
+
+// APIError is returned for responses with an error status code
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("kubernetes API returned %d: %s", e.Code, e.Message)
+}
+
+// IsNotFound _
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Code == http.StatusNotFound
+}
+
+// RESTClient talks to the Kubernetes API server with a bearer token
+type RESTClient struct {
+	BaseURL string
+	Token   string
+	client  *http.Client
+}
+
+// NewRESTClient creates a client for the API server at baseURL; caFile may be empty to use the system CAs
+func NewRESTClient(baseURL string, token string, caFile string) (c *RESTClient, err error) {
+	transport := &http.Transport{}
+	if caFile != "" {
+		var pem []byte
+		pem, err = ioutil.ReadFile(caFile)
+		if err != nil {
+			err = fmt.Errorf("(NewRESTClient) could not read CA file: %s", err.Error())
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			err = fmt.Errorf("(NewRESTClient) no certificates found in %s", caFile)
+			return
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	c = &RESTClient{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   token,
+		client:  &http.Client{Transport: transport, Timeout: 60 * time.Second},
+	}
+	return
+}
+
+// NewInClusterClient uses the service account of the pod; apiURL overrides the address of the API server
+func NewInClusterClient(apiURL string) (c *RESTClient, err error) {
+	if apiURL == "" {
+		host := os.Getenv("KUBERNETES_SERVICE_HOST")
+		port := os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			err = fmt.Errorf("(NewInClusterClient) not running in a kubernetes cluster, KUBERNETES_SERVICE_HOST/PORT not set")
+			return
+		}
+		apiURL = "https://" + host + ":" + port
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		err = fmt.Errorf("(NewInClusterClient) could not read service account token: %s", err.Error())
+		return
+	}
+	return NewRESTClient(apiURL, strings.TrimSpace(string(token)), serviceAccountDir+"/ca.crt")
+}
+
+// InClusterNamespace returns the namespace of the pod, "default" outside of a cluster
+func InClusterNamespace() string {
+	namespace, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil || len(bytes.TrimSpace(namespace)) == 0 {
+		return "default"
+	}
+	return string(bytes.TrimSpace(namespace))
+}
+
+func (c *RESTClient) do(method string, path string, body interface{}, result interface{}) (err error) {
+	var reader io.Reader
+	if body != nil {
+		var b []byte
+		b, err = json.Marshal(body)
+		if err != nil {
+			return
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "application/json")
+	if method == "PATCH" {
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	} else if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		status := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = string(data)
+		}
+		err = &APIError{Code: resp.StatusCode, Message: status.Message}
+		return
+	}
+	if result != nil {
+		err = json.Unmarshal(data, result)
+	}
+	return
+}
+
+// CreateJob _
+func (c *RESTClient) CreateJob(namespace string, job *Job) (created *Job, err error) {
+	job.APIVersion = "batch/v1"
+	job.Kind = "Job"
+	created = &Job{}
+	err = c.do("POST", "/apis/batch/v1/namespaces/"+namespace+"/jobs", job, created)
+	return
+}
+
+// GetJob _
+func (c *RESTClient) GetJob(namespace string, name string) (job *Job, err error) {
+	job = &Job{}
+	err = c.do("GET", "/apis/batch/v1/namespaces/"+namespace+"/jobs/"+name, nil, job)
+	return
+}
+
+// DeleteJob deletes the job and its pods
+func (c *RESTClient) DeleteJob(namespace string, name string) (err error) {
+	return c.do("DELETE", "/apis/batch/v1/namespaces/"+namespace+"/jobs/"+name+"?propagationPolicy=Background", nil, nil)
+}
+
+// ListPods _
+func (c *RESTClient) ListPods(namespace string, labelSelector string) (pods []Pod, err error) {
+	list := PodList{}
+	err = c.do("GET", "/api/v1/namespaces/"+namespace+"/pods?labelSelector="+url.QueryEscape(labelSelector), nil, &list)
+	pods = list.Items
+	return
+}
+
+// CreateSecret _
+func (c *RESTClient) CreateSecret(namespace string, secret *Secret) (err error) {
+	secret.APIVersion = "v1"
+	secret.Kind = "Secret"
+	return c.do("POST", "/api/v1/namespaces/"+namespace+"/secrets", secret, nil)
+}
+
+// DeleteSecret _
+func (c *RESTClient) DeleteSecret(namespace string, name string) (err error) {
+	return c.do("DELETE", "/api/v1/namespaces/"+namespace+"/secrets/"+name, nil, nil)
+}
+
+// GetDeploymentScale _
+func (c *RESTClient) GetDeploymentScale(namespace string, name string) (scale *Scale, err error) {
+	scale = &Scale{}
+	err = c.do("GET", "/apis/apps/v1/namespaces/"+namespace+"/deployments/"+name+"/scale", nil, scale)
+	return
+}
+
+// UpdateDeploymentScale sets the number of replicas of a Deployment
+func (c *RESTClient) UpdateDeploymentScale(namespace string, name string, replicas int32) (err error) {
+	scale := &Scale{APIVersion: "autoscaling/v1", Kind: "Scale", Metadata: ObjectMeta{Name: name, Namespace: namespace}}
+	scale.Spec.Replicas = replicas
+	return c.do("PUT", "/apis/apps/v1/namespaces/"+namespace+"/deployments/"+name+"/scale", scale, nil)
+}
+
+// AnnotatePod adds annotations to a pod
+func (c *RESTClient) AnnotatePod(namespace string, name string, annotations map[string]string) (err error) {
+	patch := map[string]interface{}{"metadata": map[string]interface{}{"annotations": annotations}}
+	return c.do("PATCH", "/api/v1/namespaces/"+namespace+"/pods/"+name, patch, nil)
+}
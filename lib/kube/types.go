@@ -0,0 +1,219 @@
+package kube
+
+// The types below are the subset of the Kubernetes API objects (batch/v1 Job, v1 Pod, v1 Secret,
+// autoscaling/v1 Scale) used by the kubernetes executor of the worker and the autoscaler of the
+// server. Field names follow the Kubernetes API.
+
+// ObjectMeta _
+type ObjectMeta struct {
+	Name      string            `json:"name,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// Annotations e.g. controller.kubernetes.io/pod-deletion-cost
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Job _
+type Job struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       JobSpec    `json:"spec"`
+	Status     JobStatus  `json:"status,omitempty"`
+}
+
+// JobSpec _
+type JobSpec struct {
+	BackoffLimit            *int32          `json:"backoffLimit,omitempty"`
+	ActiveDeadlineSeconds   *int64          `json:"activeDeadlineSeconds,omitempty"`
+	TTLSecondsAfterFinished *int32          `json:"ttlSecondsAfterFinished,omitempty"`
+	Template                PodTemplateSpec `json:"template"`
+}
+
+// JobStatus _
+type JobStatus struct {
+	Active     int32          `json:"active,omitempty"`
+	Succeeded  int32          `json:"succeeded,omitempty"`
+	Failed     int32          `json:"failed,omitempty"`
+	Conditions []JobCondition `json:"conditions,omitempty"`
+}
+
+// JobCondition _
+type JobCondition struct {
+	Type    string `json:"type"` // Complete or Failed
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// PodTemplateSpec _
+type PodTemplateSpec struct {
+	Metadata ObjectMeta `json:"metadata,omitempty"`
+	Spec     PodSpec    `json:"spec"`
+}
+
+// PodSpec _
+type PodSpec struct {
+	RestartPolicy      string              `json:"restartPolicy,omitempty"`
+	ServiceAccountName string              `json:"serviceAccountName,omitempty"`
+	SecurityContext    *PodSecurityContext `json:"securityContext,omitempty"`
+	InitContainers     []Container         `json:"initContainers,omitempty"`
+	Containers         []Container         `json:"containers"`
+	Volumes            []Volume            `json:"volumes,omitempty"`
+}
+
+// PodSecurityContext _
+type PodSecurityContext struct {
+	RunAsUser  *int64 `json:"runAsUser,omitempty"`
+	RunAsGroup *int64 `json:"runAsGroup,omitempty"`
+}
+
+// Container _
+type Container struct {
+	Name         string               `json:"name"`
+	Image        string               `json:"image"`
+	Command      []string             `json:"command,omitempty"`
+	Args         []string             `json:"args,omitempty"`
+	WorkingDir   string               `json:"workingDir,omitempty"`
+	Env          []EnvVar             `json:"env,omitempty"`
+	EnvFrom      []EnvFromSource      `json:"envFrom,omitempty"`
+	Resources    ResourceRequirements `json:"resources,omitempty"`
+	VolumeMounts []VolumeMount        `json:"volumeMounts,omitempty"`
+	// init containers may need root to prepare the work directory
+	SecurityContext *SecurityContext `json:"securityContext,omitempty"`
+}
+
+// SecurityContext _
+type SecurityContext struct {
+	RunAsUser *int64 `json:"runAsUser,omitempty"`
+}
+
+// EnvVar _
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// EnvFromSource _
+type EnvFromSource struct {
+	SecretRef *LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+// LocalObjectReference _
+type LocalObjectReference struct {
+	Name string `json:"name"`
+}
+
+// ResourceRequirements quantities, e.g. "cpu": "2", "memory": "4096Mi"
+type ResourceRequirements struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+// VolumeMount _
+type VolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	SubPath   string `json:"subPath,omitempty"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// Volume _
+type Volume struct {
+	Name                  string                             `json:"name"`
+	PersistentVolumeClaim *PersistentVolumeClaimVolumeSource `json:"persistentVolumeClaim,omitempty"`
+}
+
+// PersistentVolumeClaimVolumeSource _
+type PersistentVolumeClaimVolumeSource struct {
+	ClaimName string `json:"claimName"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// Pod _
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Status   PodStatus  `json:"status,omitempty"`
+}
+
+// PodList _
+type PodList struct {
+	Items []Pod `json:"items"`
+}
+
+// Pod phases
+const (
+	PodPending   = "Pending"
+	PodRunning   = "Running"
+	PodSucceeded = "Succeeded"
+	PodFailed    = "Failed"
+	PodUnknown   = "Unknown"
+)
+
+// PodStatus _
+type PodStatus struct {
+	Phase                 string            `json:"phase,omitempty"`
+	Reason                string            `json:"reason,omitempty"`
+	Message               string            `json:"message,omitempty"`
+	InitContainerStatuses []ContainerStatus `json:"initContainerStatuses,omitempty"`
+	ContainerStatuses     []ContainerStatus `json:"containerStatuses,omitempty"`
+}
+
+// ContainerStatus _
+type ContainerStatus struct {
+	Name  string         `json:"name"`
+	State ContainerState `json:"state,omitempty"`
+}
+
+// ContainerState only one of the states is set
+type ContainerState struct {
+	Waiting    *ContainerStateWaiting    `json:"waiting,omitempty"`
+	Running    *ContainerStateRunning    `json:"running,omitempty"`
+	Terminated *ContainerStateTerminated `json:"terminated,omitempty"`
+}
+
+// ContainerStateWaiting _
+type ContainerStateWaiting struct {
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ContainerStateRunning _
+type ContainerStateRunning struct {
+	StartedAt string `json:"startedAt,omitempty"`
+}
+
+// ContainerStateTerminated _
+type ContainerStateTerminated struct {
+	ExitCode int32  `json:"exitCode"`
+	Reason   string `json:"reason,omitempty"` // e.g. OOMKilled, Error, Completed
+	Message  string `json:"message,omitempty"`
+}
+
+// Secret _
+type Secret struct {
+	APIVersion string            `json:"apiVersion,omitempty"`
+	Kind       string            `json:"kind,omitempty"`
+	Metadata   ObjectMeta        `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	StringData map[string]string `json:"stringData,omitempty"`
+}
+
+// Scale subresource of a Deployment
+type Scale struct {
+	APIVersion string      `json:"apiVersion,omitempty"`
+	Kind       string      `json:"kind,omitempty"`
+	Metadata   ObjectMeta  `json:"metadata"`
+	Spec       ScaleSpec   `json:"spec"`
+	Status     ScaleStatus `json:"status,omitempty"`
+}
+
+// ScaleSpec _
+type ScaleSpec struct {
+	Replicas int32 `json:"replicas"`
+}
+
+// ScaleStatus _
+type ScaleStatus struct {
+	Replicas int32 `json:"replicas"`
+}
@@ -0,0 +1,167 @@
+// Package localstore a data store in a local directory, used instead of Shock by a standalone server.
+// The store is addressed like a Shock server, by a url: file:///var/lib/awe/data. A node is the file
+// <directory>/node/<id>, so that the Shock download url of a node (<url>/node/<id>?download) is
+// also a valid file url. Server and workers have to share the directory.
+package localstore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MG-RAST/golib/go-uuid/uuid"
+)
+
+// Scheme of local store urls
+const Scheme = "file://"
+
+// IsURL true if the Shock host or location refers to a local store
+func IsURL(u string) bool {
+	return strings.HasPrefix(u, Scheme)
+}
+
+// URL returns the url of the store in directory dir
+func URL(dir string) (u string, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	u = (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}).String()
+	return
+}
+
+// filePath the local path of a store url or location, the query string is ignored
+func filePath(u string) (p string, err error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		err = fmt.Errorf("(filePath) url.Parse returned: %s", err.Error())
+		return
+	}
+	if parsed.Scheme != "file" || parsed.Path == "" {
+		err = fmt.Errorf("(filePath) not a local store url: %s", u)
+		return
+	}
+	p = filepath.FromSlash(parsed.Path)
+	return
+}
+
+// Stored true if location is a node of the store at host
+func Stored(host string, location string) bool {
+	if !IsURL(host) || !IsURL(location) {
+		return false
+	}
+	dir, err := filePath(host)
+	if err != nil {
+		return false
+	}
+	p, err := filePath(location)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(p, filepath.Join(dir, "node")+string(filepath.Separator))
+}
+
+// PostFile copies the file at sourcePath, or contents if sourcePath is empty, into the store
+func PostFile(host string, sourcePath string, contents string) (node string, err error) {
+	dir, err := filePath(host)
+	if err != nil {
+		return
+	}
+	nodeDir := filepath.Join(dir, "node")
+	err = os.MkdirAll(nodeDir, 0777)
+	if err != nil {
+		err = fmt.Errorf("(PostFile) os.MkdirAll returned: %s", err.Error())
+		return
+	}
+
+	var source io.Reader = strings.NewReader(contents)
+	if sourcePath != "" {
+		var f *os.File
+		f, err = os.Open(sourcePath)
+		if err != nil {
+			err = fmt.Errorf("(PostFile) os.Open returned: %s", err.Error())
+			return
+		}
+		defer f.Close()
+		source = f
+	}
+
+	// write under a temporary name, a node is complete once it exists
+	tmp, err := ioutil.TempFile(nodeDir, ".upload_")
+	if err != nil {
+		err = fmt.Errorf("(PostFile) ioutil.TempFile returned: %s", err.Error())
+		return
+	}
+	_, err = io.Copy(tmp, source)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		err = fmt.Errorf("(PostFile) writing %s returned: %s", tmp.Name(), err.Error())
+		return
+	}
+
+	id := uuid.New()
+	err = os.Rename(tmp.Name(), filepath.Join(nodeDir, id))
+	if err != nil {
+		os.Remove(tmp.Name())
+		err = fmt.Errorf("(PostFile) os.Rename returned: %s", err.Error())
+		return
+	}
+	node = id
+	return
+}
+
+// FetchFile copies the node at location (<url>/node/<id>?download) to target
+func FetchFile(target string, location string) (size int64, err error) {
+	source, err := filePath(location)
+	if err != nil {
+		return
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		err = fmt.Errorf("(FetchFile) os.Open returned: %s", err.Error())
+		return
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		err = fmt.Errorf("(FetchFile) os.Create returned: %s", err.Error())
+		return
+	}
+	size, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("(FetchFile) copying %s returned: %s", source, err.Error())
+	}
+	return
+}
+
+// Delete removes a node, a missing node is not an error
+func Delete(host string, node string) (err error) {
+	dir, err := filePath(host)
+	if err != nil {
+		return
+	}
+	if node == "" || strings.ContainsAny(node, `/\`) || strings.HasPrefix(node, ".") {
+		err = fmt.Errorf("(Delete) invalid node id %s", node)
+		return
+	}
+	err = os.Remove(filepath.Join(dir, "node", node))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
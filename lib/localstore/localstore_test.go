@@ -0,0 +1,52 @@
+package localstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-localstore-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	host, err := URL(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	node, err := PostFile(host, "", "hello")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	location := host + "/node/" + node + "?download"
+	if !Stored(host, location) {
+		t.Errorf("%s is not recognized as node of %s", location, host)
+	}
+	if Stored(host, "file://"+filepath.Join(dir, "input.txt")) {
+		t.Errorf("a local file outside of the store is recognized as node")
+	}
+
+	target := filepath.Join(dir, "fetched.txt")
+	size, err := FetchFile(target, location)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, _ := ioutil.ReadFile(target)
+	if size != 5 || string(data) != "hello" {
+		t.Errorf("fetched %d bytes: %q", size, data)
+	}
+
+	if err = Delete(host, node); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = FetchFile(target, location); err == nil {
+		t.Errorf("the deleted node can still be fetched")
+	}
+	if err = Delete(host, "../store"); err == nil {
+		t.Errorf("expected an error for an invalid node id")
+	}
+}
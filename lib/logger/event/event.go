@@ -6,6 +6,7 @@ const (
 	CLIENT_REGISTRATION = "CR" //client registered (for the first time)
 	CLIENT_AUTO_REREGI  = "CA" //client automatically re-registered
 	CLIENT_UNREGISTER   = "CU" //client unregistered
+	CLIENT_DRAIN        = "CD" //client draining, gets no new work
 	WORK_CHECKOUT       = "WC" //workunit checkout
 	WORK_FAIL           = "WF" //workunit fails running
 	WORK_FAILED         = "W!" //workunit fails running (not recoverable)
@@ -21,6 +22,7 @@ const (
 	WORK_DONE            = "WD" //workunit received successful feedback from client
 	WORK_REQUEUE         = "WR" //workunit requeue after receive failed feedback from client
 	WORK_SUSPEND         = "WP" //workunit suspend after failing for conf.Max_Failure times
+	WORK_PREEMPT         = "WX" //workunit requeued to make room for higher priority work
 	TASK_DONE            = "TD" //task done (all the workunits in the task have finished)
 	TASK_SKIPPED         = "TS" //task skipped (skip option > 0)
 	JOB_DONE             = "JD" //job done (all the tasks in the job have finished)
@@ -29,6 +31,10 @@ const (
 	JOB_EXPIRED          = "JE" //job expired
 	JOB_FULL_DELETE      = "JR" //job removed form mongodb (deleted fully)
 	JOB_FAILED_PERMANENT = "JF" //job failed permanently
+	JOB_ARCHIVED         = "JA" //job moved to the archive
+	JOB_RESTORED         = "JX" //job restored from the archive
+	JOB_DEADLINE_RISK    = "JW" //job predicted to miss its deadline
+	JOB_DEADLINE_MISSED  = "JM" //job missed its deadline
 	//client only events
 	WORK_START     = "WS" //workunit command start running
 	WORK_END       = "WE" //workunit command finish running
@@ -53,6 +59,7 @@ var EventDiscription = map[string]map[string]string{
 		"CR": "client registered (for the first time)",
 		"CA": "client automatically re-registered",
 		"CU": "client unregistered",
+		"CD": "client draining, gets no new work",
 		"WC": "workunit checkout",
 		"WF": "workunit fails running",
 		"W!": "workunit failed running (not recoverable)",
@@ -69,6 +76,7 @@ var EventDiscription = map[string]map[string]string{
 		"WD": "workunit received successful feedback from client",
 		"WR": "workunit requeue after receive failed feedback from client",
 		"WP": "workunit suspend after failing for conf.Max_Failure times",
+		"WX": "workunit requeued to make room for higher priority work",
 		"TD": "task done (all the workunits in the task have finished)",
 		"TS": "task skipped (skip option > 0)",
 		"JD": "job done (all the tasks in the job have finished)",
@@ -76,6 +84,10 @@ var EventDiscription = map[string]map[string]string{
 		"JL": "job deleted",
 		"JE": "job expired",
 		"JR": "job removed form mongodb (deleted fully)",
+		"JA": "job moved to the archive",
+		"JX": "job restored from the archive",
+		"JW": "job predicted to miss its deadline",
+		"JM": "job missed its deadline",
 		"JF": "job failed permanently",
 	},
 	"client": map[string]string{
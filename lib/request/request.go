@@ -1,17 +1,24 @@
 package request
 
 import (
+	"context"
 	"errors"
 	"github.com/MG-RAST/AWE/lib/auth"
+	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/user"
 	"github.com/MG-RAST/golib/goweb"
+	"net"
 	"net/http"
+	"strings"
 )
 
 func Authenticate(req *http.Request) (u *user.User, err error) {
+	if result, ok := req.Context().Value(authResultKey{}).(*authResult); ok && !result.clientGroup {
+		return result.u, result.err
+	}
 	if _, ok := req.Header["Authorization"]; !ok {
 		err = errors.New(e.NoAuth)
 		return
@@ -21,16 +28,100 @@ func Authenticate(req *http.Request) (u *user.User, err error) {
 	return
 }
 
+type authResultKey struct{}
+
+type authResult struct {
+	u           *user.User
+	cg          *core.ClientGroup
+	clientGroup bool // the result of AuthenticateClientGroup, not of Authenticate
+	err         error
+}
+
+// WithAuthentication authenticates the user once, Authenticate on the returned request reuses the result
+func WithAuthentication(req *http.Request) (authenticated *http.Request, u *user.User, err error) {
+	u, err = Authenticate(req)
+	authenticated = req.WithContext(context.WithValue(req.Context(), authResultKey{}, &authResult{u: u, err: err}))
+	return
+}
+
+// WithClientGroupAuthentication like WithAuthentication for AuthenticateClientGroup
+func WithClientGroupAuthentication(req *http.Request) (authenticated *http.Request, cg *core.ClientGroup, err error) {
+	cg, err = AuthenticateClientGroup(req)
+	authenticated = req.WithContext(context.WithValue(req.Context(), authResultKey{}, &authResult{cg: cg, clientGroup: true, err: err}))
+	return
+}
+
+// AuthenticateClientGroup uses the verified worker certificate (mutual TLS) if there is one,
+// the clientgroup token otherwise. The client address has to match the IPCidr of the clientgroup.
 func AuthenticateClientGroup(req *http.Request) (cg *core.ClientGroup, err error) {
-	if _, ok := req.Header["Authorization"]; !ok {
-		err = errors.New(e.NoAuth)
+	if result, ok := req.Context().Value(authResultKey{}).(*authResult); ok && result.clientGroup {
+		return result.cg, result.err
+	}
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		cg, err = auth.AuthenticateClientGroupCertificate(req.TLS)
+	} else {
+		if conf.SSL_CLIENT_CERT_REQUIRED {
+			err = errors.New(e.ClientCertRequired)
+			return
+		}
+		if _, ok := req.Header["Authorization"]; !ok {
+			err = errors.New(e.NoAuth)
+			return
+		}
+		header := req.Header.Get("Authorization")
+		cg, err = auth.AuthenticateClientGroup(header)
+	}
+	if err != nil {
 		return
 	}
-	header := req.Header.Get("Authorization")
-	cg, err = auth.AuthenticateClientGroup(header)
+
+	address := ClientAddress(req)
+	allowed, err := cg.AllowsAddress(address)
+	if err != nil {
+		return
+	}
+	if !allowed {
+		logger.Error("(AuthenticateClientGroup) address %s not allowed for clientgroup %s (ip_cidr=%s)", address, cg.Name, cg.IPCidr)
+		cg = nil
+		err = errors.New(e.ClientGroupIPDenied)
+	}
 	return
 }
 
+// ClientAddress the address of the client of a request. Behind the reverse proxies of [External]
+// trusted_proxies it is the last address in client_ip_header that is not one of the proxies.
+func ClientAddress(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	address := net.ParseIP(host)
+	if conf.TRUSTED_PROXIES == "" || conf.CLIENT_IP_HEADER == "" {
+		return address
+	}
+	trusted, err := core.AddressInCIDRs(address, conf.TRUSTED_PROXIES)
+	if err != nil {
+		logger.Error("(ClientAddress) invalid trusted_proxies: %s", err.Error())
+		return address
+	}
+	if !trusted {
+		return address
+	}
+	// each proxy appends the address it received the request from
+	forwarded := strings.Split(strings.Join(req.Header[http.CanonicalHeaderKey(conf.CLIENT_IP_HEADER)], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		address = ip
+		if trusted, _ = core.AddressInCIDRs(ip, conf.TRUSTED_PROXIES); !trusted {
+			break
+		}
+	}
+	return address
+}
+
 func RetrieveToken(req *http.Request) (token string, err error) {
 	if _, ok := req.Header["Datatoken"]; !ok {
 		err = errors.New("no token received")
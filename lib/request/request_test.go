@@ -0,0 +1,44 @@
+package request
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+func TestClientAddress(t *testing.T) {
+	if logger.Log == nil {
+		logger.Initialize("server")
+	}
+	oldProxies, oldHeader := conf.TRUSTED_PROXIES, conf.CLIENT_IP_HEADER
+	defer func() { conf.TRUSTED_PROXIES, conf.CLIENT_IP_HEADER = oldProxies, oldHeader }()
+
+	tests := []struct {
+		proxies   string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"", "10.0.0.1:1234", []string{"1.2.3.4"}, "10.0.0.1"},
+		{"10.0.0.0/8", "192.168.1.1:1234", []string{"1.2.3.4"}, "192.168.1.1"},
+		{"10.0.0.0/8", "10.0.0.1:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"10.0.0.0/8", "10.0.0.1:1234", []string{"6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"10.0.0.0/8", "10.0.0.1:1234", []string{"6.6.6.6", "1.2.3.4"}, "1.2.3.4"},
+		{"10.0.0.0/8", "10.0.0.1:1234", []string{"unknown, 10.0.0.2"}, "10.0.0.2"},
+		{"10.0.0.0/8", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"invalid", "10.0.0.1:1234", []string{"1.2.3.4"}, "10.0.0.1"},
+	}
+	conf.CLIENT_IP_HEADER = "X-Forwarded-For"
+	for i, test := range tests {
+		conf.TRUSTED_PROXIES = test.proxies
+		req := &http.Request{RemoteAddr: test.remote, Header: http.Header{}}
+		for _, value := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if got := ClientAddress(req); got.String() != test.want {
+			t.Errorf("%d: got %s, want %s", i, got, test.want)
+		}
+	}
+}
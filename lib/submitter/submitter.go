@@ -0,0 +1,597 @@
+// Package submitter submits CWL workflows to an AWE server: the input files are uploaded to Shock
+// (or a local data store), the workflow is normalized and submitted, and the results are downloaded.
+package submitter
+
+import (
+	"context"
+	//"encoding/json"
+	"fmt"
+
+	"github.com/MG-RAST/AWE/lib/client"
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/logger"
+	shock "github.com/MG-RAST/go-shock-client"
+
+	//"github.com/MG-RAST/AWE/lib/logger/event"
+
+	"encoding/json"
+
+	"github.com/MG-RAST/AWE/lib/cache"
+	//"github.com/davecgh/go-spew/spew"
+
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Run submits workflowArg (the workflow file, optionally with #entrypoint) with the job input file
+// (may be empty) and, with conf.SUBMITTER_WAIT, waits for the results
+func Run(workflowArg string, jobFile string, aweAuth string, shockAuth string) (err error) {
+
+	var workflowTemporaryFile string
+	var entrypoint string
+
+	args0Array := strings.Split(workflowArg, "#")
+	workflowFile := args0Array[0]
+	if len(args0Array) > 1 {
+		entrypoint = "#" + args0Array[1]
+	}
+	logger.Debug(3, "(Run) A entrypoint: %s", entrypoint)
+	//fmt.Printf("---------- A\n")
+
+	context := cwl.NewWorkflowContext()
+	context.Init(entrypoint)
+
+	var jobData []byte
+	var newEntrypoint string // name of Workflow in single document for example
+	workflowTemporaryFile, jobData, newEntrypoint, err = createNormalizedSubmisson(aweAuth, shockAuth, workflowFile, jobFile, entrypoint, context)
+	if err != nil {
+		err = fmt.Errorf("(mainWrapper) createNormalizedSubmisson returned: %s", err.Error())
+		return
+	}
+
+	if newEntrypoint != "" {
+		entrypoint = newEntrypoint
+	}
+	logger.Debug(3, "(Run) B entrypoint: %s", entrypoint)
+	//fmt.Printf("---------- B\n")
+	// ### Submit job to AWE
+	var jobid string
+
+	// single tools are wrapped into a workflow, thus returns new entrypoint
+	jobid, newEntrypoint, err = SubmitCWLJobToAWE(workflowTemporaryFile, jobFile, entrypoint, &jobData, aweAuth, shockAuth)
+	if err != nil {
+		err = fmt.Errorf("(Run) SubmitCWLJobToAWE returned: %s", err.Error())
+		return
+	}
+
+	if newEntrypoint != "" {
+		entrypoint = newEntrypoint
+	}
+	logger.Debug(3, "(Run) C entrypoint: %s", entrypoint)
+	// if strings.HasPrefix(entrypoint, "#") {
+	// 	entrypoint = strings.TrimPrefix(entrypoint, "#")
+	// }
+	//fmt.Printf("---------- C\n")
+	//fmt.Printf("Job id: %s\n", jobid)
+
+	if conf.SUBMITTER_WAIT {
+		err = WaitForResults(jobid, entrypoint, aweAuth, context)
+		if err != nil {
+			err = fmt.Errorf("(Run) Wait_for_results returned: %s", err.Error())
+			return
+		}
+	} else {
+		fmt.Printf("JobID=%s\n", jobid)
+	}
+
+	return
+}
+
+func createNormalizedSubmisson(aweAuth string, shockAuth string, workflowFile string, jobFile string, entrypoint string, context *cwl.WorkflowContext) (workflowTemporaryFile string, jobData []byte, newEntrypoint string, err error) {
+
+	//workflowFile := conf.ARGS[0]
+
+	if workflowFile == "" {
+		err = fmt.Errorf("(createNormalizedSubmisson) workflowFile empty")
+		return
+	}
+
+	inputfilePath := ""
+	//workflowFileBase := path.Base(workflowFile)
+	//fmt.Printf("job path: %s\n", inputfile_path) // needed to resolve relative paths
+	//fmt.Printf("createNormalizedSubmisson A\n")
+	// ### parse job file
+	var jobDoc *cwl.Job_document
+
+	if jobFile != "" {
+
+		inputfilePath = path.Dir(jobFile)
+		//inputFileBase = path.Base(jobFile)
+		jobDoc, err = cwl.ParseJobFile(jobFile)
+		if err != nil {
+			err = fmt.Errorf("error parsing cwl job: %s", err.Error())
+			return
+		}
+	} else {
+		jobDoc = &cwl.Job_document{}
+		inputfilePath = path.Dir(workflowFile)
+
+	}
+	//fmt.Println("Job input after reading from file:")
+	//spew.Dump(*job_doc)
+
+	var jobDocMap cwl.JobDocMap
+	//fmt.Printf("createNormalizedSubmisson B\n")
+	//job_doc_map["test"] = cwl.NewNull()
+
+	// if conf.DEBUG_LEVEL >= 3 {
+	// 	fmt.Println("Job input after reading from file: map !!!!\n")
+	// 	spew.Dump(jobDocMap)
+	// }
+	//var jobData []byte
+
+	if conf.DEBUG_LEVEL >= 3 {
+		fmt.Printf("jobFile: \"%s\"\n", jobFile)
+
+		jobDocMap = jobDoc.GetMap()
+		jobData, err = yaml.Marshal(jobDocMap)
+		if err != nil {
+			return
+		}
+
+		jobDocString := string(jobData[:])
+		fmt.Printf("job_doc_string:\n \"%s\"\n", jobDocString)
+
+		fmt.Printf("yaml:\n%s\n", jobDocString)
+	}
+
+	// ### upload input files
+
+	shockClient := shock.NewShockClient(conf.SHOCK_URL, shockAuth, false)
+	//fmt.Printf("createNormalizedSubmisson C\n")
+	uploadCount := 0
+
+	if jobFile != "" {
+		uploadCount, err = cache.ProcessIOData(jobDoc, inputfilePath, inputfilePath, "upload", shockClient, context, true, false)
+		if err != nil {
+			err = fmt.Errorf("(createNormalizedSubmisson) A) ProcessIOData(for upload) returned: %s", err.Error())
+			return
+		}
+	}
+	logger.Debug(3, "%d files have been uploaded\n", uploadCount)
+	//time.Sleep(2)
+
+	//spew.Dump(*job_doc)
+	jobDocMap = jobDoc.GetMap()
+	//fmt.Println("------------Job input after parsing:")
+	jobData, err = yaml.Marshal(jobDocMap)
+	if err != nil {
+		return
+	}
+	//fmt.Printf("createNormalizedSubmisson D\n")
+	if conf.DEBUG_LEVEL >= 3 {
+		fmt.Printf("job input as yaml:\n%s\n", string(jobData[:]))
+	}
+	var yamlstream []byte
+	// read and pack workfow
+	if conf.SUBMITTER_PACK {
+
+		yamlstream, err = exec.Command("cwltool", "--pack", workflowFile).Output()
+		if err != nil {
+			err = fmt.Errorf("(createNormalizedSubmisson) exec.Command returned: %s (%s %s %s)", err.Error(), "cwltool", "--pack", workflowFile)
+			return
+		}
+
+	} else {
+
+		yamlstream, err = ioutil.ReadFile(workflowFile)
+		if err != nil {
+			err = fmt.Errorf("(createNormalizedSubmisson) error in reading workflow file %s: %s", workflowFile, err.Error())
+			return
+		}
+	}
+	// ### PARSE (maybe PACKED) WORKFLOW DOCUMENT, in case default files have to be uploaded
+
+	// convert CWL to string
+	yamlStr := string(yamlstream[:])
+	//fmt.Printf("after cwltool --pack: \n%s\n", yaml_str)
+	var namedObjectArray []cwl.NamedCWLObject
+	//var cwl_version cwl.CWLVersion
+	var schemata []cwl.CWLType_Type
+	//var namespaces map[string]string
+	//var schemas []interface{}
+	//var context *cwl.WorkflowContext
+	//fmt.Printf("createNormalizedSubmisson E\n")
+	//var newEntrypoint string
+	namedObjectArray, schemata, context, _, newEntrypoint, err = cwl.ParseCWLDocument(nil, yamlStr, entrypoint, inputfilePath, "#"+workflowFile)
+
+	// if newEntrypoint != "" {
+	// 	entrypoint = newEntrypoint
+	// }
+
+	//fmt.Printf("createNormalizedSubmisson F\n")
+	if err != nil {
+		if conf.SUBMITTER_PACK {
+			err = fmt.Errorf("(createNormalizedSubmisson) error in parsing output of \"cwltool --pack %s\" (%s)", workflowFile, err.Error())
+		} else {
+			err = fmt.Errorf("(createNormalizedSubmisson) error in parsing file %s: %s", workflowFile, err.Error())
+		}
+		return
+	}
+
+	_ = schemata // TODO put into a collection!
+
+	// A) search for File objects in Document, e.g. in CommandLineTools
+
+	subUploadCount := 0
+	subUploadCount, err = cache.ProcessIOData(namedObjectArray, inputfilePath, inputfilePath, "upload", shockClient, context, true, false)
+	if err != nil {
+		err = fmt.Errorf("(createNormalizedSubmisson) B) ProcessIOData(for upload) returned: %s", err.Error())
+		return
+	}
+	uploadCount += subUploadCount
+	//fmt.Printf("createNormalizedSubmisson G\n")
+	if context.Schemas != nil {
+		subUploadCount := 0
+		subUploadCount, err = cache.ProcessIOData(context.Schemas, inputfilePath, inputfilePath, "upload", shockClient, context, true, false)
+		if err != nil {
+			err = fmt.Errorf("(createNormalizedSubmisson) C) ProcessIOData(for upload) returned: %s", err.Error())
+			return
+		}
+		uploadCount += subUploadCount
+
+	}
+
+	logger.Debug(3, "%d files have been uploaded\n", uploadCount)
+
+	var shockRequirement cwl.ShockRequirement
+	var shockRequirementPtr *cwl.ShockRequirement
+	shockRequirementPtr, err = cwl.NewShockRequirement(shockClient.Host)
+	if err != nil {
+		err = fmt.Errorf("(createNormalizedSubmisson) NewShockRequirement returned: %s", err.Error())
+		return
+	}
+
+	shockRequirement = *shockRequirementPtr
+
+	// B) inject ShockRequirement into CommandLineTools, ExpressionTools and Workflow
+	for j := range namedObjectArray {
+
+		pair := namedObjectArray[j]
+		object := pair.Value
+
+		var ok bool
+
+		switch object.(type) {
+		case *cwl.Workflow:
+			workflow := object.(*cwl.Workflow)
+
+			workflow.Requirements, err = cwl.AddRequirement(&shockRequirement, workflow.Requirements)
+			if err != nil {
+				err = fmt.Errorf("(createNormalizedSubmisson) AddRequirement returned: %s", err.Error())
+				return
+			}
+
+		case *cwl.CommandLineTool:
+			var cmdLineTool *cwl.CommandLineTool
+			cmdLineTool, ok = object.(*cwl.CommandLineTool) // TODO this misses embedded CommandLineTools !
+			if !ok {
+				//fmt.Println("nope.")
+				err = nil
+				continue
+			}
+
+			cmdLineTool.Requirements, err = cwl.AddRequirement(&shockRequirement, cmdLineTool.Requirements)
+			if err != nil {
+				err = fmt.Errorf("(createNormalizedSubmisson) AddRequirement returned: %s", err.Error())
+			}
+
+		case *cwl.ExpressionTool:
+			var expressTool *cwl.ExpressionTool
+			expressTool, ok = object.(*cwl.ExpressionTool) // TODO this misses embedded ExpressionTools !
+			if !ok {
+				//fmt.Println("nope.")
+				err = nil
+				continue
+			}
+
+			if expressTool == nil {
+				err = fmt.Errorf("(createNormalizedSubmisson) express_tool==nil")
+				return
+			}
+
+			expressTool.Requirements, err = cwl.AddRequirement(&shockRequirement, expressTool.Requirements)
+			if err != nil {
+				err = fmt.Errorf("(createNormalizedSubmisson) AddRequirement returned: %s", err.Error())
+			}
+
+		}
+	}
+
+	// create temporary workflow document file
+
+	newDocument := context.GraphDocument
+
+	//new_document := cwl.GraphDocument{}
+	//new_document.CwlVersion = context.CwlVersion
+	//new_document.Namespaces = namespaces
+	//new_document.Schemas = schemas
+
+	// replace graph
+	newDocument.Graph = []interface{}{}
+	//for i := range namedObjectArray {
+	for i := range context.Objects {
+		//object := cwl.NamedCWLObject{}
+		//pair.Value =
+		object := context.Objects[i]
+		//object := pair.Value
+		newDocument.Graph = append(newDocument.Graph, object)
+	}
+
+	if len(newDocument.Graph) == 0 {
+		err = fmt.Errorf("(createNormalizedSubmisson) len(new_document.Graph) == 0")
+		return
+	}
+
+	var newDocumentBytes []byte
+	newDocumentBytes, err = yaml.Marshal(newDocument)
+	if err != nil {
+		err = fmt.Errorf("(createNormalizedSubmisson) yaml.Marshal returned: %s", err.Error())
+		return
+	}
+	newDocumentStr := string(newDocumentBytes[:])
+	//graph_pos := strings.Index(new_document_str, "\ngraph:")
+
+	//new_document_str = strings.Replace(new_document_str, "\nnamespaces", "\n$namespaces", -1) // remove dollar sign
+
+	//if graph_pos != -1 {
+	//new_document_str = strings.Replace(new_document_str, "\ngraph", "\n$graph", -1) // remove dollar sign
+	//} else {
+
+	//	err = fmt.Errorf("(main_wrapper) keyword graph not found")
+	//	return
+	//}
+
+	if conf.DEBUG_LEVEL >= 3 {
+		fmt.Println("------------ new_document_str:")
+		fmt.Println(newDocumentStr)
+		fmt.Println("------------")
+		//panic("hhhh")
+	}
+	newDocumentBytes = []byte(newDocumentStr)
+
+	// ### Write workflow to file, so we can run "cwltool --pack""
+	var tmpfile *os.File
+	tmpfile, err = ioutil.TempFile(os.TempDir(), "awe-submitter_")
+	if err != nil {
+		err = fmt.Errorf("(main_wrapper) ioutil.TempFile returned: %s", err.Error())
+		return
+	}
+	workflowTemporaryFile = tmpfile.Name()
+	//defer os.Remove(tempfile_name)
+
+	_, err = tmpfile.Write(newDocumentBytes)
+	if err != nil {
+		err = fmt.Errorf("(main_wrapper) tmpfile.Write returned: %s", err.Error())
+		return
+	}
+
+	err = tmpfile.Close()
+	if err != nil {
+		err = fmt.Errorf("(main_wrapper) tmpfile.Close returned: %s", err.Error())
+		return
+	}
+
+	// job submission example:
+	// curl -X POST -F job=@test.yaml -F cwl=@/Users/wolfganggerlach/awe_data/pipeline/CWL/PackedWorkflow/preprocess-fasta.workflow.cwl http://localhost:8001/job
+
+	//var b bytes.Buffer
+	//w := multipart.NewWriter(&b)
+
+	return
+}
+
+// WaitForResults _
+func WaitForResults(jobid string, entrypoint string, aweAuth string, context *cwl.WorkflowContext) (err error) {
+
+	var job *core.Job
+	var statusCode int
+	// ***** Wait for job to complete
+
+FORLOOP:
+	for true {
+		time.Sleep(5 * time.Second)
+		job = nil
+
+		job, statusCode, err = GetAWEJob(jobid, aweAuth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "(Wait_for_results) GetAWEJob returned: (status_code: %d) error: %s  \n", statusCode, err.Error())
+			if statusCode == -1 {
+				err = nil
+				continue
+			}
+			return
+		}
+
+		//fmt.Printf("job state: %s\n", job.State)
+
+		switch job.State {
+		case core.JOB_STAT_COMPLETED:
+			logger.Debug(1, "(Wait_for_results) job state: %s", core.JOB_STAT_COMPLETED)
+
+			//root = job.Root
+
+			break FORLOOP
+		case core.JOB_STAT_SUSPEND:
+			errorMessageStr := ""
+
+			if job.Error != nil {
+
+				errorMessage, _ := json.Marshal(job.Error)
+				errorMessageStr = string(errorMessage[:])
+			}
+
+			err = fmt.Errorf("(Wait_for_results) job is in state \"%s\" (error: %s)", job.State, errorMessageStr)
+			return
+		case core.JOB_STAT_FAILED_PERMANENT:
+			err = fmt.Errorf("(Wait_for_results) job is in state \"%s\"", job.State)
+			return
+		case core.JOB_STAT_DELETED:
+			err = fmt.Errorf("(Wait_for_results) job is in state \"%s\"", job.State)
+			return
+		}
+	}
+	//spew.Dump(job)
+
+	//_, err = job.Init()
+	//if err != nil {
+	//return
+	//}
+
+	// example: curl http://skyport.local:8001/awe/api/workflow_instances/c1cad21a-5ab5-4015-8aae-1dfda844e559_root
+
+	var wi *core.WorkflowInstance
+	wi, statusCode, err = GetRootWorkflowInstance(job, aweAuth)
+
+	//panic("Implement getting for output")
+	//var wi *core.WorkflowInstance
+	// var ok bool
+	// wi, ok, err = job.GetWorkflowInstance("_root", false)
+	if err != nil {
+		err = fmt.Errorf("(Wait_for_results) GetWorkflowInstance returned: %s", err.Error())
+		return
+	}
+
+	// if !ok {
+	// 	err = fmt.Errorf("(Wait_for_results) WorkflowInstance not found")
+	// 	return
+	// }
+
+	//spew.Dump(wi.Outputs)
+
+	outputReceipt := map[string]interface{}{}
+	for _, out := range wi.Outputs {
+
+		outID := strings.TrimPrefix(out.ID, job.Entrypoint+"/")
+
+		outputReceipt[outID] = out.Value
+	}
+
+	if conf.SUBMITTER_DOWNLOAD_FILES { // TODO
+		var outputFilePath string
+		outputFilePath, err = os.Getwd()
+
+		_, err = cache.ProcessIOData(outputReceipt, outputFilePath, outputFilePath, "download", nil, context, true, true)
+		if err != nil {
+			//spew.Dump(outputReceipt)
+			err = fmt.Errorf("(Wait_for_results) ProcessIOData(for download) returned: %s", err.Error())
+			return
+		}
+	}
+
+	var outputReceiptBytes []byte
+	outputReceiptBytes, err = json.MarshalIndent(outputReceipt, "", "    ")
+	if err != nil {
+		if err != nil {
+			err = fmt.Errorf("(Wait_for_results) json.MarshalIndent returned: %s", err.Error())
+			return
+		}
+	}
+	logger.Debug(3, "%s", string(outputReceiptBytes[:]))
+
+	if conf.SUBMITTER_OUTPUT != "" {
+		err = ioutil.WriteFile(conf.SUBMITTER_OUTPUT, outputReceiptBytes, 0644)
+		if err != nil {
+			err = fmt.Errorf("(Wait_for_results) ioutil.WriteFile returned: %s", err.Error())
+			return
+		}
+	} else {
+		fmt.Println(string(outputReceiptBytes[:]))
+	}
+	return
+}
+
+// aweClient a client for the AWE server with the credentials of the user
+func aweClient(aweAuth string, shockAuth string) (c *client.Client) {
+	c = client.New(conf.SERVER_URL, aweAuth)
+	c.DataToken = shockAuth
+	return
+}
+
+// SubmitCWLJobToAWE _
+func SubmitCWLJobToAWE(workflowFile string, jobFile string, entrypoint string, jobData *[]byte, aweAuth string, shockAuth string) (jobid string, newEntrypoint string, err error) {
+	workflow, err := ioutil.ReadFile(workflowFile)
+	if err != nil {
+		err = fmt.Errorf("(SubmitCWLJobToAWE) ioutil.ReadFile returned: %s (workflowFile=%s)", err.Error(), workflowFile)
+		return
+	}
+
+	logger.Debug(3, "(SubmitCWLJobToAWE) jobFile: %s, entrypoint: %s", jobFile, entrypoint)
+	var jobInput []byte
+	if jobData != nil {
+		jobInput = *jobData
+	}
+
+	job := &core.Job{}
+	err = aweClient(aweAuth, shockAuth).SubmitCWLJob(context.Background(), workflowFile, workflow, jobFile, jobInput, conf.CLIENT_GROUP, entrypoint, job)
+	if err != nil {
+		err = fmt.Errorf("(SubmitCWLJobToAWE) client.SubmitCWLJob returned: %s", err.Error())
+		return
+	}
+	jobid = job.ID
+	newEntrypoint = job.Entrypoint
+	return
+}
+
+// GetAWEObject gets a resource like job/<objectid>, statusCode is -1 if the server did not respond
+func GetAWEObject(resource string, objectid string, aweAuth string, result interface{}) (statusCode int, err error) {
+	statusCode = -1
+	if objectid == "" {
+		err = fmt.Errorf("(GetAWEObject) objectid empty")
+		return
+	}
+
+	_, err = aweClient(aweAuth, "").Get(context.Background(), resource+"/"+objectid, nil, result)
+	if err != nil {
+		statusCode = client.StatusCode(err)
+		err = fmt.Errorf("(GetAWEObject) client.Get returned: %s", err.Error())
+		return
+	}
+	statusCode = 200
+	return
+}
+
+// GetAWEJob _
+func GetAWEJob(jobid string, aweAuth string) (job *core.Job, statusCode int, err error) {
+
+	job = &core.Job{}
+	statusCode, err = GetAWEObject("job", jobid, aweAuth, job)
+	if err != nil {
+		err = fmt.Errorf("(GetAWEJob) GetAWEObject returned: %s", err.Error())
+	}
+	return
+}
+
+// GetRootWorkflowInstance _
+func GetRootWorkflowInstance(job *core.Job, aweAuth string) (wi *core.WorkflowInstance, statusCode int, err error) {
+	statusCode = 200
+	wiIf, err := aweClient(aweAuth, "").GetWorkflowInstance(context.Background(), job.Root)
+	if err != nil {
+		statusCode = client.StatusCode(err)
+		err = fmt.Errorf("(GetRootWorkflowInstance) client.GetWorkflowInstance returned: %s (root: %s)", err.Error(), job.Root)
+		return
+	}
+	wi, err = core.NewWorkflowInstanceFromInterface(wiIf, job, nil, false)
+	if err != nil {
+		err = fmt.Errorf("(GetRootWorkflowInstance) NewWorkflowInstanceFromInterface returned: %s", err.Error())
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package user
+
+import (
+	"github.com/MG-RAST/AWE/lib/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// documentUsers the users in the Users collection
+type documentUsers struct{}
+
+func (documentUsers) Init() (err error) {
+	c := db.C("Users")
+	if err = c.EnsureIndex([]string{"uuid"}, true); err != nil {
+		return err
+	}
+	return c.EnsureIndex([]string{"username"}, true)
+}
+
+func (documentUsers) findOne(selector bson.M) (u *User, err error) {
+	u = &User{}
+	if err = db.C("Users").Find(selector).One(&u); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (r documentUsers) Get(uuid string) (*User, error) {
+	return r.findOne(bson.M{"uuid": uuid})
+}
+
+func (r documentUsers) GetByUsername(username string) (*User, error) {
+	return r.findOne(bson.M{"username": username})
+}
+
+func (r documentUsers) GetByUsernamePassword(username string, password string) (*User, error) {
+	return r.findOne(bson.M{"username": username, "password": password})
+}
+
+func (documentUsers) All(u *Users) error {
+	return db.C("Users").Find(nil).All(u)
+}
+
+func (documentUsers) Save(u *User) error {
+	return db.C("Users").Upsert(bson.M{"uuid": u.Uuid}, &u)
+}
+
+func (documentUsers) ResetAdmins() (err error) {
+	_, err = db.C("Users").UpdateAll(bson.M{}, bson.M{"$set": bson.M{"admin": false}})
+	return
+}
+
+func (documentUsers) SetAdmin(username string) (updated int, err error) {
+	return db.C("Users").UpdateAll(bson.M{"username": username}, bson.M{"$set": bson.M{"admin": true}})
+}
@@ -0,0 +1,16 @@
+package user
+
+// UserRepository the users
+type UserRepository interface {
+	Init() error
+	Get(uuid string) (*User, error)
+	GetByUsername(username string) (*User, error)
+	GetByUsernamePassword(username string, password string) (*User, error)
+	All(u *Users) error
+	Save(u *User) error
+	ResetAdmins() error                                // all users are no admins
+	SetAdmin(username string) (updated int, err error) // the number of users changed
+}
+
+// Repo the users used by the server, lib/db documents unless replaced (e.g. by tests)
+var Repo UserRepository = documentUsers{}
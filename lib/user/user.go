@@ -2,10 +2,7 @@ package user
 
 import (
 	"github.com/MG-RAST/AWE/lib/conf"
-	"github.com/MG-RAST/AWE/lib/db"
 	"github.com/MG-RAST/golib/go-uuid/uuid"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
 )
 
 // Array of User
@@ -23,28 +20,22 @@ type User struct {
 }
 
 func Initialize() (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
-	if err = c.EnsureIndex(mgo.Index{Key: []string{"uuid"}, Unique: true}); err != nil {
-		return err
-	}
-	if err = c.EnsureIndex(mgo.Index{Key: []string{"username"}, Unique: true}); err != nil {
+	if err = Repo.Init(); err != nil {
 		return err
 	}
 
 	// Setting admin users based on config file.  First, set all users to Admin = false
-	if _, err = c.UpdateAll(bson.M{}, bson.M{"$set": bson.M{"admin": false}}); err != nil {
+	if err = Repo.ResetAdmins(); err != nil {
 		return err
 	}
 
 	// process list of amin users from config, create those that are missing
 	for _, v := range conf.AdminUsers {
-		info, err := c.UpdateAll(bson.M{"username": v}, bson.M{"$set": bson.M{"admin": true}})
+		updated, err := Repo.SetAdmin(v)
 		if err != nil {
 			return err
 		}
-		if info.Updated == 0 {
+		if updated == 0 {
 			if _, err := New(v, "", true); err != nil {
 				return err
 			}
@@ -63,33 +54,15 @@ func New(username string, password string, isAdmin bool) (u *User, err error) {
 }
 
 func FindByUuid(uuid string) (u *User, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
-	u = &User{Uuid: uuid}
-	if err = c.Find(bson.M{"uuid": u.Uuid}).One(&u); err != nil {
-		return nil, err
-	}
-	return
+	return Repo.Get(uuid)
 }
 
 func FindByUsernamePassword(username string, password string) (u *User, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
-	u = &User{}
-	if err = c.Find(bson.M{"username": username, "password": password}).One(&u); err != nil {
-		return nil, err
-	}
-	return
+	return Repo.GetByUsernamePassword(username, password)
 }
 
 func AdminGet(u *Users) (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
-	err = c.Find(nil).All(u)
-	return
+	return Repo.All(u)
 }
 
 func (u *User) SetMongoInfo() (err error) {
@@ -115,20 +88,13 @@ func (u *User) SetMongoInfo() (err error) {
 }
 
 func dbGetInfo(username string) (uuid string, admin bool, err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
-	u := User{}
-	if err = c.Find(bson.M{"username": username}).One(&u); err != nil {
+	u, err := Repo.GetByUsername(username)
+	if err != nil {
 		return "", false, err
 	}
 	return u.Uuid, u.Admin, nil
 }
 
 func (u *User) Save() (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Users")
-	_, err = c.Upsert(bson.M{"uuid": u.Uuid}, &u)
-	return
+	return Repo.Save(u)
 }
@@ -6,7 +6,6 @@ import (
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	"github.com/MG-RAST/AWE/lib/db"
-	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"os"
 	"strconv"
@@ -22,10 +21,8 @@ type Versions []Version
 var VersionMap = make(map[string]int)
 
 func Initialize() (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Versions")
-	c.EnsureIndex(mgo.Index{Key: []string{"name"}, Unique: true})
+	c := db.C("Versions")
+	c.EnsureIndex([]string{"name"}, true)
 	var versions = new(Versions)
 	err = c.Find(bson.M{}).All(versions)
 	if err != nil {
@@ -47,9 +44,7 @@ func Initialize() (err error) {
 
 func Print() (err error) {
 	fmt.Printf("##### Versions ####\n")
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Versions")
+	c := db.C("Versions")
 	var versions = new(Versions)
 	if err = c.Find(bson.M{}).All(versions); err != nil {
 		return err
@@ -62,11 +57,9 @@ func Print() (err error) {
 }
 
 func PushVersionsToDatabase() (err error) {
-	session := db.Connection.Session.Copy()
-	defer session.Close()
-	c := session.DB(conf.MONGODB_DATABASE).C("Versions")
+	c := db.C("Versions")
 	for k, v := range conf.VERSIONS {
-		if _, err = c.Upsert(bson.M{"name": k}, bson.M{"$set": bson.M{"name": k, "version": v}}); err != nil {
+		if err = c.Upsert(bson.M{"name": k}, bson.M{"$set": bson.M{"name": k, "version": v}}); err != nil {
 			return err
 		}
 	}
@@ -80,7 +73,8 @@ func RunVersionUpdates() (err error) {
 
 	// Upgrading database if the Job version is less than 2 (or no Job version number exists) and
 	// the Job version in the config file exists and is greater than or equal to 2.
-	if (ok1 && confVersionJob >= 2) && (!ok2 || (ok2 && dbVersionJob < 2)) {
+	// (only mongodb databases can have version 1 jobs)
+	if conf.DB_BACKEND == db.BackendMongoDB && (ok1 && confVersionJob >= 2) && (!ok2 || (ok2 && dbVersionJob < 2)) {
 		consoleReader := bufio.NewReader(os.Stdin)
 		session := db.Connection.Session.Copy()
 		defer session.Close()
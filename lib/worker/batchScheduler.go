@@ -0,0 +1,359 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
+)
+
+const (
+	batchScript       = "awe_batch_job.sh"
+	batchStdout       = "awe_batch.out"
+	batchStderr       = "awe_batch.err"
+	batchExitcodeFile = "awe_batch_exitcode"
+	batchJobNameMax   = 15 // pbs limit
+	batchLostPolls    = 3  // polls to wait for the exit code on the shared filesystem after the job ended
+)
+
+// BatchJob describes a workunit submitted to the batch system
+type BatchJob struct {
+	Name     string
+	Script   string
+	WorkPath string
+	Cores    int // 0: scheduler default
+	RamMB    int // 0: scheduler default
+}
+
+// BatchState is the state of a job as reported by the batch system
+type BatchState struct {
+	Known     bool   // the scheduler knows the job
+	Finished  bool   // the job has left the batch system
+	State     string // scheduler specific
+	OOMKilled bool
+}
+
+// BatchScheduler wraps the command line tools of a batch system, the commands are configurable
+// so that a stub script can stand in for the scheduler
+type BatchScheduler interface {
+	Name() string
+	Submit(job BatchJob) (jobID string, err error)
+	State(jobID string) (state BatchState, err error)
+	Cancel(jobID string) (err error)
+}
+
+// GetBatchScheduler returns the scheduler configured with batch_system, nil if the worker runs workunits locally
+func GetBatchScheduler() (scheduler BatchScheduler, err error) {
+	switch conf.BATCH_SYSTEM {
+	case "":
+		return
+	case "slurm":
+		scheduler = slurmScheduler{}
+	case "pbs":
+		scheduler = pbsScheduler{}
+	default:
+		err = fmt.Errorf("(GetBatchScheduler) unknown batch system %s", conf.BATCH_SYSTEM)
+	}
+	return
+}
+
+// slurmScheduler uses sbatch, sacct and scancel
+type slurmScheduler struct{}
+
+func (s slurmScheduler) Name() string { return "slurm" }
+
+func (s slurmScheduler) Submit(job BatchJob) (jobID string, err error) {
+	args := []string{"--parsable",
+		"--job-name=" + job.Name,
+		"--chdir=" + job.WorkPath,
+		"--output=" + path.Join(job.WorkPath, batchStdout),
+		"--error=" + path.Join(job.WorkPath, batchStderr),
+	}
+	if job.Cores > 0 {
+		args = append(args, fmt.Sprintf("--cpus-per-task=%d", job.Cores))
+	}
+	if job.RamMB > 0 {
+		args = append(args, fmt.Sprintf("--mem=%dM", job.RamMB))
+	}
+	if conf.BATCH_WALLTIME != "" {
+		args = append(args, "--time="+conf.BATCH_WALLTIME)
+	}
+	if conf.BATCH_QUEUE != "" {
+		args = append(args, "--partition="+conf.BATCH_QUEUE)
+	}
+	args = append(args, strings.Fields(conf.BATCH_SUBMIT_ARGS)...)
+	args = append(args, job.Script)
+
+	stdo, stde, err := RunCommand(conf.BATCH_SUBMIT_CMD, args...)
+	if err != nil {
+		err = fmt.Errorf("(slurmScheduler/Submit) %s returned: %s (%s)", conf.BATCH_SUBMIT_CMD, err.Error(), strings.TrimSpace(string(stde)))
+		return
+	}
+	// --parsable: "jobid" or "jobid;cluster"
+	jobID = strings.SplitN(firstLine(stdo), ";", 2)[0]
+	if jobID == "" {
+		err = fmt.Errorf("(slurmScheduler/Submit) %s did not return a job id", conf.BATCH_SUBMIT_CMD)
+	}
+	return
+}
+
+func (s slurmScheduler) State(jobID string) (state BatchState, err error) {
+	stdo, stde, err := RunCommand(conf.BATCH_STATUS_CMD, "--noheader", "--allocations", "--parsable2", "--jobs="+jobID, "--format=State")
+	if err != nil {
+		err = fmt.Errorf("(slurmScheduler/State) %s returned: %s (%s)", conf.BATCH_STATUS_CMD, err.Error(), strings.TrimSpace(string(stde)))
+		return
+	}
+	line := firstLine(stdo)
+	if line == "" {
+		// not yet in the accounting database
+		return
+	}
+	state.Known = true
+	state.State = strings.Fields(line)[0] // e.g. "CANCELLED by 1000"
+	switch state.State {
+	case "PENDING", "RUNNING", "CONFIGURING", "COMPLETING", "SUSPENDED", "REQUEUED", "RESIZING", "STAGE_OUT", "SIGNALING":
+	case "OUT_OF_MEMORY":
+		state.Finished = true
+		state.OOMKilled = true
+	default:
+		// COMPLETED, FAILED, CANCELLED, TIMEOUT, NODE_FAIL, PREEMPTED, BOOT_FAIL, DEADLINE
+		state.Finished = true
+	}
+	return
+}
+
+func (s slurmScheduler) Cancel(jobID string) (err error) {
+	_, stde, err := RunCommand(conf.BATCH_CANCEL_CMD, jobID)
+	if err != nil {
+		err = fmt.Errorf("(slurmScheduler/Cancel) %s returned: %s (%s)", conf.BATCH_CANCEL_CMD, err.Error(), strings.TrimSpace(string(stde)))
+	}
+	return
+}
+
+// pbsScheduler uses qsub, qstat and qdel (Torque and PBS Pro)
+type pbsScheduler struct{}
+
+func (s pbsScheduler) Name() string { return "pbs" }
+
+func (s pbsScheduler) Submit(job BatchJob) (jobID string, err error) {
+	args := []string{
+		"-N", job.Name,
+		"-o", path.Join(job.WorkPath, batchStdout),
+		"-e", path.Join(job.WorkPath, batchStderr),
+	}
+	if job.Cores > 0 {
+		args = append(args, "-l", fmt.Sprintf("nodes=1:ppn=%d", job.Cores))
+	}
+	if job.RamMB > 0 {
+		args = append(args, "-l", fmt.Sprintf("mem=%dmb", job.RamMB))
+	}
+	if conf.BATCH_WALLTIME != "" {
+		args = append(args, "-l", "walltime="+conf.BATCH_WALLTIME)
+	}
+	if conf.BATCH_QUEUE != "" {
+		args = append(args, "-q", conf.BATCH_QUEUE)
+	}
+	args = append(args, strings.Fields(conf.BATCH_SUBMIT_ARGS)...)
+	args = append(args, job.Script)
+
+	stdo, stde, err := RunCommand(conf.BATCH_SUBMIT_CMD, args...)
+	if err != nil {
+		err = fmt.Errorf("(pbsScheduler/Submit) %s returned: %s (%s)", conf.BATCH_SUBMIT_CMD, err.Error(), strings.TrimSpace(string(stde)))
+		return
+	}
+	jobID = firstLine(stdo)
+	if jobID == "" {
+		err = fmt.Errorf("(pbsScheduler/Submit) %s did not return a job id", conf.BATCH_SUBMIT_CMD)
+	}
+	return
+}
+
+func (s pbsScheduler) State(jobID string) (state BatchState, err error) {
+	stdo, _, xerr := RunCommand(conf.BATCH_STATUS_CMD, "-f", jobID)
+	if xerr != nil {
+		// qstat fails for jobs that are no longer known to the server
+		logger.Debug(3, "(pbsScheduler/State) %s -f %s returned: %s", conf.BATCH_STATUS_CMD, jobID, xerr.Error())
+		return
+	}
+	for _, line := range strings.Split(string(stdo), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "job_state" {
+			continue
+		}
+		state.Known = true
+		state.State = strings.TrimSpace(parts[1])
+		switch state.State {
+		case "C", "F": // completed (Torque), finished (PBS Pro)
+			state.Finished = true
+		}
+		return
+	}
+	return
+}
+
+func (s pbsScheduler) Cancel(jobID string) (err error) {
+	_, stde, err := RunCommand(conf.BATCH_CANCEL_CMD, jobID)
+	if err != nil {
+		err = fmt.Errorf("(pbsScheduler/Cancel) %s returned: %s (%s)", conf.BATCH_CANCEL_CMD, err.Error(), strings.TrimSpace(string(stde)))
+	}
+	return
+}
+
+func firstLine(b []byte) string {
+	return strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+}
+
+// RunWorkunitBatch runs the command of a workunit as a batch job, stdout and stderr of the
+// command are written to the work directory like in RunWorkunitDirect
+func RunWorkunitBatch(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
+	args := workunit.Cmd.ParsedArgs
+	if len(workunit.Cmd.ArgsArray) > 0 {
+		args = workunit.Cmd.ArgsArray
+	}
+	if workunit.Cmd.Name == "" {
+		err = fmt.Errorf("(RunWorkunitBatch) command name is empty")
+		return
+	}
+
+	status, err := runBatchProcess(workunit, workunit.Cmd.Name, args, true)
+	if err != nil {
+		err = fmt.Errorf("(RunWorkunitBatch) %s", err.Error())
+		return
+	}
+	if status != 0 {
+		err = fmt.Errorf("(RunWorkunitBatch) cmd=%s, exit status %d", workunit.Cmd.Name, status)
+		return
+	}
+
+	pstats = new(core.WorkPerf)
+	pstats.MaxMemUsage = -1
+	return
+}
+
+// runBatchProcess submits a job that runs binary with args in the work directory of the
+// workunit and waits for it. The job script records the exit status on the shared filesystem.
+func runBatchProcess(workunit *core.Workunit, binary string, args []string, redirect bool) (status int, err error) {
+
+	scheduler, err := GetBatchScheduler()
+	if err != nil {
+		return
+	}
+	if scheduler == nil {
+		err = errors.New("(runBatchProcess) no batch system configured")
+		return
+	}
+
+	workPath, err := workunit.Path()
+	if err != nil {
+		err = fmt.Errorf("(runBatchProcess) workunit.Path() returned: %s", err.Error())
+		return
+	}
+
+	exitcodeFile := path.Join(workPath, batchExitcodeFile)
+	os.Remove(exitcodeFile) // from a previous attempt
+
+	command := shellQuote(binary)
+	for _, arg := range args {
+		command += " " + shellQuote(arg)
+	}
+	if redirect {
+		command += fmt.Sprintf(" > %s 2> %s", shellQuote(path.Join(workPath, conf.STDOUT_FILENAME)), shellQuote(path.Join(workPath, conf.STDERR_FILENAME)))
+	}
+	script := path.Join(workPath, batchScript)
+	content := fmt.Sprintf("#!/bin/bash\ncd %s\n%s\necho $? > %s\n", shellQuote(workPath), command, shellQuote(exitcodeFile))
+	err = ioutil.WriteFile(script, []byte(content), 0755)
+	if err != nil {
+		err = fmt.Errorf("(runBatchProcess) error writing job script: %s", err.Error())
+		return
+	}
+
+	name := "awe_" + DockerizeName(workunit.ID)
+	if len(name) > batchJobNameMax {
+		name = name[:batchJobNameMax]
+	}
+	job := BatchJob{Name: name, Script: script, WorkPath: workPath}
+	job.Cores, job.RamMB = WorkunitResources(workunit)
+
+	jobID, err := scheduler.Submit(job)
+	if err != nil {
+		err = fmt.Errorf("(runBatchProcess) Submit returned: %s", err.Error())
+		return
+	}
+	logger.Info("workunit %s submitted as %s job %s", workunit.ID, scheduler.Name(), jobID)
+	logger.Event(event.WORK_START, "workid="+workunit.ID,
+		"cmd="+binary,
+		fmt.Sprintf("args=%v", args),
+		"batch_job="+jobID)
+
+	interval := time.Duration(conf.BATCH_POLL_INTERVAL_SECONDS) * time.Second
+	seen := false
+	lostPolls := 0
+	lastState := BatchState{}
+	for {
+		select {
+		case <-chankill:
+			if cerr := scheduler.Cancel(jobID); cerr != nil {
+				logger.Error("(runBatchProcess) could not cancel job %s: %s", jobID, cerr.Error())
+			}
+			err = fmt.Errorf("(runBatchProcess) batch job %s cancelled as requested from chankill", jobID)
+			return
+		case <-time.After(interval):
+		}
+
+		if b, xerr := ioutil.ReadFile(exitcodeFile); xerr == nil && len(strings.TrimSpace(string(b))) > 0 {
+			status, err = strconv.Atoi(strings.TrimSpace(string(b)))
+			if err != nil {
+				err = fmt.Errorf("(runBatchProcess) could not parse exit status of job %s: %s", jobID, err.Error())
+				return
+			}
+			break
+		}
+
+		state, xerr := scheduler.State(jobID)
+		if xerr != nil {
+			logger.Error("(runBatchProcess) %s", xerr.Error())
+			continue
+		}
+		if state.Known {
+			seen = true
+			lastState = state
+		}
+		if !state.Finished && (state.Known || !seen) {
+			logger.Debug(3, "(runBatchProcess) job %s: %s", jobID, state.State)
+			continue
+		}
+		if state.Finished {
+			lastState = state
+		}
+
+		// the job has ended, give the shared filesystem a few polls to show the exit status
+		lostPolls++
+		if lostPolls < batchLostPolls {
+			continue
+		}
+		if lastState.OOMKilled && workunit.WorkPerf != nil {
+			workunit.WorkPerf.OOMKilled = true
+		}
+		err = fmt.Errorf("(runBatchProcess) batch job %s ended in state %s without exit status, see %s", jobID, lastState.State, path.Join(workPath, batchStderr))
+		return
+	}
+
+	workunit.ExitStatus = status
+	if status != 0 {
+		// a memory limit kill of the command may leave the job script running to the end
+		if state, xerr := scheduler.State(jobID); xerr == nil && state.OOMKilled && workunit.WorkPerf != nil {
+			workunit.WorkPerf.OOMKilled = true
+		}
+	}
+	logger.Event(event.WORK_END, "workid="+workunit.ID, "batch_job="+jobID)
+	return
+}
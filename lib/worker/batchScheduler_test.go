@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// stub scripts for sbatch, sacct and scancel. sbatch records its arguments and runs the job script
+// unless the file "lost" exists, sacct prints the content of the file "state".
+const (
+	stubSubmit = `#!/bin/sh
+echo "$@" > STUB/submit_args
+if [ ! -e STUB/lost ]; then
+  for script; do :; done
+  sh "$script" > /dev/null 2>&1
+fi
+echo "4711;cluster"
+`
+	stubStatus = `#!/bin/sh
+cat STUB/state 2>/dev/null
+`
+	stubCancel = `#!/bin/sh
+echo "$1" > STUB/cancelled
+`
+)
+
+func setupBatchTest(t *testing.T) (workunit *core.Workunit, stub string, cleanup func()) {
+	if logger.Log == nil {
+		logger.Initialize("worker")
+	}
+	dir, err := ioutil.TempDir("", "awe-batch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub = path.Join(dir, "stub")
+	if err = os.MkdirAll(stub, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"sbatch": stubSubmit, "sacct": stubStatus, "scancel": stubCancel} {
+		content = strings.Replace(content, "STUB", stub, -1)
+		if err = ioutil.WriteFile(path.Join(stub, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restore := conftest.Save(&conf.WORK_PATH, &conf.BATCH_SYSTEM, &conf.BATCH_SUBMIT_CMD, &conf.BATCH_STATUS_CMD,
+		&conf.BATCH_CANCEL_CMD, &conf.BATCH_QUEUE, &conf.BATCH_POLL_INTERVAL_SECONDS)
+	cleanup = func() {
+		restore()
+		os.RemoveAll(dir)
+	}
+	conf.WORK_PATH = path.Join(dir, "work")
+	conf.BATCH_SYSTEM = "slurm"
+	conf.BATCH_SUBMIT_CMD = path.Join(stub, "sbatch")
+	conf.BATCH_STATUS_CMD = path.Join(stub, "sacct")
+	conf.BATCH_CANCEL_CMD = path.Join(stub, "scancel")
+	conf.BATCH_QUEUE = "short"
+	conf.BATCH_POLL_INTERVAL_SECONDS = 1
+
+	workunit = &core.Workunit{ID: "0123456789ab_Task_0", WorkPerf: &core.WorkPerf{}}
+	workunit.WorkPath = path.Join(conf.WORK_PATH, "0123456789ab_Task_0")
+	if err = os.MkdirAll(workunit.WorkPath, 0777); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestRunWorkunitBatch(t *testing.T) {
+	workunit, stub, cleanup := setupBatchTest(t)
+	defer cleanup()
+
+	tool := &cwl.CommandLineTool{}
+	tool.Requirements = []cwl.Requirement{&cwl.ResourceRequirement{CoresMin: 2, RamMin: 4096}}
+	workunit.CWLWorkunit = &core.CWLWorkunit{Tool: tool}
+	workunit.Cmd = &core.Command{Name: "echo", ArgsArray: []string{"hello", "batch"}}
+	ioutil.WriteFile(path.Join(stub, "state"), []byte("COMPLETED\n"), 0644)
+
+	pstats, err := RunWorkunitBatch(workunit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pstats == nil || workunit.ExitStatus != 0 {
+		t.Errorf("exit status %d", workunit.ExitStatus)
+	}
+
+	submitArgs, _ := ioutil.ReadFile(path.Join(stub, "submit_args"))
+	for _, want := range []string{"--parsable", "--job-name=awe_", "--cpus-per-task=2", "--mem=4096M", "--partition=short", batchScript} {
+		if !strings.Contains(string(submitArgs), want) {
+			t.Errorf("submit arguments do not contain %s: %s", want, submitArgs)
+		}
+	}
+	stdout, _ := ioutil.ReadFile(path.Join(workunit.WorkPath, conf.STDOUT_FILENAME))
+	if string(stdout) != "hello batch\n" {
+		t.Errorf("stdout of the command: %q", stdout)
+	}
+}
+
+func TestRunWorkunitBatchExitStatus(t *testing.T) {
+	workunit, stub, cleanup := setupBatchTest(t)
+	defer cleanup()
+
+	workunit.Cmd = &core.Command{Name: "sh", ArgsArray: []string{"-c", "exit 3"}}
+	ioutil.WriteFile(path.Join(stub, "state"), []byte("COMPLETED\n"), 0644)
+
+	_, err := RunWorkunitBatch(workunit)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+	if workunit.ExitStatus != 3 || workunit.WorkPerf.OOMKilled {
+		t.Errorf("exit status %d, oom %t", workunit.ExitStatus, workunit.WorkPerf.OOMKilled)
+	}
+}
+
+func TestRunWorkunitBatchFailed(t *testing.T) {
+	workunit, stub, cleanup := setupBatchTest(t)
+	defer cleanup()
+
+	// the job ends without running the script, e.g. killed by the scheduler
+	workunit.Cmd = &core.Command{Name: "true"}
+	ioutil.WriteFile(path.Join(stub, "lost"), nil, 0644)
+	ioutil.WriteFile(path.Join(stub, "state"), []byte("OUT_OF_MEMORY\n"), 0644)
+
+	_, err := RunWorkunitBatch(workunit)
+	if err == nil || !strings.Contains(err.Error(), "ended in state OUT_OF_MEMORY without exit status") {
+		t.Errorf("expected failed job, got %v", err)
+	}
+	if !workunit.WorkPerf.OOMKilled {
+		t.Errorf("OOM kill of the job not reported")
+	}
+}
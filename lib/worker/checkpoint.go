@@ -0,0 +1,315 @@
+package worker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/datastore"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/golib/httpclient"
+)
+
+// checkpointArchive temporary name of the checkpoint tarball in the work directory
+const checkpointArchive = "awe_checkpoint.tar.gz"
+
+// checkpointSpec returns the checkpoint directory (relative to the work directory) and the upload
+// interval of a workunit, from the AWE command or the CWL CheckpointRequirement. Empty if none.
+// The directory of a CheckpointRequirement is relative to the output directory of the tool.
+func checkpointSpec(workunit *core.Workunit) (directory string, interval time.Duration) {
+	seconds := 0
+	if workunit.Cmd != nil && workunit.Cmd.CheckpointDir != "" {
+		directory = workunit.Cmd.CheckpointDir
+		seconds = workunit.Cmd.CheckpointInterval
+	}
+	for _, requirement := range workunitRequirements(workunit) {
+		switch requirement.(type) {
+		case *cwl.CheckpointRequirement:
+			r := requirement.(*cwl.CheckpointRequirement)
+			if r.Directory != "" {
+				directory = r.Directory
+				seconds = r.Interval
+			}
+		}
+	}
+	if directory == "" {
+		return
+	}
+	directory = path.Clean(strings.TrimPrefix(directory, "/"))
+	if directory == "." || strings.HasPrefix(directory, "..") {
+		logger.Error("(checkpointSpec) invalid checkpoint directory %s", directory)
+		directory = ""
+		return
+	}
+	if workunit.CWLWorkunit != nil {
+		directory = path.Join(cwlOutdir, directory)
+	}
+	if seconds <= 0 {
+		seconds = conf.CHECKPOINT_INTERVAL_SECONDS
+	}
+	interval = time.Duration(seconds) * time.Second
+	return
+}
+
+// startCheckpoints restores the checkpoint of a requeued workunit and uploads the checkpoint directory
+// every interval until the returned stop function is called
+func startCheckpoints(workunit *core.Workunit) (stop func()) {
+	stop = func() {}
+	directory, interval := checkpointSpec(workunit)
+	if directory == "" {
+		return
+	}
+	workPath, err := workunit.Path()
+	if err != nil {
+		logger.Error("(startCheckpoints) workunit.Path returned: %s", err.Error())
+		return
+	}
+
+	if workunit.Checkpoint != nil {
+		err = restoreCheckpoint(workunit, workPath)
+		if err != nil {
+			// not fatal, the workunit starts from scratch
+			logger.Error("(startCheckpoints) restoreCheckpoint returned: %s", err.Error())
+		}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				xerr := uploadCheckpoint(workunit, workPath, directory)
+				if xerr != nil {
+					logger.Error("(startCheckpoints) uploadCheckpoint returned: %s", xerr.Error())
+				}
+			}
+		}
+	}()
+	// waits for a running upload, the archive must not be left in the work directory
+	stop = func() {
+		close(done)
+		<-finished
+		os.Remove(path.Join(workPath, checkpointArchive))
+	}
+	return
+}
+
+// restoreCheckpoint downloads the latest checkpoint and extracts it into the work directory
+func restoreCheckpoint(workunit *core.Workunit, workPath string) (err error) {
+	checkpoint := workunit.Checkpoint
+	archive := path.Join(workPath, checkpointArchive)
+	dataURL := fmt.Sprintf("%s/node/%s?download", checkpoint.Host, checkpoint.Node)
+	_, err = datastore.New(checkpoint.Host, workunit.Info.DataToken).FetchFile(archive, dataURL)
+	if err != nil {
+		err = fmt.Errorf("(restoreCheckpoint) FetchFile returned: %s", err.Error())
+		return
+	}
+	defer os.Remove(archive)
+
+	err = extractCheckpoint(archive, workPath)
+	if err != nil {
+		err = fmt.Errorf("(restoreCheckpoint) extractCheckpoint returned: %s", err.Error())
+		return
+	}
+	logger.Info("(restoreCheckpoint) workunit %s: restored checkpoint %d (node %s)", workunit.ID, checkpoint.Count, checkpoint.Node)
+	return
+}
+
+// uploadCheckpoint uploads the checkpoint directory to the data store and reports the node to the server
+func uploadCheckpoint(workunit *core.Workunit, workPath string, directory string) (err error) {
+	if _, err = os.Stat(path.Join(workPath, directory)); err != nil {
+		if os.IsNotExist(err) {
+			// nothing written yet
+			err = nil
+		}
+		return
+	}
+	archive := path.Join(workPath, checkpointArchive)
+	size, err := createCheckpoint(archive, workPath, directory)
+	if err != nil {
+		err = fmt.Errorf("(uploadCheckpoint) createCheckpoint returned: %s", err.Error())
+		return
+	}
+	defer os.Remove(archive)
+
+	host := workunit.ShockHost
+	if host == "" {
+		err = fmt.Errorf("(uploadCheckpoint) workunit has no shock host")
+		return
+	}
+	store := datastore.New(host, workunit.Info.DataToken)
+	node, err := store.PostFile(archive, "", checkpointArchive)
+	if err != nil {
+		err = fmt.Errorf("(uploadCheckpoint) PostFile returned: %s", err.Error())
+		return
+	}
+
+	checkpoint := &core.Checkpoint{Host: host, Node: node, Directory: directory, Size: size, Time: time.Now().Unix()}
+	err = notifyCheckpoint(workunit, checkpoint)
+	if err != nil {
+		// the server does not know the node, remove it again
+		store.Delete(node)
+		return
+	}
+	logger.Debug(1, "(uploadCheckpoint) workunit %s: checkpoint uploaded, node %s, %d bytes", workunit.ID, node, size)
+	return
+}
+
+// notifyCheckpoint sends PUT /work/{id}?checkpoint with the checkpoint as body
+func notifyCheckpoint(workunit *core.Workunit, checkpoint *core.Checkpoint) (err error) {
+	workIDb64, err := workunit.GetIDBase64()
+	if err != nil {
+		err = fmt.Errorf("(notifyCheckpoint) workunit.GetIDBase64 returned: %s", err.Error())
+		return
+	}
+	targeturl := fmt.Sprintf("%s/work/%s?checkpoint&client=%s", core.ServerURL(), workIDb64, core.Self.ID)
+
+	body, err := json.Marshal(checkpoint)
+	if err != nil {
+		return
+	}
+	headers := httpclient.Header{}
+	if conf.CLIENT_GROUP_TOKEN != "" {
+		headers["Authorization"] = []string{"CG_TOKEN " + conf.CLIENT_GROUP_TOKEN}
+	}
+	res, err := core.DoServerRequest("PUT", targeturl, headers, bytes.NewReader(body), 0)
+	if err != nil {
+		err = fmt.Errorf("(notifyCheckpoint) core.DoServerRequest returned: %s", err.Error())
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		err = fmt.Errorf("(notifyCheckpoint) server returned %s", res.Status)
+	}
+	return
+}
+
+// createCheckpoint writes directory (relative to workPath) as tar.gz to archive
+func createCheckpoint(archive string, workPath string, directory string) (size int64, err error) {
+	file, err := os.Create(archive)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(path.Join(workPath, directory), func(name string, info os.FileInfo, werr error) error {
+		if werr != nil {
+			return werr
+		}
+		rel, werr := filepath.Rel(workPath, name)
+		if werr != nil {
+			return werr
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			// symlinks, sockets etc. are not checkpointed
+			return nil
+		}
+		header, werr := tar.FileInfoHeader(info, "")
+		if werr != nil {
+			return werr
+		}
+		header.Name = filepath.ToSlash(rel)
+		if werr = tw.WriteHeader(header); werr != nil {
+			return werr
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, werr := os.Open(name)
+		if werr != nil {
+			return werr
+		}
+		defer f.Close()
+		_, werr = io.Copy(tw, f)
+		return werr
+	})
+	if err != nil {
+		return
+	}
+	if err = tw.Close(); err != nil {
+		return
+	}
+	if err = gz.Close(); err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	size = info.Size()
+	return
+}
+
+// extractCheckpoint extracts a checkpoint tar.gz into workPath, entries outside of workPath are rejected
+func extractCheckpoint(archive string, workPath string) (err error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	for {
+		var header *tar.Header
+		header, err = tr.Next()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		target := filepath.Join(workPath, header.Name)
+		if !strings.HasPrefix(target, filepath.Clean(workPath)+string(os.PathSeparator)) {
+			err = fmt.Errorf("(extractCheckpoint) invalid path %s in checkpoint", header.Name)
+			return
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = extractCheckpointFile(tr, target, os.FileMode(header.Mode))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func extractCheckpointFile(r io.Reader, target string, mode os.FileMode) (err error) {
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return
+}
@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+func TestCheckpointSpec(t *testing.T) {
+	if logger.Log == nil {
+		logger.Initialize("worker")
+	}
+	defer conftest.Save(&conf.CHECKPOINT_INTERVAL_SECONDS)()
+	conf.CHECKPOINT_INTERVAL_SECONDS = 600
+
+	cwlWorkunit := func(directory string, interval int) *core.Workunit {
+		tool := &cwl.CommandLineTool{}
+		tool.Hints = []cwl.Requirement{&cwl.CheckpointRequirement{Directory: directory, Interval: interval}}
+		return &core.Workunit{Cmd: &core.Command{Name: "cwl-runner"}, CWLWorkunit: &core.CWLWorkunit{Tool: tool}}
+	}
+	tests := []struct {
+		workunit  *core.Workunit
+		directory string
+		interval  time.Duration
+	}{
+		{&core.Workunit{Cmd: &core.Command{}}, "", 0},
+		{&core.Workunit{Cmd: &core.Command{CheckpointDir: "state", CheckpointInterval: 60}}, "state", time.Minute},
+		{&core.Workunit{Cmd: &core.Command{CheckpointDir: "/state/"}}, "state", 10 * time.Minute},
+		{&core.Workunit{Cmd: &core.Command{CheckpointDir: "../state"}}, "", 0},
+		{&core.Workunit{Cmd: &core.Command{CheckpointDir: "."}}, "", 0},
+		{cwlWorkunit("ckpt", 120), path.Join(cwlOutdir, "ckpt"), 2 * time.Minute},
+		{cwlWorkunit("a/../ckpt", 0), path.Join(cwlOutdir, "ckpt"), 10 * time.Minute},
+		{cwlWorkunit("a/../../ckpt", 0), "", 0},
+	}
+	for i, test := range tests {
+		directory, interval := checkpointSpec(test.workunit)
+		if directory != test.directory || interval != test.interval {
+			t.Errorf("%d: got %q %s, want %q %s", i, directory, interval, test.directory, test.interval)
+		}
+	}
+}
+
+func TestCreateAndExtractCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-checkpoint-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workPath, restorePath := path.Join(dir, "work"), path.Join(dir, "restore")
+	files := map[string]string{
+		"cwl_outdir/ckpt/state.txt":  "iteration 3",
+		"cwl_outdir/ckpt/sub/x.bin":  "x",
+		"cwl_outdir/output.txt":      "not in the checkpoint",
+		"cwl_outdir/ckpt/empty_file": "",
+	}
+	for name, content := range files {
+		if err = os.MkdirAll(path.Dir(path.Join(workPath, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path.Join(workPath, name), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Symlink("/etc/passwd", path.Join(workPath, "cwl_outdir/ckpt/link")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := path.Join(dir, checkpointArchive)
+	size, err := createCheckpoint(archive, workPath, "cwl_outdir/ckpt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size == 0 {
+		t.Errorf("empty archive")
+	}
+	if err = extractCheckpoint(archive, restorePath); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		data, err := ioutil.ReadFile(path.Join(restorePath, name))
+		if name == "cwl_outdir/output.txt" {
+			if err == nil {
+				t.Errorf("%s restored although it is outside of the checkpoint directory", name)
+			}
+			continue
+		}
+		if err != nil || string(data) != content {
+			t.Errorf("%s: got %q %v, want %q", name, data, err, content)
+		}
+	}
+	if info, err := os.Stat(path.Join(restorePath, "cwl_outdir/ckpt/state.txt")); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("mode not restored: %v %v", info, err)
+	}
+	if _, err = os.Lstat(path.Join(restorePath, "cwl_outdir/ckpt/link")); err == nil {
+		t.Errorf("symlink restored")
+	}
+}
+
+func TestExtractCheckpointRejectsEscapes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-checkpoint-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := path.Join(dir, checkpointArchive)
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "../escaped", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("x"))
+	tw.Close()
+	gz.Close()
+	file.Close()
+
+	workPath := path.Join(dir, "work")
+	if err = extractCheckpoint(archive, workPath); err == nil {
+		t.Errorf("expected an error for an entry outside of the work path")
+	}
+	if _, err = os.Stat(path.Join(dir, "escaped")); err == nil {
+		t.Errorf("entry outside of the work path extracted")
+	}
+}
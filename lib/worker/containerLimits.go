@@ -0,0 +1,263 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	cpuPeriod       = 100000 // CFS period in microseconds, quota = cores * period
+	nobodyUser      = "65534:65534"
+	containerTmpDir = "/tmp"
+)
+
+// ContainerLimits describes resources and isolation of a workunit container
+type ContainerLimits struct {
+	Cores          int    // 0: no limit
+	RamMB          int    // 0: no limit
+	PidsLimit      int    // 0: no limit
+	Network        bool   // false: container has no network
+	User           string // empty: default user of the image
+	ReadOnlyRootfs bool
+}
+
+// GetContainerLimits derives the limits from the ResourceRequirement of a CWL tool, the worker
+// (client group) defaults apply otherwise
+func GetContainerLimits(workunit *core.Workunit, workPath string) (limits ContainerLimits, err error) {
+
+	limits = ContainerLimits{
+		Cores:          conf.DOCKER_DEFAULT_CORES,
+		RamMB:          conf.DOCKER_DEFAULT_RAM_MB,
+		PidsLimit:      conf.DOCKER_PIDS_LIMIT,
+		Network:        !conf.DOCKER_RESTRICT_NETWORK,
+		ReadOnlyRootfs: conf.DOCKER_READ_ONLY_ROOTFS,
+	}
+
+	cores, ramMB := WorkunitResources(workunit)
+	if cores > 0 {
+		limits.Cores = cores
+	}
+	if ramMB > 0 {
+		limits.RamMB = ramMB
+	}
+
+	if workunit.Cmd.NetworkAccess {
+		limits.Network = true
+	}
+
+	for _, requirement := range workunitRequirements(workunit) {
+		switch requirement.(type) {
+		case *cwl.NetworkAccess:
+			r := requirement.(*cwl.NetworkAccess)
+			var inputs map[string]interface{}
+			inputs, err = cwlInputObject(workunit.CWLWorkunit.JobInput, nil, conf.DOCKER_WORK_DIR)
+			if err != nil {
+				err = fmt.Errorf("(GetContainerLimits) cwlInputObject returned: %s", err.Error())
+				return
+			}
+			var granted bool
+			granted, err = r.Granted(inputs)
+			if err != nil {
+				err = fmt.Errorf("(GetContainerLimits) NetworkAccess: %s", err.Error())
+				return
+			}
+			if granted {
+				limits.Network = true
+			}
+		}
+	}
+
+	limits.User, err = containerUser(workPath)
+	if err != nil {
+		err = fmt.Errorf("(GetContainerLimits) containerUser returned: %s", err.Error())
+		return
+	}
+	return
+}
+
+// workunitRequirements returns hints and requirements of the CWL tool, requirements last
+func workunitRequirements(workunit *core.Workunit) (requirements []cwl.Requirement) {
+	if workunit.CWLWorkunit == nil {
+		return
+	}
+	switch workunit.CWLWorkunit.Tool.(type) {
+	case *cwl.CommandLineTool:
+		tool := workunit.CWLWorkunit.Tool.(*cwl.CommandLineTool)
+		requirements = append(requirements, tool.Hints...)
+		requirements = append(requirements, tool.Requirements...)
+	case *cwl.ExpressionTool:
+		tool := workunit.CWLWorkunit.Tool.(*cwl.ExpressionTool)
+		requirements = append(requirements, tool.Hints...)
+		requirements = append(requirements, tool.Requirements...)
+	}
+	return
+}
+
+// WorkunitResources returns cores and memory (MiB) of the ResourceRequirement of a CWL tool,
+// the maximum if given, the minimum otherwise; 0 if not specified
+func WorkunitResources(workunit *core.Workunit) (cores int, ramMB int) {
+	for _, requirement := range workunitRequirements(workunit) {
+		switch requirement.(type) {
+		case *cwl.ResourceRequirement:
+			r := requirement.(*cwl.ResourceRequirement)
+			if n, ok := cwl.ResourceNumber(r.CoresMax); ok {
+				cores = n
+			} else if n, ok := cwl.ResourceNumber(r.CoresMin); ok {
+				cores = n
+			}
+			if n, ok := cwl.ResourceNumber(r.RamMax); ok {
+				ramMB = n
+			} else if n, ok := cwl.ResourceNumber(r.RamMin); ok {
+				ramMB = n
+			}
+		}
+	}
+	return
+}
+
+// containerUser returns the uid:gid the container runs as. If the worker runs as root and chown_work_path
+// is set, the directories of the work path and the files private to the workunit are handed over to that
+// user so that the tool can write its outputs. Symlinks and hard links, e.g. staged inputs shared with the cache or predata,
+// keep their owner.
+func containerUser(workPath string) (user string, err error) {
+
+	switch conf.DOCKER_CONTAINER_USER {
+	case "root":
+		return
+	case "auto", "":
+		if os.Getuid() != 0 {
+			user = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+			return
+		}
+		user = nobodyUser
+	default:
+		user = conf.DOCKER_CONTAINER_USER
+	}
+
+	if os.Getuid() != 0 || !conf.DOCKER_CHOWN_WORK_PATH {
+		return
+	}
+
+	parts := strings.SplitN(user, ":", 2)
+	uid, xerr := strconv.Atoi(parts[0])
+	if xerr != nil {
+		// user name, ownership is left to the image
+		return
+	}
+	gid := uid
+	if len(parts) == 2 {
+		gid, xerr = strconv.Atoi(parts[1])
+		if xerr != nil {
+			return
+		}
+	}
+
+	err = filepath.Walk(workPath, func(p string, info os.FileInfo, werr error) error {
+		if werr != nil {
+			return werr
+		}
+		if !privateToWorkunit(info) {
+			return nil
+		}
+		return os.Lchown(p, uid, gid)
+	})
+	if err != nil {
+		err = fmt.Errorf("(containerUser) chown of %s returned: %s", workPath, err.Error())
+	}
+	return
+}
+
+// privateToWorkunit reports whether a file in the work path can be given to the container user:
+// directories and regular files with a single link
+func privateToWorkunit(info os.FileInfo) bool {
+	if info.IsDir() {
+		return true
+	}
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Nlink == 1
+}
+
+// Apply sets the limits in the container configuration used by the docker API
+func (limits ContainerLimits) Apply(config *docker.Config, hostConfig *docker.HostConfig) {
+	if limits.Cores > 0 {
+		hostConfig.CPUPeriod = cpuPeriod
+		hostConfig.CPUQuota = int64(limits.Cores) * cpuPeriod
+	}
+	if limits.RamMB > 0 {
+		hostConfig.Memory = int64(limits.RamMB) * 1024 * 1024
+		hostConfig.MemorySwap = hostConfig.Memory // no swap on top of the limit
+	}
+	if limits.PidsLimit > 0 {
+		hostConfig.PidsLimit = int64(limits.PidsLimit)
+	}
+	if !limits.Network {
+		hostConfig.NetworkMode = "none"
+		config.NetworkDisabled = true
+	}
+	if limits.User != "" {
+		config.User = limits.User
+	}
+	if limits.ReadOnlyRootfs {
+		hostConfig.ReadonlyRootfs = true
+		hostConfig.Tmpfs = map[string]string{containerTmpDir: "rw,exec"}
+	}
+}
+
+// CommandLineArgs returns the limits as options for "docker create"
+func (limits ContainerLimits) CommandLineArgs() (args []string) {
+	if limits.Cores > 0 {
+		args = append(args, fmt.Sprintf("--cpu-period=%d", cpuPeriod), fmt.Sprintf("--cpu-quota=%d", limits.Cores*cpuPeriod))
+	}
+	if limits.RamMB > 0 {
+		args = append(args, fmt.Sprintf("--memory=%dm", limits.RamMB), fmt.Sprintf("--memory-swap=%dm", limits.RamMB))
+	}
+	if limits.PidsLimit > 0 {
+		args = append(args, fmt.Sprintf("--pids-limit=%d", limits.PidsLimit))
+	}
+	if !limits.Network {
+		args = append(args, "--network=none")
+	}
+	if limits.User != "" {
+		args = append(args, "--user="+limits.User)
+	}
+	if limits.ReadOnlyRootfs {
+		args = append(args, "--read-only", "--tmpfs="+containerTmpDir)
+	}
+	return
+}
+
+// containerOOMKilled reports whether the kernel killed the container for exceeding its memory limit
+func containerOOMKilled(client *docker.Client, containerID string) (oomKilled bool, err error) {
+	if client != nil {
+		var cont *docker.Container
+		cont, err = client.InspectContainer(containerID)
+		if err != nil {
+			err = fmt.Errorf("(containerOOMKilled) InspectContainer returned: %s", err.Error())
+			return
+		}
+		oomKilled = cont.State.OOMKilled
+		return
+	}
+
+	stdo, _, err := RunCommand(conf.DOCKER_BINARY, "inspect", "--format={{.State.OOMKilled}}", containerID)
+	if err != nil {
+		err = fmt.Errorf("(containerOOMKilled) docker inspect returned: %s", err.Error())
+		return
+	}
+	oomKilled = strings.TrimSpace(string(stdo)) == "true"
+	logger.Debug(3, "(containerOOMKilled) container %s: %t", containerID, oomKilled)
+	return
+}
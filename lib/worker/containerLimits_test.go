@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestGetContainerLimits(t *testing.T) {
+	defer setupContainerLimitsTest("root", false)()
+	conf.DOCKER_DEFAULT_CORES, conf.DOCKER_DEFAULT_RAM_MB = 1, 256
+
+	// AWE workunits get the defaults of the worker
+	limits, err := GetContainerLimits(&core.Workunit{Cmd: &core.Command{}}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ContainerLimits{Cores: 1, RamMB: 256, PidsLimit: 100, ReadOnlyRootfs: true}
+	if limits != want {
+		t.Errorf("got %+v, want %+v", limits, want)
+	}
+
+	cores := cwl.Int(4)
+	tool := &cwl.CommandLineTool{}
+	tool.Hints = []cwl.Requirement{&cwl.ResourceRequirement{CoresMin: 2, RamMax: 1024}}
+	tool.Requirements = []cwl.Requirement{&cwl.ResourceRequirement{CoresMin: 1, CoresMax: &cores}, &cwl.NetworkAccess{NetworkAccess: true}}
+	workunit := &core.Workunit{Cmd: &core.Command{}, CWLWorkunit: &core.CWLWorkunit{Tool: tool}}
+	limits, err = GetContainerLimits(workunit, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = ContainerLimits{Cores: 4, RamMB: 1024, PidsLimit: 100, Network: true, ReadOnlyRootfs: true}
+	if limits != want {
+		t.Errorf("got %+v, want %+v", limits, want)
+	}
+
+	tool.Requirements = []cwl.Requirement{&cwl.NetworkAccess{NetworkAccess: "yes"}}
+	if _, err = GetContainerLimits(workunit, t.TempDir()); err == nil {
+		t.Errorf("expected an error for a networkAccess that is not a boolean")
+	}
+}
+
+func TestContainerUserChown(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("the work path is only handed over if the worker runs as root")
+	}
+	defer setupContainerLimitsTest("1234:2345", true)()
+	workPath := t.TempDir()
+	private, shared := path.Join(workPath, "private"), path.Join(workPath, "shared")
+	for _, file := range []string{private, shared} {
+		if err := ioutil.WriteFile(file, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(shared, path.Join(t.TempDir(), "cached")); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := containerUser(workPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "1234:2345" {
+		t.Errorf("got user %s", user)
+	}
+	for file, uid := range map[string]uint32{workPath: 1234, private: 1234, shared: 0} {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat := info.Sys().(*syscall.Stat_t); stat.Uid != uid {
+			t.Errorf("%s: owner %d, want %d", file, stat.Uid, uid)
+		}
+	}
+
+	// chown_work_path=false leaves the owners alone
+	conf.DOCKER_CHOWN_WORK_PATH = false
+	if err = os.Chown(private, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = containerUser(workPath); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(private); info.Sys().(*syscall.Stat_t).Uid != 0 {
+		t.Errorf("work path handed over although chown_work_path is false")
+	}
+}
+
+func TestContainerLimitsApply(t *testing.T) {
+	limits := ContainerLimits{Cores: 2, RamMB: 512, PidsLimit: 100, User: "1000:1000", ReadOnlyRootfs: true}
+	config, hostConfig := &docker.Config{}, &docker.HostConfig{}
+	limits.Apply(config, hostConfig)
+	if hostConfig.CPUQuota != 2*cpuPeriod || hostConfig.CPUPeriod != cpuPeriod || hostConfig.Memory != 512*1024*1024 || hostConfig.MemorySwap != hostConfig.Memory || hostConfig.PidsLimit != 100 {
+		t.Errorf("unexpected resources %+v", hostConfig)
+	}
+	if hostConfig.NetworkMode != "none" || !config.NetworkDisabled || config.User != "1000:1000" {
+		t.Errorf("unexpected isolation %+v %+v", config, hostConfig)
+	}
+	if !hostConfig.ReadonlyRootfs || hostConfig.Tmpfs[containerTmpDir] == "" {
+		t.Errorf("root filesystem not read-only %+v", hostConfig)
+	}
+
+	// no limits leave the configuration alone
+	config, hostConfig = &docker.Config{User: "image"}, &docker.HostConfig{}
+	ContainerLimits{Network: true}.Apply(config, hostConfig)
+	if !reflect.DeepEqual(config, &docker.Config{User: "image"}) || !reflect.DeepEqual(hostConfig, &docker.HostConfig{}) {
+		t.Errorf("unexpected configuration %+v %+v", config, hostConfig)
+	}
+}
+
+func TestContainerLimitsCommandLineArgs(t *testing.T) {
+	tests := []struct {
+		limits ContainerLimits
+		args   []string
+	}{
+		{ContainerLimits{Network: true}, nil},
+		{ContainerLimits{Cores: 2, RamMB: 512, PidsLimit: 100, User: "65534:65534", ReadOnlyRootfs: true}, []string{
+			"--cpu-period=100000", "--cpu-quota=200000", "--memory=512m", "--memory-swap=512m", "--pids-limit=100",
+			"--network=none", "--user=65534:65534", "--read-only", "--tmpfs=/tmp"}},
+	}
+	for _, test := range tests {
+		if args := test.limits.CommandLineArgs(); !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%+v: got %v, want %v", test.limits, args, test.args)
+		}
+	}
+}
+
+// setupContainerLimitsTest sets the [Docker] config of the container limits
+func setupContainerLimitsTest(user string, chown bool) (restore func()) {
+	restore = conftest.Save(&conf.DOCKER_DEFAULT_CORES, &conf.DOCKER_DEFAULT_RAM_MB, &conf.DOCKER_PIDS_LIMIT, &conf.DOCKER_RESTRICT_NETWORK,
+		&conf.DOCKER_READ_ONLY_ROOTFS, &conf.DOCKER_CONTAINER_USER, &conf.DOCKER_CHOWN_WORK_PATH)
+	conf.DOCKER_DEFAULT_CORES, conf.DOCKER_DEFAULT_RAM_MB, conf.DOCKER_PIDS_LIMIT = 0, 0, 100
+	conf.DOCKER_RESTRICT_NETWORK, conf.DOCKER_READ_ONLY_ROOTFS = true, true
+	conf.DOCKER_CONTAINER_USER, conf.DOCKER_CHOWN_WORK_PATH = user, chown
+	return
+}
@@ -0,0 +1,640 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
+	shock "github.com/MG-RAST/go-shock-client"
+)
+
+const containerLauncherScript = "awe_container_launcher.sh"
+
+// ContainerRuntime executes workunits that have a Dockerimage or DockerPull
+type ContainerRuntime interface {
+	Name() string
+	Available() bool
+	Run(workunit *core.Workunit) (pstats *core.WorkPerf, err error)
+}
+
+// containerRuntimeOrder is the order of preference used by container_runtimes=auto
+var containerRuntimeOrder = []string{core.ContainerRuntimeDocker, core.ContainerRuntimePodman, core.ContainerRuntimeApptainer, core.ContainerRuntimeSingularity}
+
+var containerRuntimes = map[string]ContainerRuntime{
+	core.ContainerRuntimeDocker:      dockerRuntime{},
+	core.ContainerRuntimePodman:      podmanRuntime{},
+	core.ContainerRuntimeApptainer:   apptainerRuntime{binary: core.ContainerRuntimeApptainer},
+	core.ContainerRuntimeSingularity: apptainerRuntime{binary: core.ContainerRuntimeSingularity},
+	core.ContainerRuntimeKubernetes:  kubernetesRuntime{},
+}
+
+// imageCacheLock serializes downloads and conversions of images in the image cache
+var imageCacheLock sync.Mutex
+
+// AvailableContainerRuntimes returns the configured runtimes that are installed on the worker,
+// in order of preference. The list is advertised to the server.
+func AvailableContainerRuntimes() (names []string) {
+	names = []string{}
+	if conf.USE_DOCKER == "no" {
+		return
+	}
+	if conf.KUBE_EXECUTOR {
+		names = append(names, core.ContainerRuntimeKubernetes)
+		return
+	}
+
+	configured := []string{}
+	for _, name := range strings.Split(conf.CONTAINER_RUNTIMES, ",") {
+		name = strings.TrimSpace(name)
+		if name == "auto" {
+			configured = append(configured, containerRuntimeOrder...)
+			continue
+		}
+		configured = append(configured, name)
+	}
+
+	for _, name := range configured {
+		if contains(names, name) {
+			continue
+		}
+		rt, ok := containerRuntimes[name]
+		if !ok {
+			continue
+		}
+		if name == core.ContainerRuntimeDocker && conf.BATCH_SYSTEM != "" {
+			// no docker daemon on the compute nodes of a batch system
+			continue
+		}
+		if rt.Available() {
+			names = append(names, name)
+		} else {
+			logger.Debug(1, "(AvailableContainerRuntimes) container runtime %s not found", name)
+		}
+	}
+	return
+}
+
+// GetContainerRuntime returns the preferred container runtime of the worker
+func GetContainerRuntime() (rt ContainerRuntime, err error) {
+	names := AvailableContainerRuntimes()
+	if len(names) == 0 {
+		err = fmt.Errorf("(GetContainerRuntime) no container runtime available (container_runtimes=%s)", conf.CONTAINER_RUNTIMES)
+		return
+	}
+	rt = containerRuntimes[names[0]]
+	return
+}
+
+func contains(list []string, item string) bool {
+	for _, x := range list {
+		if x == item {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerRuntime uses the docker daemon, either via the API or the docker binary
+type dockerRuntime struct{}
+
+func (r dockerRuntime) Name() string { return core.ContainerRuntimeDocker }
+
+func (r dockerRuntime) Available() bool {
+	if conf.DOCKER_BINARY != "API" {
+		_, err := exec.LookPath(conf.DOCKER_BINARY)
+		return err == nil
+	}
+	if strings.HasPrefix(conf.DOCKER_SOCKET, "unix://") {
+		_, err := os.Stat(strings.TrimPrefix(conf.DOCKER_SOCKET, "unix://"))
+		return err == nil
+	}
+	return true
+}
+
+func (r dockerRuntime) Run(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
+	return RunWorkunitDocker(workunit)
+}
+
+// podmanRuntime runs containers with podman, rootless if the worker is not root
+type podmanRuntime struct{}
+
+func (r podmanRuntime) Name() string { return core.ContainerRuntimePodman }
+
+func (r podmanRuntime) Available() bool {
+	_, err := exec.LookPath("podman")
+	return err == nil
+}
+
+func (r podmanRuntime) Run(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
+	pstats = new(core.WorkPerf)
+	pstats.MaxMemUsage = -1
+	pstats.MaxMemoryTotalRss = -1
+	pstats.MaxMemoryTotalSwap = -1
+	preparationStart := time.Now().Unix()
+
+	workPath, err := workunit.Path()
+	if err != nil {
+		err = fmt.Errorf("(podmanRuntime/Run) workunit.Path() returned: %s", err.Error())
+		return
+	}
+
+	image := ""
+	if workunit.Cmd.Dockerimage != "" {
+		var imageID, downloadURL string
+		imageID, downloadURL, err = shockImage(workunit)
+		if err != nil {
+			err = fmt.Errorf("(podmanRuntime/Run) shockImage returned: %s", err.Error())
+			return
+		}
+		_, _, xerr := RunCommand("podman", "image", "exists", imageID)
+		if xerr != nil {
+			var tarball string
+			tarball, err = cachedShockImage(imageID, downloadURL, workunit.Info.DataToken)
+			if err != nil {
+				err = fmt.Errorf("(podmanRuntime/Run) cachedShockImage returned: %s", err.Error())
+				return
+			}
+			_, stde, xerr := RunCommand("podman", "load", "--input", tarball)
+			if xerr != nil {
+				err = fmt.Errorf("(podmanRuntime/Run) podman load returned: %s (%s)", xerr.Error(), strings.TrimSpace(string(stde)))
+				return
+			}
+		}
+		image = imageID
+	} else {
+		image, err = normalizedImageName(workunit.Cmd.DockerPull)
+		if err != nil {
+			err = fmt.Errorf("(podmanRuntime/Run) normalizedImageName returned: %s", err.Error())
+			return
+		}
+		_, _, xerr := RunCommand("podman", "image", "exists", image)
+		if xerr != nil {
+			logger.Debug(1, "(podmanRuntime/Run) pulling image %s", image)
+			_, stde, xerr := RunCommand("podman", "pull", image)
+			if xerr != nil {
+				err = fmt.Errorf("(podmanRuntime/Run) podman pull %s returned: %s (%s)", image, xerr.Error(), strings.TrimSpace(string(stde)))
+				return
+			}
+		}
+	}
+
+	launcher, err := writeContainerLauncher(workunit, workPath, conf.DOCKER_WORK_DIR)
+	if err != nil {
+		err = fmt.Errorf("(podmanRuntime/Run) writeContainerLauncher returned: %s", err.Error())
+		return
+	}
+
+	environment, err := containerEnvironment(workunit)
+	if err != nil {
+		err = fmt.Errorf("(podmanRuntime/Run) containerEnvironment returned: %s", err.Error())
+		return
+	}
+
+	limits, err := GetContainerLimits(workunit, workPath)
+	if err != nil {
+		err = fmt.Errorf("(podmanRuntime/Run) GetContainerLimits returned: %s", err.Error())
+		return
+	}
+
+	containerName := "AWE_workunit_" + DockerizeName(conf.CLIENT_NAME)
+	RunCommand("podman", "rm", "--force", containerName) // left over from a previous workunit
+
+	args := []string{"run",
+		"--name=" + containerName,
+		"--workdir=" + conf.DOCKER_WORK_DIR,
+		"--volume=" + workPath + "/:" + conf.DOCKER_WORK_DIR,
+	}
+	if len(workunit.Predata) > 0 {
+		args = append(args, "--volume="+path.Join(conf.PREDATA_PATH, "predata")+"/:"+conf.DOCKER_WORKUNIT_PREDATA_DIR+":ro")
+	}
+	for _, envPair := range environment {
+		args = append(args, "--env="+envPair)
+	}
+	if os.Getuid() != 0 {
+		// rootless: map the worker user into the container so that it owns the outputs
+		args = append(args, "--userns=keep-id")
+	}
+	args = append(args, limits.CommandLineArgs()...)
+	args = append(args, image, launcher)
+
+	defer RunCommand("podman", "rm", "--force", containerName)
+
+	pstats.DockerPrep = time.Now().Unix() - preparationStart
+
+	status, usage, err := runContainerProcess(workunit, "podman", args, containerUsage(inspectCgroup("podman", containerName)))
+	setMemoryStats(pstats, usage)
+	if err != nil {
+		err = fmt.Errorf("(podmanRuntime/Run) %s", err.Error())
+		pstats = nil
+		return
+	}
+	if status != 0 {
+		stdo, _, xerr := RunCommand("podman", "inspect", "--format={{.State.OOMKilled}}", containerName)
+		if xerr == nil && strings.TrimSpace(string(stdo)) == "true" {
+			if workunit.WorkPerf != nil {
+				workunit.WorkPerf.OOMKilled = true
+			}
+			err = fmt.Errorf("(podmanRuntime/Run) container exceeded its memory limit (%d MiB) and was killed, status=%d", limits.RamMB, status)
+			pstats = nil
+			return
+		}
+		err = fmt.Errorf("(podmanRuntime/Run) container returned non-zero status=%d", status)
+		pstats = nil
+		return
+	}
+	return
+}
+
+// apptainerRuntime runs images converted to SIF with apptainer or singularity, no daemon required
+type apptainerRuntime struct {
+	binary string
+}
+
+func (r apptainerRuntime) Name() string { return r.binary }
+
+func (r apptainerRuntime) Available() bool {
+	_, err := exec.LookPath(r.binary)
+	return err == nil
+}
+
+func (r apptainerRuntime) Run(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
+	pstats = new(core.WorkPerf)
+	pstats.MaxMemUsage = -1
+	pstats.MaxMemoryTotalRss = -1
+	pstats.MaxMemoryTotalSwap = -1
+	preparationStart := time.Now().Unix()
+
+	workPath, err := workunit.Path()
+	if err != nil {
+		err = fmt.Errorf("(apptainerRuntime/Run) workunit.Path() returned: %s", err.Error())
+		return
+	}
+
+	sif, err := r.sifImage(workunit)
+	if err != nil {
+		err = fmt.Errorf("(apptainerRuntime/Run) sifImage returned: %s", err.Error())
+		return
+	}
+
+	launcher, err := writeContainerLauncher(workunit, workPath, conf.DOCKER_WORK_DIR)
+	if err != nil {
+		err = fmt.Errorf("(apptainerRuntime/Run) writeContainerLauncher returned: %s", err.Error())
+		return
+	}
+
+	environment, err := containerEnvironment(workunit)
+	if err != nil {
+		err = fmt.Errorf("(apptainerRuntime/Run) containerEnvironment returned: %s", err.Error())
+		return
+	}
+
+	limits, err := GetContainerLimits(workunit, workPath)
+	if err != nil {
+		err = fmt.Errorf("(apptainerRuntime/Run) GetContainerLimits returned: %s", err.Error())
+		return
+	}
+
+	// the container runs as the worker user, limits.User and ReadOnlyRootfs do not apply (SIF is read-only)
+	args := []string{"exec",
+		"--containall",
+		"--cleanenv",
+		"--pwd", conf.DOCKER_WORK_DIR,
+		"--bind", workPath + ":" + conf.DOCKER_WORK_DIR,
+	}
+	if len(workunit.Predata) > 0 {
+		args = append(args, "--bind", path.Join(conf.PREDATA_PATH, "predata")+":"+conf.DOCKER_WORKUNIT_PREDATA_DIR+":ro")
+	}
+	for _, envPair := range environment {
+		args = append(args, "--env", envPair)
+	}
+	if !limits.Network {
+		args = append(args, "--net", "--network", "none")
+	}
+	// resource limits require cgroups v2 with delegation for unprivileged users
+	if limits.Cores > 0 {
+		args = append(args, fmt.Sprintf("--cpus=%d", limits.Cores))
+	}
+	if limits.RamMB > 0 {
+		args = append(args, fmt.Sprintf("--memory=%dm", limits.RamMB))
+	}
+	if limits.PidsLimit > 0 {
+		args = append(args, fmt.Sprintf("--pids-limit=%d", limits.PidsLimit))
+	}
+	args = append(args, sif, launcher)
+
+	pstats.DockerPrep = time.Now().Unix() - preparationStart
+
+	status, usage, err := runContainerProcess(workunit, r.binary, args, nil)
+	setMemoryStats(pstats, usage)
+	if err != nil {
+		err = fmt.Errorf("(apptainerRuntime/Run) %s", err.Error())
+		pstats = nil
+		return
+	}
+	if status != 0 {
+		err = fmt.Errorf("(apptainerRuntime/Run) container returned non-zero status=%d", status)
+		pstats = nil
+		return
+	}
+	return
+}
+
+// sifImage returns the path of the SIF image of the workunit, converting and caching it if needed
+func (r apptainerRuntime) sifImage(workunit *core.Workunit) (sif string, err error) {
+
+	source := ""
+	name := ""
+	if workunit.Cmd.Dockerimage != "" {
+		var imageID, downloadURL string
+		imageID, downloadURL, err = shockImage(workunit)
+		if err != nil {
+			err = fmt.Errorf("(sifImage) shockImage returned: %s", err.Error())
+			return
+		}
+		name = DockerizeName(imageID)
+		sif = path.Join(conf.CONTAINER_IMAGE_CACHE, name+".sif")
+		if _, xerr := os.Stat(sif); xerr == nil {
+			return
+		}
+		var tarball string
+		tarball, err = cachedShockImage(imageID, downloadURL, workunit.Info.DataToken)
+		if err != nil {
+			err = fmt.Errorf("(sifImage) cachedShockImage returned: %s", err.Error())
+			return
+		}
+		defer os.Remove(tarball) // the SIF replaces the tarball in the cache
+		source = "docker-archive://" + tarball
+	} else {
+		var image string
+		image, err = normalizedImageName(workunit.Cmd.DockerPull)
+		if err != nil {
+			err = fmt.Errorf("(sifImage) normalizedImageName returned: %s", err.Error())
+			return
+		}
+		name = DockerizeName(image)
+		source = "docker://" + image
+	}
+
+	sif = path.Join(conf.CONTAINER_IMAGE_CACHE, name+".sif")
+
+	imageCacheLock.Lock()
+	defer imageCacheLock.Unlock()
+
+	if _, xerr := os.Stat(sif); xerr == nil {
+		logger.Debug(1, "(sifImage) using cached image %s", sif)
+		return
+	}
+
+	err = os.MkdirAll(conf.CONTAINER_IMAGE_CACHE, 0755)
+	if err != nil {
+		err = fmt.Errorf("(sifImage) could not create image cache: %s", err.Error())
+		return
+	}
+
+	logger.Info("converting image %s to %s", source, sif)
+	tmpSif := fmt.Sprintf("%s.%d.tmp", sif, os.Getpid())
+	_, stde, err := RunCommand(r.binary, "build", "--force", tmpSif, source)
+	if err != nil {
+		os.Remove(tmpSif)
+		err = fmt.Errorf("(sifImage) %s build returned: %s (%s)", r.binary, err.Error(), strings.TrimSpace(string(stde)))
+		return
+	}
+	err = os.Rename(tmpSif, sif)
+	if err != nil {
+		err = fmt.Errorf("(sifImage) os.Rename returned: %s", err.Error())
+	}
+	return
+}
+
+// normalizedImageName returns repository:tag of an image name
+func normalizedImageName(image string) (normalized string, err error) {
+	repository, tag, err := SplitDockerimageName(image)
+	if err != nil {
+		err = fmt.Errorf("(normalizedImageName) SplitDockerimageName returned: %s", err.Error())
+		return
+	}
+	normalized = repository + ":" + tag
+	return
+}
+
+// shockImage looks up the Dockerimage of the workunit in the Shock image repository
+func shockImage(workunit *core.Workunit) (imageID string, downloadURL string, err error) {
+	normalized, err := normalizedImageName(workunit.Cmd.Dockerimage)
+	if err != nil {
+		return
+	}
+
+	node, downloadURL, err := findDockerImageInShock(normalized, workunit.Info.DataToken)
+	if err != nil {
+		err = fmt.Errorf("(shockImage) findDockerImageInShock returned: %s", err.Error())
+		return
+	}
+
+	attributes, ok := node.Attributes.(map[string]interface{})
+	if !ok {
+		err = fmt.Errorf("(shockImage) could not type assert attributes of Dockerimage=%s", normalized)
+		return
+	}
+	imageID, ok = attributes["id"].(string)
+	if !ok || imageID == "" {
+		err = fmt.Errorf("(shockImage) id of Dockerimage=%s not found", normalized)
+		return
+	}
+	return
+}
+
+// cachedShockImage downloads the (gzipped) image tarball from Shock into the image cache
+func cachedShockImage(imageID string, downloadURL string, datatoken string) (tarball string, err error) {
+	imageCacheLock.Lock()
+	defer imageCacheLock.Unlock()
+
+	tarball = path.Join(conf.CONTAINER_IMAGE_CACHE, DockerizeName(imageID)+".tar")
+	if _, xerr := os.Stat(tarball); xerr == nil {
+		return
+	}
+
+	err = os.MkdirAll(conf.CONTAINER_IMAGE_CACHE, 0755)
+	if err != nil {
+		err = fmt.Errorf("(cachedShockImage) could not create image cache: %s", err.Error())
+		return
+	}
+
+	logger.Info("downloading image %s from %s", imageID, downloadURL)
+	stream, err := shock.FetchShockStream(downloadURL, datatoken)
+	if err != nil {
+		err = fmt.Errorf("(cachedShockImage) FetchShockStream returned: %s", err.Error())
+		return
+	}
+	defer stream.Close()
+
+	gr, err := gzip.NewReader(stream)
+	if err != nil {
+		err = fmt.Errorf("(cachedShockImage) gzip.NewReader returned: %s", err.Error())
+		return
+	}
+	defer gr.Close()
+
+	tmpTarball := fmt.Sprintf("%s.%d.tmp", tarball, os.Getpid())
+	f, err := os.Create(tmpTarball)
+	if err != nil {
+		err = fmt.Errorf("(cachedShockImage) os.Create returned: %s", err.Error())
+		return
+	}
+	_, err = io.Copy(f, gr)
+	f.Close()
+	if err != nil {
+		os.Remove(tmpTarball)
+		err = fmt.Errorf("(cachedShockImage) download of %s failed: %s", downloadURL, err.Error())
+		return
+	}
+	err = os.Rename(tmpTarball, tarball)
+	if err != nil {
+		err = fmt.Errorf("(cachedShockImage) os.Rename returned: %s", err.Error())
+	}
+	return
+}
+
+// writeContainerLauncher writes the script executed in the container, it redirects stdout and
+// stderr into the work directory like the docker wrapper script. containerWorkPath is the
+// work directory as seen in the container.
+func writeContainerLauncher(workunit *core.Workunit, workPath string, containerWorkPath string) (launcher string, err error) {
+
+	command := ""
+	if len(workunit.Cmd.CmdScript) > 0 {
+		wrapper := "awe_workunit_wrapper.sh"
+		err = ioutil.WriteFile(path.Join(workPath, wrapper), []byte("#!/bin/bash\n"+strings.Join(workunit.Cmd.CmdScript, "\n")+"\n"), 0755)
+		if err != nil {
+			err = fmt.Errorf("(writeContainerLauncher) error writing wrapper script: %s", err.Error())
+			return
+		}
+		command = path.Join(containerWorkPath, wrapper)
+	} else {
+		command = workunit.Cmd.Name + " " + strings.Join(workunit.Cmd.ParsedArgs, " ")
+	}
+
+	stdoutFile := path.Join(containerWorkPath, conf.STDOUT_FILENAME)
+	stderrFile := path.Join(containerWorkPath, conf.STDERR_FILENAME)
+	content := fmt.Sprintf("#!/bin/bash\n%s 2> %s 1> %s\n", command, stderrFile, stdoutFile)
+
+	err = ioutil.WriteFile(path.Join(workPath, containerLauncherScript), []byte(content), 0755)
+	if err != nil {
+		err = fmt.Errorf("(writeContainerLauncher) error writing launcher script: %s", err.Error())
+		return
+	}
+	launcher = path.Join(containerWorkPath, containerLauncherScript)
+	return
+}
+
+// containerEnvironment collects the public and private environment of the workunit and the proxy settings of the worker
+func containerEnvironment(workunit *core.Workunit) (environment []string, err error) {
+	for key, val := range workunit.Cmd.Environ.Public {
+		environment = append(environment, key+"="+val)
+	}
+	if workunit.Cmd.HasPrivateEnv {
+		var privateEnvs map[string]string
+		privateEnvs, err = FetchPrivateEnvByWorkId(workunit.ID)
+		if err != nil {
+			err = fmt.Errorf("(containerEnvironment) FetchPrivateEnvByWorkId returned: %s", err.Error())
+			return
+		}
+		for key, val := range privateEnvs {
+			environment = append(environment, key+"="+val)
+		}
+	}
+	for _, proxyVar := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		if val := os.Getenv(proxyVar); val != "" {
+			environment = append(environment, proxyVar+"="+val)
+		}
+	}
+	return
+}
+
+// runContainerProcess runs a daemonless container runtime in the foreground, the process is
+// killed on chankill. A batch gateway submits the runtime as a batch job instead. The usage of
+// the container is sampled with resolve, or of the runtime process and its children if nil.
+func runContainerProcess(workunit *core.Workunit, binary string, args []string, resolve usageResolver) (status int, usage *core.ResourceUsage, err error) {
+
+	if conf.BATCH_SYSTEM != "" {
+		status, err = runBatchProcess(workunit, binary, args, false)
+		return
+	}
+
+	err = workunit.CDworkpath()
+	if err != nil {
+		err = fmt.Errorf("(runContainerProcess) CDworkpath returned: %s", err.Error())
+		return
+	}
+
+	cmd := exec.Command(binary, args...)
+	var output bytes.Buffer // messages of the runtime itself, the tool writes into the work directory
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	logger.Debug(1, "(runContainerProcess) %s %s", binary, strings.Join(args, " "))
+	logger.Event(event.WORK_START, "workid="+workunit.ID,
+		"cmd="+binary,
+		fmt.Sprintf("args=%v", args))
+
+	err = cmd.Start()
+	if err != nil {
+		err = fmt.Errorf("(runContainerProcess) start of %s failed: %s", binary, err.Error())
+		return
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	if resolve == nil {
+		resolve = processUsage(cmd.Process.Pid)
+	}
+	stopUsage := startUsageSampler(workunit, resolve)
+	defer stopUsage()
+
+	select {
+	case <-chankill:
+		if kerr := cmd.Process.Kill(); kerr != nil {
+			logger.Error("(runContainerProcess) failed to kill %s: %s", binary, kerr.Error())
+		}
+		<-done // allow goroutine to exit
+		err = errors.New("process killed as requested from chankill")
+		return
+	case err = <-done:
+	}
+	usage = stopUsage()
+
+	if output.Len() > 0 {
+		logger.Debug(1, "(runContainerProcess) %s output: %s", binary, output.String())
+	}
+
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if !ok {
+			err = fmt.Errorf("(runContainerProcess) %s returned: %s", binary, err.Error())
+			return
+		}
+		err = nil
+		status = 1
+		if waitStatus, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+			status = waitStatus.ExitStatus()
+		}
+	}
+	workunit.ExitStatus = status
+	logger.Event(event.WORK_END, "workid="+workunit.ID)
+	return
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+)
+
+func TestAvailableContainerRuntimes(t *testing.T) {
+	defer conftest.Save(&conf.USE_DOCKER, &conf.KUBE_EXECUTOR, &conf.CONTAINER_RUNTIMES, &conf.DOCKER_BINARY, &conf.BATCH_SYSTEM)()
+
+	// only the executables in PATH are installed
+	bin := t.TempDir()
+	for _, name := range []string{"docker", "podman", "singularity"} {
+		if err := ioutil.WriteFile(path.Join(bin, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin)
+
+	tests := []struct {
+		useDocker string
+		kube      bool
+		runtimes  string
+		batch     string
+		want      []string
+	}{
+		{"yes", false, "auto", "", []string{"docker", "podman", "singularity"}},
+		{"allow", false, "singularity, podman, singularity", "", []string{"singularity", "podman"}},
+		{"yes", false, "apptainer,bogus", "", []string{}},
+		{"yes", false, "auto", "slurm", []string{"podman", "singularity"}},
+		{"no", false, "auto", "", []string{}},
+		{"yes", true, "auto", "", []string{"kubernetes"}},
+	}
+	conf.DOCKER_BINARY = "docker"
+	for _, test := range tests {
+		conf.USE_DOCKER, conf.KUBE_EXECUTOR, conf.CONTAINER_RUNTIMES, conf.BATCH_SYSTEM = test.useDocker, test.kube, test.runtimes, test.batch
+		if names := AvailableContainerRuntimes(); !reflect.DeepEqual(names, test.want) {
+			t.Errorf("%+v: got %v, want %v", test, names, test.want)
+		}
+	}
+
+	conf.USE_DOCKER, conf.KUBE_EXECUTOR, conf.CONTAINER_RUNTIMES, conf.BATCH_SYSTEM = "yes", false, "podman,docker", ""
+	rt, err := GetContainerRuntime()
+	if err != nil || rt.Name() != "podman" {
+		t.Errorf("got %v %v, want podman", rt, err)
+	}
+	conf.CONTAINER_RUNTIMES = "apptainer"
+	if _, err = GetContainerRuntime(); err == nil {
+		t.Errorf("expected an error without a container runtime")
+	}
+}
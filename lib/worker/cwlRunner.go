@@ -0,0 +1,1222 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+const (
+	cwlOutdir         = "cwl_outdir"             // working directory of the tool, relative to the workunit path
+	cwlTmpdir         = "cwl_tmpdir"             // runtime.tmpdir, relative to the workunit path
+	cwlCommandScript  = "awe_cwl_tool.sh"        // script used for direct (non-docker) execution
+	cwlDefaultStdout  = "cwl.stdout.txt"         // used for outputs of type stdout if the tool does not name the file
+	cwlDefaultStderr  = "cwl.stderr.txt"         // used for outputs of type stderr if the tool does not name the file
+	cwlOutputJSON     = "cwl.output.json"        // if the tool writes this file, it replaces output collection
+	cwlLoadContentMax = 64 * 1024                // loadContents reads at most 64KiB
+	cwlPermanentFail  = "awe_cwl_permanent_fail" // created in the tmpdir if the exit code is a permanentFailCode
+)
+
+// cwlArg is a single element of the tool command line
+type cwlArg struct {
+	Value string
+	Quote bool
+}
+
+// cwlBinding is the rendered form of an argument or input binding, used for sorting
+type cwlBinding struct {
+	Position int
+	IsInput  bool // arguments come before inputs at the same position
+	Index    int
+	Name     string
+	Args     []cwlArg
+}
+
+// cwlToolRun holds the state of a natively executed CommandLineTool
+type cwlToolRun struct {
+	Tool        *cwl.CommandLineTool
+	WorkPath    string // workunit path on the host
+	BasePath    string // workunit path as seen by the tool, differs from WorkPath inside a container
+	OutdirHost  string
+	Outdir      string
+	Tmpdir      string
+	DockerImage string
+	ShellQuote  bool // false if ShellCommandRequirement is present
+	EnvDef      []cwl.EnvironmentDef
+	Listing     interface{}
+	Inputs      map[string]interface{}
+	Runtime     map[string]interface{}
+	Stdin       string
+	Stdout      string
+	Stderr      string
+}
+
+// RunCWLWorkunit executes the CWL tool of the workunit natively (without cwl-runner)
+func RunCWLWorkunit(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
+
+	var workPath string
+	workPath, err = workunit.Path()
+	if err != nil {
+		err = fmt.Errorf("(RunCWLWorkunit) workunit.Path() returned: %s", err.Error())
+		return
+	}
+
+	var results map[string]interface{}
+
+	switch workunit.CWLWorkunit.Tool.(type) {
+	case *cwl.ExpressionTool:
+		tool := workunit.CWLWorkunit.Tool.(*cwl.ExpressionTool)
+		pstats = &core.WorkPerf{MaxMemUsage: -1, MaxMemoryTotalRss: -1, MaxMemoryTotalSwap: -1}
+		results, err = runCWLExpressionTool(workunit, tool, workPath)
+		if err != nil {
+			err = fmt.Errorf("(RunCWLWorkunit) runCWLExpressionTool returned: %s", err.Error())
+			return
+		}
+	case *cwl.CommandLineTool:
+		tool := workunit.CWLWorkunit.Tool.(*cwl.CommandLineTool)
+		var run *cwlToolRun
+		run, err = newCWLToolRun(workunit, tool, workPath)
+		if err != nil {
+			err = fmt.Errorf("(RunCWLWorkunit) newCWLToolRun returned: %s", err.Error())
+			return
+		}
+		pstats, err = run.execute(workunit)
+		if err != nil {
+			err = fmt.Errorf("(RunCWLWorkunit) execute returned: %s", err.Error())
+			return
+		}
+		results, err = run.collectOutputs()
+		if err != nil {
+			err = fmt.Errorf("(RunCWLWorkunit) collectOutputs returned: %s", err.Error())
+			return
+		}
+	default:
+		err = fmt.Errorf("(RunCWLWorkunit) tool type %T not supported", workunit.CWLWorkunit.Tool)
+		return
+	}
+
+	// normalize to the JSON representation cwl-runner would have printed
+	var resultBytes []byte
+	resultBytes, err = json.Marshal(results)
+	if err != nil {
+		err = fmt.Errorf("(RunCWLWorkunit) json.Marshal returned: %s", err.Error())
+		return
+	}
+	var toolResults interface{}
+	err = json.Unmarshal(resultBytes, &toolResults)
+	if err != nil {
+		err = fmt.Errorf("(RunCWLWorkunit) json.Unmarshal returned: %s", err.Error())
+		return
+	}
+
+	resultDoc, err := cwl.NewJobDocument(toolResults, nil)
+	if err != nil {
+		err = fmt.Errorf("(RunCWLWorkunit) NewJobDocument returned: %s", err.Error())
+		return
+	}
+
+	if conf.PRINT_APP_MSG {
+		stderrFile := path.Join(workPath, conf.STDERR_FILENAME)
+		fi, serr := os.Stat(stderrFile)
+		if serr == nil && fi.Size() > 0 {
+			stderrCWLFile := cwl.NewFile()
+			stderrCWLFile.Path = stderrFile
+			resultDoc = resultDoc.Add(conf.STDERR_FILENAME, stderrCWLFile)
+		}
+	}
+
+	workunit.CWLWorkunit.Outputs = resultDoc
+	return
+}
+
+// runCWLExpressionTool evaluates the expression of an ExpressionTool, no process is started
+func runCWLExpressionTool(workunit *core.Workunit, tool *cwl.ExpressionTool, workPath string) (results map[string]interface{}, err error) {
+
+	var inputs map[string]interface{}
+	inputs, err = cwlInputObject(workunit.CWLWorkunit.JobInput, nil, workPath)
+	if err != nil {
+		err = fmt.Errorf("(runCWLExpressionTool) cwlInputObject returned: %s", err.Error())
+		return
+	}
+
+	cores, ram := cwlRuntimeResources(workunit)
+	runtimeObj := map[string]interface{}{
+		"outdir": workPath,
+		"tmpdir": workPath,
+		"cores":  cores,
+		"ram":    ram,
+	}
+
+	var value interface{}
+	value, err = tool.Expression.EvaluateRaw(nil, inputs, runtimeObj)
+	if err != nil {
+		err = fmt.Errorf("(runCWLExpressionTool) EvaluateRaw returned: %s", err.Error())
+		return
+	}
+
+	var ok bool
+	results, ok = value.(map[string]interface{})
+	if !ok {
+		err = fmt.Errorf("(runCWLExpressionTool) expression did not return an object (got %T)", value)
+		return
+	}
+
+	for key, elem := range results {
+		if elem == nil {
+			delete(results, key)
+			continue
+		}
+		results[key] = cwlFixPaths(elem, workPath, workPath, workPath)
+	}
+
+	return
+}
+
+// newCWLToolRun evaluates requirements, inputs and runtime of a CommandLineTool
+func newCWLToolRun(workunit *core.Workunit, tool *cwl.CommandLineTool, workPath string) (run *cwlToolRun, err error) {
+
+	run = &cwlToolRun{Tool: tool, WorkPath: workPath, BasePath: workPath, ShellQuote: true}
+
+	cores, ram := cwlRuntimeResources(workunit)
+
+	// hints first, requirements take precedence
+	requirements := []cwl.Requirement{}
+	requirements = append(requirements, tool.Hints...)
+	requirements = append(requirements, tool.Requirements...)
+
+	for _, requirement := range requirements {
+		switch requirement.(type) {
+		case *cwl.DockerRequirement:
+			r := requirement.(*cwl.DockerRequirement)
+			image := r.DockerPull
+			if image == "" {
+				image = r.DockerImageId
+			}
+			if image == "" {
+				err = fmt.Errorf("(newCWLToolRun) DockerRequirement without dockerPull or dockerImageId is not supported")
+				return
+			}
+			run.DockerImage = image
+		case *cwl.ShellCommandRequirement:
+			run.ShellQuote = false
+		case *cwl.EnvVarRequirement:
+			r := requirement.(*cwl.EnvVarRequirement)
+			run.EnvDef = append(run.EnvDef, r.EnvDef...)
+		case *cwl.InitialWorkDirRequirement:
+			r := requirement.(*cwl.InitialWorkDirRequirement)
+			run.Listing = r.Listing
+		}
+	}
+
+	if run.DockerImage != "" {
+		if conf.USE_DOCKER == "no" {
+			err = fmt.Errorf("(newCWLToolRun) tool requires docker image %s, but docker is disabled on this worker", run.DockerImage)
+			return
+		}
+		run.BasePath = conf.DOCKER_WORK_DIR
+	}
+
+	run.OutdirHost = path.Join(workPath, cwlOutdir)
+	run.Outdir = path.Join(run.BasePath, cwlOutdir)
+	run.Tmpdir = path.Join(run.BasePath, cwlTmpdir)
+
+	for _, dir := range []string{run.OutdirHost, path.Join(workPath, cwlTmpdir)} {
+		err = os.MkdirAll(dir, 0777)
+		if err != nil {
+			err = fmt.Errorf("(newCWLToolRun) os.MkdirAll returned: %s", err.Error())
+			return
+		}
+	}
+
+	run.Inputs, err = cwlInputObject(workunit.CWLWorkunit.JobInput, tool.Inputs, run.BasePath)
+	if err != nil {
+		err = fmt.Errorf("(newCWLToolRun) cwlInputObject returned: %s", err.Error())
+		return
+	}
+
+	run.Runtime = map[string]interface{}{
+		"outdir": run.Outdir,
+		"tmpdir": run.Tmpdir,
+		"cores":  cores,
+		"ram":    ram,
+	}
+
+	run.Stdin, err = run.evaluateString(tool.Stdin)
+	if err != nil {
+		err = fmt.Errorf("(newCWLToolRun) stdin: %s", err.Error())
+		return
+	}
+	run.Stdout, err = run.evaluateString(tool.Stdout)
+	if err != nil {
+		err = fmt.Errorf("(newCWLToolRun) stdout: %s", err.Error())
+		return
+	}
+	run.Stderr, err = run.evaluateString(tool.Stderr)
+	if err != nil {
+		err = fmt.Errorf("(newCWLToolRun) stderr: %s", err.Error())
+		return
+	}
+
+	for _, output := range tool.Outputs {
+		typeName, _, _ := cwlOutputType(output.Type)
+		if typeName == string(cwl.CWLStdout) && run.Stdout == "" {
+			run.Stdout = cwlDefaultStdout
+		}
+		if typeName == string(cwl.CWLStderr) && run.Stderr == "" {
+			run.Stderr = cwlDefaultStderr
+		}
+	}
+
+	return
+}
+
+// execute stages the working directory and runs the command line, in docker if the tool requires it
+func (run *cwlToolRun) execute(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
+
+	err = run.stageInitialWorkDir()
+	if err != nil {
+		err = fmt.Errorf("(execute) stageInitialWorkDir returned: %s", err.Error())
+		return
+	}
+
+	var script []string
+	script, err = run.commandScript()
+	if err != nil {
+		err = fmt.Errorf("(execute) commandScript returned: %s", err.Error())
+		return
+	}
+
+	logger.Debug(1, "(execute) CWL command script:\n%s", strings.Join(script, "\n"))
+
+	if run.DockerImage != "" {
+		workunit.Cmd.DockerPull = run.DockerImage
+		workunit.Cmd.CmdScript = script
+		var rt ContainerRuntime
+		rt, err = GetContainerRuntime()
+		if err != nil {
+			err = fmt.Errorf("(execute) GetContainerRuntime returned: %s", err.Error())
+			return
+		}
+		pstats, err = rt.Run(workunit)
+		if err != nil {
+			err = fmt.Errorf("(execute) %s runtime returned: %s", rt.Name(), err.Error())
+		}
+		return
+	}
+
+	scriptFile := path.Join(run.WorkPath, cwlCommandScript)
+	err = ioutil.WriteFile(scriptFile, []byte("#!/bin/bash\n"+strings.Join(script, "\n")+"\n"), 0755)
+	if err != nil {
+		err = fmt.Errorf("(execute) error writing command script: %s", err.Error())
+		return
+	}
+
+	workunit.Cmd.Name = "/bin/bash"
+	workunit.Cmd.ArgsArray = []string{scriptFile}
+	if conf.KUBE_EXECUTOR {
+		pstats, err = RunWorkunitKubernetes(workunit)
+		if err != nil {
+			err = fmt.Errorf("(execute) RunWorkunitKubernetes returned: %s", err.Error())
+		}
+		return
+	}
+	if conf.BATCH_SYSTEM != "" {
+		pstats, err = RunWorkunitBatch(workunit)
+		if err != nil {
+			err = fmt.Errorf("(execute) RunWorkunitBatch returned: %s", err.Error())
+		}
+		return
+	}
+	pstats, _, err = RunWorkunitDirect(workunit)
+	if err != nil {
+		err = fmt.Errorf("(execute) RunWorkunitDirect returned: %s", err.Error())
+	}
+	return
+}
+
+// commandScript returns the shell lines that run the tool, including redirection and exit code mapping
+func (run *cwlToolRun) commandScript() (script []string, err error) {
+
+	var args []cwlArg
+	args, err = run.commandLine()
+	if err != nil {
+		err = fmt.Errorf("(commandScript) commandLine returned: %s", err.Error())
+		return
+	}
+	if len(args) == 0 {
+		err = fmt.Errorf("(commandScript) command line is empty")
+		return
+	}
+
+	script = []string{
+		"cd " + shellQuote(run.Outdir) + " || exit 1",
+		"rm -f " + shellQuote(path.Join(run.Tmpdir, cwlPermanentFail)),
+		"export HOME=" + shellQuote(run.Outdir) + " TMPDIR=" + shellQuote(run.Tmpdir),
+	}
+
+	for _, def := range run.EnvDef {
+		var value string
+		value, err = run.evaluateString(def.EnvValue)
+		if err != nil {
+			err = fmt.Errorf("(commandScript) envValue of %s: %s", def.EnvName, err.Error())
+			return
+		}
+		script = append(script, "export "+def.EnvName+"="+shellQuote(value))
+	}
+
+	command := []string{}
+	for _, arg := range args {
+		if arg.Quote {
+			command = append(command, shellQuote(arg.Value))
+		} else {
+			command = append(command, arg.Value)
+		}
+	}
+	if run.Stdin != "" {
+		command = append(command, "<", shellQuote(run.Stdin))
+	}
+	if run.Stdout != "" {
+		command = append(command, ">", shellQuote(run.Stdout))
+	}
+	if run.Stderr != "" {
+		command = append(command, "2>", shellQuote(run.Stderr))
+	}
+	script = append(script, strings.Join(command, " "))
+
+	successCodes := run.Tool.SuccessCodes
+	if len(successCodes) == 0 {
+		successCodes = []int{0}
+	}
+
+	script = append(script,
+		"rc=$?",
+		"case \" "+joinInts(successCodes)+" \" in *\" $rc \"*) exit 0 ;; esac",
+	)
+	if len(run.Tool.PermanentFailCodes) > 0 {
+		// the exit code of the tool is kept, see cwlPermanentFailure
+		script = append(script, "case \" "+joinInts(run.Tool.PermanentFailCodes)+" \" in *\" $rc \"*) touch "+shellQuote(path.Join(run.Tmpdir, cwlPermanentFail))+" ;; esac")
+	}
+	script = append(script,
+		"if [ $rc -eq 0 ]; then exit 1; fi",
+		"exit $rc",
+	)
+	return
+}
+
+// cwlRuntimeResources runtime.cores and runtime.ram, the resources of the container (WorkunitResources).
+// Without a ResourceRequirement, all cores of the worker and 1024 MiB are used, like cwltool.
+func cwlRuntimeResources(workunit *core.Workunit) (cores int, ram int) {
+	cores, ram = WorkunitResources(workunit)
+	if cores == 0 {
+		cores = runtime.NumCPU()
+	}
+	if ram == 0 {
+		ram = 1024
+	}
+	return
+}
+
+// cwlPermanentFailure reports whether the tool exited with one of its permanentFailCodes
+func cwlPermanentFailure(workunit *core.Workunit) bool {
+	workPath, err := workunit.Path()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path.Join(workPath, cwlTmpdir, cwlPermanentFail))
+	return err == nil
+}
+
+// commandLine builds the command line from baseCommand, arguments and input bindings
+func (run *cwlToolRun) commandLine() (args []cwlArg, err error) {
+
+	for _, base := range run.Tool.BaseCommand {
+		args = append(args, cwlArg{Value: base, Quote: true})
+	}
+
+	bindings := []cwlBinding{}
+
+	for i := range run.Tool.Arguments {
+		argument := &run.Tool.Arguments[i]
+		if argument.ValueFrom == nil {
+			continue
+		}
+		binding := cwlBinding{Position: bindingPosition(argument), Index: i}
+		binding.Args, err = run.bindValue(argument, nil)
+		if err != nil {
+			err = fmt.Errorf("(commandLine) argument %d: %s", i, err.Error())
+			return
+		}
+		bindings = append(bindings, binding)
+	}
+
+	for _, input := range run.Tool.Inputs {
+		if input.InputBinding == nil {
+			continue
+		}
+		name := cwlShortID(input.ID)
+		binding := cwlBinding{Position: bindingPosition(input.InputBinding), IsInput: true, Name: name}
+		binding.Args, err = run.bindValue(input.InputBinding, run.Inputs[name])
+		if err != nil {
+			err = fmt.Errorf("(commandLine) input %s: %s", name, err.Error())
+			return
+		}
+		bindings = append(bindings, binding)
+	}
+
+	sort.SliceStable(bindings, func(i, j int) bool {
+		a, b := bindings[i], bindings[j]
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		if a.IsInput != b.IsInput {
+			return !a.IsInput
+		}
+		if a.IsInput {
+			return a.Name < b.Name
+		}
+		return a.Index < b.Index
+	})
+
+	for _, binding := range bindings {
+		args = append(args, binding.Args...)
+	}
+	return
+}
+
+// bindValue renders a value according to its CommandLineBinding
+func (run *cwlToolRun) bindValue(binding *cwl.CommandLineBinding, value interface{}) (args []cwlArg, err error) {
+
+	if binding.LoadContents {
+		value, err = cwlLoadContents(value, run.BasePath, run.WorkPath)
+		if err != nil {
+			return
+		}
+	}
+
+	if binding.ValueFrom != nil {
+		value, err = binding.ValueFrom.EvaluateRaw(value, run.Inputs, run.Runtime)
+		if err != nil {
+			err = fmt.Errorf("(bindValue) valueFrom: %s", err.Error())
+			return
+		}
+	}
+
+	quote := run.ShellQuote || binding.ShellQuote == nil || *binding.ShellQuote
+	separate := binding.Separate == nil || *binding.Separate
+
+	withPrefix := func(str string) []cwlArg {
+		if binding.Prefix == "" {
+			return []cwlArg{{Value: str, Quote: quote}}
+		}
+		if separate {
+			return []cwlArg{{Value: binding.Prefix, Quote: quote}, {Value: str, Quote: quote}}
+		}
+		return []cwlArg{{Value: binding.Prefix + str, Quote: quote}}
+	}
+
+	switch value.(type) {
+	case nil:
+	case bool:
+		if value.(bool) && binding.Prefix != "" {
+			args = []cwlArg{{Value: binding.Prefix, Quote: quote}}
+		}
+	case []interface{}:
+		array := value.([]interface{})
+		if len(array) == 0 {
+			return
+		}
+		items := []string{}
+		for _, item := range array {
+			var str string
+			str, err = cwlValueString(item)
+			if err != nil {
+				return
+			}
+			items = append(items, str)
+		}
+		if binding.ItemSeparator != "" {
+			args = withPrefix(strings.Join(items, binding.ItemSeparator))
+			return
+		}
+		if binding.Prefix != "" {
+			args = append(args, cwlArg{Value: binding.Prefix, Quote: quote})
+		}
+		for _, item := range items {
+			args = append(args, cwlArg{Value: item, Quote: quote})
+		}
+	default:
+		var str string
+		str, err = cwlValueString(value)
+		if err != nil {
+			return
+		}
+		args = withPrefix(str)
+	}
+	return
+}
+
+// stageInitialWorkDir creates the entries of the InitialWorkDirRequirement in the output directory
+func (run *cwlToolRun) stageInitialWorkDir() (err error) {
+
+	if run.Listing == nil {
+		return
+	}
+
+	var listing []interface{}
+	switch run.Listing.(type) {
+	case []cwl.CWLObject:
+		for _, obj := range run.Listing.([]cwl.CWLObject) {
+			listing = append(listing, obj)
+		}
+	case []interface{}:
+		listing = run.Listing.([]interface{})
+	default:
+		listing = []interface{}{run.Listing}
+	}
+
+	for _, entry := range listing {
+		switch entry.(type) {
+		case *cwl.Dirent:
+			dirent := entry.(*cwl.Dirent)
+			err = run.stageDirent(dirent)
+			if err != nil {
+				return
+			}
+		case *cwl.File, *cwl.Directory, *cwl.String, cwl.Expression, string:
+			var value interface{}
+			value, err = run.evaluateListingEntry(entry)
+			if err != nil {
+				return
+			}
+			err = run.stageValue(value, "")
+			if err != nil {
+				return
+			}
+		default:
+			err = fmt.Errorf("(stageInitialWorkDir) listing entry of type %T not supported", entry)
+			return
+		}
+	}
+	return
+}
+
+// stageDirent writes or links a single Dirent
+func (run *cwlToolRun) stageDirent(dirent *cwl.Dirent) (err error) {
+
+	var entryname string
+	entryname, err = run.evaluateString(dirent.Entryname)
+	if err != nil {
+		err = fmt.Errorf("(stageDirent) entryname: %s", err.Error())
+		return
+	}
+
+	var value interface{}
+	value, err = run.evaluateListingEntry(dirent.Entry)
+	if err != nil {
+		err = fmt.Errorf("(stageDirent) entry: %s", err.Error())
+		return
+	}
+
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		err = run.stageValue(value, entryname)
+		return
+	}
+
+	if entryname == "" {
+		err = fmt.Errorf("(stageDirent) entryname missing for literal entry")
+		return
+	}
+
+	var contents string
+	contents, err = cwlValueString(value)
+	if err != nil {
+		return
+	}
+
+	target := path.Join(run.OutdirHost, entryname)
+	err = os.MkdirAll(path.Dir(target), 0777)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(target, []byte(contents), 0666)
+	if err != nil {
+		err = fmt.Errorf("(stageDirent) error writing %s: %s", target, err.Error())
+	}
+	return
+}
+
+// stageValue makes File and Directory objects available in the output directory
+func (run *cwlToolRun) stageValue(value interface{}, entryname string) (err error) {
+
+	switch value.(type) {
+	case nil:
+		return
+	case []interface{}:
+		for _, elem := range value.([]interface{}) {
+			err = run.stageValue(elem, "")
+			if err != nil {
+				return
+			}
+		}
+		return
+	case map[string]interface{}:
+	default:
+		err = fmt.Errorf("(stageValue) type %T cannot be staged", value)
+		return
+	}
+
+	obj := value.(map[string]interface{})
+	class, _ := obj["class"].(string)
+	if class != "File" && class != "Directory" {
+		err = fmt.Errorf("(stageValue) class %s cannot be staged", class)
+		return
+	}
+
+	if entryname == "" {
+		entryname, _ = obj["basename"].(string)
+	}
+
+	target := path.Join(run.OutdirHost, entryname)
+
+	contents, hasContents := obj["contents"].(string)
+	objPath, _ := obj["path"].(string)
+	if objPath == "" && hasContents {
+		err = ioutil.WriteFile(target, []byte(contents), 0666)
+		return
+	}
+	if objPath == "" {
+		err = fmt.Errorf("(stageValue) %s has no path", entryname)
+		return
+	}
+
+	source := cwlHostPath(objPath, run.BasePath, run.WorkPath)
+	if source == target {
+		return
+	}
+
+	err = os.MkdirAll(path.Dir(target), 0777)
+	if err != nil {
+		return
+	}
+
+	if class == "Directory" {
+		err = os.Symlink(source, target)
+		return
+	}
+
+	if conf.NO_SYMLINK || run.DockerImage != "" {
+		// symlinks to files outside of the work directory do not resolve in a container
+		err = copyFile(source, target)
+		return
+	}
+	err = os.Symlink(source, target)
+	return
+}
+
+// evaluateListingEntry turns a listing entry into a plain value
+func (run *cwlToolRun) evaluateListingEntry(entry interface{}) (value interface{}, err error) {
+
+	switch entry.(type) {
+	case nil:
+		return
+	case cwl.Expression:
+		value, err = entry.(cwl.Expression).EvaluateRaw(nil, run.Inputs, run.Runtime)
+		return
+	case *cwl.Expression:
+		value, err = entry.(*cwl.Expression).EvaluateRaw(nil, run.Inputs, run.Runtime)
+		return
+	case string:
+		value, err = cwl.Expression(entry.(string)).EvaluateRaw(nil, run.Inputs, run.Runtime)
+		return
+	case *cwl.String:
+		value, err = cwl.Expression(string(*entry.(*cwl.String))).EvaluateRaw(nil, run.Inputs, run.Runtime)
+		return
+	}
+
+	var entryBytes []byte
+	entryBytes, err = json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(entryBytes, &value)
+	if err != nil {
+		return
+	}
+	value = cwlFixPaths(value, run.BasePath, run.BasePath, run.WorkPath)
+	return
+}
+
+// evaluateString evaluates a string or Expression field, File results are converted into their path
+func (run *cwlToolRun) evaluateString(field interface{}) (result string, err error) {
+
+	var expr cwl.Expression
+	switch field.(type) {
+	case nil:
+		return
+	case string:
+		expr = cwl.Expression(field.(string))
+	case cwl.Expression:
+		expr = field.(cwl.Expression)
+	case *cwl.Expression:
+		expr = *field.(*cwl.Expression)
+	case *cwl.String:
+		expr = cwl.Expression(string(*field.(*cwl.String)))
+	default:
+		result, err = cwlValueString(field)
+		return
+	}
+
+	if expr == "" {
+		return
+	}
+
+	var value interface{}
+	value, err = expr.EvaluateRaw(nil, run.Inputs, run.Runtime)
+	if err != nil {
+		return
+	}
+	result, err = cwlValueString(value)
+	return
+}
+
+// collectOutputs gathers the output object of the tool
+func (run *cwlToolRun) collectOutputs() (results map[string]interface{}, err error) {
+
+	results = map[string]interface{}{}
+
+	outputJSONFile := path.Join(run.OutdirHost, cwlOutputJSON)
+	outputJSON, rerr := ioutil.ReadFile(outputJSONFile)
+	if rerr == nil {
+		err = json.Unmarshal(outputJSON, &results)
+		if err != nil {
+			err = fmt.Errorf("(collectOutputs) could not parse %s: %s", cwlOutputJSON, err.Error())
+			return
+		}
+		for key, value := range results {
+			if value == nil {
+				delete(results, key)
+				continue
+			}
+			results[key] = cwlFixPaths(value, run.Outdir, run.BasePath, run.WorkPath)
+		}
+		return
+	}
+
+	for _, output := range run.Tool.Outputs {
+		name := cwlShortID(output.Id)
+		typeName, optional, isArray := cwlOutputType(output.Type)
+
+		var value interface{}
+		switch typeName {
+		case string(cwl.CWLStdout):
+			value, err = cwlFileObject(path.Join(run.OutdirHost, run.Stdout))
+		case string(cwl.CWLStderr):
+			value, err = cwlFileObject(path.Join(run.OutdirHost, run.Stderr))
+		default:
+			if output.OutputBinding != nil {
+				value, err = run.evaluateOutputBinding(output.OutputBinding, typeName, isArray)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("(collectOutputs) output %s: %s", name, err.Error())
+			return
+		}
+
+		if value == nil {
+			if !optional {
+				err = fmt.Errorf("(collectOutputs) output %s not found", name)
+				return
+			}
+			continue
+		}
+		results[name] = value
+	}
+	return
+}
+
+// evaluateOutputBinding applies glob, loadContents and outputEval
+func (run *cwlToolRun) evaluateOutputBinding(binding *cwl.CommandOutputBinding, typeName string, isArray bool) (value interface{}, err error) {
+
+	files := []interface{}{}
+
+	for _, glob := range binding.Glob {
+		var patterns interface{}
+		patterns, err = glob.EvaluateRaw(nil, run.Inputs, run.Runtime)
+		if err != nil {
+			err = fmt.Errorf("(evaluateOutputBinding) glob: %s", err.Error())
+			return
+		}
+
+		patternArray := []interface{}{}
+		switch patterns.(type) {
+		case []interface{}:
+			patternArray = patterns.([]interface{})
+		case nil:
+		default:
+			patternArray = append(patternArray, patterns)
+		}
+
+		for _, pattern := range patternArray {
+			var patternStr string
+			patternStr, err = cwlValueString(pattern)
+			if err != nil {
+				return
+			}
+			if path.IsAbs(patternStr) {
+				patternStr = cwlHostPath(patternStr, run.BasePath, run.WorkPath)
+			} else {
+				patternStr = path.Join(run.OutdirHost, patternStr)
+			}
+
+			var matches []string
+			matches, err = filepath.Glob(patternStr)
+			if err != nil {
+				err = fmt.Errorf("(evaluateOutputBinding) filepath.Glob returned: %s", err.Error())
+				return
+			}
+			sort.Strings(matches)
+
+			for _, match := range matches {
+				var obj map[string]interface{}
+				obj, err = cwlFileObject(match)
+				if err != nil {
+					return
+				}
+				if binding.LoadContents && obj["class"] == "File" {
+					var contents string
+					contents, err = readContents(match)
+					if err != nil {
+						return
+					}
+					obj["contents"] = contents
+				}
+				files = append(files, obj)
+			}
+		}
+	}
+
+	if binding.OutputEval != nil {
+		value, err = binding.OutputEval.EvaluateRaw(files, run.Inputs, run.Runtime)
+		if err != nil {
+			err = fmt.Errorf("(evaluateOutputBinding) outputEval: %s", err.Error())
+			return
+		}
+		value = cwlFixPaths(value, run.Outdir, run.BasePath, run.WorkPath)
+		return
+	}
+
+	switch typeName {
+	case string(cwl.CWLFile), string(cwl.CWLDirectory):
+		if isArray {
+			value = files
+			return
+		}
+		if len(files) > 0 {
+			value = files[0]
+		}
+	}
+	return
+}
+
+// cwlInputObject converts the job document into the "inputs" object used by expressions and bindings
+func cwlInputObject(jobInput *cwl.Job_document, toolInputs []cwl.CommandInputParameter, basePath string) (inputs map[string]interface{}, err error) {
+
+	inputs = map[string]interface{}{}
+
+	jobMap := cwl.JobDocMap{}
+	if jobInput != nil {
+		jobMap = jobInput.GetMap()
+	}
+
+	for _, input := range toolInputs {
+		name := cwlShortID(input.ID)
+		if _, has := jobMap[name]; !has && input.Default != nil {
+			jobMap[name] = input.Default
+		}
+	}
+
+	for name, value := range jobMap {
+		if value == nil || value.GetType() == cwl.CWLNull {
+			inputs[name] = nil
+			continue
+		}
+
+		var valueBytes []byte
+		valueBytes, err = json.Marshal(value)
+		if err != nil {
+			err = fmt.Errorf("(cwlInputObject) json.Marshal(%s) returned: %s", name, err.Error())
+			return
+		}
+		var raw interface{}
+		err = json.Unmarshal(valueBytes, &raw)
+		if err != nil {
+			err = fmt.Errorf("(cwlInputObject) json.Unmarshal(%s) returned: %s", name, err.Error())
+			return
+		}
+		inputs[name] = cwlFixPaths(raw, basePath, basePath, basePath)
+	}
+	return
+}
+
+// cwlFixPaths sets path, basename, dirname, nameroot and nameext of File and Directory objects.
+// Relative locations are resolved against relativeTo, paths below fromPath are moved to toPath.
+func cwlFixPaths(value interface{}, relativeTo string, fromPath string, toPath string) interface{} {
+
+	switch value.(type) {
+	case []interface{}:
+		array := value.([]interface{})
+		for i := range array {
+			array[i] = cwlFixPaths(array[i], relativeTo, fromPath, toPath)
+		}
+		return array
+	case map[string]interface{}:
+	default:
+		return value
+	}
+
+	obj := value.(map[string]interface{})
+	for _, key := range []string{"secondaryFiles", "listing"} {
+		if sub, ok := obj[key]; ok {
+			obj[key] = cwlFixPaths(sub, relativeTo, fromPath, toPath)
+		}
+	}
+
+	class, _ := obj["class"].(string)
+	if class != "File" && class != "Directory" {
+		return obj
+	}
+
+	objPath, _ := obj["path"].(string)
+	location, _ := obj["location"].(string)
+	if objPath == "" && location != "" {
+		if strings.HasPrefix(location, "file://") {
+			objPath = strings.TrimPrefix(location, "file://")
+		} else if !strings.Contains(location, "://") {
+			objPath = location
+		}
+	}
+	if objPath == "" {
+		return obj
+	}
+	if !path.IsAbs(objPath) {
+		objPath = path.Join(relativeTo, objPath)
+	}
+	objPath = cwlHostPath(objPath, fromPath, toPath)
+
+	obj["path"] = objPath
+	if location == "" || !strings.Contains(location, "://") {
+		obj["location"] = "file://" + objPath
+	}
+	basename, _ := obj["basename"].(string)
+	if basename == "" {
+		basename = path.Base(objPath)
+		obj["basename"] = basename
+	}
+	obj["dirname"] = path.Dir(objPath)
+	if class == "File" {
+		ext := path.Ext(basename)
+		obj["nameext"] = ext
+		obj["nameroot"] = strings.TrimSuffix(basename, ext)
+	}
+	return obj
+}
+
+// cwlHostPath maps a path as seen by the tool (below basePath) to the host (below workPath)
+func cwlHostPath(p string, basePath string, workPath string) string {
+	if basePath != workPath && (p == basePath || strings.HasPrefix(p, basePath+"/")) {
+		return path.Join(workPath, strings.TrimPrefix(p, basePath))
+	}
+	return p
+}
+
+// cwlFileObject describes a file or directory on the host
+func cwlFileObject(filePath string) (obj map[string]interface{}, err error) {
+
+	var fi os.FileInfo
+	fi, err = os.Stat(filePath)
+	if err != nil {
+		err = fmt.Errorf("(cwlFileObject) os.Stat returned: %s", err.Error())
+		return
+	}
+
+	obj = map[string]interface{}{
+		"location": "file://" + filePath,
+		"path":     filePath,
+		"basename": path.Base(filePath),
+	}
+	if fi.IsDir() {
+		obj["class"] = "Directory"
+		return
+	}
+	obj["class"] = "File"
+	obj["size"] = fi.Size()
+	return
+}
+
+// cwlLoadContents adds the contents field to a File input
+func cwlLoadContents(value interface{}, basePath string, workPath string) (result interface{}, err error) {
+	result = value
+	obj, ok := value.(map[string]interface{})
+	if !ok || obj["class"] != "File" {
+		return
+	}
+	objPath, _ := obj["path"].(string)
+	var contents string
+	contents, err = readContents(cwlHostPath(objPath, basePath, workPath))
+	if err != nil {
+		return
+	}
+	obj["contents"] = contents
+	return
+}
+
+// readContents reads up to the first 64KiB of a file
+func readContents(filePath string) (contents string, err error) {
+	var f *os.File
+	f, err = os.Open(filePath)
+	if err != nil {
+		err = fmt.Errorf("(readContents) os.Open returned: %s", err.Error())
+		return
+	}
+	defer f.Close()
+
+	buf := make([]byte, cwlLoadContentMax)
+	var n int
+	n, err = io.ReadFull(f, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	contents = string(buf[:n])
+	return
+}
+
+// cwlOutputType simplifies an output type to its name, whether it is optional and whether it is an array
+func cwlOutputType(t interface{}) (typeName string, optional bool, isArray bool) {
+
+	typeBytes, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	var raw interface{}
+	if json.Unmarshal(typeBytes, &raw) != nil {
+		return
+	}
+	return cwlRawType(raw)
+}
+
+func cwlRawType(raw interface{}) (typeName string, optional bool, isArray bool) {
+	switch raw.(type) {
+	case string:
+		typeName = raw.(string)
+		if strings.HasSuffix(typeName, "?") {
+			optional = true
+			typeName = strings.TrimSuffix(typeName, "?")
+		}
+		if strings.HasSuffix(typeName, "[]") {
+			isArray = true
+			typeName = strings.TrimSuffix(typeName, "[]")
+		}
+	case []interface{}:
+		for _, elem := range raw.([]interface{}) {
+			name, opt, arr := cwlRawType(elem)
+			if name == string(cwl.CWLNull) {
+				optional = true
+				continue
+			}
+			if typeName == "" {
+				typeName, isArray = name, arr
+			}
+			optional = optional || opt
+		}
+	case map[string]interface{}:
+		obj := raw.(map[string]interface{})
+		if obj["type"] == string(cwl.CWLArray) {
+			isArray = true
+			typeName, _, _ = cwlRawType(obj["items"])
+			return
+		}
+		typeName, optional, isArray = cwlRawType(obj["type"])
+	}
+	return
+}
+
+// cwlValueString renders a plain value for the command line
+func cwlValueString(value interface{}) (str string, err error) {
+	switch value.(type) {
+	case nil:
+	case string:
+		str = value.(string)
+	case bool:
+		str = strconv.FormatBool(value.(bool))
+	case float64:
+		str = strconv.FormatFloat(value.(float64), 'f', -1, 64)
+	case int:
+		str = strconv.Itoa(value.(int))
+	case int64:
+		str = strconv.FormatInt(value.(int64), 10)
+	case map[string]interface{}:
+		obj := value.(map[string]interface{})
+		if objPath, ok := obj["path"].(string); ok {
+			str = objPath
+			return
+		}
+		err = fmt.Errorf("(cwlValueString) object without path cannot be used as string")
+	default:
+		err = fmt.Errorf("(cwlValueString) type %T not supported", value)
+	}
+	return
+}
+
+// cwlShortID strips the document and tool prefixes of an id
+func cwlShortID(id string) string {
+	return path.Base(strings.TrimPrefix(id, "#"))
+}
+
+func bindingPosition(binding *cwl.CommandLineBinding) int {
+	if binding.Position == nil {
+		return 0
+	}
+	return *binding.Position
+}
+
+func joinInts(codes []int) string {
+	strs := []string{}
+	for _, code := range codes {
+		strs = append(strs, strconv.Itoa(code))
+	}
+	return strings.Join(strs, " ")
+}
+
+func shellQuote(str string) string {
+	return "'" + strings.Replace(str, "'", "'\\''", -1) + "'"
+}
+
+func copyFile(source string, target string) (err error) {
+	var in, out *os.File
+	in, err = os.Open(source)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err = os.Create(target)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return
+}
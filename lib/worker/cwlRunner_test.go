@@ -0,0 +1,198 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// renderArgs joins the arguments like commandScript does
+func renderArgs(args []cwlArg) string {
+	strs := []string{}
+	for _, arg := range args {
+		if arg.Quote {
+			strs = append(strs, shellQuote(arg.Value))
+		} else {
+			strs = append(strs, arg.Value)
+		}
+	}
+	return strings.Join(strs, " ")
+}
+
+func TestBindValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		binding cwl.CommandLineBinding
+		value   interface{}
+		want    string
+	}{
+		{"string", cwl.CommandLineBinding{}, "a b", "'a b'"},
+		{"prefix", cwl.CommandLineBinding{Prefix: "-n"}, 5, "'-n' '5'"},
+		{"prefix not separate", cwl.CommandLineBinding{Prefix: "--n=", Separate: boolPtr(false)}, 5, "'--n=5'"},
+		{"float", cwl.CommandLineBinding{}, 0.5, "'0.5'"},
+		{"null", cwl.CommandLineBinding{Prefix: "-x"}, nil, ""},
+		{"true", cwl.CommandLineBinding{Prefix: "-v"}, true, "'-v'"},
+		{"false", cwl.CommandLineBinding{Prefix: "-v"}, false, ""},
+		{"array", cwl.CommandLineBinding{Prefix: "-i"}, []interface{}{"a", "b"}, "'-i' 'a' 'b'"},
+		{"array item separator", cwl.CommandLineBinding{Prefix: "-i", ItemSeparator: ","}, []interface{}{"a", 1}, "'-i' 'a,1'"},
+		{"empty array", cwl.CommandLineBinding{Prefix: "-i"}, []interface{}{}, ""},
+		{"file", cwl.CommandLineBinding{}, map[string]interface{}{"class": "File", "path": "/work/in.txt"}, "'/work/in.txt'"},
+		{"no shell quote", cwl.CommandLineBinding{ShellQuote: boolPtr(false)}, "a|b", "'a|b'"},
+	}
+
+	for _, test := range tests {
+		run := &cwlToolRun{ShellQuote: true}
+		args, err := run.bindValue(&test.binding, test.value)
+		if err != nil {
+			t.Errorf("%s: bindValue returned: %s", test.name, err.Error())
+			continue
+		}
+		if got := renderArgs(args); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// with ShellCommandRequirement shellQuote: false is honored
+	run := &cwlToolRun{ShellQuote: false}
+	args, err := run.bindValue(&cwl.CommandLineBinding{ShellQuote: boolPtr(false)}, "a|b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := renderArgs(args); got != "a|b" {
+		t.Errorf("shellQuote false: got %q", got)
+	}
+
+	_, err = run.bindValue(&cwl.CommandLineBinding{}, map[string]interface{}{"class": "Directory"})
+	if err == nil {
+		t.Errorf("object without path should not be bound")
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		tool   cwl.CommandLineTool
+		inputs map[string]interface{}
+		want   string
+	}{
+		{
+			name: "base command only",
+			tool: cwl.CommandLineTool{BaseCommand: []string{"echo", "hello"}},
+			want: "'echo' 'hello'",
+		},
+		{
+			name: "inputs sorted by position then name",
+			tool: cwl.CommandLineTool{
+				BaseCommand: []string{"tool"},
+				Inputs: []cwl.CommandInputParameter{
+					{ID: "#main/z", InputBinding: &cwl.CommandLineBinding{}},
+					{ID: "#main/a", InputBinding: &cwl.CommandLineBinding{}},
+					{ID: "#main/first", InputBinding: &cwl.CommandLineBinding{Position: intPtr(-1), Prefix: "-f"}},
+					{ID: "#main/unbound"},
+				},
+			},
+			inputs: map[string]interface{}{"z": "Z", "a": "A", "first": "F", "unbound": "U"},
+			want:   "'tool' '-f' 'F' 'A' 'Z'",
+		},
+		{
+			name: "missing optional input",
+			tool: cwl.CommandLineTool{
+				BaseCommand: []string{"tool"},
+				Inputs: []cwl.CommandInputParameter{
+					{ID: "#main/opt", InputBinding: &cwl.CommandLineBinding{Prefix: "--opt"}},
+					{ID: "#main/arg", InputBinding: &cwl.CommandLineBinding{Position: intPtr(1)}},
+				},
+			},
+			inputs: map[string]interface{}{"arg": "x"},
+			want:   "'tool' 'x'",
+		},
+	}
+
+	for _, test := range tests {
+		tool := test.tool
+		run := &cwlToolRun{Tool: &tool, Inputs: test.inputs, ShellQuote: true}
+		args, err := run.commandLine()
+		if err != nil {
+			t.Errorf("%s: commandLine returned: %s", test.name, err.Error())
+			continue
+		}
+		if got := renderArgs(args); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCommandScript(t *testing.T) {
+	tool := &cwl.CommandLineTool{
+		BaseCommand:        []string{"grep", "it's"},
+		SuccessCodes:       []int{0, 1},
+		PermanentFailCodes: []int{3},
+	}
+	run := &cwlToolRun{
+		Tool:       tool,
+		Outdir:     "/work/cwl_outdir",
+		Tmpdir:     "/work/cwl_tmpdir",
+		ShellQuote: true,
+		Stdin:      "/work/in.txt",
+		Stdout:     "out.txt",
+	}
+	script, err := run.commandScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(script, "\n")
+	for _, want := range []string{
+		"cd '/work/cwl_outdir' || exit 1",
+		"rm -f '/work/cwl_tmpdir/" + cwlPermanentFail + "'",
+		`'grep' 'it'\''s' < '/work/in.txt' > 'out.txt'`,
+		`case " 0 1 " in *" $rc "*) exit 0 ;; esac`,
+		`case " 3 " in *" $rc "*) touch '/work/cwl_tmpdir/` + cwlPermanentFail + `' ;; esac`,
+		"exit $rc",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("script does not contain %q:\n%s", want, joined)
+		}
+	}
+
+	run.Tool = &cwl.CommandLineTool{}
+	_, err = run.commandScript()
+	if err == nil {
+		t.Errorf("empty command line should fail")
+	}
+}
+
+func TestCWLRuntimeResources(t *testing.T) {
+	cores := cwl.Int(2)
+	ram := cwl.Long(4096)
+	hintRAM := cwl.Long(512)
+
+	tool := &cwl.CommandLineTool{}
+	workunit := &core.Workunit{CWLWorkunit: &core.CWLWorkunit{Tool: tool}}
+	c, r := cwlRuntimeResources(workunit)
+	if c < 1 || r != 1024 {
+		t.Errorf("defaults: got %d cores, %d ram", c, r)
+	}
+
+	tool.Hints = []cwl.Requirement{&cwl.ResourceRequirement{RamMin: &hintRAM}}
+	tool.Requirements = []cwl.Requirement{&cwl.ResourceRequirement{CoresMin: &cores, RamMin: &ram}}
+	c, r = cwlRuntimeResources(workunit)
+	if c != 2 || r != 4096 {
+		t.Errorf("requirements should take precedence over hints: got %d cores, %d ram", c, r)
+	}
+
+	tool.Requirements = nil
+	_, r = cwlRuntimeResources(workunit)
+	if r != 512 {
+		t.Errorf("hint: got %d ram", r)
+	}
+}
@@ -210,6 +210,11 @@ func downloadWorkunitData(workunit *core.Workunit) (err error) {
 			//hand the parsed workunit to next stage and continue to get new workunit to process
 			return
 		}
+		err = writeWorkDirOwner(work_path)
+		if err != nil {
+			// only needed to clean up after a crash
+			logger.Warning("(dataDownloader) writeWorkDirOwner returned: %s", err.Error())
+		}
 
 		//run the PreWorkExecutionScript
 		err = runPreWorkExecutionScript(workunit)
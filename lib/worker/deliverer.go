@@ -170,6 +170,18 @@ func deliverer_run(control chan int) (err error) { // TODO return all errors
 }
 
 func removeDirLater(path string, duration time.Duration) (err error) {
+	pendingRemovals.Lock()
+	pendingRemovals.dirs[path] = true
+	pendingRemovals.Unlock()
+
 	time.Sleep(duration)
+
+	pendingRemovals.Lock()
+	defer pendingRemovals.Unlock()
+	if !pendingRemovals.dirs[path] {
+		// already removed because disk space was low
+		return
+	}
+	delete(pendingRemovals.dirs, path)
 	return os.RemoveAll(path)
 }
@@ -0,0 +1,221 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// workDirOwner marker file in each workunit directory: "<hostname> <pid>" of the worker that created it
+const workDirOwner = ".awe_owner"
+
+// diskUsageInterval how often the usage of work dirs, predata and caches is measured
+const diskUsageInterval = 5 * time.Minute
+
+const mebibyte = 1024 * 1024
+
+// pendingRemovals work dirs scheduled for removal by removeDirLater, removed right away when space is low
+var pendingRemovals = struct {
+	sync.Mutex
+	dirs map[string]bool
+}{dirs: make(map[string]bool)}
+
+// diskFree returns the bytes available to unprivileged users on the file system of dir
+func diskFree(dir string) (free int64, err error) {
+	var stat syscall.Statfs_t
+	err = syscall.Statfs(dir, &stat)
+	if err != nil {
+		err = fmt.Errorf("(diskFree) syscall.Statfs %s returned: %s", dir, err.Error())
+		return
+	}
+	free = int64(stat.Bavail) * int64(stat.Bsize)
+	return
+}
+
+// dirSize returns the size of all regular files below dir, 0 if dir does not exist
+func dirSize(dir string) (size int64) {
+	filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// getDiskUsage measures free space in the work path and the space used by work dirs, predata and caches
+func getDiskUsage() (usage *core.DiskUsage, err error) {
+	free, err := diskFree(conf.WORK_PATH)
+	if err != nil {
+		return
+	}
+	usage = &core.DiskUsage{
+		Free:    free,
+		Work:    dirSize(conf.WORK_PATH),
+		Predata: dirSize(path.Join(conf.PREDATA_PATH, "predata")),
+		Cache:   dirSize(conf.CONTAINER_IMAGE_CACHE),
+		Time:    time.Now().Unix(),
+	}
+	return
+}
+
+// diskMonitor updates the disk usage reported with the heartbeat
+func diskMonitor() {
+	for {
+		usage, err := getDiskUsage()
+		if err != nil {
+			logger.Error("(diskMonitor) %s", err.Error())
+		} else if err = core.Self.SetDisk(usage, true); err != nil {
+			logger.Error("(diskMonitor) SetDisk returned: %s", err.Error())
+		} else {
+			logger.Debug(1, "(diskMonitor) free=%d work=%d predata=%d cache=%d", usage.Free, usage.Work, usage.Predata, usage.Cache)
+		}
+		time.Sleep(diskUsageInterval)
+	}
+}
+
+// checkoutSpace returns the bytes a new workunit may use: free space in the work path minus the headroom.
+// If that is not positive, the directories of finished workunits are removed first.
+func checkoutSpace() (available int64, err error) {
+	headroom := int64(conf.DISK_HEADROOM_MB) * mebibyte
+	free, err := diskFree(conf.WORK_PATH)
+	if err != nil {
+		return
+	}
+	if free-headroom <= 0 && removePendingDirs() > 0 {
+		free, err = diskFree(conf.WORK_PATH)
+		if err != nil {
+			return
+		}
+	}
+	available = free - headroom
+	return
+}
+
+// removePendingDirs removes the work dirs waiting in removeDirLater, returns the number removed
+func removePendingDirs() (count int) {
+	pendingRemovals.Lock()
+	defer pendingRemovals.Unlock()
+	for dir := range pendingRemovals.dirs {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			logger.Error("(removePendingDirs) could not remove %s: %s", dir, err.Error())
+			continue
+		}
+		delete(pendingRemovals.dirs, dir)
+		count++
+	}
+	if count > 0 {
+		logger.Info("(removePendingDirs) disk space low, removed %d work directories", count)
+	}
+	return
+}
+
+// startDiskWatch checks the free space in the work path while a workunit runs. Below the minimum it
+// kills the workunit, diskFull reports whether that happened.
+func startDiskWatch() (stop func(), diskFull func() bool) {
+	var full int32
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Duration(conf.DISK_CHECK_INTERVAL) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				free, err := diskFree(conf.WORK_PATH)
+				if err != nil {
+					logger.Error("(startDiskWatch) %s", err.Error())
+					continue
+				}
+				if free >= int64(conf.DISK_MIN_FREE_MB)*mebibyte {
+					continue
+				}
+				if removePendingDirs() > 0 {
+					continue
+				}
+				logger.Error("(startDiskWatch) only %d MiB free in %s, killing workunit", free/mebibyte, conf.WORK_PATH)
+				atomic.StoreInt32(&full, 1)
+				select {
+				case chankill <- true:
+				case <-done:
+				}
+				return
+			}
+		}
+	}()
+	stop = func() { close(done) }
+	diskFull = func() bool { return atomic.LoadInt32(&full) == 1 }
+	return
+}
+
+// writeWorkDirOwner marks a new work dir as owned by this worker process
+func writeWorkDirOwner(workPath string) (err error) {
+	hostname, _ := os.Hostname()
+	return ioutil.WriteFile(path.Join(workPath, workDirOwner), []byte(fmt.Sprintf("%s %d", hostname, os.Getpid())), 0644)
+}
+
+// orphanedWorkDir a work dir is left over from a crashed run if it was created on this host by a
+// process that no longer exists, or by a previous worker with the same pid (e.g. pid 1 in a container)
+func orphanedWorkDir(workPath string, hostname string) bool {
+	owner, err := ioutil.ReadFile(path.Join(workPath, workDirOwner))
+	if err != nil {
+		// no marker, could belong to another worker sharing the work path
+		return false
+	}
+	fields := strings.Fields(string(owner))
+	if len(fields) != 2 || fields[0] != hostname {
+		return false
+	}
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false
+	}
+	if pid == os.Getpid() {
+		return true
+	}
+	err = syscall.Kill(pid, 0)
+	return err == syscall.ESRCH
+}
+
+// removeOrphanedWorkDirs is called at startup, before any work is checked out
+func removeOrphanedWorkDirs() {
+	hostname, _ := os.Hostname()
+	// work dirs are <workpath>/xx/xx/xx/<jobid>_<task>_<rank>
+	dirs, err := filepath.Glob(path.Join(conf.WORK_PATH, "*", "*", "*", "*"))
+	if err != nil {
+		logger.Error("(removeOrphanedWorkDirs) %s", err.Error())
+		return
+	}
+	count := 0
+	for _, dir := range dirs {
+		if !orphanedWorkDir(dir, hostname) {
+			continue
+		}
+		err = os.RemoveAll(dir)
+		if err != nil {
+			logger.Error("(removeOrphanedWorkDirs) could not remove %s: %s", dir, err.Error())
+			continue
+		}
+		count++
+	}
+	if count > 0 {
+		logger.Info("(removeOrphanedWorkDirs) removed %d work directories of crashed runs", count)
+	}
+}
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+func TestOrphanedWorkDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-disk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the pid of a process that has exited
+	cmd := exec.Command("true")
+	if err = cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	exited := cmd.Process.Pid
+
+	tests := []struct {
+		owner    string // empty: no marker
+		orphaned bool
+	}{
+		{"", false},
+		{fmt.Sprintf("host1 %d", os.Getpid()), true},
+		{fmt.Sprintf("host1 %d", exited), true},
+		{fmt.Sprintf("host1 %d", os.Getppid()), false},
+		{fmt.Sprintf("host2 %d", exited), false},
+		{"host1", false},
+		{"host1 pid", false},
+	}
+	for i, test := range tests {
+		workPath := path.Join(dir, fmt.Sprintf("work%d", i))
+		if err = os.MkdirAll(workPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if test.owner != "" {
+			if err = ioutil.WriteFile(path.Join(workPath, workDirOwner), []byte(test.owner), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if orphaned := orphanedWorkDir(workPath, "host1"); orphaned != test.orphaned {
+			t.Errorf("%q: got %t, want %t", test.owner, orphaned, test.orphaned)
+		}
+	}
+
+	// the marker written by this worker
+	workPath := path.Join(dir, "own")
+	os.MkdirAll(workPath, 0755)
+	if err = writeWorkDirOwner(workPath); err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	if !orphanedWorkDir(workPath, hostname) || orphanedWorkDir(workPath, hostname+".other") {
+		t.Errorf("marker of this worker not recognized")
+	}
+}
+
+func TestCheckoutSpace(t *testing.T) {
+	if logger.Log == nil {
+		logger.Initialize("worker")
+	}
+	dir, err := ioutil.TempDir("", "awe-disk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer conftest.Save(&conf.WORK_PATH, &conf.DISK_HEADROOM_MB)()
+	conf.WORK_PATH = dir
+
+	free, err := diskFree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.DISK_HEADROOM_MB = 1
+	available, err := checkoutSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// other processes may write in between
+	if available <= 0 || available > free {
+		t.Errorf("got %d available, %d free", available, free)
+	}
+
+	// without space the directories of finished workunits are removed first
+	finished := path.Join(dir, "finished")
+	if err = os.MkdirAll(finished, 0755); err != nil {
+		t.Fatal(err)
+	}
+	pendingRemovals.Lock()
+	pendingRemovals.dirs[finished] = true
+	pendingRemovals.Unlock()
+	conf.DISK_HEADROOM_MB = int(free/mebibyte) + 1024
+	available, err = checkoutSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available > 0 {
+		t.Errorf("got %d available, expected none", available)
+	}
+	if _, err = os.Stat(finished); !os.IsNotExist(err) {
+		t.Errorf("finished work directory not removed")
+	}
+	pendingRemovals.Lock()
+	defer pendingRemovals.Unlock()
+	if pendingRemovals.dirs[finished] {
+		t.Errorf("finished work directory still pending")
+	}
+}
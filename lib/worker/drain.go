@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/golib/httpclient"
+)
+
+// DrainClient the worker checks out no more work and exits when the current work is delivered
+func DrainClient() {
+	if atomic.CompareAndSwapInt32(&draining, 0, 1) {
+		logger.Info("(DrainClient) draining, exiting after current work")
+	}
+}
+
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// drainOnSignal on SIGTERM the worker asks the server to drain it and finishes its current work,
+// a second SIGTERM exits immediately
+func drainOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	<-signals
+	logger.Info("(drainOnSignal) SIGTERM received, draining")
+	err := sendClientRequest("drain", conf.DRAIN_DEADLINE)
+	if err != nil {
+		logger.Error("(drainOnSignal) %s", err.Error())
+	}
+	DrainClient()
+	<-signals
+	fmt.Fprintf(os.Stderr, "second SIGTERM received, exiting...\n")
+	os.Exit(1)
+}
+
+// exitWhenIdle waits until all workunits are delivered, deregisters and exits
+func exitWhenIdle() {
+	for {
+		work, err := workmap.GetKeys()
+		if err == nil && len(work) == 0 {
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	err := sendClientRequest("deregister", "")
+	if err != nil {
+		logger.Error("(exitWhenIdle) %s", err.Error())
+	}
+	logger.Info("(exitWhenIdle) drained, exiting")
+	fmt.Printf("client drained, exiting...\n")
+	os.Exit(0)
+}
+
+// sendClientRequest sends PUT /client/{id}?<op>, deadline is optional
+func sendClientRequest(op string, deadline string) (err error) {
+	targeturl := fmt.Sprintf("%s/client/%s?%s", core.ServerURL(), core.Self.ID, op)
+	if deadline != "" {
+		targeturl += "&deadline=" + url.QueryEscape(deadline)
+	}
+
+	headers := httpclient.Header{}
+	if conf.CLIENT_GROUP_TOKEN != "" {
+		headers["Authorization"] = []string{"CG_TOKEN " + conf.CLIENT_GROUP_TOKEN}
+	}
+
+	res, err := core.DoServerRequest("PUT", targeturl, headers, nil, 0)
+	if err != nil {
+		err = fmt.Errorf("(sendClientRequest) %s: core.DoServerRequest returned: %s", op, err.Error())
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		err = fmt.Errorf("(sendClientRequest) %s: server returned %s", op, res.Status)
+	}
+	return
+}
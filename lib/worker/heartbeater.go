@@ -1,7 +1,7 @@
 package worker
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MG-RAST/AWE/lib/client"
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
 	e "github.com/MG-RAST/AWE/lib/errors"
@@ -22,13 +23,6 @@ import (
 	"github.com/MG-RAST/golib/httpclient"
 )
 
-// HeartbeatResponse _
-type HeartbeatResponse struct {
-	Code int                        `bson:"status" json:"status"`
-	Data core.HeartbeatInstructions `bson:"data" json:"data"`
-	Errs []string                   `bson:"error" json:"error"`
-}
-
 // ClientResponse _
 type ClientResponse struct {
 	Code int         `bson:"status" json:"status"`
@@ -71,26 +65,26 @@ type OpenstackMetadataMeta struct {
 	Name     string `bson:"name" json:"name"`
 }
 
-// ExitWorker _
-func ExitWorker(newServerUUID string) {
-	logger.Warning("(SendHeartBeat) Server UUID has changed (%s -> %s). Will stop all work units.", core.ServerUUID, newServerUUID)
-	allWork, _ := workmap.GetKeys()
-
-	for _, work := range allWork {
-		_ = DiscardWorkunit(work)
+// serverRestarted the server has a new UUID: re-register with the workunits that are still running,
+// the server gives them back to this worker (or tells it to discard them with the next heartbeat)
+func serverRestarted(newServerUUID string) {
+	currentWork, _ := core.Self.CurrentWork.Length(true)
+	logger.Warning("(serverRestarted) Server UUID has changed (%s -> %s), re-registering with %d running workunits", core.ServerUUID, newServerUUID, currentWork)
+	core.ServerUUID = newServerUUID
+	err := ReRegisterWithSelf(core.ServerURL())
+	if err != nil {
+		logger.Error("(serverRestarted) ReRegisterWithSelf returned: %s", err.Error())
 	}
-	_, _ = fmt.Fprintln(os.Stderr, "AWE server has been restarted, stopping worker now to ensure correct state, bye....")
-	os.Exit(0)
 }
 
 // SendHeartBeat client sends heartbeat to server to maintain active status and re-register when needed
 func SendHeartBeat() (err error) {
-	hbmsg, err := heartbeating(conf.SERVER_URL, core.Self.ID)
+	hbmsg, err := heartbeating(core.ServerURL(), core.Self.ID)
 	if err != nil {
 		logger.Debug(3, "(SendHeartBeat) heartbeat returned error: "+err.Error())
 		if strings.Contains(err.Error(), e.ClientNotFound) {
 			logger.Debug(3, "(SendHeartBeat) invoke ReRegisterWithSelf: ")
-			xerr := ReRegisterWithSelf(conf.SERVER_URL)
+			xerr := ReRegisterWithSelf(core.ServerURL())
 			if xerr != nil {
 				err = fmt.Errorf("(SendHeartBeat) needed to register, but that failed: %s", xerr.Error())
 				return
@@ -107,12 +101,8 @@ func SendHeartBeat() (err error) {
 				core.ServerUUID = val
 			} else {
 				if core.ServerUUID != val {
-					// server has been restarted, stop work on client (TODO in future we will try to recover work)
-
-					ExitWorker(val)
-
-					//_ = core.Self.SetBusy(false, false)
-					//core.ServerUUID = val
+					// server has been restarted
+					serverRestarted(val)
 				}
 			}
 
@@ -135,10 +125,23 @@ func SendHeartBeat() (err error) {
 				}
 				_ = DiscardWorkunit(work_id)
 			}
+		} else if op == "preempt" { //stop workunits the server requeued for higher priority work
+			preemptedworks := strings.Split(objs, ",")
+			for _, work := range preemptedworks {
+				work_id, xerr := core.New_Workunit_Unique_Identifier_FromString(work)
+				if xerr != nil {
+					err = xerr
+					return
+				}
+				logger.Info("workunit %s preempted by the server", work)
+				_ = DiscardWorkunit(work_id)
+			}
 		} else if op == "restart" {
 			RestartClient()
 		} else if op == "stop" {
 			StopClient()
+		} else if op == "drain" {
+			DrainClient()
 		} else if op == "clean" {
 			CleanDisk()
 		}
@@ -146,53 +149,34 @@ func SendHeartBeat() (err error) {
 	return
 }
 
-func heartbeating(host string, clientid string) (msg core.HeartbeatInstructions, err error) {
-	response := new(HeartbeatResponse)
-	targeturl := fmt.Sprintf("%s/client/%s?heartbeat", host, clientid)
-	//res, err := http.Get(targeturl)
-
-	worker_state_b, err := json.Marshal(core.Self.WorkerState)
-	if err != nil {
-		err = fmt.Errorf("(heartbeating) json.Marshal failed: %s", err.Error())
-		return
-	}
-
-	headers := httpclient.Header{"Content-Type": []string{"application/json"}}
-
+// serverClient a client for the current server, which changes with failover. Requests are not retried,
+// the heartbeater and the workStealer retry and switch servers in between.
+func serverClient() (c *client.Client) {
+	auth := ""
 	if conf.CLIENT_GROUP_TOKEN != "" {
-		headers["Authorization"] = []string{"CG_TOKEN " + conf.CLIENT_GROUP_TOKEN}
-	}
-
-	res, err := httpclient.Put(targeturl, headers, bytes.NewBuffer(worker_state_b), nil)
-	if err != nil {
-		err = fmt.Errorf("(heartbeating) httpclient.Put failed: %s", err.Error())
-		return
-	}
-	logger.Debug(3, "client %s sent a heartbeat to %s", clientid, host)
-
-	defer res.Body.Close()
-
-	if res.StatusCode == 404 {
-		err = fmt.Errorf("(heartbeating) response: 404 Not Found")
-		return
+		auth = "CG_TOKEN " + conf.CLIENT_GROUP_TOKEN
 	}
+	c = client.New(core.ServerURL(), auth)
+	c.HTTPClient = core.ServerClient{}
+	c.Retries = 0
+	return
+}
 
-	jsonstream, err := ioutil.ReadAll(res.Body)
+func heartbeating(host string, clientid string) (msg core.HeartbeatInstructions, err error) {
+	state, err := core.Self.GetWorkerState(true)
 	if err != nil {
-		err = fmt.Errorf("(heartbeating) ioutil.ReadAll failed: %s", err.Error())
+		err = fmt.Errorf("(heartbeating) GetWorkerState returned: %s", err.Error())
 		return
 	}
-	err = json.Unmarshal(jsonstream, response)
+	c := serverClient()
+	c.URL = strings.TrimSuffix(host, "/")
+	msg = core.HeartbeatInstructions{}
+	err = c.Heartbeat(context.Background(), clientid, state, &msg)
 	if err != nil {
-		err = fmt.Errorf("(heartbeating) json.Unmarshal response failed: %s", err.Error())
-		return
-	}
-
-	if len(response.Errs) > 0 {
-		err = fmt.Errorf("(heartbeating) errors in response: %s ", strings.Join(response.Errs, ","))
+		err = fmt.Errorf("(heartbeating) client.Heartbeat returned: %s", err.Error())
 		return
 	}
-	msg = response.Data
+	logger.Debug(3, "client %s sent a heartbeat to %s", clientid, host)
 	return
 }
 
@@ -293,9 +277,9 @@ func RegisterWithAuth(host string, pclient *core.Client) (err error) {
 	targetUrl := host + "/client"
 	logger.Debug(3, "Try to register client: %s", targetUrl)
 
-	resp, err := httpclient.DoTimeout("POST", targetUrl, headers, form.Reader, nil, time.Second*10)
+	resp, err := core.DoServerRequest("POST", targetUrl, headers, form.Reader, time.Second*10)
 	if err != nil {
-		err = fmt.Errorf("(RegisterWithAuth) POST %s, httpclient.DoTimeout returns: %s", targetUrl, err.Error())
+		err = fmt.Errorf("(RegisterWithAuth) POST %s, core.DoServerRequest returns: %s", targetUrl, err.Error())
 		return
 	}
 	defer resp.Body.Close()
@@ -325,17 +309,10 @@ func RegisterWithAuth(host string, pclient *core.Client) (err error) {
 
 	rr := response.Data
 
-	if rr.ServerUUID != "" && core.ServerUUID != "" {
-
-		if rr.ServerUUID != core.ServerUUID {
-			ExitWorker(rr.ServerUUID)
-		}
-		logger.Debug(3, "(RegisterWithAuth) server UUID already known")
-	}
-
-	if rr.ServerUUID != "" && core.ServerUUID == "" {
+	if rr.ServerUUID != "" && rr.ServerUUID != core.ServerUUID {
+		// first registration, or registered again after a server restart with the running workunits
 		logger.Debug(3, "(RegisterWithAuth) Using ServerUUID=%s", rr.ServerUUID)
-		rr.ServerUUID = core.ServerUUID
+		core.ServerUUID = rr.ServerUUID
 	}
 
 	//client = &response.Data
@@ -423,6 +400,9 @@ func ComposeProfile() (profile *core.Client, err error) {
 
 	profile.Group = conf.CLIENT_GROUP
 	profile.CPUs = runtime.NumCPU()
+	if conf.BATCH_SYSTEM != "" || conf.KUBE_EXECUTOR {
+		profile.CPUs = 0 // cores are allocated by the batch system or the cluster
+	}
 	profile.Domain = conf.CLIENT_DOMAIN
 	profile.Version = conf.VERSION
 	//profile.GitCommitHash = conf.GIT_COMMIT_HASH
@@ -442,6 +422,13 @@ func ComposeProfile() (profile *core.Client, err error) {
 		}
 	}
 
+	profile.ContainerRuntimes = AvailableContainerRuntimes()
+	if len(profile.ContainerRuntimes) == 0 {
+		// an empty list would mean unknown to the server
+		profile.ContainerRuntimes = []string{core.ContainerRuntimeNone}
+	}
+	logger.Info("container runtimes: %v", profile.ContainerRuntimes)
+
 	Set_Metadata(profile)
 
 	if core.Service == "proxy" {
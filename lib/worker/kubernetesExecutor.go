@@ -0,0 +1,445 @@
+package worker
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/kube"
+	"github.com/MG-RAST/AWE/lib/logger"
+	"github.com/MG-RAST/AWE/lib/logger/event"
+)
+
+const (
+	kubeWorkVolume     = "awe-work"
+	kubePredataVolume  = "awe-predata"
+	kubeMainContainer  = "workunit"
+	kubeStageContainer = "stage"
+	kubeLabelWorkunit  = "awe-workunit"
+	kubeLabelWorker    = "awe-worker"
+	kubeLabelNetwork   = "awe-network" // "none" or "allowed", for use in NetworkPolicies
+	kubeNameMax        = 63
+)
+
+// waiting reasons of containers that will not start without intervention
+var kubeStartErrors = []string{"ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError", "CreateContainerError"}
+
+// kubeClientset is created on first use, tests replace it with a fake
+var kubeClientset kube.Clientset
+
+// kubernetesRuntime runs workunits as Jobs in the cluster of the worker
+type kubernetesRuntime struct{}
+
+func (r kubernetesRuntime) Name() string { return core.ContainerRuntimeKubernetes }
+
+func (r kubernetesRuntime) Available() bool { return conf.KUBE_EXECUTOR }
+
+func (r kubernetesRuntime) Run(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
+	return RunWorkunitKubernetes(workunit)
+}
+
+// kubeJobResult is the outcome of a Job derived from its pod
+type kubeJobResult struct {
+	Finished  bool
+	ExitCode  int
+	OOMKilled bool
+	Message   string
+}
+
+func getKubeClientset() (clientset kube.Clientset, err error) {
+	if kubeClientset != nil {
+		clientset = kubeClientset
+		return
+	}
+	client, err := kube.NewInClusterClient(conf.KUBE_API_URL)
+	if err != nil {
+		err = fmt.Errorf("(getKubeClientset) NewInClusterClient returned: %s", err.Error())
+		return
+	}
+	kubeClientset = client
+	clientset = client
+	return
+}
+
+func kubeNamespace() string {
+	if conf.KUBE_NAMESPACE != "" {
+		return conf.KUBE_NAMESPACE
+	}
+	return kube.InClusterNamespace()
+}
+
+// kubeName converts an identifier into a DNS-1123 label
+func kubeName(prefix string, id string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, prefix+id)
+	if len(name) > kubeNameMax {
+		name = name[:kubeNameMax]
+	}
+	return strings.Trim(name, "-")
+}
+
+// kubeWorkunitName returns a unique name for the objects of a workunit, long workunit ids are
+// shortened and made unique with a hash
+func kubeWorkunitName(id string) string {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	name := kubeName("awe-", id)
+	if len(name) > kubeNameMax-9 {
+		name = strings.Trim(name[:kubeNameMax-9], "-")
+	}
+	return fmt.Sprintf("%s-%08x", name, h.Sum32())
+}
+
+// RunWorkunitKubernetes runs the workunit as a Job, the work directory is shared through the
+// persistent volume claim work_pvc
+func RunWorkunitKubernetes(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
+	clientset, err := getKubeClientset()
+	if err != nil {
+		return
+	}
+	namespace := kubeNamespace()
+
+	workPath, err := workunit.Path()
+	if err != nil {
+		err = fmt.Errorf("(RunWorkunitKubernetes) workunit.Path() returned: %s", err.Error())
+		return
+	}
+
+	job, secret, err := newKubeJob(workunit, workPath)
+	if err != nil {
+		err = fmt.Errorf("(RunWorkunitKubernetes) newKubeJob returned: %s", err.Error())
+		return
+	}
+
+	if secret != nil {
+		err = clientset.CreateSecret(namespace, secret)
+		if err != nil {
+			err = fmt.Errorf("(RunWorkunitKubernetes) CreateSecret returned: %s", err.Error())
+			return
+		}
+		defer func() {
+			if xerr := clientset.DeleteSecret(namespace, secret.Metadata.Name); xerr != nil {
+				logger.Error("(RunWorkunitKubernetes) could not delete secret %s: %s", secret.Metadata.Name, xerr.Error())
+			}
+		}()
+	}
+
+	jobName := job.Metadata.Name
+	if xerr := clientset.DeleteJob(namespace, jobName); xerr == nil {
+		// left over from a previous attempt, wait until it is gone
+		logger.Debug(1, "(RunWorkunitKubernetes) deleting old job %s", jobName)
+		for i := 0; i < 60; i++ {
+			if _, xerr = clientset.GetJob(namespace, jobName); kube.IsNotFound(xerr) {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+	}
+	_, err = clientset.CreateJob(namespace, job)
+	if err != nil {
+		err = fmt.Errorf("(RunWorkunitKubernetes) CreateJob returned: %s", err.Error())
+		return
+	}
+	defer func() {
+		// stdout and stderr are in the work directory, the Job is not needed anymore
+		if xerr := clientset.DeleteJob(namespace, jobName); xerr != nil && !kube.IsNotFound(xerr) {
+			logger.Error("(RunWorkunitKubernetes) could not delete job %s: %s", jobName, xerr.Error())
+		}
+	}()
+
+	logger.Info("workunit %s submitted as kubernetes job %s/%s", workunit.ID, namespace, jobName)
+	logger.Event(event.WORK_START, "workid="+workunit.ID, "kubernetes_job="+jobName)
+
+	interval := time.Duration(conf.KUBE_POLL_INTERVAL_SECONDS) * time.Second
+	result, err := waitKubeJob(clientset, namespace, jobName, interval)
+	if err != nil {
+		err = fmt.Errorf("(RunWorkunitKubernetes) %s", err.Error())
+		return
+	}
+
+	workunit.ExitStatus = result.ExitCode
+	if result.OOMKilled {
+		if workunit.WorkPerf != nil {
+			workunit.WorkPerf.OOMKilled = true
+		}
+		err = fmt.Errorf("(RunWorkunitKubernetes) job %s exceeded its memory limit and was killed", jobName)
+		return
+	}
+	if result.ExitCode != 0 {
+		err = fmt.Errorf("(RunWorkunitKubernetes) job %s failed with exit code %d: %s", jobName, result.ExitCode, result.Message)
+		return
+	}
+	logger.Event(event.WORK_END, "workid="+workunit.ID, "kubernetes_job="+jobName)
+
+	pstats = new(core.WorkPerf)
+	pstats.MaxMemUsage = -1
+	return
+}
+
+// waitKubeJob polls the Job and its pod until it has finished, it deletes the Job on chankill
+func waitKubeJob(clientset kube.Clientset, namespace string, jobName string, interval time.Duration) (result kubeJobResult, err error) {
+	for {
+		select {
+		case <-chankill:
+			if xerr := clientset.DeleteJob(namespace, jobName); xerr != nil {
+				logger.Error("(waitKubeJob) could not delete job %s: %s", jobName, xerr.Error())
+			}
+			err = fmt.Errorf("(waitKubeJob) job %s deleted as requested from chankill", jobName)
+			return
+		case <-time.After(interval):
+		}
+
+		var job *kube.Job
+		job, err = clientset.GetJob(namespace, jobName)
+		if err != nil {
+			if kube.IsNotFound(err) {
+				err = fmt.Errorf("(waitKubeJob) job %s was deleted", jobName)
+				return
+			}
+			logger.Error("(waitKubeJob) GetJob returned: %s", err.Error())
+			err = nil
+			continue
+		}
+		var pods []kube.Pod
+		pods, err = clientset.ListPods(namespace, kubeLabelWorkunit+"="+jobName)
+		if err != nil {
+			logger.Error("(waitKubeJob) ListPods returned: %s", err.Error())
+			err = nil
+			continue
+		}
+
+		result, err = kubeJobStatus(job, pods)
+		if err != nil || result.Finished {
+			return
+		}
+	}
+}
+
+// kubeJobStatus maps the pod phase and exit codes of the Job to the result of the workunit,
+// an error is returned for pods that cannot start
+func kubeJobStatus(job *kube.Job, pods []kube.Pod) (result kubeJobResult, err error) {
+
+	for _, pod := range pods {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.State.Terminated != nil && status.State.Terminated.ExitCode != 0 {
+				result.Finished = true
+				result.ExitCode = int(status.State.Terminated.ExitCode)
+				result.Message = fmt.Sprintf("staging of the work directory failed: %s", status.State.Terminated.Reason)
+				return
+			}
+		}
+		for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			if status.State.Waiting != nil && contains(kubeStartErrors, status.State.Waiting.Reason) {
+				err = fmt.Errorf("(kubeJobStatus) container %s of pod %s cannot start: %s %s", status.Name, pod.Metadata.Name, status.State.Waiting.Reason, status.State.Waiting.Message)
+				return
+			}
+		}
+
+		switch pod.Status.Phase {
+		case kube.PodSucceeded:
+			result.Finished = true
+			return
+		case kube.PodFailed:
+			result.Finished = true
+			result.ExitCode = 1 // e.g. evicted before the container terminated
+			result.Message = strings.TrimSpace(pod.Status.Reason + " " + pod.Status.Message)
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name != kubeMainContainer || status.State.Terminated == nil {
+					continue
+				}
+				result.ExitCode = int(status.State.Terminated.ExitCode)
+				result.OOMKilled = status.State.Terminated.Reason == "OOMKilled"
+				if result.Message == "" {
+					result.Message = status.State.Terminated.Reason
+				}
+			}
+			return
+		}
+	}
+
+	// no pod (anymore), e.g. the Job exceeded its deadline
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != "True" {
+			continue
+		}
+		switch condition.Type {
+		case "Complete":
+			result.Finished = true
+			return
+		case "Failed":
+			result.Finished = true
+			result.ExitCode = 1
+			result.Message = strings.TrimSpace(condition.Reason + " " + condition.Message)
+			return
+		}
+	}
+	return
+}
+
+// newKubeJob builds the Job of a workunit. The work directory on work_pvc is mounted at the
+// docker work dir for container workunits and at its worker path otherwise, so that the
+// arguments prepared by the worker stay valid. Private environment variables go into a Secret.
+func newKubeJob(workunit *core.Workunit, workPath string) (job *kube.Job, secret *kube.Secret, err error) {
+
+	subPath, err := filepath.Rel(conf.WORK_PATH, workPath)
+	if err != nil || strings.HasPrefix(subPath, "..") {
+		err = fmt.Errorf("(newKubeJob) work directory %s is not in workpath %s", workPath, conf.WORK_PATH)
+		return
+	}
+
+	image := conf.KUBE_DEFAULT_IMAGE
+	mountPath := workPath
+	predataPath := path.Join(conf.PREDATA_PATH, "predata")
+	if workunit.Cmd.Dockerimage != "" {
+		err = fmt.Errorf("(newKubeJob) images hosted in Shock (%s) cannot be pulled by kubernetes, use dockerPull", workunit.Cmd.Dockerimage)
+		return
+	}
+	if workunit.Cmd.DockerPull != "" {
+		image, err = normalizedImageName(workunit.Cmd.DockerPull)
+		if err != nil {
+			err = fmt.Errorf("(newKubeJob) normalizedImageName returned: %s", err.Error())
+			return
+		}
+		mountPath = strings.TrimSuffix(conf.DOCKER_WORK_DIR, "/")
+		predataPath = conf.DOCKER_WORKUNIT_PREDATA_DIR
+	}
+
+	launcher, err := writeContainerLauncher(workunit, workPath, mountPath)
+	if err != nil {
+		err = fmt.Errorf("(newKubeJob) writeContainerLauncher returned: %s", err.Error())
+		return
+	}
+
+	limits, err := GetContainerLimits(workunit, workPath)
+	if err != nil {
+		err = fmt.Errorf("(newKubeJob) GetContainerLimits returned: %s", err.Error())
+		return
+	}
+
+	name := kubeWorkunitName(workunit.ID)
+	labels := map[string]string{
+		kubeLabelWorkunit: name,
+		kubeLabelWorker:   kubeName("", conf.CLIENT_NAME),
+		kubeLabelNetwork:  "none",
+	}
+	if limits.Network {
+		labels[kubeLabelNetwork] = "allowed"
+	}
+
+	main := kube.Container{
+		Name:         kubeMainContainer,
+		Image:        image,
+		Command:      []string{"/bin/bash", launcher},
+		WorkingDir:   mountPath,
+		VolumeMounts: []kube.VolumeMount{{Name: kubeWorkVolume, MountPath: mountPath, SubPath: subPath}},
+		Resources:    kube.ResourceRequirements{Requests: map[string]string{}, Limits: map[string]string{}},
+	}
+	if limits.Cores > 0 {
+		main.Resources.Requests["cpu"] = strconv.Itoa(limits.Cores)
+		main.Resources.Limits["cpu"] = strconv.Itoa(limits.Cores)
+	}
+	if limits.RamMB > 0 {
+		main.Resources.Requests["memory"] = fmt.Sprintf("%dMi", limits.RamMB)
+		main.Resources.Limits["memory"] = fmt.Sprintf("%dMi", limits.RamMB)
+	}
+
+	for key, val := range workunit.Cmd.Environ.Public {
+		main.Env = append(main.Env, kube.EnvVar{Name: key, Value: val})
+	}
+	for _, proxyVar := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		if val := os.Getenv(proxyVar); val != "" {
+			main.Env = append(main.Env, kube.EnvVar{Name: proxyVar, Value: val})
+		}
+	}
+	if workunit.Cmd.HasPrivateEnv {
+		var privateEnvs map[string]string
+		privateEnvs, err = FetchPrivateEnvByWorkId(workunit.ID)
+		if err != nil {
+			err = fmt.Errorf("(newKubeJob) FetchPrivateEnvByWorkId returned: %s", err.Error())
+			return
+		}
+		secret = &kube.Secret{
+			Metadata:   kube.ObjectMeta{Name: name, Labels: labels},
+			Type:       "Opaque",
+			StringData: privateEnvs,
+		}
+		main.EnvFrom = append(main.EnvFrom, kube.EnvFromSource{SecretRef: &kube.LocalObjectReference{Name: name}})
+	}
+
+	volumes := []kube.Volume{{Name: kubeWorkVolume, PersistentVolumeClaim: &kube.PersistentVolumeClaimVolumeSource{ClaimName: conf.KUBE_WORK_PVC}}}
+	if len(workunit.Predata) > 0 && workunit.Cmd.DockerPull != "" {
+		// without container the predata files have been copied into the work directory
+		if conf.KUBE_PREDATA_PVC == "" {
+			err = fmt.Errorf("(newKubeJob) workunit uses predata, but predata_pvc is not configured")
+			return
+		}
+		volumes = append(volumes, kube.Volume{Name: kubePredataVolume, PersistentVolumeClaim: &kube.PersistentVolumeClaimVolumeSource{ClaimName: conf.KUBE_PREDATA_PVC, ReadOnly: true}})
+		main.VolumeMounts = append(main.VolumeMounts, kube.VolumeMount{Name: kubePredataVolume, MountPath: predataPath, SubPath: "predata", ReadOnly: true})
+	}
+
+	// the init container checks that the staged work directory is complete and makes it
+	// writable for the user of the tool container
+	var root int64
+	stage := kube.Container{
+		Name:            kubeStageContainer,
+		Image:           conf.KUBE_STAGE_IMAGE,
+		Command:         []string{"sh", "-c", fmt.Sprintf("test -f %s && chmod -R a+rwX %s", shellQuote(launcher), shellQuote(mountPath))},
+		VolumeMounts:    []kube.VolumeMount{{Name: kubeWorkVolume, MountPath: mountPath, SubPath: subPath}},
+		SecurityContext: &kube.SecurityContext{RunAsUser: &root},
+	}
+
+	var backoffLimit int32 // AWE resubmits failed workunits itself
+	job = &kube.Job{
+		Metadata: kube.ObjectMeta{Name: name, Labels: labels},
+		Spec: kube.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: kube.PodTemplateSpec{
+				Metadata: kube.ObjectMeta{Labels: labels},
+				Spec: kube.PodSpec{
+					RestartPolicy:      "Never",
+					ServiceAccountName: conf.KUBE_SERVICE_ACCOUNT,
+					SecurityContext:    kubeSecurityContext(limits.User),
+					InitContainers:     []kube.Container{stage},
+					Containers:         []kube.Container{main},
+					Volumes:            volumes,
+				},
+			},
+		},
+	}
+	return
+}
+
+// kubeSecurityContext returns the pod security context for a numeric uid[:gid], nil otherwise
+func kubeSecurityContext(user string) (context *kube.PodSecurityContext) {
+	if user == "" {
+		return
+	}
+	parts := strings.SplitN(user, ":", 2)
+	uid, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return
+	}
+	context = &kube.PodSecurityContext{RunAsUser: &uid}
+	if len(parts) == 2 {
+		gid, err := strconv.ParseInt(parts[1], 10, 64)
+		if err == nil {
+			context.RunAsGroup = &gid
+		}
+	}
+	return
+}
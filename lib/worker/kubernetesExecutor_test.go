@@ -0,0 +1,282 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/conf/conftest"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/kube"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// fakeClientset keeps Jobs and Secrets in memory, the pod of a Job goes through the phases in
+// podPhases, one per ListPods call
+type fakeClientset struct {
+	sync.Mutex
+	jobs      map[string]*kube.Job
+	secrets   map[string]*kube.Secret
+	deleted   []string
+	podPhases []kube.Pod
+	listCalls int
+}
+
+func newFakeClientset(podPhases ...kube.Pod) *fakeClientset {
+	return &fakeClientset{jobs: map[string]*kube.Job{}, secrets: map[string]*kube.Secret{}, podPhases: podPhases}
+}
+
+func (f *fakeClientset) CreateJob(namespace string, job *kube.Job) (created *kube.Job, err error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.jobs[job.Metadata.Name]; ok {
+		err = &kube.APIError{Code: 409, Message: "already exists"}
+		return
+	}
+	f.jobs[job.Metadata.Name] = job
+	created = job
+	return
+}
+
+func (f *fakeClientset) GetJob(namespace string, name string) (job *kube.Job, err error) {
+	f.Lock()
+	defer f.Unlock()
+	job, ok := f.jobs[name]
+	if !ok {
+		err = &kube.APIError{Code: 404, Message: "not found"}
+	}
+	return
+}
+
+func (f *fakeClientset) DeleteJob(namespace string, name string) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.jobs[name]; !ok {
+		return &kube.APIError{Code: 404, Message: "not found"}
+	}
+	delete(f.jobs, name)
+	f.deleted = append(f.deleted, name)
+	return
+}
+
+func (f *fakeClientset) ListPods(namespace string, labelSelector string) (pods []kube.Pod, err error) {
+	f.Lock()
+	defer f.Unlock()
+	if len(f.podPhases) == 0 {
+		return
+	}
+	i := f.listCalls
+	if i >= len(f.podPhases) {
+		i = len(f.podPhases) - 1
+	}
+	f.listCalls++
+	pods = []kube.Pod{f.podPhases[i]}
+	return
+}
+
+func (f *fakeClientset) CreateSecret(namespace string, secret *kube.Secret) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.secrets[secret.Metadata.Name] = secret
+	return
+}
+
+func (f *fakeClientset) DeleteSecret(namespace string, name string) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.secrets, name)
+	return
+}
+
+func podWithPhase(phase string, exitCode int32, reason string) kube.Pod {
+	pod := kube.Pod{Status: kube.PodStatus{Phase: phase}}
+	if phase == kube.PodSucceeded || phase == kube.PodFailed {
+		pod.Status.ContainerStatuses = []kube.ContainerStatus{{
+			Name:  kubeMainContainer,
+			State: kube.ContainerState{Terminated: &kube.ContainerStateTerminated{ExitCode: exitCode, Reason: reason}},
+		}}
+	}
+	return pod
+}
+
+func setupKubeTest(t *testing.T) (workunit *core.Workunit, cleanup func()) {
+	if logger.Log == nil {
+		logger.Initialize("worker")
+	}
+	dir, err := ioutil.TempDir("", "awe-kube-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore := conftest.Save(&conf.WORK_PATH, &conf.DOCKER_WORK_DIR, &conf.DOCKER_CONTAINER_USER, &conf.KUBE_WORK_PVC,
+		&conf.KUBE_DEFAULT_IMAGE, &conf.KUBE_STAGE_IMAGE, &conf.KUBE_NAMESPACE, &conf.KUBE_POLL_INTERVAL_SECONDS, &kubeClientset)
+	cleanup = func() {
+		restore()
+		os.RemoveAll(dir)
+	}
+	conf.WORK_PATH = dir
+	conf.DOCKER_WORK_DIR = "/workdir/"
+	conf.DOCKER_CONTAINER_USER = "root"
+	conf.KUBE_WORK_PVC = "awe-work"
+	conf.KUBE_DEFAULT_IMAGE = "mgrast/awe-worker"
+	conf.KUBE_STAGE_IMAGE = "busybox"
+	conf.KUBE_NAMESPACE = "awe"
+	conf.KUBE_POLL_INTERVAL_SECONDS = 1
+
+	workunit = &core.Workunit{ID: "0123456789ab_Task_0", Cmd: &core.Command{Name: "bowtie2", ParsedArgs: []string{"-x", "index"}}}
+	workunit.WorkPath = path.Join(dir, "01/23/45/0123456789ab_Task_0")
+	if err = os.MkdirAll(workunit.WorkPath, 0777); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestNewKubeJob(t *testing.T) {
+	workunit, cleanup := setupKubeTest(t)
+	defer cleanup()
+
+	tool := &cwl.CommandLineTool{}
+	tool.Requirements = []cwl.Requirement{&cwl.ResourceRequirement{CoresMin: 2, RamMin: 4096}}
+	workunit.CWLWorkunit = &core.CWLWorkunit{Tool: tool}
+	workunit.Cmd.DockerPull = "ubuntu:18.04"
+
+	job, secret, err := newKubeJob(workunit, workunit.WorkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != nil {
+		t.Errorf("secret created without private environment")
+	}
+	spec := job.Spec.Template.Spec
+	if len(spec.Containers) != 1 || len(spec.InitContainers) != 1 {
+		t.Fatalf("expected one container and one init container, got %d and %d", len(spec.Containers), len(spec.InitContainers))
+	}
+	main := spec.Containers[0]
+	if main.Image != "ubuntu:18.04" {
+		t.Errorf("image: %s", main.Image)
+	}
+	if main.Resources.Requests["cpu"] != "2" || main.Resources.Limits["memory"] != "4096Mi" {
+		t.Errorf("resources: %+v", main.Resources)
+	}
+	if main.VolumeMounts[0].MountPath != "/workdir" || main.VolumeMounts[0].SubPath != "01/23/45/0123456789ab_Task_0" {
+		t.Errorf("work mount: %+v", main.VolumeMounts[0])
+	}
+	if spec.Volumes[0].PersistentVolumeClaim == nil || spec.Volumes[0].PersistentVolumeClaim.ClaimName != "awe-work" {
+		t.Errorf("work volume: %+v", spec.Volumes[0])
+	}
+	if spec.RestartPolicy != "Never" || job.Spec.BackoffLimit == nil || *job.Spec.BackoffLimit != 0 {
+		t.Errorf("job must not be retried by kubernetes")
+	}
+	if len(job.Metadata.Name) > kubeNameMax || job.Metadata.Labels[kubeLabelWorkunit] != job.Metadata.Name {
+		t.Errorf("job name/label: %s %v", job.Metadata.Name, job.Metadata.Labels)
+	}
+
+	launcher, err := ioutil.ReadFile(path.Join(workunit.WorkPath, containerLauncherScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(launcher), "bowtie2 -x index 2> /workdir/") {
+		t.Errorf("launcher: %s", launcher)
+	}
+}
+
+func TestNewKubeJobWithoutImage(t *testing.T) {
+	workunit, cleanup := setupKubeTest(t)
+	defer cleanup()
+
+	job, _, err := newKubeJob(workunit, workunit.WorkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := job.Spec.Template.Spec.Containers[0]
+	if main.Image != conf.KUBE_DEFAULT_IMAGE {
+		t.Errorf("image: %s", main.Image)
+	}
+	// arguments prepared by the worker use the worker path of the work directory
+	if main.VolumeMounts[0].MountPath != workunit.WorkPath {
+		t.Errorf("work mount: %+v", main.VolumeMounts[0])
+	}
+}
+
+func TestKubeJobStatus(t *testing.T) {
+	imagePull := kube.Pod{Status: kube.PodStatus{Phase: kube.PodPending, ContainerStatuses: []kube.ContainerStatus{{
+		Name:  kubeMainContainer,
+		State: kube.ContainerState{Waiting: &kube.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+	}}}}
+	stageFailed := kube.Pod{Status: kube.PodStatus{Phase: kube.PodPending, InitContainerStatuses: []kube.ContainerStatus{{
+		Name:  kubeStageContainer,
+		State: kube.ContainerState{Terminated: &kube.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}},
+	}}}}
+	deadline := &kube.Job{Status: kube.JobStatus{Conditions: []kube.JobCondition{{Type: "Failed", Status: "True", Reason: "DeadlineExceeded"}}}}
+
+	tests := []struct {
+		name     string
+		job      *kube.Job
+		pods     []kube.Pod
+		finished bool
+		exitCode int
+		oom      bool
+		err      bool
+	}{
+		{"pending", &kube.Job{}, []kube.Pod{podWithPhase(kube.PodPending, 0, "")}, false, 0, false, false},
+		{"running", &kube.Job{}, []kube.Pod{podWithPhase(kube.PodRunning, 0, "")}, false, 0, false, false},
+		{"succeeded", &kube.Job{}, []kube.Pod{podWithPhase(kube.PodSucceeded, 0, "Completed")}, true, 0, false, false},
+		{"failed", &kube.Job{}, []kube.Pod{podWithPhase(kube.PodFailed, 3, "Error")}, true, 3, false, false},
+		{"permanent", &kube.Job{}, []kube.Pod{podWithPhase(kube.PodFailed, 42, "Error")}, true, 42, false, false},
+		{"oom", &kube.Job{}, []kube.Pod{podWithPhase(kube.PodFailed, 137, "OOMKilled")}, true, 137, true, false},
+		{"image", &kube.Job{}, []kube.Pod{imagePull}, false, 0, false, true},
+		{"stage", &kube.Job{}, []kube.Pod{stageFailed}, true, 1, false, false},
+		{"deadline", deadline, nil, true, 1, false, false},
+	}
+
+	for _, test := range tests {
+		result, err := kubeJobStatus(test.job, test.pods)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if result.Finished != test.finished || result.ExitCode != test.exitCode || result.OOMKilled != test.oom {
+			t.Errorf("%s: got %+v", test.name, result)
+		}
+	}
+}
+
+func TestRunWorkunitKubernetes(t *testing.T) {
+	workunit, cleanup := setupKubeTest(t)
+	defer cleanup()
+
+	fake := newFakeClientset(podWithPhase(kube.PodRunning, 0, ""), podWithPhase(kube.PodSucceeded, 0, "Completed"))
+	kubeClientset = fake
+
+	pstats, err := RunWorkunitKubernetes(workunit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pstats == nil || workunit.ExitStatus != 0 {
+		t.Errorf("exit status %d", workunit.ExitStatus)
+	}
+	if len(fake.jobs) != 0 || len(fake.deleted) != 1 {
+		t.Errorf("job not deleted after completion: %v", fake.deleted)
+	}
+}
+
+func TestRunWorkunitKubernetesFailure(t *testing.T) {
+	workunit, cleanup := setupKubeTest(t)
+	defer cleanup()
+
+	workunit.WorkPerf = new(core.WorkPerf)
+	kubeClientset = newFakeClientset(podWithPhase(kube.PodFailed, 137, "OOMKilled"))
+
+	_, err := RunWorkunitKubernetes(workunit)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !workunit.WorkPerf.OOMKilled || workunit.ExitStatus != 137 {
+		t.Errorf("oom: %t, exit status %d", workunit.WorkPerf.OOMKilled, workunit.ExitStatus)
+	}
+}
@@ -3,6 +3,7 @@ package worker
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,7 +13,6 @@ import (
 	"github.com/MG-RAST/AWE/lib/core/cwl"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/logger/event"
-	"github.com/MG-RAST/golib/httpclient"
 
 	//"github.com/davecgh/go-spew/spew"
 	//"github.com/davecgh/go-spew/spew"
@@ -22,8 +22,6 @@ import (
 	"os"
 	"os/exec"
 	"path"
-	"runtime"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -110,16 +108,29 @@ func processor_run(control chan int) (err error) {
 	}
 	run_start := time.Now().Unix()
 
+	stopCheckpoints := startCheckpoints(workunit)
+	stopDiskWatch, diskFull := startDiskWatch()
 	var pstat *core.WorkPerf
 	pstat, err = RunWorkunit(workunit)
+	stopDiskWatch()
+	stopCheckpoints()
 	exit_status := workunit.ExitStatus
 	logger.Debug(1, "(processor) ExitStatus of process: %d", exit_status)
 	if err != nil {
 		logger.Error("(processor) RunWorkunit returned error , workid=%s, %s", work_str, err.Error())
 		workunit.Notes = append(workunit.Notes, "[processor#RunWorkunit]"+err.Error())
 
-		if exit_status == 42 {
+		if diskFull() {
+			// requeued, possibly on a worker with more space
+			workunit.WorkPerf.DiskFull = true
+			workunit.Notes = append(workunit.Notes, fmt.Sprintf("[processor] disk full: less than %d MiB free in work path", conf.DISK_MIN_FREE_MB))
+			workunit.SetState(core.WORK_STAT_ERROR, "disk full")
+		} else if exit_status == 42 {
 			workunit.SetState(core.WORK_STAT_FAILED_PERMANENT, "exit_status == 42") // process told us that is an error where resubmission does not make sense.
+		} else if workunit.CWLWorkunit != nil && cwlPermanentFailure(workunit) {
+			workunit.SetState(core.WORK_STAT_FAILED_PERMANENT, fmt.Sprintf("exit code %d is a permanentFailCode of the tool", exit_status))
+		} else if workunit.WorkPerf != nil && workunit.WorkPerf.OOMKilled {
+			workunit.SetState(core.WORK_STAT_FAILED_OOM, "container exceeded its memory limit")
 		} else {
 			workunit.SetState(core.WORK_STAT_ERROR, "RunWorkunit failed")
 		}
@@ -177,14 +188,43 @@ func processor(control chan int) {
 
 func RunWorkunit(workunit *core.Workunit) (pstats *core.WorkPerf, err error) {
 
+	if workunit.CWLWorkunit != nil && workunit.Cmd.Name != "cwl-runner" {
+		// native execution, see cwl_runner option
+		pstats, err = RunCWLWorkunit(workunit)
+		if err != nil {
+			err = fmt.Errorf("(RunWorkunit) RunCWLWorkunit returned: %s", err.Error())
+		}
+		return
+	}
+
 	stderr_exists := false
 
 	if workunit.Cmd.Dockerimage != "" || workunit.Cmd.DockerPull != "" {
-		pstats, err = RunWorkunitDocker(workunit)
+		var rt ContainerRuntime
+		rt, err = GetContainerRuntime()
+		if err != nil {
+			err = fmt.Errorf("(RunWorkunit) GetContainerRuntime returned: %s", err.Error())
+			return
+		}
+		pstats, err = rt.Run(workunit)
+		if err != nil {
+			err = fmt.Errorf("(RunWorkunit) %s runtime returned: %s", rt.Name(), err.Error())
+			return
+		}
+	} else if conf.KUBE_EXECUTOR {
+		pstats, err = RunWorkunitKubernetes(workunit)
 		if err != nil {
-			err = fmt.Errorf("(RunWorkunit) RunWorkunitDocker returned: %s", err.Error())
+			err = fmt.Errorf("(RunWorkunit) RunWorkunitKubernetes returned: %s", err.Error())
 			return
 		}
+		stderr_exists = true
+	} else if conf.BATCH_SYSTEM != "" {
+		pstats, err = RunWorkunitBatch(workunit)
+		if err != nil {
+			err = fmt.Errorf("(RunWorkunit) RunWorkunitBatch returned: %s", err.Error())
+			return
+		}
+		stderr_exists = true
 	} else {
 		pstats, stderr_exists, err = RunWorkunitDirect(workunit)
 		if err != nil {
@@ -642,6 +682,14 @@ func RunWorkunitDocker(workunit *core.Workunit) (pstats *core.WorkPerf, err erro
 		docker_commandline_create = append(docker_commandline_create, docker_environment_string)
 	}
 
+	limits, err := GetContainerLimits(workunit, work_path)
+	if err != nil {
+		err = fmt.Errorf("(RunWorkunitDocker) GetContainerLimits returned: %s", err.Error())
+		return
+	}
+	logger.Debug(1, "container limits: %+v", limits)
+	docker_commandline_create = append(docker_commandline_create, limits.CommandLineArgs()...)
+
 	// version for docker API
 	config := docker.Config{Image: dockerimage_id,
 		WorkingDir:   conf.DOCKER_WORK_DIR,
@@ -662,7 +710,10 @@ func RunWorkunitDocker(workunit *core.Workunit) (pstats *core.WorkPerf, err erro
 	docker_commandline_create = append(docker_commandline_create, dockerimage_id)   //
 	docker_commandline_create = append(docker_commandline_create, container_cmd...) // argument to the "docker create" command
 
-	opts := docker.CreateContainerOptions{Name: container_name, Config: &config, HostConfig: &docker.HostConfig{Binds: bindarray}}
+	host_config := docker.HostConfig{Binds: bindarray}
+	limits.Apply(&config, &host_config)
+
+	opts := docker.CreateContainerOptions{Name: container_name, Config: &config, HostConfig: &host_config}
 
 	// note: docker binary mounts on creation, while docker API mounts on start of container
 
@@ -776,132 +827,27 @@ func RunWorkunitDocker(workunit *core.Workunit) (pstats *core.WorkPerf, err erro
 		cresult := WaitContainerResult{errwait, status}
 
 		done <- cresult // inform main function
-		if conf.MEM_CHECK_INTERVAL != 0 {
-			done <- cresult // inform memory checker
-		}
 	}()
 
-	var MaxMem int64 = -1
-	var max_memory_total_rss int64 = -1
-	var max_memory_total_swap int64 = -1
-
-	memory_stat_filename := ""
-	if conf.MEM_CHECK_INTERVAL != 0 {
-
-		// documentation: https://docs.docker.com/articles/runmetrics/
-		// e.g. ubuntu: /sys/fs/cgroup/memory/docker/[ID]/memory.stat
-		//      coreos: /sys/fs/cgroup/memory/system.slice/docker-[ID].scope/memory.stat
-
-		memory_stat_filename = strings.Replace(conf.CGROUP_MEMORY_DOCKER_DIR, "[ID]", container_id, -1)
-
-		if _, err := os.Stat(memory_stat_filename); os.IsNotExist(err) {
-			logger.Error("warning: memory measurement requested, but no memory.stat found: %s" + memory_stat_filename)
-			memory_stat_filename = ""
-		}
-
-	}
-
-	if conf.MEM_CHECK_INTERVAL != 0 && memory_stat_filename != "" {
-		go func() { // memory checker
-
-			for {
-
-				select {
-				case cresult := <-done:
-					workunit.ExitStatus = cresult.Status
-					if cresult.Error != nil {
-						logger.Error("channel done returned error: " + cresult.Error.Error())
-					}
-					return
-				default:
-				}
-
-				var memory_total_rss int64 = -1
-				var memory_total_swap int64 = -1
-				memory_stat_file, err_mem := os.Open(memory_stat_filename)
-				if err_mem != nil {
-
-					logger.Error("warning: error opening memory_stat_file file:" + err_mem.Error())
-					time.Sleep(conf.MEM_CHECK_INTERVAL)
-					continue
-				}
-
-				// Closes the file when we leave the scope of the current function,
-				// this makes sure we never forget to close the file if the
-				// function can exit in multiple places.
-
-				memory_stat_file_scanner := bufio.NewScanner(memory_stat_file)
-
-				memory_total_rss_read := false
-				memory_total_swap_read := false
-				// scanner.Scan() advances to the next token returning false if an error was encountered
-				for memory_stat_file_scanner.Scan() {
-					line := memory_stat_file_scanner.Text()
-					if strings.HasPrefix(line, "total_rss ") { // TODO what is total_rss_huge
-						//logger.Debug(1, fmt.Sprint("inspecting container with memory line=", line))
-
-						memory_total_rss, err = strconv.ParseInt(strings.TrimPrefix(line, "total_rss "), 10, 64)
-						if err != nil {
-							memory_total_rss = -1
-						}
-						memory_total_rss_read = true
-					} else if strings.HasPrefix(line, "total_swap ") { // TODO what is total_rss_huge
-						//logger.Debug(1, fmt.Sprint("inspecting container with memory line=", line))
-
-						memory_total_swap, err = strconv.ParseInt(strings.TrimPrefix(line, "total_swap "), 10, 64)
-						if err != nil {
-							memory_total_swap = -1
-						}
-						memory_total_swap_read = true
-					} else {
-						continue
-					}
-					if memory_total_rss_read && memory_total_swap_read { // we found all information we need, leave the loop
-						break
-					}
-
-				}
-
-				// When finished scanning if any error other than io.EOF occured
-				// it will be returned by scanner.Err().
-				if err := memory_stat_file_scanner.Err(); err != nil {
-					logger.Error("warning: could no read memory usage from cgroups=%s", memory_stat_file_scanner.Err())
-					//err = nil
-				} else {
-
-					// RSS maxium
-					if memory_total_rss >= 0 && memory_total_rss > max_memory_total_rss {
-						max_memory_total_rss = memory_total_rss
-					}
-
-					// SWAP maximum
-					if memory_total_swap >= 0 && memory_total_swap > max_memory_total_swap {
-						max_memory_total_swap = memory_total_swap
-					}
-
-					// RSS+SWAP maximum
-					if memory_total_rss >= 0 && memory_total_swap >= 0 {
-
-						memory_combined := memory_total_rss + memory_total_swap
-						if memory_combined > MaxMem {
-							MaxMem = memory_combined
-						}
-
-					}
-
-					logger.Debug(1, fmt.Sprintf("memory: rss=%d, swap=%d, max_rss=%d max_swap=%d max_combined=%d",
-						memory_total_rss, memory_total_swap, max_memory_total_rss, max_memory_total_swap, MaxMem))
-
-				}
-				memory_stat_file.Close() // defer does not work in for loop !
-				//time.Sleep(5 * time.Second)
-				time.Sleep(conf.MEM_CHECK_INTERVAL)
-
+	dockerCgroup := inspectCgroup(conf.DOCKER_BINARY, container_id)
+	if client != nil {
+		dockerCgroup = func() (cgroup containerCgroup, err error) {
+			cont, err := client.InspectContainer(container_id)
+			if err != nil {
+				return
 			}
-		}()
-	} else {
-		logger.Debug(1, "memory checking disabled")
+			cgroup.ID = cont.ID
+			if cont.HostConfig != nil {
+				cgroup.Parent = cont.HostConfig.CgroupParent
+			}
+			if info, xerr := client.Info(); xerr == nil {
+				cgroup.Driver = info.CgroupDriver
+			}
+			return
+		}
 	}
+	stopUsage := startUsageSampler(workunit, containerUsage(dockerCgroup))
+	defer stopUsage()
 
 	cresult := WaitContainerResult{nil, -1}
 
@@ -930,14 +876,21 @@ func RunWorkunitDocker(workunit *core.Workunit) (pstats *core.WorkPerf, err erro
 		}
 		if cresult.Status != 0 {
 			logger.Debug(3, "WaitContainer returned non-zero status=%d", cresult.Status)
+			oom_killed, oom_err := containerOOMKilled(client, container_id)
+			if oom_err != nil {
+				logger.Error("(RunWorkunitDocker) %s", oom_err.Error())
+			}
+			if oom_killed {
+				if workunit.WorkPerf != nil {
+					workunit.WorkPerf.OOMKilled = true
+				}
+				return nil, fmt.Errorf("container exceeded its memory limit (%d MiB) and was killed, status=%d", limits.RamMB, cresult.Status)
+			}
 			return nil, fmt.Errorf("error WaitContainer returned non-zero status=%d", cresult.Status)
 		}
 	}
 
-	logger.Debug(1, fmt.Sprint("pstats.MaxMemUsage: ", pstats.MaxMemUsage))
-	pstats.MaxMemUsage = MaxMem
-	pstats.MaxMemoryTotalRss = max_memory_total_rss
-	pstats.MaxMemoryTotalSwap = max_memory_total_swap
+	setMemoryStats(pstats, stopUsage())
 	logger.Debug(1, fmt.Sprint("pstats.MaxMemUsage: ", pstats.MaxMemUsage))
 
 	return
@@ -1020,48 +973,18 @@ func RunWorkunitDirect(workunit *core.Workunit) (pstats *core.WorkPerf, stderr_e
 		return
 	}
 
-	var MaxMem uint64 = 0
-	MaxMemChan := make(chan uint64)
 	done := make(chan error)
-	memcheck_done := make(chan bool)
 	go func() {
 		done <- cmd.Wait()
-		memcheck_done <- true
 	}()
 
-	mem_check_interval_here := conf.MEM_CHECK_INTERVAL
-	if mem_check_interval_here == 0 {
-		mem_check_interval_here = 10 * time.Second
-	}
-
-	go func() {
-		mstats := new(runtime.MemStats)
-		runtime.ReadMemStats(mstats)
-		_MaxMem := mstats.Alloc
-		MaxMemChan <- mstats.Alloc
-		time.Sleep(2 * time.Second)
-		for {
-			select {
-			default:
-				mstats := new(runtime.MemStats)
-				runtime.ReadMemStats(mstats)
-				if mstats.Alloc > _MaxMem {
-					MaxMemChan <- mstats.Alloc
-				}
-				time.Sleep(mem_check_interval_here)
-			case <-memcheck_done:
-				return
-			}
-		}
-	}()
+	stopUsage := startUsageSampler(workunit, processUsage(cmd.Process.Pid))
+	defer stopUsage()
 
 	do_loop := true
 	for do_loop {
 		logger.Debug(3, "(RunWorkunitDirect) for-loop")
 		select {
-		case MaxMem_value := <-MaxMemChan:
-			logger.Debug(3, "(RunWorkunitDirect) received MaxMem_value %d", MaxMem_value)
-			MaxMem = MaxMem_value
 		case <-chankill:
 			if err := cmd.Process.Kill(); err != nil {
 				fmt.Println("(RunWorkunitDirect) failed to kill" + err.Error())
@@ -1104,7 +1027,10 @@ func RunWorkunitDirect(workunit *core.Workunit) (pstats *core.WorkPerf, stderr_e
 	logger.Event(event.WORK_END, "workid="+workunit.ID)
 
 	pstats = new(core.WorkPerf)
-	pstats.MaxMemUsage = int64(MaxMem)
+	pstats.MaxMemUsage = -1
+	pstats.MaxMemoryTotalRss = -1
+	pstats.MaxMemoryTotalSwap = -1
+	setMemoryStats(pstats, stopUsage())
 	return
 }
 
@@ -1216,36 +1142,11 @@ func UnSetEnv(envkeys []string) {
 	}
 }
 
+// FetchPrivateEnvByWorkId the private environment variables of a workunit
 func FetchPrivateEnvByWorkId(workid string) (envs map[string]string, err error) {
-	targeturl := fmt.Sprintf("%s/work/%s?privateenv&client=%s", conf.SERVER_URL, workid, core.Self.ID)
-	var headers httpclient.Header
-	if conf.CLIENT_GROUP_TOKEN != "" {
-		headers = httpclient.Header{
-			"Authorization": []string{"CG_TOKEN " + conf.CLIENT_GROUP_TOKEN},
-		}
-	}
-	res, err := httpclient.Get(targeturl, headers, nil)
+	envs, err = serverClient().WorkunitPrivateEnv(context.Background(), workid, core.Self.ID)
 	if err != nil {
-		return envs, err
-	}
-	defer res.Body.Close()
-	var jsonstream string
-	if res.Header != nil {
-		if _, ok := res.Header["Privateenv"]; ok {
-			jsonstream = res.Header["Privateenv"][0]
-		}
-	}
-	tmp_map := new(map[string]string)
-
-	if err := json.Unmarshal([]byte(jsonstream), tmp_map); err != nil {
-		err = fmt.Errorf("(FetchPrivateEnvByWorkId) json.Unmarshal returned: %s", err.Error())
-		return nil, err
-	}
-
-	envs = make(map[string]string)
-
-	for key, val := range *tmp_map {
-		envs[key] = val
+		err = fmt.Errorf("(FetchPrivateEnvByWorkId) client.WorkunitPrivateEnv returned: %s", err.Error())
 	}
 	return
 }
@@ -0,0 +1,594 @@
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MG-RAST/AWE/lib/conf"
+	"github.com/MG-RAST/AWE/lib/core"
+	"github.com/MG-RAST/AWE/lib/logger"
+)
+
+// cgroupRoot mount point of the cgroup file system (v2) or of the v1 hierarchies
+const cgroupRoot = "/sys/fs/cgroup"
+
+// maxUsageSamples longer runs keep every second sample when the time series gets longer
+const maxUsageSamples = 720
+
+// clockTicks USER_HZ, the unit of utime and stime in /proc/<pid>/stat
+const clockTicks = 100
+
+// usageReading cumulative counters of a process or cgroup
+type usageReading struct {
+	CPUSeconds float64
+	Memory     int64 // current, rss (+ page cache for cgroups v2)
+	Swap       int64
+	MemPeak    int64 // peak reported by the kernel, 0 if unknown
+	IORead     int64
+	IOWrite    int64
+}
+
+// usageSource reads the resource usage of a running workunit
+type usageSource interface {
+	Name() string
+	Read() (reading usageReading, err error)
+}
+
+// usageResolver finds the usage source of a workunit, it is retried until the process or container exists
+type usageResolver func() (source usageSource, err error)
+
+// startUsageSampler samples the usage every usage_interval seconds. stop ends sampling, stores the usage
+// in the WorkPerf of the workunit and returns it (nil if nothing could be sampled), it may be called again.
+func startUsageSampler(workunit *core.Workunit, resolve usageResolver) (stop func() *core.ResourceUsage) {
+	if conf.USAGE_INTERVAL <= 0 {
+		stop = func() *core.ResourceUsage { return nil }
+		return
+	}
+	interval := time.Duration(conf.USAGE_INTERVAL) * time.Second
+	usage := &core.ResourceUsage{Interval: conf.USAGE_INTERVAL, Samples: []core.UsageSample{}}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		var source usageSource
+		var previous usageReading
+		var memorySum int64
+		count := 0
+		keepEvery := 1
+		start := time.Now()
+		lastTime := start
+
+		sample := func() {
+			if source == nil {
+				var err error
+				source, err = resolve()
+				if err != nil {
+					logger.Debug(3, "(usageSampler) %s", err.Error())
+					return
+				}
+				usage.Source = source.Name()
+			}
+			reading, err := source.Read()
+			if err != nil {
+				logger.Debug(3, "(usageSampler) %s Read returned: %s", source.Name(), err.Error())
+				return
+			}
+			now := time.Now()
+			count++
+			memorySum += reading.Memory
+			usage.CPUSeconds = reading.CPUSeconds
+			usage.IORead = reading.IORead
+			usage.IOWrite = reading.IOWrite
+			usage.MemAvg = memorySum / int64(count)
+			if reading.Memory > usage.MemPeak {
+				usage.MemPeak = reading.Memory
+			}
+			if reading.MemPeak > usage.MemPeak {
+				usage.MemPeak = reading.MemPeak
+			}
+			if reading.Swap > usage.SwapPeak {
+				usage.SwapPeak = reading.Swap
+			}
+
+			cores := 0.0
+			if elapsed := now.Sub(lastTime).Seconds(); elapsed > 0 && count > 1 {
+				cores = (reading.CPUSeconds - previous.CPUSeconds) / elapsed
+			}
+			previous = reading
+			lastTime = now
+			if count%keepEvery != 0 {
+				return
+			}
+			usage.Samples = append(usage.Samples, core.UsageSample{
+				Time:    int64(now.Sub(start).Seconds()),
+				CPU:     cores,
+				Memory:  reading.Memory,
+				IORead:  reading.IORead,
+				IOWrite: reading.IOWrite,
+			})
+			if len(usage.Samples) >= maxUsageSamples {
+				thinned := usage.Samples[:0]
+				for i := 0; i < len(usage.Samples); i += 2 {
+					thinned = append(thinned, usage.Samples[i])
+				}
+				usage.Samples = thinned
+				keepEvery *= 2
+			}
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		sample()
+		for {
+			select {
+			case <-done:
+				// the process may be gone already, in that case the last sample stays
+				sample()
+				return
+			case <-ticker.C:
+				sample()
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() *core.ResourceUsage {
+		once.Do(func() {
+			close(done)
+			<-finished
+			if usage.Source == "" {
+				return
+			}
+			if workunit.WorkPerf != nil {
+				workunit.WorkPerf.Usage = usage
+			}
+			logger.Debug(1, "(usageSampler) %s: cpu=%.1fs mem_peak=%d mem_avg=%d io_read=%d io_write=%d", usage.Source, usage.CPUSeconds, usage.MemPeak, usage.MemAvg, usage.IORead, usage.IOWrite)
+		})
+		if usage.Source == "" {
+			return nil
+		}
+		return usage
+	}
+	return
+}
+
+// setMemoryStats fills the memory fields of WorkPerf from the sampled usage
+func setMemoryStats(pstats *core.WorkPerf, usage *core.ResourceUsage) {
+	if pstats == nil || usage == nil {
+		return
+	}
+	pstats.MaxMemoryTotalRss = usage.MemPeak
+	pstats.MaxMemoryTotalSwap = usage.SwapPeak
+	pstats.MaxMemUsage = usage.MemPeak + usage.SwapPeak
+}
+
+// processUsage a process started by the worker and all its descendants
+func processUsage(pid int) usageResolver {
+	return func() (source usageSource, err error) {
+		source = &procTreeSource{pid: pid, cpu: make(map[int]float64), ioRead: make(map[int]int64), ioWrite: make(map[int]int64)}
+		return
+	}
+}
+
+// containerCgroup what the container runtime reports about the cgroup of a container
+type containerCgroup struct {
+	ID     string // full container id
+	Path   string // relative to the cgroup root, empty if the runtime does not report it (docker)
+	Parent string // cgroup parent, empty for the default
+	Driver string // cgroup driver of the runtime, "systemd" or "cgroupfs", empty if unknown
+}
+
+// containerUsage the cgroup of a container, found from its id. The pid of the container is not used,
+// it is a host pid that is not visible if the worker itself runs in a container.
+func containerUsage(inspect func() (containerCgroup, error)) usageResolver {
+	return func() (source usageSource, err error) {
+		container, err := inspect()
+		if err != nil {
+			return
+		}
+		source, err = cgroupSourceForContainer(container)
+		return
+	}
+}
+
+// inspectCgroup returns id and cgroup path of a container using the CLI of the runtime (docker or podman)
+func inspectCgroup(binary string, container string) func() (containerCgroup, error) {
+	return func() (cgroup containerCgroup, err error) {
+		format := "--format={{.Id}} {{.HostConfig.CgroupParent}}"
+		if binary == "podman" {
+			format = "--format={{.Id}} {{.State.CgroupPath}}"
+		}
+		stdo, _, err := RunCommand(binary, "inspect", format, container)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(string(stdo))
+		if len(fields) == 0 {
+			err = fmt.Errorf("(inspectCgroup) %s inspect returned no id for %s", binary, container)
+			return
+		}
+		cgroup.ID = fields[0]
+		if len(fields) > 1 {
+			if binary == "podman" {
+				cgroup.Path = fields[1]
+			} else {
+				cgroup.Parent = fields[1]
+			}
+		}
+		if binary != "podman" {
+			cgroup.Driver = dockerCgroupDriver()
+		}
+		return
+	}
+}
+
+var cgroupDriverOnce sync.Once
+var cgroupDriver string
+
+// dockerCgroupDriver asks the docker daemon once, empty if it cannot be found out
+func dockerCgroupDriver() string {
+	cgroupDriverOnce.Do(func() {
+		stdo, _, err := RunCommand(conf.DOCKER_BINARY, "info", "--format={{.CgroupDriver}}")
+		if err == nil {
+			cgroupDriver = strings.TrimSpace(string(stdo))
+		}
+	})
+	return cgroupDriver
+}
+
+// containerCgroupPaths the possible cgroup paths (relative to the cgroup root) of a container, the path
+// reported by the runtime or those of the docker and podman cgroup drivers
+func containerCgroupPaths(container containerCgroup) (paths []string) {
+	if container.Path != "" {
+		return []string{strings.TrimPrefix(container.Path, "/")}
+	}
+	id := container.ID
+	systemd := path.Join("system.slice", "docker-"+id+".scope")
+	cgroupfs := path.Join("docker", id)
+	if container.Parent != "" {
+		parent := strings.TrimPrefix(container.Parent, "/")
+		systemd = path.Join(parent, "docker-"+id+".scope")
+		cgroupfs = path.Join(parent, id)
+	}
+	switch container.Driver {
+	case "systemd":
+		paths = []string{systemd}
+	case "cgroupfs":
+		paths = []string{cgroupfs}
+	default:
+		paths = []string{systemd, cgroupfs}
+	}
+	// podman without a reported path
+	paths = append(paths, path.Join("machine.slice", "libpod-"+id+".scope"), path.Join("libpod_parent", "libpod-"+id))
+	return
+}
+
+// cgroupSourceForContainer the first existing cgroup of containerCgroupPaths, or cgroup_memory_docker_dir
+func cgroupSourceForContainer(container containerCgroup) (source usageSource, err error) {
+	for _, relative := range containerCgroupPaths(container) {
+		source = cgroupSourceForPath(relative)
+		if source != nil {
+			return
+		}
+	}
+	memoryStat := strings.Replace(conf.CGROUP_MEMORY_DOCKER_DIR, "[ID]", container.ID, -1)
+	if _, xerr := os.Stat(memoryStat); xerr == nil {
+		// only the memory controller is known
+		source = &cgroupV1Source{memoryDir: filepath.Dir(memoryStat)}
+		return
+	}
+	err = fmt.Errorf("(cgroupSourceForContainer) no cgroup found for container %s", container.ID)
+	return
+}
+
+// cgroupSourceForPath a cgroup relative to the root of the unified hierarchy or of each v1 hierarchy,
+// nil if it does not exist
+func cgroupSourceForPath(relative string) (source usageSource) {
+	exists := func(dir string) bool {
+		_, err := os.Stat(dir)
+		return err == nil
+	}
+	if exists(path.Join(cgroupRoot, "cgroup.controllers")) {
+		dir := path.Join(cgroupRoot, relative)
+		if exists(dir) {
+			source = &cgroupV2Source{dir: dir}
+		}
+		return
+	}
+	v1 := &cgroupV1Source{}
+	if dir := path.Join(cgroupRoot, "memory", relative); exists(dir) {
+		v1.memoryDir = dir
+	}
+	for _, hierarchy := range []string{"cpuacct", "cpu,cpuacct"} {
+		if dir := path.Join(cgroupRoot, hierarchy, relative); exists(dir) {
+			v1.cpuacctDir = dir
+			break
+		}
+	}
+	if dir := path.Join(cgroupRoot, "blkio", relative); exists(dir) {
+		v1.blkioDir = dir
+	}
+	if v1.memoryDir == "" && v1.cpuacctDir == "" {
+		return
+	}
+	source = v1
+	return
+}
+
+// cgroupV2Source unified hierarchy
+type cgroupV2Source struct {
+	dir string
+}
+
+func (s *cgroupV2Source) Name() string { return "cgroup2" }
+
+func (s *cgroupV2Source) Read() (reading usageReading, err error) {
+	cpuStat, err := readKeyValues(path.Join(s.dir, "cpu.stat"))
+	if err != nil {
+		return
+	}
+	reading.CPUSeconds = float64(cpuStat["usage_usec"]) / 1e6
+	reading.Memory, err = readInt(path.Join(s.dir, "memory.current"))
+	if err != nil {
+		return
+	}
+	reading.Swap, _ = readInt(path.Join(s.dir, "memory.swap.current"))
+	reading.MemPeak, _ = readInt(path.Join(s.dir, "memory.peak")) // kernel 5.19+
+
+	content, xerr := ioutil.ReadFile(path.Join(s.dir, "io.stat"))
+	if xerr != nil {
+		return
+	}
+	reading.IORead, reading.IOWrite = parseIOStat(string(content))
+	return
+}
+
+// parseIOStat sums the bytes of io.stat: "<major>:<minor> rbytes=1 wbytes=2 rios=3 ..." per device
+func parseIOStat(content string) (read int64, write int64) {
+	for _, line := range strings.Split(content, "\n") {
+		for _, field := range strings.Fields(line) {
+			pair := strings.SplitN(field, "=", 2)
+			if len(pair) != 2 {
+				continue
+			}
+			value, xerr := strconv.ParseInt(pair[1], 10, 64)
+			if xerr != nil {
+				continue
+			}
+			switch pair[0] {
+			case "rbytes":
+				read += value
+			case "wbytes":
+				write += value
+			}
+		}
+	}
+	return
+}
+
+// cgroupV1Source separate hierarchies per controller, missing ones are skipped
+type cgroupV1Source struct {
+	memoryDir  string
+	cpuacctDir string
+	blkioDir   string
+}
+
+func (s *cgroupV1Source) Name() string { return "cgroup1" }
+
+func (s *cgroupV1Source) Read() (reading usageReading, err error) {
+	if s.memoryDir != "" {
+		var memoryStat map[string]int64
+		memoryStat, err = readKeyValues(path.Join(s.memoryDir, "memory.stat"))
+		if err != nil {
+			return
+		}
+		reading.Memory = memoryStat["total_rss"]
+		reading.Swap = memoryStat["total_swap"]
+		reading.MemPeak, _ = readInt(path.Join(s.memoryDir, "memory.max_usage_in_bytes"))
+	}
+	if s.cpuacctDir != "" {
+		nanoseconds, xerr := readInt(path.Join(s.cpuacctDir, "cpuacct.usage"))
+		if xerr == nil {
+			reading.CPUSeconds = float64(nanoseconds) / 1e9
+		}
+	}
+	if s.blkioDir != "" {
+		content, xerr := ioutil.ReadFile(path.Join(s.blkioDir, "blkio.throttle.io_service_bytes"))
+		if xerr == nil {
+			reading.IORead, reading.IOWrite = parseBlkioServiceBytes(string(content))
+		}
+	}
+	return
+}
+
+// parseBlkioServiceBytes sums blkio.throttle.io_service_bytes: "<major>:<minor> Read 123" per device and operation
+func parseBlkioServiceBytes(content string) (read int64, write int64) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, xerr := strconv.ParseInt(fields[2], 10, 64)
+		if xerr != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			read += value
+		case "Write":
+			write += value
+		}
+	}
+	return
+}
+
+// procTreeSource sums /proc of a process and its descendants, used for runs without a cgroup of their own.
+// CPU time and I/O of processes that exit between two samples are counted with their last reading.
+type procTreeSource struct {
+	pid     int
+	cpu     map[int]float64
+	ioRead  map[int]int64
+	ioWrite map[int]int64
+}
+
+func (s *procTreeSource) Name() string { return "proc" }
+
+func (s *procTreeSource) Read() (reading usageReading, err error) {
+	pids, err := processTree(s.pid)
+	if err != nil {
+		return
+	}
+	alive := 0
+	for _, pid := range pids {
+		stat, xerr := readProcStat(pid)
+		if xerr != nil {
+			continue // exited
+		}
+		alive++
+		s.cpu[pid] = float64(stat.utime+stat.stime) / clockTicks
+		status, xerr := readKeyValues(fmt.Sprintf("/proc/%d/status", pid))
+		if xerr == nil {
+			reading.Memory += status["VmRSS"] * 1024
+			reading.Swap += status["VmSwap"] * 1024
+		}
+		io, xerr := readKeyValues(fmt.Sprintf("/proc/%d/io", pid))
+		if xerr == nil {
+			s.ioRead[pid] = io["read_bytes"]
+			s.ioWrite[pid] = io["write_bytes"]
+		}
+	}
+	if alive == 0 {
+		err = fmt.Errorf("(procTreeSource) process %d has exited", s.pid)
+		return
+	}
+	for _, seconds := range s.cpu {
+		reading.CPUSeconds += seconds
+	}
+	for _, bytes := range s.ioRead {
+		reading.IORead += bytes
+	}
+	for _, bytes := range s.ioWrite {
+		reading.IOWrite += bytes
+	}
+	return
+}
+
+type procStat struct {
+	ppid  int
+	utime int64
+	stime int64
+}
+
+// readProcStat reads /proc/<pid>/stat
+func readProcStat(pid int) (stat procStat, err error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return
+	}
+	stat, err = parseProcStat(string(content))
+	if err != nil {
+		err = fmt.Errorf("(readProcStat) pid %d: %s", pid, err.Error())
+	}
+	return
+}
+
+// parseProcStat parses the content of /proc/<pid>/stat, the command name may contain spaces and parentheses
+func parseProcStat(text string) (stat procStat, err error) {
+	end := strings.LastIndex(text, ")")
+	if end < 0 {
+		err = fmt.Errorf("(parseProcStat) no command name")
+		return
+	}
+	// fields after the command: state ppid pgrp session tty_nr tpgid flags minflt cminflt majflt cmajflt utime stime
+	fields := strings.Fields(text[end+1:])
+	if len(fields) < 13 {
+		err = fmt.Errorf("(parseProcStat) %d fields after the command name", len(fields))
+		return
+	}
+	stat.ppid, _ = strconv.Atoi(fields[1])
+	stat.utime, _ = strconv.ParseInt(fields[11], 10, 64)
+	stat.stime, _ = strconv.ParseInt(fields[12], 10, 64)
+	return
+}
+
+// processTree returns pid and all its descendants
+func processTree(pid int) (pids []int, err error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return
+	}
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		child, xerr := strconv.Atoi(entry.Name())
+		if xerr != nil {
+			continue
+		}
+		stat, xerr := readProcStat(child)
+		if xerr != nil {
+			continue
+		}
+		children[stat.ppid] = append(children[stat.ppid], child)
+	}
+	queue := []int{pid}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		pids = append(pids, current)
+		queue = append(queue, children[current]...)
+	}
+	return
+}
+
+// readInt reads a file containing a single number ("max" is returned as 0)
+func readInt(filename string) (value int64, err error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	text := strings.TrimSpace(string(content))
+	if text == "max" {
+		return
+	}
+	value, err = strconv.ParseInt(text, 10, 64)
+	return
+}
+
+// readKeyValues reads "key value" or "key: value [kB]" lines
+func readKeyValues(filename string) (values map[string]int64, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	values, err = parseKeyValues(file)
+	return
+}
+
+// parseKeyValues parses "key value" or "key: value [kB]" lines, lines without a number are skipped
+func parseKeyValues(r io.Reader) (values map[string]int64, err error) {
+	values = make(map[string]int64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.Replace(scanner.Text(), ":", " ", 1))
+		if len(fields) < 2 {
+			continue
+		}
+		value, xerr := strconv.ParseInt(fields[1], 10, 64)
+		if xerr != nil {
+			continue
+		}
+		values[fields[0]] = value
+	}
+	err = scanner.Err()
+	return
+}
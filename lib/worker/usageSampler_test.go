@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		stat  procStat
+		error bool
+	}{
+		{"plain", "1234 (bash) S 1 1234 1234 0 -1 4194560 1000 0 0 0 250 50 0 0 20 0 1 0", procStat{ppid: 1, utime: 250, stime: 50}, false},
+		{"name with spaces and parentheses", "99 (my (odd) tool) R 42 99 99 0 -1 0 0 0 0 0 7 3 0 0", procStat{ppid: 42, utime: 7, stime: 3}, false},
+		{"no command", "1234 bash S 1", procStat{}, true},
+		{"truncated", "1234 (bash) S 1 1234", procStat{}, true},
+	}
+	for _, test := range tests {
+		stat, err := parseProcStat(test.text)
+		if (err != nil) != test.error {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if stat != test.stat {
+			t.Errorf("%s: got %+v, want %+v", test.name, stat, test.stat)
+		}
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	status := "Name:\tbash\nVmRSS:\t  2048 kB\nVmSwap:\t0 kB\nThreads:\t1\n"
+	values, err := parseKeyValues(strings.NewReader(status))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"VmRSS": 2048, "VmSwap": 0, "Threads": 1}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("status: got %v", values)
+	}
+
+	values, err = parseKeyValues(strings.NewReader("usage_usec 1500000\nuser_usec 1000000\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["usage_usec"] != 1500000 || values["user_usec"] != 1000000 {
+		t.Errorf("cpu.stat: got %v", values)
+	}
+}
+
+func TestParseIOStat(t *testing.T) {
+	content := "8:0 rbytes=1000 wbytes=200 rios=3 wios=1 dbytes=0 dios=0\n8:16 rbytes=24 wbytes=0 rios=1 wios=0\n"
+	read, write := parseIOStat(content)
+	if read != 1024 || write != 200 {
+		t.Errorf("got read=%d write=%d", read, write)
+	}
+}
+
+func TestParseBlkioServiceBytes(t *testing.T) {
+	content := "8:0 Read 4096\n8:0 Write 512\n8:0 Sync 4608\n8:0 Total 4608\n8:16 Read 4096\nTotal 8704\n"
+	read, write := parseBlkioServiceBytes(content)
+	if read != 8192 || write != 512 {
+		t.Errorf("got read=%d write=%d", read, write)
+	}
+}
+
+func TestContainerCgroupPaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		container containerCgroup
+		first     string
+	}{
+		{"reported path", containerCgroup{ID: "abc", Path: "/machine.slice/libpod-abc.scope"}, "machine.slice/libpod-abc.scope"},
+		{"systemd", containerCgroup{ID: "abc", Driver: "systemd"}, "system.slice/docker-abc.scope"},
+		{"cgroupfs", containerCgroup{ID: "abc", Driver: "cgroupfs"}, "docker/abc"},
+		{"cgroupfs parent", containerCgroup{ID: "abc", Driver: "cgroupfs", Parent: "/awe"}, "awe/abc"},
+		{"unknown driver", containerCgroup{ID: "abc"}, "system.slice/docker-abc.scope"},
+	}
+	for _, test := range tests {
+		paths := containerCgroupPaths(test.container)
+		if len(paths) == 0 || paths[0] != test.first {
+			t.Errorf("%s: got %v", test.name, paths)
+		}
+	}
+	paths := containerCgroupPaths(containerCgroup{ID: "abc"})
+	if len(paths) != 4 || paths[1] != "docker/abc" || paths[2] != "machine.slice/libpod-abc.scope" {
+		t.Errorf("unknown driver: got %v", paths)
+	}
+}
+
+func TestCgroupV2Source(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awe-cgroup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"cpu.stat":            "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+		"memory.current":      "1048576\n",
+		"memory.swap.current": "0\n",
+		"memory.peak":         "2097152\n",
+		"io.stat":             "8:0 rbytes=10 wbytes=20 rios=1 wios=1\n",
+	} {
+		if err = ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reading, err := (&cgroupV2Source{dir: dir}).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := usageReading{CPUSeconds: 2.5, Memory: 1048576, MemPeak: 2097152, IORead: 10, IOWrite: 20}
+	if reading != want {
+		t.Errorf("got %+v, want %+v", reading, want)
+	}
+}
@@ -1,29 +1,24 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
-
-	"github.com/MG-RAST/AWE/lib/core/cwl"
 	//"errors"
 	"fmt"
 
+	"github.com/MG-RAST/AWE/lib/client"
 	"github.com/MG-RAST/AWE/lib/conf"
 	"github.com/MG-RAST/AWE/lib/core"
-
-	//"github.com/MG-RAST/AWE/lib/core/cwl"
+	"github.com/MG-RAST/AWE/lib/core/cwl"
 	e "github.com/MG-RAST/AWE/lib/errors"
 	"github.com/MG-RAST/AWE/lib/logger"
 	"github.com/MG-RAST/AWE/lib/logger/event"
-	"github.com/MG-RAST/golib/httpclient"
+	"github.com/mitchellh/mapstructure"
 
 	//"github.com/davecgh/go-spew/spew"
-	"io/ioutil"
 	"os"
 	"strings"
-	"syscall"
 	"time"
-
-	"github.com/mitchellh/mapstructure"
 )
 
 type WorkResponse struct {
@@ -44,6 +39,11 @@ func workStealerRun(control chan int, retry_previous int) (retry int, err error)
 		<-core.ProxyWorkChan
 	}
 
+	// a draining client checks out no more work
+	if isDraining() {
+		exitWhenIdle()
+	}
+
 	// do not check out work if client is in a bad state
 	for core.Self.WorkerState.Healthy == false {
 		time.Sleep(time.Second * 10)
@@ -54,7 +54,7 @@ func workStealerRun(control chan int, retry_previous int) (retry int, err error)
 		_ = core.Self.SetBusy(false, false)
 		if err.Error() == e.QueueEmpty || err.Error() == e.QueueSuspend || err.Error() == e.NoEligibleWorkunitFound {
 			//normal, do nothing
-			logger.Debug(3, "(workStealer) client %s received status %s from server %s", core.Self.ID, err.Error(), conf.SERVER_URL)
+			logger.Debug(3, "(workStealer) client %s received status %s from server %s", core.Self.ID, err.Error(), core.ServerURL())
 		} else if err.Error() == e.ClientBusy {
 			// client asked for work, but server has not finished processing its last delivered work
 			logger.Error("(workStealer) server responds: last work delivered by client not yet processed, retry=%d", retry)
@@ -68,6 +68,11 @@ func workStealerRun(control chan int, retry_previous int) (retry int, err error)
 			logger.Error("(workStealer) client suspended, waiting for repair or resume request...")
 			//TODO: send out email notice that this client has problem and been suspended
 			time.Sleep(2 * time.Minute)
+		} else if err.Error() == e.InsufficientDiskSpace {
+			logger.Warning("(workStealer) less than %d MiB free in %s, no new work", conf.DISK_HEADROOM_MB, conf.WORK_PATH)
+		} else if strings.Contains(err.Error(), e.ClientDraining) {
+			// the heartbeat tells the client to exit, unless it is in maintenance
+			logger.Debug(1, "(workStealer) client draining or in maintenance, no new work")
 		} else if err.Error() == e.ClientDeleted {
 			fmt.Printf("(workStealer) client deleted, exiting...\n")
 			os.Exit(1) // TODO is there a better way of exiting ? E.g. in regard of the logger who wants to flush....
@@ -121,12 +126,11 @@ func workStealerRun(control chan int, retry_previous int) (retry int, err error)
 	//}
 	workunit.WorkPerf = workstat
 
-	// make sure cwl-runner is invoked
-	if workunit.CWLWorkunit != nil {
+	// make sure cwl-runner is invoked, unless the worker executes CWL tools natively
+	if workunit.CWLWorkunit != nil && conf.CWL_RUNNER == "cwltool" {
 		workunit.Cmd.Name = "cwl-runner"
 		// "--provenance", "cwl_tool_provenance", "--disable-pull"
 		workunit.Cmd.ArgsArray = []string{"--leave-outputs", "--leave-tmpdir", "--tmp-outdir-prefix", "./tmp/", "--tmpdir-prefix", "./tmp/", "--rm-container", "--on-error", "stop", "./cwl_tool.yaml", "./cwl_job_input.yaml"}
-
 	}
 
 	//FromStealer <- rawWork // sends to dataMover
@@ -161,224 +165,39 @@ func workStealer(control chan int) {
 // CheckoutWorkunitRemote _
 func CheckoutWorkunitRemote() (workunit *core.Workunit, err error) {
 	logger.Debug(3, "(CheckoutWorkunitRemote) start")
-	// get available work dir disk space
-	var stat syscall.Statfs_t
-	syscall.Statfs(conf.WORK_PATH, &stat)
-	availableBytes := stat.Bavail * uint64(stat.Bsize)
-
-	if core.Self == nil {
-		err = fmt.Errorf("(CheckoutWorkunitRemote) core.Self == nil")
-		return
-	}
-	targeturl := fmt.Sprintf("%s/work?client=%s&available=%d&server_uuid=%s", conf.SERVER_URL, core.Self.ID, availableBytes, core.ServerUUID)
-
-	var headers httpclient.Header
-	if conf.CLIENT_GROUP_TOKEN != "" {
-		headers = httpclient.Header{
-			"Authorization": []string{"CG_TOKEN " + conf.CLIENT_GROUP_TOKEN},
-		}
-	}
-	logger.Debug(3, fmt.Sprintf("(CheckoutWorkunitRemote) client %s sends a checkout request to %s with available %d (targeturl = %s)", core.Self.ID, conf.SERVER_URL, availableBytes, targeturl))
-	res, err := httpclient.DoTimeout("GET", targeturl, headers, nil, nil, time.Second*0)
-	logger.Debug(3, fmt.Sprintf("(CheckoutWorkunitRemote) client %s sent a checkout request to %s with available %d", core.Self.ID, conf.SERVER_URL, availableBytes))
-	if err != nil {
-		err = fmt.Errorf("(CheckoutWorkunitRemote) error sending checkout request: %s", err.Error())
-		return
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode == 404 {
-		err = fmt.Errorf("(404) AWE server not found")
-		return
-	}
-
-	jsonstream, err := ioutil.ReadAll(res.Body)
+	// get available work dir disk space, keeping the headroom free
+	availableBytes, err := checkoutSpace()
 	if err != nil {
+		err = fmt.Errorf("(CheckoutWorkunitRemote) checkoutSpace returned: %s", err.Error())
 		return
 	}
-
-	if len(jsonstream) == 0 {
-		err = fmt.Errorf("response is empty")
-		return
-	}
-
-	var response core.StandardResponse
-	response.Status = -1
-
-	err = json.Unmarshal(jsonstream, &response)
-	if err != nil { // response
-		jsonstreamStr := string(jsonstream)
-		fmt.Printf("statuscode: %d\njsonstream:\n%s (len: %d) (%s)\n", res.StatusCode, jsonstreamStr, len(jsonstreamStr), err.Error())
-		err = fmt.Errorf("(CheckoutWorkunitRemote) json.Unmarshal error: %s", err.Error())
-		return
-	}
-
-	//spew.Dump(response)
-
-	if response.Status == -1 {
-		err = fmt.Errorf(e.ServerNotFound)
-		return
-	}
-
-	if len(response.Error) > 0 {
-		message := strings.Join(response.Error, ",")
-		err = fmt.Errorf("%s", message)
+	if availableBytes <= 0 {
+		err = fmt.Errorf(e.InsufficientDiskSpace)
 		return
 	}
 
-	data_generic := response.Data
-	if data_generic == nil {
-		err = fmt.Errorf("(CheckoutWorkunitRemote) Data field missing (jsonstream:\n%s\n)", jsonstream)
-		return
-	}
-
-	// remove CWL
-	data_map, ok := data_generic.(map[string]interface{})
-	if !ok {
-		err = fmt.Errorf("(CheckoutWorkunitRemote) Could not make data field map[string]interface{}")
+	if core.Self == nil {
+		err = fmt.Errorf("(CheckoutWorkunitRemote) core.Self == nil")
 		return
 	}
-	var cwl_object *core.CWLWorkunit
-
-	cwl_generic, has_cwl := data_map["cwl"]
-	if has_cwl {
-		if cwl_generic == nil {
-			has_cwl = false
-		}
-		delete(data_map, "cwl")
-
-	}
-
-	info := &core.Info{}
-	info_if, has_info := data_map["info"]
-	if has_info { // interface -> json -> struct  // this is a bit ugly
-
-		info_byte, xerr := json.Marshal(info_if)
-		if xerr != nil {
-			err = xerr
-			return
-		}
-
-		yerr := json.Unmarshal(info_byte, info)
-		if yerr != nil {
-			err = yerr
-			return
-		}
-		delete(data_map, "info")
-
-	}
-
-	command := &core.Command{}
-	command_if, has_command := data_map["command"]
-	if has_command { // interface -> json -> struct  // this is a bit ugly
-
-		command_byte, xerr := json.Marshal(command_if)
-		if xerr != nil {
-			err = xerr
-			return
-		}
-
-		yerr := json.Unmarshal(command_byte, command)
-		if yerr != nil {
-			err = yerr
-			return
-		}
-		delete(data_map, "command")
-
-	}
-
-	partinfo := &core.PartInfo{}
-	partinfo_if, has_partinfo := data_map["partinfo"]
-	if has_partinfo { // interface -> json -> struct  // this is a bit ugly
-
-		partinfo_byte, xerr := json.Marshal(partinfo_if)
-		if xerr != nil {
-			err = xerr
-			return
-		}
-
-		yerr := json.Unmarshal(partinfo_byte, partinfo)
-		if yerr != nil {
-			err = yerr
+	logger.Debug(3, "(CheckoutWorkunitRemote) client %s sends a checkout request to %s with available %d", core.Self.ID, core.ServerURL(), availableBytes)
+	data, err := serverClient().CheckoutWorkunit(context.Background(), core.Self.ID, availableBytes, core.ServerUUID)
+	if err != nil {
+		if _, ok := err.(*client.Error); ok {
+			// the messages of the server, e.g. e.QueueEmpty
 			return
 		}
-		delete(data_map, "partinfo")
-
-	}
-
-	_, has_checkout_time := data_map["checkout_time"]
-	if has_checkout_time {
-		delete(data_map, "checkout_time") // TODO add checkout_time as time.Time
-
+		err = fmt.Errorf("(CheckoutWorkunitRemote) error sending checkout request: %s", err.Error())
+		return
 	}
-
-	//delete(data_map, "info")
-
-	workunit = &core.Workunit{}
-	workunit.Info = info
-	//workunit.Workunit_Unique_Identifier = core.Workunit_Unique_Identifier{}
-	//if has_checkout_time {
-	//	workunit_checkout_time_str, ok := workunit_checkout_time_if.(string)
-	//	if !ok {
-	//		err = fmt.Errorf("(CheckoutWorkunitRemote) cannot type assert checkout_time")
-	//		return
-	//	}
-	//	workunit.CheckoutTime = workunit_checkout_time
-	//}
-
-	err = mapstructure.Decode(data_map, workunit)
+	workunit, err = decodeWorkunit(data, core.Self.ID)
 	if err != nil {
-		err = fmt.Errorf("(CheckoutWorkunitRemote) mapstructure.Decode error: %s", err.Error())
+		err = fmt.Errorf("(CheckoutWorkunitRemote) decodeWorkunit returned: %s", err.Error())
 		return
 	}
-
-	logger.Debug(1, "(CheckoutWorkunitRemote) workunit.ID: %s", workunit.ID)
-
-	if has_cwl {
-
-		var xerr error
-		//var schemata []cwl.CWLType_Type
-		workunit.Context = cwl.NewWorkflowContext()
-		workunit.Context.Init("")
-		cwl_object, _, xerr = core.NewCWLWorkunitFromInterface(cwl_generic, "", workunit.Context)
-		if xerr != nil {
-			err = fmt.Errorf("(CheckoutWorkunitRemote) NewCWLWorkunit_from_interface failed: %s", xerr.Error())
-			logger.Debug(1, err.Error())
-			workunit.State = core.WORK_STAT_ERROR
-			workunit.Notes = append(workunit.Notes, err.Error())
-			err = nil // Pass error-workunit along to maintain error message
-			return
-		}
-
-		//fmt.Println("CWL Workunit from server from server:")
-		//cwl_object_bytes, _ := json.Marshal(cwl_object)
-		//fmt.Printf("%s\n", string(cwl_object_bytes))
-
-		workunit.CWLWorkunit = cwl_object
-		workunit.CWLWorkunit.Notice = core.Notice{ID: workunit.Workunit_Unique_Identifier, WorkerID: core.Self.ID}
-
-		if workunit.CWLWorkunit.Tool == nil {
-			err = fmt.Errorf("(CheckoutWorkunitRemote) Tool == nil")
-			return
-		}
-	}
-
-	//test, err := json.Marshal(workunit)
-	//if err != nil {
-	//	panic("did not work")
-	//}
-	//fmt.Println("workunit: ")
-	//fmt.Printf("workunit:\n %s\n", test)
-
-	//panic("done...")
-
-	if response.Status == 0 { // this is ugly
-		err = fmt.Errorf(e.ServerNotFound)
-		return
-	}
-
-	if response.Status != 200 {
-		err = fmt.Errorf("(CheckoutWorkunitRemote) response_generic.Status != 200 : %d", response.Status)
+	if workunit.State == core.WORK_STAT_ERROR {
+		// the CWL workunit could not be parsed, pass it along to report the error
+		logger.Debug(1, "(CheckoutWorkunitRemote) %s", strings.Join(workunit.Notes, ", "))
 		return
 	}
 
@@ -388,13 +207,11 @@ func CheckoutWorkunitRemote() (workunit *core.Workunit, err error) {
 	}
 	logger.Debug(3, "(CheckoutWorkunitRemote) TaskName: %s", workunit.TaskName)
 
-	//workunit = response.Data
-
 	logger.Debug(3, "(CheckoutWorkunitRemote) workunit.Info.Auth == %t", workunit.Info.Auth)
 	if workunit.Info.Auth == true {
 
 		var token string
-		token, err = workunit.FetchDataToken()
+		token, err = CheckoutToken(workunit)
 		if err != nil {
 			err = fmt.Errorf("(CheckoutWorkunitRemote) need data token but failed to fetch it: %s", err.Error())
 			return
@@ -418,7 +235,72 @@ func CheckoutWorkunitRemote() (workunit *core.Workunit, err error) {
 	return
 }
 
-// CheckoutTokenByJobId _
-func CheckoutTokenByJobId(jobid string) (token string, err error) {
+// decodeWorkunit decodes a checked out workunit. The CWL part and the info, which mapstructure can not
+// decode, are decoded separately. A CWL workunit that can not be parsed is returned in state error with
+// the reason in its notes, so that the worker can report it.
+func decodeWorkunit(data map[string]interface{}, clientID string) (workunit *core.Workunit, err error) {
+	cwlGeneric, hasCWL := data["cwl"]
+	if cwlGeneric == nil {
+		hasCWL = false
+	}
+	delete(data, "cwl")
+
+	info := &core.Info{}
+	if infoIf, ok := data["info"]; ok {
+		// interface -> json -> struct, mapstructure does not decode the times in info
+		var infoBytes []byte
+		infoBytes, err = json.Marshal(infoIf)
+		if err != nil {
+			return
+		}
+		err = json.Unmarshal(infoBytes, info)
+		if err != nil {
+			err = fmt.Errorf("(decodeWorkunit) json.Unmarshal info returned: %s", err.Error())
+			return
+		}
+		delete(data, "info")
+	}
+	delete(data, "checkout_time") // TODO add checkout_time as time.Time
+	delete(data, "queued_time")   // only used by the server
+
+	workunit = &core.Workunit{}
+	workunit.Info = info
+	err = mapstructure.Decode(data, workunit)
+	if err != nil {
+		err = fmt.Errorf("(decodeWorkunit) mapstructure.Decode error: %s", err.Error())
+		return
+	}
+
+	if !hasCWL {
+		return
+	}
+	workunit.Context = cwl.NewWorkflowContext()
+	workunit.Context.Init("")
+	cwlObject, _, xerr := core.NewCWLWorkunitFromInterface(cwlGeneric, "", workunit.Context)
+	if xerr != nil {
+		xerr = fmt.Errorf("(decodeWorkunit) NewCWLWorkunitFromInterface failed: %s", xerr.Error())
+		workunit.State = core.WORK_STAT_ERROR
+		workunit.Notes = append(workunit.Notes, xerr.Error())
+		return
+	}
+	workunit.CWLWorkunit = cwlObject
+	workunit.CWLWorkunit.Notice = core.Notice{ID: workunit.Workunit_Unique_Identifier, WorkerID: clientID}
+	if workunit.CWLWorkunit.Tool == nil {
+		err = fmt.Errorf("(decodeWorkunit) Tool == nil")
+	}
+	return
+}
+
+// CheckoutToken the data token of the job of a workunit
+func CheckoutToken(workunit *core.Workunit) (token string, err error) {
+	workStr, err := workunit.String()
+	if err != nil {
+		err = fmt.Errorf("(CheckoutToken) workunit.String returned: %s", err.Error())
+		return
+	}
+	token, err = serverClient().WorkunitDataToken(context.Background(), workStr, core.Self.ID)
+	if err != nil {
+		err = fmt.Errorf("(CheckoutToken) client.WorkunitDataToken returned: %s", err.Error())
+	}
 	return
 }
@@ -0,0 +1,24 @@
+package worker
+
+import (
+	"testing"
+)
+
+func TestDecodeWorkunit(t *testing.T) {
+	data := map[string]interface{}{
+		"task_name":     "step1",
+		"jobid":         "j1",
+		"rank":          0,
+		"id":            "j1_step1_0",
+		"cmd":           map[string]interface{}{"name": "echo"},
+		"info":          map[string]interface{}{"name": "test", "submittime": "2026-10-19T10:00:00Z", "auth": true},
+		"checkout_time": "2026-10-19T10:00:00Z",
+	}
+	workunit, err := decodeWorkunit(data, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workunit.TaskName != "step1" || workunit.JobId != "j1" || workunit.Cmd == nil || workunit.Cmd.Name != "echo" || !workunit.Info.Auth || workunit.Info.SubmitTime.Year() != 2026 {
+		t.Errorf("workunit not decoded: %+v", workunit)
+	}
+}
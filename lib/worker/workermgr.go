@@ -16,6 +16,7 @@ var (
 	fromProcessor chan *core.Workunit // processor -> deliverer
 	chanPermit    chan bool
 	chankill      chan bool //heartbeater -> worker
+	draining      int32     //set by DrainClient, checked by workStealer
 	workmap       *WorkMap
 	//workmap       map[string]int //workunit map [work_id]stage_id}
 	Client_mode string
@@ -56,8 +57,11 @@ func StartClientWorkers() {
 
 	mode := Client_mode
 	if mode == "online" {
+		removeOrphanedWorkDirs()
+		go diskMonitor()
 		go heartBeater(control)
 		go workStealer(control)
+		go drainOnSignal()
 	}
 	go dataDownloader(control)
 	go processor(control)